@@ -0,0 +1,122 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"errors"
+	"fmt"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/metrics"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// DefaultMaxInFlight is the default maximum number of requests from
+	// a client that are held until the agent handles them.
+	DefaultMaxInFlight = 8
+	// DefaultMaxConcurrentSignatures is the default maximum number of
+	// signature requests that are handled at once, across all clients.
+	DefaultMaxConcurrentSignatures = 4
+	// MaxBacklog is the maximum number of messages from a client that
+	// are held while delivery to the agent is paused.
+	MaxBacklog = 4096
+	// MaxBacklogBytes is the maximum total size of the requests in the
+	// messages from a client that are held while delivery to the agent is
+	// paused.
+	MaxBacklogBytes = 4 * 1024 * 1024
+)
+
+// errBacklog indicates that a client sent too many messages without waiting
+// for responses.
+var errBacklog = errors.New("too many pending messages")
+
+// signRequest is the SSH_AGENTC_SIGN_REQUEST message type.
+const agentcSignRequest = 13
+
+// enqueue queues a message received from the client. Chrome provides no means
+// to stop receiving messages on a port, so once maxInFlight requests are held
+// for the agent, delivery is paused instead: further messages are held in the
+// backlog as they were received, and converted to requests only as the agent
+// reads those before them. Requests thus remain in the order in which they
+// were received, and none are refused. A client whose backlog exceeds
+// MaxBacklog messages or MaxBacklogBytes is in error. ap.mu must be held.
+func (ap *AgentPort) enqueue(msg js.Value) error {
+	ap.outstanding++
+	if len(ap.requests) >= ap.maxInFlight || len(ap.backlog) > 0 {
+		size := messageSize(msg)
+		if size > MaxMessageSize {
+			return fmt.Errorf("message to agent too large: %d bytes; maximum is %d", size, MaxMessageSize)
+		}
+		if len(ap.backlog) >= MaxBacklog {
+			return fmt.Errorf("%w: more than %d messages pending", errBacklog, MaxBacklog)
+		}
+		if ap.backlogBytes+size > MaxBacklogBytes {
+			return fmt.Errorf("%w: more than %d bytes pending", errBacklog, MaxBacklogBytes)
+		}
+		ap.backlog = append(ap.backlog, msg)
+		ap.backlogBytes += size
+		return nil
+	}
+	req, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	ap.requests = append(ap.requests, req)
+	return nil
+}
+
+// dequeue removes the next request from the queue, which must not be empty,
+// and resumes delivery of messages held in the backlog. ap.mu must be held.
+func (ap *AgentPort) dequeue() ([]byte, error) {
+	req := ap.requests[0]
+	ap.requests = ap.requests[1:]
+	for len(ap.backlog) > 0 && len(ap.requests) < ap.maxInFlight {
+		next, err := parseMessage(ap.backlog[0])
+		if err != nil {
+			return nil, err
+		}
+		ap.backlogBytes -= len(next)
+		ap.backlog = ap.backlog[1:]
+		ap.requests = append(ap.requests, next)
+	}
+	return req, nil
+}
+
+// messageSize returns the size of the request in a message from the client,
+// without parsing it.
+func messageSize(msg js.Value) int {
+	data := msg.Get("data")
+	if data.Type() != js.TypeObject {
+		return 0
+	}
+	return data.Length()
+}
+
+// handleLimited is like handle, but if the request is for a signature, it
+// first waits until fewer than cap(signatures) are being handled. Signatures
+// are not limited if signatures is nil. The request and response are counted
+// by m, if it is not nil.
+func handleLimited(a agent.Agent, req []byte, signatures chan struct{}, m *metrics.Metrics) []byte {
+	if signatures != nil && len(req) > 0 && req[0] == agentcSignRequest {
+		signatures <- struct{}{}
+		defer func() { <-signatures }()
+	}
+	rsp := handle(a, req)
+	m.Observe(req, rsp)
+	return rsp
+}
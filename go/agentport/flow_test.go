@@ -0,0 +1,255 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// newKeyring returns a keyring holding a new key, and the key's public key.
+func newKeyring(t *testing.T) (agent.Agent, ssh.PublicKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	return keyring, signer.PublicKey()
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	t.Parallel()
+
+	const (
+		requests         = 1000
+		identitiesAnswer = 12 // SSH_AGENT_IDENTITIES_ANSWER
+		signResponse     = 14 // SSH_AGENT_SIGN_RESPONSE
+	)
+	list := []byte{11} // SSH_AGENTC_REQUEST_IDENTITIES
+
+	keyring, pub := newKeyring(t)
+	release := make(chan struct{})
+	fp := newFakePort()
+	ap := New(fp.v)
+	go ap.Serve(&blockingAgent{Agent: keyring, release: release})
+	defer ap.OnDisconnect()
+
+	// The first request blocks, while the client sends the rest.
+	send(ap, signRequest(pub))
+	for i := 1; i < requests; i++ {
+		send(ap, list)
+	}
+	ap.mu.Lock()
+	queued, backlog := len(ap.requests), len(ap.backlog)
+	ap.mu.Unlock()
+	if queued > DefaultMaxInFlight {
+		t.Errorf("too many requests in flight; got %d, want at most %d", queued, DefaultMaxInFlight)
+	}
+	// The agent may have yet to read the first request.
+	if held := queued + backlog; held < requests-1 || held > requests {
+		t.Errorf("incorrect number of requests held; got %d, want %d or %d", held, requests-1, requests)
+	}
+
+	// Every request is answered in order once the first is released.
+	close(release)
+	if rsp := fp.receive(t); rsp[0] != signResponse {
+		t.Errorf("incorrect first response; got type %d, want %d", rsp[0], signResponse)
+	}
+	for i := 1; i < requests; i++ {
+		if rsp := fp.receive(t); rsp[0] != identitiesAnswer {
+			t.Fatalf("incorrect response %d; got type %d, want %d", i, rsp[0], identitiesAnswer)
+		}
+	}
+
+	// The backlog is drained.
+	ap.mu.Lock()
+	queued, backlog = len(ap.requests), len(ap.backlog)
+	ap.mu.Unlock()
+	if queued != 0 || backlog != 0 {
+		t.Errorf("requests remain held after draining; got %d queued and %d in backlog", queued, backlog)
+	}
+}
+
+func TestBurstBeyondMaxInFlight(t *testing.T) {
+	t.Parallel()
+
+	const signResponse = 14 // SSH_AGENT_SIGN_RESPONSE
+
+	keyring, pub := newKeyring(t)
+	fp := newFakePort()
+	ap := New(fp.v)
+	defer ap.OnDisconnect()
+
+	// The burst is sent before the agent starts reading.
+	for i := 0; i < DefaultMaxInFlight+1; i++ {
+		send(ap, signRequest(pub))
+	}
+	go ap.Serve(keyring)
+	for i := 0; i < DefaultMaxInFlight+1; i++ {
+		if rsp := fp.receive(t); rsp[0] != signResponse {
+			t.Errorf("incorrect response %d; got type %d, want %d", i, rsp[0], signResponse)
+		}
+	}
+}
+
+func TestBacklogLimit(t *testing.T) {
+	t.Parallel()
+
+	list := []byte{11} // SSH_AGENTC_REQUEST_IDENTITIES
+
+	fp := newFakePort()
+	ap := New(fp.v)
+	defer ap.OnDisconnect()
+
+	// The agent reads nothing, so all but maxInFlight messages are held in
+	// the backlog.
+	for i := 0; i < DefaultMaxInFlight+MaxBacklog; i++ {
+		send(ap, list)
+	}
+	ap.mu.Lock()
+	closed := ap.closed
+	ap.mu.Unlock()
+	if closed {
+		t.Fatalf("client disconnected before exceeding backlog")
+	}
+
+	// The client is disconnected once the backlog is exceeded.
+	send(ap, list)
+	ap.mu.Lock()
+	closed = ap.closed
+	ap.mu.Unlock()
+	if !closed {
+		t.Errorf("client not disconnected after exceeding backlog")
+	}
+}
+
+func TestBacklogBytesLimit(t *testing.T) {
+	t.Parallel()
+
+	// A request of the maximum size, which the agent never gets to parse.
+	big := make([]byte, MaxMessageSize)
+	big[4] = 11 // SSH_AGENTC_REQUEST_IDENTITIES
+
+	fp := newFakePort()
+	ap := New(fp.v)
+	defer ap.OnDisconnect()
+
+	// The agent reads nothing, so all but maxInFlight messages are held in
+	// the backlog.
+	for i := 0; i < DefaultMaxInFlight+MaxBacklogBytes/MaxMessageSize; i++ {
+		send(ap, big)
+	}
+	ap.mu.Lock()
+	closed := ap.closed
+	ap.mu.Unlock()
+	if closed {
+		t.Fatalf("client disconnected before exceeding backlog")
+	}
+
+	// The client is disconnected once the backlog is exceeded, well short
+	// of MaxBacklog messages.
+	send(ap, big)
+	ap.mu.Lock()
+	closed = ap.closed
+	ap.mu.Unlock()
+	if !closed {
+		t.Errorf("client not disconnected after exceeding backlog")
+	}
+}
+
+// countingAgent is an agent that records the maximum number of signatures
+// requested at once.
+type countingAgent struct {
+	agent.Agent
+
+	mu       sync.Mutex
+	current  int
+	maxCount int
+}
+
+func (a *countingAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	a.mu.Lock()
+	a.current++
+	if a.current > a.maxCount {
+		a.maxCount = a.current
+	}
+	a.mu.Unlock()
+
+	// Give other connections the opportunity to sign.
+	time.Sleep(10 * time.Millisecond)
+
+	a.mu.Lock()
+	a.current--
+	a.mu.Unlock()
+	return a.Agent.Sign(key, data)
+}
+
+func TestConcurrentSignatures(t *testing.T) {
+	t.Parallel()
+
+	const signResponse = 14 // SSH_AGENT_SIGN_RESPONSE
+
+	keyring, pub := newKeyring(t)
+	for _, tc := range []struct {
+		description string
+		max         int
+	}{
+		{description: "limit one", max: 1},
+		{description: "limit two", max: 2},
+	} {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			ports := NewAgentPorts()
+			ports.signatures = make(chan struct{}, tc.max)
+			counting := &countingAgent{Agent: keyring}
+			var fps []*fakePort
+			for i := 0; i < 4; i++ {
+				fp := newFakePort()
+				ap := New(fp.v)
+				ports.Add(fp.v, ap)
+				go ap.Serve(counting)
+				defer ap.OnDisconnect()
+				send(ap, signRequest(pub))
+				fps = append(fps, fp)
+			}
+			for _, fp := range fps {
+				if rsp := fp.receive(t); rsp[0] != signResponse {
+					t.Errorf("incorrect response; got type %d, want %d", rsp[0], signResponse)
+				}
+			}
+			if counting.maxCount != tc.max {
+				t.Errorf("incorrect number of concurrent signatures; got %d, want %d", counting.maxCount, tc.max)
+			}
+		})
+	}
+}
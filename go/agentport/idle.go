@@ -0,0 +1,106 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"fmt"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	extmsg "github.com/google/chrome-ssh-agent/go/message"
+	"github.com/norunners/vert"
+)
+
+// DefaultIdleTimeout is the default period of inactivity after which a
+// connection is disconnected. Clients that crash or are suspended may never
+// disconnect themselves.
+const DefaultIdleTimeout = 30 * time.Minute
+
+// idle returns true if the client has no outstanding requests, and has not
+// sent a request since the timeout.
+func (ap *AgentPort) idle(now time.Time, timeout time.Duration) bool {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	return ap.outstanding == 0 && now.Sub(ap.lastActive) >= timeout
+}
+
+// DisconnectIdle disconnects the connections that have been inactive for
+// IdleTimeout, such that their resources are freed, and returns the number
+// disconnected. It should be invoked periodically.
+func (a *AgentPorts) DisconnectIdle() int {
+	if a.IdleTimeout <= 0 {
+		return 0
+	}
+	now := a.now()
+	var n int
+	for p, ap := range a.ports {
+		if !ap.idle(now, a.IdleTimeout) {
+			continue
+		}
+		jsutil.LogDebug("AgentPorts.DisconnectIdle: disconnecting %s", Describe(p.p))
+		// Chrome does not notify us of ports that we disconnect.
+		ap.disconnect()
+		delete(a.ports, p)
+		n++
+	}
+	return n
+}
+
+// Define a distinct type for each message. These are embedded in each
+// message, and are distinct from those used by keys.Server.
+const (
+	msgTypeConnections int = 4000 + iota
+	msgTypeConnectionsRsp
+)
+
+type msgConnections struct {
+	Type int `js:"type"`
+}
+
+type rspConnections struct {
+	Type  int `js:"type"`
+	Count int `js:"count"`
+}
+
+// OnMessage is the callback invoked when a message is received. Requests sent
+// using Connections are answered. Other messages are ignored, in which case
+// undefined is returned such that they may be handled elsewhere.
+func (a *AgentPorts) OnMessage(_ jsutil.AsyncContext, headerObj js.Value, _ js.Value) js.Value {
+	var m msgConnections
+	if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil || m.Type != msgTypeConnections {
+		return js.Undefined()
+	}
+	jsutil.LogDebug("AgentPorts.OnMessage(Connections req)")
+	return vert.ValueOf(rspConnections{Type: msgTypeConnectionsRsp, Count: a.Len()}).JSValue()
+}
+
+// Connections returns the number of clients connected to the agent, such that
+// it can be displayed by the options page.
+func Connections(ctx jsutil.AsyncContext, msg extmsg.Sender) (int, error) {
+	m := msgConnections{Type: msgTypeConnections}
+	jsutil.LogDebug("Connections(req)")
+	rspObj, err := msg.Send(ctx, vert.ValueOf(m).JSValue())
+	if err != nil {
+		return 0, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspConnections
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Count, nil
+}
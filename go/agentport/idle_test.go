@@ -0,0 +1,142 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	mfakes "github.com/google/chrome-ssh-agent/go/message/fakes"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestDisconnectIdle(t *testing.T) {
+	t.Parallel()
+
+	start := time.UnixMilli(1700000000000)
+	now := start
+	clock := func() time.Time { return now }
+	ports := NewAgentPorts()
+	ports.now = clock
+
+	// add connects a client, served by the agent.
+	add := func(a agent.Agent) (*fakePort, *AgentPort, chan error) {
+		fp := newFakePort()
+		ap := New(fp.v)
+		ap.now = clock
+		ap.lastActive = now
+		ports.Add(fp.v, ap)
+		done := make(chan error, 1)
+		go func() { done <- ap.Serve(a) }()
+		return fp, ap, done
+	}
+	list := []byte{11} // SSH_AGENTC_REQUEST_IDENTITIES
+
+	release := make(chan struct{})
+	_, _, idleDone := add(agent.NewKeyring())
+	busyPort, busy, busyDone := add(&blockingAgent{Agent: agent.NewKeyring(), release: release})
+	activePort, active, activeDone := add(agent.NewKeyring())
+	defer active.OnDisconnect()
+
+	// A request that has yet to be answered keeps the connection active,
+	// however long it takes.
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to convert key: %v", err)
+	}
+	send(busy, signRequest(sshPub))
+	now = start.Add(20 * time.Minute)
+	send(active, list)
+	activePort.receive(t)
+
+	now = start.Add(35 * time.Minute)
+	if n := ports.DisconnectIdle(); n != 1 {
+		t.Errorf("incorrect number of connections disconnected; got %d, want 1", n)
+	}
+	if err := <-idleDone; !errors.Is(err, io.EOF) {
+		t.Errorf("Serve returned incorrect error on disconnect: %v", err)
+	}
+	if n := ports.Len(); n != 2 {
+		t.Errorf("incorrect number of connections; got %d, want 2", n)
+	}
+
+	close(release)
+	busyPort.receive(t)
+	now = start.Add(50 * time.Minute)
+	send(active, list)
+	activePort.receive(t)
+
+	now = start.Add(65 * time.Minute)
+	if n := ports.DisconnectIdle(); n != 1 {
+		t.Errorf("incorrect number of connections disconnected; got %d, want 1", n)
+	}
+	if err := <-busyDone; !errors.Is(err, io.EOF) {
+		t.Errorf("Serve returned incorrect error on disconnect: %v", err)
+	}
+
+	// The remaining connection is still served.
+	send(active, list)
+	activePort.receive(t)
+	if n := ports.Len(); n != 1 {
+		t.Errorf("incorrect number of connections; got %d, want 1", n)
+	}
+
+	// Connections are not disconnected if there is no timeout.
+	ports.IdleTimeout = 0
+	now = start.Add(24 * time.Hour)
+	if n := ports.DisconnectIdle(); n != 0 {
+		t.Errorf("incorrect number of connections disconnected without timeout; got %d, want 0", n)
+	}
+	select {
+	case err := <-activeDone:
+		t.Errorf("Serve finished without timeout: %v", err)
+	default:
+	}
+}
+
+func TestConnections(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		ports := NewAgentPorts()
+		hub.AddReceiver(ports)
+
+		for want := 0; want < 3; want++ {
+			n, err := Connections(ctx, hub)
+			if err != nil {
+				t.Fatalf("Connections failed: %v", err)
+			}
+			if n != want {
+				t.Errorf("incorrect number of connections; got %d, want %d", n, want)
+			}
+			fp := newFakePort()
+			ports.Add(fp.v, New(fp.v))
+		}
+	})
+}
@@ -27,18 +27,61 @@
 package agentport
 
 import (
-	"encoding/binary"
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"sync"
 	"syscall/js"
+	"time"
 
 	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/metrics"
 	"github.com/norunners/vert"
+	"golang.org/x/crypto/ssh/agent"
 )
 
+// AgentPort is a connection with a single client. Each AgentPort has its own
+// queue of requests and its own loop sending responses, such that a client
+// whose requests are slow to handle does not delay other clients.
 type AgentPort struct {
-	p         js.Value
-	inReader  *io.PipeReader // client -> agent pipe: agent read from incoming messages
-	inWriter  *io.PipeWriter // client -> agent pipe: write to agent
+	p js.Value
+
+	// now returns the current time.
+	now func() time.Time
+
+	// mu guards the fields below. cond is signalled when requests or
+	// closed change.
+	mu   sync.Mutex
+	cond *sync.Cond
+	// requests are those received from the client that the agent has yet
+	// to read, of which there are at most maxInFlight. Messages are
+	// queued rather than written to a pipe, such that receiving them
+	// never blocks. See enqueue.
+	requests    [][]byte
+	maxInFlight int
+	// backlog holds the messages received while delivery of requests to
+	// the agent is paused, and backlogBytes is the total size of their
+	// requests.
+	backlog      []js.Value
+	backlogBytes int
+	// signatures limits the number of signature requests handled at
+	// once, across all clients. It is nil if there is no limit.
+	signatures chan struct{}
+	// metrics counts the requests served. It is nil if they are not
+	// counted.
+	metrics *metrics.Metrics
+	// closed indicates that the client disconnected.
+	closed bool
+	// outstanding is the number of requests that have yet to be answered.
+	outstanding int
+	// lastActive is the time at which a request was last received or
+	// answered.
+	lastActive time.Time
+	// unread is the remainder of the framed request that the agent is
+	// reading. It is only accessed by Read.
+	unread []byte
+
 	outReader *io.PipeReader // agent -> client pipe: read from agent
 	outWriter *io.PipeWriter // agent -> client pipe: agent write to outgoing messages
 }
@@ -50,15 +93,16 @@ type AgentPort struct {
 // has connected.
 func New(p js.Value) *AgentPort {
 	jsutil.LogDebug("AgentPort.New")
-	ir, iw := io.Pipe()
 	or, ow := io.Pipe()
 	ap := &AgentPort{
-		p:         p,
-		inReader:  ir,
-		inWriter:  iw,
-		outReader: or,
-		outWriter: ow,
+		p:           p,
+		now:         time.Now,
+		lastActive:  time.Now(),
+		maxInFlight: DefaultMaxInFlight,
+		outReader:   or,
+		outWriter:   ow,
 	}
+	ap.cond = sync.NewCond(&ap.mu)
 
 	jsutil.LogDebug("AgentPort.New: Initiating SendMessages loop")
 	go ap.SendMessages()
@@ -66,46 +110,153 @@ func New(p js.Value) *AgentPort {
 	return ap
 }
 
+// SenderID returns the ID of the extension that connected port p, or an empty
+// string if it was not connected by an extension; for example, by a web page.
+func SenderID(p js.Value) string {
+	if sender := p.Get("sender"); sender.Truthy() && sender.Get("id").Truthy() {
+		return sender.Get("id").String()
+	}
+	return ""
+}
+
+// PortName returns the name the client gave to port p, or an empty string if
+// it has none.
+func PortName(p js.Value) string {
+	if name := p.Get("name"); name.Truthy() {
+		return name.String()
+	}
+	return ""
+}
+
+// Describe returns a description of the client connected to port p: the ID of
+// the extension that connected, and the name of the port if it has one.
+func Describe(p js.Value) string {
+	desc := "unknown client"
+	if id := SenderID(p); id != "" {
+		desc = id
+	}
+	if name := PortName(p); name != "" {
+		desc = fmt.Sprintf("%s (%s)", desc, name)
+	}
+	return desc
+}
+
+// Serve serves the agent to the client until it disconnects, using the
+// package-level Serve with the signature limit and metrics of the port. A
+// client that sends a message that is too large is disconnected. It blocks,
+// and must be invoked on its own goroutine for each client.
+func (ap *AgentPort) Serve(a agent.Agent) error {
+	ap.mu.Lock()
+	signatures, m := ap.signatures, ap.metrics
+	ap.mu.Unlock()
+	err := serveLimited(a, ap, signatures, m)
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+		ap.disconnect()
+	}
+	return err
+}
+
 func (ap *AgentPort) OnDisconnect() {
-	jsutil.LogDebug("AgentPort.OnDisconnect: closing input writer")
-	ap.inWriter.Close()
+	jsutil.LogDebug("AgentPort.OnDisconnect: closing request queue")
+	ap.mu.Lock()
+	ap.closed = true
+	ap.requests = nil
+	ap.backlog = nil
+	ap.backlogBytes = 0
+	ap.cond.Broadcast()
+	ap.mu.Unlock()
 	jsutil.LogDebug("AgentPort.OnDisconnect: closing output writer")
 	ap.outWriter.Close()
 }
 
+// disconnect disconnects the client. Chrome does not notify us when we
+// disconnect the port ourselves, so the pipes are also closed, such that the
+// agent stops serving the connection.
+func (ap *AgentPort) disconnect() {
+	ap.p.Call("disconnect")
+	ap.OnDisconnect()
+}
+
 type message struct {
 	Data []int  `js:"data"`
 	Type string `js:"type"`
 }
 
-func (ap *AgentPort) OnMessage(msg js.Value) {
-	jsutil.LogDebug("AgentPort.OnMessage: parsing message from client to agent")
+// parseMessage returns the request in a message from the client.
+func parseMessage(msg js.Value) ([]byte, error) {
 	var parsed message
 	if err := vert.ValueOf(msg).AssignTo(&parsed); err != nil {
-		jsutil.LogError("Failed to parse message to agent: %v; message=%s", err, msg)
-		ap.p.Call("disconnect")
-		return
+		return nil, fmt.Errorf("failed to parse message to agent: %w; message=%s", err, msg)
+	}
+	if len(parsed.Data) > MaxMessageSize {
+		return nil, fmt.Errorf("message to agent too large: %d bytes; maximum is %d", len(parsed.Data), MaxMessageSize)
 	}
 
-	jsutil.LogDebug("AgentPort.OnMessage: converting to bytestream")
-	framed := make([]byte, 4+len(parsed.Data))
-	binary.BigEndian.PutUint32(framed, uint32(len(parsed.Data)))
+	data := make([]byte, len(parsed.Data))
 	for i, raw := range parsed.Data {
-		framed[i+4] = byte(raw)
+		if raw < 0 || raw > 255 {
+			return nil, fmt.Errorf("message to agent contains invalid byte at offset %d: %d", i, raw)
+		}
+		data[i] = byte(raw)
 	}
+	return data, nil
+}
 
-	jsutil.LogDebug("AgentPort.OnMessage: writing to agent")
-	_, err := ap.inWriter.Write(framed)
+func (ap *AgentPort) OnMessage(msg js.Value) {
+	jsutil.LogDebug("AgentPort.OnMessage: queueing message from client to agent")
+	ap.mu.Lock()
+	var err error
+	if !ap.closed {
+		err = ap.enqueue(msg)
+		ap.lastActive = ap.now()
+		ap.cond.Signal()
+	}
+	ap.mu.Unlock()
 	if err != nil {
-		jsutil.LogError("Error writing to pipe: %v", err)
-		ap.p.Call("disconnect")
+		jsutil.LogError("Disconnecting client: %v", err)
+		ap.disconnect()
+	}
+}
+
+// answered records that a request was answered.
+func (ap *AgentPort) answered() {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	ap.outstanding--
+	ap.lastActive = ap.now()
+}
+
+// next waits for the next request from the client. It returns io.EOF once the
+// client disconnects.
+func (ap *AgentPort) next() ([]byte, error) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	for len(ap.requests) == 0 && !ap.closed {
+		ap.cond.Wait()
 	}
+	if ap.closed {
+		return nil, io.EOF
+	}
+	return ap.dequeue()
 }
 
 func (ap *AgentPort) Read(p []byte) (n int, err error) {
 	jsutil.LogDebug("AgentPort.Read: agent reading from client")
 	defer jsutil.LogDebug("AgentPort.Read: read finished")
-	return ap.inReader.Read(p)
+	if len(ap.unread) == 0 {
+		req, err := ap.next()
+		if err != nil {
+			return 0, err
+		}
+		var framed bytes.Buffer
+		if err := writeFrame(&framed, req); err != nil {
+			return 0, err
+		}
+		ap.unread = framed.Bytes()
+	}
+	n = copy(p, ap.unread)
+	ap.unread = ap.unread[n:]
+	return n, nil
 }
 
 const (
@@ -118,19 +269,8 @@ func (ap *AgentPort) SendMessages() {
 	jsutil.LogDebug("AgentPort.SendMessages: starting loop")
 	defer jsutil.LogDebug("AgentPort.SendMessages: finished loop")
 	for {
-		jsutil.LogDebug("AgentPort.SendMessages: reading message length from agent to client")
-		l := make([]byte, 4)
-		_, err := io.ReadFull(ap.outReader, l)
-		if err != nil {
-			jsutil.Log("AgentPort.SendMessages: Error reading from pipe: %v", err)
-			ap.outReader.Close()
-			return
-		}
-		length := binary.BigEndian.Uint32(l)
-
 		jsutil.LogDebug("AgentPort.SendMessages: reading message from agent to client")
-		data := make([]byte, length)
-		_, err = io.ReadFull(ap.outReader, data)
+		data, err := readFrame(ap.outReader)
 		if err != nil {
 			jsutil.Log("AgentPort.SendMessages: Error reading from pipe: %v", err)
 			ap.outReader.Close()
@@ -146,6 +286,7 @@ func (ap *AgentPort) SendMessages() {
 		}
 
 		jsutil.LogDebug("AgentPort.SendMessages: sending message to client")
+		ap.answered()
 		ap.p.Call("postMessage", vert.ValueOf(encoded).JSValue())
 	}
 }
@@ -162,7 +303,37 @@ type portRef struct {
 
 // AgentPorts is a mapping of chrome.runtime.Port objects to the corresponding
 // connection (AgentPort) with our Agent.
-type AgentPorts map[*portRef]*AgentPort
+type AgentPorts struct {
+	// IdleTimeout is the period of inactivity after which a connection is
+	// disconnected by DisconnectIdle, or zero if connections are never
+	// disconnected.
+	IdleTimeout time.Duration
+	// MaxInFlight is the maximum number of requests from each client that
+	// are held until the agent handles them. It applies to connections
+	// that are subsequently added.
+	MaxInFlight int
+	// Metrics counts the requests served, or is nil if they are not
+	// counted. It applies to connections that are subsequently added.
+	Metrics *metrics.Metrics
+
+	now   func() time.Time
+	ports map[*portRef]*AgentPort
+	// signatures limits the number of signature requests handled at
+	// once, across all connections.
+	signatures chan struct{}
+}
+
+// NewAgentPorts returns an empty AgentPorts, using DefaultIdleTimeout,
+// DefaultMaxInFlight and DefaultMaxConcurrentSignatures.
+func NewAgentPorts() *AgentPorts {
+	return &AgentPorts{
+		IdleTimeout: DefaultIdleTimeout,
+		MaxInFlight: DefaultMaxInFlight,
+		now:         time.Now,
+		ports:       map[*portRef]*AgentPort{},
+		signatures:  make(chan struct{}, DefaultMaxConcurrentSignatures),
+	}
+}
 
 // Lookup returns the AgentPort corresponding to the supplied Port value. A Port
 // value is considered equal if it refers to the exact same Port as was
@@ -170,8 +341,8 @@ type AgentPorts map[*portRef]*AgentPort
 // maintain a unique Port value for each port, and just pass around a reference
 // to it.  Thus, we use js.Value.Equal() to compare ports; two references to the
 // same object are equal iff they are equal in the '===' sense in Javascript.
-func (a AgentPorts) Lookup(port js.Value) *AgentPort {
-	for p, ap := range a {
+func (a *AgentPorts) Lookup(port js.Value) *AgentPort {
+	for p, ap := range a.ports {
 		if p.p.Equal(port) {
 			return ap
 		}
@@ -180,16 +351,45 @@ func (a AgentPorts) Lookup(port js.Value) *AgentPort {
 }
 
 // Delete removes the AgentPort corresponding to the supplied Port.
-func (a AgentPorts) Delete(port js.Value) {
-	for p := range a {
+func (a *AgentPorts) Delete(port js.Value) {
+	for p := range a.ports {
 		if p.p.Equal(port) {
-			delete(a, p)
+			delete(a.ports, p)
 			return
 		}
 	}
 }
 
-// Add adds an AgentPort corresponding to the supplied Port.
-func (a AgentPorts) Add(port js.Value, ap *AgentPort) {
-	a[&portRef{p: port}] = ap
+// DisconnectSenders disconnects the connections from extensions for which
+// allowed returns false, and returns the number disconnected. Connections from
+// clients that are not extensions are unaffected.
+func (a *AgentPorts) DisconnectSenders(allowed func(id string) bool) int {
+	var n int
+	for p, ap := range a.ports {
+		if id := SenderID(p.p); id == "" || allowed(id) {
+			continue
+		}
+		jsutil.LogError("AgentPorts.DisconnectSenders: disconnecting %s, which is no longer permitted to connect", Describe(p.p))
+		// Chrome does not notify us of ports that we disconnect.
+		ap.disconnect()
+		delete(a.ports, p)
+		n++
+	}
+	return n
+}
+
+// Add adds an AgentPort corresponding to the supplied Port. The AgentPort is
+// subject to the limits on requests; it must be added before it is served.
+func (a *AgentPorts) Add(port js.Value, ap *AgentPort) {
+	ap.mu.Lock()
+	ap.maxInFlight = a.MaxInFlight
+	ap.signatures = a.signatures
+	ap.metrics = a.Metrics
+	ap.mu.Unlock()
+	a.ports[&portRef{p: port}] = ap
+}
+
+// Len returns the number of connections.
+func (a *AgentPorts) Len() int {
+	return len(a.ports)
 }
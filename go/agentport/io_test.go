@@ -0,0 +1,231 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/norunners/vert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// fakePort is a fake chrome.runtime.Port. Messages posted to it are delivered
+// to a channel.
+type fakePort struct {
+	v        js.Value
+	messages chan []byte
+}
+
+func newFakePort() *fakePort {
+	fp := &fakePort{messages: make(chan []byte, 1024)}
+	fp.v = js.Global().Get("Object").New()
+	fp.v.Set("postMessage", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		var msg message
+		if err := vert.ValueOf(args[0]).AssignTo(&msg); err != nil {
+			panic(err)
+		}
+		data := make([]byte, len(msg.Data))
+		for i, b := range msg.Data {
+			data[i] = byte(b)
+		}
+		fp.messages <- data
+		return nil
+	}))
+	fp.v.Set("disconnect", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return nil
+	}))
+	return fp
+}
+
+// receive returns the next message posted to the port.
+func (fp *fakePort) receive(t *testing.T) []byte {
+	t.Helper()
+	select {
+	case msg := <-fp.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for message")
+		return nil
+	}
+}
+
+// send delivers a request to the AgentPort, as the client would.
+func send(ap *AgentPort, req []byte) {
+	msg := message{Type: messageType, Data: make([]int, len(req))}
+	for i, b := range req {
+		msg.Data[i] = int(b)
+	}
+	ap.OnMessage(vert.ValueOf(msg).JSValue())
+}
+
+// blockingAgent is an agent whose signatures block until released.
+type blockingAgent struct {
+	agent.Agent
+	release chan struct{}
+}
+
+func (a *blockingAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	<-a.release
+	return a.Agent.Sign(key, data)
+}
+
+// signRequest returns an SSH_AGENTC_SIGN_REQUEST for the key.
+func signRequest(pub ssh.PublicKey) []byte {
+	return append([]byte{13}, ssh.Marshal(struct {
+		Blob  []byte
+		Data  []byte
+		Flags uint32
+	}{pub.Marshal(), []byte("data"), 0})...)
+}
+
+func TestConcurrentPorts(t *testing.T) {
+	t.Parallel()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+
+	const (
+		identitiesAnswer = 12 // SSH_AGENT_IDENTITIES_ANSWER
+		signResponse     = 14 // SSH_AGENT_SIGN_RESPONSE
+	)
+	list := []byte{11} // SSH_AGENTC_REQUEST_IDENTITIES
+	sign := signRequest(signer.PublicKey())
+
+	slowPort, fastPort := newFakePort(), newFakePort()
+	slow, fast := New(slowPort.v), New(fastPort.v)
+	release := make(chan struct{})
+	slowDone := make(chan error, 1)
+	go func() { slowDone <- slow.Serve(&blockingAgent{Agent: keyring, release: release}) }()
+	go fast.Serve(keyring)
+	defer fast.OnDisconnect()
+
+	// Pipeline requests on the slow port; the first is blocked.
+	send(slow, sign)
+	send(slow, list)
+
+	// The fast port is served while the slow port is blocked.
+	send(fast, list)
+	if rsp := fastPort.receive(t); rsp[0] != identitiesAnswer {
+		t.Errorf("incorrect response on fast port; got type %d, want %d", rsp[0], identitiesAnswer)
+	}
+	send(fast, sign)
+	if rsp := fastPort.receive(t); rsp[0] != signResponse {
+		t.Errorf("incorrect response on fast port; got type %d, want %d", rsp[0], signResponse)
+	}
+	if n := len(slowPort.messages); n != 0 {
+		t.Errorf("slow port received %d responses while blocked", n)
+	}
+
+	// Responses on the slow port are in the order of the requests.
+	close(release)
+	if rsp := slowPort.receive(t); rsp[0] != signResponse {
+		t.Errorf("incorrect first response on slow port; got type %d, want %d", rsp[0], signResponse)
+	}
+	if rsp := slowPort.receive(t); rsp[0] != identitiesAnswer {
+		t.Errorf("incorrect second response on slow port; got type %d, want %d", rsp[0], identitiesAnswer)
+	}
+
+	// Disconnecting one port does not affect the other.
+	slow.OnDisconnect()
+	if err := <-slowDone; !errors.Is(err, io.EOF) {
+		t.Errorf("Serve returned incorrect error on disconnect: %v", err)
+	}
+	send(slow, list)
+	send(fast, list)
+	if rsp := fastPort.receive(t); rsp[0] != identitiesAnswer {
+		t.Errorf("incorrect response on fast port after disconnect; got type %d, want %d", rsp[0], identitiesAnswer)
+	}
+	if n := len(slowPort.messages); n != 0 {
+		t.Errorf("disconnected port received %d responses", n)
+	}
+}
+
+func TestDisconnectSenders(t *testing.T) {
+	t.Parallel()
+
+	const (
+		allowed = "pnhechapfaindjhompbnflcldabbghjo"
+		refused = "okddffdblfhhnmhodogpojmfkjmhinfp"
+	)
+	ports := NewAgentPorts()
+
+	// add connects a client with the specified extension ID, or one that
+	// is not an extension if id is empty.
+	add := func(id string) (*fakePort, *AgentPort, chan error) {
+		fp := newFakePort()
+		if id != "" {
+			sender := js.Global().Get("Object").New()
+			sender.Set("id", id)
+			fp.v.Set("sender", sender)
+		}
+		ap := New(fp.v)
+		ports.Add(fp.v, ap)
+		done := make(chan error, 1)
+		go func() { done <- ap.Serve(agent.NewKeyring()) }()
+		return fp, ap, done
+	}
+	list := []byte{11} // SSH_AGENTC_REQUEST_IDENTITIES
+
+	allowedPort, allowedAP, _ := add(allowed)
+	defer allowedAP.OnDisconnect()
+	nativePort, nativeAP, _ := add("")
+	defer nativeAP.OnDisconnect()
+	refusedPort, refusedAP, refusedDone := add(refused)
+
+	if n := ports.DisconnectSenders(func(id string) bool { return id == allowed }); n != 1 {
+		t.Errorf("incorrect number of connections disconnected; got %d, want 1", n)
+	}
+	if ports.Lookup(refusedPort.v) != nil {
+		t.Errorf("refused connection not removed")
+	}
+	if err := <-refusedDone; !errors.Is(err, io.EOF) {
+		t.Errorf("Serve returned incorrect error on disconnect: %v", err)
+	}
+	send(refusedAP, list)
+	if n := len(refusedPort.messages); n != 0 {
+		t.Errorf("disconnected port received %d responses", n)
+	}
+
+	// Other connections are still served.
+	for _, c := range []struct {
+		fp *fakePort
+		ap *AgentPort
+	}{{allowedPort, allowedAP}, {nativePort, nativeAP}} {
+		if ports.Lookup(c.fp.v) == nil {
+			t.Errorf("connection %s removed", Describe(c.fp.v))
+		}
+		send(c.ap, list)
+		c.fp.receive(t)
+	}
+}
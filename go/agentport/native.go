@@ -0,0 +1,300 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"encoding/base64"
+	"sync"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/norunners/vert"
+)
+
+const (
+	// nativeClose is the type of message that closes a channel, in
+	// either direction.
+	nativeClose = "close"
+)
+
+// nativeMessage is a message exchanged with a native messaging host. A host
+// may relay several clients' connections to the agent at once, so each is
+// assigned a channel by the host; a message for a channel that is not open
+// opens it.
+//
+// Messages to and from the host are encoded as JSON, and messages from the
+// host are limited to 1MB, so the agent protocol message is encoded using
+// base64 rather than as an array of bytes. See:
+//
+//	https://developer.chrome.com/docs/extensions/develop/concepts/native-messaging#native-messaging-host-protocol
+type nativeMessage struct {
+	// Type is messageType for an agent protocol message, or nativeClose.
+	Type    string `js:"type"`
+	Channel int    `js:"channel"`
+	// Data is the base64-encoded agent protocol message, excluding its
+	// length.
+	Data string `js:"data"`
+}
+
+// ConnectNative connects to the named native messaging host using runtime,
+// which is typically chrome.runtime.
+func ConnectNative(runtime js.Value, name string) js.Value {
+	return runtime.Call("connectNative", name)
+}
+
+// NativeHost serves the agent to the clients relayed by a native messaging
+// host. Each channel is served as though the client had connected its own
+// port; it is added to ports, such that it is subject to the same limits and
+// idle timeout as other connections.
+type NativeHost struct {
+	name  string
+	port  js.Value
+	ports *AgentPorts
+	// serve adds a channel's port to ports and serves the agent to it.
+	serve func(port js.Value) *AgentPort
+
+	// postMessage and disconnect implement the methods of each channel's
+	// port, which identifies the channel. They are never released, since
+	// the AgentPort for a channel may invoke them after it is closed.
+	postMessage js.Func
+	disconnect  js.Func
+
+	// mu guards the fields below.
+	mu sync.Mutex
+	// channels are the ports of the open channels, keyed by the channel
+	// assigned by the host.
+	channels map[int]js.Value
+	// closed indicates that the host disconnected or was closed.
+	closed  bool
+	cleanup jsutil.CleanupFuncs
+}
+
+// NewNativeHost serves the agent to the clients relayed by the named native
+// messaging host, to which port is connected; see ConnectNative. serve is
+// invoked with an object resembling a chrome.runtime.Port for each channel
+// the host opens; it must add the port to ports, and serve the agent to it.
+func NewNativeHost(port js.Value, name string, ports *AgentPorts, serve func(port js.Value) *AgentPort) *NativeHost {
+	jsutil.LogDebug("NativeHost.New: %s", name)
+	h := &NativeHost{
+		name:     name,
+		port:     port,
+		ports:    ports,
+		serve:    serve,
+		channels: map[int]js.Value{},
+	}
+	h.postMessage = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		h.postChannel(this.Get("channel").Int(), jsutil.SingleArg(args))
+		return nil
+	})
+	h.disconnect = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		h.disconnectChannel(this.Get("channel").Int())
+		return nil
+	})
+	h.cleanup.Add(addListener(port.Get("onMessage"), func(args []js.Value) {
+		h.onMessage(jsutil.SingleArg(args))
+	}))
+	h.cleanup.Add(addListener(port.Get("onDisconnect"), func(_ []js.Value) {
+		h.onDisconnect()
+	}))
+	return h
+}
+
+// addListener adds a listener to the Chrome event, and returns a function
+// that removes it.
+func addListener(event js.Value, f func(args []js.Value)) jsutil.CleanupFunc {
+	listener := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		f(args)
+		return nil
+	})
+	event.Call("addListener", listener)
+	return func() {
+		event.Call("removeListener", listener)
+		listener.Release()
+	}
+}
+
+// Name returns the name of the native messaging host.
+func (h *NativeHost) Name() string {
+	return h.name
+}
+
+// Connected returns true if the host remains connected.
+func (h *NativeHost) Connected() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.closed
+}
+
+// Close disconnects the host, and all the clients it relays.
+func (h *NativeHost) Close() {
+	jsutil.LogDebug("NativeHost.Close: %s", h.name)
+	if h.shutdown() {
+		// Chrome does not notify us of ports that we disconnect.
+		h.port.Call("disconnect")
+	}
+}
+
+func (h *NativeHost) onDisconnect() {
+	// Chrome reports the reason the host disconnected, such as it failing
+	// to start, using chrome.runtime.lastError.
+	if chrome := js.Global().Get("chrome"); chrome.Truthy() && chrome.Get("runtime").Get("lastError").Truthy() {
+		jsutil.LogError("Native messaging host %s disconnected: %s", h.name, chrome.Get("runtime").Get("lastError").Get("message"))
+	} else {
+		jsutil.Log("Native messaging host %s disconnected", h.name)
+	}
+	h.shutdown()
+}
+
+// shutdown closes all channels, and stops listening to the host. It returns
+// false if the host was already closed.
+func (h *NativeHost) shutdown() bool {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return false
+	}
+	h.closed = true
+	channels := h.channels
+	h.channels = map[int]js.Value{}
+	h.mu.Unlock()
+
+	for _, c := range channels {
+		h.closeChannel(c)
+	}
+	h.cleanup.Do()
+	return true
+}
+
+func (h *NativeHost) onMessage(msg js.Value) {
+	var parsed nativeMessage
+	if err := vert.ValueOf(msg).AssignTo(&parsed); err != nil {
+		jsutil.LogError("Failed to parse message from native messaging host %s: %v", h.name, err)
+		return
+	}
+
+	switch parsed.Type {
+	case messageType:
+		data, err := base64.StdEncoding.DecodeString(parsed.Data)
+		if err != nil {
+			jsutil.LogError("Failed to decode message from native messaging host %s on channel %d: %v", h.name, parsed.Channel, err)
+			h.disconnectChannel(parsed.Channel)
+			return
+		}
+		ap := h.open(parsed.Channel)
+		if ap == nil {
+			return
+		}
+		encoded := message{Type: messageType, Data: make([]int, len(data))}
+		for i, b := range data {
+			encoded.Data[i] = int(b)
+		}
+		ap.OnMessage(vert.ValueOf(encoded).JSValue())
+	case nativeClose:
+		jsutil.LogDebug("NativeHost.onMessage: %s closed channel %d", h.name, parsed.Channel)
+		h.mu.Lock()
+		c, ok := h.channels[parsed.Channel]
+		delete(h.channels, parsed.Channel)
+		h.mu.Unlock()
+		if ok {
+			h.closeChannel(c)
+		}
+	default:
+		jsutil.LogError("Unknown message type from native messaging host %s: %s", h.name, parsed.Type)
+	}
+}
+
+// open returns the AgentPort for the channel, opening it if necessary. It
+// returns nil if the host is closed.
+func (h *NativeHost) open(channel int) *AgentPort {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	if c, ok := h.channels[channel]; ok {
+		if ap := h.ports.Lookup(c); ap != nil {
+			return ap
+		}
+	}
+
+	jsutil.LogDebug("NativeHost.open: %s opened channel %d", h.name, channel)
+	c := jsutil.NewObject()
+	// The port is named for the host, such that the client is identified
+	// in the audit log and when confirming use of keys.
+	c.Set("name", h.name)
+	c.Set("channel", channel)
+	c.Set("postMessage", h.postMessage)
+	c.Set("disconnect", h.disconnect)
+	h.channels[channel] = c
+	return h.serve(c)
+}
+
+// postChannel sends a message from the agent to the client on the channel.
+func (h *NativeHost) postChannel(channel int, msg js.Value) {
+	var parsed message
+	if err := vert.ValueOf(msg).AssignTo(&parsed); err != nil {
+		jsutil.LogError("Failed to parse message to native messaging host %s: %v", h.name, err)
+		return
+	}
+	data := make([]byte, len(parsed.Data))
+	for i, b := range parsed.Data {
+		data[i] = byte(b)
+	}
+	h.post(nativeMessage{
+		Type:    parsed.Type,
+		Channel: channel,
+		Data:    base64.StdEncoding.EncodeToString(data),
+	})
+}
+
+// post sends a message to the host, unless it is closed.
+func (h *NativeHost) post(msg nativeMessage) {
+	h.mu.Lock()
+	closed := h.closed
+	h.mu.Unlock()
+	if closed {
+		return
+	}
+	// Chrome throws if the host disconnected, which we may not yet have
+	// been notified of.
+	defer func() {
+		if r := recover(); r != nil {
+			jsutil.LogError("Failed to send message to native messaging host %s: %v", h.name, r)
+		}
+	}()
+	h.port.Call("postMessage", vert.ValueOf(msg).JSValue())
+}
+
+// disconnectChannel closes the channel, and notifies the host.
+func (h *NativeHost) disconnectChannel(channel int) {
+	h.mu.Lock()
+	c, ok := h.channels[channel]
+	delete(h.channels, channel)
+	h.mu.Unlock()
+	if ok {
+		h.closeChannel(c)
+	}
+	h.post(nativeMessage{Type: nativeClose, Channel: channel})
+}
+
+// closeChannel stops serving the agent to the channel's port.
+func (h *NativeHost) closeChannel(port js.Value) {
+	if ap := h.ports.Lookup(port); ap != nil {
+		ap.OnDisconnect()
+		h.ports.Delete(port)
+	}
+}
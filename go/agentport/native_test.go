@@ -0,0 +1,159 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"encoding/base64"
+	"syscall/js"
+	"testing"
+	"time"
+
+	mfakes "github.com/google/chrome-ssh-agent/go/message/fakes"
+	"github.com/google/go-cmp/cmp"
+	"github.com/norunners/vert"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestNativeHost(t *testing.T) {
+	t.Parallel()
+
+	const host = "com.example.ssh_agent"
+	list := []byte{11} // SSH_AGENTC_REQUEST_IDENTITIES
+
+	runtime := mfakes.NewNativeRuntime()
+	ports := NewAgentPorts()
+	var names []string
+	serve := func(port js.Value) *AgentPort {
+		names = append(names, PortName(port))
+		ap := New(port)
+		ports.Add(port, ap)
+		go ap.Serve(agent.NewKeyring())
+		return ap
+	}
+	connect := func() (*NativeHost, *mfakes.NativePort) {
+		h := NewNativeHost(ConnectNative(runtime.Value(), host), host, ports, serve)
+		return h, runtime.Port(host)
+	}
+
+	// send delivers a message from the host.
+	send := func(fp *mfakes.NativePort, typ string, channel int, data string) {
+		fp.Send(vert.ValueOf(nativeMessage{Type: typ, Channel: channel, Data: data}).JSValue())
+	}
+	sendRequest := func(fp *mfakes.NativePort, channel int, req []byte) {
+		send(fp, messageType, channel, base64.StdEncoding.EncodeToString(req))
+	}
+	// receive returns the next message sent to the host, with its data
+	// decoded.
+	receive := func(fp *mfakes.NativePort) (nativeMessage, []byte) {
+		t.Helper()
+		msg, ok := fp.Receive(5 * time.Second)
+		if !ok {
+			t.Fatalf("timed out waiting for message")
+		}
+		var parsed nativeMessage
+		if err := vert.ValueOf(msg).AssignTo(&parsed); err != nil {
+			t.Fatalf("failed to parse message: %v", err)
+		}
+		data, err := base64.StdEncoding.DecodeString(parsed.Data)
+		if err != nil {
+			t.Fatalf("failed to decode message: %v", err)
+		}
+		return parsed, data
+	}
+	// expectResponse expects an answer to a request on the channel.
+	expectResponse := func(fp *mfakes.NativePort, channel int) {
+		t.Helper()
+		msg, data := receive(fp)
+		if msg.Type != messageType || msg.Channel != channel {
+			t.Errorf("incorrect response; got type %s on channel %d, want type %s on channel %d", msg.Type, msg.Channel, messageType, channel)
+		}
+		if diff := cmp.Diff(data, []byte{12, 0, 0, 0, 0}); diff != "" { // SSH_AGENT_IDENTITIES_ANSWER
+			t.Errorf("incorrect response; -got +want: %s", diff)
+		}
+	}
+	// expectClose expects the agent to close the channel.
+	expectClose := func(fp *mfakes.NativePort, channel int) {
+		t.Helper()
+		msg, _ := receive(fp)
+		if msg.Type != nativeClose || msg.Channel != channel {
+			t.Errorf("incorrect message; got type %s on channel %d, want type %s on channel %d", msg.Type, msg.Channel, nativeClose, channel)
+		}
+	}
+	expectConnections := func(want int) {
+		t.Helper()
+		if n := ports.Len(); n != want {
+			t.Errorf("incorrect number of connections; got %d, want %d", n, want)
+		}
+	}
+
+	h, fp := connect()
+
+	// Each channel is served as its own connection, named for the host.
+	sendRequest(fp, 1, list)
+	expectResponse(fp, 1)
+	sendRequest(fp, 2, list)
+	expectResponse(fp, 2)
+	sendRequest(fp, 1, list)
+	expectResponse(fp, 1)
+	expectConnections(2)
+	if diff := cmp.Diff(names, []string{host, host}); diff != "" {
+		t.Errorf("incorrect port names; -got +want: %s", diff)
+	}
+
+	// The host may close a channel.
+	send(fp, nativeClose, 1, "")
+	expectConnections(1)
+
+	// Invalid messages close the channel.
+	send(fp, messageType, 2, "not base64!")
+	expectClose(fp, 2)
+	expectConnections(0)
+	sendRequest(fp, 3, make([]byte, MaxMessageSize+1))
+	expectClose(fp, 3)
+	expectConnections(0)
+
+	// Messages of an unknown type are ignored.
+	send(fp, "unknown", 4, "")
+	expectConnections(0)
+
+	// All channels are closed when the host exits.
+	sendRequest(fp, 5, list)
+	expectResponse(fp, 5)
+	sendRequest(fp, 6, list)
+	expectResponse(fp, 6)
+	expectConnections(2)
+	fp.Exit()
+	expectConnections(0)
+	if h.Connected() {
+		t.Errorf("host unexpectedly connected after exit")
+	}
+
+	// All channels are closed, and the host disconnected, when closed.
+	h, fp = connect()
+	sendRequest(fp, 1, list)
+	expectResponse(fp, 1)
+	h.Close()
+	expectConnections(0)
+	if !fp.Disconnected() {
+		t.Errorf("host not disconnected when closed")
+	}
+	if h.Connected() {
+		t.Errorf("host unexpectedly connected after close")
+	}
+	// Closing again has no effect.
+	h.Close()
+}
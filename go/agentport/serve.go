@@ -0,0 +1,121 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/metrics"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// MaxMessageSize is the maximum size of a message in the SSH agent protocol,
+// excluding its length; see draft-miller-ssh-agent.
+const MaxMessageSize = 256 * 1024
+
+var errMessageTooLarge = errors.New("message too large")
+
+// failure is the SSH_AGENT_FAILURE response.
+var failure = []byte{5}
+
+// readFrame reads a message preceded by its length. It fails if the message is
+// larger than MaxMessageSize, or is truncated.
+func readFrame(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > MaxMessageSize {
+		return nil, fmt.Errorf("%w: %d bytes", errMessageTooLarge, n)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeFrame writes a message preceded by its length.
+func writeFrame(w io.Writer, msg []byte) error {
+	framed := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(framed, uint32(len(msg)))
+	copy(framed[4:], msg)
+	_, err := w.Write(framed)
+	return err
+}
+
+// Serve serves the SSH agent protocol over the connection until it is closed,
+// or a message exceeds MaxMessageSize. It is a replacement for
+// agent.ServeAgent, which ends the connection on an empty request, and whose
+// panics while handling a request would end the program. Instead, requests
+// that cannot be handled for any reason are answered with SSH_AGENT_FAILURE,
+// as are requests of unknown types.
+func Serve(a agent.Agent, c io.ReadWriter) error {
+	return serveLimited(a, c, nil, nil)
+}
+
+// serveLimited is like Serve, but limits the number of signature requests handled at
+// once, and counts requests; see handleLimited.
+func serveLimited(a agent.Agent, c io.ReadWriter, signatures chan struct{}, m *metrics.Metrics) error {
+	for {
+		req, err := readFrame(c)
+		if err != nil {
+			return err
+		}
+		if err := writeFrame(c, handleLimited(a, req, signatures, m)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a single request, and returns the response.
+func handle(a agent.Agent, req []byte) (rsp []byte) {
+	if len(req) == 0 {
+		jsutil.LogDebug("agentport.handle: empty request")
+		return failure
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			jsutil.LogError("panic while handling agent request of type %d: %v", req[0], r)
+			rsp = failure
+		}
+	}()
+
+	// Use agent.ServeAgent to handle the request, such that its message
+	// parsing is reused. It returns once it has answered the request and
+	// reaches the end of the input.
+	var in, out bytes.Buffer
+	if err := writeFrame(&in, req); err != nil {
+		return failure
+	}
+	agent.ServeAgent(a, struct {
+		io.Reader
+		io.Writer
+	}{&in, &out})
+	rsp, err := readFrame(&out)
+	if err != nil {
+		jsutil.LogError("failed to handle agent request of type %d: %v", req[0], err)
+		return failure
+	}
+	return rsp
+}
@@ -0,0 +1,204 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// panicAgent is an agent that panics when listing keys.
+type panicAgent struct {
+	agent.Agent
+}
+
+func (a *panicAgent) List() ([]*agent.Key, error) {
+	panic("oops")
+}
+
+// serve serves the agent over a pipe. It returns the client end of the pipe,
+// and a channel that receives the result of Serve.
+func serve(a agent.Agent) (net.Conn, chan error) {
+	c, s := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(a, s)
+		s.Close()
+	}()
+	return c, done
+}
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+
+	identities := []byte{11}               // SSH_AGENTC_REQUEST_IDENTITIES
+	noIdentities := []byte{12, 0, 0, 0, 0} // SSH_AGENT_IDENTITIES_ANSWER
+
+	testcases := []struct {
+		description string
+		agent       agent.Agent
+		req         []byte
+		wantRsp     []byte
+	}{
+		{
+			description: "known request",
+			req:         identities,
+			wantRsp:     noIdentities,
+		},
+		{
+			description: "unknown request",
+			req:         []byte{20}, // SSH_AGENTC_ADD_SMARTCARD_KEY
+			wantRsp:     failure,
+		},
+		{
+			description: "unknown extension",
+			req:         []byte{27, 0, 0, 0, 3, 'f', 'o', 'o'}, // SSH_AGENTC_EXTENSION
+			wantRsp:     failure,
+		},
+		{
+			description: "empty request",
+			req:         []byte{},
+			wantRsp:     failure,
+		},
+		{
+			description: "truncated request",
+			req:         []byte{17, 0, 0, 0, 100}, // SSH_AGENTC_ADD_IDENTITY
+			wantRsp:     failure,
+		},
+		{
+			description: "panic handling request",
+			agent:       &panicAgent{Agent: agent.NewKeyring()},
+			req:         identities,
+			wantRsp:     failure,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			a := tc.agent
+			if a == nil {
+				a = agent.NewKeyring()
+			}
+			c, done := serve(a)
+			defer c.Close()
+
+			if err := writeFrame(c, tc.req); err != nil {
+				t.Fatalf("failed to write request: %v", err)
+			}
+			rsp, err := readFrame(c)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			if diff := cmp.Diff(rsp, tc.wantRsp); diff != "" {
+				t.Errorf("incorrect response; -got +want: %s", diff)
+			}
+
+			// The connection remains usable.
+			if tc.agent == nil {
+				if err := writeFrame(c, identities); err != nil {
+					t.Fatalf("failed to write request: %v", err)
+				}
+				rsp, err = readFrame(c)
+				if err != nil {
+					t.Fatalf("failed to read response: %v", err)
+				}
+				if diff := cmp.Diff(rsp, noIdentities); diff != "" {
+					t.Errorf("incorrect response to subsequent request; -got +want: %s", diff)
+				}
+			}
+
+			c.Close()
+			if err := <-done; !errors.Is(err, io.EOF) {
+				t.Errorf("Serve returned incorrect error on close: %v", err)
+			}
+		})
+	}
+}
+
+func TestServeTooLarge(t *testing.T) {
+	t.Parallel()
+
+	c, done := serve(agent.NewKeyring())
+	defer c.Close()
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], MaxMessageSize+1)
+	if _, err := c.Write(length[:]); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+	if err := <-done; !errors.Is(err, errMessageTooLarge) {
+		t.Errorf("Serve returned incorrect error: %v", err)
+	}
+	// The connection is closed.
+	if _, err := readFrame(c); err == nil {
+		t.Errorf("connection not closed")
+	}
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 1, 11})
+	f.Add([]byte{0, 0, 0, 0})
+	f.Add([]byte{0, 0, 0, 5, 1})
+	f.Add([]byte{0, 0, 0})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3})
+	f.Add([]byte{0, 4, 0, 1})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Input may be arbitrary, such as that sent by a misbehaving
+		// client. We must never panic when reading it.
+		msg, err := readFrame(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if len(msg) > MaxMessageSize {
+			t.Errorf("read message of %d bytes; maximum is %d", len(msg), MaxMessageSize)
+		}
+		var buf bytes.Buffer
+		if err := writeFrame(&buf, msg); err != nil {
+			t.Fatalf("failed to write message: %v", err)
+		}
+		if !bytes.HasPrefix(data, buf.Bytes()) {
+			t.Errorf("message did not round trip; got %x, want prefix of %x", buf.Bytes(), data)
+		}
+	})
+}
+
+func FuzzHandle(f *testing.F) {
+	f.Add([]byte{11})
+	f.Add([]byte{13, 0, 0, 0, 1})
+	f.Add([]byte{17, 0, 0, 0, 7, 's', 's', 'h', '-', 'r', 's', 'a'})
+	f.Add([]byte{18, 0, 0, 0, 0})
+	f.Add([]byte{25, 0, 0, 0, 0xff})
+	f.Add([]byte{27, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, req []byte) {
+		// Every request is answered, whatever its content.
+		if rsp := handle(agent.NewKeyring(), req); len(rsp) == 0 {
+			t.Errorf("no response to request %x", req)
+		}
+	})
+}
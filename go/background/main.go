@@ -18,36 +18,151 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"slices"
 	"syscall/js"
+	"time"
 
 	"github.com/google/chrome-ssh-agent/go/agentport"
 	"github.com/google/chrome-ssh-agent/go/app"
+	"github.com/google/chrome-ssh-agent/go/badge"
+	"github.com/google/chrome-ssh-agent/go/commands"
+	"github.com/google/chrome-ssh-agent/go/confirm"
 	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/keepalive"
 	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/message"
+	"github.com/google/chrome-ssh-agent/go/metrics"
+	"github.com/google/chrome-ssh-agent/go/notifications"
 	"github.com/google/chrome-ssh-agent/go/storage"
+	"github.com/google/chrome-ssh-agent/go/webauthn"
+	"github.com/google/chrome-ssh-agent/go/webcrypto"
 	"golang.org/x/crypto/ssh/agent"
 )
 
+const (
+	// compactionAlarm is the name of the alarm used to schedule
+	// compaction of storage.
+	compactionAlarm = "compact-storage"
+	// compactionPeriod is the interval between compactions.
+	compactionPeriod = 24 * time.Hour
+	// idleUnloadAlarm is the name of the alarm used to schedule unloading
+	// of idle keys.
+	idleUnloadAlarm = "unload-idle-keys"
+	// expiryAlarm is the name of the alarm used to schedule checks for
+	// keys that are about to expire.
+	expiryAlarm = "check-key-expiry"
+	// expiryCheckPeriod is the interval between checks for expiring keys.
+	expiryCheckPeriod = 24 * time.Hour
+	// idleConnectionAlarm is the name of the alarm used to schedule
+	// disconnection of idle clients.
+	idleConnectionAlarm = "disconnect-idle-connections"
+	// idleConnectionCheckPeriod is the interval between checks for idle
+	// clients.
+	idleConnectionCheckPeriod = 5 * time.Minute
+	// metricsAlarm is the name of the alarm used to schedule writing of
+	// metrics to storage.
+	metricsAlarm = "flush-metrics"
+	// metricsFlushPeriod is the interval between writes of metrics to
+	// storage. Counts observed since the last write are lost if the
+	// service worker is stopped.
+	metricsFlushPeriod = time.Minute
+	// expiryWarning is how long before a key expires that the user is
+	// warned.
+	expiryWarning = 7 * 24 * time.Hour
+	// confirmPage is the page opened to confirm use of a key.
+	confirmPage = "html/options.html"
+	// confirmTimeout is the period after which use of a key is denied
+	// if the user has not responded.
+	confirmTimeout = time.Minute
+	// securityKeyPage is the page opened to perform operations on
+	// security keys.
+	securityKeyPage = "html/options.html"
+	// securityKeyTimeout is the period after which an operation on a
+	// security key fails if the user has not touched it.
+	securityKeyTimeout = time.Minute
+	// lockPage is the page opened to lock or unlock the agent, since the
+	// user must enter a passphrase. Keep the query string parameter in
+	// sync with go/options/main.go.
+	lockPage = "html/options.html?lock"
+	// optionsPage is the page opened when the user clicks a notification.
+	optionsPage = "html/options.html"
+	// notificationIcon is the icon displayed in notifications.
+	notificationIcon = "img/icon128.png"
+	// quotaWarningPercent is the percentage of the sync storage quota in
+	// use at which the user is warned.
+	quotaWarningPercent = 80
+)
+
 type background struct {
-	// agent is keyring with the loaded keys.
-	agent agent.Agent
 	// ports manages opened ports for communicating with the agent.
-	ports agentport.AgentPorts
+	ports *agentport.AgentPorts
 	// manager is a wrapper that can manage loaded keys.
 	manager *keys.DefaultManager
 	// server exposes an API for the manager.
 	server *keys.Server
+	// prompter asks the user to confirm use of keys.
+	prompter *confirm.Prompter
+	// broker performs operations on security keys.
+	broker *webauthn.Broker
+	// metrics counts the requests served by the agent.
+	metrics *metrics.Metrics
+	// keepAlive keeps the service worker running during long operations.
+	keepAlive *keepalive.KeepAlive
+	// notifier notifies the user of events affecting their keys.
+	notifier *notifications.Notifier
+	// commands performs keyboard commands.
+	commands *commands.Handler
+	// nativeHosts are the native messaging hosts to which the agent is
+	// connected, keyed by name.
+	nativeHosts map[string]*agentport.NativeHost
+	// compactable are the storage areas that are periodically compacted.
+	compactable []*storage.Big
 }
 
 func newBackground() *background {
-	agt := agent.NewKeyring()
-	mgr := keys.NewManager(agt, storage.DefaultSync(), storage.DefaultSession())
-	return &background{
-		agent:   agt,
-		ports:   agentport.AgentPorts{},
-		manager: mgr,
-		server:  keys.NewServer(mgr),
+	mgr := keys.NewManager(agent.NewKeyring(), storage.DefaultSync(), storage.DefaultSession())
+	chrome := js.Global().Get("chrome")
+	prompter := confirm.NewPrompter(confirm.OpenWindow(chrome.Get("windows"), chrome.Get("runtime").Call("getURL", confirmPage).String()), confirmTimeout)
+	mgr.SetConfirmer(prompter.Confirm)
+	// Security key credentials are scoped to the extension ID, which is
+	// the WebAuthn relying party ID for extension pages.
+	broker := webauthn.NewBroker(webauthn.OpenWindow(chrome.Get("windows"), chrome.Get("runtime").Call("getURL", securityKeyPage).String()), securityKeyTimeout)
+	mgr.SetAuthenticator(broker, chrome.Get("runtime").Get("id").String())
+	mgr.SetCryptoKeyStore(webcrypto.NewStore(js.Global().Get("crypto").Get("subtle"), storage.DefaultIndexedDB(webcrypto.DBName)))
+	keepAlive := keepalive.Default()
+	mgr.SetKeepAlive(keepAlive)
+	notifier := notifications.New(
+		chrome.Get("notifications"),
+		chrome.Get("runtime").Call("getURL", notificationIcon).String(),
+		storage.DefaultSync(),
+		notifications.OpenTab(chrome.Get("tabs"), chrome.Get("runtime").Call("getURL", optionsPage).String()))
+	m := metrics.New(storage.DefaultLocal())
+	ports := agentport.NewAgentPorts()
+	ports.Metrics = m
+	// Options pages are informed of the progress of keys being
+	// generated.
+	server := keys.NewServer(mgr)
+	server.SetProgress(message.NewLocalSender())
+	a := &background{
+		ports:       ports,
+		manager:     mgr,
+		server:      server,
+		prompter:    prompter,
+		broker:      broker,
+		metrics:     m,
+		keepAlive:   keepAlive,
+		notifier:    notifier,
+		commands:    commands.New(mgr, commands.OpenOptionsPage(chrome.Get("runtime"))),
+		nativeHosts: map[string]*agentport.NativeHost{},
+		compactable: storage.DefaultCompactable(),
 	}
+	mgr.SetOnRefused(a.onRefused)
+	a.commands.Register(commands.ToggleLock, func(ctx jsutil.AsyncContext) error {
+		a.openLockPage(ctx)
+		return nil
+	})
+	return a
 }
 
 func (a *background) Name() string {
@@ -63,17 +178,129 @@ func (a *background) Init(ctx jsutil.AsyncContext, cleanup *jsutil.CleanupFuncs)
 		jsutil.LogError("failed to load keys into agent: %v", err)
 	}
 
+	// Open options pages are kept up-to-date with changes to keys,
+	// including those made from other devices.
+	stop, err := a.manager.OnChange(ctx, func(ctx jsutil.AsyncContext, e keys.ChangeEvent) {
+		keys.NotifyChanges(ctx, message.NewLocalSender(), []keys.ChangeEvent{e})
+	})
+	if err != nil {
+		jsutil.LogError("failed to watch for changes to keys: %v", err)
+	} else {
+		cleanup.Add(stop)
+	}
+
+	// The toolbar icon displays the number of loaded keys, and whether the
+	// agent is locked.
+	if stop, err := badge.New(js.Global().Get("chrome").Get("action")).Watch(ctx, a.manager); err != nil {
+		jsutil.LogError("failed to display state of agent on toolbar icon: %v", err)
+	} else {
+		cleanup.Add(stop)
+	}
+
+	// Connections are refused until the extensions permitted to connect
+	// are known, and closed once they are no longer permitted.
+	jsutil.Log("Reading extensions permitted to connect")
+	if stop, err := a.manager.WatchAllowedClients(ctx, a.disconnectRefused); err != nil {
+		jsutil.LogError("failed to read extensions permitted to connect: %v", err)
+	} else {
+		cleanup.Add(stop)
+	}
+
+	// Native messaging hosts may relay connections from clients that are
+	// not extensions, such as local SSH clients.
+	jsutil.Log("Connecting to native messaging hosts")
+	if stop, err := a.manager.WatchNativeHosts(ctx, a.connectNativeHosts); err != nil {
+		jsutil.LogError("failed to read native messaging hosts: %v", err)
+	} else {
+		cleanup.Add(stop)
+	}
+
+	// Init is invoked whenever the service worker starts, including at
+	// browser startup.
+	jsutil.Log("Loading keys configured to load automatically")
+	a.loadAutoKeys(ctx)
+
+	jsutil.Log("Scheduling storage compaction")
+	if err := storage.ScheduleCompaction(ctx, js.Global().Get("chrome").Get("alarms"), compactionAlarm, compactionPeriod); err != nil {
+		jsutil.LogError("failed to schedule storage compaction: %v", err)
+	}
+
+	jsutil.Log("Scheduling expiry checks")
+	if err := schedulePeriodic(ctx, js.Global().Get("chrome").Get("alarms"), expiryAlarm, expiryCheckPeriod); err != nil {
+		jsutil.LogError("failed to schedule expiry checks: %v", err)
+	}
+
+	jsutil.Log("Reading metrics")
+	if err := a.metrics.Load(ctx); err != nil {
+		jsutil.LogError("failed to read metrics: %v", err)
+	}
+	jsutil.Log("Scheduling writes of metrics")
+	if err := schedulePeriodic(ctx, js.Global().Get("chrome").Get("alarms"), metricsAlarm, metricsFlushPeriod); err != nil {
+		jsutil.LogError("failed to schedule writes of metrics: %v", err)
+	}
+
+	jsutil.Log("Scheduling checks for idle connections")
+	if err := schedulePeriodic(ctx, js.Global().Get("chrome").Get("alarms"), idleConnectionAlarm, idleConnectionCheckPeriod); err != nil {
+		jsutil.LogError("failed to schedule checks for idle connections: %v", err)
+	}
+
+	// Keys may have become idle while the service worker was not running.
+	jsutil.Log("Unloading idle keys")
+	a.manager.UnloadIdleWithAlarms(ctx, js.Global().Get("chrome").Get("alarms"), idleUnloadAlarm)
+	a.unloadIdle(ctx)
+
 	jsutil.LogDebug("Attaching event handlers")
 	cleanup.Add(jsutil.DefineAsyncFunc(js.Global(), "handleOnMessage", a.onMessage))
 	cleanup.Add(jsutil.DefineAsyncFunc(js.Global(), "handleConnectionMessage", a.onConnectionMessage))
 	cleanup.Add(jsutil.DefineAsyncFunc(js.Global(), "handleConnectionDisconnect", a.onConnectionDisconnect))
+	cleanup.Add(jsutil.DefineAsyncFunc(js.Global(), "handleAlarm", a.onAlarm))
+	cleanup.Add(jsutil.DefineAsyncFunc(js.Global(), "handleCommand", a.commands.OnCommand))
+	cleanup.Add(jsutil.DefineAsyncFunc(js.Global(), "handleNotificationClick", a.onNotificationClick))
 	return nil
 }
 
+// loadAutoKeys loads keys that are configured to load automatically. The user
+// is notified of any keys that fail to load.
+func (a *background) loadAutoKeys(ctx jsutil.AsyncContext) {
+	results, err := a.manager.LoadAutoKeys(ctx)
+	if err != nil {
+		jsutil.LogError("failed to load keys automatically: %v", err)
+		a.notifier.KeyLoadFailed(ctx, "", "", err)
+		return
+	}
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		jsutil.LogError("failed to load key ID %s automatically: %v", r.ID, r.Err)
+		a.notifier.KeyLoadFailed(ctx, string(r.ID), a.keyName(ctx, r.ID), r.Err)
+	}
+}
+
 func (a *background) onMessage(ctx jsutil.AsyncContext, _ js.Value, args []js.Value) (js.Value, error) {
 	var message, sender, sendResponse js.Value
 	jsutil.ExpandArgs(args, &message, &sender, &sendResponse)
-	rsp := a.server.OnMessage(ctx, message, sender)
+	// Responses to confirmation requests are handled by the prompter,
+	// security key requests by the broker, requests for the number of
+	// connections by the ports, requests for metrics by the metrics, and
+	// requests for notification settings by the notifier; everything else
+	// is handled by the server.
+	rsp := a.prompter.OnMessage(ctx, message, sender)
+	if rsp.IsUndefined() {
+		rsp = a.broker.OnMessage(ctx, message, sender)
+	}
+	if rsp.IsUndefined() {
+		rsp = a.ports.OnMessage(ctx, message, sender)
+	}
+	if rsp.IsUndefined() {
+		rsp = a.metrics.OnMessage(ctx, message, sender)
+	}
+	if rsp.IsUndefined() {
+		rsp = a.notifier.OnMessage(ctx, message, sender)
+	}
+	if rsp.IsUndefined() {
+		rsp = a.server.OnMessage(ctx, message, sender)
+	}
 	sendResponse.Invoke(rsp)
 	return js.Undefined(), nil
 }
@@ -85,7 +312,16 @@ func (a *background) addPort(port js.Value) *agentport.AgentPort {
 	go func() {
 		jsutil.LogDebug("ServeAgent: starting for new port")
 		defer jsutil.LogDebug("ServeAgent: finished")
-		if err := agent.ServeAgent(a.agent, ap); err != nil {
+		// Each connection is served by its own agent, such that
+		// destination constraints apply to the host to which the
+		// client is connected, and requests are attributed to the
+		// client.
+		conn := &keys.Connection{
+			ExtensionID: agentport.SenderID(port),
+			Name:        agentport.PortName(port),
+			Connected:   time.Now(),
+		}
+		if err := ap.Serve(a.manager.ConnectionAgent(conn)); err != nil {
 			jsutil.LogDebug("ServeAgent: finished with error: %v", err)
 		}
 	}()
@@ -105,6 +341,16 @@ func (a *background) onConnectionMessage(_ jsutil.AsyncContext, _ js.Value, args
 		// executed (in our model, anyways) and we don't have any
 		// guarantee that it will happen prior to receiving the first
 		// message.
+		//
+		// Extensions may only connect if they are in the allowlist,
+		// which is checked before any message is processed. Other
+		// clients, such as web pages, may only connect if they are
+		// listed in the manifest.
+		if id := agentport.SenderID(port); id != "" && !a.manager.ClientAllowed(id) {
+			jsutil.LogError("onConnectionMessage: refusing connection from extension %s, which is not permitted to connect", id)
+			port.Call("disconnect")
+			return js.Undefined(), nil
+		}
 		jsutil.LogDebug("onConnectionMessage: existing connection not found; spawning")
 		ap = a.addPort(port)
 	}
@@ -130,6 +376,191 @@ func (a *background) onConnectionDisconnect(_ jsutil.AsyncContext, _ js.Value, a
 	return js.Undefined(), nil
 }
 
+// disconnectRefused closes the connections from extensions that are not among
+// clients, those permitted to connect.
+func (a *background) disconnectRefused(_ jsutil.AsyncContext, clients []string) {
+	a.ports.DisconnectSenders(func(id string) bool { return slices.Contains(clients, id) })
+}
+
+// connectNativeHosts connects to the native messaging hosts that are not yet
+// connected, including those that have since disconnected, and disconnects
+// from those that are no longer permitted.
+func (a *background) connectNativeHosts(_ jsutil.AsyncContext, hosts []string) {
+	for name, h := range a.nativeHosts {
+		if !slices.Contains(hosts, name) {
+			jsutil.Log("Disconnecting from native messaging host %s", name)
+			h.Close()
+			delete(a.nativeHosts, name)
+		}
+	}
+	for _, name := range hosts {
+		if h := a.nativeHosts[name]; h != nil && h.Connected() {
+			continue
+		}
+		jsutil.Log("Connecting to native messaging host %s", name)
+		port := agentport.ConnectNative(js.Global().Get("chrome").Get("runtime"), name)
+		a.nativeHosts[name] = agentport.NewNativeHost(port, name, a.ports, a.addPort)
+	}
+}
+
+// keyName returns the name of the configured key, or its ID if the name cannot
+// be determined.
+func (a *background) keyName(ctx jsutil.AsyncContext, id keys.ID) string {
+	configured, err := a.manager.Configured(ctx)
+	if err != nil {
+		jsutil.LogError("failed to read configured keys: %v", err)
+		return string(id)
+	}
+	for _, k := range configured {
+		if keys.ID(k.ID) == id && k.Name != "" {
+			return k.Name
+		}
+	}
+	return string(id)
+}
+
+// onRefused notifies the user that a request to sign was refused by a
+// constraint on the key. It is invoked while the agent is handling the
+// request, so must not block.
+func (a *background) onRefused(e *keys.AuditEntry) {
+	jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+		a.notifier.SignatureDenied(ctx, e.ID, a.keyName(ctx, keys.ID(e.ID)), e.Refused)
+		return js.Undefined(), nil
+	})
+}
+
+func (a *background) onNotificationClick(_ jsutil.AsyncContext, _ js.Value, args []js.Value) (js.Value, error) {
+	a.notifier.OnClicked(jsutil.SingleArg(args).String())
+	return js.Undefined(), nil
+}
+
+// unloadIdle unloads keys that have become idle. Open options pages are
+// notified of the keys that were unloaded using OnChange.
+func (a *background) unloadIdle(ctx jsutil.AsyncContext) {
+	ids, err := a.manager.UnloadIdle(ctx)
+	if err != nil {
+		jsutil.LogError("failed to unload idle keys: %v", err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+	jsutil.Log("Unloaded %d idle keys", len(ids))
+}
+
+func (a *background) onAlarm(ctx jsutil.AsyncContext, _ js.Value, args []js.Value) (js.Value, error) {
+	alarm := jsutil.SingleArg(args)
+	switch alarm.Get("name").String() {
+	case compactionAlarm:
+		a.compact(ctx)
+	case idleUnloadAlarm:
+		a.unloadIdle(ctx)
+	case idleConnectionAlarm:
+		if n := a.ports.DisconnectIdle(); n > 0 {
+			jsutil.Log("Disconnected %d idle connections", n)
+		}
+		// Hosts that have exited are reconnected.
+		if hosts, err := a.manager.NativeHosts(ctx); err != nil {
+			jsutil.LogError("onAlarm: failed to read native messaging hosts: %v", err)
+		} else {
+			a.connectNativeHosts(ctx, hosts)
+		}
+	case expiryAlarm:
+		a.warnExpiring(ctx)
+	case metricsAlarm:
+		if err := a.metrics.Flush(ctx); err != nil {
+			jsutil.LogError("onAlarm: failed to write metrics: %v", err)
+		}
+	}
+	return js.Undefined(), nil
+}
+
+// openLockPage opens a popup window in which the user may lock or unlock the
+// agent.
+func (a *background) openLockPage(ctx jsutil.AsyncContext) {
+	chrome := js.Global().Get("chrome")
+	opts := js.ValueOf(map[string]interface{}{
+		"url":     chrome.Get("runtime").Call("getURL", lockPage).String(),
+		"type":    "popup",
+		"width":   400,
+		"height":  200,
+		"focused": true,
+	})
+	if _, err := jsutil.AsPromise(chrome.Get("windows").Call("create", opts)).Await(ctx); err != nil {
+		jsutil.LogError("failed to open lock page: %v", err)
+	}
+}
+
+// compact compacts the storage areas that are periodically compacted.
+func (a *background) compact(ctx jsutil.AsyncContext) {
+	defer a.keepAlive.Acquire()()
+	for _, b := range a.compactable {
+		saved, err := b.Compact(ctx)
+		if err != nil {
+			jsutil.LogError("onAlarm: failed to compact storage: %v", err)
+			continue
+		}
+		jsutil.Log("onAlarm: compacted storage; saved %d bytes", saved)
+	}
+	a.checkQuota(ctx)
+}
+
+// checkQuota warns the user if the sync storage area is nearly full, such that
+// keys may soon fail to be saved.
+func (a *background) checkQuota(ctx jsutil.AsyncContext) {
+	area := js.Global().Get("chrome").Get("storage").Get("sync")
+	quota := storage.ReadLimits(area).QuotaBytes
+	if quota == 0 {
+		return
+	}
+	raw := storage.NewRaw(area)
+	stored, err := raw.Keys(ctx)
+	if err != nil {
+		jsutil.LogError("onAlarm: failed to read storage: %v", err)
+		return
+	}
+	used, err := raw.BytesInUse(ctx, stored)
+	if err != nil {
+		jsutil.LogError("onAlarm: failed to read storage usage: %v", err)
+		return
+	}
+	jsutil.Log("onAlarm: sync storage usage is %d of %d bytes", used, quota)
+	if used*100 >= quota*quotaWarningPercent {
+		a.notifier.QuotaWarning(ctx, "sync", used, quota)
+	}
+}
+
+// schedulePeriodic schedules a periodic alarm with the specified name, unless
+// it is already scheduled.
+func schedulePeriodic(ctx jsutil.AsyncContext, alarms js.Value, name string, period time.Duration) error {
+	existing, err := jsutil.AsPromise(alarms.Call("get", name)).Await(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read alarm: %w", err)
+	}
+	if existing.Type() == js.TypeObject {
+		return nil // Already scheduled.
+	}
+	info := jsutil.NewObject()
+	info.Set("periodInMinutes", period.Minutes())
+	if _, err := jsutil.AsPromise(alarms.Call("create", name, info)).Await(ctx); err != nil {
+		return fmt.Errorf("failed to create alarm: %w", err)
+	}
+	return nil
+}
+
+// warnExpiring notifies the user of keys that have expired or will expire
+// soon.
+func (a *background) warnExpiring(ctx jsutil.AsyncContext) {
+	expiring, err := a.manager.ExpiringKeys(ctx, expiryWarning)
+	if err != nil {
+		jsutil.LogError("onAlarm: failed to check for expiring keys: %v", err)
+		return
+	}
+	for _, k := range expiring {
+		a.notifier.CertificateExpiring(ctx, k.ID, k.Name, time.UnixMilli(k.ExpiresAtMillis), k.Expired)
+	}
+}
+
 func main() {
 	a := app.New(newBackground())
 	defer a.Release()
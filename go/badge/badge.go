@@ -0,0 +1,126 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package badge displays the state of the agent on the extension's toolbar
+// icon.
+package badge
+
+import (
+	"fmt"
+	"strconv"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/keys"
+)
+
+const (
+	// loadedColor is the background color of the badge while keys are
+	// loaded.
+	loadedColor = "#1a73e8"
+	// lockedColor is the background color of the badge while the agent is
+	// locked.
+	lockedColor = "#d93025"
+	// lockedText is the text of the badge while the agent is locked.
+	lockedText = "🔒"
+	// titlePrefix precedes the description of the state in the title of
+	// the toolbar icon.
+	titlePrefix = "SSH Agent"
+)
+
+// State is the state of the agent displayed by the badge.
+type State struct {
+	// Loaded is the number of keys loaded into the agent.
+	Loaded int
+	// Locked indicates that the agent is locked.
+	Locked bool
+}
+
+// text returns the text of the badge, and the description of the state used
+// as the title.
+func (s State) text() (text, description string) {
+	switch {
+	case s.Locked:
+		return lockedText, "locked"
+	case s.Loaded == 0:
+		return "", "no keys loaded"
+	case s.Loaded == 1:
+		return "1", "1 key loaded"
+	default:
+		return strconv.Itoa(s.Loaded), fmt.Sprintf("%d keys loaded", s.Loaded)
+	}
+}
+
+// Badge displays the state of the agent using chrome.action.
+type Badge struct {
+	action js.Value
+}
+
+// New returns a Badge that displays the state using action, which is
+// typically chrome.action.
+func New(action js.Value) *Badge {
+	return &Badge{action: action}
+}
+
+// Set displays the state: the number of loaded keys, a distinct badge if the
+// agent is locked, or no badge if no keys are loaded.
+func (b *Badge) Set(s State) {
+	text, description := s.text()
+	color := loadedColor
+	if s.Locked {
+		color = lockedColor
+	}
+	jsutil.LogDebug("Badge.Set: %s", description)
+	b.action.Call("setBadgeText", map[string]interface{}{"text": text})
+	b.action.Call("setBadgeBackgroundColor", map[string]interface{}{"color": color})
+	b.action.Call("setTitle", map[string]interface{}{"title": fmt.Sprintf("%s: %s", titlePrefix, description)})
+}
+
+// Update queries the manager for the state of the agent, and displays it.
+func (b *Badge) Update(ctx jsutil.AsyncContext, mgr keys.Manager) error {
+	loaded, err := mgr.Loaded(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get loaded keys: %w", err)
+	}
+	locked, err := mgr.Locked(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get lock state: %w", err)
+	}
+	b.Set(State{Loaded: len(loaded), Locked: locked})
+	return nil
+}
+
+// Watch displays the state of the agent, and updates it whenever keys are
+// loaded or unloaded, or the agent is locked or unlocked. The state may have
+// changed while the service worker was not running, so Watch should be invoked
+// whenever it starts.
+//
+// The returned cleanup function must be invoked to stop updating the badge.
+func (b *Badge) Watch(ctx jsutil.AsyncContext, mgr *keys.DefaultManager) (jsutil.CleanupFunc, error) {
+	if err := b.Update(ctx, mgr); err != nil {
+		return nil, err
+	}
+	return mgr.OnChange(ctx, func(ctx jsutil.AsyncContext, e keys.ChangeEvent) {
+		switch e.Kind {
+		case keys.KeyLoaded, keys.KeyUnloaded, keys.AgentLocked, keys.AgentUnlocked:
+		default:
+			return
+		}
+		if err := b.Update(ctx, mgr); err != nil {
+			jsutil.LogError("failed to update badge: %v", err)
+		}
+	})
+}
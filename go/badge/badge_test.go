@@ -0,0 +1,147 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package badge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	mfakes "github.com/google/chrome-ssh-agent/go/message/fakes"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// displayed is the badge displayed on the toolbar icon.
+type displayed struct {
+	text, color, title string
+}
+
+func display(a *mfakes.Action) displayed {
+	return displayed{text: a.BadgeText(), color: a.BadgeBackgroundColor(), title: a.Title()}
+}
+
+func TestSet(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		state       State
+		want        displayed
+	}{
+		{
+			description: "no keys",
+			state:       State{},
+			want:        displayed{text: "", color: loadedColor, title: "SSH Agent: no keys loaded"},
+		},
+		{
+			description: "single key",
+			state:       State{Loaded: 1},
+			want:        displayed{text: "1", color: loadedColor, title: "SSH Agent: 1 key loaded"},
+		},
+		{
+			description: "several keys",
+			state:       State{Loaded: 12},
+			want:        displayed{text: "12", color: loadedColor, title: "SSH Agent: 12 keys loaded"},
+		},
+		{
+			description: "locked",
+			state:       State{Loaded: 2, Locked: true},
+			want:        displayed{text: lockedText, color: lockedColor, title: "SSH Agent: locked"},
+		},
+		{
+			description: "locked without keys",
+			state:       State{Locked: true},
+			want:        displayed{text: lockedText, color: lockedColor, title: "SSH Agent: locked"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.description, func(t *testing.T) {
+			action := mfakes.NewAction()
+			New(action.Value()).Set(tc.state)
+			if got := display(action); got != tc.want {
+				t.Errorf("incorrect badge; got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr := keys.NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		if err := mgr.Add(ctx, "key", testdata.WithoutPassphrase.Private); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		configured, err := mgr.Configured(ctx)
+		if err != nil || len(configured) != 1 {
+			t.Fatalf("Configured failed: %v", err)
+		}
+		id := keys.ID(configured[0].ID)
+		if err := mgr.Load(ctx, id, ""); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+
+		// expect waits for the badge to display the text.
+		action := mfakes.NewAction()
+		expect := func(description, want string) {
+			t.Helper()
+			deadline := time.Now().Add(5 * time.Second)
+			for action.BadgeText() != want && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if got := action.BadgeText(); got != want {
+				t.Errorf("%s: incorrect badge text; got %q, want %q", description, got, want)
+			}
+		}
+
+		// The state is recomputed when the service worker restarts.
+		restarted := keys.NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		if err := restarted.LoadFromSession(ctx); err != nil {
+			t.Fatalf("LoadFromSession failed: %v", err)
+		}
+		stop, err := New(action.Value()).Watch(ctx, restarted)
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		defer stop()
+		expect("restarted", "1")
+
+		if err := restarted.Lock(ctx, "lock-passphrase"); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		expect("locked", lockedText)
+
+		if err := restarted.Unlock(ctx, "lock-passphrase"); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+		expect("unlocked", "1")
+
+		if err := restarted.Unload(ctx, id); err != nil {
+			t.Fatalf("Unload failed: %v", err)
+		}
+		expect("unloaded", "")
+	})
+}
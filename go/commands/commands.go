@@ -0,0 +1,149 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package commands performs the keyboard commands declared by the extension,
+// and describes the shortcuts assigned to them.
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/keys"
+)
+
+// Names of the commands. Keep in sync with manifest.json.
+const (
+	// ToggleLock locks or unlocks the agent using a passphrase.
+	ToggleLock = "toggle-lock"
+	// LockAgent immediately removes all secrets held for the session; see
+	// keys.DefaultManager.PurgeSecrets.
+	LockAgent = "lock-agent"
+	// UnloadAll unloads all keys.
+	UnloadAll = "unload-all"
+	// OpenOptions opens the options page.
+	OpenOptions = "open-options"
+)
+
+var (
+	errUnknownCommand = errors.New("unknown command")
+)
+
+// Func performs a command.
+type Func func(ctx jsutil.AsyncContext) error
+
+// OpenOptionsPage returns a Func that opens the options page using runtime,
+// which is typically chrome.runtime.
+func OpenOptionsPage(runtime js.Value) Func {
+	return func(ctx jsutil.AsyncContext) error {
+		_, err := jsutil.AsPromise(runtime.Call("openOptionsPage")).Await(ctx)
+		return err
+	}
+}
+
+// Handler dispatches commands to the functions that perform them.
+type Handler struct {
+	funcs map[string]Func
+}
+
+// New returns a Handler that performs commands affecting keys using mgr.
+// openOptions is invoked to open the options page. Commands that require
+// other state may be added using Register.
+func New(mgr *keys.DefaultManager, openOptions Func) *Handler {
+	h := &Handler{funcs: map[string]Func{}}
+	h.Register(LockAgent, mgr.PurgeSecrets)
+	h.Register(UnloadAll, func(ctx jsutil.AsyncContext) error {
+		unloaded, err := mgr.UnloadAll(ctx)
+		if err != nil {
+			return err
+		}
+		jsutil.Log("Unloaded %d keys", len(unloaded))
+		return nil
+	})
+	h.Register(OpenOptions, openOptions)
+	return h
+}
+
+// Register sets the function that performs the named command, replacing any
+// that was previously registered.
+func (h *Handler) Register(name string, f Func) {
+	h.funcs[name] = f
+}
+
+// Run performs the named command.
+func (h *Handler) Run(ctx jsutil.AsyncContext, name string) error {
+	f, ok := h.funcs[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", errUnknownCommand, name)
+	}
+	jsutil.LogDebug("Handler.Run: %s", name)
+	return f(ctx)
+}
+
+// OnCommand is the callback invoked by chrome.commands.onCommand. The service
+// worker may have been started to handle the command, so it must only be
+// invoked once the manager has restored keys from the session; otherwise, the
+// command would act on an empty agent.
+func (h *Handler) OnCommand(ctx jsutil.AsyncContext, _ js.Value, args []js.Value) (js.Value, error) {
+	name := jsutil.SingleArg(args).String()
+	if err := h.Run(ctx, name); err != nil {
+		jsutil.LogError("failed to perform command %s: %v", name, err)
+	}
+	return js.Undefined(), nil
+}
+
+// Command is a command declared by the extension.
+type Command struct {
+	// Name is the name of the command.
+	Name string
+	// Description describes the command to the user.
+	Description string
+	// Shortcut is the keyboard shortcut assigned to the command, or empty
+	// if none is assigned.
+	Shortcut string
+}
+
+// stringProp returns the named string property of v, or an empty string if
+// it is not set.
+func stringProp(v js.Value, name string) string {
+	p := v.Get(name)
+	if p.Type() != js.TypeString {
+		return ""
+	}
+	return p.String()
+}
+
+// List returns the commands declared by the extension using commands, which is
+// typically chrome.commands. The user may change the shortcuts, so they are
+// read each time.
+func List(ctx jsutil.AsyncContext, commands js.Value) ([]*Command, error) {
+	val, err := jsutil.AsPromise(commands.Call("getAll")).Await(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commands: %w", err)
+	}
+	var result []*Command
+	for i := 0; i < val.Length(); i++ {
+		c := val.Index(i)
+		result = append(result, &Command{
+			Name:        stringProp(c, "name"),
+			Description: stringProp(c, "description"),
+			Shortcut:    stringProp(c, "shortcut"),
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,220 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"errors"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	mfakes "github.com/google/chrome-ssh-agent/go/message/fakes"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// restart returns a manager using the storage, as if the service worker had
+// been started to handle a command.
+func restart(ctx jsutil.AsyncContext, t *testing.T, syncStorage, sessionStorage storage.Area) *keys.DefaultManager {
+	t.Helper()
+	mgr := keys.NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+	if err := mgr.LoadFromSession(ctx); err != nil {
+		t.Fatalf("LoadFromSession failed: %v", err)
+	}
+	return mgr
+}
+
+// loadKey adds and loads a key.
+func loadKey(ctx jsutil.AsyncContext, t *testing.T, mgr *keys.DefaultManager, name, pemPrivateKey string) keys.ID {
+	t.Helper()
+	if err := mgr.Add(ctx, name, pemPrivateKey); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	configured, err := mgr.Configured(ctx)
+	if err != nil {
+		t.Fatalf("Configured failed: %v", err)
+	}
+	for _, k := range configured {
+		if k.Name != name {
+			continue
+		}
+		id := keys.ID(k.ID)
+		if err := mgr.Load(ctx, id, ""); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		return id
+	}
+	t.Fatalf("failed to find key %s", name)
+	return keys.InvalidID
+}
+
+// expectLoaded checks the number of keys loaded by mgr.
+func expectLoaded(ctx jsutil.AsyncContext, t *testing.T, description string, mgr *keys.DefaultManager, want int) {
+	t.Helper()
+	loaded, err := mgr.Loaded(ctx)
+	if err != nil {
+		t.Fatalf("%s: Loaded failed: %v", description, err)
+	}
+	if len(loaded) != want {
+		t.Errorf("%s: incorrect number of loaded keys; got %d, want %d", description, len(loaded), want)
+	}
+}
+
+func TestUnloadAll(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr := keys.NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		loadKey(ctx, t, mgr, "rsa", testdata.WithoutPassphrase.Private)
+		loadKey(ctx, t, mgr, "ecdsa", testdata.ECDSAWithoutPassphrase.Private)
+
+		// The command is handled by a service worker that has just
+		// started, and restored the keys from the session.
+		restarted := restart(ctx, t, syncStorage, sessionStorage)
+		expectLoaded(ctx, t, "restarted", restarted, 2)
+		if err := New(restarted, nil).Run(ctx, UnloadAll); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		expectLoaded(ctx, t, "unloaded", restarted, 0)
+		expectLoaded(ctx, t, "restarted after unload", restart(ctx, t, syncStorage, sessionStorage), 0)
+	})
+}
+
+func TestLockAgent(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr := keys.NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		id := loadKey(ctx, t, mgr, "auto", testdata.WithoutPassphrase.Private)
+		if err := mgr.SetAutoLoad(ctx, id, true); err != nil {
+			t.Fatalf("SetAutoLoad failed: %v", err)
+		}
+		if _, err := mgr.EnableMasterKey(ctx, "master", nil); err != nil {
+			t.Fatalf("EnableMasterKey failed: %v", err)
+		}
+
+		restarted := restart(ctx, t, syncStorage, sessionStorage)
+		expectLoaded(ctx, t, "restarted", restarted, 1)
+		if err := New(restarted, nil).Run(ctx, LockAgent); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		expectLoaded(ctx, t, "locked", restarted, 0)
+		if state, err := restarted.MasterKeyState(ctx); err != nil || state != keys.MasterKeyLocked {
+			t.Errorf("incorrect master key state; got %s, %v, want %s", state, err, keys.MasterKeyLocked)
+		}
+
+		// The key is not loaded again when the service worker next
+		// starts.
+		again := restart(ctx, t, syncStorage, sessionStorage)
+		if _, err := again.LoadAutoKeys(ctx); err != nil {
+			t.Errorf("LoadAutoKeys failed: %v", err)
+		}
+		expectLoaded(ctx, t, "restarted after lock", again, 0)
+	})
+}
+
+func TestOpenOptions(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := keys.NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		opened := 0
+		h := New(mgr, func(ctx jsutil.AsyncContext) error {
+			opened++
+			return nil
+		})
+		if err := h.Run(ctx, OpenOptions); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if opened != 1 {
+			t.Errorf("options page opened %d times; want 1", opened)
+		}
+	})
+}
+
+func TestRegister(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := keys.NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		h := New(mgr, nil)
+
+		if err := h.Run(ctx, ToggleLock); !errors.Is(err, errUnknownCommand) {
+			t.Errorf("Run returned incorrect error for unregistered command: %v", err)
+		}
+
+		toggled := 0
+		h.Register(ToggleLock, func(ctx jsutil.AsyncContext) error {
+			toggled++
+			return nil
+		})
+		if err := h.Run(ctx, ToggleLock); err != nil {
+			t.Errorf("Run failed: %v", err)
+		}
+		if toggled != 1 {
+			t.Errorf("command performed %d times; want 1", toggled)
+		}
+
+		// Unknown commands are logged by OnCommand, rather than failing.
+		if _, err := h.OnCommand(ctx, js.Undefined(), []js.Value{js.ValueOf("unknown")}); err != nil {
+			t.Errorf("OnCommand failed: %v", err)
+		}
+	})
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		fake := mfakes.NewCommands(
+			mfakes.Command{Name: LockAgent, Description: "Lock the agent", Shortcut: "Ctrl+Shift+K"},
+			mfakes.Command{Name: OpenOptions, Description: "Open options"},
+		)
+
+		got, err := List(ctx, fake.Value())
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		want := []*Command{
+			{Name: LockAgent, Description: "Lock the agent", Shortcut: "Ctrl+Shift+K"},
+			{Name: OpenOptions, Description: "Open options"},
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect commands; -got +want: %s", diff)
+		}
+
+		// Shortcuts changed by the user are reflected.
+		fake.SetShortcut(OpenOptions, "Alt+O")
+		got, err = List(ctx, fake.Value())
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		want[1].Shortcut = "Alt+O"
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect commands after change; -got +want: %s", diff)
+		}
+	})
+}
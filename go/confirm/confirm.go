@@ -0,0 +1,251 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package confirm asks the user to approve use of keys.
+//
+// The extension's service worker cannot display UI itself. Instead, a Prompter
+// running in the service worker opens an extension page for each request, and
+// the page returns the user's decision using Respond.
+package confirm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/message"
+	"github.com/norunners/vert"
+)
+
+const (
+	// RequestParam is the query string parameter containing the request
+	// ID in the URL of the page opened by OpenWindow.
+	RequestParam = "confirm"
+	// NameParam is the query string parameter containing the name of the
+	// key in the URL of the page opened by OpenWindow.
+	NameParam = "name"
+	// ClientParam is the query string parameter containing the
+	// description of the client in the URL of the page opened by
+	// OpenWindow.
+	ClientParam = "client"
+	// HostParam is the query string parameter containing the description
+	// of the host in the URL of the page opened by OpenWindow.
+	HostParam = "host"
+)
+
+// Request is a request for the user to confirm use of a key.
+type Request struct {
+	// ID uniquely identifies the request. It must be supplied to Respond.
+	ID string
+	// KeyName is the name of the key to be used.
+	KeyName string
+	// Client describes the client that requested the signature, or is
+	// empty if it is not known.
+	Client string
+	// Host describes the host to which the client is connected, or is
+	// empty if it is not known.
+	Host string
+}
+
+// CloseFunc closes the page opened for a request.
+type CloseFunc func(ctx jsutil.AsyncContext)
+
+// OpenFunc opens a page that displays the request to the user.
+type OpenFunc func(ctx jsutil.AsyncContext, req *Request) (CloseFunc, error)
+
+// Prompter asks the user to approve use of keys, by opening a page for each
+// request and waiting for it to respond.
+type Prompter struct {
+	open    OpenFunc
+	timeout time.Duration
+	pending map[string]chan bool
+}
+
+// NewPrompter returns a Prompter that opens pages using open. Requests are
+// denied if the page does not respond within the timeout.
+func NewPrompter(open OpenFunc, timeout time.Duration) *Prompter {
+	return &Prompter{
+		open:    open,
+		timeout: timeout,
+		pending: map[string]chan bool{},
+	}
+}
+
+// Define a distinct type for each message. These are embedded in each
+// message, and are distinct from those used by keys.Server.
+const (
+	msgTypeRespond int = 2000 + iota
+	msgTypeRespondRsp
+)
+
+type msgRespond struct {
+	Type     int    `js:"type"`
+	Request  string `js:"request"`
+	Approved bool   `js:"approved"`
+}
+
+type rspRespond struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+// newRequestID returns a new random request ID.
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Confirm implements keys.ConfirmFunc. It must not be invoked from the main
+// thread, since it blocks until the user responds.
+func (p *Prompter) Confirm(req *keys.ConfirmRequest) bool {
+	done := make(chan bool, 1)
+	jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+		done <- p.prompt(ctx, req)
+		return js.Undefined(), nil
+	})
+	return <-done
+}
+
+// prompt opens a page for the request, and waits for the response.
+func (p *Prompter) prompt(ctx jsutil.AsyncContext, confirmReq *keys.ConfirmRequest) bool {
+	reqID, err := newRequestID()
+	if err != nil {
+		jsutil.LogError("failed to generate request ID: %v", err)
+		return false
+	}
+	req := &Request{
+		ID:      reqID,
+		KeyName: confirmReq.Name,
+		Client:  confirmReq.Client,
+		Host:    confirmReq.Host,
+	}
+
+	rsp := make(chan bool, 1)
+	p.pending[req.ID] = rsp
+	defer delete(p.pending, req.ID)
+
+	jsutil.LogDebug("Prompter.prompt: opening page for request %s", req.ID)
+	closePage, err := p.open(ctx, req)
+	if err != nil {
+		jsutil.LogError("failed to open confirmation page: %v", err)
+		return false
+	}
+	defer closePage(ctx)
+
+	select {
+	case approved := <-rsp:
+		return approved
+	case <-time.After(p.timeout):
+		jsutil.LogError("confirmation request %s timed out after %s", req.ID, p.timeout)
+		return false
+	}
+}
+
+// OnMessage is the callback invoked when a message is received. Responses
+// sent using Respond are delivered to the pending request. Other messages are
+// ignored, in which case undefined is returned such that they may be handled
+// elsewhere.
+func (p *Prompter) OnMessage(_ jsutil.AsyncContext, headerObj js.Value, _ js.Value) js.Value {
+	var m msgRespond
+	if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil || m.Type != msgTypeRespond {
+		return js.Undefined()
+	}
+
+	jsutil.LogDebug("Prompter.OnMessage(Respond req): request=%s, approved=%t", m.Request, m.Approved)
+	rsp := rspRespond{Type: msgTypeRespondRsp}
+	if ch, ok := p.pending[m.Request]; ok {
+		select {
+		case ch <- m.Approved:
+		default: // Already responded.
+		}
+	} else {
+		rsp.Err = fmt.Sprintf("no pending request %s", m.Request)
+	}
+	return vert.ValueOf(rsp).JSValue()
+}
+
+// Respond sends the user's response to the request with the specified ID.
+func Respond(ctx jsutil.AsyncContext, msg message.Sender, reqID string, approved bool) error {
+	m := msgRespond{
+		Type:     msgTypeRespond,
+		Request:  reqID,
+		Approved: approved,
+	}
+	jsutil.LogDebug("Respond(req): request=%s, approved=%t", reqID, approved)
+	rspObj, err := msg.Send(ctx, vert.ValueOf(m).JSValue())
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspRespond
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if rsp.Err != "" {
+		return fmt.Errorf("failed to respond: %s", rsp.Err)
+	}
+	return nil
+}
+
+// windowOptions are the options for a window. See
+// https://developer.chrome.com/docs/extensions/reference/api/windows#method-create
+type windowOptions struct {
+	URL     string `js:"url"`
+	Type    string `js:"type"`
+	Width   int    `js:"width"`
+	Height  int    `js:"height"`
+	Focused bool   `js:"focused"`
+}
+
+// OpenWindow returns an OpenFunc that opens the page at the specified URL in a
+// popup window using the chrome.windows API. The request ID, key name, client
+// and host are supplied in the RequestParam, NameParam, ClientParam and
+// HostParam query string parameters.
+func OpenWindow(windows js.Value, pageURL string) OpenFunc {
+	return func(ctx jsutil.AsyncContext, req *Request) (CloseFunc, error) {
+		qs := url.Values{}
+		qs.Set(RequestParam, req.ID)
+		qs.Set(NameParam, req.KeyName)
+		qs.Set(ClientParam, req.Client)
+		qs.Set(HostParam, req.Host)
+		opts := &windowOptions{
+			URL:     pageURL + "?" + qs.Encode(),
+			Type:    "popup",
+			Width:   400,
+			Height:  200,
+			Focused: true,
+		}
+		win, err := jsutil.AsPromise(windows.Call("create", vert.ValueOf(opts).JSValue())).Await(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create window: %w", err)
+		}
+		winID := win.Get("id")
+		return func(ctx jsutil.AsyncContext) {
+			// The page closes itself once it responds, in which case
+			// removing the window fails.
+			if _, err := jsutil.AsPromise(windows.Call("remove", winID)).Await(ctx); err != nil {
+				jsutil.LogDebug("failed to remove window: %v", err)
+			}
+		}, nil
+	}
+}
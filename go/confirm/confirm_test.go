@@ -0,0 +1,132 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package confirm
+
+import (
+	"errors"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	mfakes "github.com/google/chrome-ssh-agent/go/message/fakes"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConfirm(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		respond     func(ctx jsutil.AsyncContext, hub *mfakes.Hub, req *Request) error
+		openErr     error
+		want        bool
+		wantClosed  bool
+	}{
+		{
+			description: "approved",
+			respond: func(ctx jsutil.AsyncContext, hub *mfakes.Hub, req *Request) error {
+				return Respond(ctx, hub, req.ID, true)
+			},
+			want:       true,
+			wantClosed: true,
+		},
+		{
+			description: "denied",
+			respond: func(ctx jsutil.AsyncContext, hub *mfakes.Hub, req *Request) error {
+				return Respond(ctx, hub, req.ID, false)
+			},
+			want:       false,
+			wantClosed: true,
+		},
+		{
+			description: "response for unknown request",
+			respond: func(ctx jsutil.AsyncContext, hub *mfakes.Hub, req *Request) error {
+				if err := Respond(ctx, hub, "bogus-request", true); err == nil {
+					return errors.New("response for unknown request accepted")
+				}
+				return nil
+			},
+			want:       false,
+			wantClosed: true,
+		},
+		{
+			description: "timeout",
+			want:        false,
+			wantClosed:  true,
+		},
+		{
+			description: "failure to open page",
+			openErr:     errors.New("failed"),
+			want:        false,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				hub := mfakes.NewHub()
+				var gotReq Request
+				closed := false
+				open := func(ctx jsutil.AsyncContext, req *Request) (CloseFunc, error) {
+					gotReq = *req
+					if tc.openErr != nil {
+						return nil, tc.openErr
+					}
+					if tc.respond != nil {
+						jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+							if err := tc.respond(ctx, hub, req); err != nil {
+								t.Errorf("failed to respond: %v", err)
+							}
+							return js.Undefined(), nil
+						})
+					}
+					return func(jsutil.AsyncContext) { closed = true }, nil
+				}
+				p := NewPrompter(open, 100*time.Millisecond)
+				hub.AddReceiver(p)
+
+				got := p.Confirm(&keys.ConfirmRequest{ID: keys.ID("id-0"), Name: "key-name", Client: "client-name", Host: "host-name"})
+				if diff := cmp.Diff(got, tc.want); diff != "" {
+					t.Errorf("incorrect result; -got +want: %s", diff)
+				}
+				if diff := cmp.Diff(gotReq, Request{ID: gotReq.ID, KeyName: "key-name", Client: "client-name", Host: "host-name"}); diff != "" {
+					t.Errorf("incorrect request; -got +want: %s", diff)
+				}
+				if diff := cmp.Diff(closed, tc.wantClosed); diff != "" {
+					t.Errorf("incorrect closed state; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestOnMessageIgnoresOtherMessages(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		p := NewPrompter(nil, time.Second)
+		msg := jsutil.NewObject()
+		msg.Set("type", 1000)
+		if rsp := p.OnMessage(ctx, msg, js.Null()); !rsp.IsUndefined() {
+			t.Errorf("incorrect response; got %v, want undefined", rsp)
+		}
+	})
+}
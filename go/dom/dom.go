@@ -135,6 +135,20 @@ func OnClick(o js.Value, callback func(ctx jsutil.AsyncContext, evt Event)) jsut
 		})
 }
 
+// OnChange registers a callback to be invoked when the value of the specified
+// object is changed by the user.
+func OnChange(o js.Value, callback func(ctx jsutil.AsyncContext, evt Event)) jsutil.CleanupFunc {
+	return addEventListener(
+		o, "change",
+		func(this js.Value, args []js.Value) interface{} {
+			jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+				callback(ctx, Event{Value: jsutil.SingleArg(args)})
+				return js.Undefined(), nil
+			})
+			return nil
+		})
+}
+
 // OnSubmit registers a callback to be invoked when the specified form is
 // submitted.
 func OnSubmit(o js.Value, callback func(ctx jsutil.AsyncContext, evt Event)) jsutil.CleanupFunc {
@@ -170,6 +184,18 @@ func TextContent(o js.Value) string {
 	return o.Get("textContent").String()
 }
 
+// WriteClipboard writes text to the system clipboard.
+func WriteClipboard(ctx jsutil.AsyncContext, text string) error {
+	clipboard := js.Global().Get("navigator").Get("clipboard")
+	if clipboard.IsUndefined() {
+		return fmt.Errorf("clipboard not available")
+	}
+	if _, err := jsutil.AsPromise(clipboard.Call("writeText", text)).Await(ctx); err != nil {
+		return fmt.Errorf("failed to write clipboard: %w", err)
+	}
+	return nil
+}
+
 // AppendChild adds the child object.  If non-nil, the populate() function is
 // invoked on the child to initialize it.
 func AppendChild(parent, child js.Value, populate func(child js.Value)) {
@@ -44,3 +44,13 @@ func NewURLSearchParams(queryString string) *URLSearchParams {
 func (u *URLSearchParams) Has(param string) bool {
 	return u.o.Call("has", param).Bool()
 }
+
+// Get returns the value of the specified parameter, or the empty string if
+// the query string does not contain it.
+func (u *URLSearchParams) Get(param string) string {
+	v := u.o.Call("get", param)
+	if v.IsNull() {
+		return ""
+	}
+	return v.String()
+}
@@ -0,0 +1,127 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keepalive keeps the extension's service worker running during
+// long operations.
+package keepalive
+
+import (
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// DefaultInterval is the interval at which the service worker is kept alive.
+// Chrome stops a service worker that has not invoked an extension API for 30
+// seconds.  See:
+//
+//	https://developer.chrome.com/docs/extensions/develop/concepts/service-workers/lifecycle
+const DefaultInterval = 20 * time.Second
+
+// PingFunc is invoked periodically to keep the service worker alive.
+type PingFunc func()
+
+// KeepAlive keeps the service worker alive while it is held by at least one
+// operation. It may be held by several operations at once.
+type KeepAlive struct {
+	ping     PingFunc
+	interval time.Duration
+
+	mu sync.Mutex
+	// held is the number of operations holding the KeepAlive.
+	held int
+	// stop stops the periodic pings. It is non-nil only while held.
+	stop chan struct{}
+}
+
+// New returns a KeepAlive that invokes ping at the specified interval while it
+// is held.
+func New(ping PingFunc, interval time.Duration) *KeepAlive {
+	return &KeepAlive{
+		ping:     ping,
+		interval: interval,
+	}
+}
+
+// Default returns a KeepAlive that invokes a trivial extension API at
+// DefaultInterval, which resets the service worker's idle timer.
+func Default() *KeepAlive {
+	return New(func() {
+		runtime := js.Global().Get("chrome").Get("runtime")
+		// The result is irrelevant; the call itself keeps the service
+		// worker alive.
+		runtime.Call("getPlatformInfo")
+	}, DefaultInterval)
+}
+
+// Acquire holds the KeepAlive until the returned function is invoked. The
+// returned function may be invoked more than once; only the first invocation
+// releases it. Acquire may be invoked on a nil KeepAlive, in which case the
+// service worker is not kept alive.
+func (k *KeepAlive) Acquire() (release func()) {
+	if k == nil {
+		return func() {}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.held++
+	if k.held == 1 {
+		jsutil.LogDebug("KeepAlive.Acquire: keeping service worker alive")
+		k.stop = make(chan struct{})
+		go k.run(k.stop)
+	}
+
+	var once sync.Once
+	return func() { once.Do(k.release) }
+}
+
+// release releases a hold on the KeepAlive, and stops the pings once none
+// remain.
+func (k *KeepAlive) release() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.held--
+	if k.held == 0 {
+		jsutil.LogDebug("KeepAlive.release: no longer keeping service worker alive")
+		close(k.stop)
+		k.stop = nil
+	}
+}
+
+// run invokes ping at the interval until stop is closed.
+func (k *KeepAlive) run(stop chan struct{}) {
+	t := time.NewTicker(k.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			k.ping()
+		}
+	}
+}
+
+// Held returns the number of operations holding the KeepAlive. It allows tests
+// to verify that each acquisition is released.
+func (k *KeepAlive) Held() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.held
+}
@@ -0,0 +1,87 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keepalive
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepAlive(t *testing.T) {
+	t.Parallel()
+
+	pings := make(chan struct{}, 100)
+	k := New(func() { pings <- struct{}{} }, time.Millisecond)
+
+	// expectPings waits for pings if the KeepAlive is held, or ensures
+	// there are none otherwise.
+	expectPings := func(want bool) {
+		t.Helper()
+		for len(pings) > 0 {
+			<-pings
+		}
+		if want {
+			select {
+			case <-pings:
+			case <-time.After(5 * time.Second):
+				t.Errorf("no pings while held")
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		for len(pings) > 0 {
+			<-pings
+		}
+		time.Sleep(20 * time.Millisecond)
+		if n := len(pings); n != 0 {
+			t.Errorf("incorrect number of pings while not held; got %d, want 0", n)
+		}
+	}
+
+	expectPings(false)
+
+	release1 := k.Acquire()
+	release2 := k.Acquire()
+	if n := k.Held(); n != 2 {
+		t.Errorf("incorrect holds; got %d, want 2", n)
+	}
+	expectPings(true)
+
+	// Releasing more than once has no effect.
+	release1()
+	release1()
+	if n := k.Held(); n != 1 {
+		t.Errorf("incorrect holds after release; got %d, want 1", n)
+	}
+	expectPings(true)
+
+	release2()
+	if n := k.Held(); n != 0 {
+		t.Errorf("incorrect holds after release; got %d, want 0", n)
+	}
+	expectPings(false)
+
+	// It may be held again once released.
+	release := k.Acquire()
+	expectPings(true)
+	release()
+	expectPings(false)
+
+	// A nil KeepAlive may be acquired and released.
+	var none *KeepAlive
+	none.Acquire()()
+}
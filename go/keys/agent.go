@@ -0,0 +1,335 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var errUseDenied = errors.New("use of key not approved")
+
+// managedAgent wraps an agent, applying the Manager's policies for keys that
+// it loaded: use of the key to sign may require confirmation, SHA-1 signatures
+// may be refused, and use is tracked such that idle keys can be unloaded. The
+// number of loaded identities may be limited, and they may be listed with the
+// most recently used first; see sortLocked.
+//
+// The wrapped agent creates a signer for each key when it is added, and
+// retains it until the key is removed, such that the private key is not
+// parsed again for each signature; see BenchmarkSign.
+//
+// Some keys cannot be added to the wrapped agent, since their private keys are
+// not available; for example, keys held on security keys. Instead,
+// managedAgent holds them itself, and signs using the Signer for each.
+//
+// managedAgent implements the agent.ExtendedAgent interface.
+type managedAgent struct {
+	agent.Agent
+	// confirm is invoked before a key loaded by the Manager is used to
+	// sign, with the client on whose behalf it is used, if known. The key
+	// is not used unless it returns true.
+	confirm func(id ID, r *requester) bool
+	// allowSHA1 is invoked before a key loaded by the Manager is used to
+	// produce an RSA signature using SHA-1. The key is not used unless it
+	// returns true.
+	allowSHA1 func(id ID) bool
+	// onSign is invoked after a key loaded by the Manager is used to sign.
+	onSign func(id ID)
+	// rank is invoked to determine the position of a key loaded by the
+	// Manager in the identities list. It returns nil for other keys.
+	rank func(id ID) *keyRank
+
+	mu sync.Mutex
+	// held are the loaded keys that are held by managedAgent, rather than
+	// the wrapped agent.
+	held []*heldIdentity
+	// maxLoaded is the maximum number of loaded identities, or 0 if
+	// unlimited.
+	maxLoaded int
+	// order is the order in which identities are listed.
+	order KeyOrder
+	// lastUsed maps the blob of each identity used to sign to the value of
+	// useSeq at the time.
+	lastUsed map[string]uint64
+	useSeq   uint64
+	// ids maps the blob of each identity loaded by the Manager to its ID,
+	// such that the ID of a key used to sign is found without listing
+	// the loaded keys.
+	ids map[string]ID
+}
+
+// Signer signs using a private key. It is implemented for software keys by
+// the signers returned by ssh.NewSignerFromKey, and for keys whose private key
+// is not available by signers that delegate to whatever holds it.
+//
+// Sign may block until the operation completes; it must not be invoked from
+// the main thread.
+type Signer = ssh.Signer
+
+// heldIdentity is a loaded key held by managedAgent.
+type heldIdentity struct {
+	Signer  Signer
+	Comment string
+}
+
+// addHeld adds the key to the agent, replacing any existing identity with the
+// same public key.
+func (a *managedAgent) addHeld(key *heldIdentity) error {
+	if err := a.checkCapacity(key.Signer.PublicKey()); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.removeHeldLocked(key.Signer.PublicKey())
+	a.held = append(a.held, key)
+	a.recordIDLocked(key.Signer.PublicKey(), key.Comment)
+	return nil
+}
+
+// heldKey returns the loaded key held by managedAgent with the specified public
+// key, or nil if there is none.
+func (a *managedAgent) heldKey(key ssh.PublicKey) *heldIdentity {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	blob := key.Marshal()
+	for _, k := range a.held {
+		if bytes.Equal(k.Signer.PublicKey().Marshal(), blob) {
+			return k
+		}
+	}
+	return nil
+}
+
+// removeHeldLocked removes the key held by managedAgent with the specified
+// public key. It returns false if there is none. a.mu must be held.
+func (a *managedAgent) removeHeldLocked(key ssh.PublicKey) bool {
+	blob := key.Marshal()
+	for i, k := range a.held {
+		if bytes.Equal(k.Signer.PublicKey().Marshal(), blob) {
+			a.held = append(a.held[:i], a.held[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// renameHeld updates the comments of held keys loaded by the Manager whose IDs
+// are renamed according to renames.
+func (a *managedAgent) renameHeld(renames map[ID]ID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, k := range a.held {
+		k.Comment = renameComment(k.Comment, renames)
+		a.recordIDLocked(k.Signer.PublicKey(), k.Comment)
+	}
+}
+
+// recordIDLocked records the ID of the identity with the specified public key
+// and comment, if it was loaded by the Manager; see lookup. a.mu must be held.
+func (a *managedAgent) recordIDLocked(pub ssh.PublicKey, comment string) {
+	blob := string(pub.Marshal())
+	lk := LoadedKey{Comment: comment}
+	id := lk.ID()
+	if id == InvalidID {
+		delete(a.ids, blob)
+		return
+	}
+	if a.ids == nil {
+		a.ids = map[string]ID{}
+	}
+	a.ids[blob] = id
+}
+
+// List implements agent.Agent.List().
+func (a *managedAgent) List() ([]*agent.Key, error) {
+	keys, err := a.Agent.List()
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, k := range a.held {
+		pub := k.Signer.PublicKey()
+		keys = append(keys, &agent.Key{
+			Format:  pub.Type(),
+			Blob:    pub.Marshal(),
+			Comment: k.Comment,
+		})
+	}
+	a.sortLocked(keys)
+	return keys, nil
+}
+
+// Add implements agent.Agent.Add().
+func (a *managedAgent) Add(key agent.AddedKey) error {
+	var pub ssh.PublicKey
+	if key.Certificate != nil {
+		pub = key.Certificate
+	} else {
+		signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed to create signer: %w", err)
+		}
+		pub = signer.PublicKey()
+	}
+	if err := a.checkCapacity(pub); err != nil {
+		return err
+	}
+	// The wrapped agent is updated while holding a.mu, such that the
+	// recorded IDs remain consistent with it.
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.Agent.Add(key); err != nil {
+		return err
+	}
+	a.recordIDLocked(pub, key.Comment)
+	return nil
+}
+
+// Remove implements agent.Agent.Remove().
+func (a *managedAgent) Remove(key ssh.PublicKey) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.lastUsed, string(key.Marshal()))
+	delete(a.ids, string(key.Marshal()))
+	if a.removeHeldLocked(key) {
+		return nil
+	}
+	return a.Agent.Remove(key)
+}
+
+// RemoveAll implements agent.Agent.RemoveAll().
+func (a *managedAgent) RemoveAll() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.held = nil
+	a.lastUsed = nil
+	a.ids = nil
+	return a.Agent.RemoveAll()
+}
+
+// Sign implements agent.Agent.Sign().
+func (a *managedAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.sign(nil, key, data, 0, func() (*ssh.Signature, error) {
+		return a.Agent.Sign(key, data)
+	})
+}
+
+// SignWithFlags implements agent.ExtendedAgent.SignWithFlags(). For RSA keys,
+// the flags select the rsa-sha2-256 or rsa-sha2-512 signature algorithm in
+// place of ssh-rsa.
+func (a *managedAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	return a.signFor(nil, key, data, flags)
+}
+
+// signFor signs as with SignWithFlags on behalf of the requester, which is
+// nil if it is not known.
+func (a *managedAgent) signFor(r *requester, key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	flags = signatureFlags(key, flags)
+	ext, ok := a.Agent.(agent.ExtendedAgent)
+	if !ok {
+		if flags != 0 {
+			return nil, fmt.Errorf("signature flags not supported: %d", flags)
+		}
+		return a.sign(r, key, data, 0, func() (*ssh.Signature, error) {
+			return a.Agent.Sign(key, data)
+		})
+	}
+	return a.sign(r, key, data, flags, func() (*ssh.Signature, error) {
+		return ext.SignWithFlags(key, data, flags)
+	})
+}
+
+// Extension implements agent.ExtendedAgent.Extension().
+func (a *managedAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	ext, ok := a.Agent.(agent.ExtendedAgent)
+	if !ok {
+		return nil, agent.ErrExtensionUnsupported
+	}
+	return ext.Extension(extensionType, contents)
+}
+
+// sign applies policies for the key, and invokes f to sign using the
+// specified flags if they allow it. Keys held by managedAgent are instead
+// signed using their Signer, in which case flags are ignored; they only apply
+// to RSA keys, which are always held by the wrapped agent. The requester, if
+// known, is identified to the user when confirming use of the key.
+func (a *managedAgent) sign(r *requester, key ssh.PublicKey, data []byte, flags agent.SignatureFlags, f func() (*ssh.Signature, error)) (*ssh.Signature, error) {
+	id := a.lookup(key)
+	// Check before confirming, such that the user is not asked to
+	// approve a signature that would be refused.
+	if id != InvalidID && isRSA(key) && flags == 0 && !a.allowSHA1(id) {
+		return nil, fmt.Errorf("%w: key ID %s", errSHA1Refused, id)
+	}
+	if id != InvalidID && !a.confirm(id, r) {
+		return nil, fmt.Errorf("%w: key ID %s", errUseDenied, id)
+	}
+	var sig *ssh.Signature
+	var err error
+	if h := a.heldKey(key); h != nil {
+		sig, err = h.Signer.Sign(rand.Reader, data)
+	} else {
+		sig, err = f()
+	}
+	if err == nil {
+		a.recordSigned(key)
+	}
+	if err == nil && id != InvalidID {
+		a.onSign(id)
+	}
+	return sig, err
+}
+
+// lookup returns the ID of the loaded key, or InvalidID if it was not loaded
+// by the Manager. It is invoked for each signature, so the ID is found using
+// the IDs recorded as keys are added, rather than by listing the loaded keys.
+func (a *managedAgent) lookup(key ssh.PublicKey) ID {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if id, ok := a.ids[string(key.Marshal())]; ok {
+		return id
+	}
+	return InvalidID
+}
+
+// awaitMain invokes f on the main thread, where storage may be used, and waits
+// for it to complete. It must not be invoked from the main thread.
+func awaitMain(f func(ctx jsutil.AsyncContext) error) error {
+	done := make(chan error, 1)
+	jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+		done <- f(ctx)
+		return js.Undefined(), nil
+	})
+	return <-done
+}
+
+// Agent returns the agent into which keys are loaded. Requests should be
+// served using the returned agent, such that the Manager's policies apply to
+// the use of keys; for example, confirmation of use, unloading of idle keys,
+// and locking.
+func (m *DefaultManager) Agent() agent.ExtendedAgent {
+	return m.locker
+}
@@ -0,0 +1,180 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "key", PEMPrivateKey: testdata.ED25519WithoutPassphrase.Private, Load: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "key")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+		priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ED25519WithoutPassphrase.Private))
+		if err != nil {
+			t.Fatalf("failed to parse key: %v", err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatalf("failed to create signer: %v", err)
+		}
+		pub := signer.PublicKey()
+
+		// Keys loaded by the Manager are found by their public key.
+		if got := mgr.agent.lookup(pub); got != id {
+			t.Errorf("incorrect ID for loaded key; got %s, want %s", got, id)
+		}
+
+		// Replacing the key with one that was not loaded by the Manager
+		// forgets its ID.
+		if err := mgr.agent.Add(agent.AddedKey{PrivateKey: priv, Comment: "other"}); err != nil {
+			t.Fatalf("failed to add key: %v", err)
+		}
+		if got := mgr.agent.lookup(pub); got != InvalidID {
+			t.Errorf("incorrect ID for key not loaded by the Manager; got %s, want none", got)
+		}
+
+		// Keys held by managedAgent are found, including once renamed.
+		if err := mgr.agent.addHeld(&heldIdentity{Signer: signer, Comment: agentComment(id, "")}); err != nil {
+			t.Fatalf("failed to add held key: %v", err)
+		}
+		if got := mgr.agent.lookup(pub); got != id {
+			t.Errorf("incorrect ID for held key; got %s, want %s", got, id)
+		}
+		mgr.agent.renameHeld(map[ID]ID{id: "new-id"})
+		if got := mgr.agent.lookup(pub); got != "new-id" {
+			t.Errorf("incorrect ID for renamed key; got %s, want new-id", got)
+		}
+
+		// Removed keys are not found.
+		if err := mgr.agent.Remove(pub); err != nil {
+			t.Fatalf("failed to remove key: %v", err)
+		}
+		if got := mgr.agent.lookup(pub); got != InvalidID {
+			t.Errorf("incorrect ID for removed key; got %s, want none", got)
+		}
+		if err := mgr.Load(ctx, id, ""); err != nil {
+			t.Fatalf("failed to load key: %v", err)
+		}
+		if err := mgr.agent.RemoveAll(); err != nil {
+			t.Fatalf("failed to remove keys: %v", err)
+		}
+		if got := mgr.agent.lookup(pub); got != InvalidID {
+			t.Errorf("incorrect ID after removing all keys; got %s, want none", got)
+		}
+	})
+}
+
+// BenchmarkSign compares signing using a loaded key, which uses the signer
+// created when the key was loaded, with parsing the private key for each
+// signature.
+func BenchmarkSign(b *testing.B) {
+	data := []byte("data")
+
+	for _, bc := range []struct {
+		description string
+		generate    func() (crypto.PrivateKey, error)
+		algorithm   string
+		flags       agent.SignatureFlags
+	}{
+		{
+			description: "rsa-4096",
+			generate:    func() (crypto.PrivateKey, error) { return rsa.GenerateKey(rand.Reader, 4096) },
+			algorithm:   ssh.KeyAlgoRSASHA256,
+			flags:       agent.SignatureFlagRsaSha256,
+		},
+		{
+			description: "ed25519",
+			generate: func() (crypto.PrivateKey, error) {
+				_, priv, err := ed25519.GenerateKey(rand.Reader)
+				return priv, err
+			},
+			algorithm: ssh.KeyAlgoED25519,
+		},
+	} {
+		priv, err := bc.generate()
+		if err != nil {
+			b.Fatalf("failed to generate key: %v", err)
+		}
+		block, err := ssh.MarshalPrivateKey(priv, "")
+		if err != nil {
+			b.Fatalf("failed to marshal key: %v", err)
+		}
+		pemPrivateKey := string(pem.EncodeToMemory(block))
+
+		b.Run(bc.description+"/loaded", func(b *testing.B) {
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+					{Name: "key", PEMPrivateKey: pemPrivateKey, Load: true},
+				})
+				if err != nil {
+					b.Fatalf("failed to initialize manager: %v", err)
+				}
+				loaded, err := mgr.Agent().List()
+				if err != nil || len(loaded) != 1 {
+					b.Fatalf("failed to list loaded keys: %v", err)
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := mgr.Agent().SignWithFlags(loaded[0], data, bc.flags); err != nil {
+						b.Fatalf("failed to sign: %v", err)
+					}
+				}
+			})
+		})
+
+		b.Run(bc.description+"/parsed", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				priv, err := ssh.ParseRawPrivateKey([]byte(pemPrivateKey))
+				if err != nil {
+					b.Fatalf("failed to parse key: %v", err)
+				}
+				signer, err := ssh.NewSignerFromKey(priv)
+				if err != nil {
+					b.Fatalf("failed to create signer: %v", err)
+				}
+				if _, err := signer.(ssh.AlgorithmSigner).SignWithAlgorithm(rand.Reader, data, bc.algorithm); err != nil {
+					b.Fatalf("failed to sign: %v", err)
+				}
+			}
+		})
+	}
+}
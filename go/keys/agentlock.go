@@ -0,0 +1,347 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	errAgentLocked    = errors.New("agent is locked")
+	errAgentNotLocked = errors.New("agent is not locked")
+	// errUnlockThrottled indicates that an attempt to unlock the agent was
+	// made too soon after a failed attempt.
+	errUnlockThrottled = errors.New("too many failed attempts to unlock agent")
+)
+
+// agentLock is the raw object stored in session storage while the agent is
+// locked. Only a salted hash of the passphrase is stored. The session already
+// holds decrypted keys, so a slow hash would not add protection.
+type agentLock struct {
+	// Salt is the base64-encoded salt.
+	Salt string `js:"salt"`
+	// Hash is the base64-encoded SHA-256 hash of the salt followed by the
+	// passphrase.
+	Hash string `js:"hash"`
+}
+
+var (
+	// agentLockPrefixes is the prefix for the lock state stored in-memory
+	// for our current session.
+	agentLockPrefixes = []string{"agentLock"}
+)
+
+const (
+	// agentLockKey is the storage key for agentLock.
+	agentLockKey = "lock"
+	// lockSaltBytes is the size of the salt used to hash the passphrase.
+	lockSaltBytes = 16
+	// unlockBackoff is the period for which attempts to unlock the agent
+	// are refused after the first failed attempt. It doubles with each
+	// consecutive failure, up to maxUnlockBackoff.
+	unlockBackoff    = time.Second
+	maxUnlockBackoff = 5 * time.Minute
+)
+
+// unlockDelay returns the period for which attempts to unlock the agent are
+// refused after the specified number of consecutive failures.
+func unlockDelay(failures int) time.Duration {
+	d := unlockBackoff
+	for i := 1; i < failures && d < maxUnlockBackoff; i++ {
+		d *= 2
+	}
+	if d > maxUnlockBackoff {
+		d = maxUnlockBackoff
+	}
+	return d
+}
+
+// hashLockPassphrase returns the hash of the salt followed by the passphrase.
+func hashLockPassphrase(salt, passphrase []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(passphrase)
+	return h.Sum(nil)
+}
+
+// newAgentLock returns the lock for the passphrase, using a random salt.
+func newAgentLock(passphrase []byte) (*agentLock, error) {
+	salt := make([]byte, lockSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return &agentLock{
+		Salt: base64.StdEncoding.EncodeToString(salt),
+		Hash: base64.StdEncoding.EncodeToString(hashLockPassphrase(salt, passphrase)),
+	}, nil
+}
+
+// matches returns true if the passphrase is the one with which the agent was
+// locked. The hashes are compared in constant time.
+func (l *agentLock) matches(passphrase []byte) bool {
+	salt, err := base64.StdEncoding.DecodeString(l.Salt)
+	if err != nil {
+		jsutil.LogError("failed to decode lock salt: %v", err)
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(l.Hash)
+	if err != nil {
+		jsutil.LogError("failed to decode lock hash: %v", err)
+		return false
+	}
+	return subtle.ConstantTimeCompare(hashLockPassphrase(salt, passphrase), want) == 1
+}
+
+// lockingAgent wraps an agent, implementing the lock and unlock requests of
+// the SSH agent protocol. While locked, no identities are listed and keys
+// cannot be used or modified. Extensions remain available, since they describe
+// the agent or the connection rather than using keys. The wrapped agent itself
+// is never locked, such that the Manager continues to manage the keys it
+// holds.
+//
+// Connected clients may attempt to unlock the agent, so attempts are refused
+// for a period after each failure, which grows with consecutive failures.
+//
+// lockingAgent implements the agent.ExtendedAgent interface.
+type lockingAgent struct {
+	agent.ExtendedAgent
+	// persist stores the lock, or removes it if nil, such that the lock
+	// survives restarts of the service worker. It blocks until complete.
+	persist func(l *agentLock) error
+	// now returns the current time.
+	now func() time.Time
+
+	// storeMu serializes invocations of persist, such that the stored lock
+	// is the one most recently set. It is not held with mu, such that
+	// the agent remains usable while storage is slow.
+	storeMu sync.Mutex
+
+	mu sync.Mutex
+	// lock is the current lock, or nil if the agent is not locked.
+	lock *agentLock
+	// failures is the number of consecutive failed attempts to unlock
+	// the agent.
+	failures int
+	// retryAfter is the time before which attempts to unlock the agent
+	// are refused.
+	retryAfter time.Time
+}
+
+// locked returns true if the agent is locked.
+func (a *lockingAgent) locked() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lock != nil
+}
+
+// restore applies a lock read from storage.
+func (a *lockingAgent) restore(l *agentLock) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lock = l
+}
+
+// List implements agent.Agent.List().
+func (a *lockingAgent) List() ([]*agent.Key, error) {
+	if a.locked() {
+		return nil, nil
+	}
+	return a.ExtendedAgent.List()
+}
+
+// Add implements agent.Agent.Add().
+func (a *lockingAgent) Add(key agent.AddedKey) error {
+	if a.locked() {
+		return errAgentLocked
+	}
+	return a.ExtendedAgent.Add(key)
+}
+
+// Remove implements agent.Agent.Remove().
+func (a *lockingAgent) Remove(key ssh.PublicKey) error {
+	if a.locked() {
+		return errAgentLocked
+	}
+	return a.ExtendedAgent.Remove(key)
+}
+
+// RemoveAll implements agent.Agent.RemoveAll().
+func (a *lockingAgent) RemoveAll() error {
+	if a.locked() {
+		return errAgentLocked
+	}
+	return a.ExtendedAgent.RemoveAll()
+}
+
+// Sign implements agent.Agent.Sign().
+func (a *lockingAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	if a.locked() {
+		return nil, errAgentLocked
+	}
+	return a.ExtendedAgent.Sign(key, data)
+}
+
+// SignWithFlags implements agent.ExtendedAgent.SignWithFlags().
+func (a *lockingAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if a.locked() {
+		return nil, errAgentLocked
+	}
+	return a.ExtendedAgent.SignWithFlags(key, data, flags)
+}
+
+// signFor signs as with SignWithFlags on behalf of the requester; see
+// managedAgent.signFor.
+func (a *lockingAgent) signFor(r *requester, key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if a.locked() {
+		return nil, errAgentLocked
+	}
+	if m, ok := a.ExtendedAgent.(*managedAgent); ok {
+		return m.signFor(r, key, data, flags)
+	}
+	return a.ExtendedAgent.SignWithFlags(key, data, flags)
+}
+
+// Signers implements agent.Agent.Signers().
+func (a *lockingAgent) Signers() ([]ssh.Signer, error) {
+	if a.locked() {
+		return nil, errAgentLocked
+	}
+	return a.ExtendedAgent.Signers()
+}
+
+// store stores the current lock, or removes it if the agent is not locked.
+// It must be invoked without holding mu.
+func (a *lockingAgent) store() error {
+	a.storeMu.Lock()
+	defer a.storeMu.Unlock()
+	a.mu.Lock()
+	l := a.lock
+	a.mu.Unlock()
+	return a.persist(l)
+}
+
+// Lock implements agent.Agent.Lock(). The agent is locked immediately, but is
+// unlocked again if the lock cannot be stored, since it would not survive a
+// restart.
+func (a *lockingAgent) Lock(passphrase []byte) error {
+	l, err := newAgentLock(passphrase)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	if a.lock != nil {
+		a.mu.Unlock()
+		return errAgentLocked
+	}
+	a.lock = l
+	a.mu.Unlock()
+
+	if err := a.store(); err != nil {
+		a.mu.Lock()
+		if a.lock == l {
+			a.lock = nil
+		}
+		a.mu.Unlock()
+		return fmt.Errorf("failed to store lock: %w", err)
+	}
+	return nil
+}
+
+// Unlock implements agent.Agent.Unlock(). The agent is unlocked even if the
+// stored lock cannot be removed; at worst, it is locked again on restart.
+// Attempts made too soon after a failure are refused without checking the
+// passphrase.
+func (a *lockingAgent) Unlock(passphrase []byte) error {
+	if err := a.unlock(passphrase); err != nil {
+		return err
+	}
+	if err := a.store(); err != nil {
+		jsutil.LogError("failed to remove stored lock: %v", err)
+	}
+	return nil
+}
+
+// unlock unlocks the agent without removing the stored lock; see Unlock.
+func (a *lockingAgent) unlock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lock == nil {
+		return errAgentNotLocked
+	}
+	now := a.now()
+	if now.Before(a.retryAfter) {
+		return fmt.Errorf("%w: retry after %s", errUnlockThrottled, a.retryAfter.Sub(now).Round(time.Second))
+	}
+	if !a.lock.matches(passphrase) {
+		a.failures++
+		a.retryAfter = now.Add(unlockDelay(a.failures))
+		return fmt.Errorf("%w: failed to unlock agent", ErrWrongPassphrase)
+	}
+	a.failures = 0
+	a.retryAfter = time.Time{}
+	a.lock = nil
+	return nil
+}
+
+// storeLock stores the lock in the session, or removes it if l is nil. It is
+// invoked outside an async context, and blocks until complete; see awaitMain.
+func (m *DefaultManager) storeLock(l *agentLock) error {
+	return awaitMain(func(ctx jsutil.AsyncContext) error {
+		if l == nil {
+			return m.agentLock.Delete(ctx, func(*agentLock) bool { return true })
+		}
+		return m.agentLock.WriteKey(ctx, agentLockKey, l)
+	})
+}
+
+// restoreLock locks the agent if it was locked by a previous instance.
+func (m *DefaultManager) restoreLock(ctx jsutil.AsyncContext) error {
+	l, err := m.agentLock.ReadKey(ctx, agentLockKey)
+	if err != nil {
+		return fmt.Errorf("failed to read lock: %w", err)
+	}
+	if l != nil {
+		m.locker.restore(l)
+	}
+	return nil
+}
+
+// Locked implements Manager.Locked.
+func (m *DefaultManager) Locked(_ jsutil.AsyncContext) (bool, error) {
+	return m.locker.locked(), nil
+}
+
+// Lock implements Manager.Lock.
+func (m *DefaultManager) Lock(_ jsutil.AsyncContext, passphrase string) error {
+	return m.locker.Lock([]byte(passphrase))
+}
+
+// Unlock implements Manager.Unlock.
+func (m *DefaultManager) Unlock(_ jsutil.AsyncContext, passphrase string) error {
+	return m.locker.Unlock([]byte(passphrase))
+}
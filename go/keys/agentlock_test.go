@@ -0,0 +1,253 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serveAgent serves the manager's agent over a pipe, returning a client that
+// uses the SSH agent protocol. The returned function closes the connection.
+func serveAgent(mgr *DefaultManager) (agent.ExtendedAgent, func()) {
+	c, s := net.Pipe()
+	go agent.ServeAgent(mgr.Agent(), s)
+	return agent.NewClient(c), func() { c.Close() }
+}
+
+func TestLockUnlock(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "good-key", PEMPrivateKey: testdata.WithoutPassphrase.Private, Load: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "good-key")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+		client, closeClient := serveAgent(mgr)
+		defer closeClient()
+
+		// Lock using the protocol, as with 'ssh-add -x'.
+		if err := client.Lock([]byte("lock-secret")); err != nil {
+			t.Fatalf("failed to lock agent: %v", err)
+		}
+		if locked, err := mgr.Locked(ctx); err != nil || !locked {
+			t.Errorf("incorrect lock state; got %t, %v; want true", locked, err)
+		}
+		if err := client.Lock([]byte("lock-secret")); err == nil {
+			t.Errorf("locking a locked agent unexpectedly succeeded")
+		}
+
+		// No keys are listed, and keys cannot be used, although they
+		// remain loaded.
+		listed, err := client.List()
+		if err != nil {
+			t.Errorf("failed to list keys: %v", err)
+		}
+		if len(listed) != 0 {
+			t.Errorf("incorrect keys listed while locked; got %d, want none", len(listed))
+		}
+		if err := signWith(mgr, id); err == nil {
+			t.Errorf("signing while locked unexpectedly succeeded")
+		}
+		loaded, err := mgr.Loaded(ctx)
+		if err != nil {
+			t.Errorf("failed to get loaded keys: %v", err)
+		}
+		if diff := cmp.Diff(loadedKeyIds(loaded), []ID{id}); diff != "" {
+			t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+		}
+
+		// The lock survives a restart.
+		now := time.Now()
+		restarted := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		restarted.now = func() time.Time { return now }
+		if err := restarted.LoadFromSession(ctx); err != nil {
+			t.Fatalf("failed to load keys from session: %v", err)
+		}
+		if locked, err := restarted.Locked(ctx); err != nil || !locked {
+			t.Errorf("incorrect lock state after restart; got %t, %v; want true", locked, err)
+		}
+		if err := signWith(restarted, id); err == nil {
+			t.Errorf("signing while locked after restart unexpectedly succeeded")
+		}
+
+		// Only the passphrase used to lock the agent unlocks it.
+		err = restarted.Unlock(ctx, "bogus")
+		if diff := cmp.Diff(err, ErrWrongPassphrase, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+		now = now.Add(unlockBackoff)
+		if err := restarted.Unlock(ctx, "lock-secret"); err != nil {
+			t.Fatalf("failed to unlock agent: %v", err)
+		}
+		if err := signWith(restarted, id); err != nil {
+			t.Errorf("failed to sign after unlock: %v", err)
+		}
+		// The keys loaded before the restart are listed once unlocked.
+		restartedClient, closeRestarted := serveAgent(restarted)
+		defer closeRestarted()
+		if listed, err := restartedClient.List(); err != nil || len(listed) != 1 {
+			t.Errorf("incorrect keys listed after restart; got %d, %v; want 1", len(listed), err)
+		}
+		err = restarted.Unlock(ctx, "lock-secret")
+		if diff := cmp.Diff(err, errAgentNotLocked, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		// Unlocking removes the stored lock.
+		restarted = NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		if err := restarted.LoadFromSession(ctx); err != nil {
+			t.Fatalf("failed to load keys from session: %v", err)
+		}
+		if locked, err := restarted.Locked(ctx); err != nil || locked {
+			t.Errorf("incorrect lock state after unlock; got %t, %v; want false", locked, err)
+		}
+	})
+}
+
+func TestUnlockBackoff(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		now := time.Now()
+		mgr.now = func() time.Time { return now }
+		client, closeClient := serveAgent(mgr)
+		defer closeClient()
+		if err := client.Lock([]byte("lock-secret")); err != nil {
+			t.Fatalf("failed to lock agent: %v", err)
+		}
+
+		// Each failure refuses further attempts for twice as long as
+		// the last, even with the correct passphrase.
+		for failures, wait := 1, unlockBackoff; failures <= 4; failures, wait = failures+1, wait*2 {
+			if err := mgr.Unlock(ctx, "bogus"); !errors.Is(err, ErrWrongPassphrase) {
+				t.Fatalf("failure %d: incorrect error for wrong passphrase: %v", failures, err)
+			}
+			now = now.Add(wait - time.Millisecond)
+			if err := mgr.Unlock(ctx, "lock-secret"); !errors.Is(err, errUnlockThrottled) {
+				t.Errorf("failure %d: incorrect error before backoff elapsed: %v", failures, err)
+			}
+			// Clients using the protocol are also refused.
+			if err := client.Unlock([]byte("lock-secret")); err == nil {
+				t.Errorf("failure %d: unlock using protocol unexpectedly succeeded", failures)
+			}
+			now = now.Add(time.Millisecond)
+		}
+		if got := unlockDelay(100); got != maxUnlockBackoff {
+			t.Errorf("incorrect maximum backoff; got %s, want %s", got, maxUnlockBackoff)
+		}
+
+		// Once the backoff elapses, the correct passphrase unlocks the
+		// agent, and failures are forgotten.
+		if err := mgr.Unlock(ctx, "lock-secret"); err != nil {
+			t.Fatalf("failed to unlock agent: %v", err)
+		}
+		if err := client.Lock([]byte("lock-secret")); err != nil {
+			t.Fatalf("failed to lock agent: %v", err)
+		}
+		if err := mgr.Unlock(ctx, "bogus"); !errors.Is(err, ErrWrongPassphrase) {
+			t.Fatalf("incorrect error for wrong passphrase: %v", err)
+		}
+		now = now.Add(unlockBackoff)
+		if err := mgr.Unlock(ctx, "lock-secret"); err != nil {
+			t.Errorf("failed to unlock agent after first failure: %v", err)
+		}
+	})
+}
+
+func TestLockWhileStoring(t *testing.T) {
+	t.Parallel()
+
+	stored := make(chan *agentLock)
+	results := make(chan error)
+	a := &lockingAgent{
+		ExtendedAgent: agent.NewKeyring().(agent.ExtendedAgent),
+		persist: func(l *agentLock) error {
+			stored <- l
+			return <-results
+		},
+		now: time.Now,
+	}
+
+	// The agent is locked while the lock is being stored, and the lock
+	// state remains available.
+	lockErr := make(chan error)
+	go func() { lockErr <- a.Lock([]byte("lock-secret")) }()
+	if l := <-stored; l == nil {
+		t.Fatalf("lock not stored")
+	}
+	if !a.locked() {
+		t.Errorf("agent not locked while storing lock")
+	}
+	if _, err := a.Signers(); !errors.Is(err, errAgentLocked) {
+		t.Errorf("incorrect error while storing lock: %v", err)
+	}
+
+	// The agent is unlocked again if the lock cannot be stored.
+	results <- errors.New("storage failed")
+	if err := <-lockErr; err == nil {
+		t.Errorf("locking unexpectedly succeeded when lock could not be stored")
+	}
+	if a.locked() {
+		t.Errorf("agent locked although lock could not be stored")
+	}
+
+	// Once stored, the lock remains.
+	go func() { lockErr <- a.Lock([]byte("lock-secret")) }()
+	<-stored
+	results <- nil
+	if err := <-lockErr; err != nil {
+		t.Fatalf("failed to lock agent: %v", err)
+	}
+	if !a.locked() {
+		t.Errorf("agent not locked after storing lock")
+	}
+
+	// Unlocking also does not wait for storage.
+	unlockErr := make(chan error)
+	go func() { unlockErr <- a.Unlock([]byte("lock-secret")) }()
+	if l := <-stored; l != nil {
+		t.Errorf("lock not removed from storage")
+	}
+	if a.locked() {
+		t.Errorf("agent locked while removing stored lock")
+	}
+	results <- nil
+	if err := <-unlockErr; err != nil {
+		t.Errorf("failed to unlock agent: %v", err)
+	}
+}
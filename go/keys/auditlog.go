@@ -0,0 +1,172 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// AuditEntry records a request to sign.
+type AuditEntry struct {
+	// TimeMillis is the time of the request, in milliseconds since the
+	// epoch.
+	TimeMillis int64 `js:"timeMillis"`
+	// ID is the ID of the key, or empty if it was not loaded by the
+	// Manager.
+	ID string `js:"id"`
+	// FingerprintSHA256 is the SHA256 fingerprint of the key.
+	FingerprintSHA256 string `js:"fingerprintSHA256"`
+	// Connection describes the client that made the request.
+	Connection string `js:"connection"`
+	// ExtensionID is the ID of the extension that made the request, if
+	// it was made by an extension.
+	ExtensionID string `js:"extensionId"`
+	// ConnectionName is the name the client gave to the connection over
+	// which it made the request, if any.
+	ConnectionName string `js:"connectionName"`
+	// ConnectedMillis is the time at which the client connected, in
+	// milliseconds since the epoch, or zero if unknown.
+	ConnectedMillis int64 `js:"connectedMillis"`
+	// Host describes the host to which the client was connected, if it
+	// reported it using the session-bind@openssh.com extension.
+	Host string `js:"host"`
+	// Refused is the reason the request was refused, or empty if the
+	// data was signed.
+	Refused string `js:"refused"`
+	// Superseded indicates that the key was being replaced at the time of
+	// the request; see StartRotation. The host should be updated to accept
+	// the replacement key.
+	Superseded bool `js:"superseded"`
+}
+
+var (
+	// auditLogPrefixes is the prefix for audit log entries stored for our
+	// current session.
+	auditLogPrefixes = []string{"auditLog"}
+)
+
+const (
+	// maxAuditEntries is the number of audit log entries retained; older
+	// entries are discarded.
+	maxAuditEntries = 200
+)
+
+// RefusedFunc is invoked when a request to sign is refused by a constraint on
+// the key, such as a destination constraint. e describes the request, as
+// recorded in the audit log; it is supplied whether or not the audit log is
+// enabled. It must not block.
+type RefusedFunc func(e *AuditEntry)
+
+// SetOnRefused sets the function invoked when a request to sign is refused by
+// a constraint on the key. Requests the user declined to approve are not
+// reported, since the user is already aware of them.
+func (m *DefaultManager) SetOnRefused(f RefusedFunc) {
+	m.onRefused = f
+}
+
+// refusedByConstraint returns true if a request to sign failed because it was
+// refused by a constraint on the key.
+func refusedByConstraint(err error) bool {
+	return errors.Is(err, errDestinationRefused) || errors.Is(err, errSHA1Refused)
+}
+
+// recordAudit adds an entry to the audit log, unless logging is disabled.
+// Signing happens outside of an async context, so the entry is written
+// asynchronously; failures are logged, such that logging never affects the
+// outcome of a request.
+func (m *DefaultManager) recordAudit(e *AuditEntry) {
+	e.TimeMillis = m.now().UnixMilli()
+	jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+		enabled, err := m.AuditLogEnabled(ctx)
+		if err != nil {
+			jsutil.LogError("failed to read audit log setting: %v", err)
+			return js.Undefined(), nil
+		}
+		if !enabled {
+			return js.Undefined(), nil
+		}
+		if e.ID != "" {
+			if e.Superseded, err = m.superseded(ctx, ID(e.ID)); err != nil {
+				jsutil.LogError("failed to check rotation of key ID %s: %v", e.ID, err)
+			}
+		}
+		if err := m.auditLog.Write(ctx, e); err != nil {
+			jsutil.LogError("failed to write audit log entry: %v", err)
+			return js.Undefined(), nil
+		}
+		m.trimAuditLog(ctx)
+		return js.Undefined(), nil
+	})
+}
+
+// trimAuditLog discards the oldest audit log entries, such that at most
+// maxAuditEntries remain. Failures are logged.
+func (m *DefaultManager) trimAuditLog(ctx jsutil.AsyncContext) {
+	entries, err := m.AuditLog(ctx)
+	if err != nil {
+		jsutil.LogError("failed to read audit log: %v", err)
+		return
+	}
+	if len(entries) <= maxAuditEntries {
+		return
+	}
+	cutoff := entries[len(entries)-maxAuditEntries].TimeMillis
+	if err := m.auditLog.Delete(ctx, func(e *AuditEntry) bool { return e.TimeMillis < cutoff }); err != nil {
+		jsutil.LogError("failed to trim audit log: %v", err)
+	}
+}
+
+// AuditLog implements Manager.AuditLog.
+func (m *DefaultManager) AuditLog(ctx jsutil.AsyncContext) ([]*AuditEntry, error) {
+	entries, err := m.auditLog.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].TimeMillis < entries[j].TimeMillis
+	})
+	return entries, nil
+}
+
+// ClearAuditLog implements Manager.ClearAuditLog.
+func (m *DefaultManager) ClearAuditLog(ctx jsutil.AsyncContext) error {
+	if err := m.auditLog.Delete(ctx, func(*AuditEntry) bool { return true }); err != nil {
+		return fmt.Errorf("failed to clear audit log: %w", err)
+	}
+	return nil
+}
+
+// AuditLogEnabled implements Manager.AuditLogEnabled.
+func (m *DefaultManager) AuditLogEnabled(ctx jsutil.AsyncContext) (bool, error) {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read settings: %w", err)
+	}
+	return s == nil || !s.DisableAuditLog, nil
+}
+
+// SetAuditLogEnabled implements Manager.SetAuditLogEnabled.
+func (m *DefaultManager) SetAuditLogEnabled(ctx jsutil.AsyncContext, enabled bool) error {
+	return m.updateSettings(ctx, func(s *managerSettings) {
+		s.DisableAuditLog = !enabled
+	})
+}
@@ -0,0 +1,140 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestTrimAuditLog(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		for i := 0; i < maxAuditEntries+5; i++ {
+			if err := mgr.auditLog.Write(ctx, &AuditEntry{TimeMillis: int64(i)}); err != nil {
+				t.Errorf("failed to write entry: %v", err)
+				return
+			}
+		}
+
+		mgr.trimAuditLog(ctx)
+
+		entries, err := mgr.AuditLog(ctx)
+		if err != nil {
+			t.Errorf("failed to read audit log: %v", err)
+			return
+		}
+		if len(entries) != maxAuditEntries {
+			t.Errorf("incorrect number of entries; got %d, want %d", len(entries), maxAuditEntries)
+			return
+		}
+		if entries[0].TimeMillis != 5 {
+			t.Errorf("incorrect oldest entry; got time %d, want 5", entries[0].TimeMillis)
+		}
+	})
+}
+
+func TestAuditLogEnabled(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		now := time.UnixMilli(1000)
+		mgr.now = func() time.Time { return now }
+
+		enabled, err := mgr.AuditLogEnabled(ctx)
+		if err != nil {
+			t.Errorf("failed to read setting: %v", err)
+			return
+		}
+		if !enabled {
+			t.Errorf("audit log disabled by default")
+		}
+
+		if err := mgr.SetAuditLogEnabled(ctx, false); err != nil {
+			t.Errorf("failed to disable audit log: %v", err)
+			return
+		}
+		mgr.recordAudit(&AuditEntry{ID: "disabled"})
+		// Entries are written asynchronously; allow time for the
+		// entry to be (incorrectly) written.
+		time.Sleep(100 * time.Millisecond)
+		entries, err := mgr.AuditLog(ctx)
+		if err != nil {
+			t.Errorf("failed to read audit log: %v", err)
+			return
+		}
+		if len(entries) != 0 {
+			t.Errorf("entry recorded while disabled: %+v", entries)
+		}
+
+		if err := mgr.SetAuditLogEnabled(ctx, true); err != nil {
+			t.Errorf("failed to enable audit log: %v", err)
+			return
+		}
+		mgr.recordAudit(&AuditEntry{ID: "enabled"})
+		poll(func() bool {
+			entries, err = mgr.AuditLog(ctx)
+			return err != nil || len(entries) > 0
+		})
+		if err != nil {
+			t.Errorf("failed to read audit log: %v", err)
+			return
+		}
+		want := []*AuditEntry{{TimeMillis: 1000, ID: "enabled"}}
+		if diff := cmp.Diff(entries, want); diff != "" {
+			t.Errorf("incorrect audit log; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClearAuditLog(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		for i := 0; i < 3; i++ {
+			if err := mgr.auditLog.Write(ctx, &AuditEntry{TimeMillis: int64(i)}); err != nil {
+				t.Errorf("failed to write entry: %v", err)
+				return
+			}
+		}
+
+		if err := mgr.ClearAuditLog(ctx); err != nil {
+			t.Errorf("failed to clear audit log: %v", err)
+			return
+		}
+
+		entries, err := mgr.AuditLog(ctx)
+		if err != nil {
+			t.Errorf("failed to read audit log: %v", err)
+			return
+		}
+		if len(entries) != 0 {
+			t.Errorf("incorrect number of entries; got %d, want 0", len(entries))
+		}
+	})
+}
@@ -0,0 +1,125 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// cachedPassphrase is the raw object stored in session storage for the
+// passphrase of a key that is loaded automatically. It is stored under the
+// key's ID.
+type cachedPassphrase struct {
+	ID         string `js:"id"`
+	Passphrase string `js:"passphrase"`
+}
+
+var (
+	// passphrasePrefixes is the prefix for passphrases cached in-memory
+	// for our current session.
+	passphrasePrefixes = []string{"passphrase"}
+)
+
+// cachePassphrase caches the passphrase for the key if it is loaded
+// automatically. Failures are logged, since the key can still be used.
+func (m *DefaultManager) cachePassphrase(ctx jsutil.AsyncContext, key *storedKey, passphrase string) {
+	if !key.AutoLoad || !key.Encrypted() {
+		return
+	}
+	cp := &cachedPassphrase{
+		ID:         key.ID,
+		Passphrase: passphrase,
+	}
+	if err := m.passphrases.WriteKey(ctx, key.ID, cp); err != nil {
+		jsutil.LogError("failed to cache passphrase for key ID %s: %v", key.ID, err)
+	}
+}
+
+// forgetPassphrases removes cached passphrases for the keys with the specified
+// IDs. Failures are logged.
+func (m *DefaultManager) forgetPassphrases(ctx jsutil.AsyncContext, ids ...ID) {
+	remove := map[ID]bool{}
+	for _, id := range ids {
+		remove[id] = true
+	}
+	if err := m.passphrases.Delete(ctx, func(cp *cachedPassphrase) bool { return remove[ID(cp.ID)] }); err != nil {
+		jsutil.LogError("failed to remove cached passphrases: %v", err)
+	}
+}
+
+// SetAutoLoad implements Manager.SetAutoLoad.
+func (m *DefaultManager) SetAutoLoad(ctx jsutil.AsyncContext, id ID, autoLoad bool) error {
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(key *storedKey) (*storedKey, error) {
+		updated := *key
+		updated.AutoLoad = autoLoad
+		return &updated, nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+	if !autoLoad {
+		m.forgetPassphrases(ctx, id)
+	}
+	return nil
+}
+
+// LoadAutoKeys implements Manager.LoadAutoKeys.
+func (m *DefaultManager) LoadAutoKeys(ctx jsutil.AsyncContext) ([]*Result, error) {
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	loaded, err := m.Loaded(ctx)
+	if err != nil {
+		return nil, err
+	}
+	isLoaded := map[ID]bool{}
+	for _, l := range loaded {
+		isLoaded[l.ID()] = true
+	}
+
+	var reqs []*LoadRequest
+	for _, k := range keys {
+		if !k.AutoLoad || isLoaded[ID(k.ID)] {
+			continue
+		}
+		req := &LoadRequest{ID: ID(k.ID)}
+		if k.Encrypted() {
+			cp, err := m.passphrases.ReadKey(ctx, k.ID)
+			if err != nil {
+				jsutil.LogError("failed to read cached passphrase for key ID %s: %v; skipping", k.ID, err)
+				continue
+			}
+			if cp == nil {
+				jsutil.LogDebug("DefaultManager.LoadAutoKeys: passphrase for key ID %s not cached; skipping", k.ID)
+				continue
+			}
+			req.Passphrase = cp.Passphrase
+		}
+		reqs = append(reqs, req)
+	}
+
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	return m.LoadAll(ctx, reqs)
+}
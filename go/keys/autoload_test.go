@@ -0,0 +1,214 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestLoadAutoKeys(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "auto-unencrypted", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+			{Name: "auto-cached", PEMPrivateKey: testdata.WithPassphrase.Private},
+			{Name: "auto-uncached", PEMPrivateKey: testdata.OpenSSHFormat.Private},
+			{Name: "auto-incorrect", PEMPrivateKey: testdata.ED25519WithPassphrase.Private},
+			{Name: "manual", PEMPrivateKey: testdata.ECDSAWithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Errorf("failed to initialize manager: %v", err)
+			return
+		}
+		ids := map[string]ID{}
+		for _, name := range []string{"auto-unencrypted", "auto-cached", "auto-uncached", "auto-incorrect", "manual"} {
+			id, err := findKey(ctx, mgr, InvalidID, name)
+			if err != nil {
+				t.Errorf("failed to find key: %v", err)
+				return
+			}
+			ids[name] = id
+			if name != "manual" {
+				if err := mgr.SetAutoLoad(ctx, id, true); err != nil {
+					t.Errorf("failed to set auto-load: %v", err)
+					return
+				}
+			}
+		}
+
+		// Cache passphrases as if the keys had been loaded in a previous
+		// instance of the agent.
+		for name, passphrase := range map[string]string{
+			"auto-cached":    testdata.WithPassphrase.Passphrase,
+			"auto-incorrect": "incorrect-passphrase",
+		} {
+			id := string(ids[name])
+			if err := mgr.passphrases.WriteKey(ctx, id, &cachedPassphrase{ID: id, Passphrase: passphrase}); err != nil {
+				t.Errorf("failed to cache passphrase: %v", err)
+				return
+			}
+		}
+
+		results, err := mgr.LoadAutoKeys(ctx)
+		if err != nil {
+			t.Errorf("LoadAutoKeys failed: %v", err)
+			return
+		}
+		gotErrs := map[ID]error{}
+		for _, r := range results {
+			gotErrs[r.ID] = r.Err
+		}
+		wantErrs := map[ID]error{
+			ids["auto-unencrypted"]: nil,
+			ids["auto-cached"]:      nil,
+			ids["auto-incorrect"]:   x509.IncorrectPasswordError,
+		}
+		if diff := cmp.Diff(gotErrs, wantErrs, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+
+		wantBlobs := []string{testdata.WithoutPassphrase.Blob, testdata.WithPassphrase.Blob}
+		loaded, err := mgr.Loaded(ctx)
+		if err != nil {
+			t.Errorf("failed to get loaded keys: %v", err)
+			return
+		}
+		if diff := cmp.Diff(loadedKeyBlobs(loaded), wantBlobs, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+			t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+		}
+
+		// Keys that are already loaded are not loaded again.
+		results, err = mgr.LoadAutoKeys(ctx)
+		if err != nil {
+			t.Errorf("LoadAutoKeys failed: %v", err)
+			return
+		}
+		var gotIDs []ID
+		for _, r := range results {
+			gotIDs = append(gotIDs, r.ID)
+		}
+		if diff := cmp.Diff(gotIDs, []ID{ids["auto-incorrect"]}); diff != "" {
+			t.Errorf("incorrect keys attempted; -got +want: %s", diff)
+		}
+		loaded, err = mgr.Loaded(ctx)
+		if err != nil {
+			t.Errorf("failed to get loaded keys: %v", err)
+			return
+		}
+		if diff := cmp.Diff(loadedKeyBlobs(loaded), wantBlobs, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+			t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestAutoLoadPassphraseCache(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "good-key", PEMPrivateKey: testdata.WithPassphrase.Private},
+		})
+		if err != nil {
+			t.Errorf("failed to initialize manager: %v", err)
+			return
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "good-key")
+		if err != nil {
+			t.Errorf("failed to find key: %v", err)
+			return
+		}
+
+		cached := func() string {
+			cp, err := mgr.passphrases.ReadKey(ctx, string(id))
+			if err != nil {
+				t.Errorf("failed to read cached passphrase: %v", err)
+			}
+			if cp == nil {
+				return ""
+			}
+			return cp.Passphrase
+		}
+		load := func() {
+			if err := mgr.Load(ctx, id, testdata.WithPassphrase.Passphrase); err != nil {
+				t.Errorf("failed to load key: %v", err)
+			}
+		}
+		unload := func() {
+			if err := mgr.Unload(ctx, id); err != nil {
+				t.Errorf("failed to unload key: %v", err)
+			}
+		}
+
+		// Passphrases are only cached for keys loaded automatically.
+		load()
+		if got := cached(); got != "" {
+			t.Errorf("passphrase cached for key not loaded automatically")
+		}
+		unload()
+
+		if err := mgr.SetAutoLoad(ctx, id, true); err != nil {
+			t.Errorf("failed to set auto-load: %v", err)
+			return
+		}
+		configured, err := mgr.Configured(ctx)
+		if err != nil {
+			t.Errorf("failed to get configured keys: %v", err)
+			return
+		}
+		if len(configured) != 1 || !configured[0].AutoLoad {
+			t.Errorf("incorrect configured keys: auto-load not set")
+		}
+		load()
+		if diff := cmp.Diff(cached(), testdata.WithPassphrase.Passphrase); diff != "" {
+			t.Errorf("incorrect cached passphrase; -got +want: %s", diff)
+		}
+
+		// Unloading the key forgets the passphrase.
+		unload()
+		if got := cached(); got != "" {
+			t.Errorf("passphrase cached after unload")
+		}
+
+		// As does disabling auto-load.
+		load()
+		if err := mgr.SetAutoLoad(ctx, id, false); err != nil {
+			t.Errorf("failed to clear auto-load: %v", err)
+			return
+		}
+		if got := cached(); got != "" {
+			t.Errorf("passphrase cached after disabling auto-load")
+		}
+
+		err = mgr.SetAutoLoad(ctx, ID("bogus-id"), true)
+		if diff := cmp.Diff(err, errKeyNotFound, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// KeyStatus is the status of a key returned by Available.
+type KeyStatus string
+
+const (
+	// StatusConfigured indicates that the key is configured, but not
+	// loaded.
+	StatusConfigured KeyStatus = "configured"
+	// StatusLoaded indicates that the key is loaded into the agent. It
+	// may or may not be configured; keys may be loaded by other means,
+	// such as ssh-add over a forwarded connection.
+	StatusLoaded KeyStatus = "loaded"
+	// StatusEphemeral indicates that the key was loaded using
+	// LoadEphemeral, and is not configured.
+	StatusEphemeral KeyStatus = "ephemeral"
+	// StatusExpired indicates that the key is configured and not loaded,
+	// and is past its expiry.
+	StatusExpired KeyStatus = "expired"
+	// StatusCorrupt indicates that the key is configured, but its private
+	// key is not in a supported format, such that it cannot be loaded.
+	StatusCorrupt KeyStatus = "corrupt"
+)
+
+// AvailableKey is a key that is configured, loaded into the agent, or both.
+type AvailableKey struct {
+	// InternalStatus is the status of the key; see Status. Must be
+	// exported to be handled correctly in conversion to/from js.Value.
+	InternalStatus string `js:"status"`
+	// Configured is the configured key, or nil if the key is not
+	// configured.
+	Configured *ConfiguredKey `js:"configured"`
+	// Loaded is the key loaded into the agent, or nil if the key is not
+	// loaded.
+	Loaded *LoadedKey `js:"loaded"`
+}
+
+// Status returns the status of the key.
+func (k *AvailableKey) Status() KeyStatus {
+	return KeyStatus(k.InternalStatus)
+}
+
+// Available implements Manager.Available.
+func (m *DefaultManager) Available(ctx jsutil.AsyncContext) ([]*AvailableKey, error) {
+	configured, err := m.Configured(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The agent is listed without yielding after configured keys are read,
+	// such that no key can be loaded or unloaded in between.
+	loaded, err := m.Loaded(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[ID]*ConfiguredKey{}
+	for _, c := range configured {
+		byID[ID(c.ID)] = c
+	}
+
+	var result []*AvailableKey
+	isLoaded := map[ID]bool{}
+	for _, l := range loaded {
+		k := &AvailableKey{InternalStatus: string(StatusLoaded), Loaded: l}
+		id := l.ID()
+		if c := byID[id]; c != nil {
+			k.Configured = c
+			isLoaded[id] = true
+		} else if l.Ephemeral {
+			k.InternalStatus = string(StatusEphemeral)
+		}
+		result = append(result, k)
+	}
+	for _, c := range configured {
+		if isLoaded[ID(c.ID)] {
+			continue
+		}
+		status := StatusConfigured
+		switch {
+		case c.Format == "":
+			status = StatusCorrupt
+		case c.Expired:
+			status = StatusExpired
+		}
+		result = append(result, &AvailableKey{InternalStatus: string(status), Configured: c})
+	}
+	return result, nil
+}
@@ -0,0 +1,103 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAvailable(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		agt := agent.NewKeyring()
+		mgr, err := newTestManager(ctx, agt, storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "loaded", PEMPrivateKey: testdata.ED25519WithoutPassphrase.Private, Load: true},
+			{Name: "configured", PEMPrivateKey: testdata.PKCS8Format.Private},
+			{Name: "expired", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "expired")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+		if err := mgr.SetExpiry(ctx, id, time.Now().Add(-time.Hour)); err != nil {
+			t.Fatalf("SetExpiry failed: %v", err)
+		}
+		// Keys may be stored in a format we no longer recognize.
+		if err := mgr.writeStoredKey(ctx, &storedKey{ID: "corrupt-id", Name: "corrupt", PEMPrivateKey: "garbage"}); err != nil {
+			t.Fatalf("failed to store key: %v", err)
+		}
+		if _, err := mgr.LoadEphemeral(ctx, "ephemeral", testdata.ECDSAWithoutPassphrase.Private, ""); err != nil {
+			t.Fatalf("LoadEphemeral failed: %v", err)
+		}
+		// Keys loaded into the agent directly are listed, although they
+		// are not configured.
+		priv, err := ssh.ParseRawPrivateKey([]byte(testdata.OpenSSHFormatWithoutPassphrase.Private))
+		if err != nil {
+			t.Fatalf("failed to parse private key: %v", err)
+		}
+		if err := agt.Add(agent.AddedKey{PrivateKey: priv, Comment: "external"}); err != nil {
+			t.Fatalf("failed to load key into agent: %v", err)
+		}
+
+		available, err := mgr.Available(ctx)
+		if err != nil {
+			t.Fatalf("Available failed: %v", err)
+		}
+		got := map[string]KeyStatus{}
+		for _, k := range available {
+			var name string
+			if k.Configured != nil {
+				name = k.Configured.Name
+			} else {
+				// Keys that are not configured are identified
+				// by the last word of their comment.
+				fields := strings.Fields(k.Loaded.Comment)
+				name = fields[len(fields)-1]
+			}
+			got[name] = k.Status()
+			if k.Status() == StatusLoaded && k.Loaded == nil {
+				t.Errorf("loaded key %s has no loaded details", name)
+			}
+		}
+		want := map[string]KeyStatus{
+			"loaded":     StatusLoaded,
+			"configured": StatusConfigured,
+			"expired":    StatusExpired,
+			"corrupt":    StatusCorrupt,
+			"ephemeral":  StatusEphemeral,
+			"external":   StatusLoaded,
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect statuses; -got +want: %s", diff)
+		}
+	})
+}
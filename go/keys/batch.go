@@ -0,0 +1,127 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// errBatchFailed indicates that some of the keys supplied to AddBatch could
+// not be added, and therefore none were.
+var errBatchFailed = errors.New("keys not added")
+
+// AddItem is one of the keys supplied to AddBatch.
+type AddItem struct {
+	// Name is the name to assign to the key. It must not already be in
+	// use.
+	Name string `js:"name"`
+	// PEMPrivateKey is the private key, in any of the formats supported
+	// by Add.
+	PEMPrivateKey string `js:"pemPrivateKey"`
+	// Certificate is an optional certificate for the key; see
+	// WithCertificate.
+	Certificate string `js:"certificate"`
+	// ExpectedPublicKey is an optional public key to which the key must
+	// correspond; see WithExpectedPublicKey.
+	ExpectedPublicKey string `js:"expectedPublicKey"`
+}
+
+// AddResult is the outcome of adding one of the keys supplied to AddBatch.
+type AddResult struct {
+	// Name is the name of the key.
+	Name string `js:"name"`
+	// ID is the ID assigned to the key, or empty if it was not added.
+	ID string `js:"id"`
+	// Err describes why the key could not be added, or is empty if it was
+	// (or would have been) added.
+	Err string `js:"err"`
+}
+
+// AddBatch implements Manager.AddBatch.
+func (m *DefaultManager) AddBatch(ctx jsutil.AsyncContext, items []*AddItem) ([]*AddResult, error) {
+	defer m.keepAlive.Acquire()()
+
+	existing, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	names := map[string]bool{}
+	used := map[ID]bool{}
+	for _, k := range existing {
+		names[k.Name] = true
+		used[ID(k.ID)] = true
+	}
+
+	// Keys earlier in the batch are taken into account when checking for
+	// duplicates and allocating IDs for those that follow.
+	add := func(item *AddItem) (*storedKey, error) {
+		if names[item.Name] {
+			return nil, fmt.Errorf("%w: %s", errNameInUse, item.Name)
+		}
+		sk, pub, err := parseNewKey(item.Name, item.PEMPrivateKey, &addOptions{
+			certificate:       item.Certificate,
+			expectedPublicKey: item.ExpectedPublicKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if pub != nil {
+			if err := findDuplicateIn(existing, pub); err != nil {
+				return nil, err
+			}
+		}
+		id, err := allocateID(pub, used)
+		if err != nil {
+			return nil, err
+		}
+		sk.ID = string(id)
+		return sk, nil
+	}
+
+	var results []*AddResult
+	added := map[string]*storedKey{}
+	failed := 0
+	for _, item := range items {
+		r := &AddResult{Name: item.Name}
+		results = append(results, r)
+		sk, err := add(item)
+		if err != nil {
+			r.Err = err.Error()
+			failed++
+			continue
+		}
+		names[sk.Name] = true
+		used[ID(sk.ID)] = true
+		existing = append(existing, sk)
+		added[sk.ID] = sk
+		r.ID = sk.ID
+	}
+
+	if failed > 0 {
+		for _, r := range results {
+			r.ID = ""
+		}
+		return results, fmt.Errorf("%w: %d of %d keys failed", errBatchFailed, failed, len(items))
+	}
+	if err := m.storedKeys.WriteKeys(ctx, added); err != nil {
+		return nil, fmt.Errorf("failed to write keys: %w", err)
+	}
+	return results, nil
+}
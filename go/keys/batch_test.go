@@ -0,0 +1,114 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAddBatch(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "existing", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+
+		// configuredNames returns the names of the configured keys, by ID.
+		configuredNames := func() map[ID]string {
+			t.Helper()
+			configured, err := mgr.Configured(ctx)
+			if err != nil {
+				t.Fatalf("failed to get configured keys: %v", err)
+			}
+			names := map[ID]string{}
+			for _, k := range configured {
+				names[ID(k.ID)] = k.Name
+			}
+			return names
+		}
+		// failures returns the items that failed, by name.
+		failures := func(results []*AddResult) map[string]bool {
+			failed := map[string]bool{}
+			for _, r := range results {
+				failed[r.Name] = r.Err != ""
+			}
+			return failed
+		}
+		before := configuredNames()
+
+		// If any key fails, none are added, but the failure of each is
+		// reported.
+		results, err := mgr.AddBatch(ctx, []*AddItem{
+			{Name: "ed25519", PEMPrivateKey: testdata.ED25519WithoutPassphrase.Private},
+			{Name: "duplicate", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+			{Name: "existing", PEMPrivateKey: testdata.ECDSAWithoutPassphrase.Private},
+			{Name: "invalid", PEMPrivateKey: "not a key"},
+			{Name: "duplicate-in-batch", PEMPrivateKey: testdata.ED25519WithoutPassphrase.Private},
+		})
+		if !errors.Is(err, errBatchFailed) {
+			t.Errorf("AddBatch returned incorrect error: %v", err)
+		}
+		wantFailed := map[string]bool{
+			"ed25519":            false,
+			"duplicate":          true,
+			"existing":           true,
+			"invalid":            true,
+			"duplicate-in-batch": true,
+		}
+		if diff := cmp.Diff(failures(results), wantFailed); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(configuredNames(), before); diff != "" {
+			t.Errorf("keys unexpectedly added; -got +want: %s", diff)
+		}
+
+		// Otherwise, all are added.
+		results, err = mgr.AddBatch(ctx, []*AddItem{
+			{Name: "ed25519", PEMPrivateKey: testdata.ED25519WithoutPassphrase.Private},
+			{Name: "ecdsa", PEMPrivateKey: testdata.ECDSAWithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Fatalf("AddBatch failed: %v", err)
+		}
+		want := before
+		for _, r := range results {
+			if r.Err != "" || r.ID == "" {
+				t.Errorf("incorrect result for %s: %+v", r.Name, r)
+			}
+			want[ID(r.ID)] = r.Name
+		}
+		if diff := cmp.Diff(configuredNames(), want); diff != "" {
+			t.Errorf("incorrect configured keys; -got +want: %s", diff)
+		}
+		if err := mgr.Load(ctx, ID(results[1].ID), ""); err != nil {
+			t.Errorf("Load failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,207 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/blowfish"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// opensshKDFRounds and opensshSaltBytes are the bcrypt_pbkdf
+	// parameters used to encrypt keys in the OpenSSH format; they are
+	// those used by ssh.MarshalPrivateKeyWithPassphrase.
+	opensshKDFRounds = 16
+	opensshSaltBytes = 16
+	// opensshKeyMagic precedes the outer structure of a private key in the
+	// OpenSSH format.
+	opensshKeyMagic = "openssh-key-v1\x00"
+	// bcryptBlockSize is the size of the output of a single bcrypt hash.
+	bcryptBlockSize = 32
+)
+
+var errPaddingNotFound = errors.New("padding of private key not found")
+
+// bcryptMagic is the plaintext encrypted by each bcrypt hash.
+var bcryptMagic = []byte("OxychromaticBlowfishSwatDynamite")
+
+// bcryptHash computes a single bcrypt hash into out.
+func bcryptHash(out, shapass, shasalt []byte) error {
+	c, err := blowfish.NewSaltedCipher(shapass, shasalt)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 64; i++ {
+		blowfish.ExpandKey(shasalt, c)
+		blowfish.ExpandKey(shapass, c)
+	}
+	copy(out, bcryptMagic)
+	for i := 0; i < bcryptBlockSize; i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(out[i:i+8], out[i:i+8])
+		}
+	}
+	// The hash is defined using little-endian words.
+	for i := 0; i < bcryptBlockSize; i += 4 {
+		out[i+3], out[i+2], out[i+1], out[i] = out[i], out[i+1], out[i+2], out[i+3]
+	}
+	return nil
+}
+
+// bcryptPBKDF derives a key of keyLen bytes from the passphrase and salt using
+// bcrypt_pbkdf, as used by OpenSSH. It is equivalent to the implementation
+// internal to golang.org/x/crypto/ssh, but yields to the event loop between
+// hashes, and reports progress as the fraction of hashes computed.
+func bcryptPBKDF(passphrase, salt []byte, rounds, keyLen int, progress ProgressFunc) ([]byte, error) {
+	numBlocks := (keyLen + bcryptBlockSize - 1) / bcryptBlockSize
+	key := make([]byte, numBlocks*bcryptBlockSize)
+
+	h := sha512.New()
+	h.Write(passphrase)
+	shapass := h.Sum(nil)
+	defer clear(shapass)
+
+	y := &yielder{}
+	total, done := float64(numBlocks*rounds), 0
+	shasalt := make([]byte, 0, sha512.Size)
+	cnt, tmp, out := make([]byte, 4), make([]byte, bcryptBlockSize), make([]byte, bcryptBlockSize)
+	defer clear(tmp)
+	defer clear(out)
+	for block := 1; block <= numBlocks; block++ {
+		h.Reset()
+		h.Write(salt)
+		binary.BigEndian.PutUint32(cnt, uint32(block))
+		h.Write(cnt)
+		clear(out)
+		for i := 1; i <= rounds; i++ {
+			if i > 1 {
+				h.Reset()
+				h.Write(tmp)
+			}
+			y.maybeYield()
+			if err := bcryptHash(tmp, shapass, h.Sum(shasalt)); err != nil {
+				return nil, err
+			}
+			for j := range out {
+				out[j] ^= tmp[j]
+			}
+			done++
+			progress(float64(done) / total)
+		}
+		for i, v := range out {
+			key[i*numBlocks+(block-1)] = v
+		}
+	}
+	clear(key[keyLen:])
+	return key[:keyLen], nil
+}
+
+// opensshPadding returns the number of bytes of padding at the end of the
+// private section of a key in the OpenSSH format. The section ends with the
+// comment, followed by bytes counting up from 1.
+func opensshPadding(section []byte, comment string) (int, error) {
+	encoded := ssh.Marshal(struct{ Comment string }{comment})
+	for n := 0; n < aes.BlockSize && n <= len(section); n++ {
+		body, pad := section[:len(section)-n], section[len(section)-n:]
+		if bytes.HasSuffix(body, encoded) && checkPadding(pad) {
+			return n, nil
+		}
+	}
+	return 0, errPaddingNotFound
+}
+
+// checkPadding determines if pad holds the bytes counting up from 1.
+func checkPadding(pad []byte) bool {
+	for i, b := range pad {
+		if int(b) != i+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// marshalEncryptedKey is like ssh.MarshalPrivateKeyWithPassphrase, but the
+// key used to encrypt the private key is derived incrementally; see
+// bcryptPBKDF. ssh.MarshalPrivateKeyWithPassphrase would block the agent for
+// as long as the derivation takes.
+func marshalEncryptedKey(priv crypto.PrivateKey, comment string, passphrase []byte, progress ProgressFunc) (*pem.Block, error) {
+	plain, err := ssh.MarshalPrivateKey(priv, comment)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(plain.Bytes)
+	if !bytes.HasPrefix(plain.Bytes, []byte(opensshKeyMagic)) {
+		return nil, fmt.Errorf("%w: not in the OpenSSH format", errMarshalFailed)
+	}
+	var w opensshKey
+	if err := ssh.Unmarshal(plain.Bytes[len(opensshKeyMagic):], &w); err != nil {
+		return nil, err
+	}
+
+	// The unencrypted section is padded to a multiple of 8 bytes, but the
+	// encrypted section must be padded to the block size of AES.
+	n, err := opensshPadding(w.PrivKeyBlock, comment)
+	if err != nil {
+		return nil, err
+	}
+	body := w.PrivKeyBlock[:len(w.PrivKeyBlock)-n]
+	section := make([]byte, len(body), len(body)+aes.BlockSize)
+	copy(section, body)
+	defer clear(section)
+	for i := 0; len(section)%aes.BlockSize != 0; i++ {
+		section = append(section, byte(i+1))
+	}
+
+	opts := struct {
+		Salt   []byte
+		Rounds uint32
+	}{make([]byte, opensshSaltBytes), opensshKDFRounds}
+	if _, err := rand.Read(opts.Salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	k, err := bcryptPBKDF(passphrase, opts.Salt, int(opts.Rounds), 32+aes.BlockSize, progress)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(k)
+	block, err := aes.NewCipher(k[:32])
+	if err != nil {
+		return nil, err
+	}
+	encrypted := make([]byte, len(section))
+	cipher.NewCTR(block, k[32:]).XORKeyStream(encrypted, section)
+
+	w.CipherName = "aes256-ctr"
+	w.KdfName = "bcrypt"
+	w.KdfOpts = string(ssh.Marshal(opts))
+	w.PrivKeyBlock = encrypted
+	return &pem.Block{
+		Type:  plain.Type,
+		Bytes: append([]byte(opensshKeyMagic), ssh.Marshal(w)...),
+	}, nil
+}
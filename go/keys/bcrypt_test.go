@@ -0,0 +1,158 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/pem"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMarshalEncryptedKey(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		key         string
+		comment     string
+	}{
+		{
+			description: "rsa",
+			key:         testdata.WithoutPassphrase.Private,
+			comment:     "some-comment",
+		},
+		{
+			description: "ecdsa",
+			key:         testdata.ECDSAWithoutPassphrase.Private,
+			comment:     "some-comment",
+		},
+		{
+			description: "ed25519",
+			key:         testdata.ED25519WithoutPassphrase.Private,
+			comment:     "some-comment",
+		},
+		{
+			description: "empty comment",
+			key:         testdata.ED25519WithoutPassphrase.Private,
+		},
+		{
+			description: "comment resembling padding",
+			key:         testdata.ED25519WithoutPassphrase.Private,
+			comment:     "\x01\x02\x03",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			priv, err := ssh.ParseRawPrivateKey([]byte(tc.key))
+			if err != nil {
+				t.Fatalf("failed to parse key: %v", err)
+			}
+			// Vary the length of the comment, such that the
+			// unencrypted section is padded by every possible
+			// amount.
+			for i := 0; i < 8; i++ {
+				comment := tc.comment + strings.Repeat("x", i)
+				var progress []float64
+				block, err := marshalEncryptedKey(priv, comment, []byte("secret"), func(p float64) { progress = append(progress, p) })
+				if err != nil {
+					t.Fatalf("failed to marshal key with comment %q: %v", comment, err)
+				}
+
+				// The key can be parsed by the SSH library, which
+				// checks the padding that follows the comment.
+				encoded := pem.EncodeToMemory(block)
+				if _, err := ssh.ParseRawPrivateKey(encoded); err == nil {
+					t.Errorf("key with comment %q parsed without passphrase", comment)
+				}
+				parsed, err := ssh.ParseRawPrivateKeyWithPassphrase(encoded, []byte("secret"))
+				if err != nil {
+					t.Errorf("failed to parse key with comment %q: %v", comment, err)
+					continue
+				}
+				if diff := cmp.Diff(publicKeyBlob(t, parsed), publicKeyBlob(t, priv)); diff != "" {
+					t.Errorf("incorrect key with comment %q; -got +want: %s", comment, diff)
+				}
+
+				// Progress is reported for each hash, through to
+				// completion.
+				if !sort.Float64sAreSorted(progress) || len(progress) != 2*opensshKDFRounds || progress[len(progress)-1] != 1 {
+					t.Errorf("incorrect progress: %v", progress)
+				}
+			}
+		})
+	}
+}
+
+// publicKeyBlob returns the SSH wire encoding of the public key for priv.
+func publicKeyBlob(t *testing.T, priv interface{}) []byte {
+	t.Helper()
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to get signer for key: %v", err)
+	}
+	return signer.PublicKey().Marshal()
+}
+
+func TestMarshalEncryptedKeyYields(t *testing.T) {
+	t.Parallel()
+
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ED25519WithoutPassphrase.Private))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		// Count timer callbacks that run while the key is encrypted. If
+		// encryption blocked the event loop, none would run.
+		ticks := 0
+		stop := false
+		var tick func()
+		tick = func() {
+			if stop {
+				return
+			}
+			ticks++
+			jsutil.SetTimeout(yieldInterval/2, tick)
+		}
+		jsutil.SetTimeout(yieldInterval/2, tick)
+
+		start := time.Now()
+		_, err := marshalEncryptedKey(priv, "some-comment", []byte("secret"), func(float64) {})
+		elapsed := time.Since(start)
+		stop = true
+		if err != nil {
+			t.Errorf("marshalEncryptedKey failed: %v", err)
+			return
+		}
+		// Encryption need not yield if it completes quickly.
+		if elapsed > 2*yieldInterval && ticks == 0 {
+			t.Errorf("key encryption did not yield to the event loop")
+		}
+	})
+}
@@ -0,0 +1,172 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	errInvalidCertificate  = errors.New("invalid certificate")
+	errCertificateMismatch = errors.New("certificate does not match key")
+)
+
+// WithCertificate supplies an OpenSSH certificate for the key, in the format
+// written by ssh-keygen to -cert.pub files. It takes precedence over any
+// certificate included alongside the private key.
+func WithCertificate(cert string) AddOption {
+	return func(o *addOptions) {
+		o.certificate = cert
+	}
+}
+
+// parseCertificate parses an OpenSSH certificate in the format written by
+// ssh-keygen to -cert.pub files.
+func parseCertificate(s string) (*ssh.Certificate, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidCertificate, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%w: public key is not a certificate", errInvalidCertificate)
+	}
+	return cert, nil
+}
+
+// splitCertificate separates any certificate from the private key, such that
+// the contents of a key and its -cert.pub file may be pasted together. The
+// certificate is empty if none is present.
+func splitCertificate(text string) (privateKey, cert string) {
+	var key []string
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if cert == "" {
+			if _, err := parseCertificate(line); err == nil {
+				cert = strings.TrimSpace(line)
+				continue
+			}
+		}
+		key = append(key, line)
+	}
+	return strings.Join(key, ""), cert
+}
+
+// checkCertificate returns an error if the certificate is not for the public
+// key.
+func checkCertificate(cert *ssh.Certificate, pub ssh.PublicKey) error {
+	if !bytes.Equal(cert.Key.Marshal(), pub.Marshal()) {
+		return fmt.Errorf("%w: certificate is for key %s", errCertificateMismatch, ssh.FingerprintSHA256(cert.Key))
+	}
+	return nil
+}
+
+// certificateExpired determines if the certificate is no longer valid at the
+// specified time.
+func certificateExpired(cert *ssh.Certificate, now time.Time) bool {
+	return cert.ValidBefore != ssh.CertTimeInfinity && uint64(now.Unix()) >= cert.ValidBefore
+}
+
+// SetCertificate sets the certificate corresponding to the stored private key.
+// A nil certificate removes any existing one.
+func (s *storedKey) SetCertificate(cert *ssh.Certificate) {
+	if cert == nil {
+		s.Certificate = ""
+		return
+	}
+	s.Certificate = base64.StdEncoding.EncodeToString(cert.Marshal())
+}
+
+// Cert returns the certificate corresponding to the stored private key, or nil
+// if there is none.
+func (s *storedKey) Cert() *ssh.Certificate {
+	if s.Certificate == "" {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s.Certificate)
+	if err != nil {
+		jsutil.LogError("failed to decode certificate for key ID %s: %v", s.ID, err)
+		return nil
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		jsutil.LogError("failed to parse certificate for key ID %s: %v", s.ID, err)
+		return nil
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		jsutil.LogError("stored certificate for key ID %s is not a certificate", s.ID)
+		return nil
+	}
+	return cert
+}
+
+// SetCertificate implements Manager.SetCertificate.
+func (m *DefaultManager) SetCertificate(ctx jsutil.AsyncContext, id ID, cert string) error {
+	var parsed *ssh.Certificate
+	if strings.TrimSpace(cert) != "" {
+		var err error
+		if parsed, err = parseCertificate(cert); err != nil {
+			return err
+		}
+	}
+
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(key *storedKey) (*storedKey, error) {
+		if key.IsSecurityKey() {
+			return nil, fmt.Errorf("%w: certificates are not supported", errSecurityKeyOperation)
+		}
+		if key.IsCryptoKey() {
+			return nil, fmt.Errorf("%w: certificates are not supported", errCryptoKeyOperation)
+		}
+		// If the public key is not yet known, the certificate is
+		// checked when the key is loaded.
+		if pub := key.Public(); parsed != nil && pub != nil {
+			if err := checkCertificate(parsed, pub); err != nil {
+				return nil, err
+			}
+		}
+		updated := *key
+		updated.SetCertificate(parsed)
+		return &updated, nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+
+	// If the key is loaded, replace it in the agent using the decrypted
+	// key from the session, such that the passphrase is not required.
+	sk, err := m.sessionKeys.Read(ctx, func(sk *sessionKey) bool { return ID(sk.ID) == id })
+	if err != nil {
+		return fmt.Errorf("failed to read session key: %w", err)
+	}
+	if sk == nil {
+		return nil
+	}
+	key := m.sessionPrivateKey(sk)
+	defer key.wipe()
+	return m.replaceInAgent(ctx, id, key, sk.Comment, parsed)
+}
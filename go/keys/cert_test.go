@@ -0,0 +1,389 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// newCertificate returns a user certificate for the public key of the
+// private key, valid until the specified time, in the format written to
+// -cert.pub files.
+func newCertificate(privateKey, passphrase string, validBefore time.Time) string {
+	priv, err := ssh.ParseRawPrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+	if passphrase == "" {
+		priv, err = ssh.ParseRawPrivateKey([]byte(privateKey))
+	}
+	if err != nil {
+		panic(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(err)
+	}
+
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		panic(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "test-cert",
+		ValidPrincipals: []string{"user"},
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		panic(err)
+	}
+	return string(ssh.MarshalAuthorizedKey(cert))
+}
+
+// loadedKeyTypes returns the sorted types of the loaded keys.
+func loadedKeyTypes(keys []*LoadedKey) []string {
+	var result []string
+	for _, k := range keys {
+		result = append(result, k.Type)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestSplitCertificate(t *testing.T) {
+	t.Parallel()
+
+	key := testdata.ED25519WithoutPassphrase.Private
+	cert := newCertificate(key, "", time.Now().Add(time.Hour))
+
+	testcases := []struct {
+		description string
+		text        string
+		wantKey     string
+		wantCert    string
+	}{
+		{
+			description: "key only",
+			text:        key,
+			wantKey:     key,
+		},
+		{
+			description: "certificate follows key",
+			text:        key + "\n" + cert,
+			wantKey:     key + "\n",
+			wantCert:    cert[:len(cert)-1],
+		},
+		{
+			description: "certificate precedes key",
+			text:        cert + key,
+			wantKey:     key,
+			wantCert:    cert[:len(cert)-1],
+		},
+		{
+			description: "public key is not a certificate",
+			text:        key + "\nssh-ed25519 " + testdata.ED25519WithoutPassphrase.Blob,
+			wantKey:     key + "\nssh-ed25519 " + testdata.ED25519WithoutPassphrase.Blob,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			gotKey, gotCert := splitCertificate(tc.text)
+			if diff := cmp.Diff(gotKey, tc.wantKey); diff != "" {
+				t.Errorf("incorrect key; -got +want: %s", diff)
+			}
+			if diff := cmp.Diff(gotCert, tc.wantCert); diff != "" {
+				t.Errorf("incorrect certificate; -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestAddCertificate(t *testing.T) {
+	t.Parallel()
+
+	validBefore := time.Now().Add(time.Hour).Truncate(time.Second)
+	key := testdata.ED25519WithoutPassphrase.Private
+	cert := newCertificate(key, "", validBefore)
+
+	testcases := []struct {
+		description string
+		privateKey  string
+		opts        []AddOption
+		want        *ConfiguredKey
+		wantErr     error
+	}{
+		{
+			description: "no certificate",
+			privateKey:  key,
+			want:        &ConfiguredKey{Type: ssh.KeyAlgoED25519, Bits: 256},
+		},
+		{
+			description: "certificate included with key",
+			privateKey:  key + "\n" + cert,
+			want: &ConfiguredKey{
+				Type:                         ssh.KeyAlgoED25519,
+				Bits:                         256,
+				HasCertificate:               true,
+				CertificateValidBeforeMillis: validBefore.UnixMilli(),
+				CertificatePrincipals:        []string{"user"},
+				ExpiresAtMillis:              validBefore.UnixMilli(),
+			},
+		},
+		{
+			description: "certificate supplied as option",
+			privateKey:  key,
+			opts:        []AddOption{WithCertificate(cert)},
+			want: &ConfiguredKey{
+				Type:                         ssh.KeyAlgoED25519,
+				Bits:                         256,
+				HasCertificate:               true,
+				CertificateValidBeforeMillis: validBefore.UnixMilli(),
+				CertificatePrincipals:        []string{"user"},
+				ExpiresAtMillis:              validBefore.UnixMilli(),
+			},
+		},
+		{
+			description: "expired certificate",
+			privateKey:  key,
+			opts:        []AddOption{WithCertificate(newCertificate(key, "", validBefore.Add(-2*time.Hour)))},
+			want: &ConfiguredKey{
+				Type:                         ssh.KeyAlgoED25519,
+				Bits:                         256,
+				HasCertificate:               true,
+				CertificateValidBeforeMillis: validBefore.Add(-2 * time.Hour).UnixMilli(),
+				CertificatePrincipals:        []string{"user"},
+				CertificateExpired:           true,
+				ExpiresAtMillis:              validBefore.Add(-2 * time.Hour).UnixMilli(),
+				Expired:                      true,
+			},
+		},
+		{
+			description: "certificate for other key",
+			privateKey:  key,
+			opts:        []AddOption{WithCertificate(newCertificate(testdata.ECDSAWithoutPassphrase.Private, "", validBefore))},
+			wantErr:     errCertificateMismatch,
+		},
+		{
+			description: "invalid certificate",
+			privateKey:  key,
+			opts:        []AddOption{WithCertificate("ssh-ed25519 " + testdata.ED25519WithoutPassphrase.Blob)},
+			wantErr:     errInvalidCertificate,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				syncStorage := storage.NewRaw(st.NewMemArea())
+				sessionStorage := storage.NewRaw(st.NewMemArea())
+				mgr := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+
+				err := mgr.Add(ctx, "some-key", tc.privateKey, tc.opts...)
+				if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("incorrect error; -got +want: %s", diff)
+				}
+				configured, err := mgr.Configured(ctx)
+				if err != nil {
+					t.Errorf("failed to get configured keys: %v", err)
+					return
+				}
+				var got *ConfiguredKey
+				if len(configured) > 0 {
+					got = configured[0]
+				}
+				ignored := cmpopts.IgnoreFields(ConfiguredKey{}, "ID", "Name", "Format", "FingerprintSHA256", "FingerprintMD5")
+				if diff := cmp.Diff(got, tc.want, ignored); diff != "" {
+					t.Errorf("incorrect configured key; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestLoadCertificate(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+
+		key := testdata.OpenSSHFormat
+		cert := newCertificate(key.Private, key.Passphrase, time.Now().Add(time.Hour))
+		if err := mgr.Add(ctx, "some-key", key.Private+"\n"+cert); err != nil {
+			t.Errorf("failed to add key: %v", err)
+			return
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Errorf("failed to find key: %v", err)
+			return
+		}
+		if err := mgr.Load(ctx, id, key.Passphrase); err != nil {
+			t.Errorf("failed to load key: %v", err)
+			return
+		}
+
+		withCert := []string{key.Type, key.Type + "-cert-v01@openssh.com"}
+		for _, step := range []struct {
+			description string
+			do          func() error
+			wantTypes   []string
+		}{
+			{
+				description: "key and certificate loaded",
+				wantTypes:   withCert,
+			},
+			{
+				description: "certificate replaced without passphrase",
+				do: func() error {
+					cert = newCertificate(key.Private, key.Passphrase, time.Now().Add(2*time.Hour))
+					return mgr.SetCertificate(ctx, id, cert)
+				},
+				wantTypes: withCert,
+			},
+			{
+				description: "certificate loaded after restart",
+				do: func() error {
+					mgr = NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+					return mgr.LoadFromSession(ctx)
+				},
+				wantTypes: withCert,
+			},
+			{
+				description: "certificate removed",
+				do: func() error {
+					return mgr.SetCertificate(ctx, id, "")
+				},
+				wantTypes: []string{key.Type},
+			},
+			{
+				description: "certificate added",
+				do: func() error {
+					return mgr.SetCertificate(ctx, id, cert)
+				},
+				wantTypes: withCert,
+			},
+			{
+				description: "key and certificate unloaded",
+				do: func() error {
+					return mgr.Unload(ctx, id)
+				},
+			},
+		} {
+			if step.do != nil {
+				if err := step.do(); err != nil {
+					t.Errorf("%s: failed: %v", step.description, err)
+					return
+				}
+			}
+			loaded, err := mgr.Loaded(ctx)
+			if err != nil {
+				t.Errorf("%s: failed to get loaded keys: %v", step.description, err)
+				return
+			}
+			if diff := cmp.Diff(loadedKeyTypes(loaded), step.wantTypes); diff != "" {
+				t.Errorf("%s: incorrect loaded keys; -got +want: %s", step.description, diff)
+			}
+			for _, l := range loaded {
+				if l.ID() != id {
+					t.Errorf("%s: incorrect ID for loaded key; got %s, want %s", step.description, l.ID(), id)
+				}
+			}
+		}
+
+		// The certificate loaded into the agent is the replacement.
+		if err := mgr.Load(ctx, id, key.Passphrase); err != nil {
+			t.Errorf("failed to load key: %v", err)
+			return
+		}
+		wantCert, err := parseCertificate(cert)
+		if err != nil {
+			t.Errorf("failed to parse certificate: %v", err)
+			return
+		}
+		loaded, err := mgr.Loaded(ctx)
+		if err != nil {
+			t.Errorf("failed to get loaded keys: %v", err)
+			return
+		}
+		if diff := cmp.Diff(loadedKeyBlobs(loaded), []string{key.Blob, base64.StdEncoding.EncodeToString(wantCert.Marshal())}, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+			t.Errorf("incorrect loaded blobs; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestLoadMismatchedCertificate(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+
+		// The public key of an encrypted PKCS#1 key is not known until
+		// it is loaded, so the certificate cannot be checked when it is
+		// added.
+		key := testdata.WithPassphrase
+		cert := newCertificate(testdata.ECDSAWithoutPassphrase.Private, "", time.Now().Add(time.Hour))
+		if err := mgr.Add(ctx, "some-key", key.Private, WithCertificate(cert)); err != nil {
+			t.Errorf("failed to add key: %v", err)
+			return
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Errorf("failed to find key: %v", err)
+			return
+		}
+
+		err = mgr.Load(ctx, id, key.Passphrase)
+		if diff := cmp.Diff(err, errCertificateMismatch, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+		loaded, err := mgr.Loaded(ctx)
+		if err != nil {
+			t.Errorf("failed to get loaded keys: %v", err)
+			return
+		}
+		if len(loaded) != 0 {
+			t.Errorf("incorrect loaded keys; got %d, want 0", len(loaded))
+		}
+	})
+}
@@ -19,7 +19,10 @@ package keys
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"syscall/js"
+	"time"
 
 	"github.com/google/chrome-ssh-agent/go/jsutil"
 	"github.com/google/chrome-ssh-agent/go/message"
@@ -30,6 +33,9 @@ import (
 // instance can be invoked from a different page.
 type Server struct {
 	mgr Manager
+	// progress is used to broadcast the progress of long-running
+	// operations, or is nil if it is not reported. See SetProgress.
+	progress message.Sender
 }
 
 // NewServer returns a new Server that manages keys using the
@@ -41,6 +47,14 @@ func NewServer(mgr Manager) *Server {
 	return result
 }
 
+// SetProgress sets the Sender used to broadcast the progress of long-running
+// operations, such as generating keys, to the clients that requested them.
+// Clients receive it using a ProgressReceiver. If none is set, clients are
+// only notified once an operation completes.
+func (s *Server) SetProgress(msg message.Sender) {
+	s.progress = msg
+}
+
 // Define a distinct type for each message.  These are embedded in each
 // message.
 const (
@@ -57,6 +71,131 @@ const (
 	msgTypeUnload
 	msgTypeUnloadRsp
 	msgTypeErrorRsp
+	msgTypeGenerate
+	msgTypeGenerateRsp
+	msgTypeChangePassphrase
+	msgTypeChangePassphraseRsp
+	msgTypeRename
+	msgTypeRenameRsp
+	msgTypeSetComment
+	msgTypeSetCommentRsp
+	msgTypeRemoveAll
+	msgTypeRemoveAllRsp
+	msgTypeLoadAll
+	msgTypeLoadAllRsp
+	msgTypeSetAutoLoad
+	msgTypeSetAutoLoadRsp
+	msgTypeLoadAutoKeys
+	msgTypeLoadAutoKeysRsp
+	msgTypeSetIdleTimeout
+	msgTypeSetIdleTimeoutRsp
+	msgTypeDefaultIdleTimeout
+	msgTypeDefaultIdleTimeoutRsp
+	msgTypeSetDefaultIdleTimeout
+	msgTypeSetDefaultIdleTimeoutRsp
+	msgTypeIdleUnloaded
+	msgTypeSetConfirmUse
+	msgTypeSetConfirmUseRsp
+	msgTypeSetCertificate
+	msgTypeSetCertificateRsp
+	msgTypeGenerateSecurityKey
+	msgTypeGenerateSecurityKeyRsp
+	msgTypeSetTags
+	msgTypeSetTagsRsp
+	msgTypeLoadByTag
+	msgTypeLoadByTagRsp
+	msgTypeLoadMatching
+	msgTypeLoadMatchingRsp
+	msgTypePersistSession
+	msgTypePersistSessionRsp
+	msgTypeSetPersistSession
+	msgTypeSetPersistSessionRsp
+	msgTypeLocked
+	msgTypeLockedRsp
+	msgTypeLock
+	msgTypeLockRsp
+	msgTypeUnlock
+	msgTypeUnlockRsp
+	msgTypeSetRefuseSHA1
+	msgTypeSetRefuseSHA1Rsp
+	msgTypeGenerateCryptoKey
+	msgTypeGenerateCryptoKeyRsp
+	msgTypeLoadEphemeral
+	msgTypeLoadEphemeralRsp
+	msgTypeSetDestinations
+	msgTypeSetDestinationsRsp
+	msgTypeAuditLog
+	msgTypeAuditLogRsp
+	msgTypeClearAuditLog
+	msgTypeClearAuditLogRsp
+	msgTypeAuditLogEnabled
+	msgTypeAuditLogEnabledRsp
+	msgTypeSetAuditLogEnabled
+	msgTypeSetAuditLogEnabledRsp
+	msgTypeImport
+	msgTypeImportRsp
+	msgTypePublicKeys
+	msgTypePublicKeysRsp
+	msgTypeChanged
+	msgTypeDefaultKeyEncryption
+	msgTypeDefaultKeyEncryptionRsp
+	msgTypeSetDefaultKeyEncryption
+	msgTypeSetDefaultKeyEncryptionRsp
+	msgTypeSetKeyEncryption
+	msgTypeSetKeyEncryptionRsp
+	msgTypeReencryptAll
+	msgTypeReencryptAllRsp
+	msgTypeMasterKeyState
+	msgTypeMasterKeyStateRsp
+	msgTypeEnableMasterKey
+	msgTypeEnableMasterKeyRsp
+	msgTypeDisableMasterKey
+	msgTypeDisableMasterKeyRsp
+	msgTypeUnlockMasterKey
+	msgTypeUnlockMasterKeyRsp
+	msgTypeLockMasterKey
+	msgTypeLockMasterKeyRsp
+	msgTypeSetExpiry
+	msgTypeSetExpiryRsp
+	msgTypeAllowExpired
+	msgTypeAllowExpiredRsp
+	msgTypeSetAllowExpired
+	msgTypeSetAllowExpiredRsp
+	msgTypeAddBatch
+	msgTypeAddBatchRsp
+	msgTypeAvailable
+	msgTypeAvailableRsp
+	msgTypeStartRotation
+	msgTypeStartRotationRsp
+	msgTypeCompleteRotation
+	msgTypeCompleteRotationRsp
+	msgTypeMaxLoadedKeys
+	msgTypeMaxLoadedKeysRsp
+	msgTypeSetMaxLoadedKeys
+	msgTypeSetMaxLoadedKeysRsp
+	msgTypeKeyOrder
+	msgTypeKeyOrderRsp
+	msgTypeSetKeyOrder
+	msgTypeSetKeyOrderRsp
+	msgTypeSetPriority
+	msgTypeSetPriorityRsp
+	msgTypeProtocolKeyPolicy
+	msgTypeProtocolKeyPolicyRsp
+	msgTypeSetProtocolKeyPolicy
+	msgTypeSetProtocolKeyPolicyRsp
+	msgTypeAllowedClients
+	msgTypeAllowedClientsRsp
+	msgTypeAllowClient
+	msgTypeAllowClientRsp
+	msgTypeDisallowClient
+	msgTypeDisallowClientRsp
+	msgTypeNativeHosts
+	msgTypeNativeHostsRsp
+	msgTypeAllowNativeHost
+	msgTypeAllowNativeHostRsp
+	msgTypeDisallowNativeHost
+	msgTypeDisallowNativeHostRsp
+	msgTypeProgress
 )
 
 // msgHeader are the common fields included in every message.
@@ -65,7 +204,8 @@ type msgHeader struct {
 }
 
 type msgConfigured struct {
-	Type int `js:"type"`
+	Type int    `js:"type"`
+	Tag  string `js:"tag"`
 }
 
 type rspConfigured struct {
@@ -84,10 +224,23 @@ type rspLoaded struct {
 	Err  string       `js:"err"`
 }
 
+type msgAvailable struct {
+	Type int `js:"type"`
+}
+
+type rspAvailable struct {
+	Type int             `js:"type"`
+	Keys []*AvailableKey `js:"keys"`
+	Err  string          `js:"err"`
+}
+
 type msgAdd struct {
-	Type          int    `js:"type"`
-	Name          string `js:"name"`
-	PEMPrivateKey string `js:"pemPrivateKey"`
+	Type              int    `js:"type"`
+	Name              string `js:"name"`
+	PEMPrivateKey     string `js:"pemPrivateKey"`
+	AllowDuplicate    bool   `js:"allowDuplicate"`
+	Certificate       string `js:"certificate"`
+	ExpectedPublicKey string `js:"expectedPublicKey"`
 }
 
 type rspAdd struct {
@@ -95,6 +248,77 @@ type rspAdd struct {
 	Err  string `js:"err"`
 }
 
+type msgImport struct {
+	Type              int    `js:"type"`
+	Name              string `js:"name"`
+	Text              string `js:"text"`
+	AllowDuplicate    bool   `js:"allowDuplicate"`
+	ExpectedPublicKey string `js:"expectedPublicKey"`
+}
+
+type rspImport struct {
+	Type    int             `js:"type"`
+	Results []*ImportResult `js:"results"`
+	Err     string          `js:"err"`
+}
+
+type msgAddBatch struct {
+	Type  int        `js:"type"`
+	Items []*AddItem `js:"items"`
+}
+
+type rspAddBatch struct {
+	Type    int          `js:"type"`
+	Results []*AddResult `js:"results"`
+	Err     string       `js:"err"`
+}
+
+type msgGenerate struct {
+	Type       int    `js:"type"`
+	Name       string `js:"name"`
+	KeyType    string `js:"keyType"`
+	Bits       int    `js:"bits"`
+	Passphrase string `js:"passphrase"`
+	// Request identifies the request in progress messages, or is empty
+	// if progress is not reported.
+	Request string `js:"request"`
+}
+
+type rspGenerate struct {
+	Type      int    `js:"type"`
+	PublicKey string `js:"publicKey"`
+	Err       string `js:"err"`
+}
+
+type msgStartRotation struct {
+	Type       int    `js:"type"`
+	ID         string `js:"id"`
+	Name       string `js:"name"`
+	KeyType    string `js:"keyType"`
+	Bits       int    `js:"bits"`
+	Passphrase string `js:"passphrase"`
+	// Request identifies the request in progress messages, or is empty
+	// if progress is not reported.
+	Request string `js:"request"`
+}
+
+type rspStartRotation struct {
+	Type      int    `js:"type"`
+	PublicKey string `js:"publicKey"`
+	Err       string `js:"err"`
+}
+
+type msgCompleteRotation struct {
+	Type   int    `js:"type"`
+	ID     string `js:"id"`
+	Remove bool   `js:"remove"`
+}
+
+type rspCompleteRotation struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
 type msgRemove struct {
 	Type int    `js:"type"`
 	ID   string `js:"id"`
@@ -126,243 +350,3126 @@ type rspUnload struct {
 	Err  string `js:"err"`
 }
 
-type rspError struct {
+type msgChangePassphrase struct {
+	Type          int    `js:"type"`
+	ID            string `js:"id"`
+	OldPassphrase string `js:"oldPassphrase"`
+	NewPassphrase string `js:"newPassphrase"`
+}
+
+type rspChangePassphrase struct {
 	Type int    `js:"type"`
 	Err  string `js:"err"`
 }
 
-// makeErr converts a string to an error. Empty string returns nil (i.e., no
-// error).
-func makeErr(s string) error {
-	if s == "" {
-		return nil
-	}
-	return errors.New(s)
+type msgRename struct {
+	Type    int    `js:"type"`
+	ID      string `js:"id"`
+	NewName string `js:"newName"`
 }
 
-// makeErrStr converts an error to a string. A nil error is converted to the
-// empty string.
-func makeErrStr(err error) string {
-	if err == nil {
-		return ""
-	}
-	return err.Error()
+type rspRename struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
 }
 
-// makeErrorResponse produces a generic error response that can be sent to the
-// client. This is used in case a more specific error is not possible.
-func (s *Server) makeErrorResponse(err error) js.Value {
-	jsutil.LogError("Server.makeErrorResponse: %v", err)
-	rsp := rspError{
-		Type: msgTypeErrorRsp,
-		Err:  makeErrStr(err),
-	}
-	return vert.ValueOf(rsp).JSValue()
+type msgSetComment struct {
+	Type       int    `js:"type"`
+	ID         string `js:"id"`
+	Comment    string `js:"comment"`
+	Passphrase string `js:"passphrase"`
 }
 
-// OnMessage is the callback invoked when a message is received. It determines
-// the type of request received, invokes the appropriate method on the
-// underlying manager instance, and then returns the response to be sent to the
-// client.
-func (s *Server) OnMessage(ctx jsutil.AsyncContext, headerObj js.Value, _ js.Value) js.Value {
-	var header msgHeader
-	if err := vert.ValueOf(headerObj).AssignTo(&header); err != nil {
-		return s.makeErrorResponse(fmt.Errorf("failed to parse message header: %w", err))
+type rspSetComment struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+// wireResult is the representation of a Result sent in a message.
+type wireResult struct {
+	ID  string `js:"id"`
+	Err string `js:"err"`
+}
+
+func makeWireResults(results []*Result) []*wireResult {
+	var res []*wireResult
+	for _, r := range results {
+		res = append(res, &wireResult{ID: string(r.ID), Err: makeErrStr(r.Err)})
 	}
+	return res
+}
 
-	jsutil.LogDebug("Server.OnMessage(type = %d)", header.Type)
-	switch header.Type {
-	case msgTypeConfigured:
-		jsutil.LogDebug("Server.OnMessage(Configured req)")
-		keys, err := s.mgr.Configured(ctx)
-		jsutil.LogDebug("Server.OnMessage(Configured rsp): %d keys, err=%v", len(keys), err)
-		rsp := rspConfigured{
-			Type: msgTypeConfiguredRsp,
-			Keys: keys,
-			Err:  makeErrStr(err),
-		}
-		return vert.ValueOf(rsp).JSValue()
-	case msgTypeLoaded:
-		jsutil.LogDebug("Server.OnMessage(Loaded req)")
-		keys, err := s.mgr.Loaded(ctx)
-		jsutil.LogDebug("Server.OnMessage(Loaded rsp): %d keys, err=%v", len(keys), err)
-		rsp := rspLoaded{
-			Type: msgTypeLoadedRsp,
-			Keys: keys,
-			Err:  makeErrStr(err),
-		}
-		return vert.ValueOf(rsp).JSValue()
-	case msgTypeAdd:
-		var m msgAdd
-		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
-			return s.makeErrorResponse(fmt.Errorf("failed to parse Add message: %w", err))
-		}
-		jsutil.LogDebug("Server.OnMessage(Add req): name=%s", m.Name)
-		err := s.mgr.Add(ctx, m.Name, m.PEMPrivateKey)
-		rsp := rspAdd{
-			Type: msgTypeAddRsp,
-			Err:  makeErrStr(err),
-		}
-		jsutil.LogDebug("Server.OnMessage(Add rsp): err=%v", err)
-		return vert.ValueOf(rsp).JSValue()
-	case msgTypeRemove:
-		var m msgRemove
-		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
-			return s.makeErrorResponse(fmt.Errorf("failed to parse Remove message: %w", err))
-		}
-		jsutil.LogDebug("Server.OnMessage(Remove req): id=%s", m.ID)
-		err := s.mgr.Remove(ctx, ID(m.ID))
-		rsp := rspRemove{
-			Type: msgTypeRemoveRsp,
-			Err:  makeErrStr(err),
-		}
-		jsutil.LogDebug("Server.OnMessage(Remove rsp): err=%v", err)
-		return vert.ValueOf(rsp).JSValue()
-	case msgTypeLoad:
-		var m msgLoad
-		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
-			return s.makeErrorResponse(fmt.Errorf("failed to parse Load message: %w", err))
-		}
-		jsutil.LogDebug("Server.OnMessage(Load req): id=%s", m.ID)
-		err := s.mgr.Load(ctx, ID(m.ID), m.Passphrase)
-		rsp := rspLoad{
-			Type: msgTypeLoadRsp,
-			Err:  makeErrStr(err),
-		}
-		jsutil.LogDebug("Server.OnMessage(Load rsp): err=%v", err)
-		return vert.ValueOf(rsp).JSValue()
-	case msgTypeUnload:
-		var m msgUnload
-		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
-			return s.makeErrorResponse(fmt.Errorf("failed to parse Unload message: %w", err))
-		}
-		jsutil.LogDebug("Server.OnMessage(Unload req): id=%s", m.ID)
-		err := s.mgr.Unload(ctx, ID(m.ID))
-		rsp := rspUnload{
-			Type: msgTypeUnloadRsp,
-			Err:  makeErrStr(err),
-		}
-		jsutil.LogDebug("Server.OnMessage(Unload rsp): err=%v", err)
-		return vert.ValueOf(rsp).JSValue()
-	default:
-		return s.makeErrorResponse(fmt.Errorf("received invalid message type: %d", header.Type))
+func makeResults(results []*wireResult) []*Result {
+	var res []*Result
+	for _, r := range results {
+		res = append(res, &Result{ID: ID(r.ID), Err: makeErr(r.Err)})
 	}
+	return res
 }
 
-// client implements the Manager interface and forwards calls to a Server.
-type client struct {
-	msg message.Sender
+type msgRemoveAll struct {
+	Type int      `js:"type"`
+	IDs  []string `js:"ids"`
 }
 
-// NewClient returns a Manager implementation that forwards calls to a Server.
-func NewClient(msg message.Sender) Manager {
-	return &client{msg: msg}
+type rspRemoveAll struct {
+	Type    int           `js:"type"`
+	Results []*wireResult `js:"results"`
+	Err     string        `js:"err"`
 }
 
-// Configured implements Manager.Configured.
-func (c *client) Configured(ctx jsutil.AsyncContext) ([]*ConfiguredKey, error) {
-	var msg msgConfigured
-	msg.Type = msgTypeConfigured
-	jsutil.LogDebug("Client.Configured(req)")
-	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
-	jsutil.LogDebug("Client.Configured(rsp)")
-	if err != nil {
-		return nil, fmt.Errorf("failed to send message: %w", err)
-	}
-	var rsp rspConfigured
-	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
-	}
-	return rsp.Keys, makeErr(rsp.Err)
+// wireLoadRequest is the representation of a LoadRequest sent in a message.
+type wireLoadRequest struct {
+	ID         string `js:"id"`
+	Passphrase string `js:"passphrase"`
 }
 
-// Loaded implements Manager.Loaded.
-func (c *client) Loaded(ctx jsutil.AsyncContext) ([]*LoadedKey, error) {
-	var msg msgLoaded
-	msg.Type = msgTypeLoaded
-	jsutil.LogDebug("Client.Loaded(req)")
-	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
-	jsutil.LogDebug("Client.Loaded(rsp)")
-	if err != nil {
-		return nil, fmt.Errorf("failed to send message: %w", err)
-	}
-	var rsp rspLoaded
-	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+func makeWireLoadRequests(reqs []*LoadRequest) []*wireLoadRequest {
+	var res []*wireLoadRequest
+	for _, r := range reqs {
+		res = append(res, &wireLoadRequest{ID: string(r.ID), Passphrase: r.Passphrase})
 	}
-	return rsp.Keys, makeErr(rsp.Err)
+	return res
 }
 
-// Add implements Manager.Add.
-func (c *client) Add(ctx jsutil.AsyncContext, name string, pemPrivateKey string) error {
-	var msg msgAdd
-	msg.Type = msgTypeAdd
-	msg.Name = name
-	msg.PEMPrivateKey = pemPrivateKey
-	jsutil.LogDebug("Client.Add(req): name=%s", msg.Name)
-	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
-	jsutil.LogDebug("Client.Add(rsp)")
-	if err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
-	}
-	var rsp rspAdd
-	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
-		return fmt.Errorf("failed to parse response: %w", err)
+func makeLoadRequests(reqs []*wireLoadRequest) []*LoadRequest {
+	var res []*LoadRequest
+	for _, r := range reqs {
+		res = append(res, &LoadRequest{ID: ID(r.ID), Passphrase: r.Passphrase})
 	}
-	return makeErr(rsp.Err)
+	return res
 }
 
-// Remove implements Manager.Remove.
-func (c *client) Remove(ctx jsutil.AsyncContext, id ID) error {
-	var msg msgRemove
-	msg.Type = msgTypeRemove
-	msg.ID = string(id)
-	jsutil.LogDebug("Client.Remove(req): id=%s", msg.ID)
-	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
-	jsutil.LogDebug("Client.Remove(rsp)")
-	if err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
-	}
-	var rsp rspRemove
-	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
-		return fmt.Errorf("failed to parse response: %w", err)
-	}
-	return makeErr(rsp.Err)
+type msgLoadAll struct {
+	Type     int                `js:"type"`
+	Requests []*wireLoadRequest `js:"requests"`
 }
 
-// Load implements Manager.Load.
-func (c *client) Load(ctx jsutil.AsyncContext, id ID, passphrase string) error {
-	var msg msgLoad
-	msg.Type = msgTypeLoad
-	msg.ID = string(id)
-	msg.Passphrase = passphrase
-	jsutil.LogDebug("Client.Load(req): id=%s", msg.ID)
-	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
-	jsutil.LogDebug("Client.Load(rsp)")
-	if err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
-	}
-	var rsp rspLoad
-	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
-		return fmt.Errorf("failed to parse response: %w", err)
-	}
-	return makeErr(rsp.Err)
+type rspLoadAll struct {
+	Type    int           `js:"type"`
+	Results []*wireResult `js:"results"`
+	Err     string        `js:"err"`
 }
 
-// Unload implements Manager.Unload.
-func (c *client) Unload(ctx jsutil.AsyncContext, id ID) error {
-	var msg msgUnload
-	msg.Type = msgTypeUnload
-	msg.ID = string(id)
-	jsutil.LogDebug("Client.Unload(req): id=%s", msg.ID)
-	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
-	jsutil.LogDebug("Client.Unload(rsp)")
-	if err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
-	}
-	var rsp rspUnload
-	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
-		return fmt.Errorf("failed to parse response: %w", err)
-	}
-	return makeErr(rsp.Err)
+type msgSetAutoLoad struct {
+	Type     int    `js:"type"`
+	ID       string `js:"id"`
+	AutoLoad bool   `js:"autoLoad"`
+}
+
+type rspSetAutoLoad struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgLoadAutoKeys struct {
+	Type int `js:"type"`
+}
+
+type rspLoadAutoKeys struct {
+	Type    int           `js:"type"`
+	Results []*wireResult `js:"results"`
+	Err     string        `js:"err"`
+}
+
+// Timeouts are sent in milliseconds.
+
+type msgSetIdleTimeout struct {
+	Type       int    `js:"type"`
+	ID         string `js:"id"`
+	Timeout    int64  `js:"timeout"`
+	UseDefault bool   `js:"useDefault"`
+}
+
+type rspSetIdleTimeout struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgDefaultIdleTimeout struct {
+	Type int `js:"type"`
+}
+
+type rspDefaultIdleTimeout struct {
+	Type    int    `js:"type"`
+	Timeout int64  `js:"timeout"`
+	Err     string `js:"err"`
+}
+
+type msgSetDefaultIdleTimeout struct {
+	Type    int   `js:"type"`
+	Timeout int64 `js:"timeout"`
+}
+
+type rspSetDefaultIdleTimeout struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+// msgIdleUnloaded is broadcast when keys are unloaded because they were idle.
+// No response is expected.
+type msgIdleUnloaded struct {
+	Type int      `js:"type"`
+	IDs  []string `js:"ids"`
+}
+
+type msgSetConfirmUse struct {
+	Type       int    `js:"type"`
+	ID         string `js:"id"`
+	ConfirmUse bool   `js:"confirmUse"`
+}
+
+type rspSetConfirmUse struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgSetRefuseSHA1 struct {
+	Type   int    `js:"type"`
+	ID     string `js:"id"`
+	Refuse bool   `js:"refuse"`
+}
+
+type rspSetRefuseSHA1 struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgSetPriority struct {
+	Type     int    `js:"type"`
+	ID       string `js:"id"`
+	Priority int    `js:"priority"`
+}
+
+type rspSetPriority struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgSetDestinations struct {
+	Type               int      `js:"type"`
+	ID                 string   `js:"id"`
+	Destinations       []string `js:"destinations"`
+	RequireSessionBind bool     `js:"requireSessionBind"`
+}
+
+type rspSetDestinations struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgAuditLog struct {
+	Type int `js:"type"`
+}
+
+type rspAuditLog struct {
+	Type    int           `js:"type"`
+	Entries []*AuditEntry `js:"entries"`
+	Err     string        `js:"err"`
+}
+
+type msgPublicKeys struct {
+	Type int `js:"type"`
+}
+
+type rspPublicKeys struct {
+	Type    int               `js:"type"`
+	Entries []*PublicKeyEntry `js:"entries"`
+	Err     string            `js:"err"`
+}
+
+type changeEvent struct {
+	Kind int    `js:"kind"`
+	ID   string `js:"id"`
+}
+
+type msgChanged struct {
+	Type   int            `js:"type"`
+	Events []*changeEvent `js:"events"`
+}
+
+type msgProgress struct {
+	Type     int     `js:"type"`
+	Request  string  `js:"request"`
+	Progress float64 `js:"progress"`
+}
+
+type msgDefaultKeyEncryption struct {
+	Type int `js:"type"`
+}
+
+type rspDefaultKeyEncryption struct {
+	Type       int    `js:"type"`
+	Encryption string `js:"encryption"`
+	Err        string `js:"err"`
+}
+
+type msgSetDefaultKeyEncryption struct {
+	Type       int    `js:"type"`
+	Encryption string `js:"encryption"`
+}
+
+type rspSetDefaultKeyEncryption struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgSetKeyEncryption struct {
+	Type       int    `js:"type"`
+	ID         string `js:"id"`
+	Encryption string `js:"encryption"`
+	Passphrase string `js:"passphrase"`
+}
+
+type rspSetKeyEncryption struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgReencryptAll struct {
+	Type       int    `js:"type"`
+	Passphrase string `js:"passphrase"`
+}
+
+type rspReencryptAll struct {
+	Type    int           `js:"type"`
+	Results []*wireResult `js:"results"`
+	Err     string        `js:"err"`
+}
+
+type msgMasterKeyState struct {
+	Type int `js:"type"`
+}
+
+type rspMasterKeyState struct {
+	Type  int    `js:"type"`
+	State string `js:"state"`
+	Err   string `js:"err"`
+}
+
+type msgEnableMasterKey struct {
+	Type             int                `js:"type"`
+	MasterPassphrase string             `js:"masterPassphrase"`
+	Requests         []*wireLoadRequest `js:"requests"`
+}
+
+type rspEnableMasterKey struct {
+	Type    int           `js:"type"`
+	Results []*wireResult `js:"results"`
+	Err     string        `js:"err"`
+}
+
+type msgDisableMasterKey struct {
+	Type             int                `js:"type"`
+	MasterPassphrase string             `js:"masterPassphrase"`
+	Requests         []*wireLoadRequest `js:"requests"`
+}
+
+type rspDisableMasterKey struct {
+	Type    int           `js:"type"`
+	Results []*wireResult `js:"results"`
+	Err     string        `js:"err"`
+}
+
+type msgUnlockMasterKey struct {
+	Type             int    `js:"type"`
+	MasterPassphrase string `js:"masterPassphrase"`
+}
+
+type rspUnlockMasterKey struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgLockMasterKey struct {
+	Type int `js:"type"`
+}
+
+type rspLockMasterKey struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgSetExpiry struct {
+	Type int    `js:"type"`
+	ID   string `js:"id"`
+	// ExpiresAt is in milliseconds since the epoch, or zero for none.
+	ExpiresAt int64 `js:"expiresAt"`
+}
+
+type rspSetExpiry struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgAllowExpired struct {
+	Type int `js:"type"`
+}
+
+type rspAllowExpired struct {
+	Type  int    `js:"type"`
+	Allow bool   `js:"allow"`
+	Err   string `js:"err"`
+}
+
+type msgSetAllowExpired struct {
+	Type  int  `js:"type"`
+	Allow bool `js:"allow"`
+}
+
+type rspSetAllowExpired struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgMaxLoadedKeys struct {
+	Type int `js:"type"`
+}
+
+type rspMaxLoadedKeys struct {
+	Type int    `js:"type"`
+	Max  int    `js:"max"`
+	Err  string `js:"err"`
+}
+
+type msgSetMaxLoadedKeys struct {
+	Type int `js:"type"`
+	Max  int `js:"max"`
+}
+
+type rspSetMaxLoadedKeys struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgKeyOrder struct {
+	Type int `js:"type"`
+}
+
+type rspKeyOrder struct {
+	Type  int    `js:"type"`
+	Order string `js:"order"`
+	Err   string `js:"err"`
+}
+
+type msgSetKeyOrder struct {
+	Type  int    `js:"type"`
+	Order string `js:"order"`
+}
+
+type rspSetKeyOrder struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgProtocolKeyPolicy struct {
+	Type int `js:"type"`
+}
+
+type rspProtocolKeyPolicy struct {
+	Type   int    `js:"type"`
+	Policy string `js:"policy"`
+	Err    string `js:"err"`
+}
+
+type msgSetProtocolKeyPolicy struct {
+	Type   int    `js:"type"`
+	Policy string `js:"policy"`
+}
+
+type rspSetProtocolKeyPolicy struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgAllowedClients struct {
+	Type int `js:"type"`
+}
+
+type rspAllowedClients struct {
+	Type    int      `js:"type"`
+	Clients []string `js:"clients"`
+	Err     string   `js:"err"`
+}
+
+type msgAllowClient struct {
+	Type     int    `js:"type"`
+	ClientID string `js:"clientId"`
+}
+
+type rspAllowClient struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgDisallowClient struct {
+	Type     int    `js:"type"`
+	ClientID string `js:"clientId"`
+}
+
+type rspDisallowClient struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgNativeHosts struct {
+	Type int `js:"type"`
+}
+
+type rspNativeHosts struct {
+	Type  int      `js:"type"`
+	Hosts []string `js:"hosts"`
+	Err   string   `js:"err"`
+}
+
+type msgAllowNativeHost struct {
+	Type     int    `js:"type"`
+	HostName string `js:"hostName"`
+}
+
+type rspAllowNativeHost struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgDisallowNativeHost struct {
+	Type     int    `js:"type"`
+	HostName string `js:"hostName"`
+}
+
+type rspDisallowNativeHost struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgClearAuditLog struct {
+	Type int `js:"type"`
+}
+
+type rspClearAuditLog struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgAuditLogEnabled struct {
+	Type int `js:"type"`
+}
+
+type rspAuditLogEnabled struct {
+	Type    int    `js:"type"`
+	Enabled bool   `js:"enabled"`
+	Err     string `js:"err"`
+}
+
+type msgSetAuditLogEnabled struct {
+	Type    int  `js:"type"`
+	Enabled bool `js:"enabled"`
+}
+
+type rspSetAuditLogEnabled struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgSetCertificate struct {
+	Type        int    `js:"type"`
+	ID          string `js:"id"`
+	Certificate string `js:"certificate"`
+}
+
+type rspSetCertificate struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgGenerateSecurityKey struct {
+	Type    int    `js:"type"`
+	Name    string `js:"name"`
+	KeyType string `js:"keyType"`
+}
+
+type rspGenerateSecurityKey struct {
+	Type      int    `js:"type"`
+	PublicKey string `js:"publicKey"`
+	Err       string `js:"err"`
+}
+
+type msgGenerateCryptoKey struct {
+	Type int    `js:"type"`
+	Name string `js:"name"`
+}
+
+type rspGenerateCryptoKey struct {
+	Type      int    `js:"type"`
+	PublicKey string `js:"publicKey"`
+	Err       string `js:"err"`
+}
+
+type msgLoadEphemeral struct {
+	Type          int    `js:"type"`
+	Name          string `js:"name"`
+	PEMPrivateKey string `js:"pemPrivateKey"`
+	Passphrase    string `js:"passphrase"`
+}
+
+type rspLoadEphemeral struct {
+	Type int    `js:"type"`
+	ID   string `js:"id"`
+	Err  string `js:"err"`
+}
+
+type msgSetTags struct {
+	Type int      `js:"type"`
+	ID   string   `js:"id"`
+	Tags []string `js:"tags"`
+}
+
+type rspSetTags struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgLoadByTag struct {
+	Type       int    `js:"type"`
+	Tag        string `js:"tag"`
+	Passphrase string `js:"passphrase"`
+}
+
+type rspLoadByTag struct {
+	Type    int           `js:"type"`
+	Results []*wireResult `js:"results"`
+	Err     string        `js:"err"`
+}
+
+type msgLoadMatching struct {
+	Type       int    `js:"type"`
+	Passphrase string `js:"passphrase"`
+}
+
+type rspLoadMatching struct {
+	Type   int           `js:"type"`
+	Loaded []string      `js:"loaded"`
+	Locked []*wireResult `js:"locked"`
+	Err    string        `js:"err"`
+}
+
+type msgPersistSession struct {
+	Type int `js:"type"`
+}
+
+type rspPersistSession struct {
+	Type    int    `js:"type"`
+	Persist bool   `js:"persist"`
+	Err     string `js:"err"`
+}
+
+type msgSetPersistSession struct {
+	Type    int  `js:"type"`
+	Persist bool `js:"persist"`
+}
+
+type rspSetPersistSession struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgLocked struct {
+	Type int `js:"type"`
+}
+
+type rspLocked struct {
+	Type   int    `js:"type"`
+	Locked bool   `js:"locked"`
+	Err    string `js:"err"`
+}
+
+type msgLock struct {
+	Type       int    `js:"type"`
+	Passphrase string `js:"passphrase"`
+}
+
+type rspLock struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type msgUnlock struct {
+	Type       int    `js:"type"`
+	Passphrase string `js:"passphrase"`
+}
+
+type rspUnlock struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+type rspError struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+// wireErrors are the exported errors whose identity is preserved when sent in
+// a message, such that errors.Is can be used on errors returned by a client.
+var wireErrors = []error{
+	ErrWrongPassphrase,
+	ErrPassphraseRequired,
+	ErrKeyCorrupt,
+	ErrLocked,
+	ErrKeyExpired,
+	ErrUnsupportedKeyType,
+	ErrPublicKeyMismatch,
+	ErrTooManyLoadedKeys,
+}
+
+// wireError is an error received in a message. It wraps the corresponding
+// member of wireErrors, if any.
+type wireError struct {
+	msg     string
+	wrapped error
+}
+
+func (e *wireError) Error() string {
+	return e.msg
+}
+
+func (e *wireError) Unwrap() error {
+	return e.wrapped
+}
+
+// makeErr converts a string to an error. Empty string returns nil (i.e., no
+// error).
+func makeErr(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, e := range wireErrors {
+		if strings.Contains(s, e.Error()) {
+			return &wireError{msg: s, wrapped: e}
+		}
+	}
+	return errors.New(s)
+}
+
+// makeErrStr converts an error to a string. A nil error is converted to the
+// empty string.
+func makeErrStr(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// makeErrorResponse produces a generic error response that can be sent to the
+// client. This is used in case a more specific error is not possible.
+func (s *Server) makeErrorResponse(err error) js.Value {
+	jsutil.LogError("Server.makeErrorResponse: %v", err)
+	rsp := rspError{
+		Type: msgTypeErrorRsp,
+		Err:  makeErrStr(err),
+	}
+	return vert.ValueOf(rsp).JSValue()
+}
+
+// OnMessage is the callback invoked when a message is received. It determines
+// the type of request received, invokes the appropriate method on the
+// underlying manager instance, and then returns the response to be sent to the
+// client.
+func (s *Server) OnMessage(ctx jsutil.AsyncContext, headerObj js.Value, _ js.Value) js.Value {
+	var header msgHeader
+	if err := vert.ValueOf(headerObj).AssignTo(&header); err != nil {
+		return s.makeErrorResponse(fmt.Errorf("failed to parse message header: %w", err))
+	}
+
+	jsutil.LogDebug("Server.OnMessage(type = %d)", header.Type)
+	switch header.Type {
+	case msgTypeConfigured:
+		var m msgConfigured
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse Configured message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(Configured req): tag=%s", m.Tag)
+		var opts []ConfiguredOption
+		if m.Tag != "" {
+			opts = append(opts, WithTag(m.Tag))
+		}
+		keys, err := s.mgr.Configured(ctx, opts...)
+		jsutil.LogDebug("Server.OnMessage(Configured rsp): %d keys, err=%v", len(keys), err)
+		rsp := rspConfigured{
+			Type: msgTypeConfiguredRsp,
+			Keys: keys,
+			Err:  makeErrStr(err),
+		}
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeLoaded:
+		jsutil.LogDebug("Server.OnMessage(Loaded req)")
+		keys, err := s.mgr.Loaded(ctx)
+		jsutil.LogDebug("Server.OnMessage(Loaded rsp): %d keys, err=%v", len(keys), err)
+		rsp := rspLoaded{
+			Type: msgTypeLoadedRsp,
+			Keys: keys,
+			Err:  makeErrStr(err),
+		}
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeAvailable:
+		jsutil.LogDebug("Server.OnMessage(Available req)")
+		keys, err := s.mgr.Available(ctx)
+		jsutil.LogDebug("Server.OnMessage(Available rsp): %d keys, err=%v", len(keys), err)
+		rsp := rspAvailable{
+			Type: msgTypeAvailableRsp,
+			Keys: keys,
+			Err:  makeErrStr(err),
+		}
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeAdd:
+		var m msgAdd
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse Add message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(Add req): name=%s", m.Name)
+		var opts []AddOption
+		if m.AllowDuplicate {
+			opts = append(opts, AllowDuplicate())
+		}
+		if m.Certificate != "" {
+			opts = append(opts, WithCertificate(m.Certificate))
+		}
+		if m.ExpectedPublicKey != "" {
+			opts = append(opts, WithExpectedPublicKey(m.ExpectedPublicKey))
+		}
+		err := s.mgr.Add(ctx, m.Name, m.PEMPrivateKey, opts...)
+		rsp := rspAdd{
+			Type: msgTypeAddRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(Add rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeImport:
+		var m msgImport
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse Import message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(Import req): name=%s", m.Name)
+		var opts []AddOption
+		if m.AllowDuplicate {
+			opts = append(opts, AllowDuplicate())
+		}
+		if m.ExpectedPublicKey != "" {
+			opts = append(opts, WithExpectedPublicKey(m.ExpectedPublicKey))
+		}
+		results, err := s.mgr.Import(ctx, m.Name, m.Text, opts...)
+		rsp := rspImport{
+			Type:    msgTypeImportRsp,
+			Results: results,
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(Import rsp): %d results, err=%v", len(results), err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeAddBatch:
+		var m msgAddBatch
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse AddBatch message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(AddBatch req): %d items", len(m.Items))
+		results, err := s.mgr.AddBatch(ctx, m.Items)
+		rsp := rspAddBatch{
+			Type:    msgTypeAddBatchRsp,
+			Results: results,
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(AddBatch rsp): %d results, err=%v", len(results), err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeGenerate:
+		var m msgGenerate
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse Generate message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(Generate req): name=%s, type=%s, bits=%d", m.Name, m.KeyType, m.Bits)
+		pub, err := s.mgr.Generate(ctx, m.Name, KeyType(m.KeyType), m.Bits, m.Passphrase, func(progress float64) {
+			jsutil.LogDebug("Server.OnMessage(Generate progress): %.0f%%", progress*100)
+			s.notifyProgress(ctx, m.Request, progress)
+		})
+		rsp := rspGenerate{
+			Type:      msgTypeGenerateRsp,
+			PublicKey: pub,
+			Err:       makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(Generate rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeStartRotation:
+		var m msgStartRotation
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse StartRotation message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(StartRotation req): id=%s, name=%s, type=%s, bits=%d", m.ID, m.Name, m.KeyType, m.Bits)
+		pub, err := s.mgr.StartRotation(ctx, ID(m.ID), m.Name, KeyType(m.KeyType), m.Bits, m.Passphrase, func(progress float64) {
+			jsutil.LogDebug("Server.OnMessage(StartRotation progress): %.0f%%", progress*100)
+			s.notifyProgress(ctx, m.Request, progress)
+		})
+		rsp := rspStartRotation{
+			Type:      msgTypeStartRotationRsp,
+			PublicKey: pub,
+			Err:       makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(StartRotation rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeCompleteRotation:
+		var m msgCompleteRotation
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse CompleteRotation message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(CompleteRotation req): id=%s, remove=%t", m.ID, m.Remove)
+		err := s.mgr.CompleteRotation(ctx, ID(m.ID), m.Remove)
+		rsp := rspCompleteRotation{
+			Type: msgTypeCompleteRotationRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(CompleteRotation rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeRemove:
+		var m msgRemove
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse Remove message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(Remove req): id=%s", m.ID)
+		err := s.mgr.Remove(ctx, ID(m.ID))
+		rsp := rspRemove{
+			Type: msgTypeRemoveRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(Remove rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeLoad:
+		var m msgLoad
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse Load message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(Load req): id=%s", m.ID)
+		err := s.mgr.Load(ctx, ID(m.ID), m.Passphrase)
+		rsp := rspLoad{
+			Type: msgTypeLoadRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(Load rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeUnload:
+		var m msgUnload
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse Unload message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(Unload req): id=%s", m.ID)
+		err := s.mgr.Unload(ctx, ID(m.ID))
+		rsp := rspUnload{
+			Type: msgTypeUnloadRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(Unload rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeChangePassphrase:
+		var m msgChangePassphrase
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse ChangePassphrase message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(ChangePassphrase req): id=%s", m.ID)
+		err := s.mgr.ChangePassphrase(ctx, ID(m.ID), m.OldPassphrase, m.NewPassphrase)
+		rsp := rspChangePassphrase{
+			Type: msgTypeChangePassphraseRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(ChangePassphrase rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeRename:
+		var m msgRename
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse Rename message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(Rename req): id=%s, name=%s", m.ID, m.NewName)
+		err := s.mgr.Rename(ctx, ID(m.ID), m.NewName)
+		rsp := rspRename{
+			Type: msgTypeRenameRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(Rename rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetComment:
+		var m msgSetComment
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetComment message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetComment req): id=%s", m.ID)
+		err := s.mgr.SetComment(ctx, ID(m.ID), m.Comment, m.Passphrase)
+		rsp := rspSetComment{
+			Type: msgTypeSetCommentRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetComment rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeRemoveAll:
+		var m msgRemoveAll
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse RemoveAll message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(RemoveAll req): ids=%v", m.IDs)
+		var ids []ID
+		for _, id := range m.IDs {
+			ids = append(ids, ID(id))
+		}
+		results, err := s.mgr.RemoveAll(ctx, ids)
+		rsp := rspRemoveAll{
+			Type:    msgTypeRemoveAllRsp,
+			Results: makeWireResults(results),
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(RemoveAll rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeLoadAll:
+		var m msgLoadAll
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse LoadAll message: %w", err))
+		}
+		reqs := makeLoadRequests(m.Requests)
+		jsutil.LogDebug("Server.OnMessage(LoadAll req): %d keys", len(reqs))
+		results, err := s.mgr.LoadAll(ctx, reqs)
+		rsp := rspLoadAll{
+			Type:    msgTypeLoadAllRsp,
+			Results: makeWireResults(results),
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(LoadAll rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetAutoLoad:
+		var m msgSetAutoLoad
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetAutoLoad message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetAutoLoad req): id=%s, autoLoad=%t", m.ID, m.AutoLoad)
+		err := s.mgr.SetAutoLoad(ctx, ID(m.ID), m.AutoLoad)
+		rsp := rspSetAutoLoad{
+			Type: msgTypeSetAutoLoadRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetAutoLoad rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeLoadAutoKeys:
+		jsutil.LogDebug("Server.OnMessage(LoadAutoKeys req)")
+		results, err := s.mgr.LoadAutoKeys(ctx)
+		rsp := rspLoadAutoKeys{
+			Type:    msgTypeLoadAutoKeysRsp,
+			Results: makeWireResults(results),
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(LoadAutoKeys rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetIdleTimeout:
+		var m msgSetIdleTimeout
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetIdleTimeout message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetIdleTimeout req): id=%s, timeout=%dms, useDefault=%t", m.ID, m.Timeout, m.UseDefault)
+		timeout := time.Duration(m.Timeout) * time.Millisecond
+		if m.UseDefault {
+			timeout = UseDefaultIdleTimeout
+		}
+		err := s.mgr.SetIdleTimeout(ctx, ID(m.ID), timeout)
+		rsp := rspSetIdleTimeout{
+			Type: msgTypeSetIdleTimeoutRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetIdleTimeout rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeDefaultIdleTimeout:
+		jsutil.LogDebug("Server.OnMessage(DefaultIdleTimeout req)")
+		timeout, err := s.mgr.DefaultIdleTimeout(ctx)
+		rsp := rspDefaultIdleTimeout{
+			Type:    msgTypeDefaultIdleTimeoutRsp,
+			Timeout: timeout.Milliseconds(),
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(DefaultIdleTimeout rsp): timeout=%s, err=%v", timeout, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetDefaultIdleTimeout:
+		var m msgSetDefaultIdleTimeout
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetDefaultIdleTimeout message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetDefaultIdleTimeout req): timeout=%dms", m.Timeout)
+		err := s.mgr.SetDefaultIdleTimeout(ctx, time.Duration(m.Timeout)*time.Millisecond)
+		rsp := rspSetDefaultIdleTimeout{
+			Type: msgTypeSetDefaultIdleTimeoutRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetDefaultIdleTimeout rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetConfirmUse:
+		var m msgSetConfirmUse
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetConfirmUse message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetConfirmUse req): id=%s, confirmUse=%t", m.ID, m.ConfirmUse)
+		err := s.mgr.SetConfirmUse(ctx, ID(m.ID), m.ConfirmUse)
+		rsp := rspSetConfirmUse{
+			Type: msgTypeSetConfirmUseRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetConfirmUse rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetRefuseSHA1:
+		var m msgSetRefuseSHA1
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetRefuseSHA1 message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetRefuseSHA1 req): id=%s, refuse=%t", m.ID, m.Refuse)
+		err := s.mgr.SetRefuseSHA1(ctx, ID(m.ID), m.Refuse)
+		rsp := rspSetRefuseSHA1{
+			Type: msgTypeSetRefuseSHA1Rsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetRefuseSHA1 rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetPriority:
+		var m msgSetPriority
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetPriority message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetPriority req): id=%s, priority=%d", m.ID, m.Priority)
+		err := s.mgr.SetPriority(ctx, ID(m.ID), m.Priority)
+		rsp := rspSetPriority{
+			Type: msgTypeSetPriorityRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetPriority rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetDestinations:
+		var m msgSetDestinations
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetDestinations message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetDestinations req): id=%s, %d destinations, requireSessionBind=%t", m.ID, len(m.Destinations), m.RequireSessionBind)
+		err := s.mgr.SetDestinations(ctx, ID(m.ID), m.Destinations, m.RequireSessionBind)
+		rsp := rspSetDestinations{
+			Type: msgTypeSetDestinationsRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetDestinations rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeAuditLog:
+		jsutil.LogDebug("Server.OnMessage(AuditLog req)")
+		entries, err := s.mgr.AuditLog(ctx)
+		jsutil.LogDebug("Server.OnMessage(AuditLog rsp): %d entries, err=%v", len(entries), err)
+		rsp := rspAuditLog{
+			Type:    msgTypeAuditLogRsp,
+			Entries: entries,
+			Err:     makeErrStr(err),
+		}
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypePublicKeys:
+		jsutil.LogDebug("Server.OnMessage(PublicKeys req)")
+		entries, err := s.mgr.PublicKeys(ctx)
+		jsutil.LogDebug("Server.OnMessage(PublicKeys rsp): %d entries, err=%v", len(entries), err)
+		rsp := rspPublicKeys{
+			Type:    msgTypePublicKeysRsp,
+			Entries: entries,
+			Err:     makeErrStr(err),
+		}
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeDefaultKeyEncryption:
+		jsutil.LogDebug("Server.OnMessage(DefaultKeyEncryption req)")
+		enc, err := s.mgr.DefaultKeyEncryption(ctx)
+		rsp := rspDefaultKeyEncryption{
+			Type:       msgTypeDefaultKeyEncryptionRsp,
+			Encryption: string(enc),
+			Err:        makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(DefaultKeyEncryption rsp): encryption=%s, err=%v", enc, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetDefaultKeyEncryption:
+		var m msgSetDefaultKeyEncryption
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetDefaultKeyEncryption message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetDefaultKeyEncryption req): encryption=%s", m.Encryption)
+		err := s.mgr.SetDefaultKeyEncryption(ctx, KeyEncryption(m.Encryption))
+		rsp := rspSetDefaultKeyEncryption{
+			Type: msgTypeSetDefaultKeyEncryptionRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetDefaultKeyEncryption rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetKeyEncryption:
+		var m msgSetKeyEncryption
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetKeyEncryption message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetKeyEncryption req): id=%s, encryption=%s", m.ID, m.Encryption)
+		err := s.mgr.SetKeyEncryption(ctx, ID(m.ID), KeyEncryption(m.Encryption), m.Passphrase)
+		rsp := rspSetKeyEncryption{
+			Type: msgTypeSetKeyEncryptionRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetKeyEncryption rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeReencryptAll:
+		var m msgReencryptAll
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse ReencryptAll message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(ReencryptAll req)")
+		results, err := s.mgr.ReencryptAll(ctx, m.Passphrase)
+		rsp := rspReencryptAll{
+			Type:    msgTypeReencryptAllRsp,
+			Results: makeWireResults(results),
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(ReencryptAll rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeMasterKeyState:
+		jsutil.LogDebug("Server.OnMessage(MasterKeyState req)")
+		state, err := s.mgr.MasterKeyState(ctx)
+		rsp := rspMasterKeyState{
+			Type:  msgTypeMasterKeyStateRsp,
+			State: string(state),
+			Err:   makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(MasterKeyState rsp): state=%s, err=%v", state, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeEnableMasterKey:
+		var m msgEnableMasterKey
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse EnableMasterKey message: %w", err))
+		}
+		reqs := makeLoadRequests(m.Requests)
+		jsutil.LogDebug("Server.OnMessage(EnableMasterKey req): %d keys", len(reqs))
+		results, err := s.mgr.EnableMasterKey(ctx, m.MasterPassphrase, reqs)
+		rsp := rspEnableMasterKey{
+			Type:    msgTypeEnableMasterKeyRsp,
+			Results: makeWireResults(results),
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(EnableMasterKey rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeDisableMasterKey:
+		var m msgDisableMasterKey
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse DisableMasterKey message: %w", err))
+		}
+		reqs := makeLoadRequests(m.Requests)
+		jsutil.LogDebug("Server.OnMessage(DisableMasterKey req): %d keys", len(reqs))
+		results, err := s.mgr.DisableMasterKey(ctx, m.MasterPassphrase, reqs)
+		rsp := rspDisableMasterKey{
+			Type:    msgTypeDisableMasterKeyRsp,
+			Results: makeWireResults(results),
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(DisableMasterKey rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeUnlockMasterKey:
+		var m msgUnlockMasterKey
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse UnlockMasterKey message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(UnlockMasterKey req)")
+		err := s.mgr.UnlockMasterKey(ctx, m.MasterPassphrase)
+		rsp := rspUnlockMasterKey{
+			Type: msgTypeUnlockMasterKeyRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(UnlockMasterKey rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeLockMasterKey:
+		jsutil.LogDebug("Server.OnMessage(LockMasterKey req)")
+		err := s.mgr.LockMasterKey(ctx)
+		rsp := rspLockMasterKey{
+			Type: msgTypeLockMasterKeyRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(LockMasterKey rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetExpiry:
+		var m msgSetExpiry
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetExpiry message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetExpiry req): id=%s, expiresAt=%d", m.ID, m.ExpiresAt)
+		var expiresAt time.Time
+		if m.ExpiresAt != 0 {
+			expiresAt = time.UnixMilli(m.ExpiresAt)
+		}
+		err := s.mgr.SetExpiry(ctx, ID(m.ID), expiresAt)
+		rsp := rspSetExpiry{
+			Type: msgTypeSetExpiryRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetExpiry rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeAllowExpired:
+		jsutil.LogDebug("Server.OnMessage(AllowExpired req)")
+		allow, err := s.mgr.AllowExpired(ctx)
+		rsp := rspAllowExpired{
+			Type:  msgTypeAllowExpiredRsp,
+			Allow: allow,
+			Err:   makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(AllowExpired rsp): allow=%t, err=%v", allow, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetAllowExpired:
+		var m msgSetAllowExpired
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetAllowExpired message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetAllowExpired req): allow=%t", m.Allow)
+		err := s.mgr.SetAllowExpired(ctx, m.Allow)
+		rsp := rspSetAllowExpired{
+			Type: msgTypeSetAllowExpiredRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetAllowExpired rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeMaxLoadedKeys:
+		jsutil.LogDebug("Server.OnMessage(MaxLoadedKeys req)")
+		max, err := s.mgr.MaxLoadedKeys(ctx)
+		rsp := rspMaxLoadedKeys{
+			Type: msgTypeMaxLoadedKeysRsp,
+			Max:  max,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(MaxLoadedKeys rsp): max=%d, err=%v", max, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetMaxLoadedKeys:
+		var m msgSetMaxLoadedKeys
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetMaxLoadedKeys message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetMaxLoadedKeys req): max=%d", m.Max)
+		err := s.mgr.SetMaxLoadedKeys(ctx, m.Max)
+		rsp := rspSetMaxLoadedKeys{
+			Type: msgTypeSetMaxLoadedKeysRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetMaxLoadedKeys rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeKeyOrder:
+		jsutil.LogDebug("Server.OnMessage(KeyOrder req)")
+		order, err := s.mgr.KeyOrder(ctx)
+		rsp := rspKeyOrder{
+			Type:  msgTypeKeyOrderRsp,
+			Order: string(order),
+			Err:   makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(KeyOrder rsp): order=%s, err=%v", order, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetKeyOrder:
+		var m msgSetKeyOrder
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetKeyOrder message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetKeyOrder req): order=%s", m.Order)
+		err := s.mgr.SetKeyOrder(ctx, KeyOrder(m.Order))
+		rsp := rspSetKeyOrder{
+			Type: msgTypeSetKeyOrderRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetKeyOrder rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeProtocolKeyPolicy:
+		jsutil.LogDebug("Server.OnMessage(ProtocolKeyPolicy req)")
+		policy, err := s.mgr.ProtocolKeyPolicy(ctx)
+		rsp := rspProtocolKeyPolicy{
+			Type:   msgTypeProtocolKeyPolicyRsp,
+			Policy: string(policy),
+			Err:    makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(ProtocolKeyPolicy rsp): policy=%s, err=%v", policy, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetProtocolKeyPolicy:
+		var m msgSetProtocolKeyPolicy
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetProtocolKeyPolicy message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetProtocolKeyPolicy req): policy=%s", m.Policy)
+		err := s.mgr.SetProtocolKeyPolicy(ctx, ProtocolKeyPolicy(m.Policy))
+		rsp := rspSetProtocolKeyPolicy{
+			Type: msgTypeSetProtocolKeyPolicyRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetProtocolKeyPolicy rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeAllowedClients:
+		jsutil.LogDebug("Server.OnMessage(AllowedClients req)")
+		clients, err := s.mgr.AllowedClients(ctx)
+		rsp := rspAllowedClients{
+			Type:    msgTypeAllowedClientsRsp,
+			Clients: clients,
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(AllowedClients rsp): clients=%v, err=%v", clients, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeAllowClient:
+		var m msgAllowClient
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse AllowClient message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(AllowClient req): clientId=%s", m.ClientID)
+		err := s.mgr.AllowClient(ctx, m.ClientID)
+		rsp := rspAllowClient{
+			Type: msgTypeAllowClientRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(AllowClient rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeDisallowClient:
+		var m msgDisallowClient
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse DisallowClient message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(DisallowClient req): clientId=%s", m.ClientID)
+		err := s.mgr.DisallowClient(ctx, m.ClientID)
+		rsp := rspDisallowClient{
+			Type: msgTypeDisallowClientRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(DisallowClient rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeNativeHosts:
+		jsutil.LogDebug("Server.OnMessage(NativeHosts req)")
+		hosts, err := s.mgr.NativeHosts(ctx)
+		rsp := rspNativeHosts{
+			Type:  msgTypeNativeHostsRsp,
+			Hosts: hosts,
+			Err:   makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(NativeHosts rsp): hosts=%v, err=%v", hosts, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeAllowNativeHost:
+		var m msgAllowNativeHost
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse AllowNativeHost message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(AllowNativeHost req): hostName=%s", m.HostName)
+		err := s.mgr.AllowNativeHost(ctx, m.HostName)
+		rsp := rspAllowNativeHost{
+			Type: msgTypeAllowNativeHostRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(AllowNativeHost rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeDisallowNativeHost:
+		var m msgDisallowNativeHost
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse DisallowNativeHost message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(DisallowNativeHost req): hostName=%s", m.HostName)
+		err := s.mgr.DisallowNativeHost(ctx, m.HostName)
+		rsp := rspDisallowNativeHost{
+			Type: msgTypeDisallowNativeHostRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(DisallowNativeHost rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeClearAuditLog:
+		jsutil.LogDebug("Server.OnMessage(ClearAuditLog req)")
+		err := s.mgr.ClearAuditLog(ctx)
+		rsp := rspClearAuditLog{
+			Type: msgTypeClearAuditLogRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(ClearAuditLog rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeAuditLogEnabled:
+		jsutil.LogDebug("Server.OnMessage(AuditLogEnabled req)")
+		enabled, err := s.mgr.AuditLogEnabled(ctx)
+		rsp := rspAuditLogEnabled{
+			Type:    msgTypeAuditLogEnabledRsp,
+			Enabled: enabled,
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(AuditLogEnabled rsp): enabled=%t, err=%v", enabled, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetAuditLogEnabled:
+		var m msgSetAuditLogEnabled
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetAuditLogEnabled message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetAuditLogEnabled req): enabled=%t", m.Enabled)
+		err := s.mgr.SetAuditLogEnabled(ctx, m.Enabled)
+		rsp := rspSetAuditLogEnabled{
+			Type: msgTypeSetAuditLogEnabledRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetAuditLogEnabled rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetCertificate:
+		var m msgSetCertificate
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetCertificate message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetCertificate req): id=%s", m.ID)
+		err := s.mgr.SetCertificate(ctx, ID(m.ID), m.Certificate)
+		rsp := rspSetCertificate{
+			Type: msgTypeSetCertificateRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetCertificate rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeGenerateSecurityKey:
+		var m msgGenerateSecurityKey
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse GenerateSecurityKey message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(GenerateSecurityKey req): name=%s, type=%s", m.Name, m.KeyType)
+		pub, err := s.mgr.GenerateSecurityKey(ctx, m.Name, KeyType(m.KeyType))
+		rsp := rspGenerateSecurityKey{
+			Type:      msgTypeGenerateSecurityKeyRsp,
+			PublicKey: pub,
+			Err:       makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(GenerateSecurityKey rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeGenerateCryptoKey:
+		var m msgGenerateCryptoKey
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse GenerateCryptoKey message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(GenerateCryptoKey req): name=%s", m.Name)
+		pub, err := s.mgr.GenerateCryptoKey(ctx, m.Name)
+		rsp := rspGenerateCryptoKey{
+			Type:      msgTypeGenerateCryptoKeyRsp,
+			PublicKey: pub,
+			Err:       makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(GenerateCryptoKey rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeLoadEphemeral:
+		var m msgLoadEphemeral
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse LoadEphemeral message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(LoadEphemeral req): name=%s", m.Name)
+		id, err := s.mgr.LoadEphemeral(ctx, m.Name, m.PEMPrivateKey, m.Passphrase)
+		rsp := rspLoadEphemeral{
+			Type: msgTypeLoadEphemeralRsp,
+			ID:   string(id),
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(LoadEphemeral rsp): id=%s, err=%v", id, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetTags:
+		var m msgSetTags
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetTags message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetTags req): id=%s, tags=%v", m.ID, m.Tags)
+		err := s.mgr.SetTags(ctx, ID(m.ID), m.Tags)
+		rsp := rspSetTags{
+			Type: msgTypeSetTagsRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetTags rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeLoadByTag:
+		var m msgLoadByTag
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse LoadByTag message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(LoadByTag req): tag=%s", m.Tag)
+		results, err := s.mgr.LoadByTag(ctx, m.Tag, m.Passphrase)
+		rsp := rspLoadByTag{
+			Type:    msgTypeLoadByTagRsp,
+			Results: makeWireResults(results),
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(LoadByTag rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeLoadMatching:
+		var m msgLoadMatching
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse LoadMatching message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(LoadMatching req)")
+		results, err := s.mgr.LoadMatching(ctx, m.Passphrase)
+		rsp := rspLoadMatching{
+			Type: msgTypeLoadMatchingRsp,
+			Err:  makeErrStr(err),
+		}
+		if results != nil {
+			for _, id := range results.Loaded {
+				rsp.Loaded = append(rsp.Loaded, string(id))
+			}
+			rsp.Locked = makeWireResults(results.Locked)
+		}
+		jsutil.LogDebug("Server.OnMessage(LoadMatching rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypePersistSession:
+		jsutil.LogDebug("Server.OnMessage(PersistSession req)")
+		persist, err := s.mgr.PersistSession(ctx)
+		rsp := rspPersistSession{
+			Type:    msgTypePersistSessionRsp,
+			Persist: persist,
+			Err:     makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(PersistSession rsp): persist=%t, err=%v", persist, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetPersistSession:
+		var m msgSetPersistSession
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse SetPersistSession message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(SetPersistSession req): persist=%t", m.Persist)
+		err := s.mgr.SetPersistSession(ctx, m.Persist)
+		rsp := rspSetPersistSession{
+			Type: msgTypeSetPersistSessionRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(SetPersistSession rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeLocked:
+		jsutil.LogDebug("Server.OnMessage(Locked req)")
+		locked, err := s.mgr.Locked(ctx)
+		rsp := rspLocked{
+			Type:   msgTypeLockedRsp,
+			Locked: locked,
+			Err:    makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(Locked rsp): locked=%t, err=%v", locked, err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeLock:
+		var m msgLock
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse Lock message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(Lock req)")
+		err := s.mgr.Lock(ctx, m.Passphrase)
+		rsp := rspLock{
+			Type: msgTypeLockRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(Lock rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeUnlock:
+		var m msgUnlock
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return s.makeErrorResponse(fmt.Errorf("failed to parse Unlock message: %w", err))
+		}
+		jsutil.LogDebug("Server.OnMessage(Unlock req)")
+		err := s.mgr.Unlock(ctx, m.Passphrase)
+		rsp := rspUnlock{
+			Type: msgTypeUnlockRsp,
+			Err:  makeErrStr(err),
+		}
+		jsutil.LogDebug("Server.OnMessage(Unlock rsp): err=%v", err)
+		return vert.ValueOf(rsp).JSValue()
+	default:
+		return s.makeErrorResponse(fmt.Errorf("received invalid message type: %d", header.Type))
+	}
+}
+
+// client implements the Manager interface and forwards calls to a Server.
+type client struct {
+	msg message.Sender
+	// progress receives the progress of long-running operations, or is
+	// nil if it is not received. See WithProgress.
+	progress *ProgressReceiver
+}
+
+// ClientOption is an option for NewClient.
+type ClientOption func(c *client)
+
+// WithProgress configures the client to report the progress of long-running
+// operations, such as generating keys, as received by r. Otherwise, progress is
+// only reported once an operation completes.
+func WithProgress(r *ProgressReceiver) ClientOption {
+	return func(c *client) {
+		c.progress = r
+	}
+}
+
+// NewClient returns a Manager implementation that forwards calls to a Server.
+func NewClient(msg message.Sender, opts ...ClientOption) Manager {
+	c := &client{msg: msg}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Configured implements Manager.Configured.
+func (c *client) Configured(ctx jsutil.AsyncContext, opts ...ConfiguredOption) ([]*ConfiguredKey, error) {
+	var o configuredOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var msg msgConfigured
+	msg.Type = msgTypeConfigured
+	msg.Tag = o.tag
+	jsutil.LogDebug("Client.Configured(req): tag=%s", msg.Tag)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Configured(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspConfigured
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Keys, makeErr(rsp.Err)
+}
+
+// Loaded implements Manager.Loaded.
+func (c *client) Loaded(ctx jsutil.AsyncContext) ([]*LoadedKey, error) {
+	var msg msgLoaded
+	msg.Type = msgTypeLoaded
+	jsutil.LogDebug("Client.Loaded(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Loaded(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspLoaded
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Keys, makeErr(rsp.Err)
+}
+
+// Available implements Manager.Available.
+func (c *client) Available(ctx jsutil.AsyncContext) ([]*AvailableKey, error) {
+	var msg msgAvailable
+	msg.Type = msgTypeAvailable
+	jsutil.LogDebug("Client.Available(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Available(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspAvailable
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Keys, makeErr(rsp.Err)
+}
+
+// Add implements Manager.Add.
+func (c *client) Add(ctx jsutil.AsyncContext, name string, pemPrivateKey string, opts ...AddOption) error {
+	var o addOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var msg msgAdd
+	msg.Type = msgTypeAdd
+	msg.Name = name
+	msg.PEMPrivateKey = pemPrivateKey
+	msg.AllowDuplicate = o.allowDuplicate
+	msg.Certificate = o.certificate
+	msg.ExpectedPublicKey = o.expectedPublicKey
+	jsutil.LogDebug("Client.Add(req): name=%s", msg.Name)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Add(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspAdd
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// Import implements Manager.Import. The WithCertificate option is not
+// supported.
+func (c *client) Import(ctx jsutil.AsyncContext, name string, text string, opts ...AddOption) ([]*ImportResult, error) {
+	var o addOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var msg msgImport
+	msg.Type = msgTypeImport
+	msg.Name = name
+	msg.Text = text
+	msg.AllowDuplicate = o.allowDuplicate
+	msg.ExpectedPublicKey = o.expectedPublicKey
+	jsutil.LogDebug("Client.Import(req): name=%s", msg.Name)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Import(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspImport
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Results, makeErr(rsp.Err)
+}
+
+// AddBatch implements Manager.AddBatch.
+func (c *client) AddBatch(ctx jsutil.AsyncContext, items []*AddItem) ([]*AddResult, error) {
+	var msg msgAddBatch
+	msg.Type = msgTypeAddBatch
+	msg.Items = items
+	jsutil.LogDebug("Client.AddBatch(req): %d items", len(msg.Items))
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.AddBatch(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspAddBatch
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Results, makeErr(rsp.Err)
+}
+
+// Generate implements Manager.Generate. Progress is reported as it is received
+// if the client was configured using WithProgress, and otherwise only once
+// generation completes.
+func (c *client) Generate(ctx jsutil.AsyncContext, name string, keyType KeyType, bits int, passphrase string, progress ProgressFunc) (string, error) {
+	var msg msgGenerate
+	msg.Type = msgTypeGenerate
+	msg.Name = name
+	msg.KeyType = string(keyType)
+	msg.Bits = bits
+	msg.Passphrase = passphrase
+	w := c.progress.watch(progress)
+	defer w.stop()
+	msg.Request = w.request
+	jsutil.LogDebug("Client.Generate(req): name=%s", msg.Name)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Generate(rsp)")
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspGenerate
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if err := makeErr(rsp.Err); err != nil {
+		return "", err
+	}
+	w.complete()
+	return rsp.PublicKey, nil
+}
+
+// StartRotation implements Manager.StartRotation. Progress is reported as for
+// Generate.
+func (c *client) StartRotation(ctx jsutil.AsyncContext, id ID, name string, keyType KeyType, bits int, passphrase string, progress ProgressFunc) (string, error) {
+	var msg msgStartRotation
+	msg.Type = msgTypeStartRotation
+	msg.ID = string(id)
+	msg.Name = name
+	msg.KeyType = string(keyType)
+	msg.Bits = bits
+	msg.Passphrase = passphrase
+	w := c.progress.watch(progress)
+	defer w.stop()
+	msg.Request = w.request
+	jsutil.LogDebug("Client.StartRotation(req): id=%s, name=%s", msg.ID, msg.Name)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.StartRotation(rsp)")
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspStartRotation
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if err := makeErr(rsp.Err); err != nil {
+		return "", err
+	}
+	w.complete()
+	return rsp.PublicKey, nil
+}
+
+// CompleteRotation implements Manager.CompleteRotation.
+func (c *client) CompleteRotation(ctx jsutil.AsyncContext, id ID, remove bool) error {
+	var msg msgCompleteRotation
+	msg.Type = msgTypeCompleteRotation
+	msg.ID = string(id)
+	msg.Remove = remove
+	jsutil.LogDebug("Client.CompleteRotation(req): id=%s, remove=%t", msg.ID, msg.Remove)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.CompleteRotation(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspCompleteRotation
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// Remove implements Manager.Remove.
+func (c *client) Remove(ctx jsutil.AsyncContext, id ID) error {
+	var msg msgRemove
+	msg.Type = msgTypeRemove
+	msg.ID = string(id)
+	jsutil.LogDebug("Client.Remove(req): id=%s", msg.ID)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Remove(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspRemove
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// Load implements Manager.Load.
+func (c *client) Load(ctx jsutil.AsyncContext, id ID, passphrase string) error {
+	var msg msgLoad
+	msg.Type = msgTypeLoad
+	msg.ID = string(id)
+	msg.Passphrase = passphrase
+	jsutil.LogDebug("Client.Load(req): id=%s", msg.ID)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Load(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspLoad
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// Unload implements Manager.Unload.
+func (c *client) Unload(ctx jsutil.AsyncContext, id ID) error {
+	var msg msgUnload
+	msg.Type = msgTypeUnload
+	msg.ID = string(id)
+	jsutil.LogDebug("Client.Unload(req): id=%s", msg.ID)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Unload(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspUnload
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// ChangePassphrase implements Manager.ChangePassphrase.
+func (c *client) ChangePassphrase(ctx jsutil.AsyncContext, id ID, oldPassphrase, newPassphrase string) error {
+	var msg msgChangePassphrase
+	msg.Type = msgTypeChangePassphrase
+	msg.ID = string(id)
+	msg.OldPassphrase = oldPassphrase
+	msg.NewPassphrase = newPassphrase
+	jsutil.LogDebug("Client.ChangePassphrase(req): id=%s", msg.ID)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.ChangePassphrase(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspChangePassphrase
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// Rename implements Manager.Rename.
+func (c *client) Rename(ctx jsutil.AsyncContext, id ID, newName string) error {
+	var msg msgRename
+	msg.Type = msgTypeRename
+	msg.ID = string(id)
+	msg.NewName = newName
+	jsutil.LogDebug("Client.Rename(req): id=%s, name=%s", msg.ID, msg.NewName)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Rename(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspRename
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// SetComment implements Manager.SetComment.
+func (c *client) SetComment(ctx jsutil.AsyncContext, id ID, comment string, passphrase string) error {
+	var msg msgSetComment
+	msg.Type = msgTypeSetComment
+	msg.ID = string(id)
+	msg.Comment = comment
+	msg.Passphrase = passphrase
+	jsutil.LogDebug("Client.SetComment(req): id=%s", msg.ID)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetComment(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetComment
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// RemoveAll implements Manager.RemoveAll.
+func (c *client) RemoveAll(ctx jsutil.AsyncContext, ids []ID) ([]*Result, error) {
+	var msg msgRemoveAll
+	msg.Type = msgTypeRemoveAll
+	for _, id := range ids {
+		msg.IDs = append(msg.IDs, string(id))
+	}
+	jsutil.LogDebug("Client.RemoveAll(req): ids=%v", msg.IDs)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.RemoveAll(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspRemoveAll
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeResults(rsp.Results), makeErr(rsp.Err)
+}
+
+// LoadAll implements Manager.LoadAll.
+func (c *client) LoadAll(ctx jsutil.AsyncContext, reqs []*LoadRequest) ([]*Result, error) {
+	var msg msgLoadAll
+	msg.Type = msgTypeLoadAll
+	msg.Requests = makeWireLoadRequests(reqs)
+	jsutil.LogDebug("Client.LoadAll(req): %d keys", len(msg.Requests))
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.LoadAll(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspLoadAll
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeResults(rsp.Results), makeErr(rsp.Err)
+}
+
+// LoadEphemeral implements Manager.LoadEphemeral.
+func (c *client) LoadEphemeral(ctx jsutil.AsyncContext, name string, pemPrivateKey string, passphrase string) (ID, error) {
+	var msg msgLoadEphemeral
+	msg.Type = msgTypeLoadEphemeral
+	msg.Name = name
+	msg.PEMPrivateKey = pemPrivateKey
+	msg.Passphrase = passphrase
+	jsutil.LogDebug("Client.LoadEphemeral(req): name=%s", msg.Name)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.LoadEphemeral(rsp)")
+	if err != nil {
+		return InvalidID, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspLoadEphemeral
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return InvalidID, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if err := makeErr(rsp.Err); err != nil {
+		return InvalidID, err
+	}
+	return ID(rsp.ID), nil
+}
+
+// SetAutoLoad implements Manager.SetAutoLoad.
+func (c *client) SetAutoLoad(ctx jsutil.AsyncContext, id ID, autoLoad bool) error {
+	var msg msgSetAutoLoad
+	msg.Type = msgTypeSetAutoLoad
+	msg.ID = string(id)
+	msg.AutoLoad = autoLoad
+	jsutil.LogDebug("Client.SetAutoLoad(req): id=%s, autoLoad=%t", msg.ID, msg.AutoLoad)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetAutoLoad(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetAutoLoad
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// LoadAutoKeys implements Manager.LoadAutoKeys.
+func (c *client) LoadAutoKeys(ctx jsutil.AsyncContext) ([]*Result, error) {
+	var msg msgLoadAutoKeys
+	msg.Type = msgTypeLoadAutoKeys
+	jsutil.LogDebug("Client.LoadAutoKeys(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.LoadAutoKeys(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspLoadAutoKeys
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeResults(rsp.Results), makeErr(rsp.Err)
+}
+
+// SetIdleTimeout implements Manager.SetIdleTimeout.
+func (c *client) SetIdleTimeout(ctx jsutil.AsyncContext, id ID, timeout time.Duration) error {
+	var msg msgSetIdleTimeout
+	msg.Type = msgTypeSetIdleTimeout
+	msg.ID = string(id)
+	msg.Timeout = timeout.Milliseconds()
+	msg.UseDefault = timeout == UseDefaultIdleTimeout
+	jsutil.LogDebug("Client.SetIdleTimeout(req): id=%s, timeout=%s", msg.ID, timeout)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetIdleTimeout(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetIdleTimeout
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// DefaultIdleTimeout implements Manager.DefaultIdleTimeout.
+func (c *client) DefaultIdleTimeout(ctx jsutil.AsyncContext) (time.Duration, error) {
+	var msg msgDefaultIdleTimeout
+	msg.Type = msgTypeDefaultIdleTimeout
+	jsutil.LogDebug("Client.DefaultIdleTimeout(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.DefaultIdleTimeout(rsp)")
+	if err != nil {
+		return 0, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspDefaultIdleTimeout
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return time.Duration(rsp.Timeout) * time.Millisecond, makeErr(rsp.Err)
+}
+
+// SetDefaultIdleTimeout implements Manager.SetDefaultIdleTimeout.
+func (c *client) SetDefaultIdleTimeout(ctx jsutil.AsyncContext, timeout time.Duration) error {
+	var msg msgSetDefaultIdleTimeout
+	msg.Type = msgTypeSetDefaultIdleTimeout
+	msg.Timeout = timeout.Milliseconds()
+	jsutil.LogDebug("Client.SetDefaultIdleTimeout(req): timeout=%s", timeout)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetDefaultIdleTimeout(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetDefaultIdleTimeout
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// SetConfirmUse implements Manager.SetConfirmUse.
+func (c *client) SetConfirmUse(ctx jsutil.AsyncContext, id ID, confirmUse bool) error {
+	var msg msgSetConfirmUse
+	msg.Type = msgTypeSetConfirmUse
+	msg.ID = string(id)
+	msg.ConfirmUse = confirmUse
+	jsutil.LogDebug("Client.SetConfirmUse(req): id=%s, confirmUse=%t", msg.ID, msg.ConfirmUse)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetConfirmUse(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetConfirmUse
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// SetRefuseSHA1 implements Manager.SetRefuseSHA1.
+func (c *client) SetRefuseSHA1(ctx jsutil.AsyncContext, id ID, refuse bool) error {
+	var msg msgSetRefuseSHA1
+	msg.Type = msgTypeSetRefuseSHA1
+	msg.ID = string(id)
+	msg.Refuse = refuse
+	jsutil.LogDebug("Client.SetRefuseSHA1(req): id=%s, refuse=%t", msg.ID, msg.Refuse)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetRefuseSHA1(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetRefuseSHA1
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// SetPriority implements Manager.SetPriority.
+func (c *client) SetPriority(ctx jsutil.AsyncContext, id ID, priority int) error {
+	var msg msgSetPriority
+	msg.Type = msgTypeSetPriority
+	msg.ID = string(id)
+	msg.Priority = priority
+	jsutil.LogDebug("Client.SetPriority(req): id=%s, priority=%d", msg.ID, msg.Priority)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetPriority(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetPriority
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// SetDestinations implements Manager.SetDestinations.
+func (c *client) SetDestinations(ctx jsutil.AsyncContext, id ID, destinations []string, requireSessionBind bool) error {
+	var msg msgSetDestinations
+	msg.Type = msgTypeSetDestinations
+	msg.ID = string(id)
+	msg.Destinations = destinations
+	msg.RequireSessionBind = requireSessionBind
+	jsutil.LogDebug("Client.SetDestinations(req): id=%s, %d destinations, requireSessionBind=%t", msg.ID, len(msg.Destinations), msg.RequireSessionBind)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetDestinations(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetDestinations
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// AuditLog implements Manager.AuditLog.
+func (c *client) AuditLog(ctx jsutil.AsyncContext) ([]*AuditEntry, error) {
+	var msg msgAuditLog
+	msg.Type = msgTypeAuditLog
+	jsutil.LogDebug("Client.AuditLog(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.AuditLog(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspAuditLog
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Entries, makeErr(rsp.Err)
+}
+
+// DefaultKeyEncryption implements Manager.DefaultKeyEncryption.
+func (c *client) DefaultKeyEncryption(ctx jsutil.AsyncContext) (KeyEncryption, error) {
+	var msg msgDefaultKeyEncryption
+	msg.Type = msgTypeDefaultKeyEncryption
+	jsutil.LogDebug("Client.DefaultKeyEncryption(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.DefaultKeyEncryption(rsp)")
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspDefaultKeyEncryption
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return KeyEncryption(rsp.Encryption), makeErr(rsp.Err)
+}
+
+// SetDefaultKeyEncryption implements Manager.SetDefaultKeyEncryption.
+func (c *client) SetDefaultKeyEncryption(ctx jsutil.AsyncContext, enc KeyEncryption) error {
+	var msg msgSetDefaultKeyEncryption
+	msg.Type = msgTypeSetDefaultKeyEncryption
+	msg.Encryption = string(enc)
+	jsutil.LogDebug("Client.SetDefaultKeyEncryption(req): encryption=%s", enc)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetDefaultKeyEncryption(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetDefaultKeyEncryption
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// SetKeyEncryption implements Manager.SetKeyEncryption.
+func (c *client) SetKeyEncryption(ctx jsutil.AsyncContext, id ID, enc KeyEncryption, passphrase string) error {
+	var msg msgSetKeyEncryption
+	msg.Type = msgTypeSetKeyEncryption
+	msg.ID = string(id)
+	msg.Encryption = string(enc)
+	msg.Passphrase = passphrase
+	jsutil.LogDebug("Client.SetKeyEncryption(req): id=%s, encryption=%s", id, enc)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetKeyEncryption(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetKeyEncryption
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// ReencryptAll implements Manager.ReencryptAll.
+func (c *client) ReencryptAll(ctx jsutil.AsyncContext, passphrase string) ([]*Result, error) {
+	var msg msgReencryptAll
+	msg.Type = msgTypeReencryptAll
+	msg.Passphrase = passphrase
+	jsutil.LogDebug("Client.ReencryptAll(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.ReencryptAll(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspReencryptAll
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeResults(rsp.Results), makeErr(rsp.Err)
+}
+
+// MasterKeyState implements Manager.MasterKeyState.
+func (c *client) MasterKeyState(ctx jsutil.AsyncContext) (MasterKeyState, error) {
+	var msg msgMasterKeyState
+	msg.Type = msgTypeMasterKeyState
+	jsutil.LogDebug("Client.MasterKeyState(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.MasterKeyState(rsp)")
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspMasterKeyState
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return MasterKeyState(rsp.State), makeErr(rsp.Err)
+}
+
+// EnableMasterKey implements Manager.EnableMasterKey.
+func (c *client) EnableMasterKey(ctx jsutil.AsyncContext, masterPassphrase string, reqs []*LoadRequest) ([]*Result, error) {
+	var msg msgEnableMasterKey
+	msg.Type = msgTypeEnableMasterKey
+	msg.MasterPassphrase = masterPassphrase
+	msg.Requests = makeWireLoadRequests(reqs)
+	jsutil.LogDebug("Client.EnableMasterKey(req): %d keys", len(msg.Requests))
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.EnableMasterKey(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspEnableMasterKey
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeResults(rsp.Results), makeErr(rsp.Err)
+}
+
+// DisableMasterKey implements Manager.DisableMasterKey.
+func (c *client) DisableMasterKey(ctx jsutil.AsyncContext, masterPassphrase string, reqs []*LoadRequest) ([]*Result, error) {
+	var msg msgDisableMasterKey
+	msg.Type = msgTypeDisableMasterKey
+	msg.MasterPassphrase = masterPassphrase
+	msg.Requests = makeWireLoadRequests(reqs)
+	jsutil.LogDebug("Client.DisableMasterKey(req): %d keys", len(msg.Requests))
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.DisableMasterKey(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspDisableMasterKey
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeResults(rsp.Results), makeErr(rsp.Err)
+}
+
+// UnlockMasterKey implements Manager.UnlockMasterKey.
+func (c *client) UnlockMasterKey(ctx jsutil.AsyncContext, masterPassphrase string) error {
+	var msg msgUnlockMasterKey
+	msg.Type = msgTypeUnlockMasterKey
+	msg.MasterPassphrase = masterPassphrase
+	jsutil.LogDebug("Client.UnlockMasterKey(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.UnlockMasterKey(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspUnlockMasterKey
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// LockMasterKey implements Manager.LockMasterKey.
+func (c *client) LockMasterKey(ctx jsutil.AsyncContext) error {
+	var msg msgLockMasterKey
+	msg.Type = msgTypeLockMasterKey
+	jsutil.LogDebug("Client.LockMasterKey(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.LockMasterKey(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspLockMasterKey
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// SetExpiry implements Manager.SetExpiry.
+func (c *client) SetExpiry(ctx jsutil.AsyncContext, id ID, expiresAt time.Time) error {
+	var msg msgSetExpiry
+	msg.Type = msgTypeSetExpiry
+	msg.ID = string(id)
+	if !expiresAt.IsZero() {
+		msg.ExpiresAt = expiresAt.UnixMilli()
+	}
+	jsutil.LogDebug("Client.SetExpiry(req): id=%s, expiresAt=%d", msg.ID, msg.ExpiresAt)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetExpiry(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetExpiry
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// AllowExpired implements Manager.AllowExpired.
+func (c *client) AllowExpired(ctx jsutil.AsyncContext) (bool, error) {
+	var msg msgAllowExpired
+	msg.Type = msgTypeAllowExpired
+	jsutil.LogDebug("Client.AllowExpired(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.AllowExpired(rsp)")
+	if err != nil {
+		return false, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspAllowExpired
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return false, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Allow, makeErr(rsp.Err)
+}
+
+// SetAllowExpired implements Manager.SetAllowExpired.
+func (c *client) SetAllowExpired(ctx jsutil.AsyncContext, allow bool) error {
+	var msg msgSetAllowExpired
+	msg.Type = msgTypeSetAllowExpired
+	msg.Allow = allow
+	jsutil.LogDebug("Client.SetAllowExpired(req): allow=%t", msg.Allow)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetAllowExpired(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetAllowExpired
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// MaxLoadedKeys implements Manager.MaxLoadedKeys.
+func (c *client) MaxLoadedKeys(ctx jsutil.AsyncContext) (int, error) {
+	var msg msgMaxLoadedKeys
+	msg.Type = msgTypeMaxLoadedKeys
+	jsutil.LogDebug("Client.MaxLoadedKeys(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.MaxLoadedKeys(rsp)")
+	if err != nil {
+		return 0, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspMaxLoadedKeys
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Max, makeErr(rsp.Err)
+}
+
+// SetMaxLoadedKeys implements Manager.SetMaxLoadedKeys.
+func (c *client) SetMaxLoadedKeys(ctx jsutil.AsyncContext, max int) error {
+	var msg msgSetMaxLoadedKeys
+	msg.Type = msgTypeSetMaxLoadedKeys
+	msg.Max = max
+	jsutil.LogDebug("Client.SetMaxLoadedKeys(req): max=%d", msg.Max)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetMaxLoadedKeys(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetMaxLoadedKeys
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// KeyOrder implements Manager.KeyOrder.
+func (c *client) KeyOrder(ctx jsutil.AsyncContext) (KeyOrder, error) {
+	var msg msgKeyOrder
+	msg.Type = msgTypeKeyOrder
+	jsutil.LogDebug("Client.KeyOrder(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.KeyOrder(rsp)")
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspKeyOrder
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return KeyOrder(rsp.Order), makeErr(rsp.Err)
+}
+
+// SetKeyOrder implements Manager.SetKeyOrder.
+func (c *client) SetKeyOrder(ctx jsutil.AsyncContext, order KeyOrder) error {
+	var msg msgSetKeyOrder
+	msg.Type = msgTypeSetKeyOrder
+	msg.Order = string(order)
+	jsutil.LogDebug("Client.SetKeyOrder(req): order=%s", msg.Order)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetKeyOrder(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetKeyOrder
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// ProtocolKeyPolicy implements Manager.ProtocolKeyPolicy.
+func (c *client) ProtocolKeyPolicy(ctx jsutil.AsyncContext) (ProtocolKeyPolicy, error) {
+	var msg msgProtocolKeyPolicy
+	msg.Type = msgTypeProtocolKeyPolicy
+	jsutil.LogDebug("Client.ProtocolKeyPolicy(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.ProtocolKeyPolicy(rsp)")
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspProtocolKeyPolicy
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return ProtocolKeyPolicy(rsp.Policy), makeErr(rsp.Err)
+}
+
+// SetProtocolKeyPolicy implements Manager.SetProtocolKeyPolicy.
+func (c *client) SetProtocolKeyPolicy(ctx jsutil.AsyncContext, policy ProtocolKeyPolicy) error {
+	var msg msgSetProtocolKeyPolicy
+	msg.Type = msgTypeSetProtocolKeyPolicy
+	msg.Policy = string(policy)
+	jsutil.LogDebug("Client.SetProtocolKeyPolicy(req): policy=%s", msg.Policy)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetProtocolKeyPolicy(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetProtocolKeyPolicy
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// AllowedClients implements Manager.AllowedClients.
+func (c *client) AllowedClients(ctx jsutil.AsyncContext) ([]string, error) {
+	var msg msgAllowedClients
+	msg.Type = msgTypeAllowedClients
+	jsutil.LogDebug("Client.AllowedClients(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.AllowedClients(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspAllowedClients
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Clients, makeErr(rsp.Err)
+}
+
+// AllowClient implements Manager.AllowClient.
+func (c *client) AllowClient(ctx jsutil.AsyncContext, id string) error {
+	var msg msgAllowClient
+	msg.Type = msgTypeAllowClient
+	msg.ClientID = id
+	jsutil.LogDebug("Client.AllowClient(req): clientId=%s", msg.ClientID)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.AllowClient(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspAllowClient
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// DisallowClient implements Manager.DisallowClient.
+func (c *client) DisallowClient(ctx jsutil.AsyncContext, id string) error {
+	var msg msgDisallowClient
+	msg.Type = msgTypeDisallowClient
+	msg.ClientID = id
+	jsutil.LogDebug("Client.DisallowClient(req): clientId=%s", msg.ClientID)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.DisallowClient(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspDisallowClient
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// NativeHosts implements Manager.NativeHosts.
+func (c *client) NativeHosts(ctx jsutil.AsyncContext) ([]string, error) {
+	var msg msgNativeHosts
+	msg.Type = msgTypeNativeHosts
+	jsutil.LogDebug("Client.NativeHosts(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.NativeHosts(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspNativeHosts
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Hosts, makeErr(rsp.Err)
+}
+
+// AllowNativeHost implements Manager.AllowNativeHost.
+func (c *client) AllowNativeHost(ctx jsutil.AsyncContext, name string) error {
+	var msg msgAllowNativeHost
+	msg.Type = msgTypeAllowNativeHost
+	msg.HostName = name
+	jsutil.LogDebug("Client.AllowNativeHost(req): hostName=%s", msg.HostName)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.AllowNativeHost(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspAllowNativeHost
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// DisallowNativeHost implements Manager.DisallowNativeHost.
+func (c *client) DisallowNativeHost(ctx jsutil.AsyncContext, name string) error {
+	var msg msgDisallowNativeHost
+	msg.Type = msgTypeDisallowNativeHost
+	msg.HostName = name
+	jsutil.LogDebug("Client.DisallowNativeHost(req): hostName=%s", msg.HostName)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.DisallowNativeHost(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspDisallowNativeHost
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// PublicKeys implements Manager.PublicKeys.
+func (c *client) PublicKeys(ctx jsutil.AsyncContext) ([]*PublicKeyEntry, error) {
+	var msg msgPublicKeys
+	msg.Type = msgTypePublicKeys
+	jsutil.LogDebug("Client.PublicKeys(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.PublicKeys(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspPublicKeys
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Entries, makeErr(rsp.Err)
+}
+
+// ClearAuditLog implements Manager.ClearAuditLog.
+func (c *client) ClearAuditLog(ctx jsutil.AsyncContext) error {
+	var msg msgClearAuditLog
+	msg.Type = msgTypeClearAuditLog
+	jsutil.LogDebug("Client.ClearAuditLog(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.ClearAuditLog(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspClearAuditLog
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// AuditLogEnabled implements Manager.AuditLogEnabled.
+func (c *client) AuditLogEnabled(ctx jsutil.AsyncContext) (bool, error) {
+	var msg msgAuditLogEnabled
+	msg.Type = msgTypeAuditLogEnabled
+	jsutil.LogDebug("Client.AuditLogEnabled(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.AuditLogEnabled(rsp)")
+	if err != nil {
+		return false, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspAuditLogEnabled
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return false, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Enabled, makeErr(rsp.Err)
+}
+
+// SetAuditLogEnabled implements Manager.SetAuditLogEnabled.
+func (c *client) SetAuditLogEnabled(ctx jsutil.AsyncContext, enabled bool) error {
+	var msg msgSetAuditLogEnabled
+	msg.Type = msgTypeSetAuditLogEnabled
+	msg.Enabled = enabled
+	jsutil.LogDebug("Client.SetAuditLogEnabled(req): enabled=%t", msg.Enabled)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetAuditLogEnabled(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetAuditLogEnabled
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// SetCertificate implements Manager.SetCertificate.
+func (c *client) SetCertificate(ctx jsutil.AsyncContext, id ID, cert string) error {
+	var msg msgSetCertificate
+	msg.Type = msgTypeSetCertificate
+	msg.ID = string(id)
+	msg.Certificate = cert
+	jsutil.LogDebug("Client.SetCertificate(req): id=%s", msg.ID)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetCertificate(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetCertificate
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// GenerateSecurityKey implements Manager.GenerateSecurityKey.
+func (c *client) GenerateSecurityKey(ctx jsutil.AsyncContext, name string, keyType KeyType) (string, error) {
+	var msg msgGenerateSecurityKey
+	msg.Type = msgTypeGenerateSecurityKey
+	msg.Name = name
+	msg.KeyType = string(keyType)
+	jsutil.LogDebug("Client.GenerateSecurityKey(req): name=%s", msg.Name)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.GenerateSecurityKey(rsp)")
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspGenerateSecurityKey
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if err := makeErr(rsp.Err); err != nil {
+		return "", err
+	}
+	return rsp.PublicKey, nil
+}
+
+// GenerateCryptoKey implements Manager.GenerateCryptoKey.
+func (c *client) GenerateCryptoKey(ctx jsutil.AsyncContext, name string) (string, error) {
+	var msg msgGenerateCryptoKey
+	msg.Type = msgTypeGenerateCryptoKey
+	msg.Name = name
+	jsutil.LogDebug("Client.GenerateCryptoKey(req): name=%s", msg.Name)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.GenerateCryptoKey(rsp)")
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspGenerateCryptoKey
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if err := makeErr(rsp.Err); err != nil {
+		return "", err
+	}
+	return rsp.PublicKey, nil
+}
+
+// SetTags implements Manager.SetTags.
+func (c *client) SetTags(ctx jsutil.AsyncContext, id ID, tags []string) error {
+	var msg msgSetTags
+	msg.Type = msgTypeSetTags
+	msg.ID = string(id)
+	msg.Tags = tags
+	jsutil.LogDebug("Client.SetTags(req): id=%s, tags=%v", msg.ID, msg.Tags)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetTags(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetTags
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// LoadByTag implements Manager.LoadByTag.
+func (c *client) LoadByTag(ctx jsutil.AsyncContext, tag string, passphrase string) ([]*Result, error) {
+	var msg msgLoadByTag
+	msg.Type = msgTypeLoadByTag
+	msg.Tag = tag
+	msg.Passphrase = passphrase
+	jsutil.LogDebug("Client.LoadByTag(req): tag=%s", msg.Tag)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.LoadByTag(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspLoadByTag
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeResults(rsp.Results), makeErr(rsp.Err)
+}
+
+// LoadMatching implements Manager.LoadMatching.
+func (c *client) LoadMatching(ctx jsutil.AsyncContext, passphrase string) (*MatchResults, error) {
+	var msg msgLoadMatching
+	msg.Type = msgTypeLoadMatching
+	msg.Passphrase = passphrase
+	jsutil.LogDebug("Client.LoadMatching(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.LoadMatching(rsp)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspLoadMatching
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if err := makeErr(rsp.Err); err != nil {
+		return nil, err
+	}
+	results := &MatchResults{Locked: makeResults(rsp.Locked)}
+	for _, id := range rsp.Loaded {
+		results.Loaded = append(results.Loaded, ID(id))
+	}
+	return results, nil
+}
+
+// PersistSession implements Manager.PersistSession.
+func (c *client) PersistSession(ctx jsutil.AsyncContext) (bool, error) {
+	var msg msgPersistSession
+	msg.Type = msgTypePersistSession
+	jsutil.LogDebug("Client.PersistSession(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.PersistSession(rsp)")
+	if err != nil {
+		return false, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspPersistSession
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return false, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Persist, makeErr(rsp.Err)
+}
+
+// SetPersistSession implements Manager.SetPersistSession.
+func (c *client) SetPersistSession(ctx jsutil.AsyncContext, persist bool) error {
+	var msg msgSetPersistSession
+	msg.Type = msgTypeSetPersistSession
+	msg.Persist = persist
+	jsutil.LogDebug("Client.SetPersistSession(req): persist=%t", msg.Persist)
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.SetPersistSession(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetPersistSession
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// Locked implements Manager.Locked.
+func (c *client) Locked(ctx jsutil.AsyncContext) (bool, error) {
+	var msg msgLocked
+	msg.Type = msgTypeLocked
+	jsutil.LogDebug("Client.Locked(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Locked(rsp)")
+	if err != nil {
+		return false, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspLocked
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return false, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return rsp.Locked, makeErr(rsp.Err)
+}
+
+// Lock implements Manager.Lock.
+func (c *client) Lock(ctx jsutil.AsyncContext, passphrase string) error {
+	var msg msgLock
+	msg.Type = msgTypeLock
+	msg.Passphrase = passphrase
+	jsutil.LogDebug("Client.Lock(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Lock(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspLock
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// Unlock implements Manager.Unlock.
+func (c *client) Unlock(ctx jsutil.AsyncContext, passphrase string) error {
+	var msg msgUnlock
+	msg.Type = msgTypeUnlock
+	msg.Passphrase = passphrase
+	jsutil.LogDebug("Client.Unlock(req)")
+	rspObj, err := c.msg.Send(ctx, vert.ValueOf(msg).JSValue())
+	jsutil.LogDebug("Client.Unlock(rsp)")
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspUnlock
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return makeErr(rsp.Err)
+}
+
+// NotifyIdleUnloaded broadcasts a message indicating that the keys with the
+// specified IDs were unloaded because they were idle. Pages may receive it
+// using an IdleUnloadReceiver. Failure to deliver the message (e.g., because
+// no page is listening) is logged.
+func NotifyIdleUnloaded(ctx jsutil.AsyncContext, msg message.Sender, ids []ID) {
+	m := msgIdleUnloaded{Type: msgTypeIdleUnloaded}
+	for _, id := range ids {
+		m.IDs = append(m.IDs, string(id))
+	}
+	jsutil.LogDebug("NotifyIdleUnloaded: ids=%v", m.IDs)
+	if _, err := msg.Send(ctx, vert.ValueOf(m).JSValue()); err != nil {
+		jsutil.LogDebug("NotifyIdleUnloaded: failed to send message: %v", err)
+	}
+}
+
+// IdleUnloadReceiver receives the messages broadcast by NotifyIdleUnloaded.
+type IdleUnloadReceiver struct {
+	callback func(ctx jsutil.AsyncContext, ids []ID)
+}
+
+// NewIdleUnloadReceiver returns a new IdleUnloadReceiver that invokes callback
+// with the IDs of keys unloaded because they were idle.
+func NewIdleUnloadReceiver(callback func(ctx jsutil.AsyncContext, ids []ID)) *IdleUnloadReceiver {
+	return &IdleUnloadReceiver{callback: callback}
+}
+
+// OnMessage is the callback invoked when a message is received. Messages
+// other than those broadcast by NotifyIdleUnloaded are ignored. No response
+// is sent, so undefined is always returned.
+func (r *IdleUnloadReceiver) OnMessage(ctx jsutil.AsyncContext, headerObj js.Value, _ js.Value) js.Value {
+	var m msgIdleUnloaded
+	if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil || m.Type != msgTypeIdleUnloaded {
+		return js.Undefined()
+	}
+	var ids []ID
+	for _, id := range m.IDs {
+		ids = append(ids, ID(id))
+	}
+	jsutil.LogDebug("IdleUnloadReceiver.OnMessage: ids=%v", m.IDs)
+	r.callback(ctx, ids)
+	return js.Undefined()
+}
+
+// notifyProgress broadcasts the progress of the operation identified by
+// request, if the client requested it. Failure to deliver the message (e.g.,
+// because the page that requested it has closed) is logged.
+func (s *Server) notifyProgress(ctx jsutil.AsyncContext, request string, progress float64) {
+	if s.progress == nil || request == "" {
+		return
+	}
+	m := msgProgress{Type: msgTypeProgress, Request: request, Progress: progress}
+	if _, err := s.progress.Send(ctx, vert.ValueOf(m).JSValue()); err != nil {
+		jsutil.LogDebug("Server.notifyProgress: failed to send message: %v", err)
+	}
+}
+
+// ProgressReceiver receives the progress of long-running operations broadcast
+// by a Server, and reports it to the clients that requested the operations.
+// See WithProgress.
+type ProgressReceiver struct {
+	// mu guards watches.
+	mu sync.Mutex
+	// watches are the operations in progress, by request.
+	watches map[string]*progressWatch
+}
+
+// NewProgressReceiver returns a new ProgressReceiver.
+func NewProgressReceiver() *ProgressReceiver {
+	return &ProgressReceiver{watches: map[string]*progressWatch{}}
+}
+
+// progressWatch reports the progress of an operation requested by a client.
+type progressWatch struct {
+	r *ProgressReceiver
+	// request identifies the operation in progress messages, or is empty
+	// if progress is not received.
+	request  string
+	progress ProgressFunc
+
+	// mu guards done.
+	mu sync.Mutex
+	// done indicates that no further progress is reported.
+	done bool
+}
+
+// watch returns a progressWatch that reports the progress of a new operation
+// to progress, which may be nil. The receiver may be nil, in which case only
+// completion is reported. stop must be invoked once the operation finishes.
+func (r *ProgressReceiver) watch(progress ProgressFunc) *progressWatch {
+	if progress == nil {
+		progress = func(float64) {}
+		r = nil
+	}
+	w := &progressWatch{r: r, progress: progress}
+	if r == nil {
+		return w
+	}
+	id, err := newID()
+	if err != nil {
+		jsutil.LogDebug("ProgressReceiver.watch: progress not reported: %v", err)
+		w.r = nil
+		return w
+	}
+	w.request = string(id)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.watches[w.request] = w
+	return w
+}
+
+// report reports progress, unless the operation is done. It is done once
+// progress reaches 1.
+func (w *progressWatch) report(progress float64) {
+	w.mu.Lock()
+	if w.done {
+		w.mu.Unlock()
+		return
+	}
+	w.done = progress >= 1
+	w.mu.Unlock()
+	w.progress(progress)
+}
+
+// complete reports that the operation completed, if the Server has yet to do
+// so, and stops reporting progress.
+func (w *progressWatch) complete() {
+	w.report(1)
+	w.stop()
+}
+
+// stop stops reporting progress.
+func (w *progressWatch) stop() {
+	w.mu.Lock()
+	w.done = true
+	w.mu.Unlock()
+	if w.r == nil {
+		return
+	}
+	w.r.mu.Lock()
+	defer w.r.mu.Unlock()
+	delete(w.r.watches, w.request)
+}
+
+// OnMessage is the callback invoked when a message is received. Messages
+// other than the progress broadcast by a Server are ignored. No response is
+// sent, so undefined is always returned.
+func (r *ProgressReceiver) OnMessage(ctx jsutil.AsyncContext, headerObj js.Value, _ js.Value) js.Value {
+	var m msgProgress
+	if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil || m.Type != msgTypeProgress {
+		return js.Undefined()
+	}
+	r.mu.Lock()
+	w := r.watches[m.Request]
+	r.mu.Unlock()
+	if w == nil {
+		return js.Undefined()
+	}
+	jsutil.LogDebug("ProgressReceiver.OnMessage: request=%s, progress=%.0f%%", m.Request, m.Progress*100)
+	w.report(m.Progress)
+	return js.Undefined()
+}
+
+// NotifyChanges broadcasts a message describing changes made to keys. Pages
+// may receive it using a ChangeReceiver. Failure to deliver the message
+// (e.g., because no page is listening) is logged.
+func NotifyChanges(ctx jsutil.AsyncContext, msg message.Sender, events []ChangeEvent) {
+	m := msgChanged{Type: msgTypeChanged}
+	for _, e := range events {
+		m.Events = append(m.Events, &changeEvent{Kind: int(e.Kind), ID: string(e.ID)})
+	}
+	jsutil.LogDebug("NotifyChanges: events=%d", len(m.Events))
+	if _, err := msg.Send(ctx, vert.ValueOf(m).JSValue()); err != nil {
+		jsutil.LogDebug("NotifyChanges: failed to send message: %v", err)
+	}
+}
+
+// ChangeReceiver receives the messages broadcast by NotifyChanges.
+type ChangeReceiver struct {
+	callback func(ctx jsutil.AsyncContext, events []ChangeEvent)
+}
+
+// NewChangeReceiver returns a new ChangeReceiver that invokes callback with
+// the changes made to keys.
+func NewChangeReceiver(callback func(ctx jsutil.AsyncContext, events []ChangeEvent)) *ChangeReceiver {
+	return &ChangeReceiver{callback: callback}
+}
+
+// OnMessage is the callback invoked when a message is received. Messages
+// other than those broadcast by NotifyChanges are ignored. No response is
+// sent, so undefined is always returned.
+func (r *ChangeReceiver) OnMessage(ctx jsutil.AsyncContext, headerObj js.Value, _ js.Value) js.Value {
+	var m msgChanged
+	if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil || m.Type != msgTypeChanged {
+		return js.Undefined()
+	}
+	var events []ChangeEvent
+	for _, e := range m.Events {
+		events = append(events, ChangeEvent{Kind: ChangeKind(e.Kind), ID: ID(e.ID)})
+	}
+	jsutil.LogDebug("ChangeReceiver.OnMessage: events=%d", len(events))
+	r.callback(ctx, events)
+	return js.Undefined()
 }
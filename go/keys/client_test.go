@@ -16,7 +16,9 @@ package keys
 
 import (
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/google/chrome-ssh-agent/go/jsutil"
 	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
@@ -29,22 +31,135 @@ type dummyManager struct {
 	Name           string
 	PEMPrivateKey  string
 	Passphrase     string
+	NewPassphrase  string
+	Comment        string
+	IDs            []ID
+	LoadRequests   []*LoadRequest
+	Results        []*Result
+	MatchResults   *MatchResults
+	AllowDuplicate bool
+	AutoLoad       bool
+	Timeout        time.Duration
+	ConfirmUse     bool
+	RefuseSHA1     bool
+	Priority       int
+	Destinations   []string
+	RequireBind    bool
+	Persist        bool
+	IsLocked       bool
+	Certificate    string
+	ExpectedPubKey string
+	Tag            string
+	Tags           []string
+	KeyType        KeyType
+	Bits           int
+	Progress       []float64
+	PublicKey      string
 	ConfiguredKeys []*ConfiguredKey
 	LoadedKeys     []*LoadedKey
+	AvailableKeys  []*AvailableKey
+	AuditEntries   []*AuditEntry
+	ImportResults  []*ImportResult
+	AddItems       []*AddItem
+	AddResults     []*AddResult
+	PubKeyEntries  []*PublicKeyEntry
+	Encryption     KeyEncryption
+	MasterState    MasterKeyState
+	ExpiresAt      time.Time
+	AllowExp       bool
+	RemoveOld      bool
+	MaxLoaded      int
+	Order          KeyOrder
+	ProtoPolicy    ProtocolKeyPolicy
+	ClientID       string
+	Clients        []string
+	HostName       string
+	Hosts          []string
+	AuditCleared   bool
+	AuditEnabled   bool
 	Key            *LoadedKey
 	Err            error
 }
 
-func (m *dummyManager) Configured(_ jsutil.AsyncContext) ([]*ConfiguredKey, error) {
+func (m *dummyManager) Configured(_ jsutil.AsyncContext, opts ...ConfiguredOption) ([]*ConfiguredKey, error) {
+	var o configuredOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m.Tag = o.tag
 	return m.ConfiguredKeys, m.Err
 }
 
-func (m *dummyManager) Add(_ jsutil.AsyncContext, name string, pemPrivateKey string) error {
+func (m *dummyManager) Add(_ jsutil.AsyncContext, name string, pemPrivateKey string, opts ...AddOption) error {
+	var o addOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
 	m.Name = name
 	m.PEMPrivateKey = pemPrivateKey
+	m.AllowDuplicate = o.allowDuplicate
+	m.Certificate = o.certificate
+	m.ExpectedPubKey = o.expectedPublicKey
 	return m.Err
 }
 
+func (m *dummyManager) Import(_ jsutil.AsyncContext, name string, text string, opts ...AddOption) ([]*ImportResult, error) {
+	var o addOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m.Name = name
+	m.PEMPrivateKey = text
+	m.AllowDuplicate = o.allowDuplicate
+	m.ExpectedPubKey = o.expectedPublicKey
+	return m.ImportResults, m.Err
+}
+
+func (m *dummyManager) AddBatch(_ jsutil.AsyncContext, items []*AddItem) ([]*AddResult, error) {
+	m.AddItems = items
+	return m.AddResults, m.Err
+}
+
+func (m *dummyManager) Generate(_ jsutil.AsyncContext, name string, keyType KeyType, bits int, passphrase string, progress ProgressFunc) (string, error) {
+	m.Name = name
+	m.KeyType = keyType
+	m.Bits = bits
+	m.Passphrase = passphrase
+	for _, p := range m.Progress {
+		progress(p)
+	}
+	return m.PublicKey, m.Err
+}
+
+func (m *dummyManager) StartRotation(_ jsutil.AsyncContext, id ID, name string, keyType KeyType, bits int, passphrase string, progress ProgressFunc) (string, error) {
+	m.ID = id
+	m.Name = name
+	m.KeyType = keyType
+	m.Bits = bits
+	m.Passphrase = passphrase
+	for _, p := range m.Progress {
+		progress(p)
+	}
+	return m.PublicKey, m.Err
+}
+
+func (m *dummyManager) CompleteRotation(_ jsutil.AsyncContext, id ID, remove bool) error {
+	m.ID = id
+	m.RemoveOld = remove
+	return m.Err
+}
+
+func (m *dummyManager) GenerateSecurityKey(_ jsutil.AsyncContext, name string, keyType KeyType) (string, error) {
+	m.Name = name
+	m.KeyType = keyType
+	return m.PublicKey, m.Err
+}
+
+func (m *dummyManager) GenerateCryptoKey(_ jsutil.AsyncContext, name string) (string, error) {
+	m.Name = name
+	return m.PublicKey, m.Err
+}
+
 func (m *dummyManager) Remove(_ jsutil.AsyncContext, id ID) error {
 	m.ID = id
 	return m.Err
@@ -54,6 +169,10 @@ func (m *dummyManager) Loaded(_ jsutil.AsyncContext) ([]*LoadedKey, error) {
 	return m.LoadedKeys, m.Err
 }
 
+func (m *dummyManager) Available(_ jsutil.AsyncContext) ([]*AvailableKey, error) {
+	return m.AvailableKeys, m.Err
+}
+
 func (m *dummyManager) Load(_ jsutil.AsyncContext, id ID, passphrase string) error {
 	m.ID = id
 	m.Passphrase = passphrase
@@ -65,6 +184,285 @@ func (m *dummyManager) Unload(_ jsutil.AsyncContext, id ID) error {
 	return m.Err
 }
 
+func (m *dummyManager) ChangePassphrase(_ jsutil.AsyncContext, id ID, oldPassphrase, newPassphrase string) error {
+	m.ID = id
+	m.Passphrase = oldPassphrase
+	m.NewPassphrase = newPassphrase
+	return m.Err
+}
+
+func (m *dummyManager) Rename(_ jsutil.AsyncContext, id ID, newName string) error {
+	m.ID = id
+	m.Name = newName
+	return m.Err
+}
+
+func (m *dummyManager) SetComment(_ jsutil.AsyncContext, id ID, comment string, passphrase string) error {
+	m.ID = id
+	m.Comment = comment
+	m.Passphrase = passphrase
+	return m.Err
+}
+
+func (m *dummyManager) RemoveAll(_ jsutil.AsyncContext, ids []ID) ([]*Result, error) {
+	m.IDs = ids
+	return m.Results, m.Err
+}
+
+func (m *dummyManager) LoadAll(_ jsutil.AsyncContext, reqs []*LoadRequest) ([]*Result, error) {
+	m.LoadRequests = reqs
+	return m.Results, m.Err
+}
+
+func (m *dummyManager) LoadEphemeral(_ jsutil.AsyncContext, name string, pemPrivateKey string, passphrase string) (ID, error) {
+	m.Name = name
+	m.PEMPrivateKey = pemPrivateKey
+	m.Passphrase = passphrase
+	return m.ID, m.Err
+}
+
+func (m *dummyManager) SetAutoLoad(_ jsutil.AsyncContext, id ID, autoLoad bool) error {
+	m.ID = id
+	m.AutoLoad = autoLoad
+	return m.Err
+}
+
+func (m *dummyManager) LoadAutoKeys(_ jsutil.AsyncContext) ([]*Result, error) {
+	return m.Results, m.Err
+}
+
+func (m *dummyManager) SetIdleTimeout(_ jsutil.AsyncContext, id ID, timeout time.Duration) error {
+	m.ID = id
+	m.Timeout = timeout
+	return m.Err
+}
+
+func (m *dummyManager) DefaultIdleTimeout(_ jsutil.AsyncContext) (time.Duration, error) {
+	return m.Timeout, m.Err
+}
+
+func (m *dummyManager) SetDefaultIdleTimeout(_ jsutil.AsyncContext, timeout time.Duration) error {
+	m.Timeout = timeout
+	return m.Err
+}
+
+func (m *dummyManager) SetConfirmUse(_ jsutil.AsyncContext, id ID, confirmUse bool) error {
+	m.ID = id
+	m.ConfirmUse = confirmUse
+	return m.Err
+}
+
+func (m *dummyManager) SetRefuseSHA1(_ jsutil.AsyncContext, id ID, refuse bool) error {
+	m.ID = id
+	m.RefuseSHA1 = refuse
+	return m.Err
+}
+
+func (m *dummyManager) SetPriority(_ jsutil.AsyncContext, id ID, priority int) error {
+	m.ID = id
+	m.Priority = priority
+	return m.Err
+}
+
+func (m *dummyManager) SetDestinations(_ jsutil.AsyncContext, id ID, destinations []string, requireSessionBind bool) error {
+	m.ID = id
+	m.Destinations = destinations
+	m.RequireBind = requireSessionBind
+	return m.Err
+}
+
+func (m *dummyManager) AuditLog(_ jsutil.AsyncContext) ([]*AuditEntry, error) {
+	return m.AuditEntries, m.Err
+}
+
+func (m *dummyManager) PublicKeys(_ jsutil.AsyncContext) ([]*PublicKeyEntry, error) {
+	return m.PubKeyEntries, m.Err
+}
+
+func (m *dummyManager) DefaultKeyEncryption(_ jsutil.AsyncContext) (KeyEncryption, error) {
+	return m.Encryption, m.Err
+}
+
+func (m *dummyManager) SetDefaultKeyEncryption(_ jsutil.AsyncContext, enc KeyEncryption) error {
+	m.Encryption = enc
+	return m.Err
+}
+
+func (m *dummyManager) SetKeyEncryption(_ jsutil.AsyncContext, id ID, enc KeyEncryption, passphrase string) error {
+	m.ID = id
+	m.Encryption = enc
+	m.Passphrase = passphrase
+	return m.Err
+}
+
+func (m *dummyManager) ReencryptAll(_ jsutil.AsyncContext, passphrase string) ([]*Result, error) {
+	m.Passphrase = passphrase
+	return m.Results, m.Err
+}
+
+func (m *dummyManager) MasterKeyState(_ jsutil.AsyncContext) (MasterKeyState, error) {
+	return m.MasterState, m.Err
+}
+
+func (m *dummyManager) EnableMasterKey(_ jsutil.AsyncContext, masterPassphrase string, reqs []*LoadRequest) ([]*Result, error) {
+	m.Passphrase = masterPassphrase
+	m.LoadRequests = reqs
+	m.MasterState = MasterKeyUnlocked
+	return m.Results, m.Err
+}
+
+func (m *dummyManager) DisableMasterKey(_ jsutil.AsyncContext, masterPassphrase string, reqs []*LoadRequest) ([]*Result, error) {
+	m.Passphrase = masterPassphrase
+	m.LoadRequests = reqs
+	m.MasterState = MasterKeyDisabled
+	return m.Results, m.Err
+}
+
+func (m *dummyManager) UnlockMasterKey(_ jsutil.AsyncContext, masterPassphrase string) error {
+	m.Passphrase = masterPassphrase
+	if m.Err == nil {
+		m.MasterState = MasterKeyUnlocked
+	}
+	return m.Err
+}
+
+func (m *dummyManager) LockMasterKey(_ jsutil.AsyncContext) error {
+	m.MasterState = MasterKeyLocked
+	return m.Err
+}
+
+func (m *dummyManager) SetExpiry(_ jsutil.AsyncContext, id ID, expiresAt time.Time) error {
+	m.ID = id
+	m.ExpiresAt = expiresAt
+	return m.Err
+}
+
+func (m *dummyManager) AllowExpired(_ jsutil.AsyncContext) (bool, error) {
+	return m.AllowExp, m.Err
+}
+
+func (m *dummyManager) SetAllowExpired(_ jsutil.AsyncContext, allow bool) error {
+	m.AllowExp = allow
+	return m.Err
+}
+
+func (m *dummyManager) MaxLoadedKeys(_ jsutil.AsyncContext) (int, error) {
+	return m.MaxLoaded, m.Err
+}
+
+func (m *dummyManager) SetMaxLoadedKeys(_ jsutil.AsyncContext, max int) error {
+	m.MaxLoaded = max
+	return m.Err
+}
+
+func (m *dummyManager) KeyOrder(_ jsutil.AsyncContext) (KeyOrder, error) {
+	return m.Order, m.Err
+}
+
+func (m *dummyManager) SetKeyOrder(_ jsutil.AsyncContext, order KeyOrder) error {
+	m.Order = order
+	return m.Err
+}
+
+func (m *dummyManager) ProtocolKeyPolicy(_ jsutil.AsyncContext) (ProtocolKeyPolicy, error) {
+	return m.ProtoPolicy, m.Err
+}
+
+func (m *dummyManager) SetProtocolKeyPolicy(_ jsutil.AsyncContext, policy ProtocolKeyPolicy) error {
+	m.ProtoPolicy = policy
+	return m.Err
+}
+
+func (m *dummyManager) AllowedClients(_ jsutil.AsyncContext) ([]string, error) {
+	return m.Clients, m.Err
+}
+
+func (m *dummyManager) AllowClient(_ jsutil.AsyncContext, id string) error {
+	m.ClientID = id
+	return m.Err
+}
+
+func (m *dummyManager) DisallowClient(_ jsutil.AsyncContext, id string) error {
+	m.ClientID = id
+	return m.Err
+}
+
+func (m *dummyManager) NativeHosts(_ jsutil.AsyncContext) ([]string, error) {
+	return m.Hosts, m.Err
+}
+
+func (m *dummyManager) AllowNativeHost(_ jsutil.AsyncContext, name string) error {
+	m.HostName = name
+	return m.Err
+}
+
+func (m *dummyManager) DisallowNativeHost(_ jsutil.AsyncContext, name string) error {
+	m.HostName = name
+	return m.Err
+}
+
+func (m *dummyManager) ClearAuditLog(_ jsutil.AsyncContext) error {
+	m.AuditCleared = true
+	return m.Err
+}
+
+func (m *dummyManager) AuditLogEnabled(_ jsutil.AsyncContext) (bool, error) {
+	return m.AuditEnabled, m.Err
+}
+
+func (m *dummyManager) SetAuditLogEnabled(_ jsutil.AsyncContext, enabled bool) error {
+	m.AuditEnabled = enabled
+	return m.Err
+}
+
+func (m *dummyManager) SetCertificate(_ jsutil.AsyncContext, id ID, cert string) error {
+	m.ID = id
+	m.Certificate = cert
+	return m.Err
+}
+
+func (m *dummyManager) SetTags(_ jsutil.AsyncContext, id ID, tags []string) error {
+	m.ID = id
+	m.Tags = tags
+	return m.Err
+}
+
+func (m *dummyManager) LoadByTag(_ jsutil.AsyncContext, tag string, passphrase string) ([]*Result, error) {
+	m.Tag = tag
+	m.Passphrase = passphrase
+	return m.Results, m.Err
+}
+
+func (m *dummyManager) LoadMatching(_ jsutil.AsyncContext, passphrase string) (*MatchResults, error) {
+	m.Passphrase = passphrase
+	return m.MatchResults, m.Err
+}
+
+func (m *dummyManager) PersistSession(_ jsutil.AsyncContext) (bool, error) {
+	return m.Persist, m.Err
+}
+
+func (m *dummyManager) SetPersistSession(_ jsutil.AsyncContext, persist bool) error {
+	m.Persist = persist
+	return m.Err
+}
+
+func (m *dummyManager) Locked(_ jsutil.AsyncContext) (bool, error) {
+	return m.IsLocked, m.Err
+}
+
+func (m *dummyManager) Lock(_ jsutil.AsyncContext, passphrase string) error {
+	m.Passphrase = passphrase
+	m.IsLocked = true
+	return m.Err
+}
+
+func (m *dummyManager) Unlock(_ jsutil.AsyncContext, passphrase string) error {
+	m.Passphrase = passphrase
+	m.IsLocked = false
+	return m.Err
+}
+
 func TestClientServerConfigured(t *testing.T) {
 	t.Parallel()
 
@@ -97,6 +495,13 @@ func TestClientServerConfigured(t *testing.T) {
 		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
 			t.Errorf("incorrect error; -got +want: %s", diff)
 		}
+
+		if _, err := cli.Configured(ctx, WithTag("work")); err == nil {
+			t.Errorf("Configured unexpectedly succeeded")
+		}
+		if diff := cmp.Diff(mgr.Tag, "work"); diff != "" {
+			t.Errorf("incorrect tag; -got +want: %s", diff)
+		}
 	})
 }
 
@@ -116,13 +521,24 @@ func TestClientServerAdd(t *testing.T) {
 
 		mgr.Err = wantErr
 
-		err := cli.Add(ctx, wantName, wantPrivateKey)
+		wantCertificate := "certificate"
+		wantPublicKey := "public-key"
+		err := cli.Add(ctx, wantName, wantPrivateKey, AllowDuplicate(), WithCertificate(wantCertificate), WithExpectedPublicKey(wantPublicKey))
 		if diff := cmp.Diff(mgr.Name, wantName); diff != "" {
 			t.Errorf("incorrect name; -got +want: %s", diff)
 		}
 		if diff := cmp.Diff(mgr.PEMPrivateKey, wantPrivateKey); diff != "" {
 			t.Errorf("incorrect private key; -got +want: %s", diff)
 		}
+		if !mgr.AllowDuplicate {
+			t.Errorf("AllowDuplicate option not forwarded")
+		}
+		if diff := cmp.Diff(mgr.Certificate, wantCertificate); diff != "" {
+			t.Errorf("incorrect certificate; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.ExpectedPubKey, wantPublicKey); diff != "" {
+			t.Errorf("incorrect expected public key; -got +want: %s", diff)
+		}
 		// Compare by error string; cmp.EquateErrors doesn't work since type
 		// information is lost on conversion to/from JSON in message hub.
 		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
@@ -131,7 +547,7 @@ func TestClientServerAdd(t *testing.T) {
 	})
 }
 
-func TestClientServerRemove(t *testing.T) {
+func TestClientServerImport(t *testing.T) {
 	t.Parallel()
 
 	jut.DoSync(func(ctx jsutil.AsyncContext) {
@@ -141,14 +557,29 @@ func TestClientServerRemove(t *testing.T) {
 		srv := NewServer(mgr)
 		hub.AddReceiver(srv)
 
-		wantID := ID("id-0")
+		wantName := "some-name"
+		wantText := "private-keys"
+		wantResults := []*ImportResult{
+			{Name: "key-0"},
+			{Name: "key-1", Err: "failed to parse"},
+		}
 		wantErr := errors.New("failed")
 
+		mgr.ImportResults = wantResults
 		mgr.Err = wantErr
 
-		err := cli.Remove(ctx, wantID)
-		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
-			t.Errorf("incorrect ID; -got +want: %s", diff)
+		results, err := cli.Import(ctx, wantName, wantText, AllowDuplicate())
+		if diff := cmp.Diff(mgr.Name, wantName); diff != "" {
+			t.Errorf("incorrect name; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.PEMPrivateKey, wantText); diff != "" {
+			t.Errorf("incorrect text; -got +want: %s", diff)
+		}
+		if !mgr.AllowDuplicate {
+			t.Errorf("AllowDuplicate option not forwarded")
+		}
+		if diff := cmp.Diff(results, wantResults); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
 		}
 		// Compare by error string; cmp.EquateErrors doesn't work since type
 		// information is lost on conversion to/from JSON in message hub.
@@ -158,7 +589,7 @@ func TestClientServerRemove(t *testing.T) {
 	})
 }
 
-func TestClientServerLoaded(t *testing.T) {
+func TestClientServerAddBatch(t *testing.T) {
 	t.Parallel()
 
 	jut.DoSync(func(ctx jsutil.AsyncContext) {
@@ -168,24 +599,25 @@ func TestClientServerLoaded(t *testing.T) {
 		srv := NewServer(mgr)
 		hub.AddReceiver(srv)
 
-		k0 := &LoadedKey{}
-		k0.Type = "type-0"
-		k0.SetBlob([]byte("blob-0"))
-		k0.Comment = "comment-0"
-		k1 := &LoadedKey{}
-		k1.Type = "type-1"
-		k1.SetBlob([]byte("blob-1"))
-		k1.Comment = "comment-1"
-
-		wantLoadedKeys := []*LoadedKey{k0, k1}
+		wantItems := []*AddItem{
+			{Name: "key-0", PEMPrivateKey: "private-key-0", Certificate: "certificate-0"},
+			{Name: "key-1", PEMPrivateKey: "private-key-1"},
+		}
+		wantResults := []*AddResult{
+			{Name: "key-0", ID: "id-0"},
+			{Name: "key-1", Err: "failed to parse"},
+		}
 		wantErr := errors.New("failed")
 
-		mgr.LoadedKeys = append(mgr.LoadedKeys, wantLoadedKeys...)
+		mgr.AddResults = wantResults
 		mgr.Err = wantErr
 
-		loaded, err := cli.Loaded(ctx)
-		if diff := cmp.Diff(loaded, wantLoadedKeys, loadedKeyCmp); diff != "" {
-			t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+		results, err := cli.AddBatch(ctx, wantItems)
+		if diff := cmp.Diff(mgr.AddItems, wantItems); diff != "" {
+			t.Errorf("incorrect items; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(results, wantResults); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
 		}
 		// Compare by error string; cmp.EquateErrors doesn't work since type
 		// information is lost on conversion to/from JSON in message hub.
@@ -195,7 +627,7 @@ func TestClientServerLoaded(t *testing.T) {
 	})
 }
 
-func TestClientServerLoad(t *testing.T) {
+func TestClientServerGenerate(t *testing.T) {
 	t.Parallel()
 
 	jut.DoSync(func(ctx jsutil.AsyncContext) {
@@ -205,19 +637,41 @@ func TestClientServerLoad(t *testing.T) {
 		srv := NewServer(mgr)
 		hub.AddReceiver(srv)
 
-		wantID := ID("id-0")
+		wantName := "some-name"
+		wantKeyType := KeyTypeECDSA
+		wantBits := 384
 		wantPassphrase := "secret"
-		wantErr := errors.New("failed")
+		wantPublicKey := "public-key"
 
-		mgr.Err = wantErr
+		mgr.PublicKey = wantPublicKey
 
-		err := cli.Load(ctx, wantID, wantPassphrase)
-		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
-			t.Errorf("incorrect ID; -got +want: %s", diff)
+		var progress []float64
+		pub, err := cli.Generate(ctx, wantName, wantKeyType, wantBits, wantPassphrase, func(p float64) { progress = append(progress, p) })
+		if err != nil {
+			t.Errorf("Generate failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.Name, wantName); diff != "" {
+			t.Errorf("incorrect name; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.KeyType, wantKeyType); diff != "" {
+			t.Errorf("incorrect key type; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Bits, wantBits); diff != "" {
+			t.Errorf("incorrect bits; -got +want: %s", diff)
 		}
 		if diff := cmp.Diff(mgr.Passphrase, wantPassphrase); diff != "" {
 			t.Errorf("incorrect passphrase; -got +want: %s", diff)
 		}
+		if diff := cmp.Diff(pub, wantPublicKey); diff != "" {
+			t.Errorf("incorrect public key; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(progress, []float64{1}); diff != "" {
+			t.Errorf("incorrect progress; -got +want: %s", diff)
+		}
+
+		wantErr := errors.New("failed")
+		mgr.Err = wantErr
+		_, err = cli.Generate(ctx, wantName, wantKeyType, wantBits, wantPassphrase, nil)
 		// Compare by error string; cmp.EquateErrors doesn't work since type
 		// information is lost on conversion to/from JSON in message hub.
 		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
@@ -226,7 +680,79 @@ func TestClientServerLoad(t *testing.T) {
 	})
 }
 
-func TestClientServerUnload(t *testing.T) {
+func TestClientServerGenerateProgress(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		description  string
+		progress     []float64
+		withProgress bool
+		want         []float64
+	}{
+		{
+			description:  "progress forwarded",
+			progress:     []float64{0, 0.5, 0.9, 1},
+			withProgress: true,
+			want:         []float64{0, 0.5, 0.9, 1},
+		},
+		{
+			description:  "completion reported by client",
+			progress:     []float64{0, 0.5},
+			withProgress: true,
+			want:         []float64{0, 0.5, 1},
+		},
+		{
+			description: "progress not received",
+			progress:    []float64{0, 0.5, 0.9, 1},
+			want:        []float64{1},
+		},
+	} {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				hub := mfakes.NewHub()
+				mgr := &dummyManager{Progress: tc.progress}
+				r := NewProgressReceiver()
+				var opts []ClientOption
+				if tc.withProgress {
+					opts = append(opts, WithProgress(r))
+				}
+				cli := NewClient(hub, opts...)
+				srv := NewServer(mgr)
+				srv.SetProgress(hub)
+				// The receiver must precede the server, which
+				// responds to messages it does not recognize.
+				hub.AddReceiver(r)
+				hub.AddReceiver(srv)
+
+				var progress []float64
+				if _, err := cli.Generate(ctx, "some-name", KeyTypeED25519, 0, "secret", func(p float64) { progress = append(progress, p) }); err != nil {
+					t.Errorf("Generate failed: %v", err)
+				}
+				if diff := cmp.Diff(progress, tc.want); diff != "" {
+					t.Errorf("incorrect progress for Generate; -got +want: %s", diff)
+				}
+
+				progress = nil
+				if _, err := cli.StartRotation(ctx, ID("id-0"), "some-name", KeyTypeED25519, 0, "secret", func(p float64) { progress = append(progress, p) }); err != nil {
+					t.Errorf("StartRotation failed: %v", err)
+				}
+				if diff := cmp.Diff(progress, tc.want); diff != "" {
+					t.Errorf("incorrect progress for StartRotation; -got +want: %s", diff)
+				}
+
+				// Progress of completed operations is not reported.
+				if n := len(r.watches); n != 0 {
+					t.Errorf("receiver holds %d completed operations", n)
+				}
+			})
+		})
+	}
+}
+
+func TestClientServerStartRotation(t *testing.T) {
 	t.Parallel()
 
 	jut.DoSync(func(ctx jsutil.AsyncContext) {
@@ -236,19 +762,1616 @@ func TestClientServerUnload(t *testing.T) {
 		srv := NewServer(mgr)
 		hub.AddReceiver(srv)
 
-		wantID := ID("some-id")
+		wantID := ID("id-0")
+		wantName := "some-name"
+		wantKeyType := KeyTypeED25519
+		wantPassphrase := "secret"
+		wantPublicKey := "public-key"
 		wantErr := errors.New("failed")
 
+		mgr.PublicKey = wantPublicKey
 		mgr.Err = wantErr
 
-		err := cli.Unload(ctx, wantID)
+		_, err := cli.StartRotation(ctx, wantID, wantName, wantKeyType, 0, wantPassphrase, nil)
 		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
-			t.Errorf("incorrect key; -got +want: %s", diff)
+			t.Errorf("incorrect ID; -got +want: %s", diff)
 		}
-		// Compare by error string; cmp.EquateErrors doesn't work since type
+		if diff := cmp.Diff(mgr.Name, wantName); diff != "" {
+			t.Errorf("incorrect name; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.KeyType, wantKeyType); diff != "" {
+			t.Errorf("incorrect key type; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Passphrase, wantPassphrase); diff != "" {
+			t.Errorf("incorrect passphrase; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		mgr.Err = nil
+		pub, err := cli.StartRotation(ctx, wantID, wantName, wantKeyType, 0, wantPassphrase, nil)
+		if err != nil {
+			t.Errorf("StartRotation failed: %v", err)
+		}
+		if diff := cmp.Diff(pub, wantPublicKey); diff != "" {
+			t.Errorf("incorrect public key; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerCompleteRotation(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.CompleteRotation(ctx, wantID, true)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if !mgr.RemoveOld {
+			t.Errorf("incorrect remove; got false, want true")
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerRemove(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.Remove(ctx, wantID)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerLoaded(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		k0 := &LoadedKey{}
+		k0.Type = "type-0"
+		k0.SetBlob([]byte("blob-0"))
+		k0.Comment = "comment-0"
+		k1 := &LoadedKey{}
+		k1.Type = "type-1"
+		k1.SetBlob([]byte("blob-1"))
+		k1.Comment = "comment-1"
+
+		wantLoadedKeys := []*LoadedKey{k0, k1}
+		wantErr := errors.New("failed")
+
+		mgr.LoadedKeys = append(mgr.LoadedKeys, wantLoadedKeys...)
+		mgr.Err = wantErr
+
+		loaded, err := cli.Loaded(ctx)
+		if diff := cmp.Diff(loaded, wantLoadedKeys, loadedKeyCmp); diff != "" {
+			t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerAvailable(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		l := &LoadedKey{}
+		l.Type = "type-0"
+		l.SetBlob([]byte("blob-0"))
+		l.Comment = "comment-0"
+
+		wantAvailableKeys := []*AvailableKey{
+			{
+				InternalStatus: string(StatusLoaded),
+				Configured:     &ConfiguredKey{ID: "id-0", Name: "name-0"},
+				Loaded:         l,
+			},
+			{
+				InternalStatus: string(StatusConfigured),
+				Configured:     &ConfiguredKey{ID: "id-1", Name: "name-1", Tags: []string{"tag"}},
+			},
+		}
+		wantErr := errors.New("failed")
+
+		mgr.AvailableKeys = wantAvailableKeys
+		mgr.Err = wantErr
+
+		available, err := cli.Available(ctx)
+		if diff := cmp.Diff(available, wantAvailableKeys, loadedKeyCmp); diff != "" {
+			t.Errorf("incorrect available keys; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerLoad(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantPassphrase := "secret"
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.Load(ctx, wantID, wantPassphrase)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Passphrase, wantPassphrase); diff != "" {
+			t.Errorf("incorrect passphrase; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerUnload(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("some-id")
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.Unload(ctx, wantID)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect key; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerChangePassphrase(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantOldPassphrase := "old-secret"
+		wantNewPassphrase := "new-secret"
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.ChangePassphrase(ctx, wantID, wantOldPassphrase, wantNewPassphrase)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Passphrase, wantOldPassphrase); diff != "" {
+			t.Errorf("incorrect old passphrase; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.NewPassphrase, wantNewPassphrase); diff != "" {
+			t.Errorf("incorrect new passphrase; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerRename(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantName := "new-name"
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.Rename(ctx, wantID, wantName)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Name, wantName); diff != "" {
+			t.Errorf("incorrect name; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerSetComment(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantComment := "user@host"
+		wantPassphrase := "secret"
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetComment(ctx, wantID, wantComment, wantPassphrase)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Comment, wantComment); diff != "" {
+			t.Errorf("incorrect comment; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Passphrase, wantPassphrase); diff != "" {
+			t.Errorf("incorrect passphrase; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerRemoveAll(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantIDs := []ID{ID("id-0"), ID("id-1")}
+		wantResults := []*Result{
+			{ID: ID("id-0")},
+			{ID: ID("id-1"), Err: errors.New("not found")},
+		}
+
+		mgr.Results = wantResults
+
+		results, err := cli.RemoveAll(ctx, wantIDs)
+		if err != nil {
+			t.Errorf("RemoveAll failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.IDs, wantIDs); diff != "" {
+			t.Errorf("incorrect IDs; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(results, wantResults, resultStringCmp); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerLoadAll(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantRequests := []*LoadRequest{
+			{ID: ID("id-0"), Passphrase: "secret-0"},
+			{ID: ID("id-1"), Passphrase: "secret-1"},
+		}
+		wantResults := []*Result{
+			{ID: ID("id-0")},
+			{ID: ID("id-1"), Err: errors.New("incorrect passphrase")},
+		}
+
+		mgr.Results = wantResults
+
+		results, err := cli.LoadAll(ctx, wantRequests)
+		if err != nil {
+			t.Errorf("LoadAll failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.LoadRequests, wantRequests); diff != "" {
+			t.Errorf("incorrect requests; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(results, wantResults, resultStringCmp); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerSetAutoLoad(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetAutoLoad(ctx, wantID, true)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if !mgr.AutoLoad {
+			t.Errorf("incorrect auto-load setting; got false, want true")
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerLoadAutoKeys(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantResults := []*Result{
+			{ID: ID("id-0")},
+			{ID: ID("id-1"), Err: errors.New("incorrect passphrase")},
+		}
+
+		mgr.Results = wantResults
+
+		results, err := cli.LoadAutoKeys(ctx)
+		if err != nil {
+			t.Errorf("LoadAutoKeys failed: %v", err)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(results, wantResults, resultStringCmp); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerSetIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	for _, wantTimeout := range []time.Duration{0, 90 * time.Second, UseDefaultIdleTimeout} {
+		jut.DoSync(func(ctx jsutil.AsyncContext) {
+			hub := mfakes.NewHub()
+			mgr := &dummyManager{}
+			cli := NewClient(hub)
+			srv := NewServer(mgr)
+			hub.AddReceiver(srv)
+
+			wantID := ID("id-0")
+			wantErr := errors.New("failed")
+
+			mgr.Err = wantErr
+
+			err := cli.SetIdleTimeout(ctx, wantID, wantTimeout)
+			if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+				t.Errorf("incorrect ID; -got +want: %s", diff)
+			}
+			if diff := cmp.Diff(mgr.Timeout, wantTimeout); diff != "" {
+				t.Errorf("incorrect timeout; -got +want: %s", diff)
+			}
+			// Compare by error string; cmp.EquateErrors doesn't work since type
+			// information is lost on conversion to/from JSON in message hub.
+			if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+				t.Errorf("incorrect error; -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestClientServerDefaultIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantTimeout := 15 * time.Minute
+
+		if err := cli.SetDefaultIdleTimeout(ctx, wantTimeout); err != nil {
+			t.Errorf("SetDefaultIdleTimeout failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.Timeout, wantTimeout); diff != "" {
+			t.Errorf("incorrect timeout set; -got +want: %s", diff)
+		}
+
+		timeout, err := cli.DefaultIdleTimeout(ctx)
+		if err != nil {
+			t.Errorf("DefaultIdleTimeout failed: %v", err)
+		}
+		if diff := cmp.Diff(timeout, wantTimeout); diff != "" {
+			t.Errorf("incorrect timeout returned; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerPersistSession(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		for _, want := range []bool{true, false} {
+			if err := cli.SetPersistSession(ctx, want); err != nil {
+				t.Errorf("SetPersistSession failed: %v", err)
+			}
+			if diff := cmp.Diff(mgr.Persist, want); diff != "" {
+				t.Errorf("incorrect setting; -got +want: %s", diff)
+			}
+
+			persist, err := cli.PersistSession(ctx)
+			if err != nil {
+				t.Errorf("PersistSession failed: %v", err)
+			}
+			if diff := cmp.Diff(persist, want); diff != "" {
+				t.Errorf("incorrect setting returned; -got +want: %s", diff)
+			}
+		}
+	})
+}
+
+func TestClientServerLock(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		if err := cli.Lock(ctx, "lock-secret"); err != nil {
+			t.Errorf("Lock failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.Passphrase, "lock-secret"); diff != "" {
+			t.Errorf("incorrect passphrase; -got +want: %s", diff)
+		}
+		locked, err := cli.Locked(ctx)
+		if err != nil {
+			t.Errorf("Locked failed: %v", err)
+		}
+		if !locked {
+			t.Errorf("incorrect lock state; got %t, want true", locked)
+		}
+
+		if err := cli.Unlock(ctx, "unlock-secret"); err != nil {
+			t.Errorf("Unlock failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.Passphrase, "unlock-secret"); diff != "" {
+			t.Errorf("incorrect passphrase; -got +want: %s", diff)
+		}
+		locked, err = cli.Locked(ctx)
+		if err != nil {
+			t.Errorf("Locked failed: %v", err)
+		}
+		if locked {
+			t.Errorf("incorrect lock state; got %t, want false", locked)
+		}
+	})
+}
+
+func TestClientServerSetConfirmUse(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetConfirmUse(ctx, wantID, true)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if !mgr.ConfirmUse {
+			t.Errorf("incorrect confirm-use setting; got false, want true")
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerSetRefuseSHA1(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetRefuseSHA1(ctx, wantID, true)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if !mgr.RefuseSHA1 {
+			t.Errorf("incorrect refuse-SHA-1 setting; got false, want true")
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerSetPriority(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetPriority(ctx, wantID, -3)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if mgr.Priority != -3 {
+			t.Errorf("incorrect priority; got %d, want -3", mgr.Priority)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerSetDestinations(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantDestinations := []string{"host-0 key-0", "host-1 key-1"}
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetDestinations(ctx, wantID, wantDestinations, true)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Destinations, wantDestinations); diff != "" {
+			t.Errorf("incorrect destinations; -got +want: %s", diff)
+		}
+		if !mgr.RequireBind {
+			t.Errorf("incorrect require-session-bind setting; got false, want true")
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerAuditLog(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantEntries := []*AuditEntry{
+			{TimeMillis: 1000, ID: "id-0", FingerprintSHA256: "fingerprint-0", Connection: "connection-0", Host: "host-0", Refused: "refused"},
+			{TimeMillis: 2000, ID: "id-1", FingerprintSHA256: "fingerprint-1"},
+		}
+		wantErr := errors.New("failed")
+
+		mgr.AuditEntries = wantEntries
+		mgr.Err = wantErr
+
+		entries, err := cli.AuditLog(ctx)
+		if diff := cmp.Diff(entries, wantEntries); diff != "" {
+			t.Errorf("incorrect entries; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerPublicKeys(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantEntries := []*PublicKeyEntry{
+			{ID: "id-0", Name: "name-0", AuthorizedKey: "ssh-ed25519 AAAA name-0"},
+			{ID: "id-1", Name: "name-1"},
+		}
+		wantErr := errors.New("failed")
+
+		mgr.PubKeyEntries = wantEntries
+		mgr.Err = wantErr
+
+		entries, err := cli.PublicKeys(ctx)
+		if diff := cmp.Diff(entries, wantEntries); diff != "" {
+			t.Errorf("incorrect entries; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerDefaultKeyEncryption(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		if err := cli.SetDefaultKeyEncryption(ctx, EncryptionArgon2id); err != nil {
+			t.Errorf("SetDefaultKeyEncryption failed: %v", err)
+		}
+		if mgr.Encryption != EncryptionArgon2id {
+			t.Errorf("incorrect encryption set; got %s, want %s", mgr.Encryption, EncryptionArgon2id)
+		}
+
+		enc, err := cli.DefaultKeyEncryption(ctx)
+		if err != nil {
+			t.Errorf("DefaultKeyEncryption failed: %v", err)
+		}
+		if enc != EncryptionArgon2id {
+			t.Errorf("incorrect encryption; got %s, want %s", enc, EncryptionArgon2id)
+		}
+	})
+}
+
+func TestClientServerSetKeyEncryption(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantErr := errors.New("failed")
+		mgr.Err = wantErr
+
+		err := cli.SetKeyEncryption(ctx, ID("id-0"), EncryptionArgon2id, "secret")
+		if mgr.ID != ID("id-0") || mgr.Encryption != EncryptionArgon2id || mgr.Passphrase != "secret" {
+			t.Errorf("incorrect request; got id=%s, encryption=%s, passphrase=%s", mgr.ID, mgr.Encryption, mgr.Passphrase)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerReencryptAll(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantResults := []*Result{
+			{ID: ID("id-0")},
+			{ID: ID("id-1"), Err: errors.New("incorrect passphrase")},
+		}
+		mgr.Results = wantResults
+
+		results, err := cli.ReencryptAll(ctx, "secret")
+		if err != nil {
+			t.Errorf("ReencryptAll failed: %v", err)
+		}
+		if mgr.Passphrase != "secret" {
+			t.Errorf("incorrect passphrase; got %s, want secret", mgr.Passphrase)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(results, wantResults, resultStringCmp); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerMasterKey(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{MasterState: MasterKeyDisabled}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		// expectState checks the state reported by the client.
+		expectState := func(description string, want MasterKeyState) {
+			t.Helper()
+			state, err := cli.MasterKeyState(ctx)
+			if err != nil {
+				t.Errorf("%s: MasterKeyState failed: %v", description, err)
+			}
+			if state != want {
+				t.Errorf("%s: incorrect state; got %s, want %s", description, state, want)
+			}
+		}
+		expectState("initial", MasterKeyDisabled)
+
+		wantRequests := []*LoadRequest{
+			{ID: ID("id-0"), Passphrase: "secret-0"},
+			{ID: ID("id-1")},
+		}
+		wantResults := []*Result{
+			{ID: ID("id-0")},
+			{ID: ID("id-1"), Err: errors.New("incorrect passphrase")},
+		}
+		mgr.Results = wantResults
+		results, err := cli.EnableMasterKey(ctx, "master", wantRequests)
+		if err != nil {
+			t.Errorf("EnableMasterKey failed: %v", err)
+		}
+		if mgr.Passphrase != "master" {
+			t.Errorf("incorrect master passphrase; got %s, want master", mgr.Passphrase)
+		}
+		if diff := cmp.Diff(mgr.LoadRequests, wantRequests); diff != "" {
+			t.Errorf("incorrect requests; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(results, wantResults, resultStringCmp); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+		expectState("enabled", MasterKeyUnlocked)
+
+		if err := cli.LockMasterKey(ctx); err != nil {
+			t.Errorf("LockMasterKey failed: %v", err)
+		}
+		expectState("locked", MasterKeyLocked)
+
+		// The identity of the error is preserved.
+		mgr.Err = fmt.Errorf("failed to decrypt master key: %w", ErrWrongPassphrase)
+		if err := cli.UnlockMasterKey(ctx, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("UnlockMasterKey returned incorrect error: %v", err)
+		}
+		mgr.Err = nil
+		if err := cli.UnlockMasterKey(ctx, "master"); err != nil {
+			t.Errorf("UnlockMasterKey failed: %v", err)
+		}
+		expectState("unlocked", MasterKeyUnlocked)
+
+		mgr.Results = nil
+		if _, err := cli.DisableMasterKey(ctx, "master", wantRequests); err != nil {
+			t.Errorf("DisableMasterKey failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.LoadRequests, wantRequests); diff != "" {
+			t.Errorf("incorrect requests; -got +want: %s", diff)
+		}
+		expectState("disabled", MasterKeyDisabled)
+	})
+}
+
+func TestClientServerClearAuditLog(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.ClearAuditLog(ctx)
+		if !mgr.AuditCleared {
+			t.Errorf("audit log not cleared")
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerAuditLogEnabled(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantErr := errors.New("failed")
+
+		mgr.AuditEnabled = true
+		mgr.Err = wantErr
+
+		enabled, err := cli.AuditLogEnabled(ctx)
+		if !enabled {
+			t.Errorf("incorrect audit log setting; got false, want true")
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerSetAuditLogEnabled(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetAuditLogEnabled(ctx, true)
+		if !mgr.AuditEnabled {
+			t.Errorf("incorrect audit log setting; got false, want true")
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerSetCertificate(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantCertificate := "certificate"
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetCertificate(ctx, wantID, wantCertificate)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Certificate, wantCertificate); diff != "" {
+			t.Errorf("incorrect certificate; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerSetExpiry(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantExpiresAt := time.UnixMilli(1700000000000)
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetExpiry(ctx, wantID, wantExpiresAt)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if !mgr.ExpiresAt.Equal(wantExpiresAt) {
+			t.Errorf("incorrect expiry; got %s, want %s", mgr.ExpiresAt, wantExpiresAt)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		// A zero time clears the expiry.
+		if err := cli.SetExpiry(ctx, wantID, time.Time{}); !mgr.ExpiresAt.IsZero() {
+			t.Errorf("incorrect expiry; got %s, want zero (err=%v)", mgr.ExpiresAt, err)
+		}
+	})
+}
+
+func TestClientServerAllowExpired(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetAllowExpired(ctx, true)
+		if !mgr.AllowExp {
+			t.Errorf("incorrect setting; got false, want true")
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		allow, err := cli.AllowExpired(ctx)
+		if !allow {
+			t.Errorf("incorrect setting; got false, want true")
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerMaxLoadedKeys(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetMaxLoadedKeys(ctx, SuggestedMaxLoadedKeys)
+		if mgr.MaxLoaded != SuggestedMaxLoadedKeys {
+			t.Errorf("incorrect limit; got %d, want %d", mgr.MaxLoaded, SuggestedMaxLoadedKeys)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		max, err := cli.MaxLoadedKeys(ctx)
+		if max != SuggestedMaxLoadedKeys {
+			t.Errorf("incorrect limit; got %d, want %d", max, SuggestedMaxLoadedKeys)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		// The identity of the error is preserved.
+		mgr.Err = &TooManyLoadedKeysError{Max: 1, Loaded: []string{`"my-key"`}}
+		if err := cli.SetMaxLoadedKeys(ctx, 1); !errors.Is(err, ErrTooManyLoadedKeys) {
+			t.Errorf("incorrect error; got %v, want %v", err, ErrTooManyLoadedKeys)
+		}
+	})
+}
+
+func TestClientServerKeyOrder(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetKeyOrder(ctx, KeyOrderRecent)
+		if mgr.Order != KeyOrderRecent {
+			t.Errorf("incorrect order; got %s, want %s", mgr.Order, KeyOrderRecent)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		order, err := cli.KeyOrder(ctx)
+		if order != KeyOrderRecent {
+			t.Errorf("incorrect order; got %s, want %s", order, KeyOrderRecent)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerProtocolKeyPolicy(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetProtocolKeyPolicy(ctx, ProtocolKeysPersist)
+		if mgr.ProtoPolicy != ProtocolKeysPersist {
+			t.Errorf("incorrect policy; got %s, want %s", mgr.ProtoPolicy, ProtocolKeysPersist)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		policy, err := cli.ProtocolKeyPolicy(ctx)
+		if policy != ProtocolKeysPersist {
+			t.Errorf("incorrect policy; got %s, want %s", policy, ProtocolKeysPersist)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerAllowedClients(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantClients := []string{"client-1", "client-2"}
+		wantErr := errors.New("failed")
+
+		mgr.Clients = wantClients
+		mgr.Err = wantErr
+
+		clients, err := cli.AllowedClients(ctx)
+		if diff := cmp.Diff(clients, wantClients); diff != "" {
+			t.Errorf("incorrect clients; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		err = cli.AllowClient(ctx, "client-3")
+		if mgr.ClientID != "client-3" {
+			t.Errorf("incorrect client allowed; got %s, want client-3", mgr.ClientID)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		err = cli.DisallowClient(ctx, "client-1")
+		if mgr.ClientID != "client-1" {
+			t.Errorf("incorrect client disallowed; got %s, want client-1", mgr.ClientID)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerNativeHosts(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantHosts := []string{"com.example.host1", "com.example.host2"}
+		wantErr := errors.New("failed")
+
+		mgr.Hosts = wantHosts
+		mgr.Err = wantErr
+
+		hosts, err := cli.NativeHosts(ctx)
+		if diff := cmp.Diff(hosts, wantHosts); diff != "" {
+			t.Errorf("incorrect hosts; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		err = cli.AllowNativeHost(ctx, "com.example.host3")
+		if mgr.HostName != "com.example.host3" {
+			t.Errorf("incorrect host allowed; got %s, want com.example.host3", mgr.HostName)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		err = cli.DisallowNativeHost(ctx, "com.example.host1")
+		if mgr.HostName != "com.example.host1" {
+			t.Errorf("incorrect host disallowed; got %s, want com.example.host1", mgr.HostName)
+		}
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerGenerateSecurityKey(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantName := "some-name"
+		wantKeyType := KeyTypeECDSA
+		wantPublicKey := "public-key"
+
+		mgr.PublicKey = wantPublicKey
+
+		pub, err := cli.GenerateSecurityKey(ctx, wantName, wantKeyType)
+		if err != nil {
+			t.Errorf("GenerateSecurityKey failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.Name, wantName); diff != "" {
+			t.Errorf("incorrect name; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.KeyType, wantKeyType); diff != "" {
+			t.Errorf("incorrect key type; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(pub, wantPublicKey); diff != "" {
+			t.Errorf("incorrect public key; -got +want: %s", diff)
+		}
+
+		wantErr := errors.New("failed")
+		mgr.Err = wantErr
+		_, err = cli.GenerateSecurityKey(ctx, wantName, wantKeyType)
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerGenerateCryptoKey(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantName := "some-name"
+		wantPublicKey := "public-key"
+
+		mgr.PublicKey = wantPublicKey
+
+		pub, err := cli.GenerateCryptoKey(ctx, wantName)
+		if err != nil {
+			t.Errorf("GenerateCryptoKey failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.Name, wantName); diff != "" {
+			t.Errorf("incorrect name; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(pub, wantPublicKey); diff != "" {
+			t.Errorf("incorrect public key; -got +want: %s", diff)
+		}
+
+		wantErr := errors.New("failed")
+		mgr.Err = wantErr
+		_, err = cli.GenerateCryptoKey(ctx, wantName)
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerLoadEphemeral(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantName := "some-name"
+		wantPEMPrivateKey := "private-key"
+		wantPassphrase := "secret"
+		wantID := ID("some-id")
+
+		mgr.ID = wantID
+
+		id, err := cli.LoadEphemeral(ctx, wantName, wantPEMPrivateKey, wantPassphrase)
+		if err != nil {
+			t.Errorf("LoadEphemeral failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.Name, wantName); diff != "" {
+			t.Errorf("incorrect name; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.PEMPrivateKey, wantPEMPrivateKey); diff != "" {
+			t.Errorf("incorrect private key; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Passphrase, wantPassphrase); diff != "" {
+			t.Errorf("incorrect passphrase; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(id, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+
+		wantErr := errors.New("failed")
+		mgr.Err = wantErr
+		_, err = cli.LoadEphemeral(ctx, wantName, wantPEMPrivateKey, wantPassphrase)
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestNotifyIdleUnloaded(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		var got []ID
+		hub.AddReceiver(NewIdleUnloadReceiver(func(_ jsutil.AsyncContext, ids []ID) {
+			got = ids
+		}))
+		// Other messages are ignored.
+		hub.AddReceiver(NewServer(&dummyManager{}))
+		if _, err := NewClient(hub).Configured(ctx); err != nil {
+			t.Errorf("Configured failed: %v", err)
+		}
+		if got != nil {
+			t.Errorf("receiver invoked for unrelated message: %v", got)
+		}
+
+		wantIDs := []ID{ID("id-0"), ID("id-1")}
+		NotifyIdleUnloaded(ctx, hub, wantIDs)
+		if diff := cmp.Diff(got, wantIDs); diff != "" {
+			t.Errorf("incorrect IDs; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestNotifyChanges(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		var got []ChangeEvent
+		hub.AddReceiver(NewChangeReceiver(func(_ jsutil.AsyncContext, events []ChangeEvent) {
+			got = events
+		}))
+		// Other messages are ignored.
+		hub.AddReceiver(NewServer(&dummyManager{}))
+		if _, err := NewClient(hub).Configured(ctx); err != nil {
+			t.Errorf("Configured failed: %v", err)
+		}
+		if got != nil {
+			t.Errorf("receiver invoked for unrelated message: %v", got)
+		}
+
+		want := []ChangeEvent{
+			{Kind: KeyAdded, ID: ID("id-0")},
+			{Kind: KeyRenamed, ID: ID("id-1")},
+		}
+		NotifyChanges(ctx, hub, want)
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect events; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerSetTags(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantID := ID("id-0")
+		wantTags := []string{"personal", "work"}
+		wantErr := errors.New("failed")
+
+		mgr.Err = wantErr
+
+		err := cli.SetTags(ctx, wantID, wantTags)
+		if diff := cmp.Diff(mgr.ID, wantID); diff != "" {
+			t.Errorf("incorrect ID; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Tags, wantTags); diff != "" {
+			t.Errorf("incorrect tags; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
 		// information is lost on conversion to/from JSON in message hub.
 		if diff := cmp.Diff(err, wantErr, errStringCmp); diff != "" {
 			t.Errorf("incorrect error; -got +want: %s", diff)
 		}
 	})
 }
+
+func TestClientServerLoadByTag(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantResults := []*Result{
+			{ID: ID("id-0")},
+			{ID: ID("id-1"), Err: errors.New("incorrect passphrase")},
+		}
+
+		mgr.Results = wantResults
+
+		results, err := cli.LoadByTag(ctx, "work", "secret")
+		if err != nil {
+			t.Errorf("LoadByTag failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.Tag, "work"); diff != "" {
+			t.Errorf("incorrect tag; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(mgr.Passphrase, "secret"); diff != "" {
+			t.Errorf("incorrect passphrase; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(results, wantResults, resultStringCmp); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientServerLoadMatching(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		wantResults := &MatchResults{
+			Loaded: []ID{ID("id-0"), ID("id-2")},
+			Locked: []*Result{
+				{ID: ID("id-1"), Err: errors.New("incorrect passphrase")},
+			},
+		}
+
+		mgr.MatchResults = wantResults
+
+		results, err := cli.LoadMatching(ctx, "secret")
+		if err != nil {
+			t.Errorf("LoadMatching failed: %v", err)
+		}
+		if diff := cmp.Diff(mgr.Passphrase, "secret"); diff != "" {
+			t.Errorf("incorrect passphrase; -got +want: %s", diff)
+		}
+		// Compare by error string; cmp.EquateErrors doesn't work since type
+		// information is lost on conversion to/from JSON in message hub.
+		if diff := cmp.Diff(results, wantResults, resultStringCmp); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestClientPreservesErrors(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		mgr := &dummyManager{}
+		cli := NewClient(hub)
+		srv := NewServer(mgr)
+		hub.AddReceiver(srv)
+
+		for _, wantErr := range []error{ErrWrongPassphrase, ErrPassphraseRequired, ErrKeyCorrupt} {
+			mgr.Err = fmt.Errorf("failed to decrypt key: %w: details", wantErr)
+			err := cli.Load(ctx, ID("id-0"), "secret")
+			if !errors.Is(err, wantErr) {
+				t.Errorf("incorrect error; got %v, want %v", err, wantErr)
+			}
+			if diff := cmp.Diff(err.Error(), mgr.Err.Error()); diff != "" {
+				t.Errorf("incorrect error message; -got +want: %s", diff)
+			}
+		}
+
+		mgr.Err = errors.New("failed")
+		err := cli.Load(ctx, ID("id-0"), "secret")
+		for _, e := range wireErrors {
+			if errors.Is(err, e) {
+				t.Errorf("error %v unexpectedly matches %v", err, e)
+			}
+		}
+	})
+}
@@ -0,0 +1,174 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// DefaultAllowedClients are the IDs of the extensions permitted to connect to
+// the agent until the allowlist is changed: the official builds of Secure
+// Shell. They are also those listed as externally_connectable in the manifest,
+// such that Chrome refuses connections from any other extension; the allowlist
+// may only narrow them.
+var DefaultAllowedClients = []string{
+	"pnhechapfaindjhompbnflcldabbghjo",
+	"okddffdblfhhnmhodogpojmfkjmhinfp",
+	"iodihamcpbpeioajjeobimgagajmlibd",
+	"algkcnfjnajfhgimadimbjhmpaeohhln",
+	"ooiklbnjmhbcgemelgfhaeaocllobloj",
+	"hmgggebkhjjkiimkjlknpdgapncghehh",
+}
+
+var (
+	errInvalidClientID      = errors.New("invalid extension ID")
+	errClientNotConnectable = errors.New("extension not permitted to connect by the manifest")
+)
+
+// validClientID determines if id is a valid extension ID: 32 characters in
+// the range a-p.
+func validClientID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if c < 'a' || c > 'p' {
+			return false
+		}
+	}
+	return true
+}
+
+// allowedClients returns the IDs of the extensions permitted to connect to the
+// agent.
+func allowedClients(s *managerSettings) []string {
+	if s == nil || !s.CustomAllowedClients {
+		return slices.Clone(DefaultAllowedClients)
+	}
+	return slices.Clone(s.AllowedClients)
+}
+
+// AllowedClients implements Manager.AllowedClients.
+func (m *DefaultManager) AllowedClients(ctx jsutil.AsyncContext) ([]string, error) {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings: %w", err)
+	}
+	return allowedClients(s), nil
+}
+
+// AllowClient implements Manager.AllowClient.
+func (m *DefaultManager) AllowClient(ctx jsutil.AsyncContext, id string) error {
+	if !validClientID(id) {
+		return fmt.Errorf("%w: %s", errInvalidClientID, id)
+	}
+	if !slices.Contains(DefaultAllowedClients, id) {
+		return fmt.Errorf("%w: %s", errClientNotConnectable, id)
+	}
+	return m.updateAllowedClients(ctx, func(clients []string) []string {
+		if slices.Contains(clients, id) {
+			return clients
+		}
+		return append(clients, id)
+	})
+}
+
+// DisallowClient implements Manager.DisallowClient.
+func (m *DefaultManager) DisallowClient(ctx jsutil.AsyncContext, id string) error {
+	return m.updateAllowedClients(ctx, func(clients []string) []string {
+		return slices.DeleteFunc(clients, func(c string) bool { return c == id })
+	})
+}
+
+// updateAllowedClients replaces the allowlist with the result of update, and
+// applies it to connections made from now on.
+func (m *DefaultManager) updateAllowedClients(ctx jsutil.AsyncContext, update func(clients []string) []string) error {
+	var clients []string
+	if err := m.updateSettings(ctx, func(s *managerSettings) {
+		clients = update(allowedClients(s))
+		s.AllowedClients = clients
+		s.CustomAllowedClients = true
+	}); err != nil {
+		return err
+	}
+	m.setAllowedClients(clients)
+	return nil
+}
+
+// setAllowedClients replaces the allowlist consulted by ClientAllowed.
+func (m *DefaultManager) setAllowedClients(clients []string) {
+	allowed := map[string]bool{}
+	for _, c := range clients {
+		allowed[c] = true
+	}
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+	m.allowedClients = allowed
+}
+
+// refreshAllowedClients reads the allowlist consulted by ClientAllowed from
+// storage, and returns it.
+func (m *DefaultManager) refreshAllowedClients(ctx jsutil.AsyncContext) ([]string, error) {
+	clients, err := m.AllowedClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.setAllowedClients(clients)
+	return clients, nil
+}
+
+// ClientAllowed determines if the extension with the specified ID is permitted
+// to connect to the agent. It does not block, such that it may be consulted
+// before any message from a new connection is processed. No extension is
+// permitted until the allowlist is read using WatchAllowedClients, or changed
+// using AllowClient or DisallowClient.
+func (m *DefaultManager) ClientAllowed(id string) bool {
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+	return m.allowedClients[id]
+}
+
+// WatchAllowedClients reads the allowlist consulted by ClientAllowed, and
+// keeps it up-to-date with changes observed in storage, such as when made from
+// another device. f is invoked with the allowlist once it is read, and again
+// whenever settings change, such that connections from extensions that are no
+// longer permitted may be closed.
+//
+// The returned cleanup function must be invoked to stop watching for changes.
+func (m *DefaultManager) WatchAllowedClients(ctx jsutil.AsyncContext, f func(ctx jsutil.AsyncContext, clients []string)) (jsutil.CleanupFunc, error) {
+	clients, err := m.refreshAllowedClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f(ctx, clients)
+	return m.settings.Watch(func(_ map[string]js.Value, _ []string) {
+		jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+			clients, err := m.refreshAllowedClients(ctx)
+			if err != nil {
+				jsutil.LogError("failed to refresh allowed clients: %v", err)
+				return js.Undefined(), nil
+			}
+			f(ctx, clients)
+			return js.Undefined(), nil
+		})
+	}), nil
+}
@@ -0,0 +1,134 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAllowedClients(t *testing.T) {
+	t.Parallel()
+
+	const (
+		secureShell = "pnhechapfaindjhompbnflcldabbghjo"
+		fork        = "abcdefghijklmnopabcdefghijklmnop"
+	)
+	others := slices.DeleteFunc(slices.Clone(DefaultAllowedClients), func(id string) bool { return id == secureShell })
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		mgr := NewManager(agent.NewKeyring(), syncStorage, storage.NewRaw(st.NewMemArea()))
+		// other shares storage with mgr, as would a manager on another
+		// device.
+		other := NewManager(agent.NewKeyring(), syncStorage, storage.NewRaw(st.NewMemArea()))
+
+		// expect checks the allowlist, and that connections are permitted
+		// accordingly.
+		expect := func(description string, want ...string) {
+			t.Helper()
+			clients, err := mgr.AllowedClients(ctx)
+			if err != nil {
+				t.Fatalf("%s: AllowedClients failed: %v", description, err)
+			}
+			if diff := cmp.Diff(clients, want, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%s: incorrect clients; -got +want: %s", description, diff)
+			}
+			for _, id := range append([]string{fork}, DefaultAllowedClients...) {
+				if got, wantAllowed := mgr.ClientAllowed(id), slices.Contains(want, id); got != wantAllowed {
+					t.Errorf("%s: incorrect result for %s; got %t, want %t", description, id, got, wantAllowed)
+				}
+			}
+		}
+
+		// No client is permitted until the allowlist is read.
+		if mgr.ClientAllowed(secureShell) {
+			t.Errorf("client permitted before allowlist read")
+		}
+		var watchedMu sync.Mutex
+		var watched []string
+		stop, err := mgr.WatchAllowedClients(ctx, func(_ jsutil.AsyncContext, clients []string) {
+			watchedMu.Lock()
+			defer watchedMu.Unlock()
+			watched = clients
+		})
+		if err != nil {
+			t.Fatalf("WatchAllowedClients failed: %v", err)
+		}
+		defer stop()
+		expect("default", DefaultAllowedClients...)
+
+		// Only extensions listed in the manifest may be permitted.
+		if err := mgr.AllowClient(ctx, "not-an-extension-id"); !errors.Is(err, errInvalidClientID) {
+			t.Errorf("AllowClient returned incorrect error for invalid ID: %v", err)
+		}
+		if err := mgr.AllowClient(ctx, fork); !errors.Is(err, errClientNotConnectable) {
+			t.Errorf("AllowClient returned incorrect error for extension not in manifest: %v", err)
+		}
+		expect("not in manifest", DefaultAllowedClients...)
+
+		if err := mgr.DisallowClient(ctx, secureShell); err != nil {
+			t.Fatalf("DisallowClient failed: %v", err)
+		}
+		expect("disallowed", others...)
+		if err := mgr.AllowClient(ctx, secureShell); err != nil {
+			t.Fatalf("AllowClient failed: %v", err)
+		}
+		expect("allowed", append(slices.Clone(others), secureShell)...)
+
+		// Clients may be allowed more than once.
+		if err := mgr.AllowClient(ctx, secureShell); err != nil {
+			t.Fatalf("AllowClient failed: %v", err)
+		}
+		expect("allowed again", append(slices.Clone(others), secureShell)...)
+
+		// Changes made elsewhere are observed, and reported to the
+		// watcher.
+		for _, id := range others {
+			if err := other.DisallowClient(ctx, id); err != nil {
+				t.Fatalf("DisallowClient failed: %v", err)
+			}
+		}
+		poll(func() bool {
+			watchedMu.Lock()
+			defer watchedMu.Unlock()
+			return len(watched) == 1
+		})
+		expect("disallowed elsewhere", secureShell)
+		watchedMu.Lock()
+		if diff := cmp.Diff(watched, []string{secureShell}); diff != "" {
+			t.Errorf("incorrect clients reported to watcher; -got +want: %s", diff)
+		}
+		watchedMu.Unlock()
+
+		// An empty allowlist is not replaced by the defaults.
+		if err := mgr.DisallowClient(ctx, secureShell); err != nil {
+			t.Fatalf("DisallowClient failed: %v", err)
+		}
+		expect("empty")
+	})
+}
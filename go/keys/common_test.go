@@ -23,6 +23,7 @@ import (
 	"github.com/google/chrome-ssh-agent/go/storage"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
 )
 
 func findKey(ctx jsutil.AsyncContext, mgr Manager, byID ID, byName string) (ID, error) {
@@ -44,6 +45,32 @@ func findKey(ctx jsutil.AsyncContext, mgr Manager, byID ID, byName string) (ID,
 	return InvalidID, fmt.Errorf("failed to find key with name %s", byName)
 }
 
+// blobFingerprints returns the SHA256 and MD5 fingerprints of the
+// base64-encoded public key.
+func blobFingerprints(blob string) (string, string) {
+	b, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		panic(err)
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		panic(err)
+	}
+	return fingerprints(pub)
+}
+
+func blobKeyType(blob string) (string, int) {
+	b, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		panic(err)
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		panic(err)
+	}
+	return keyType(pub)
+}
+
 func configuredKeyNames(keys []*ConfiguredKey) []string {
 	var result []string
 	for _, k := range keys {
@@ -91,6 +118,9 @@ var (
 	// Custom Comparer for LoadedKey type.  'blob' is an unexported
 	// field, so we explicitly compare it.
 	loadedKeyCmp = cmp.Comparer(func(a, b *LoadedKey) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
 		return cmp.Equal(a, b, cmpopts.IgnoreUnexported(LoadedKey{})) &&
 			cmp.Equal(a.Blob(), b.Blob())
 	})
@@ -102,6 +132,21 @@ var (
 		return a.Error() == b.Error()
 	})
 
+	// Custom Comparer for Result type, comparing errors by string. Used
+	// only when we can't use the standard cmpopts.EquateErrors, as for
+	// errStringCmp.
+	resultStringCmp = cmp.Comparer(func(a, b *Result) bool {
+		return a.ID == b.ID && makeErrStr(a.Err) == makeErrStr(b.Err)
+	})
+
+	// Option to ignore fingerprints of configured keys, for tests that
+	// do not cover them.
+	ignoreFingerprints = cmpopts.IgnoreFields(ConfiguredKey{}, "FingerprintSHA256", "FingerprintMD5")
+
+	// Option to ignore the type and size of configured keys, for tests
+	// that do not cover them.
+	ignoreKeyType = cmpopts.IgnoreFields(ConfiguredKey{}, "Type", "Bits")
+
 	// Option for order-independent slices of IDs.
 	idSlice = cmpopts.SortSlices(func(a, b ID) bool {
 		return a < b
@@ -0,0 +1,105 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// ConfirmRequest is a request to approve use of a key to sign.
+type ConfirmRequest struct {
+	// ID is the ID of the key.
+	ID ID
+	// Name is the name of the key.
+	Name string
+	// Client describes the client that requested the signature, or is
+	// empty if it is not known.
+	Client string
+	// Host describes the host to which the client is connected, if it
+	// reported it using the session-bind@openssh.com extension.
+	Host string
+}
+
+// ConfirmFunc asks the user to approve use of a key to sign. It blocks until
+// the user responds or the request times out, and returns true only if use
+// was approved.
+type ConfirmFunc func(req *ConfirmRequest) bool
+
+// SetConfirmer sets the function used to confirm use of keys for which
+// confirmation is required. If none is set, use of those keys is denied.
+func (m *DefaultManager) SetConfirmer(f ConfirmFunc) {
+	m.confirmer = f
+}
+
+// applyConfirmUse applies the confirmation setting for a key.
+func (m *DefaultManager) applyConfirmUse(key *storedKey) {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	if !key.ConfirmUse {
+		delete(m.confirmUse, ID(key.ID))
+		return
+	}
+	m.confirmUse[ID(key.ID)] = key.Name
+}
+
+// confirm is invoked by the agent before the key with the specified ID is used
+// to sign on behalf of the requester, which is nil if it is not known. It
+// returns true if use of the key may proceed.
+func (m *DefaultManager) confirm(id ID, r *requester) bool {
+	m.keysMu.Lock()
+	name, ok := m.confirmUse[id]
+	m.keysMu.Unlock()
+	if !ok {
+		return true
+	}
+	if m.confirmer == nil {
+		jsutil.LogError("no confirmer available; denying use of key ID %s", id)
+		return false
+	}
+	req := &ConfirmRequest{ID: id, Name: name}
+	if r != nil {
+		req.Client = r.conn.String()
+		req.Host = m.describeHost(id, r.bind)
+	}
+	approved := m.confirmer(req)
+	jsutil.LogDebug("DefaultManager.confirm: key ID %s approved=%t", id, approved)
+	return approved
+}
+
+// SetConfirmUse implements Manager.SetConfirmUse.
+func (m *DefaultManager) SetConfirmUse(ctx jsutil.AsyncContext, id ID, confirmUse bool) error {
+	var key *storedKey
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(k *storedKey) (*storedKey, error) {
+		updated := *k
+		updated.ConfirmUse = confirmUse
+		key = &updated
+		return &updated, nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+
+	// Apply the setting regardless of whether the key is loaded; it is
+	// only consulted for loaded keys.
+	m.applyConfirmUse(key)
+	return nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// signWith signs using the loaded key with the specified ID.
+func signWith(mgr *DefaultManager, id ID) error {
+	loaded, err := mgr.Agent().List()
+	if err != nil {
+		return err
+	}
+	for _, l := range loaded {
+		if (&LoadedKey{Comment: l.Comment}).ID() == id {
+			_, err := mgr.Agent().Sign(l, []byte("data"))
+			return err
+		}
+	}
+	return errKeyNotFound
+}
+
+func TestConfirmUse(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "confirmed-key", PEMPrivateKey: testdata.WithoutPassphrase.Private, Load: true},
+			{Name: "other-key", PEMPrivateKey: testdata.ECDSAWithoutPassphrase.Private, Load: true},
+		})
+		if err != nil {
+			t.Errorf("failed to initialize manager: %v", err)
+			return
+		}
+		confirmedID, err := findKey(ctx, mgr, InvalidID, "confirmed-key")
+		if err != nil {
+			t.Errorf("failed to find key: %v", err)
+			return
+		}
+		otherID, err := findKey(ctx, mgr, InvalidID, "other-key")
+		if err != nil {
+			t.Errorf("failed to find key: %v", err)
+			return
+		}
+
+		if err := mgr.SetConfirmUse(ctx, confirmedID, true); err != nil {
+			t.Errorf("failed to set confirm-use: %v", err)
+			return
+		}
+		configured, err := mgr.Configured(ctx)
+		if err != nil {
+			t.Errorf("failed to get configured keys: %v", err)
+			return
+		}
+		gotConfirm := map[string]bool{}
+		for _, k := range configured {
+			gotConfirm[k.Name] = k.ConfirmUse
+		}
+		if diff := cmp.Diff(gotConfirm, map[string]bool{"confirmed-key": true, "other-key": false}); diff != "" {
+			t.Errorf("incorrect confirm-use settings; -got +want: %s", diff)
+		}
+
+		var asked []string
+		approve := false
+		confirmer := func(req *ConfirmRequest) bool {
+			asked = append(asked, req.Name)
+			return approve
+		}
+
+		for _, step := range []struct {
+			description string
+			mgr         func() *DefaultManager
+			id          ID
+			approve     bool
+			wantAsked   []string
+			wantErr     error
+		}{
+			{
+				description: "denied without confirmer",
+				id:          confirmedID,
+				wantErr:     errUseDenied,
+			},
+			{
+				description: "denied by user",
+				mgr: func() *DefaultManager {
+					mgr.SetConfirmer(confirmer)
+					return mgr
+				},
+				id:        confirmedID,
+				wantAsked: []string{"confirmed-key"},
+				wantErr:   errUseDenied,
+			},
+			{
+				description: "approved by user",
+				id:          confirmedID,
+				approve:     true,
+				wantAsked:   []string{"confirmed-key"},
+			},
+			{
+				description: "confirmation not required",
+				id:          otherID,
+			},
+			{
+				description: "confirmation required after restart",
+				mgr: func() *DefaultManager {
+					restarted := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+					if err := restarted.LoadFromSession(ctx); err != nil {
+						t.Errorf("failed to load keys from session: %v", err)
+					}
+					restarted.SetConfirmer(confirmer)
+					return restarted
+				},
+				id:        confirmedID,
+				wantAsked: []string{"confirmed-key"},
+				wantErr:   errUseDenied,
+			},
+			{
+				description: "confirmation uses new name",
+				mgr: func() *DefaultManager {
+					if err := mgr.Rename(ctx, confirmedID, "renamed-key"); err != nil {
+						t.Errorf("failed to rename key: %v", err)
+					}
+					return mgr
+				},
+				id:        confirmedID,
+				approve:   true,
+				wantAsked: []string{"renamed-key"},
+			},
+			{
+				description: "confirmation disabled",
+				mgr: func() *DefaultManager {
+					if err := mgr.SetConfirmUse(ctx, confirmedID, false); err != nil {
+						t.Errorf("failed to clear confirm-use: %v", err)
+					}
+					return mgr
+				},
+				id: confirmedID,
+			},
+		} {
+			if step.mgr != nil {
+				mgr = step.mgr()
+			}
+			asked = nil
+			approve = step.approve
+			err := signWith(mgr, step.id)
+			if diff := cmp.Diff(err, step.wantErr, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s: incorrect error; -got +want: %s", step.description, diff)
+			}
+			if diff := cmp.Diff(asked, step.wantAsked); diff != "" {
+				t.Errorf("%s: incorrect confirmations; -got +want: %s", step.description, diff)
+			}
+		}
+
+		err = mgr.SetConfirmUse(ctx, ID("bogus-id"), true)
+		if diff := cmp.Diff(err, errKeyNotFound, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"time"
+)
+
+// Connection identifies a client connected to the agent. See ConnectionAgent.
+type Connection struct {
+	// ExtensionID is the ID of the extension that connected, or empty if
+	// the client is not an extension.
+	ExtensionID string
+	// Name is the name the client gave to the connection, if any.
+	Name string
+	// Connected is the time at which the client connected.
+	Connected time.Time
+}
+
+// String describes the connection: its name, followed by the ID of the
+// extension that connected.
+func (c *Connection) String() string {
+	switch {
+	case c == nil || (c.Name == "" && c.ExtensionID == ""):
+		return "unknown client"
+	case c.ExtensionID == "":
+		return c.Name
+	case c.Name == "":
+		return c.ExtensionID
+	default:
+		return fmt.Sprintf("%s (%s)", c.Name, c.ExtensionID)
+	}
+}
+
+// requester identifies the client on whose behalf a key is used: the
+// connection over which it was requested, and the binding the client reported
+// for it, if any.
+type requester struct {
+	conn *Connection
+	bind *sessionBind
+}
@@ -0,0 +1,159 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestConnectionString(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		conn        *Connection
+		want        string
+	}{
+		{
+			description: "unknown",
+			want:        "unknown client",
+		},
+		{
+			description: "empty",
+			conn:        &Connection{},
+			want:        "unknown client",
+		},
+		{
+			description: "extension only",
+			conn:        &Connection{ExtensionID: "extension-id"},
+			want:        "extension-id",
+		},
+		{
+			description: "name only",
+			conn:        &Connection{Name: "crosh"},
+			want:        "crosh",
+		},
+		{
+			description: "name and extension",
+			conn:        &Connection{ExtensionID: "extension-id", Name: "crosh"},
+			want:        "crosh (extension-id)",
+		},
+	}
+
+	for _, tc := range testcases {
+		if got := tc.conn.String(); got != tc.want {
+			t.Errorf("%s: incorrect description; got %q, want %q", tc.description, got, tc.want)
+		}
+	}
+}
+
+func TestConnectionIdentified(t *testing.T) {
+	t.Parallel()
+
+	hostKey := newHostKey(t)
+	sessionID := []byte("session-id")
+	connected := time.UnixMilli(1700000000000)
+	conn := &Connection{ExtensionID: "extension-id", Name: "crosh", Connected: connected}
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "work-key", PEMPrivateKey: testdata.WithoutPassphrase.Private, Load: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "work-key")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+		if err := mgr.SetDestinations(ctx, id, []string{knownHostsLine("git.example.com", hostKey.PublicKey())}, false); err != nil {
+			t.Fatalf("failed to set destinations: %v", err)
+		}
+		if err := mgr.SetConfirmUse(ctx, id, true); err != nil {
+			t.Fatalf("failed to set confirm-use: %v", err)
+		}
+		var asked []*ConfirmRequest
+		mgr.SetConfirmer(func(req *ConfirmRequest) bool {
+			asked = append(asked, req)
+			return true
+		})
+
+		c, s := net.Pipe()
+		defer c.Close()
+		go agent.ServeAgent(mgr.ConnectionAgent(conn), s)
+		client := agent.NewClient(c)
+
+		bind := makeSessionBind(t, hostKey.PublicKey(), hostKey, sessionID, false)
+		if _, err := client.Extension(sessionBindExtension, bind); err != nil {
+			t.Fatalf("failed to bind session: %v", err)
+		}
+		blob, err := base64.StdEncoding.DecodeString(testdata.WithoutPassphrase.Blob)
+		if err != nil {
+			t.Fatalf("failed to decode public key: %v", err)
+		}
+		pub, err := ssh.ParsePublicKey(blob)
+		if err != nil {
+			t.Fatalf("failed to parse public key: %v", err)
+		}
+		if _, err := client.SignWithFlags(pub, userAuthData(sessionID), agent.SignatureFlagRsaSha256); err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+
+		// The user is told which client wants to use the key, and for
+		// which host.
+		wantAsked := []*ConfirmRequest{{ID: id, Name: "work-key", Client: "crosh (extension-id)", Host: "git.example.com"}}
+		if diff := cmp.Diff(asked, wantAsked); diff != "" {
+			t.Errorf("incorrect confirmation requests; -got +want: %s", diff)
+		}
+
+		// Requests are logged asynchronously.
+		var entries []*AuditEntry
+		poll(func() bool {
+			entries, err = mgr.AuditLog(ctx)
+			return err != nil || len(entries) > 0
+		})
+		if err != nil {
+			t.Fatalf("failed to read audit log: %v", err)
+		}
+		fingerprint, _ := fingerprints(pub)
+		want := []*AuditEntry{{
+			ID:                string(id),
+			FingerprintSHA256: fingerprint,
+			Connection:        "crosh (extension-id)",
+			ExtensionID:       "extension-id",
+			ConnectionName:    "crosh",
+			ConnectedMillis:   connected.UnixMilli(),
+			Host:              "git.example.com",
+		}}
+		if diff := cmp.Diff(entries, want, cmpopts.IgnoreFields(AuditEntry{}, "TimeMillis")); diff != "" {
+			t.Errorf("incorrect audit log; -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	errInvalidDestination = errors.New("invalid destination")
+	errDestinationRefused = errors.New("destination not permitted for key")
+)
+
+// knownHost is a host to which a key may be used to authenticate.
+type knownHost struct {
+	// Hosts are the host patterns from the known_hosts line, which
+	// describe the host when reporting its use.
+	Hosts []string
+	// Key is the host key.
+	Key ssh.PublicKey
+}
+
+// destinationConstraint limits the hosts for which a key may sign. Hosts are
+// identified by the host key that the client reports using the
+// session-bind@openssh.com extension; the agent cannot otherwise determine the
+// host to which a client is connected.
+type destinationConstraint struct {
+	// hosts are the permitted hosts.
+	hosts []*knownHost
+	// requireBind indicates that the key may not be used over connections
+	// for which the client did not report a binding, such as those from
+	// clients that do not support the extension.
+	requireBind bool
+}
+
+// parseDestination parses a destination, which is a line in the known_hosts
+// format: host patterns followed by the host key.
+func parseDestination(destination string) (*knownHost, error) {
+	marker, hosts, key, _, rest, err := ssh.ParseKnownHosts([]byte(destination))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidDestination, err)
+	}
+	if marker != "" {
+		return nil, fmt.Errorf("%w: markers are not supported: %s", errInvalidDestination, marker)
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, fmt.Errorf("%w: expected a single line", errInvalidDestination)
+	}
+	return &knownHost{Hosts: hosts, Key: key}, nil
+}
+
+// describeHost returns a description of the host to which a connection is
+// bound: the host patterns for a permitted host, or otherwise the fingerprint
+// of the host key.
+func (c *destinationConstraint) describeHost(bind *sessionBind) string {
+	for _, h := range c.hosts {
+		if bytes.Equal(h.Key.Marshal(), bind.HostKey.Marshal()) {
+			return strings.Join(h.Hosts, ",")
+		}
+	}
+	return ssh.FingerprintSHA256(bind.HostKey)
+}
+
+// permits determines if the host with the specified host key is permitted.
+func (c *destinationConstraint) permits(hostKey ssh.PublicKey) bool {
+	for _, h := range c.hosts {
+		if bytes.Equal(h.Key.Marshal(), hostKey.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// check determines if the key may sign the data over a connection with the
+// specified bindings, in the order reported by the client. The last is the
+// session for which the data is signed; any earlier bindings are hops through
+// which the agent was forwarded, each of which must be permitted.
+func (c *destinationConstraint) check(data []byte, binds []*sessionBind) error {
+	if len(binds) == 0 {
+		if c.requireBind {
+			return fmt.Errorf("%w: client did not identify the host", errDestinationRefused)
+		}
+		return nil
+	}
+
+	bind := binds[len(binds)-1]
+	// A host through which the agent was forwarded could send a genuine
+	// binding for any host that the key permits, and use the key there.
+	for _, hop := range binds[:len(binds)-1] {
+		if !c.permits(hop.HostKey) {
+			return fmt.Errorf("%w: agent forwarded through %s", errDestinationRefused, c.describeHost(hop))
+		}
+	}
+
+	host := c.describeHost(bind)
+	// The host to which the agent is forwarded may use the key to
+	// authenticate to any other host, so we cannot permit it.
+	if bind.Forwarding {
+		return fmt.Errorf("%w: agent forwarded to %s", errDestinationRefused, host)
+	}
+	// Refuse to sign for a session other than the one that was bound, such
+	// that a signature cannot be relayed to some other host.
+	if !bytes.Equal(sessionID(data), bind.SessionID) {
+		return fmt.Errorf("%w: request is not for the session bound to %s", errDestinationRefused, host)
+	}
+	if !c.permits(bind.HostKey) {
+		return fmt.Errorf("%w: %s", errDestinationRefused, host)
+	}
+	return nil
+}
+
+// applyDestinations applies the destination constraint for a key.
+func (m *DefaultManager) applyDestinations(key *storedKey) {
+	id := ID(key.ID)
+	if len(key.Destinations) == 0 {
+		m.keysMu.Lock()
+		defer m.keysMu.Unlock()
+		delete(m.destinations, id)
+		return
+	}
+
+	c := &destinationConstraint{requireBind: key.RequireSessionBind}
+	for _, d := range key.Destinations {
+		h, err := parseDestination(d)
+		if err != nil {
+			// Destinations are validated when set, so this should
+			// not happen. Refuse all use of the key rather than
+			// relax the constraint.
+			jsutil.LogError("invalid destination for key ID %s: %v", id, err)
+			c = &destinationConstraint{requireBind: true}
+			break
+		}
+		c.hosts = append(c.hosts, h)
+	}
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	m.destinations[id] = c
+}
+
+// destination returns the destination constraint for the key with the
+// specified ID, or nil if it has none.
+func (m *DefaultManager) destination(id ID) *destinationConstraint {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	return m.destinations[id]
+}
+
+// checkDestination determines if the key with the specified ID may sign the
+// data over a connection with the specified bindings, which are empty if the
+// client reported none.
+func (m *DefaultManager) checkDestination(id ID, data []byte, binds []*sessionBind) error {
+	c := m.destination(id)
+	if id == InvalidID || c == nil {
+		return nil
+	}
+	if err := c.check(data, binds); err != nil {
+		jsutil.LogDebug("DefaultManager.checkDestination: refusing to sign with key ID %s: %v", id, err)
+		return fmt.Errorf("key ID %s: %w", id, err)
+	}
+	return nil
+}
+
+// describeHost returns a description of the host to which a connection with
+// the specified binding is connected, for use with the key with the specified
+// ID. It is empty if the client did not report a binding.
+func (m *DefaultManager) describeHost(id ID, bind *sessionBind) string {
+	if bind == nil {
+		return ""
+	}
+	if c := m.destination(id); c != nil {
+		return c.describeHost(bind)
+	}
+	return ssh.FingerprintSHA256(bind.HostKey)
+}
+
+// SetDestinations implements Manager.SetDestinations.
+func (m *DefaultManager) SetDestinations(ctx jsutil.AsyncContext, id ID, destinations []string, requireSessionBind bool) error {
+	var normalized []string
+	for _, d := range destinations {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		if _, err := parseDestination(d); err != nil {
+			return err
+		}
+		normalized = append(normalized, d)
+	}
+
+	var key *storedKey
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(k *storedKey) (*storedKey, error) {
+		updated := *k
+		updated.Destinations = normalized
+		updated.RequireSessionBind = requireSessionBind
+		key = &updated
+		return &updated, nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+
+	// Apply the setting regardless of whether the key is loaded; it is
+	// only consulted for loaded keys.
+	m.applyDestinations(key)
+	return nil
+}
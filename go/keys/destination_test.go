@@ -0,0 +1,343 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// knownHostsLine returns a line in the known_hosts format for the host key.
+func knownHostsLine(host string, key ssh.PublicKey) string {
+	return fmt.Sprintf("%s %s", host, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
+}
+
+// userAuthData returns data to be signed for public key authentication in the
+// specified session.
+func userAuthData(sessionID []byte) []byte {
+	return ssh.Marshal(struct {
+		SessionID []byte
+		Type      byte
+		User      string
+	}{
+		SessionID: sessionID,
+		Type:      50, // SSH_MSG_USERAUTH_REQUEST
+		User:      "user",
+	})
+}
+
+func TestSetDestinations(t *testing.T) {
+	t.Parallel()
+
+	hostKey := newHostKey(t)
+	valid := knownHostsLine("example.com", hostKey.PublicKey())
+
+	testcases := []struct {
+		description  string
+		destinations []string
+		requireBind  bool
+		want         []string
+		wantErr      error
+	}{
+		{
+			description:  "set destinations",
+			destinations: []string{valid, "  ", "\t" + valid + "\n"},
+			requireBind:  true,
+			want:         []string{valid, valid},
+		},
+		{
+			description: "remove destinations",
+		},
+		{
+			description:  "invalid destination",
+			destinations: []string{"example.com not-a-key"},
+			wantErr:      errInvalidDestination,
+		},
+		{
+			description:  "marker",
+			destinations: []string{"@revoked " + valid},
+			wantErr:      errInvalidDestination,
+		},
+		{
+			description:  "multiple lines",
+			destinations: []string{valid + "\n" + valid},
+			wantErr:      errInvalidDestination,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+					{Name: "key", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+				})
+				if err != nil {
+					t.Errorf("failed to initialize manager: %v", err)
+					return
+				}
+				id, err := findKey(ctx, mgr, InvalidID, "key")
+				if err != nil {
+					t.Errorf("failed to find key: %v", err)
+					return
+				}
+
+				err = mgr.SetDestinations(ctx, id, tc.destinations, tc.requireBind)
+				if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("incorrect error; -got +want: %s", diff)
+				}
+				configured, err := mgr.Configured(ctx)
+				if err != nil {
+					t.Errorf("failed to get configured keys: %v", err)
+					return
+				}
+				if diff := cmp.Diff(configured[0].Destinations, tc.want, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("incorrect destinations; -got +want: %s", diff)
+				}
+				if configured[0].RequireSessionBind != (tc.wantErr == nil && tc.requireBind) {
+					t.Errorf("incorrect require-session-bind setting; got %t", configured[0].RequireSessionBind)
+				}
+			})
+		})
+	}
+}
+
+func TestDestinationConstraints(t *testing.T) {
+	t.Parallel()
+
+	hostKey := newHostKey(t)
+	otherKey := newHostKey(t)
+	sessionID := []byte("session-id")
+	destinations := []string{knownHostsLine("example.com", hostKey.PublicKey())}
+
+	testcases := []struct {
+		description  string
+		destinations []string
+		requireBind  bool
+		// bindKey is the host key to which the connection is bound, or
+		// nil if it is not bound.
+		bindKey    ssh.Signer
+		forwarding bool
+		// forwardedVia is the host key to which the agent was
+		// forwarded before the connection was bound, or nil if it was
+		// not.
+		forwardedVia ssh.Signer
+		dataSession  []byte
+		wantRefused  bool
+		wantHost     string
+	}{
+		{
+			description: "no constraint",
+			bindKey:     otherKey,
+			wantHost:    ssh.FingerprintSHA256(otherKey.PublicKey()),
+		},
+		{
+			description:  "permitted host",
+			destinations: destinations,
+			bindKey:      hostKey,
+			wantHost:     "example.com",
+		},
+		{
+			description:  "other host",
+			destinations: destinations,
+			bindKey:      otherKey,
+			wantRefused:  true,
+			wantHost:     ssh.FingerprintSHA256(otherKey.PublicKey()),
+		},
+		{
+			description:  "not bound",
+			destinations: destinations,
+		},
+		{
+			description:  "not bound when binding required",
+			destinations: destinations,
+			requireBind:  true,
+			wantRefused:  true,
+		},
+		{
+			description:  "forwarded to permitted host",
+			destinations: destinations,
+			bindKey:      hostKey,
+			forwarding:   true,
+			wantRefused:  true,
+			wantHost:     "example.com",
+		},
+		{
+			description:  "forwarded through other host to permitted host",
+			destinations: destinations,
+			bindKey:      hostKey,
+			forwardedVia: otherKey,
+			wantRefused:  true,
+			wantHost:     "example.com",
+		},
+		{
+			description:  "forwarded through permitted host to permitted host",
+			destinations: destinations,
+			bindKey:      hostKey,
+			forwardedVia: hostKey,
+			wantHost:     "example.com",
+		},
+		{
+			description:  "request for other session",
+			destinations: destinations,
+			bindKey:      hostKey,
+			dataSession:  []byte("other-session-id"),
+			wantRefused:  true,
+			wantHost:     "example.com",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+					{Name: "key", PEMPrivateKey: testdata.WithoutPassphrase.Private, Load: true},
+				})
+				if err != nil {
+					t.Errorf("failed to initialize manager: %v", err)
+					return
+				}
+				id, err := findKey(ctx, mgr, InvalidID, "key")
+				if err != nil {
+					t.Errorf("failed to find key: %v", err)
+					return
+				}
+				if err := mgr.SetDestinations(ctx, id, tc.destinations, tc.requireBind); err != nil {
+					t.Errorf("failed to set destinations: %v", err)
+					return
+				}
+
+				refused := make(chan *AuditEntry, 1)
+				mgr.SetOnRefused(func(e *AuditEntry) { refused <- e })
+
+				c, s := net.Pipe()
+				defer c.Close()
+				go agent.ServeAgent(mgr.ConnectionAgent(&Connection{Name: "client"}), s)
+				client := agent.NewClient(c)
+
+				if tc.forwardedVia != nil {
+					bind := makeSessionBind(t, tc.forwardedVia.PublicKey(), tc.forwardedVia, []byte("forwarded-session-id"), true)
+					if _, err := client.Extension(sessionBindExtension, bind); err != nil {
+						t.Errorf("failed to bind forwarded session: %v", err)
+						return
+					}
+				}
+				if tc.bindKey != nil {
+					bind := makeSessionBind(t, tc.bindKey.PublicKey(), tc.bindKey, sessionID, tc.forwarding)
+					if _, err := client.Extension(sessionBindExtension, bind); err != nil {
+						t.Errorf("failed to bind session: %v", err)
+						return
+					}
+				}
+
+				blob, err := base64.StdEncoding.DecodeString(testdata.WithoutPassphrase.Blob)
+				if err != nil {
+					t.Errorf("failed to decode public key: %v", err)
+					return
+				}
+				pub, err := ssh.ParsePublicKey(blob)
+				if err != nil {
+					t.Errorf("failed to parse public key: %v", err)
+					return
+				}
+				dataSession := sessionID
+				if tc.dataSession != nil {
+					dataSession = tc.dataSession
+				}
+				_, err = client.SignWithFlags(pub, userAuthData(dataSession), agent.SignatureFlagRsaSha256)
+				if refused := err != nil; refused != tc.wantRefused {
+					t.Errorf("incorrect refusal; got %t (err=%v), want %t", refused, err, tc.wantRefused)
+				}
+				// Refusals are reported as they occur.
+				select {
+				case e := <-refused:
+					if !tc.wantRefused || e.ID != string(id) || e.Refused == "" {
+						t.Errorf("incorrect refusal reported: %+v", e)
+					}
+				default:
+					if tc.wantRefused {
+						t.Errorf("refusal not reported")
+					}
+				}
+
+				// Requests are logged asynchronously.
+				var entries []*AuditEntry
+				poll(func() bool {
+					entries, err = mgr.AuditLog(ctx)
+					return err != nil || len(entries) > 0
+				})
+				if err != nil {
+					t.Errorf("failed to read audit log: %v", err)
+					return
+				}
+				fingerprint, _ := fingerprints(pub)
+				want := []*AuditEntry{{ID: string(id), FingerprintSHA256: fingerprint, Connection: "client", ConnectionName: "client", Host: tc.wantHost}}
+				if diff := cmp.Diff(entries, want, cmpopts.IgnoreFields(AuditEntry{}, "TimeMillis", "Refused")); diff != "" {
+					t.Errorf("incorrect audit log; -got +want: %s", diff)
+				}
+				if len(entries) == 1 && (entries[0].Refused != "") != tc.wantRefused {
+					t.Errorf("incorrect refusal in audit log; got %q", entries[0].Refused)
+				}
+			})
+		})
+	}
+}
+
+func TestSessionBindWhileLocked(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		if err := mgr.Lock(ctx, "passphrase"); err != nil {
+			t.Errorf("failed to lock: %v", err)
+			return
+		}
+
+		c, s := net.Pipe()
+		defer c.Close()
+		go agent.ServeAgent(mgr.ConnectionAgent(&Connection{Name: "client"}), s)
+		client := agent.NewClient(c)
+
+		hostKey := newHostKey(t)
+		bind := makeSessionBind(t, hostKey.PublicKey(), hostKey, []byte("session-id"), false)
+		if _, err := client.Extension(sessionBindExtension, bind); err != nil {
+			t.Errorf("failed to bind session: %v", err)
+		}
+		bind = makeSessionBind(t, hostKey.PublicKey(), newHostKey(t), []byte("session-id"), false)
+		if _, err := client.Extension(sessionBindExtension, bind); err == nil {
+			t.Errorf("incorrectly accepted binding with invalid signature")
+		}
+	})
+}
@@ -0,0 +1,390 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyEncryption is the method used to encrypt a key with a passphrase.
+type KeyEncryption string
+
+const (
+	// EncryptionOpenSSH stores keys in the OpenSSH format, encrypted
+	// using a key derived from the passphrase with bcrypt_pbkdf. This is
+	// the default.
+	EncryptionOpenSSH KeyEncryption = "openssh"
+	// EncryptionArgon2id stores keys encrypted using AES-256-GCM, with a
+	// key derived from the passphrase with Argon2id. The key is stored in
+	// FormatArgon2id.
+	EncryptionArgon2id KeyEncryption = "argon2id"
+)
+
+var errInvalidEncryption = errors.New("invalid key encryption")
+
+// validEncryption determines if enc is a supported encryption method.
+func validEncryption(enc KeyEncryption) bool {
+	return enc == EncryptionOpenSSH || enc == EncryptionArgon2id
+}
+
+// envelopeBlockType is the PEM block type for keys stored in FormatArgon2id.
+// The block contains the AES-256-GCM encrypted private key in the OpenSSH
+// format, and its headers contain the parameters required to decrypt it.
+const envelopeBlockType = "CHROME-SSH-AGENT ENCRYPTED PRIVATE KEY"
+
+// Headers of the PEM block for keys stored in FormatArgon2id.
+const (
+	envelopeKDFHeader     = "KDF"
+	envelopeTimeHeader    = "Argon2-Time"
+	envelopeMemoryHeader  = "Argon2-Memory"
+	envelopeThreadsHeader = "Argon2-Threads"
+	envelopeSaltHeader    = "Salt"
+	envelopeNonceHeader   = "Nonce"
+)
+
+const (
+	envelopeSaltBytes = 16
+	envelopeKeyBytes  = 32
+
+	// maxArgon2Time and maxArgon2Memory bound the parameters accepted when
+	// decrypting a key, such that a malformed key synced from elsewhere
+	// cannot make us hang or exhaust memory.
+	maxArgon2Time   = 16
+	maxArgon2Memory = 1024 * 1024
+)
+
+// argon2Params are the parameters for deriving a key using Argon2id.
+type argon2Params struct {
+	// Time is the number of passes over memory.
+	Time uint32
+	// Memory is the amount of memory used, in KiB.
+	Memory uint32
+	// Threads is the degree of parallelism.
+	Threads uint8
+}
+
+// defaultArgon2Params are the parameters used when encrypting keys. They follow
+// the second recommended option in RFC 9106, but with a single lane since
+// WebAssembly is single-threaded. See BenchmarkArgon2idDefault; derivation
+// takes roughly 0.4s in the WebAssembly runtime on a typical workstation.
+var defaultArgon2Params = argon2Params{Time: 3, Memory: 64 * 1024, Threads: 1}
+
+// isEnvelope determines if the private key is stored in FormatArgon2id.
+func isEnvelope(privateKey string) bool {
+	block, _ := pem.Decode([]byte(privateKey))
+	return block != nil && block.Type == envelopeBlockType
+}
+
+// sealEnvelope encrypts priv using a key derived from passphrase, and returns
+// it in FormatArgon2id.
+func sealEnvelope(priv interface{}, comment string, passphrase string, p argon2Params) (string, error) {
+	inner, err := ssh.MarshalPrivateKey(priv, comment)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errMarshalFailed, err)
+	}
+	return sealWithPassphrase(envelopeBlockType, pem.EncodeToMemory(inner), passphrase, p)
+}
+
+// openEnvelope decrypts a private key stored in FormatArgon2id.
+func openEnvelope(privateKey string, passphrase string) (interface{}, error) {
+	inner, err := openWithPassphrase(envelopeBlockType, privateKey, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ssh.ParseRawPrivateKey(inner)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w: %w", ErrKeyCorrupt, errParseFailed, err)
+	}
+	return priv, nil
+}
+
+// sealWithPassphrase encrypts plaintext using a key derived from passphrase,
+// and returns a PEM block of the specified type whose headers contain the
+// parameters required to decrypt it.
+func sealWithPassphrase(blockType string, plaintext []byte, passphrase string, p argon2Params) (string, error) {
+	salt := make([]byte, envelopeSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	gcm, err := envelopeCipher(passphrase, salt, p)
+	if err != nil {
+		return "", err
+	}
+	return sealBlock(gcm, blockType, map[string]string{
+		envelopeKDFHeader:     string(EncryptionArgon2id),
+		envelopeTimeHeader:    strconv.FormatUint(uint64(p.Time), 10),
+		envelopeMemoryHeader:  strconv.FormatUint(uint64(p.Memory), 10),
+		envelopeThreadsHeader: strconv.FormatUint(uint64(p.Threads), 10),
+		envelopeSaltHeader:    base64.StdEncoding.EncodeToString(salt),
+	}, plaintext)
+}
+
+// openWithPassphrase decrypts a PEM block of the specified type that was
+// returned by sealWithPassphrase.
+func openWithPassphrase(blockType string, data string, passphrase string) ([]byte, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%w: %w: not an encrypted key", ErrKeyCorrupt, errDecodeFailed)
+	}
+	if kdf := block.Headers[envelopeKDFHeader]; kdf != string(EncryptionArgon2id) {
+		return nil, fmt.Errorf("%w: %w: unsupported KDF %q", ErrKeyCorrupt, errDecodeFailed, kdf)
+	}
+	p, err := parseArgon2Params(block.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w: %w", ErrKeyCorrupt, errDecodeFailed, err)
+	}
+	salt, err := base64.StdEncoding.DecodeString(block.Headers[envelopeSaltHeader])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w: invalid salt: %w", ErrKeyCorrupt, errDecodeFailed, err)
+	}
+
+	gcm, err := envelopeCipher(passphrase, salt, p)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openBlock(gcm, block)
+	if errors.Is(err, errAuthFailed) {
+		// Authentication fails if the passphrase is incorrect.
+		return nil, fmt.Errorf("%w: %w", ErrWrongPassphrase, err)
+	}
+	return plaintext, err
+}
+
+// errAuthFailed indicates that an encrypted block could not be authenticated;
+// that is, it was encrypted using a different key, or has been modified.
+var errAuthFailed = errors.New("message authentication failed")
+
+// sealBlock encrypts plaintext using gcm and a random nonce, and returns a PEM
+// block of the specified type with the supplied headers. The nonce is added
+// to the headers. The block type is authenticated, such that a block cannot be
+// mistaken for one of another type.
+func sealBlock(gcm cipher.AEAD, blockType string, headers map[string]string, plaintext []byte) (string, error) {
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	headers[envelopeNonceHeader] = base64.StdEncoding.EncodeToString(nonce)
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:    blockType,
+		Headers: headers,
+		Bytes:   gcm.Seal(nil, nonce, plaintext, []byte(blockType)),
+	})), nil
+}
+
+// openBlock decrypts a PEM block returned by sealBlock. It returns
+// errAuthFailed if the block cannot be authenticated.
+func openBlock(gcm cipher.AEAD, block *pem.Block) ([]byte, error) {
+	nonce, err := base64.StdEncoding.DecodeString(block.Headers[envelopeNonceHeader])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w: invalid nonce: %w", ErrKeyCorrupt, errDecodeFailed, err)
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: %w: invalid nonce size %d", ErrKeyCorrupt, errDecodeFailed, len(nonce))
+	}
+	plaintext, err := gcm.Open(nil, nonce, block.Bytes, []byte(block.Type))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errAuthFailed, err)
+	}
+	return plaintext, nil
+}
+
+// parseArgon2Params parses the Argon2id parameters from the headers of a key
+// stored in FormatArgon2id.
+func parseArgon2Params(headers map[string]string) (argon2Params, error) {
+	parse := func(name string, bits int, max uint64) (uint64, error) {
+		v, err := strconv.ParseUint(headers[name], 10, bits)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if v == 0 || v > max {
+			return 0, fmt.Errorf("invalid %s: %d out of range", name, v)
+		}
+		return v, nil
+	}
+	t, err := parse(envelopeTimeHeader, 32, maxArgon2Time)
+	if err != nil {
+		return argon2Params{}, err
+	}
+	m, err := parse(envelopeMemoryHeader, 32, maxArgon2Memory)
+	if err != nil {
+		return argon2Params{}, err
+	}
+	p, err := parse(envelopeThreadsHeader, 8, 255)
+	if err != nil {
+		return argon2Params{}, err
+	}
+	return argon2Params{Time: uint32(t), Memory: uint32(m), Threads: uint8(p)}, nil
+}
+
+// envelopeCipher returns the AES-256-GCM cipher for a key derived from the
+// passphrase.
+func envelopeCipher(passphrase string, salt []byte, p argon2Params) (cipher.AEAD, error) {
+	return newGCM(argon2.IDKey([]byte(passphrase), salt, p.Time, p.Memory, p.Threads, envelopeKeyBytes))
+}
+
+// newGCM returns the AES-GCM cipher for the key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptPrivateKey encrypts priv with passphrase using the specified method,
+// and returns the PEM-encoded result.
+func encryptPrivateKey(priv interface{}, comment string, passphrase string, enc KeyEncryption) (string, error) {
+	switch enc {
+	case EncryptionArgon2id:
+		return sealEnvelope(priv, comment, passphrase, defaultArgon2Params)
+	case EncryptionOpenSSH:
+		block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, comment, []byte(passphrase))
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", errMarshalFailed, err)
+		}
+		return string(pem.EncodeToMemory(block)), nil
+	default:
+		return "", fmt.Errorf("%w: %s", errInvalidEncryption, enc)
+	}
+}
+
+// reencryptKey returns a copy of key, decrypted using oldPassphrase and
+// encrypted using newPassphrase with the specified method.
+func reencryptKey(key *storedKey, oldPassphrase, newPassphrase string, enc KeyEncryption) (*storedKey, error) {
+	decrypted, err := decryptKey(key, oldPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key: %w", err)
+	}
+	defer decrypted.wipe()
+	priv, err := parseDecryptedKey(decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errParseFailed, err)
+	}
+	encrypted, err := encryptPrivateKey(priv, key.Comment, newPassphrase, enc)
+	if err != nil {
+		return nil, err
+	}
+	updated := *key
+	updated.PEMPrivateKey = encrypted
+	if err := updated.setPublicFromPrivate(priv); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DefaultKeyEncryption implements Manager.DefaultKeyEncryption.
+func (m *DefaultManager) DefaultKeyEncryption(ctx jsutil.AsyncContext) (KeyEncryption, error) {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read settings: %w", err)
+	}
+	if s == nil || s.KeyEncryption == "" {
+		return EncryptionOpenSSH, nil
+	}
+	return KeyEncryption(s.KeyEncryption), nil
+}
+
+// SetDefaultKeyEncryption implements Manager.SetDefaultKeyEncryption.
+func (m *DefaultManager) SetDefaultKeyEncryption(ctx jsutil.AsyncContext, enc KeyEncryption) error {
+	if !validEncryption(enc) {
+		return fmt.Errorf("%w: %s", errInvalidEncryption, enc)
+	}
+	return m.updateSettings(ctx, func(s *managerSettings) {
+		s.KeyEncryption = string(enc)
+	})
+}
+
+// SetKeyEncryption implements Manager.SetKeyEncryption.
+func (m *DefaultManager) SetKeyEncryption(ctx jsutil.AsyncContext, id ID, enc KeyEncryption, passphrase string) error {
+	if !validEncryption(enc) {
+		return fmt.Errorf("%w: %s", errInvalidEncryption, enc)
+	}
+	if passphrase == "" {
+		return fmt.Errorf("%w: passphrase must not be empty", errInvalidPassphrase)
+	}
+
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(key *storedKey) (*storedKey, error) {
+		return reencryptKey(key, passphrase, passphrase, enc)
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+	return nil
+}
+
+// ReencryptAll implements Manager.ReencryptAll.
+func (m *DefaultManager) ReencryptAll(ctx jsutil.AsyncContext, passphrase string) ([]*Result, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("%w: passphrase must not be empty", errInvalidPassphrase)
+	}
+	enc, err := m.DefaultKeyEncryption(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	// Keys are re-encrypted before writing any of them, such that all are
+	// replaced in a single write.
+	var results []*Result
+	updated := map[ID]*storedKey{}
+	for _, k := range keys {
+		if k.IsSecurityKey() || k.IsCryptoKey() || !k.Encrypted() {
+			continue
+		}
+		r := &Result{ID: ID(k.ID)}
+		results = append(results, r)
+		u, err := reencryptKey(k, passphrase, passphrase, enc)
+		if err != nil {
+			r.Err = err
+			continue
+		}
+		updated[r.ID] = u
+	}
+	if len(updated) == 0 {
+		return results, nil
+	}
+
+	_, err = m.storedKeys.Update(ctx, func(key *storedKey) bool {
+		_, ok := updated[ID(key.ID)]
+		return ok
+	}, func(key *storedKey) (*storedKey, error) {
+		return updated[ID(key.ID)], nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store keys: %w", err)
+	}
+	return results, nil
+}
@@ -0,0 +1,263 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testArgon2Params are cheap parameters, such that tests run quickly.
+var testArgon2Params = argon2Params{Time: 1, Memory: 64, Threads: 1}
+
+func TestEnvelope(t *testing.T) {
+	t.Parallel()
+
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ED25519WithoutPassphrase.Private))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	want := derivePublicKey(testdata.ED25519WithoutPassphrase.Private)
+	sealed, err := sealEnvelope(priv, "comment", "secret", testArgon2Params)
+	if err != nil {
+		t.Fatalf("failed to seal envelope: %v", err)
+	}
+
+	// setHeader returns the sealed key with the header replaced.
+	setHeader := func(name, value string) string {
+		block, _ := pem.Decode([]byte(sealed))
+		block.Headers[name] = value
+		return string(pem.EncodeToMemory(block))
+	}
+
+	testcases := []struct {
+		description string
+		key         string
+		passphrase  string
+		wantErr     error
+	}{
+		{
+			description: "correct passphrase",
+			key:         sealed,
+			passphrase:  "secret",
+		},
+		{
+			description: "wrong passphrase",
+			key:         sealed,
+			passphrase:  "wrong",
+			wantErr:     ErrWrongPassphrase,
+		},
+		{
+			description: "unsupported KDF",
+			key:         setHeader(envelopeKDFHeader, "scrypt"),
+			passphrase:  "secret",
+			wantErr:     ErrKeyCorrupt,
+		},
+		{
+			description: "excessive memory",
+			key:         setHeader(envelopeMemoryHeader, "4194304"),
+			passphrase:  "secret",
+			wantErr:     ErrKeyCorrupt,
+		},
+		{
+			description: "modified parameters",
+			key:         setHeader(envelopeTimeHeader, "2"),
+			passphrase:  "secret",
+			wantErr:     ErrWrongPassphrase,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := openEnvelope(tc.key, tc.passphrase)
+			if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("incorrect error; -got +want: %s", diff)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			signer, err := ssh.NewSignerFromKey(got)
+			if err != nil {
+				t.Fatalf("failed to create signer: %v", err)
+			}
+			if diff := cmp.Diff(signer.PublicKey().Marshal(), want.Marshal()); diff != "" {
+				t.Errorf("incorrect public key; -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestKeyEncryption(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "key", PEMPrivateKey: testdata.OpenSSHFormat.Private},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "key")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+		format := func() string {
+			t.Helper()
+			configured, err := mgr.Configured(ctx)
+			if err != nil || len(configured) != 1 {
+				t.Fatalf("failed to get configured key: %v", err)
+			}
+			if !configured[0].Encrypted {
+				t.Errorf("key not reported as encrypted")
+			}
+			return configured[0].Format
+		}
+
+		if enc, err := mgr.DefaultKeyEncryption(ctx); err != nil || enc != EncryptionOpenSSH {
+			t.Errorf("incorrect initial default encryption: %s, %v", enc, err)
+		}
+		if err := mgr.SetDefaultKeyEncryption(ctx, "bogus"); err == nil {
+			t.Errorf("SetDefaultKeyEncryption unexpectedly accepted invalid encryption")
+		}
+		if err := mgr.SetDefaultKeyEncryption(ctx, EncryptionArgon2id); err != nil {
+			t.Fatalf("SetDefaultKeyEncryption failed: %v", err)
+		}
+
+		// Changing the passphrase uses the default.
+		if err := mgr.ChangePassphrase(ctx, id, "secret", "new-secret"); err != nil {
+			t.Fatalf("ChangePassphrase failed: %v", err)
+		}
+		if got := format(); got != FormatArgon2id {
+			t.Errorf("incorrect format after changing passphrase; got %s, want %s", got, FormatArgon2id)
+		}
+		if err := mgr.Load(ctx, id, ""); !errors.Is(err, ErrPassphraseRequired) {
+			t.Errorf("Load without passphrase returned incorrect error: %v", err)
+		}
+		if err := mgr.Load(ctx, id, "secret"); !errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("Load with wrong passphrase returned incorrect error: %v", err)
+		}
+		if err := mgr.Load(ctx, id, "new-secret"); err != nil {
+			t.Errorf("Load failed: %v", err)
+		}
+		if err := mgr.Unload(ctx, id); err != nil {
+			t.Errorf("Unload failed: %v", err)
+		}
+
+		// The encryption may be selected for an individual key.
+		if err := mgr.SetKeyEncryption(ctx, id, EncryptionOpenSSH, "new-secret"); err != nil {
+			t.Fatalf("SetKeyEncryption failed: %v", err)
+		}
+		if got := format(); got != FormatOpenSSH {
+			t.Errorf("incorrect format after setting encryption; got %s, want %s", got, FormatOpenSSH)
+		}
+		if err := mgr.Load(ctx, id, "new-secret"); err != nil {
+			t.Errorf("Load failed: %v", err)
+		}
+	})
+}
+
+func TestReencryptAll(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "openssh", PEMPrivateKey: testdata.OpenSSHFormat.Private},
+			{Name: "pkcs8", PEMPrivateKey: testdata.PKCS8Format.Private},
+			{Name: "other", PEMPrivateKey: testdata.ECDSAWithPassphrase.Private},
+			{Name: "unencrypted", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		ids := map[string]ID{}
+		for _, name := range []string{"openssh", "pkcs8", "other", "unencrypted"} {
+			if ids[name], err = findKey(ctx, mgr, InvalidID, name); err != nil {
+				t.Fatalf("failed to find key %s: %v", name, err)
+			}
+		}
+		if err := mgr.ChangePassphrase(ctx, ids["other"], "secret", "other-secret"); err != nil {
+			t.Fatalf("ChangePassphrase failed: %v", err)
+		}
+		if err := mgr.SetDefaultKeyEncryption(ctx, EncryptionArgon2id); err != nil {
+			t.Fatalf("SetDefaultKeyEncryption failed: %v", err)
+		}
+
+		results, err := mgr.ReencryptAll(ctx, "secret")
+		if err != nil {
+			t.Fatalf("ReencryptAll failed: %v", err)
+		}
+		failed := map[ID]bool{}
+		for _, r := range results {
+			failed[r.ID] = r.Err != nil
+		}
+		wantFailed := map[ID]bool{
+			ids["openssh"]: false,
+			ids["pkcs8"]:   false,
+			ids["other"]:   true,
+		}
+		if diff := cmp.Diff(failed, wantFailed); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+
+		configured, err := mgr.Configured(ctx)
+		if err != nil {
+			t.Fatalf("failed to get configured keys: %v", err)
+		}
+		formats := map[string]string{}
+		for _, k := range configured {
+			formats[k.Name] = k.Format
+		}
+		wantFormats := map[string]string{
+			"openssh":     FormatArgon2id,
+			"pkcs8":       FormatArgon2id,
+			"other":       FormatOpenSSH,
+			"unencrypted": FormatPKCS1,
+		}
+		if diff := cmp.Diff(formats, wantFormats); diff != "" {
+			t.Errorf("incorrect formats; -got +want: %s", diff)
+		}
+		if err := mgr.Load(ctx, ids["pkcs8"], "secret"); err != nil {
+			t.Errorf("Load failed: %v", err)
+		}
+	})
+}
+
+func BenchmarkArgon2idDefault(b *testing.B) {
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ED25519WithoutPassphrase.Private))
+	if err != nil {
+		b.Fatalf("failed to parse key: %v", err)
+	}
+	for i := 0; i < b.N; i++ {
+		if _, err := sealEnvelope(priv, "", "secret", defaultArgon2Params); err != nil {
+			b.Fatalf("failed to seal envelope: %v", err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+var errAlreadyLoaded = errors.New("key already loaded")
+
+// LoadEphemeral implements Manager.LoadEphemeral.
+func (m *DefaultManager) LoadEphemeral(ctx jsutil.AsyncContext, name string, pemPrivateKey string, passphrase string) (ID, error) {
+	if name == "" {
+		return InvalidID, fmt.Errorf("%w: name must not be empty", errInvalidName)
+	}
+
+	// Decrypt the key in the same manner as a configured key, but without
+	// ever storing it.
+	decrypted, err := decryptKey(&storedKey{PEMPrivateKey: pemPrivateKey}, passphrase)
+	if err != nil {
+		return InvalidID, fmt.Errorf("failed to decrypt key: %w", err)
+	}
+	defer decrypted.wipe()
+	return m.loadEphemeral(ctx, name, decrypted, nil)
+}
+
+// loadEphemeral loads the decrypted key into the agent without configuring
+// it, along with the certificate if it is not nil.
+func (m *DefaultManager) loadEphemeral(ctx jsutil.AsyncContext, name string, decrypted decryptedKey, cert *ssh.Certificate) (ID, error) {
+	priv, err := parseDecryptedKey(decrypted)
+	if err != nil {
+		return InvalidID, fmt.Errorf("%w: %w", errParseFailed, err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return InvalidID, fmt.Errorf("%w: %w", errParseFailed, err)
+	}
+
+	loaded, err := m.Loaded(ctx)
+	if err != nil {
+		return InvalidID, err
+	}
+	blob := signer.PublicKey().Marshal()
+	for _, l := range loaded {
+		if bytes.Equal(l.Blob(), blob) {
+			return InvalidID, fmt.Errorf("%w: %s", errAlreadyLoaded, name)
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		return InvalidID, err
+	}
+	// The name is used as the comment, since the key has no configuration
+	// through which it could otherwise be identified.
+	if err := m.addToAgent(id, decrypted, name, cert); err != nil {
+		return InvalidID, err
+	}
+	m.keysMu.Lock()
+	m.ephemeral[id] = true
+	m.keysMu.Unlock()
+	// Ephemeral keys are not stored, so the change must be reported
+	// explicitly.
+	m.checkChanges(ctx)
+	return id, nil
+}
+
+// isEphemeral determines if the key with the specified ID was loaded using
+// LoadEphemeral.
+func (m *DefaultManager) isEphemeral(id ID) bool {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	return m.ephemeral[id]
+}
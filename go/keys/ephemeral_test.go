@@ -0,0 +1,175 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestLoadEphemeral(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		configured  []*initialKey
+		name        string
+		key         testdata.TestKey
+		passphrase  string
+		wantErr     error
+	}{
+		{
+			description: "load unencrypted key",
+			name:        "ephemeral",
+			key:         testdata.WithoutPassphrase,
+		},
+		{
+			description: "load encrypted key",
+			name:        "ephemeral",
+			key:         testdata.ED25519WithPassphrase,
+			passphrase:  "secret",
+		},
+		{
+			description: "incorrect passphrase",
+			name:        "ephemeral",
+			key:         testdata.ED25519WithPassphrase,
+			passphrase:  "incorrect",
+			wantErr:     ErrWrongPassphrase,
+		},
+		{
+			description: "empty name",
+			key:         testdata.WithoutPassphrase,
+			wantErr:     errInvalidName,
+		},
+		{
+			description: "already loaded",
+			configured: []*initialKey{
+				{Name: "configured", PEMPrivateKey: testdata.WithoutPassphrase.Private, Load: true},
+			},
+			name:    "ephemeral",
+			key:     testdata.WithoutPassphrase,
+			wantErr: errAlreadyLoaded,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				syncArea := st.NewQuotaMemArea(0, 0, 0)
+				syncStorage := storage.NewRaw(syncArea)
+				sessionStorage := storage.NewRaw(st.NewMemArea())
+				mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, tc.configured)
+				if err != nil {
+					t.Errorf("failed to initialize manager: %v", err)
+					return
+				}
+				writes := countWrites(syncArea)
+
+				id, err := mgr.LoadEphemeral(ctx, tc.name, tc.key.Private, tc.passphrase)
+				if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("incorrect error; -got +want: %s", diff)
+				}
+				// Nothing is ever written to persistent storage.
+				if got := countWrites(syncArea) - writes; got != 0 {
+					t.Errorf("incorrect writes to sync storage; got %d, want 0", got)
+				}
+				if err != nil {
+					return
+				}
+
+				loaded, err := mgr.Loaded(ctx)
+				if err != nil {
+					t.Errorf("failed to get loaded keys: %v", err)
+					return
+				}
+				want := []*LoadedKey{
+					{
+						Type:         tc.key.Type,
+						InternalBlob: tc.key.Blob,
+						Comment:      agentComment(id, tc.name),
+						Ephemeral:    true,
+					},
+				}
+				if diff := cmp.Diff(loaded, want, cmpopts.IgnoreFields(LoadedKey{}, "Bits", "FingerprintSHA256", "FingerprintMD5")); diff != "" {
+					t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+				}
+				configured, err := mgr.Configured(ctx)
+				if err != nil {
+					t.Errorf("failed to get configured keys: %v", err)
+					return
+				}
+				if len(configured) != 0 {
+					t.Errorf("incorrect configured keys; got %d, want 0", len(configured))
+				}
+
+				// Ephemeral keys are removed like any other.
+				if err := mgr.Remove(ctx, id); err != nil {
+					t.Errorf("failed to remove key: %v", err)
+					return
+				}
+				loaded, err = mgr.Loaded(ctx)
+				if err != nil {
+					t.Errorf("failed to get loaded keys: %v", err)
+					return
+				}
+				if len(loaded) != 0 {
+					t.Errorf("incorrect loaded keys; got %d, want 0", len(loaded))
+				}
+			})
+		})
+	}
+}
+
+func TestEphemeralKeysLostOnRestart(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		if _, err := mgr.LoadEphemeral(ctx, "ephemeral", testdata.WithoutPassphrase.Private, ""); err != nil {
+			t.Errorf("failed to load key: %v", err)
+			return
+		}
+
+		// Simulate a restart of the service worker.
+		mgr = NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		if err := mgr.LoadFromSession(ctx); err != nil {
+			t.Errorf("failed to load keys from session: %v", err)
+			return
+		}
+		loaded, err := mgr.Loaded(ctx)
+		if err != nil {
+			t.Errorf("failed to get loaded keys: %v", err)
+			return
+		}
+		if len(loaded) != 0 {
+			t.Errorf("incorrect loaded keys; got %d, want 0", len(loaded))
+		}
+	})
+}
@@ -0,0 +1,282 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// ChangeKind is the kind of change made to a key.
+type ChangeKind int
+
+const (
+	// KeyAdded indicates that a key was configured.
+	KeyAdded ChangeKind = iota + 1
+	// KeyRemoved indicates that a configured key was removed.
+	KeyRemoved
+	// KeyLoaded indicates that a key was loaded into the agent.
+	KeyLoaded
+	// KeyUnloaded indicates that a key was unloaded from the agent.
+	KeyUnloaded
+	// KeyRenamed indicates that a configured key was renamed.
+	KeyRenamed
+	// AgentLocked indicates that the agent was locked. It does not
+	// affect a single key, so the ID is InvalidID.
+	AgentLocked
+	// AgentUnlocked indicates that the agent was unlocked. It does not
+	// affect a single key, so the ID is InvalidID.
+	AgentUnlocked
+)
+
+// String returns a human-readable description of the kind of change.
+func (k ChangeKind) String() string {
+	switch k {
+	case KeyAdded:
+		return "added"
+	case KeyRemoved:
+		return "removed"
+	case KeyLoaded:
+		return "loaded"
+	case KeyUnloaded:
+		return "unloaded"
+	case KeyRenamed:
+		return "renamed"
+	case AgentLocked:
+		return "locked"
+	case AgentUnlocked:
+		return "unlocked"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(k))
+	}
+}
+
+// ChangeEvent describes a change made to a key, or to the agent as a whole.
+type ChangeEvent struct {
+	// Kind is the kind of change.
+	Kind ChangeKind
+	// ID is the ID of the affected key.
+	ID ID
+}
+
+// ChangeFunc is invoked when a key is changed.
+type ChangeFunc func(ctx jsutil.AsyncContext, e ChangeEvent)
+
+// keyState is the state of the keys from which changes are determined.
+type keyState struct {
+	// names contains the names of configured keys, indexed by ID.
+	names map[ID]string
+	// loaded contains the IDs of loaded keys.
+	loaded map[ID]bool
+	// locked indicates that the agent is locked.
+	locked bool
+}
+
+// changeTracker determines the changes made to keys, and reports them to
+// subscribers.
+type changeTracker struct {
+	// reportMu serializes the checking and reporting of changes, such that
+	// subscribers observe changes in order.
+	reportMu sync.Mutex
+
+	// mu guards the fields below. It is not held while reporting changes,
+	// so subscribers may subscribe and unsubscribe from within f.
+	mu sync.Mutex
+	// state is the state of keys when changes were last checked, or nil
+	// if there are no subscribers.
+	state *keyState
+	// stop stops watching storage for changes.
+	stop        jsutil.CleanupFunc
+	subscribers map[int]ChangeFunc
+	nextSub     int
+}
+
+// readKeyState returns the current state of the keys.
+func (m *DefaultManager) readKeyState(ctx jsutil.AsyncContext) (*keyState, error) {
+	stored, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	sks, err := m.sessionKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session keys: %w", err)
+	}
+
+	s := &keyState{names: map[ID]string{}, loaded: map[ID]bool{}}
+	for _, k := range stored {
+		s.names[ID(k.ID)] = k.Name
+	}
+	for _, sk := range sks {
+		s.loaded[ID(sk.ID)] = true
+	}
+	// Ephemeral keys are never stored, so are tracked separately.
+	m.keysMu.Lock()
+	for id := range m.ephemeral {
+		s.loaded[id] = true
+	}
+	m.keysMu.Unlock()
+	s.locked = m.locker.locked()
+	return s, nil
+}
+
+// diffKeyState returns the changes required to move from the old state to the
+// new state.
+func diffKeyState(old, cur *keyState) []ChangeEvent {
+	var events []ChangeEvent
+	add := func(kind ChangeKind, ids []ID) {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			events = append(events, ChangeEvent{Kind: kind, ID: id})
+		}
+	}
+
+	var added, removed, renamed, loaded, unloaded []ID
+	for id, name := range cur.names {
+		oldName, ok := old.names[id]
+		switch {
+		case !ok:
+			added = append(added, id)
+		case oldName != name:
+			renamed = append(renamed, id)
+		}
+	}
+	for id := range old.names {
+		if _, ok := cur.names[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	for id := range cur.loaded {
+		if !old.loaded[id] {
+			loaded = append(loaded, id)
+		}
+	}
+	for id := range old.loaded {
+		if !cur.loaded[id] {
+			unloaded = append(unloaded, id)
+		}
+	}
+
+	// Order events such that a key is configured before it is loaded, and
+	// unloaded before it is removed.
+	add(KeyAdded, added)
+	add(KeyRenamed, renamed)
+	add(KeyLoaded, loaded)
+	add(KeyUnloaded, unloaded)
+	add(KeyRemoved, removed)
+	switch {
+	case cur.locked && !old.locked:
+		events = append(events, ChangeEvent{Kind: AgentLocked, ID: InvalidID})
+	case !cur.locked && old.locked:
+		events = append(events, ChangeEvent{Kind: AgentUnlocked, ID: InvalidID})
+	}
+	return events
+}
+
+// checkChanges reports any changes made to keys since they were last checked.
+// It is a no-op if there are no subscribers.
+func (m *DefaultManager) checkChanges(ctx jsutil.AsyncContext) {
+	t := &m.changes
+	t.reportMu.Lock()
+	defer t.reportMu.Unlock()
+
+	t.mu.Lock()
+	if t.state == nil {
+		t.mu.Unlock()
+		return
+	}
+	cur, err := m.readKeyState(ctx)
+	if err != nil {
+		t.mu.Unlock()
+		jsutil.LogError("failed to check for changes to keys: %v", err)
+		return
+	}
+	events := diffKeyState(t.state, cur)
+	t.state = cur
+	var subs []ChangeFunc
+	for _, f := range t.subscribers {
+		subs = append(subs, f)
+	}
+	t.mu.Unlock()
+
+	for _, e := range events {
+		jsutil.LogDebug("DefaultManager.checkChanges: key %s %s", e.ID, e.Kind)
+		for _, f := range subs {
+			f(ctx, e)
+		}
+	}
+}
+
+// OnChange invokes f when keys are added, removed, loaded, unloaded or
+// renamed, and when the agent is locked or unlocked. Changes are reported whether they are made using this manager or
+// observed in storage, such as when made from another device. Pages other
+// than the one hosting the manager may receive changes that are broadcast
+// using NotifyChanges.
+//
+// The returned cleanup function must be invoked to stop receiving changes.
+func (m *DefaultManager) OnChange(ctx jsutil.AsyncContext, f ChangeFunc) (jsutil.CleanupFunc, error) {
+	t := &m.changes
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Storage is only watched while there are subscribers.
+	if t.state == nil {
+		state, err := m.readKeyState(ctx)
+		if err != nil {
+			return nil, err
+		}
+		t.state = state
+		onStorageChange := func(_ map[string]js.Value, _ []string) {
+			jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+				m.checkChanges(ctx)
+				return js.Undefined(), nil
+			})
+		}
+		stopStored := m.storedKeys.Watch(onStorageChange)
+		stopSession := m.sessionKeys.Watch(onStorageChange)
+		// The lock is stored once the agent is locked or unlocked.
+		stopLock := m.agentLock.Watch(onStorageChange)
+		t.stop = func() {
+			stopStored()
+			stopSession()
+			stopLock()
+		}
+		t.subscribers = map[int]ChangeFunc{}
+	}
+
+	sub := t.nextSub
+	t.nextSub++
+	t.subscribers[sub] = f
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := t.subscribers[sub]; !ok {
+			return
+		}
+		delete(t.subscribers, sub)
+		if len(t.subscribers) == 0 {
+			t.stop()
+			t.stop = nil
+			t.state = nil
+			t.subscribers = nil
+		}
+	}, nil
+}
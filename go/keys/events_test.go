@@ -0,0 +1,167 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestDiffKeyState(t *testing.T) {
+	t.Parallel()
+
+	old := &keyState{
+		names:  map[ID]string{"a": "a", "b": "b", "c": "c"},
+		loaded: map[ID]bool{"a": true, "c": true},
+	}
+	cur := &keyState{
+		names:  map[ID]string{"a": "a", "b": "renamed", "d": "d"},
+		loaded: map[ID]bool{"b": true, "d": true},
+	}
+	want := []ChangeEvent{
+		{Kind: KeyAdded, ID: "d"},
+		{Kind: KeyRenamed, ID: "b"},
+		{Kind: KeyLoaded, ID: "b"},
+		{Kind: KeyLoaded, ID: "d"},
+		{Kind: KeyUnloaded, ID: "a"},
+		{Kind: KeyUnloaded, ID: "c"},
+		{Kind: KeyRemoved, ID: "c"},
+	}
+	if diff := cmp.Diff(diffKeyState(old, cur), want); diff != "" {
+		t.Errorf("incorrect events; -got +want: %s", diff)
+	}
+	if got := diffKeyState(cur, cur); got != nil {
+		t.Errorf("unexpected events for unchanged state: %v", got)
+	}
+
+	locked := &keyState{names: cur.names, loaded: cur.loaded, locked: true}
+	if diff := cmp.Diff(diffKeyState(cur, locked), []ChangeEvent{{Kind: AgentLocked, ID: InvalidID}}); diff != "" {
+		t.Errorf("incorrect events when locked; -got +want: %s", diff)
+	}
+	if diff := cmp.Diff(diffKeyState(locked, cur), []ChangeEvent{{Kind: AgentUnlocked, ID: InvalidID}}); diff != "" {
+		t.Errorf("incorrect events when unlocked; -got +want: %s", diff)
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		// other shares storage with mgr, as would a manager on another
+		// device.
+		other := NewManager(agent.NewKeyring(), syncStorage, storage.NewRaw(st.NewMemArea()))
+
+		var got []ChangeEvent
+		stop, err := mgr.OnChange(ctx, func(_ jsutil.AsyncContext, e ChangeEvent) {
+			got = append(got, e)
+		})
+		if err != nil {
+			t.Fatalf("OnChange failed: %v", err)
+		}
+
+		// expect waits for the specified events to be reported.
+		expect := func(description string, want ...ChangeEvent) {
+			t.Helper()
+			poll(func() bool { return len(got) >= len(want) })
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("%s: incorrect events; -got +want: %s", description, diff)
+			}
+			got = nil
+		}
+
+		if err := mgr.Add(ctx, "key", testdata.WithPassphrase.Private); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "key")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+		expect("add", ChangeEvent{Kind: KeyAdded, ID: id})
+
+		if err := mgr.Load(ctx, id, testdata.WithPassphrase.Passphrase); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		expect("load", ChangeEvent{Kind: KeyLoaded, ID: id})
+
+		if err := mgr.Rename(ctx, id, "renamed"); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+		expect("rename", ChangeEvent{Kind: KeyRenamed, ID: id})
+
+		if err := mgr.Unload(ctx, id); err != nil {
+			t.Fatalf("Unload failed: %v", err)
+		}
+		expect("unload", ChangeEvent{Kind: KeyUnloaded, ID: id})
+
+		if err := mgr.Remove(ctx, id); err != nil {
+			t.Fatalf("Remove failed: %v", err)
+		}
+		expect("remove", ChangeEvent{Kind: KeyRemoved, ID: id})
+
+		// Changes made elsewhere are observed in storage.
+		if err := other.Add(ctx, "other", testdata.WithoutPassphrase.Private); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		otherID, err := findKey(ctx, other, InvalidID, "other")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+		expect("add elsewhere", ChangeEvent{Kind: KeyAdded, ID: otherID})
+
+		ephemeralID, err := mgr.LoadEphemeral(ctx, "ephemeral", testdata.ECDSAWithoutPassphrase.Private, "")
+		if err != nil {
+			t.Fatalf("LoadEphemeral failed: %v", err)
+		}
+		expect("load ephemeral", ChangeEvent{Kind: KeyLoaded, ID: ephemeralID})
+
+		if err := mgr.Unload(ctx, ephemeralID); err != nil {
+			t.Fatalf("Unload failed: %v", err)
+		}
+		expect("unload ephemeral", ChangeEvent{Kind: KeyUnloaded, ID: ephemeralID})
+
+		if err := mgr.Lock(ctx, "lock-passphrase"); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		expect("lock", ChangeEvent{Kind: AgentLocked, ID: InvalidID})
+
+		if err := mgr.Unlock(ctx, "lock-passphrase"); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+		expect("unlock", ChangeEvent{Kind: AgentUnlocked, ID: InvalidID})
+
+		// No changes are reported once unsubscribed.
+		stop()
+		if err := mgr.Remove(ctx, otherID); err != nil {
+			t.Fatalf("Remove failed: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if got != nil {
+			t.Errorf("unexpected events after unsubscribing: %v", got)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrKeyExpired indicates that a key is past its expiry, and expired keys may
+// not be loaded. Its identity is preserved by the client returned by
+// NewClient.
+var ErrKeyExpired = errors.New("key has expired")
+
+// expiry returns the time at which the key expires, or the zero time if it
+// does not. This is the earlier of the expiry set using SetExpiry and the end
+// of the validity period of its certificate, if any.
+func (s *storedKey) expiry() time.Time {
+	var t time.Time
+	if s.ExpiresAt != 0 {
+		t = time.UnixMilli(s.ExpiresAt)
+	}
+	if cert := s.Cert(); cert != nil && cert.ValidBefore != ssh.CertTimeInfinity {
+		if c := time.Unix(int64(cert.ValidBefore), 0); t.IsZero() || c.Before(t) {
+			t = c
+		}
+	}
+	return t
+}
+
+// expired determines if a key with the specified expiry has expired at the
+// specified time.
+func expired(expiry, now time.Time) bool {
+	return !expiry.IsZero() && !now.Before(expiry)
+}
+
+// checkExpiry returns an error wrapping ErrKeyExpired if the key has expired
+// and expired keys may not be loaded. If they may, a warning is logged
+// instead.
+func (m *DefaultManager) checkExpiry(ctx jsutil.AsyncContext, key *storedKey) error {
+	expiry := key.expiry()
+	if !expired(expiry, m.now()) {
+		return nil
+	}
+	allow, err := m.AllowExpired(ctx)
+	if err != nil {
+		return err
+	}
+	if !allow {
+		return fmt.Errorf("%w: key expired at %s", ErrKeyExpired, expiry.Format(time.RFC3339))
+	}
+	jsutil.LogError("loading key ID %s, which expired at %s", key.ID, expiry.Format(time.RFC3339))
+	return nil
+}
+
+// SetExpiry implements Manager.SetExpiry.
+func (m *DefaultManager) SetExpiry(ctx jsutil.AsyncContext, id ID, expiresAt time.Time) error {
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(key *storedKey) (*storedKey, error) {
+		updated := *key
+		updated.ExpiresAt = 0
+		if !expiresAt.IsZero() {
+			updated.ExpiresAt = expiresAt.UnixMilli()
+		}
+		return &updated, nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+	return nil
+}
+
+// AllowExpired implements Manager.AllowExpired.
+func (m *DefaultManager) AllowExpired(ctx jsutil.AsyncContext) (bool, error) {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read settings: %w", err)
+	}
+	return s != nil && s.AllowExpired, nil
+}
+
+// SetAllowExpired implements Manager.SetAllowExpired.
+func (m *DefaultManager) SetAllowExpired(ctx jsutil.AsyncContext, allow bool) error {
+	return m.updateSettings(ctx, func(s *managerSettings) {
+		s.AllowExpired = allow
+	})
+}
+
+// ExpiringKeys returns the configured keys that expire within the specified
+// period, including those that have already expired, soonest first. Callers
+// may use it to warn the user ahead of time.
+func (m *DefaultManager) ExpiringKeys(ctx jsutil.AsyncContext, within time.Duration) ([]*ConfiguredKey, error) {
+	configured, err := m.Configured(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline := m.now().Add(within).UnixMilli()
+	var res []*ConfiguredKey
+	for _, k := range configured {
+		if k.ExpiresAtMillis != 0 && k.ExpiresAtMillis <= deadline {
+			res = append(res, k)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ExpiresAtMillis < res[j].ExpiresAtMillis })
+	return res, nil
+}
@@ -0,0 +1,149 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestExpiry(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "soon", PEMPrivateKey: testdata.ED25519WithoutPassphrase.Private},
+			{Name: "later", PEMPrivateKey: testdata.OpenSSHFormat.Private},
+			{Name: "cert", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+			{Name: "never", PEMPrivateKey: testdata.PKCS8Format.Private},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		mgr.now = func() time.Time { return now }
+
+		ids := map[string]ID{}
+		for _, name := range []string{"soon", "later", "cert", "never"} {
+			if ids[name], err = findKey(ctx, mgr, InvalidID, name); err != nil {
+				t.Fatalf("failed to find key %s: %v", name, err)
+			}
+		}
+		if err := mgr.SetExpiry(ctx, ids["soon"], now.Add(time.Hour)); err != nil {
+			t.Fatalf("SetExpiry failed: %v", err)
+		}
+		if err := mgr.SetExpiry(ctx, ids["later"], now.Add(30*24*time.Hour)); err != nil {
+			t.Fatalf("SetExpiry failed: %v", err)
+		}
+		// The certificate expired in 2020, which is earlier than the
+		// expiry set explicitly.
+		if err := mgr.SetCertificate(ctx, ids["cert"], testdata.ExpiredCertificate); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		if err := mgr.SetExpiry(ctx, ids["cert"], now.Add(time.Hour)); err != nil {
+			t.Fatalf("SetExpiry failed: %v", err)
+		}
+		if err := mgr.SetExpiry(ctx, InvalidID, now); !errors.Is(err, errKeyNotFound) {
+			t.Errorf("SetExpiry returned incorrect error for missing key: %v", err)
+		}
+
+		// expectExpired checks which configured keys are reported as expired.
+		expectExpired := func(description string, want map[string]bool) {
+			t.Helper()
+			configured, err := mgr.Configured(ctx)
+			if err != nil {
+				t.Fatalf("%s: failed to get configured keys: %v", description, err)
+			}
+			got := map[string]bool{}
+			for _, k := range configured {
+				got[k.Name] = k.Expired
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("%s: incorrect expired keys; -got +want: %s", description, diff)
+			}
+		}
+		// expectExpiring checks the keys returned by ExpiringKeys.
+		expectExpiring := func(description string, within time.Duration, want []string) {
+			t.Helper()
+			expiring, err := mgr.ExpiringKeys(ctx, within)
+			if err != nil {
+				t.Fatalf("%s: ExpiringKeys failed: %v", description, err)
+			}
+			var got []string
+			for _, k := range expiring {
+				got = append(got, k.Name)
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("%s: incorrect expiring keys; -got +want: %s", description, diff)
+			}
+		}
+
+		expectExpired("initial", map[string]bool{"soon": false, "later": false, "cert": true, "never": false})
+		expectExpiring("initial", 7*24*time.Hour, []string{"cert", "soon"})
+		if err := mgr.Load(ctx, ids["soon"], ""); err != nil {
+			t.Errorf("Load failed before expiry: %v", err)
+		}
+		if err := mgr.Load(ctx, ids["cert"], ""); !errors.Is(err, ErrKeyExpired) {
+			t.Errorf("Load returned incorrect error for expired certificate: %v", err)
+		}
+
+		now = now.Add(2 * time.Hour)
+		expectExpired("after expiry", map[string]bool{"soon": true, "later": false, "cert": true, "never": false})
+		expectExpiring("after expiry", 30*24*time.Hour, []string{"cert", "soon", "later"})
+		if err := mgr.Unload(ctx, ids["soon"]); err != nil {
+			t.Errorf("Unload failed: %v", err)
+		}
+		if err := mgr.Load(ctx, ids["soon"], ""); !errors.Is(err, ErrKeyExpired) {
+			t.Errorf("Load returned incorrect error after expiry: %v", err)
+		}
+
+		// Expired keys may be loaded if allowed.
+		if err := mgr.SetAllowExpired(ctx, true); err != nil {
+			t.Fatalf("SetAllowExpired failed: %v", err)
+		}
+		if allow, err := mgr.AllowExpired(ctx); err != nil || !allow {
+			t.Errorf("incorrect AllowExpired; got %t, %v", allow, err)
+		}
+		if err := mgr.Load(ctx, ids["soon"], ""); err != nil {
+			t.Errorf("Load failed when allowing expired keys: %v", err)
+		}
+		if err := mgr.SetAllowExpired(ctx, false); err != nil {
+			t.Fatalf("SetAllowExpired failed: %v", err)
+		}
+
+		// Clearing the expiry allows the key to be loaded again.
+		if err := mgr.SetExpiry(ctx, ids["soon"], time.Time{}); err != nil {
+			t.Fatalf("SetExpiry failed: %v", err)
+		}
+		if err := mgr.Unload(ctx, ids["soon"]); err != nil {
+			t.Errorf("Unload failed: %v", err)
+		}
+		if err := mgr.Load(ctx, ids["soon"], ""); err != nil {
+			t.Errorf("Load failed after clearing expiry: %v", err)
+		}
+		expectExpiring("after clearing", 7*24*time.Hour, []string{"cert"})
+	})
+}
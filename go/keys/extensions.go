@@ -0,0 +1,81 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// queryExtension is the name of the agent extension with which clients
+// discover the extensions supported by the agent. See Section 3.8.1 of
+// draft-miller-ssh-agent.
+const queryExtension = "query"
+
+// agentSuccess is the SSH_AGENT_SUCCESS message type, with which responses to
+// extensions begin.
+const agentSuccess = 6
+
+// errUnknownExtension indicates that an extension is not supported. Unlike
+// agent.ErrExtensionUnsupported, which results in SSH_AGENT_FAILURE, any other
+// error results in SSH_AGENT_EXTENSION_FAILURE.
+var errUnknownExtension = errors.New("unknown extension")
+
+// extensionHandler handles an extension request received over a connection.
+// It returns the complete response message, or nil to respond with
+// SSH_AGENT_SUCCESS.
+type extensionHandler func(a *connectionAgent, contents []byte) ([]byte, error)
+
+// extensionHandlers returns the handlers for the supported extensions, indexed
+// by name. Extensions are supported by adding them here.
+func extensionHandlers() map[string]extensionHandler {
+	return map[string]extensionHandler{
+		queryExtension:       (*connectionAgent).query,
+		sessionBindExtension: (*connectionAgent).bindSession,
+	}
+}
+
+// Extension implements agent.ExtendedAgent.Extension(). Extensions are
+// handled even if the agent is locked, since they describe the agent or the
+// connection rather than using keys.
+func (a *connectionAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	h, ok := extensionHandlers()[extensionType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownExtension, extensionType)
+	}
+	return h(a, contents)
+}
+
+// query handles the query extension, responding with the names of the
+// supported extensions.
+func (a *connectionAgent) query(_ []byte) ([]byte, error) {
+	var names []string
+	for name := range extensionHandlers() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rsp := []byte{agentSuccess}
+	rsp = append(rsp, ssh.Marshal(struct{ Name string }{queryExtension})...)
+	for _, name := range names {
+		rsp = append(rsp, ssh.Marshal(struct{ Name string }{name})...)
+	}
+	return rsp, nil
+}
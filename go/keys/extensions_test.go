@@ -0,0 +1,91 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestExtensions(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		// Extensions are supported even if the agent is locked.
+		if err := mgr.Lock(ctx, "passphrase"); err != nil {
+			t.Fatalf("failed to lock: %v", err)
+		}
+
+		c, s := net.Pipe()
+		defer c.Close()
+		go agent.ServeAgent(mgr.ConnectionAgent(&Connection{Name: "client"}), s)
+		client := agent.NewClient(c)
+
+		rsp, err := client.Extension(queryExtension, nil)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		if len(rsp) == 0 || rsp[0] != agentSuccess {
+			t.Fatalf("incorrect response to query: %x", rsp)
+		}
+		var names []string
+		for rest := rsp[1:]; len(rest) > 0; {
+			var msg struct {
+				Name string
+				Rest []byte `ssh:"rest"`
+			}
+			if err := ssh.Unmarshal(rest, &msg); err != nil {
+				t.Fatalf("failed to parse response to query: %v", err)
+			}
+			names = append(names, msg.Name)
+			rest = msg.Rest
+		}
+		// The response repeats the name of the query extension, followed
+		// by the supported extensions.
+		want := []string{queryExtension, queryExtension, sessionBindExtension}
+		if diff := cmp.Diff(names, want); diff != "" {
+			t.Errorf("incorrect extensions; -got +want: %s", diff)
+		}
+
+		// Unknown extensions fail with SSH_AGENT_EXTENSION_FAILURE, which
+		// the client distinguishes from SSH_AGENT_FAILURE.
+		if _, err := client.Extension("unknown@example.com", nil); err == nil || errors.Is(err, agent.ErrExtensionUnsupported) {
+			t.Errorf("incorrect error for unknown extension: %v", err)
+		}
+
+		// The connection remains usable.
+		if _, err := client.Extension(queryExtension, nil); err != nil {
+			t.Errorf("query failed after unknown extension: %v", err)
+		}
+
+		// Agents not bound to a connection pass extensions through to
+		// the wrapped agent, rather than refusing them while locked.
+		if _, err := mgr.Agent().Extension(queryExtension, nil); errors.Is(err, errAgentLocked) {
+			t.Errorf("extension refused while locked: %v", err)
+		}
+	})
+}
@@ -0,0 +1,226 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyType is a type of key that may be generated.
+type KeyType string
+
+const (
+	// KeyTypeED25519 is an Ed25519 key. The size is fixed.
+	KeyTypeED25519 KeyType = "ed25519"
+	// KeyTypeECDSA is an ECDSA key, using the NIST P-256 or P-384 curve.
+	KeyTypeECDSA KeyType = "ecdsa"
+	// KeyTypeRSA is an RSA key of 2048 or 4096 bits.
+	KeyTypeRSA KeyType = "rsa"
+)
+
+// ProgressFunc is invoked to report the progress of a long-running operation,
+// as a fraction between 0 and 1.
+type ProgressFunc func(progress float64)
+
+// ErrUnsupportedKeyType indicates that keys of the requested or supplied type
+// are not supported. Its identity is preserved by the client returned by
+// NewClient.
+var ErrUnsupportedKeyType = errors.New("unsupported key type")
+
+var (
+	errInvalidPassphrase = errors.New("invalid passphrase")
+	errGenerateFailed    = errors.New("key generation failed")
+)
+
+const (
+	// rsaGeneratedProgress is the fraction of the work reported as
+	// complete once an RSA key is generated. WebCrypto does not report
+	// its progress, and generating a key takes about as long as
+	// encrypting it. Other keys are generated almost immediately.
+	rsaGeneratedProgress = 0.5
+	// encryptedProgress is the fraction of the work reported as complete
+	// once the key is encrypted with its passphrase. The remainder is
+	// storing the key.
+	encryptedProgress = 0.95
+)
+
+// yieldInterval is how long CPU-intensive work may run before yielding to the
+// event loop, such that the agent continues to handle requests.
+const yieldInterval = 50 * time.Millisecond
+
+// yielder allows CPU-intensive work to periodically yield to the event loop.
+type yielder struct {
+	last time.Time
+}
+
+// maybeYield yields to the event loop if the work has run for longer than
+// yieldInterval since it last yielded.
+func (y *yielder) maybeYield() {
+	if time.Since(y.last) < yieldInterval {
+		return
+	}
+	done := make(chan struct{})
+	jsutil.SetTimeout(0, func() { close(done) })
+	<-done
+	y.last = time.Now()
+}
+
+var (
+	// subtleCrypto is the WebCrypto API, with which RSA keys are generated.
+	subtleCrypto = js.Global().Get("crypto").Get("subtle")
+	// rsaExponent is the public exponent used for generated RSA keys,
+	// 65537, as a big-endian integer.
+	rsaExponent = []byte{0x01, 0x00, 0x01}
+)
+
+// generateRSA returns a new RSA key of the specified number of bits. The key
+// is generated by WebCrypto, which does so without blocking the event loop;
+// generating a 4096-bit key in WebAssembly would block the agent for many
+// seconds.
+func generateRSA(ctx jsutil.AsyncContext, bits int) (*rsa.PrivateKey, error) {
+	exponent := js.Global().Get("Uint8Array").New(len(rsaExponent))
+	js.CopyBytesToJS(exponent, rsaExponent)
+	pair, err := jsutil.AsPromise(subtleCrypto.Call("generateKey",
+		js.ValueOf(map[string]interface{}{
+			"name":           "RSASSA-PKCS1-v1_5",
+			"modulusLength":  bits,
+			"publicExponent": exponent,
+			"hash":           "SHA-256",
+		}),
+		true,
+		js.ValueOf([]interface{}{"sign", "verify"}))).Await(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	exported, err := jsutil.AsPromise(subtleCrypto.Call("exportKey", "pkcs8", pair.Get("privateKey"))).Await(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export key: %w", err)
+	}
+
+	buf := js.Global().Get("Uint8Array").New(exported)
+	der := make([]byte, buf.Length())
+	js.CopyBytesToGo(der, buf)
+	defer clear(der)
+	// The exported copy is no longer needed.
+	buf.Call("fill", 0)
+
+	priv, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+	key, ok := priv.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: generated %T", ErrUnsupportedKeyType, priv)
+	}
+	return key, nil
+}
+
+// generateKey returns a new private key of the specified type and size.
+func generateKey(ctx jsutil.AsyncContext, keyType KeyType, bits int) (crypto.PrivateKey, error) {
+	switch keyType {
+	case KeyTypeED25519:
+		if bits != 0 && bits != 256 {
+			return nil, fmt.Errorf("%w: %s keys must be 256 bits", ErrUnsupportedKeyType, keyType)
+		}
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	case KeyTypeECDSA:
+		var curve elliptic.Curve
+		switch bits {
+		case 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("%w: %s keys must be 256 or 384 bits", ErrUnsupportedKeyType, keyType)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case KeyTypeRSA:
+		if bits != 2048 && bits != 4096 {
+			return nil, fmt.Errorf("%w: %s keys must be 2048 or 4096 bits", ErrUnsupportedKeyType, keyType)
+		}
+		return generateRSA(ctx, bits)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyType, keyType)
+	}
+}
+
+// Generate implements Manager.Generate.
+func (m *DefaultManager) Generate(ctx jsutil.AsyncContext, name string, keyType KeyType, bits int, passphrase string, progress ProgressFunc) (string, error) {
+	return m.generate(ctx, name, keyType, bits, passphrase, progress)
+}
+
+// generate generates a new key as for Generate, and adds it using the
+// specified options.
+func (m *DefaultManager) generate(ctx jsutil.AsyncContext, name string, keyType KeyType, bits int, passphrase string, progress ProgressFunc, opts ...AddOption) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("%w: name must not be empty", errInvalidName)
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("%w: passphrase must not be empty", errInvalidPassphrase)
+	}
+	if progress == nil {
+		progress = func(float64) {}
+	}
+	defer m.keepAlive.Acquire()()
+
+	// Generating an RSA key does not report its own progress, so progress
+	// is reported once it completes. Encrypting the key reports progress
+	// as it goes.
+	progress(0)
+	priv, err := generateKey(ctx, keyType, bits)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errGenerateFailed, err)
+	}
+	var generated float64
+	if keyType == KeyTypeRSA {
+		generated = rsaGeneratedProgress
+		progress(generated)
+	}
+
+	block, err := marshalEncryptedKey(priv, name, []byte(passphrase), func(p float64) {
+		progress(generated + p*(encryptedProgress-generated))
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errMarshalFailed, err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errMarshalFailed, err)
+	}
+
+	if err := m.Add(ctx, name, string(pem.EncodeToMemory(block)), opts...); err != nil {
+		return "", fmt.Errorf("failed to store key: %w", err)
+	}
+	progress(1)
+
+	return authorizedKey(signer.PublicKey(), name), nil
+}
@@ -0,0 +1,278 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/base64"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keepalive"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description    string
+		name           string
+		keyType        KeyType
+		bits           int
+		passphrase     string
+		wantType       string
+		wantConfigured []*ConfiguredKey
+		wantErr        error
+	}{
+		{
+			description:    "generate ed25519 key",
+			name:           "new-key",
+			keyType:        KeyTypeED25519,
+			passphrase:     "secret",
+			wantType:       ssh.KeyAlgoED25519,
+			wantConfigured: []*ConfiguredKey{{Name: "new-key", Encrypted: true, Format: FormatOpenSSH, Type: ssh.KeyAlgoED25519, Bits: 256}},
+		},
+		{
+			description:    "generate ecdsa-p256 key",
+			name:           "new-key",
+			keyType:        KeyTypeECDSA,
+			bits:           256,
+			passphrase:     "secret",
+			wantType:       ssh.KeyAlgoECDSA256,
+			wantConfigured: []*ConfiguredKey{{Name: "new-key", Encrypted: true, Format: FormatOpenSSH, Type: ssh.KeyAlgoECDSA256, Bits: 256}},
+		},
+		{
+			description:    "generate ecdsa-p384 key",
+			name:           "new-key",
+			keyType:        KeyTypeECDSA,
+			bits:           384,
+			passphrase:     "secret",
+			wantType:       ssh.KeyAlgoECDSA384,
+			wantConfigured: []*ConfiguredKey{{Name: "new-key", Encrypted: true, Format: FormatOpenSSH, Type: ssh.KeyAlgoECDSA384, Bits: 384}},
+		},
+		{
+			description:    "generate rsa-2048 key",
+			name:           "new-key",
+			keyType:        KeyTypeRSA,
+			bits:           2048,
+			passphrase:     "secret",
+			wantType:       ssh.KeyAlgoRSA,
+			wantConfigured: []*ConfiguredKey{{Name: "new-key", Encrypted: true, Format: FormatOpenSSH, Type: ssh.KeyAlgoRSA, Bits: 2048}},
+		},
+		{
+			description: "reject unsupported size",
+			name:        "new-key",
+			keyType:     KeyTypeRSA,
+			bits:        1024,
+			passphrase:  "secret",
+			wantErr:     errGenerateFailed,
+		},
+		{
+			description: "reject unsupported type",
+			name:        "new-key",
+			keyType:     KeyType("dsa"),
+			passphrase:  "secret",
+			wantErr:     errGenerateFailed,
+		},
+		{
+			description: "reject empty passphrase",
+			name:        "new-key",
+			keyType:     KeyTypeED25519,
+			wantErr:     errInvalidPassphrase,
+		},
+		{
+			description: "reject invalid name",
+			keyType:     KeyTypeED25519,
+			passphrase:  "secret",
+			wantErr:     errInvalidName,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				syncStorage := storage.NewRaw(st.NewMemArea())
+				sessionStorage := storage.NewRaw(st.NewMemArea())
+				mgr := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+
+				var progress []float64
+				pub, err := mgr.Generate(ctx, tc.name, tc.keyType, tc.bits, tc.passphrase, func(p float64) { progress = append(progress, p) })
+				if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("incorrect error; -got +want: %s", diff)
+				}
+
+				configured, err := mgr.Configured(ctx)
+				if err != nil {
+					t.Errorf("failed to get configured keys: %v", err)
+					return
+				}
+				if diff := cmp.Diff(configured, tc.wantConfigured, cmpopts.IgnoreFields(ConfiguredKey{}, "ID"), ignoreFingerprints); diff != "" {
+					t.Errorf("incorrect configured keys; -got +want: %s", diff)
+				}
+				if tc.wantErr != nil {
+					return
+				}
+
+				// Progress is reported in order, through to completion.
+				if !sort.Float64sAreSorted(progress) || len(progress) < 2 || progress[0] != 0 || progress[len(progress)-1] != 1 {
+					t.Errorf("incorrect progress: %v", progress)
+				}
+
+				parsed, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(pub))
+				if err != nil {
+					t.Errorf("failed to parse public key %q: %v", pub, err)
+					return
+				}
+				if diff := cmp.Diff(parsed.Type(), tc.wantType); diff != "" {
+					t.Errorf("incorrect key type; -got +want: %s", diff)
+				}
+				if diff := cmp.Diff(comment, tc.name); diff != "" {
+					t.Errorf("incorrect comment; -got +want: %s", diff)
+				}
+
+				// The generated key can be loaded with the passphrase,
+				// and matches the returned public key.
+				if err := mgr.Load(ctx, ID(configured[0].ID), tc.passphrase); err != nil {
+					t.Errorf("failed to load generated key: %v", err)
+					return
+				}
+				loaded, err := mgr.Loaded(ctx)
+				if err != nil {
+					t.Errorf("failed to get loaded keys: %v", err)
+					return
+				}
+				wantBlobs := []string{base64.StdEncoding.EncodeToString(parsed.Marshal())}
+				if diff := cmp.Diff(loadedKeyBlobs(loaded), wantBlobs); diff != "" {
+					t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestGenerateRSAYields(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		// Count timer callbacks that run while a key is generated. If
+		// generation blocked the event loop, none would run.
+		ticks := 0
+		stop := false
+		var tick func()
+		tick = func() {
+			if stop {
+				return
+			}
+			ticks++
+			jsutil.SetTimeout(yieldInterval/2, tick)
+		}
+		jsutil.SetTimeout(yieldInterval/2, tick)
+
+		start := time.Now()
+		_, err := generateRSA(ctx, 2048)
+		elapsed := time.Since(start)
+		stop = true
+		if err != nil {
+			t.Errorf("generateRSA failed: %v", err)
+			return
+		}
+		// Generation need not yield if it completes quickly.
+		if elapsed > 2*yieldInterval && ticks == 0 {
+			t.Errorf("key generation did not yield to the event loop")
+		}
+	})
+}
+
+func TestKeepAlive(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		k := keepalive.New(func() {}, time.Hour)
+		mgr.SetKeepAlive(k)
+
+		// The service worker is kept alive while the key is generated.
+		var held []int
+		if _, err := mgr.Generate(ctx, "new-key", KeyTypeED25519, 0, "secret", func(float64) {
+			held = append(held, k.Held())
+		}); err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		if len(held) == 0 || slices.ContainsFunc(held, func(n int) bool { return n != 1 }) {
+			t.Errorf("incorrect holds during generation; got %v, want 1 throughout", held)
+		}
+
+		// Each operation releases the KeepAlive once complete, whether
+		// or not it succeeds.
+		for _, op := range []struct {
+			description string
+			do          func() error
+		}{
+			{
+				description: "failed generation",
+				do: func() error {
+					_, err := mgr.Generate(ctx, "bad-key", KeyTypeRSA, 1024, "secret", nil)
+					return err
+				},
+			},
+			{
+				description: "batch add",
+				do: func() error {
+					_, err := mgr.AddBatch(ctx, []*AddItem{{Name: "batch-key", PEMPrivateKey: testdata.WithPassphrase.Private}})
+					return err
+				},
+			},
+			{
+				description: "enable master key",
+				do: func() error {
+					_, err := mgr.EnableMasterKey(ctx, "master", nil)
+					return err
+				},
+			},
+			{
+				description: "disable master key",
+				do: func() error {
+					_, err := mgr.DisableMasterKey(ctx, "master", nil)
+					return err
+				},
+			},
+			{
+				description: "migration",
+				do: func() error {
+					mgr.CleanupOldData(ctx)
+					return nil
+				},
+			},
+		} {
+			op.do()
+			if n := k.Held(); n != 0 {
+				t.Errorf("%s: incorrect holds once complete; got %d, want 0", op.description, n)
+			}
+		}
+	})
+}
@@ -0,0 +1,367 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// UseDefaultIdleTimeout may be supplied to SetIdleTimeout to indicate that
+// the key should be unloaded after the default idle timeout.
+const UseDefaultIdleTimeout time.Duration = -1
+
+var errInvalidTimeout = errors.New("invalid timeout")
+
+// lastUse is the raw object stored in session storage recording when a loaded
+// key was last used to sign. It is stored under the key's ID.
+type lastUse struct {
+	ID string `js:"id"`
+	// Time is the time at which the key was last used, in milliseconds
+	// since the epoch.
+	Time int64 `js:"time"`
+}
+
+// managerSettings is the raw object stored in persistent storage for settings
+// that apply to all configured keys.
+type managerSettings struct {
+	// DefaultIdleTimeout is the idle timeout in milliseconds for keys
+	// that do not override it. Zero indicates that keys never expire.
+	DefaultIdleTimeout int64 `js:"defaultIdleTimeout"`
+	// MemoryOnly indicates that decrypted keys are not persisted to the
+	// session. See SetPersistSession.
+	MemoryOnly bool `js:"memoryOnly"`
+	// DisableAuditLog indicates that requests to sign are not recorded in
+	// the audit log. See SetAuditLogEnabled.
+	DisableAuditLog bool `js:"disableAuditLog"`
+	// KeyEncryption is the method used to encrypt keys. See
+	// SetDefaultKeyEncryption.
+	KeyEncryption string `js:"keyEncryption"`
+	// AllowExpired indicates that expired keys may be loaded. See
+	// SetAllowExpired.
+	AllowExpired bool `js:"allowExpired"`
+	// MaxLoadedKeys is the maximum number of loaded identities, or zero
+	// if unlimited. See SetMaxLoadedKeys.
+	MaxLoadedKeys int `js:"maxLoadedKeys"`
+	// KeyOrder is the order in which loaded identities are offered. See
+	// SetKeyOrder.
+	KeyOrder string `js:"keyOrder"`
+	// ProtocolKeys is the policy for keys added over the agent protocol.
+	// See SetProtocolKeyPolicy.
+	ProtocolKeys string `js:"protocolKeys"`
+	// CustomAllowedClients indicates that AllowedClients replaces
+	// DefaultAllowedClients. See AllowClient.
+	CustomAllowedClients bool `js:"customAllowedClients"`
+	// AllowedClients are the IDs of the extensions permitted to connect
+	// to the agent, if CustomAllowedClients is set.
+	AllowedClients []string `js:"allowedClients"`
+	// NativeHosts are the names of the native messaging hosts to which
+	// the agent connects. See AllowNativeHost.
+	NativeHosts []string `js:"nativeHosts"`
+}
+
+var (
+	// lastUsePrefixes is the prefix for last-use times stored in-memory
+	// for our current session.
+	lastUsePrefixes = []string{"lastUse"}
+	// settingsPrefixes is the prefix for settings stored in persistent
+	// storage.
+	settingsPrefixes = []string{"settings"}
+)
+
+const (
+	// managerSettingsKey is the storage key for managerSettings.
+	managerSettingsKey = "manager"
+)
+
+// recordUse records that the keys with the specified IDs were used at the
+// current time. Failures are logged; at worst, keys are unloaded early.
+func (m *DefaultManager) recordUse(ctx jsutil.AsyncContext, ids ...ID) {
+	now := m.now().UnixMilli()
+	for _, id := range ids {
+		lu := &lastUse{
+			ID:   string(id),
+			Time: now,
+		}
+		if err := m.lastUses.WriteKey(ctx, string(id), lu); err != nil {
+			jsutil.LogError("failed to record use of key ID %s: %v", id, err)
+		}
+	}
+}
+
+// onSign is invoked by the agent when a key is used to sign. Signing happens
+// outside of an async context, so the use is recorded asynchronously.
+func (m *DefaultManager) onSign(id ID) {
+	jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+		m.recordUse(ctx, id)
+		return js.Undefined(), nil
+	})
+}
+
+// forgetUse removes the last-use times for the keys with the specified IDs.
+// Failures are logged.
+func (m *DefaultManager) forgetUse(ctx jsutil.AsyncContext, ids ...ID) {
+	remove := map[ID]bool{}
+	for _, id := range ids {
+		remove[id] = true
+	}
+	if err := m.lastUses.Delete(ctx, func(lu *lastUse) bool { return remove[ID(lu.ID)] }); err != nil {
+		jsutil.LogError("failed to remove last-use times: %v", err)
+	}
+}
+
+// validTimeout returns an error if the timeout is negative.
+func validTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("%w: timeout must not be negative: %s", errInvalidTimeout, timeout)
+	}
+	return nil
+}
+
+// DefaultIdleTimeout implements Manager.DefaultIdleTimeout.
+func (m *DefaultManager) DefaultIdleTimeout(ctx jsutil.AsyncContext) (time.Duration, error) {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read settings: %w", err)
+	}
+	if s == nil {
+		return 0, nil
+	}
+	return time.Duration(s.DefaultIdleTimeout) * time.Millisecond, nil
+}
+
+// SetDefaultIdleTimeout implements Manager.SetDefaultIdleTimeout.
+func (m *DefaultManager) SetDefaultIdleTimeout(ctx jsutil.AsyncContext, timeout time.Duration) error {
+	if err := validTimeout(timeout); err != nil {
+		return err
+	}
+	if err := m.updateSettings(ctx, func(s *managerSettings) {
+		s.DefaultIdleTimeout = timeout.Milliseconds()
+	}); err != nil {
+		return err
+	}
+	m.scheduleIdleUnload(ctx)
+	return nil
+}
+
+// updateSettings applies the update to the settings, preserving those that it
+// does not modify.
+func (m *DefaultManager) updateSettings(ctx jsutil.AsyncContext, update func(s *managerSettings)) error {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return fmt.Errorf("failed to read settings: %w", err)
+	}
+	if s == nil {
+		s = &managerSettings{}
+	}
+	update(s)
+	if err := m.settings.WriteKey(ctx, managerSettingsKey, s); err != nil {
+		return fmt.Errorf("failed to write settings: %w", err)
+	}
+	return nil
+}
+
+// SetIdleTimeout implements Manager.SetIdleTimeout.
+func (m *DefaultManager) SetIdleTimeout(ctx jsutil.AsyncContext, id ID, timeout time.Duration) error {
+	if timeout != UseDefaultIdleTimeout {
+		if err := validTimeout(timeout); err != nil {
+			return err
+		}
+	}
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(key *storedKey) (*storedKey, error) {
+		updated := *key
+		updated.OverrideIdleTimeout = timeout != UseDefaultIdleTimeout
+		updated.IdleTimeout = 0
+		if updated.OverrideIdleTimeout {
+			updated.IdleTimeout = timeout.Milliseconds()
+		}
+		return &updated, nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+	m.scheduleIdleUnload(ctx)
+	return nil
+}
+
+// idleTimeout returns the idle timeout for the key, given the default.
+func (s *storedKey) idleTimeout(defaultTimeout time.Duration) time.Duration {
+	if !s.OverrideIdleTimeout {
+		return defaultTimeout
+	}
+	return time.Duration(s.IdleTimeout) * time.Millisecond
+}
+
+// unloadDeadlines returns the time at which each loaded key with an idle
+// timeout or a lifetime must be unloaded, whichever is sooner. A key is idle
+// if it has not been used since it was loaded. Loaded keys for which no load
+// time is known (i.e., those loaded by a previous version of the extension)
+// are treated as having been used now.
+func (m *DefaultManager) unloadDeadlines(ctx jsutil.AsyncContext) (map[ID]time.Time, error) {
+	defaultTimeout, err := m.DefaultIdleTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	timeouts := map[ID]time.Duration{}
+	for _, k := range keys {
+		timeouts[ID(k.ID)] = k.idleTimeout(defaultTimeout)
+	}
+
+	uses, err := m.lastUses.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read last-use times: %w", err)
+	}
+	lastUsed := map[ID]time.Time{}
+	for _, lu := range uses {
+		lastUsed[ID(lu.ID)] = time.UnixMilli(lu.Time)
+	}
+
+	loaded, err := m.sessionKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session keys: %w", err)
+	}
+	deadlines := map[ID]time.Time{}
+	var unknown []ID
+	for _, sk := range loaded {
+		id := ID(sk.ID)
+		if sk.LifetimeEnd != 0 {
+			deadlines[id] = time.UnixMilli(sk.LifetimeEnd)
+		}
+		timeout := timeouts[id]
+		if timeout == 0 {
+			continue
+		}
+		last := time.UnixMilli(sk.LoadTime)
+		if used, ok := lastUsed[id]; ok && used.After(last) {
+			last = used
+		} else if sk.LoadTime == 0 {
+			last = m.now()
+			unknown = append(unknown, id)
+		}
+		if d, ok := deadlines[id]; !ok || last.Add(timeout).Before(d) {
+			deadlines[id] = last.Add(timeout)
+		}
+	}
+	m.keysMu.Lock()
+	for id, end := range m.ephemeralLifetimes {
+		deadlines[id] = end
+	}
+	m.keysMu.Unlock()
+	m.recordUse(ctx, unknown...)
+	return deadlines, nil
+}
+
+// UnloadIdle unloads keys that have not been used within their idle timeout,
+// or whose lifetime has ended, and returns their IDs. Keys that fail to unload are logged and skipped.
+func (m *DefaultManager) UnloadIdle(ctx jsutil.AsyncContext) ([]ID, error) {
+	deadlines, err := m.unloadDeadlines(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	var unloaded []ID
+	var next time.Time
+	for id, deadline := range deadlines {
+		if deadline.After(now) {
+			if next.IsZero() || deadline.Before(next) {
+				next = deadline
+			}
+			continue
+		}
+		jsutil.LogDebug("DefaultManager.UnloadIdle: unloading key ID %s", id)
+		if err := m.Unload(ctx, id); err != nil {
+			jsutil.LogError("failed to unload key ID %s: %v; skipping", id, err)
+			continue
+		}
+		unloaded = append(unloaded, id)
+	}
+
+	if err := m.scheduleAlarm(ctx, next); err != nil {
+		jsutil.LogError("failed to schedule unloading of idle keys: %v", err)
+	}
+	return unloaded, nil
+}
+
+// UnloadIdleWithAlarms arranges for the named alarm of the chrome.alarms API
+// to fire when the next loaded key becomes idle or reaches the end of its
+// lifetime. When it fires, the caller should invoke UnloadIdle, which
+// reschedules the alarm as required. Alarms persist beyond the lifetime of the
+// extension's service worker, as do the times at which keys were last used
+// and the ends of their lifetimes.
+func (m *DefaultManager) UnloadIdleWithAlarms(ctx jsutil.AsyncContext, alarms js.Value, name string) {
+	m.alarms = alarms
+	m.alarmName = name
+	m.scheduleIdleUnload(ctx)
+}
+
+// scheduleIdleUnload ensures that the alarm fires no later than the time at
+// which the next loaded key becomes idle or reaches the end of its lifetime.
+// Failures are logged.
+func (m *DefaultManager) scheduleIdleUnload(ctx jsutil.AsyncContext) {
+	if m.alarms.IsUndefined() {
+		return
+	}
+	deadlines, err := m.unloadDeadlines(ctx)
+	if err != nil {
+		jsutil.LogError("failed to determine when keys become idle: %v", err)
+		return
+	}
+	var next time.Time
+	for _, deadline := range deadlines {
+		if next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	if err := m.scheduleAlarm(ctx, next); err != nil {
+		jsutil.LogError("failed to schedule unloading of idle keys: %v", err)
+	}
+}
+
+// scheduleAlarm ensures that the alarm fires no later than the specified
+// time. It does nothing if the time is zero, or if alarms are not in use.
+func (m *DefaultManager) scheduleAlarm(ctx jsutil.AsyncContext, when time.Time) error {
+	if m.alarms.IsUndefined() || when.IsZero() {
+		return nil
+	}
+
+	existing, err := jsutil.AsPromise(m.alarms.Call("get", m.alarmName)).Await(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read alarm: %w", err)
+	}
+	if existing.Type() == js.TypeObject && int64(existing.Get("scheduledTime").Float()) <= when.UnixMilli() {
+		return nil // Already scheduled sooner.
+	}
+
+	info := jsutil.NewObject()
+	info.Set("when", when.UnixMilli())
+	if _, err := jsutil.AsPromise(m.alarms.Call("create", m.alarmName, info)).Await(ctx); err != nil {
+		return fmt.Errorf("failed to create alarm: %w", err)
+	}
+	return nil
+}
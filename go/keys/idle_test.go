@@ -0,0 +1,261 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestIdleTimeoutSettings(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "good-key", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Errorf("failed to initialize manager: %v", err)
+			return
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "good-key")
+		if err != nil {
+			t.Errorf("failed to find key: %v", err)
+			return
+		}
+
+		type timeouts struct {
+			Default     time.Duration
+			Key         time.Duration
+			OverrideKey bool
+		}
+		check := func(want timeouts) {
+			got := timeouts{}
+			if got.Default, err = mgr.DefaultIdleTimeout(ctx); err != nil {
+				t.Errorf("failed to get default idle timeout: %v", err)
+			}
+			configured, err := mgr.Configured(ctx)
+			if err != nil || len(configured) != 1 {
+				t.Errorf("failed to get configured keys: %v", err)
+				return
+			}
+			got.Key, got.OverrideKey = configured[0].IdleTimeout(), configured[0].OverrideIdleTimeout
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("incorrect timeouts; -got +want: %s", diff)
+			}
+		}
+
+		// Keys never expire by default.
+		check(timeouts{})
+
+		// Keys use the default unless they override it.
+		if err := mgr.SetDefaultIdleTimeout(ctx, 5*time.Minute); err != nil {
+			t.Errorf("failed to set default idle timeout: %v", err)
+		}
+		check(timeouts{Default: 5 * time.Minute, Key: 5 * time.Minute})
+
+		if err := mgr.SetIdleTimeout(ctx, id, 0); err != nil {
+			t.Errorf("failed to set idle timeout: %v", err)
+		}
+		check(timeouts{Default: 5 * time.Minute, Key: 0, OverrideKey: true})
+
+		if err := mgr.SetIdleTimeout(ctx, id, time.Hour); err != nil {
+			t.Errorf("failed to set idle timeout: %v", err)
+		}
+		check(timeouts{Default: 5 * time.Minute, Key: time.Hour, OverrideKey: true})
+
+		if err := mgr.SetIdleTimeout(ctx, id, UseDefaultIdleTimeout); err != nil {
+			t.Errorf("failed to set idle timeout: %v", err)
+		}
+		check(timeouts{Default: 5 * time.Minute, Key: 5 * time.Minute})
+
+		// Invalid settings are rejected.
+		err = mgr.SetDefaultIdleTimeout(ctx, -time.Minute)
+		if diff := cmp.Diff(err, errInvalidTimeout, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+		err = mgr.SetIdleTimeout(ctx, id, -time.Minute)
+		if diff := cmp.Diff(err, errInvalidTimeout, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+		err = mgr.SetIdleTimeout(ctx, ID("bogus-id"), time.Minute)
+		if diff := cmp.Diff(err, errKeyNotFound, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+		check(timeouts{Default: 5 * time.Minute, Key: 5 * time.Minute})
+	})
+}
+
+func TestUnloadIdle(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		start := time.UnixMilli(1700000000000)
+		now := start
+		mgr := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		mgr.now = func() time.Time { return now }
+
+		if err := mgr.SetDefaultIdleTimeout(ctx, 5*time.Minute); err != nil {
+			t.Errorf("failed to set default idle timeout: %v", err)
+			return
+		}
+		ids := map[string]ID{}
+		for _, k := range []struct {
+			name    string
+			pem     string
+			timeout time.Duration
+		}{
+			{name: "default-used", pem: testdata.WithoutPassphrase.Private, timeout: UseDefaultIdleTimeout},
+			{name: "default-unused", pem: testdata.ECDSAWithoutPassphrase.Private, timeout: UseDefaultIdleTimeout},
+			{name: "never", pem: testdata.ED25519WithoutPassphrase.Private, timeout: 0},
+			{name: "override", pem: testdata.OpenSSHFormatWithoutPassphrase.Private, timeout: 10 * time.Minute},
+		} {
+			if err := mgr.Add(ctx, k.name, k.pem); err != nil {
+				t.Errorf("failed to add key: %v", err)
+				return
+			}
+			id, err := findKey(ctx, mgr, InvalidID, k.name)
+			if err != nil {
+				t.Errorf("failed to find key: %v", err)
+				return
+			}
+			ids[k.name] = id
+			if err := mgr.SetIdleTimeout(ctx, id, k.timeout); err != nil {
+				t.Errorf("failed to set idle timeout: %v", err)
+				return
+			}
+			if err := mgr.Load(ctx, id, ""); err != nil {
+				t.Errorf("failed to load key: %v", err)
+				return
+			}
+		}
+
+		alarms := st.NewAlarms()
+		const alarmName = "unload-idle"
+		mgr.UnloadIdleWithAlarms(ctx, alarms, alarmName)
+		if diff := cmp.Diff(st.AlarmTime(alarms, alarmName), start.Add(5*time.Minute).UnixMilli()); diff != "" {
+			t.Errorf("incorrect alarm time; -got +want: %s", diff)
+		}
+
+		// Use a key. The use is recorded asynchronously.
+		now = start.Add(4 * time.Minute)
+		loaded, err := mgr.Agent().List()
+		if err != nil {
+			t.Errorf("failed to list keys: %v", err)
+			return
+		}
+		for _, l := range loaded {
+			if (&LoadedKey{Comment: l.Comment}).ID() != ids["default-used"] {
+				continue
+			}
+			if _, err := mgr.Agent().Sign(l, []byte("data")); err != nil {
+				t.Errorf("failed to sign: %v", err)
+				return
+			}
+		}
+		if !poll(func() bool {
+			lu, err := mgr.lastUses.ReadKey(ctx, string(ids["default-used"]))
+			return err == nil && lu != nil
+		}) {
+			t.Errorf("use of key not recorded")
+			return
+		}
+
+		// Restart with the same session, as happens when the service worker
+		// is restarted.
+		mgr = NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		mgr.now = func() time.Time { return now }
+		if err := mgr.LoadFromSession(ctx); err != nil {
+			t.Errorf("failed to load keys from session: %v", err)
+			return
+		}
+		mgr.UnloadIdleWithAlarms(ctx, alarms, alarmName)
+
+		for _, step := range []struct {
+			elapsed      time.Duration
+			wantUnloaded []ID
+			wantLoaded   []ID
+			wantAlarm    time.Duration
+		}{
+			{
+				elapsed:      6 * time.Minute,
+				wantUnloaded: []ID{ids["default-unused"]},
+				wantLoaded:   []ID{ids["default-used"], ids["never"], ids["override"]},
+				wantAlarm:    9 * time.Minute,
+			},
+			{
+				elapsed:      9 * time.Minute,
+				wantUnloaded: []ID{ids["default-used"]},
+				wantLoaded:   []ID{ids["never"], ids["override"]},
+				wantAlarm:    10 * time.Minute,
+			},
+			{
+				elapsed:      11 * time.Minute,
+				wantUnloaded: []ID{ids["override"]},
+				wantLoaded:   []ID{ids["never"]},
+			},
+		} {
+			now = start.Add(step.elapsed)
+			st.FireAlarm(alarms, alarmName)
+			unloaded, err := mgr.UnloadIdle(ctx)
+			if err != nil {
+				t.Errorf("UnloadIdle failed after %s: %v", step.elapsed, err)
+				return
+			}
+			if diff := cmp.Diff(unloaded, step.wantUnloaded); diff != "" {
+				t.Errorf("incorrect keys unloaded after %s; -got +want: %s", step.elapsed, diff)
+			}
+			loaded, err := mgr.Loaded(ctx)
+			if err != nil {
+				t.Errorf("failed to get loaded keys: %v", err)
+				return
+			}
+			if diff := cmp.Diff(loadedKeyIDs(loaded), step.wantLoaded, cmpopts.SortSlices(func(a, b ID) bool { return a < b })); diff != "" {
+				t.Errorf("incorrect keys loaded after %s; -got +want: %s", step.elapsed, diff)
+			}
+			var wantAlarm int64
+			if step.wantAlarm != 0 {
+				wantAlarm = start.Add(step.wantAlarm).UnixMilli()
+			}
+			if diff := cmp.Diff(st.AlarmTime(alarms, alarmName), wantAlarm); diff != "" {
+				t.Errorf("incorrect alarm time after %s; -got +want: %s", step.elapsed, diff)
+			}
+		}
+	})
+}
+
+// poll checks a condition for up to a second, yielding between checks.
+func poll(done func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if done() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
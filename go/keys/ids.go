@@ -0,0 +1,308 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+// keyID returns the ID for a key with the specified public key. It is derived
+// from the SHA-256 fingerprint, such that the same key is assigned the same ID
+// on every device to which it is added.
+func keyID(pub ssh.PublicKey) ID {
+	sum := sha256.Sum256(pub.Marshal())
+	return ID(hex.EncodeToString(sum[:16]))
+}
+
+// isKeyID determines if id is one that allocateID may assign to a key with
+// the specified public key.
+func isKeyID(id ID, pub ssh.PublicKey) bool {
+	base := string(keyID(pub))
+	return string(id) == base || strings.HasPrefix(string(id), base+"-")
+}
+
+// allocateID returns the ID for a new key with the specified public key. If
+// the key is added more than once (see AllowDuplicate), each subsequent copy
+// is assigned the ID with a numeric suffix, such that all copies remain
+// distinct. A random ID is returned if the public key is not known.
+func allocateID(pub ssh.PublicKey, used map[ID]bool) (ID, error) {
+	if pub == nil {
+		return newID()
+	}
+	base := keyID(pub)
+	id := base
+	for i := 2; used[id]; i++ {
+		id = ID(fmt.Sprintf("%s-%d", base, i))
+	}
+	return id, nil
+}
+
+// usedIDs returns the IDs of the configured keys.
+func (m *DefaultManager) usedIDs(ctx jsutil.AsyncContext) (map[ID]bool, error) {
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	used := map[ID]bool{}
+	for _, k := range keys {
+		used[ID(k.ID)] = true
+	}
+	return used, nil
+}
+
+// writeStoredKey writes a new configured key to storage. Keys are stored under
+// their ID, such that the same key added on multiple synced devices results in
+// a single entry.
+func (m *DefaultManager) writeStoredKey(ctx jsutil.AsyncContext, sk *storedKey) error {
+	return m.storedKeys.WriteKey(ctx, sk.ID, sk)
+}
+
+// renameKeyed moves values stored under the old IDs in renames to the new IDs.
+// idOf returns the ID field of a value.
+func renameKeyed[V any](ctx jsutil.AsyncContext, t *storage.Typed[V], renames map[ID]ID, idOf func(v *V) *string) error {
+	for oldID, newID := range renames {
+		v, err := t.ReadKey(ctx, string(oldID))
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			continue
+		}
+		*idOf(v) = string(newID)
+		if err := t.WriteKey(ctx, string(newID), v); err != nil {
+			return err
+		}
+		if err := t.Delete(ctx, func(v *V) bool { return ID(*idOf(v)) == oldID }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameValues updates the ID field of values that refer to the old IDs in
+// renames. idOf returns the ID field of a value.
+func renameValues[V any](ctx jsutil.AsyncContext, t *storage.Typed[V], renames map[ID]ID, idOf func(v *V) *string) error {
+	_, err := t.Update(ctx,
+		func(v *V) bool { _, ok := renames[ID(*idOf(v))]; return ok },
+		func(v *V) (*V, error) {
+			*idOf(v) = string(renames[ID(*idOf(v))])
+			return v, nil
+		})
+	return err
+}
+
+// migrateIDs assigns IDs derived from the public key to keys that were added
+// with random IDs, and updates any data that refers to them. Keys whose public
+// key is not yet known keep their existing IDs; they are migrated once it is
+// recorded when they are first loaded.
+//
+// The data referring to the keys is migrated first, and the keys themselves
+// last. IDs are allocated identically until the keys are renamed, so an
+// interrupted migration is completed when next attempted. Migrated keys are
+// stored under their new IDs, as with writeStoredKey, such that a key added on
+// another device replaces, rather than duplicates, the migrated entry.
+func (m *DefaultManager) migrateIDs(ctx jsutil.AsyncContext) error {
+	items, err := m.storedKeys.ReadAllItems(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read keys: %w", err)
+	}
+	if err := m.deleteStrayKeys(ctx, items); err != nil {
+		return fmt.Errorf("failed to delete stray keys: %w", err)
+	}
+	storageKeys := map[*storedKey]string{}
+	var keys []*storedKey
+	for sk, k := range items {
+		storageKeys[k] = sk
+		keys = append(keys, k)
+	}
+	// Allocate in a consistent order, such that each device assigns the
+	// same IDs to duplicate keys.
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	used := map[ID]bool{}
+	for _, k := range keys {
+		used[ID(k.ID)] = true
+	}
+	renames := map[ID]ID{}
+	renamed := map[ID]*storedKey{}
+	var oldKeys []string
+	for _, k := range keys {
+		pub := k.Public()
+		if pub == nil || isKeyID(ID(k.ID), pub) {
+			continue
+		}
+		id, err := allocateID(pub, used)
+		if err != nil {
+			return err
+		}
+		used[id] = true
+		renames[ID(k.ID)] = id
+		renamed[ID(k.ID)] = k
+		oldKeys = append(oldKeys, storageKeys[k])
+	}
+	if len(renames) == 0 {
+		return nil
+	}
+
+	if err := renameKeyed(ctx, m.tags, renames, func(v *keyTags) *string { return &v.ID }); err != nil {
+		return fmt.Errorf("failed to migrate tags: %w", err)
+	}
+	if err := renameKeyed(ctx, m.passphrases, renames, func(v *cachedPassphrase) *string { return &v.ID }); err != nil {
+		return fmt.Errorf("failed to migrate cached passphrases: %w", err)
+	}
+	if err := renameKeyed(ctx, m.lastUses, renames, func(v *lastUse) *string { return &v.ID }); err != nil {
+		return fmt.Errorf("failed to migrate last-use times: %w", err)
+	}
+	if err := renameValues(ctx, m.sessionKeys, renames, func(v *sessionKey) *string { return &v.ID }); err != nil {
+		return fmt.Errorf("failed to migrate session keys: %w", err)
+	}
+	if err := renameValues(ctx, m.auditLog, renames, func(v *AuditEntry) *string { return &v.ID }); err != nil {
+		return fmt.Errorf("failed to migrate audit log: %w", err)
+	}
+	// Each renamed key is written under both its new and existing storage
+	// keys in a single write, and the existing storage keys are then
+	// deleted. If the deletion is interrupted, the identical copies left
+	// under the existing storage keys are deleted by deleteStrayKeys.
+	updated := map[string]*storedKey{}
+	for _, sk := range oldKeys {
+		u := *items[sk]
+		u.ID = string(renames[ID(u.ID)])
+		updated[u.ID] = &u
+		updated[sk] = &u
+	}
+	if err := m.storedKeys.WriteKeys(ctx, updated); err != nil {
+		return fmt.Errorf("failed to migrate keys: %w", err)
+	}
+	if err := m.storedKeys.DeleteKeys(ctx, oldKeys); err != nil {
+		return fmt.Errorf("failed to delete migrated keys: %w", err)
+	}
+	jsutil.Log("Migrated IDs of %d keys", len(renames))
+
+	m.renameLoaded(ctx, renamed, renames)
+	return nil
+}
+
+// deleteStrayKeys deletes copies of keys left by an interrupted migration;
+// that is, keys stored under a storage key other than their ID, identical to
+// the key stored under their ID. They are removed from items.
+func (m *DefaultManager) deleteStrayKeys(ctx jsutil.AsyncContext, items map[string]*storedKey) error {
+	var stray []string
+	for sk, k := range items {
+		if sk == k.ID {
+			continue
+		}
+		if orig, ok := items[k.ID]; ok && reflect.DeepEqual(orig, k) {
+			stray = append(stray, sk)
+		}
+	}
+	if err := m.storedKeys.DeleteKeys(ctx, stray); err != nil {
+		return err
+	}
+	for _, sk := range stray {
+		delete(items, sk)
+	}
+	return nil
+}
+
+// renameEntry moves the value indexed by oldID in m, if any, to newID.
+func renameEntry[V any](m map[ID]V, oldID, newID ID) {
+	if v, ok := m[oldID]; ok {
+		delete(m, oldID)
+		m[newID] = v
+	}
+}
+
+// renameComment returns the agent comment with the ID of the loaded key
+// replaced according to renames. Other comments are returned unchanged.
+func renameComment(comment string, renames map[ID]ID) string {
+	lk := LoadedKey{Comment: comment}
+	newID, ok := renames[lk.ID()]
+	if !ok {
+		return comment
+	}
+	return commentPrefix + string(newID) + strings.TrimPrefix(comment, commentPrefix+string(lk.ID()))
+}
+
+// renameLoaded migrates the state retained for loaded keys whose IDs are
+// renamed, and replaces their identities in the agent with ones carrying the
+// new IDs. keys are the renamed keys, indexed by their old IDs. The session
+// keys must already be renamed. Failures are logged.
+func (m *DefaultManager) renameLoaded(ctx jsutil.AsyncContext, keys map[ID]*storedKey, renames map[ID]ID) {
+	m.keysMu.Lock()
+	for oldID, newID := range renames {
+		renameEntry(m.memoryKeys, oldID, newID)
+		renameEntry(m.confirmUse, oldID, newID)
+		renameEntry(m.refuseSHA1, oldID, newID)
+		renameEntry(m.destinations, oldID, newID)
+		renameEntry(m.ephemeral, oldID, newID)
+		renameEntry(m.ephemeralLifetimes, oldID, newID)
+		renameEntry(m.ranks, oldID, newID)
+	}
+	m.keysMu.Unlock()
+
+	// Keys held by managedAgent are renamed directly. Those held by the
+	// wrapped agent must be added again.
+	m.agent.renameHeld(renames)
+	loaded, err := m.Loaded(ctx)
+	if err != nil {
+		jsutil.LogError("failed to enumerate loaded keys: %v", err)
+		return
+	}
+	replaced := map[ID]bool{}
+	for _, l := range loaded {
+		oldID := l.ID()
+		newID, ok := renames[oldID]
+		if !ok || replaced[oldID] {
+			continue
+		}
+		replaced[oldID] = true
+		if err := m.renameInAgent(ctx, oldID, newID, keys[oldID].Cert()); err != nil {
+			jsutil.LogError("failed to rename loaded key ID %s to %s: %v", oldID, newID, err)
+		}
+	}
+}
+
+// renameInAgent replaces the identities of the loaded key with ID oldID in the
+// agent with ones carrying newID, using the decrypted key held in the session
+// under newID.
+func (m *DefaultManager) renameInAgent(ctx jsutil.AsyncContext, oldID, newID ID, cert *ssh.Certificate) error {
+	sk, err := m.sessionKeys.Read(ctx, func(sk *sessionKey) bool { return ID(sk.ID) == newID })
+	if err != nil {
+		return fmt.Errorf("failed to read session key: %w", err)
+	}
+	if sk == nil {
+		return fmt.Errorf("%w: no session key for key ID %s", errKeyNotFound, newID)
+	}
+	decrypted := m.sessionPrivateKey(sk)
+	defer decrypted.wipe()
+	if len(decrypted) == 0 {
+		return fmt.Errorf("%w: decrypted key ID %s not retained", errKeyNotFound, newID)
+	}
+	if _, err := m.removeFromAgent(ctx, oldID); err != nil {
+		return err
+	}
+	return m.addToAgent(newID, decrypted, sk.Comment, cert)
+}
@@ -0,0 +1,402 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAddDerivesID(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		pub := derivePublicKey(testdata.WithoutPassphrase.Private)
+		wantID := keyID(pub)
+
+		// The same key receives the same ID when added on different
+		// devices.
+		for _, device := range []string{"first", "second"} {
+			mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+			if err := mgr.Add(ctx, "key", testdata.WithoutPassphrase.Private); err != nil {
+				t.Fatalf("%s: Add failed: %v", device, err)
+			}
+			id, err := findKey(ctx, mgr, InvalidID, "key")
+			if err != nil {
+				t.Fatalf("%s: failed to find key: %v", device, err)
+			}
+			if id != wantID {
+				t.Errorf("%s: incorrect ID; got %s, want %s", device, id, wantID)
+			}
+			// The key is stored under its ID, such that the entries
+			// from each device are merged when synced.
+			if sk, err := mgr.storedKeys.ReadKey(ctx, string(id)); err != nil || sk == nil {
+				t.Errorf("%s: key not stored under its ID: %v", device, err)
+			}
+		}
+
+		// Re-adding the same key is refused, unless duplicates are
+		// explicitly allowed. Each duplicate receives a distinct ID with
+		// a numeric suffix.
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		for _, name := range []string{"key", "copy-2", "copy-3"} {
+			if err := mgr.Add(ctx, name, testdata.WithoutPassphrase.Private, AllowDuplicate()); err != nil {
+				t.Fatalf("Add(%s) failed: %v", name, err)
+			}
+		}
+		var dupErr *DuplicateKeyError
+		if err := mgr.Add(ctx, "copy-4", testdata.WithoutPassphrase.Private); !errors.As(err, &dupErr) || dupErr.ID != wantID {
+			t.Errorf("Add of duplicate returned incorrect error: %v", err)
+		}
+		for name, want := range map[string]ID{
+			"key":    wantID,
+			"copy-2": wantID + "-2",
+			"copy-3": wantID + "-3",
+		} {
+			id, err := findKey(ctx, mgr, InvalidID, name)
+			if err != nil {
+				t.Fatalf("failed to find key %s: %v", name, err)
+			}
+			if id != want {
+				t.Errorf("incorrect ID for %s; got %s, want %s", name, id, want)
+			}
+		}
+
+		// The ID for a key whose public key is not known until it is
+		// loaded is random.
+		if err := mgr.Add(ctx, "encrypted", testdata.PKCS8Format.Private); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "encrypted")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+		if pub := derivePublicKey(testdata.PKCS8FormatWithoutPassphrase.Private); isKeyID(id, pub) {
+			t.Errorf("unexpected derived ID for encrypted key: %s", id)
+		}
+	})
+}
+
+func TestMigrateIDs(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+
+		// Keys as they would be stored before IDs were derived from the
+		// public key.
+		pub := derivePublicKey(testdata.WithoutPassphrase.Private)
+		original := &storedKey{ID: "111", Name: "original", PEMPrivateKey: testdata.WithoutPassphrase.Private}
+		original.SetPublic(pub)
+		duplicate := &storedKey{ID: "222", Name: "duplicate", PEMPrivateKey: testdata.WithoutPassphrase.Private}
+		duplicate.SetPublic(pub)
+		encrypted := &storedKey{ID: "333", Name: "encrypted", PEMPrivateKey: testdata.PKCS8Format.Private}
+		if err := mgr.storedKeys.WriteAll(ctx, []*storedKey{original, duplicate, encrypted}); err != nil {
+			t.Fatalf("failed to write keys: %v", err)
+		}
+		// Data referring to the keys by ID.
+		if err := mgr.tags.WriteKey(ctx, "111", &keyTags{ID: "111", Tags: []string{"work"}}); err != nil {
+			t.Fatalf("failed to write tags: %v", err)
+		}
+		if err := mgr.passphrases.WriteKey(ctx, "222", &cachedPassphrase{ID: "222", Passphrase: "secret"}); err != nil {
+			t.Fatalf("failed to write passphrase: %v", err)
+		}
+		if err := mgr.lastUses.WriteKey(ctx, "111", &lastUse{ID: "111", Time: 1234}); err != nil {
+			t.Fatalf("failed to write last use: %v", err)
+		}
+		if err := mgr.sessionKeys.Write(ctx, &sessionKey{ID: "111", InMemory: true}); err != nil {
+			t.Fatalf("failed to write session key: %v", err)
+		}
+		if err := mgr.auditLog.Write(ctx, &AuditEntry{ID: "222"}); err != nil {
+			t.Fatalf("failed to write audit entry: %v", err)
+		}
+
+		// Migration is idempotent.
+		mgr.CleanupOldData(ctx)
+		mgr.CleanupOldData(ctx)
+
+		base := keyID(pub)
+		for name, want := range map[string]ID{
+			"original":  base,
+			"duplicate": base + "-2",
+			"encrypted": "333",
+		} {
+			id, err := findKey(ctx, mgr, InvalidID, name)
+			if err != nil {
+				t.Fatalf("failed to find key %s: %v", name, err)
+			}
+			if id != want {
+				t.Errorf("incorrect ID for %s; got %s, want %s", name, id, want)
+			}
+		}
+		configured, err := mgr.Configured(ctx)
+		if err != nil {
+			t.Fatalf("failed to get configured keys: %v", err)
+		}
+		if len(configured) != 3 {
+			t.Errorf("incorrect number of configured keys; got %d, want 3", len(configured))
+		}
+
+		tags, err := mgr.tags.ReadKey(ctx, string(base))
+		if err != nil || tags == nil || tags.ID != string(base) {
+			t.Errorf("tags not migrated: %+v, %v", tags, err)
+		}
+		cp, err := mgr.passphrases.ReadKey(ctx, string(base+"-2"))
+		if err != nil || cp == nil || cp.ID != string(base+"-2") {
+			t.Errorf("cached passphrase not migrated: %+v, %v", cp, err)
+		}
+		lu, err := mgr.lastUses.ReadKey(ctx, string(base))
+		if err != nil || lu == nil || lu.ID != string(base) {
+			t.Errorf("last use not migrated: %+v, %v", lu, err)
+		}
+		if old, err := mgr.lastUses.ReadKey(ctx, "111"); err != nil || old != nil {
+			t.Errorf("old last use not removed: %+v, %v", old, err)
+		}
+
+		sks, err := mgr.sessionKeys.ReadAll(ctx)
+		if err != nil {
+			t.Fatalf("failed to read session keys: %v", err)
+		}
+		var sessionIDs []string
+		for _, sk := range sks {
+			sessionIDs = append(sessionIDs, sk.ID)
+		}
+		if diff := cmp.Diff(sessionIDs, []string{string(base)}); diff != "" {
+			t.Errorf("incorrect session key IDs; -got +want: %s", diff)
+		}
+		entries, err := mgr.AuditLog(ctx)
+		if err != nil {
+			t.Fatalf("failed to read audit log: %v", err)
+		}
+		var auditIDs []string
+		for _, e := range entries {
+			auditIDs = append(auditIDs, e.ID)
+		}
+		if diff := cmp.Diff(auditIDs, []string{string(base + "-2")}); diff != "" {
+			t.Errorf("incorrect audit log IDs; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestMigrateIDsInterrupted(t *testing.T) {
+	t.Parallel()
+
+	pub := derivePublicKey(testdata.WithoutPassphrase.Private)
+	base := keyID(pub)
+
+	// Interrupt each of the writes made by the migration in turn, to
+	// either area.
+	for _, tc := range []struct {
+		area string
+		n    int
+	}{
+		{"sync", 1}, {"sync", 2}, {"sync", 3}, {"sync", 4}, {"sync", 5},
+		{"session", 1}, {"session", 2}, {"session", 3},
+	} {
+		tc := tc
+		t.Run(fmt.Sprintf("%s-write-%d", tc.area, tc.n), func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				syncArea := st.NewQuotaMemArea(0, 0, 0)
+				sessionArea := st.NewQuotaMemArea(0, 0, 0)
+				// Failed writes are not retried, such that the
+				// migration is interrupted.
+				noRetry := storage.RetryPolicy{}
+				mgr := NewManager(agent.NewKeyring(), storage.NewRawWithRetryPolicy(syncArea, noRetry), storage.NewRawWithRetryPolicy(sessionArea, noRetry))
+
+				original := &storedKey{ID: "111", Name: "original", PEMPrivateKey: testdata.WithoutPassphrase.Private}
+				original.SetPublic(pub)
+				duplicate := &storedKey{ID: "222", Name: "duplicate", PEMPrivateKey: testdata.WithoutPassphrase.Private}
+				duplicate.SetPublic(pub)
+				if err := mgr.storedKeys.WriteAll(ctx, []*storedKey{original, duplicate}); err != nil {
+					t.Fatalf("failed to write keys: %v", err)
+				}
+				if err := mgr.tags.WriteKey(ctx, "111", &keyTags{ID: "111", Tags: []string{"work"}}); err != nil {
+					t.Fatalf("failed to write tags: %v", err)
+				}
+				if err := mgr.sessionKeys.Write(ctx, &sessionKey{ID: "222", InMemory: true}); err != nil {
+					t.Fatalf("failed to write session key: %v", err)
+				}
+
+				interrupted := map[string]js.Value{"sync": syncArea, "session": sessionArea}[tc.area]
+				st.FailNthWrite(interrupted, tc.n, "interrupted")
+				mgr.CleanupOldData(ctx)
+
+				// No key is lost.
+				configuredNames := func() []string {
+					configured, err := mgr.Configured(ctx)
+					if err != nil {
+						t.Fatalf("failed to get configured keys: %v", err)
+					}
+					var names []string
+					for _, k := range configured {
+						names = append(names, k.Name)
+					}
+					sort.Strings(names)
+					return names
+				}
+				if diff := cmp.Diff(slices.Compact(configuredNames()), []string{"duplicate", "original"}); diff != "" {
+					t.Errorf("incorrect configured keys after interruption; -got +want: %s", diff)
+				}
+
+				// The migration is completed when next attempted,
+				// and each key is then configured exactly once.
+				mgr.CleanupOldData(ctx)
+				if diff := cmp.Diff(configuredNames(), []string{"duplicate", "original"}); diff != "" {
+					t.Errorf("incorrect configured keys after retry; -got +want: %s", diff)
+				}
+				for name, want := range map[string]ID{
+					"original":  base,
+					"duplicate": base + "-2",
+				} {
+					id, err := findKey(ctx, mgr, InvalidID, name)
+					if err != nil {
+						t.Fatalf("failed to find key %s: %v", name, err)
+					}
+					if id != want {
+						t.Errorf("incorrect ID for %s; got %s, want %s", name, id, want)
+					}
+				}
+				tags, err := mgr.readTags(ctx)
+				if err != nil {
+					t.Fatalf("failed to read tags: %v", err)
+				}
+				if diff := cmp.Diff(tags, map[ID][]string{base: {"work"}}); diff != "" {
+					t.Errorf("incorrect tags; -got +want: %s", diff)
+				}
+				sks, err := mgr.sessionKeys.ReadAll(ctx)
+				if err != nil {
+					t.Fatalf("failed to read session keys: %v", err)
+				}
+				if len(sks) != 1 || sks[0].ID != string(base+"-2") {
+					t.Errorf("session key not migrated: %+v", sks)
+				}
+			})
+		})
+	}
+}
+
+func TestMigrateIDsAddedOnOtherDevice(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		mgr := NewManager(agent.NewKeyring(), syncStorage, storage.NewRaw(st.NewMemArea()))
+
+		pub := derivePublicKey(testdata.WithoutPassphrase.Private)
+		legacy := &storedKey{ID: "111", Name: "legacy", PEMPrivateKey: testdata.WithoutPassphrase.Private}
+		legacy.SetPublic(pub)
+		if err := mgr.storedKeys.Write(ctx, legacy); err != nil {
+			t.Fatalf("failed to write key: %v", err)
+		}
+		mgr.CleanupOldData(ctx)
+
+		// The migrated key is stored under its new ID.
+		base := keyID(pub)
+		if sk, err := mgr.storedKeys.ReadKey(ctx, string(base)); err != nil || sk == nil {
+			t.Errorf("migrated key not stored under its ID: %v", err)
+		}
+
+		// Another device that has not yet synced the migrated key adds
+		// the same key, which is stored under the same ID.
+		other := NewManager(agent.NewKeyring(), syncStorage, storage.NewRaw(st.NewMemArea()))
+		added := &storedKey{ID: string(base), Name: "other device", PEMPrivateKey: testdata.WithoutPassphrase.Private}
+		added.SetPublic(pub)
+		if err := other.writeStoredKey(ctx, added); err != nil {
+			t.Fatalf("failed to write key: %v", err)
+		}
+
+		configured, err := mgr.Configured(ctx)
+		if err != nil {
+			t.Fatalf("failed to get configured keys: %v", err)
+		}
+		var got []string
+		for _, k := range configured {
+			got = append(got, string(k.ID)+" "+k.Name)
+		}
+		if diff := cmp.Diff(got, []string{string(base) + " other device"}); diff != "" {
+			t.Errorf("incorrect configured keys; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestMigrateIDsLoaded(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		if err := mgr.SetPersistSession(ctx, false); err != nil {
+			t.Fatalf("SetPersistSession failed: %v", err)
+		}
+
+		pub := derivePublicKey(testdata.WithoutPassphrase.Private)
+		legacy := &storedKey{ID: "111", Name: "legacy", PEMPrivateKey: testdata.WithoutPassphrase.Private, ConfirmUse: true}
+		legacy.SetPublic(pub)
+		if err := mgr.storedKeys.Write(ctx, legacy); err != nil {
+			t.Fatalf("failed to write key: %v", err)
+		}
+		if err := mgr.Load(ctx, "111", ""); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+
+		mgr.CleanupOldData(ctx)
+
+		base := keyID(pub)
+		loaded, err := mgr.Loaded(ctx)
+		if err != nil {
+			t.Fatalf("failed to enumerate loaded keys: %v", err)
+		}
+		var loadedIDs []ID
+		for _, l := range loaded {
+			loadedIDs = append(loadedIDs, l.ID())
+		}
+		if diff := cmp.Diff(loadedIDs, []ID{base}); diff != "" {
+			t.Errorf("incorrect loaded key IDs; -got +want: %s", diff)
+		}
+
+		mgr.keysMu.Lock()
+		_, confirm := mgr.confirmUse[base]
+		_, rank := mgr.ranks[base]
+		retained := len(mgr.memoryKeys[base]) > 0
+		_, stale := mgr.memoryKeys["111"]
+		mgr.keysMu.Unlock()
+		if !confirm || !rank || !retained || stale {
+			t.Errorf("loaded state not migrated: confirm=%t rank=%t retained=%t stale=%t", confirm, rank, retained, stale)
+		}
+
+		// The key can still be unloaded using its new ID.
+		if err := mgr.Unload(ctx, base); err != nil {
+			t.Errorf("Unload failed: %v", err)
+		}
+		if loaded, err := mgr.Loaded(ctx); err != nil || len(loaded) != 0 {
+			t.Errorf("key not unloaded: %d keys, %v", len(loaded), err)
+		}
+	})
+}
@@ -0,0 +1,236 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// errMultipleKeys indicates that a single key was expected, but the input
+// contains several.
+var errMultipleKeys = errors.New("multiple keys provided")
+
+// ImportResult is the outcome of importing one of the keys supplied to
+// Import.
+type ImportResult struct {
+	// Name is the name assigned to the key.
+	Name string `js:"name"`
+	// Err describes why the key could not be added, or is empty if it was
+	// added.
+	Err string `js:"err"`
+}
+
+// splitKeys splits text into the keys that it contains. Each key starts at the
+// first line of a PEM block or PuTTY key file, and includes any following
+// lines (such as a certificate) up to the start of the next key. Any lines
+// before the first key are included with it.
+func splitKeys(text string) []string {
+	var keys []string
+	var cur strings.Builder
+	started := false
+	for _, line := range strings.SplitAfter(text, "\n") {
+		isStart := strings.HasPrefix(line, "-----BEGIN ") ||
+			strings.HasPrefix(line, ppkHeaderV2+":") ||
+			strings.HasPrefix(line, ppkHeaderV3+":")
+		if isStart && started {
+			keys = append(keys, cur.String())
+			cur.Reset()
+		}
+		started = started || isStart
+		cur.WriteString(line)
+	}
+	if cur.Len() > 0 {
+		keys = append(keys, cur.String())
+	}
+	return keys
+}
+
+// opensshKeyFields is the number of fields that follow the key type in the
+// private section of an OpenSSH private key, for each key type. See
+// PROTOCOL.key in the OpenSSH sources.
+var opensshKeyFields = map[string]int{
+	ssh.KeyAlgoRSA:      6, // n, e, d, iqmp, p, q
+	ssh.KeyAlgoDSA:      5, // p, q, g, y, x
+	ssh.KeyAlgoECDSA256: 3, // curve, public, private
+	ssh.KeyAlgoECDSA384: 3,
+	ssh.KeyAlgoECDSA521: 3,
+	ssh.KeyAlgoED25519:  2, // public, private
+}
+
+// skipString returns data following the SSH wire format string at the start
+// of data, or nil if data does not start with a string.
+func skipString(data []byte) []byte {
+	if len(data) < 4 {
+		return nil
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint64(len(data)-4) < uint64(n) {
+		return nil
+	}
+	return data[4+n:]
+}
+
+// opensshKey is the outer structure of a private key in the OpenSSH format.
+// See PROTOCOL.key in the OpenSSH sources.
+type opensshKey struct {
+	CipherName   string
+	KdfName      string
+	KdfOpts      string
+	NumKeys      uint32
+	PubKey       []byte
+	PrivKeyBlock []byte
+	Rest         []byte `ssh:"rest"`
+}
+
+// parseOpenSSHKey parses the outer structure of a private key in the OpenSSH
+// format, or returns nil if it is in another format. The private section
+// remains encrypted if the key is.
+func parseOpenSSHKey(privateKey string) *opensshKey {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+		return nil
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(opensshKeyMagic)) {
+		return nil
+	}
+	var w opensshKey
+	if err := ssh.Unmarshal(block.Bytes[len(opensshKeyMagic):], &w); err != nil {
+		return nil
+	}
+	return &w
+}
+
+// isDSAKey determines if the private key is a DSA key. pub is the public key
+// if known. The type of encrypted keys in the PKCS#8 format cannot be
+// determined without the passphrase, and they are not identified.
+func isDSAKey(privateKey string, pub ssh.PublicKey) bool {
+	if pub != nil {
+		return pub.Type() == ssh.KeyAlgoDSA
+	}
+	if detectFormat(privateKey) == FormatDSA {
+		return true
+	}
+	// The Go SSH library does not parse DSA keys in the OpenSSH format,
+	// but the public key is included in the clear.
+	if w := parseOpenSSHKey(privateKey); w != nil {
+		if p, err := ssh.ParsePublicKey(w.PubKey); err == nil {
+			return p.Type() == ssh.KeyAlgoDSA
+		}
+	}
+	return false
+}
+
+// keyComment returns the comment embedded in the private key, if it can be
+// determined without the passphrase. This is the case for PuTTY key files and
+// unencrypted keys in the OpenSSH format.
+func keyComment(privateKey string) string {
+	if isPPK(privateKey) {
+		f, err := parsePPK(privateKey)
+		if err != nil {
+			return ""
+		}
+		return f.Comment
+	}
+
+	w := parseOpenSSHKey(privateKey)
+	if w == nil || w.CipherName != "none" || w.NumKeys != 1 {
+		return ""
+	}
+	var pk struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Rest    []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(w.PrivKeyBlock, &pk); err != nil {
+		return ""
+	}
+	n, ok := opensshKeyFields[pk.Keytype]
+	if !ok {
+		return ""
+	}
+	rest := pk.Rest
+	for i := 0; i < n && rest != nil; i++ {
+		rest = skipString(rest)
+	}
+	if rest == nil {
+		return ""
+	}
+	var c struct {
+		Comment string
+		Rest    []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(rest, &c); err != nil {
+		return ""
+	}
+	return c.Comment
+}
+
+// uniqueName returns name, or name with a numeric suffix if it is already
+// used.
+func uniqueName(name string, used map[string]bool) string {
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+	return unique
+}
+
+// Import implements Manager.Import.
+func (m *DefaultManager) Import(ctx jsutil.AsyncContext, name string, text string, opts ...AddOption) ([]*ImportResult, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: name must not be empty", errInvalidName)
+	}
+
+	existing, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	used := map[string]bool{}
+	for _, k := range existing {
+		used[k.Name] = true
+	}
+
+	keys := splitKeys(normalizeKeyText(text))
+	if len(keys) == 0 {
+		return nil, diagnoseKeyText(text)
+	}
+	var results []*ImportResult
+	for _, key := range keys {
+		// Keys are named using their embedded comments when several
+		// are imported, such that they can be told apart.
+		base := name
+		if c := keyComment(key); c != "" && len(keys) > 1 {
+			base = c
+		}
+		r := &ImportResult{Name: uniqueName(base, used)}
+		used[r.Name] = true
+		if err := m.Add(ctx, r.Name, key, opts...); err != nil {
+			r.Err = err.Error()
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
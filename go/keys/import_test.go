@@ -0,0 +1,221 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const publicKeyPEM = `-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAGb9ECWmEzf6FQbrBZ9w7lshQhqowtrbLDFw4rXAxZuE=
+-----END PUBLIC KEY-----
+`
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description    string
+		initial        []*initialKey
+		text           string
+		want           []*ImportResult
+		wantFailed     []bool
+		wantConfigured []string
+		wantErr        error
+	}{
+		{
+			description:    "single key",
+			text:           testdata.WithoutPassphrase.Private,
+			want:           []*ImportResult{{Name: "import"}},
+			wantFailed:     []bool{false},
+			wantConfigured: []string{"import"},
+		},
+		{
+			description: "names from comments",
+			text:        testdata.OpenSSHFormatWithoutPassphrase.Private + "\n" + testdata.OpenSSHFormatWithoutPassphrase.Private,
+			want: []*ImportResult{
+				{Name: "richard_alimi_gmail_com@workstation"},
+				{Name: "richard_alimi_gmail_com@workstation-2"},
+			},
+			// The second is a duplicate of the first.
+			wantFailed:     []bool{false, true},
+			wantConfigured: []string{"richard_alimi_gmail_com@workstation"},
+		},
+		{
+			description: "names without comments",
+			initial: []*initialKey{
+				{Name: "import", PEMPrivateKey: testdata.WithPassphrase.Private},
+			},
+			text: testdata.WithoutPassphrase.Private + "\n" + testdata.ECDSAWithoutPassphrase.Private,
+			want: []*ImportResult{
+				{Name: "import-2"},
+				{Name: "import-3"},
+			},
+			wantFailed:     []bool{false, false},
+			wantConfigured: []string{"import", "import-2", "import-3"},
+		},
+		{
+			description: "bad block does not abort others",
+			text:        publicKeyPEM + testdata.WithoutPassphrase.Private,
+			want: []*ImportResult{
+				{Name: "import"},
+				{Name: "import-2"},
+			},
+			wantFailed:     []bool{true, false},
+			wantConfigured: []string{"import-2"},
+		},
+		{
+			description: "duplicate of configured key",
+			initial: []*initialKey{
+				{Name: "existing", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+			},
+			text: testdata.WithoutPassphrase.Private + testdata.ECDSAWithoutPassphrase.Private,
+			want: []*ImportResult{
+				{Name: "import"},
+				{Name: "import-2"},
+			},
+			wantFailed:     []bool{true, false},
+			wantConfigured: []string{"existing", "import-2"},
+		},
+		{
+			description:    "empty",
+			text:           "\r\n",
+			wantConfigured: nil,
+			wantErr:        errDecodeFailed,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), tc.initial)
+				if err != nil {
+					t.Errorf("failed to initialize manager: %v", err)
+					return
+				}
+
+				results, err := mgr.Import(ctx, "import", tc.text)
+				if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("incorrect error; -got +want: %s", diff)
+				}
+				if diff := cmp.Diff(results, tc.want, cmpopts.IgnoreFields(ImportResult{}, "Err")); diff != "" {
+					t.Errorf("incorrect results; -got +want: %s", diff)
+				}
+				var failed []bool
+				for _, r := range results {
+					failed = append(failed, r.Err != "")
+				}
+				if diff := cmp.Diff(failed, tc.wantFailed); diff != "" {
+					t.Errorf("incorrect failures; -got +want: %s", diff)
+				}
+
+				configured, err := mgr.Configured(ctx)
+				if err != nil {
+					t.Errorf("failed to get configured keys: %v", err)
+					return
+				}
+				if diff := cmp.Diff(configuredKeyNames(configured), tc.wantConfigured); diff != "" {
+					t.Errorf("incorrect configured keys; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestAddRejectsMultipleKeys(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		text := strings.Join([]string{testdata.WithoutPassphrase.Private, testdata.ECDSAWithoutPassphrase.Private}, "\n")
+		err := mgr.Add(ctx, "key", text)
+		if diff := cmp.Diff(err, errMultipleKeys, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestKeyComment(t *testing.T) {
+	t.Parallel()
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(ed25519Key, "ed25519-comment")
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	testcases := []struct {
+		description string
+		key         string
+		want        string
+	}{
+		{
+			description: "unencrypted OpenSSH key",
+			key:         testdata.OpenSSHFormatWithoutPassphrase.Private,
+			want:        "richard_alimi_gmail_com@workstation",
+		},
+		{
+			description: "unencrypted OpenSSH Ed25519 key",
+			key:         string(pem.EncodeToMemory(block)),
+			want:        "ed25519-comment",
+		},
+		{
+			description: "encrypted OpenSSH key",
+			key:         testdata.OpenSSHFormat.Private,
+		},
+		{
+			description: "PKCS#1 key",
+			key:         testdata.WithoutPassphrase.Private,
+		},
+		{
+			description: "PuTTY key file",
+			key:         encodePPK(ed25519Key, "putty-comment", ppkOptions{Version: 3}),
+			want:        "putty-comment",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			if diff := cmp.Diff(keyComment(tc.key), tc.want); diff != "" {
+				t.Errorf("incorrect comment; -got +want: %s", diff)
+			}
+		})
+	}
+}
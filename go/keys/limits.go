@@ -0,0 +1,249 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// SuggestedMaxLoadedKeys is a suggested limit on the number of loaded
+	// identities. Many servers disconnect after six failed authentication
+	// attempts, so clients may be unable to try further keys.
+	SuggestedMaxLoadedKeys = 5
+)
+
+// ErrTooManyLoadedKeys indicates that a key cannot be loaded, since the limit
+// set using SetMaxLoadedKeys has been reached. Errors wrapping it are of type
+// *TooManyLoadedKeysError, which identifies the loaded keys. Its identity is
+// preserved by the client returned by NewClient.
+var ErrTooManyLoadedKeys = errors.New("too many keys loaded")
+
+var (
+	errInvalidMaxLoadedKeys = errors.New("invalid limit on loaded keys")
+	errInvalidKeyOrder      = errors.New("invalid key order")
+)
+
+// TooManyLoadedKeysError is returned when loading a key would exceed the limit
+// on loaded identities.
+type TooManyLoadedKeysError struct {
+	// Max is the maximum number of loaded identities.
+	Max int
+	// Loaded describes the identities that are currently loaded.
+	Loaded []string
+	// ids are the IDs of the identities in Loaded, or InvalidID for those
+	// not loaded by the Manager.
+	ids []ID
+}
+
+func (e *TooManyLoadedKeysError) Error() string {
+	return fmt.Sprintf("%v: at most %d may be loaded; unload one of %s", ErrTooManyLoadedKeys, e.Max, strings.Join(e.Loaded, ", "))
+}
+
+// Is allows the error to be compared against ErrTooManyLoadedKeys.
+func (e *TooManyLoadedKeysError) Is(target error) bool {
+	return target == ErrTooManyLoadedKeys
+}
+
+// KeyOrder is the order in which loaded identities are offered to clients.
+type KeyOrder string
+
+const (
+	// KeyOrderPriority offers identities with the highest priority
+	// first, and those with equal priority by name; see SetPriority.
+	KeyOrderPriority KeyOrder = "priority"
+	// KeyOrderRecent offers identities with the highest priority first,
+	// and those with equal priority with the most recently used first.
+	// Those that have not been used follow by name.
+	KeyOrderRecent KeyOrder = "recent"
+)
+
+// describeIdentity returns a description of a loaded identity, for use in
+// errors, and its ID. Keys loaded by the Manager are described by their
+// comment, or their ID if they have none; see also describeLoaded.
+func describeIdentity(k *agent.Key) (string, ID) {
+	lk := LoadedKey{Comment: k.Comment}
+	id := lk.ID()
+	if id == InvalidID {
+		return fmt.Sprintf("%q", k.Comment), id
+	}
+	comment := strings.TrimSpace(strings.TrimPrefix(k.Comment, agentComment(id, "")))
+	if comment == "" {
+		return fmt.Sprintf("key ID %s", id), id
+	}
+	return fmt.Sprintf("%q", comment), id
+}
+
+// describeLoaded replaces the descriptions of loaded keys in a
+// *TooManyLoadedKeysError with their configured names, which the agent does
+// not know. Other errors are returned unchanged.
+func (m *DefaultManager) describeLoaded(ctx jsutil.AsyncContext, err error) error {
+	var tooMany *TooManyLoadedKeysError
+	if !errors.As(err, &tooMany) {
+		return err
+	}
+	keys, rerr := m.storedKeys.ReadAll(ctx)
+	if rerr != nil {
+		jsutil.LogError("failed to read keys: %v", rerr)
+		return err
+	}
+	names := map[ID]string{}
+	for _, k := range keys {
+		names[ID(k.ID)] = k.Name
+	}
+	for i, id := range tooMany.ids {
+		if name, ok := names[id]; ok {
+			tooMany.Loaded[i] = fmt.Sprintf("%q", name)
+		}
+	}
+	return err
+}
+
+// checkCapacity returns an error wrapping ErrTooManyLoadedKeys if loading the
+// specified identities would exceed the limit. Identities that are already
+// loaded are replaced when loaded again, so they do not count against it.
+func (a *managedAgent) checkCapacity(pubs ...ssh.PublicKey) error {
+	a.mu.Lock()
+	max := a.maxLoaded
+	a.mu.Unlock()
+	if max == 0 {
+		return nil
+	}
+
+	keys, err := a.List()
+	if err != nil {
+		return fmt.Errorf("failed to list loaded keys: %w", err)
+	}
+	loaded := map[string]bool{}
+	for _, k := range keys {
+		loaded[string(k.Blob)] = true
+	}
+	n := len(keys)
+	for _, pub := range pubs {
+		if !loaded[string(pub.Marshal())] {
+			n++
+		}
+	}
+	if n <= max {
+		return nil
+	}
+	e := &TooManyLoadedKeysError{Max: max}
+	for _, k := range keys {
+		desc, id := describeIdentity(k)
+		e.Loaded = append(e.Loaded, desc)
+		e.ids = append(e.ids, id)
+	}
+	return e
+}
+
+// recordSigned records that the key was used to sign, such that it may be
+// offered first.
+func (a *managedAgent) recordSigned(key ssh.PublicKey) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lastUsed == nil {
+		a.lastUsed = map[string]uint64{}
+	}
+	a.useSeq++
+	a.lastUsed[string(key.Marshal())] = a.useSeq
+}
+
+// setLimits applies the limit on loaded identities, and the order in which
+// they are offered.
+func (a *managedAgent) setLimits(maxLoaded int, order KeyOrder) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.maxLoaded = maxLoaded
+	a.order = order
+}
+
+// restoreLimits applies the stored limit on loaded identities, and the order
+// in which they are offered.
+func (m *DefaultManager) restoreLimits(ctx jsutil.AsyncContext) error {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return fmt.Errorf("failed to read settings: %w", err)
+	}
+	if s == nil {
+		s = &managerSettings{}
+	}
+	m.agent.setLimits(s.MaxLoadedKeys, keyOrder(s))
+	return nil
+}
+
+// keyOrder returns the order in which loaded identities are offered.
+func keyOrder(s *managerSettings) KeyOrder {
+	if s == nil || s.KeyOrder == "" {
+		return KeyOrderPriority
+	}
+	return KeyOrder(s.KeyOrder)
+}
+
+// MaxLoadedKeys implements Manager.MaxLoadedKeys.
+func (m *DefaultManager) MaxLoadedKeys(ctx jsutil.AsyncContext) (int, error) {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read settings: %w", err)
+	}
+	if s == nil {
+		return 0, nil
+	}
+	return s.MaxLoadedKeys, nil
+}
+
+// SetMaxLoadedKeys implements Manager.SetMaxLoadedKeys.
+func (m *DefaultManager) SetMaxLoadedKeys(ctx jsutil.AsyncContext, max int) error {
+	if max < 0 {
+		return fmt.Errorf("%w: must not be negative: %d", errInvalidMaxLoadedKeys, max)
+	}
+	if err := m.updateSettings(ctx, func(s *managerSettings) {
+		s.MaxLoadedKeys = max
+	}); err != nil {
+		return err
+	}
+	return m.restoreLimits(ctx)
+}
+
+// KeyOrder implements Manager.KeyOrder.
+func (m *DefaultManager) KeyOrder(ctx jsutil.AsyncContext) (KeyOrder, error) {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read settings: %w", err)
+	}
+	return keyOrder(s), nil
+}
+
+// SetKeyOrder implements Manager.SetKeyOrder.
+func (m *DefaultManager) SetKeyOrder(ctx jsutil.AsyncContext, order KeyOrder) error {
+	switch order {
+	case KeyOrderPriority, KeyOrderRecent:
+	default:
+		return fmt.Errorf("%w: %s", errInvalidKeyOrder, order)
+	}
+	if err := m.updateSettings(ctx, func(s *managerSettings) {
+		s.KeyOrder = string(order)
+	}); err != nil {
+		return err
+	}
+	return m.restoreLimits(ctx)
+}
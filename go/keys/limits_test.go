@@ -0,0 +1,183 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestMaxLoadedKeys(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "first", PEMPrivateKey: testdata.WithoutPassphrase.Private, Load: true},
+			{Name: "second", PEMPrivateKey: testdata.ED25519WithoutPassphrase.Private, Load: true},
+			{Name: "third", PEMPrivateKey: testdata.ECDSAWithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		ids := map[string]ID{}
+		for _, name := range []string{"first", "second", "third"} {
+			if ids[name], err = findKey(ctx, mgr, InvalidID, name); err != nil {
+				t.Fatalf("failed to find key: %v", err)
+			}
+		}
+
+		if max, err := mgr.MaxLoadedKeys(ctx); err != nil || max != 0 {
+			t.Errorf("incorrect default limit; got %d (err=%v), want 0", max, err)
+		}
+		if err := mgr.SetMaxLoadedKeys(ctx, -1); !errors.Is(err, errInvalidMaxLoadedKeys) {
+			t.Errorf("SetMaxLoadedKeys returned incorrect error for negative limit: %v", err)
+		}
+		if err := mgr.SetMaxLoadedKeys(ctx, 2); err != nil {
+			t.Fatalf("SetMaxLoadedKeys failed: %v", err)
+		}
+		if max, err := mgr.MaxLoadedKeys(ctx); err != nil || max != 2 {
+			t.Errorf("incorrect limit; got %d (err=%v), want 2", max, err)
+		}
+
+		// Loading beyond the limit fails, naming the loaded keys.
+		err = mgr.Load(ctx, ids["third"], "")
+		if !errors.Is(err, ErrTooManyLoadedKeys) {
+			t.Errorf("Load returned incorrect error beyond limit: %v", err)
+		}
+		var tooMany *TooManyLoadedKeysError
+		if errors.As(err, &tooMany) {
+			if diff := cmp.Diff(tooMany.Loaded, []string{`"first"`, `"second"`}); diff != "" {
+				t.Errorf("incorrect loaded keys in error; -got +want: %s", diff)
+			}
+		}
+
+		// Reloading a key that is already loaded is permitted.
+		if err := mgr.Load(ctx, ids["first"], ""); err != nil {
+			t.Errorf("failed to reload key at limit: %v", err)
+		}
+
+		// The limit also applies to keys added by clients of the agent.
+		priv, err := ssh.ParseRawPrivateKey([]byte(testdata.OpenSSHFormatWithoutPassphrase.Private))
+		if err != nil {
+			t.Fatalf("failed to parse private key: %v", err)
+		}
+		if err := mgr.Agent().Add(agent.AddedKey{PrivateKey: priv, Comment: "external"}); !errors.Is(err, ErrTooManyLoadedKeys) {
+			t.Errorf("Add returned incorrect error beyond limit: %v", err)
+		}
+
+		// Unloading a key makes room for another.
+		if err := mgr.Unload(ctx, ids["second"]); err != nil {
+			t.Fatalf("Unload failed: %v", err)
+		}
+		if err := mgr.Load(ctx, ids["third"], ""); err != nil {
+			t.Errorf("failed to load key within limit: %v", err)
+		}
+
+		// Removing the limit permits loading further keys.
+		if err := mgr.SetMaxLoadedKeys(ctx, 0); err != nil {
+			t.Fatalf("SetMaxLoadedKeys failed: %v", err)
+		}
+		if err := mgr.Load(ctx, ids["second"], ""); err != nil {
+			t.Errorf("failed to load key without limit: %v", err)
+		}
+	})
+}
+
+func TestKeyOrder(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "first", PEMPrivateKey: testdata.WithoutPassphrase.Private, Load: true},
+			{Name: "second", PEMPrivateKey: testdata.ED25519WithoutPassphrase.Private, Load: true},
+			{Name: "third", PEMPrivateKey: testdata.ECDSAWithoutPassphrase.Private, Load: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		ids := map[string]ID{}
+		for _, name := range []string{"first", "second", "third"} {
+			if ids[name], err = findKey(ctx, mgr, InvalidID, name); err != nil {
+				t.Fatalf("failed to find key: %v", err)
+			}
+		}
+
+		listed := func() []ID {
+			keys, err := mgr.Agent().List()
+			if err != nil {
+				t.Fatalf("failed to list keys: %v", err)
+			}
+			var result []ID
+			for _, k := range keys {
+				result = append(result, (&LoadedKey{Comment: k.Comment}).ID())
+			}
+			return result
+		}
+		sign := func(id ID) {
+			keys, err := mgr.Agent().List()
+			if err != nil {
+				t.Fatalf("failed to list keys: %v", err)
+			}
+			for _, k := range keys {
+				if (&LoadedKey{Comment: k.Comment}).ID() != id {
+					continue
+				}
+				if _, err := mgr.Agent().SignWithFlags(k, []byte("data"), agent.SignatureFlagRsaSha256); err != nil {
+					t.Fatalf("failed to sign: %v", err)
+				}
+			}
+		}
+
+		if order, err := mgr.KeyOrder(ctx); err != nil || order != KeyOrderPriority {
+			t.Errorf("incorrect default order; got %s (err=%v), want %s", order, err, KeyOrderPriority)
+		}
+		if err := mgr.SetKeyOrder(ctx, "bogus"); !errors.Is(err, errInvalidKeyOrder) {
+			t.Errorf("SetKeyOrder returned incorrect error for invalid order: %v", err)
+		}
+
+		// By default, keys of equal priority are listed by name.
+		sign(ids["third"])
+		if diff := cmp.Diff(listed(), []ID{ids["first"], ids["second"], ids["third"]}); diff != "" {
+			t.Errorf("incorrect order; -got +want: %s", diff)
+		}
+
+		// The most recently used keys are listed first. Those that have
+		// not been used follow by name.
+		if err := mgr.SetKeyOrder(ctx, KeyOrderRecent); err != nil {
+			t.Fatalf("SetKeyOrder failed: %v", err)
+		}
+		if diff := cmp.Diff(listed(), []ID{ids["third"], ids["first"], ids["second"]}); diff != "" {
+			t.Errorf("incorrect order; -got +want: %s", diff)
+		}
+		sign(ids["second"])
+		if diff := cmp.Diff(listed(), []ID{ids["second"], ids["third"], ids["first"]}); diff != "" {
+			t.Errorf("incorrect order; -got +want: %s", diff)
+		}
+		if order, err := mgr.KeyOrder(ctx); err != nil || order != KeyOrderRecent {
+			t.Errorf("incorrect order; got %s (err=%v), want %s", order, err, KeyOrderRecent)
+		}
+	})
+}
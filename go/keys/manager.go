@@ -19,8 +19,10 @@
 package keys
 
 import (
+	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
@@ -28,9 +30,15 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"syscall/js"
+	"time"
 
 	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/keepalive"
 	"github.com/google/chrome-ssh-agent/go/storage"
 	"github.com/youmark/pkcs8"
 	"golang.org/x/crypto/ssh"
@@ -54,17 +62,154 @@ type ConfiguredKey struct {
 	// Encrypted indicates if the key is encrypted and requires a passphrase
 	// to load.
 	Encrypted bool `js:"encrypted"`
+	// Format is the format in which the private key is stored; see
+	// FormatOpenSSH and related constants.
+	Format string `js:"format"`
+	// Comment is the comment embedded in the private key, if it was set
+	// using SetComment.
+	Comment string `js:"comment"`
+	// Type is the algorithm of the key (e.g., 'ssh-ed25519'). It is empty
+	// if the public key is not known; this is the case for some encrypted
+	// keys until they are first loaded.
+	Type string `js:"type"`
+	// Bits is the size of the key in bits, or zero if the public key is not
+	// known.
+	Bits int `js:"bits"`
+	// FingerprintSHA256 is the SHA256 fingerprint of the public key, in
+	// the format used by ssh-keygen. It is empty if the public key is not
+	// known.
+	FingerprintSHA256 string `js:"fingerprintSHA256"`
+	// FingerprintMD5 is the legacy MD5 fingerprint of the public key, in
+	// the format used by ssh-keygen. It is empty if the public key is not
+	// known.
+	FingerprintMD5 string `js:"fingerprintMD5"`
+	// AutoLoad indicates that the key is loaded automatically when the
+	// agent starts.
+	AutoLoad bool `js:"autoLoad"`
+	// IdleTimeoutMillis is the period in milliseconds after which the key
+	// is unloaded if it is not used; see IdleTimeout.
+	IdleTimeoutMillis int64 `js:"idleTimeoutMillis"`
+	// OverrideIdleTimeout indicates that the idle timeout was set for the
+	// key using SetIdleTimeout, rather than being the default.
+	OverrideIdleTimeout bool `js:"overrideIdleTimeout"`
+	// ConfirmUse indicates that the user must approve each use of the key
+	// to sign.
+	ConfirmUse bool `js:"confirmUse"`
+	// RefuseSHA1 indicates that the agent refuses to produce ssh-rsa
+	// signatures, which use SHA-1, with the key. Only rsa-sha2-256 and
+	// rsa-sha2-512 signatures are produced. It only affects RSA keys.
+	RefuseSHA1 bool `js:"refuseSHA1"`
+	// Destinations are the hosts for which the key may sign, in the
+	// known_hosts format; see SetDestinations. The key may sign for any
+	// host if empty.
+	Destinations []string `js:"destinations"`
+	// RequireSessionBind indicates that the key may not sign over
+	// connections for which the client did not identify the host. It only
+	// applies if Destinations is not empty.
+	RequireSessionBind bool `js:"requireSessionBind"`
+	// Priority determines the position of the key in the identities list
+	// offered to clients; see SetPriority.
+	Priority int `js:"priority"`
+	// HasCertificate indicates that an OpenSSH certificate is configured
+	// for the key, and is loaded alongside it.
+	HasCertificate bool `js:"hasCertificate"`
+	// CertificateValidBeforeMillis is the time after which the certificate
+	// is no longer valid, in milliseconds since the epoch. It is zero if
+	// there is no certificate, or if it does not expire.
+	CertificateValidBeforeMillis int64 `js:"certificateValidBeforeMillis"`
+	// CertificateValidAfterMillis is the time from which the certificate
+	// is valid, in milliseconds since the epoch. It is zero if there is no
+	// certificate, or if it is valid from the epoch.
+	CertificateValidAfterMillis int64 `js:"certificateValidAfterMillis"`
+	// CertificatePrincipals are the principals for which the certificate
+	// is valid. It is empty if the certificate is valid for any principal.
+	CertificatePrincipals []string `js:"certificatePrincipals"`
+	// CertificateExpired indicates that the certificate is no longer
+	// valid, and should be replaced.
+	CertificateExpired bool `js:"certificateExpired"`
+	// ExpiresAtMillis is the time at which the key expires, in
+	// milliseconds since the epoch. It is the earlier of the expiry set
+	// using SetExpiry and the end of the validity period of the
+	// certificate, and zero if neither applies.
+	ExpiresAtMillis int64 `js:"expiresAtMillis"`
+	// Expired indicates that the key is past its expiry, and should be
+	// rotated. Expired keys cannot be loaded unless allowed using
+	// SetAllowExpired.
+	Expired bool `js:"expired"`
+	// Tags are the tags applied to the key using SetTags, in sorted order.
+	Tags []string `js:"tags"`
+	// Replaces is the ID of the key that this key replaces, if it was
+	// generated using StartRotation and the rotation is not yet complete.
+	Replaces string `js:"replaces"`
+	// ReplacedBy is the ID of the key that replaces this key, if a
+	// rotation was started using StartRotation and is not yet complete.
+	ReplacedBy string `js:"replacedBy"`
+	// RotationStartedMillis is the time at which the rotation started, in
+	// milliseconds since the epoch, if either Replaces or ReplacedBy is
+	// set.
+	RotationStartedMillis int64 `js:"rotationStartedMillis"`
+}
+
+// IdleTimeout returns the period after which the key is unloaded if it is not
+// used. Zero indicates that the key is never unloaded.
+func (k *ConfiguredKey) IdleTimeout() time.Duration {
+	return time.Duration(k.IdleTimeoutMillis) * time.Millisecond
 }
 
 // LoadedKey is a key loaded into the agent.
 type LoadedKey struct {
 	// Type is the type of key loaded in the agent (e.g., 'ssh-rsa').
 	Type string `js:"type"`
+	// Bits is the size of the key in bits.
+	Bits int `js:"bits"`
 	// InternalBlob is the public key material for the loaded key. Must
 	// be exported to be handled correctly in conversion to/from js.Value.
 	InternalBlob string `js:"blob"`
 	// Comment is a comment for the loaded key.
 	Comment string `js:"comment"`
+	// FingerprintSHA256 is the SHA256 fingerprint of the public key, in
+	// the format used by ssh-keygen.
+	FingerprintSHA256 string `js:"fingerprintSHA256"`
+	// FingerprintMD5 is the legacy MD5 fingerprint of the public key, in
+	// the format used by ssh-keygen.
+	FingerprintMD5 string `js:"fingerprintMD5"`
+	// Ephemeral indicates that the key was loaded using LoadEphemeral, and
+	// is not configured.
+	Ephemeral bool `js:"ephemeral"`
+}
+
+// fingerprints returns the SHA256 and legacy MD5 fingerprints of the public
+// key, in the format used by ssh-keygen. As with ssh-keygen, the fingerprints
+// of a certificate are those of the underlying key.
+func fingerprints(pub ssh.PublicKey) (sha256, md5 string) {
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		pub = cert.Key
+	}
+	return ssh.FingerprintSHA256(pub), "MD5:" + ssh.FingerprintLegacyMD5(pub)
+}
+
+// keyType returns the algorithm and size in bits of the public key. As with
+// fingerprints, those of a certificate are those of the underlying key. The
+// size is zero if it cannot be determined.
+func keyType(pub ssh.PublicKey) (string, int) {
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		pub = cert.Key
+	}
+	switch pub.Type() {
+	case ssh.KeyAlgoED25519, ssh.KeyAlgoSKED25519:
+		return pub.Type(), 256
+	}
+	cpub, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return pub.Type(), 0
+	}
+	switch k := cpub.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return pub.Type(), k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return pub.Type(), k.Curve.Params().BitSize
+	}
+	return pub.Type(), 0
 }
 
 // SetBlob sets the given public key material for the loaded key.
@@ -99,18 +244,103 @@ func (k *LoadedKey) ID() ID {
 		return InvalidID
 	}
 
-	return ID(strings.TrimPrefix(k.Comment, commentPrefix))
+	id, _, _ := strings.Cut(strings.TrimPrefix(k.Comment, commentPrefix), " ")
+	return ID(id)
+}
+
+// LoadRequest identifies a key to be loaded by LoadAll, along with the
+// passphrase used to decrypt it. It also supplies per-key passphrases to
+// EnableMasterKey and DisableMasterKey.
+type LoadRequest struct {
+	ID         ID
+	Passphrase string
+}
+
+// Result is the outcome of a bulk operation for a single key.
+type Result struct {
+	// ID is the ID of the key to which the result applies.
+	ID ID
+	// Err is the error encountered for the key, if any.
+	Err error
 }
 
 // Manager provides an API for managing configured keys and loading them into
 // an SSH agent.
 type Manager interface {
-	// Configured returns the full set of keys that are configured.
-	Configured(ctx jsutil.AsyncContext) ([]*ConfiguredKey, error)
+	// Configured returns the full set of keys that are configured. The
+	// WithTag option restricts the result to keys carrying a tag.
+	Configured(ctx jsutil.AsyncContext, opts ...ConfiguredOption) ([]*ConfiguredKey, error)
 
 	// Add configures a new key.  name is a human-readable name describing
-	// the key, and pemPrivateKey is the PEM-encoded private key.
-	Add(ctx jsutil.AsyncContext, name string, pemPrivateKey string) error
+	// the key, and pemPrivateKey is the PEM-encoded private key. The
+	// OpenSSH, PKCS#8, PKCS#1 and SEC 1 formats are supported, encrypted
+	// or not, as are PuTTY key files. Byte order marks, Windows line
+	// endings and surrounding whitespace are tolerated, and an error
+	// describes common mistakes such as supplying the public key.
+	//
+	// If the key is already configured, an error wrapping ErrDuplicateKey
+	// is returned unless the AllowDuplicate option is supplied. Duplicates
+	// can only be detected if the public key can be determined without
+	// the passphrase; this is the case for unencrypted keys, and for
+	// encrypted keys in the OpenSSH and PuTTY formats.
+	//
+	// An OpenSSH certificate for the key may be supplied using the
+	// WithCertificate option, or included in pemPrivateKey alongside the
+	// key. The certificate must be for the key, although this can only be
+	// checked when the key is loaded if the public key cannot be determined
+	// without the passphrase.
+	//
+	// The WithExpectedPublicKey option requires that the key correspond to
+	// a given public key. This does not require the passphrase for keys
+	// whose public key is stored in the clear.
+	//
+	// pemPrivateKey must contain a single key; see Import.
+	Add(ctx jsutil.AsyncContext, name string, pemPrivateKey string, opts ...AddOption) error
+
+	// Import configures each of the keys in text, which may contain
+	// several keys as supported by Add. Each key is added independently,
+	// such that failing to add one does not prevent adding the others; the
+	// result for each is returned, in order.
+	//
+	// If text contains a single key, it is named name. Otherwise, keys are
+	// named using the comments embedded in them where these can be read
+	// without a passphrase, or name otherwise. A numeric suffix is
+	// appended to names that are already in use.
+	Import(ctx jsutil.AsyncContext, name string, text string, opts ...AddOption) ([]*ImportResult, error)
+
+	// AddBatch configures each of the supplied keys, as supported by Add.
+	// All keys are validated before any are stored, and they are then
+	// stored in a single write, such that either all or none are added.
+	// The result for each is returned, in order, including the reason
+	// that any would have failed; if any would have failed, none are
+	// added and an error is also returned.
+	AddBatch(ctx jsutil.AsyncContext, items []*AddItem) ([]*AddResult, error)
+
+	// PublicKeys returns the public key for each configured key, in the
+	// authorized_keys format, such that it can be installed on servers.
+	PublicKeys(ctx jsutil.AsyncContext) ([]*PublicKeyEntry, error)
+
+	// Generate configures a newly-generated key of the specified type and
+	// size, encrypted with the passphrase. The public key is returned in
+	// the authorized_keys format. progress is invoked as generation
+	// proceeds, since generating large RSA keys can be slow.
+	//
+	// Valid sizes are 256 for ed25519 (or 0, since the size is fixed), 256
+	// or 384 for ecdsa, and 2048 or 4096 for rsa.
+	Generate(ctx jsutil.AsyncContext, name string, keyType KeyType, bits int, passphrase string, progress ProgressFunc) (string, error)
+
+	// StartRotation starts replacing the key with the specified ID. A
+	// successor key is generated as for Generate, and its public key is
+	// returned in the authorized_keys format. Both keys remain configured
+	// and may be loaded while servers are updated to accept the
+	// successor; Configured reports the link between them, and uses of the
+	// original key are flagged in the audit log.
+	StartRotation(ctx jsutil.AsyncContext, id ID, name string, keyType KeyType, bits int, passphrase string, progress ProgressFunc) (string, error)
+
+	// CompleteRotation completes the replacement of the key with the
+	// specified ID, which was started using StartRotation. The key is
+	// unloaded, and also removed if remove is true.
+	CompleteRotation(ctx jsutil.AsyncContext, id ID, remove bool) error
 
 	// Remove removes the key with the specified ID.
 	//
@@ -121,38 +351,445 @@ type Manager interface {
 	// the moment.
 	Remove(ctx jsutil.AsyncContext, id ID) error
 
+	// RemoveAll removes the keys with the specified IDs using a single
+	// storage operation. A result is returned for each ID, in the same
+	// order; an ID that does not correspond to a configured key results in
+	// an error. An error is returned directly if storage could not be
+	// updated, in which case no keys were removed.
+	RemoveAll(ctx jsutil.AsyncContext, ids []ID) ([]*Result, error)
+
 	// Loaded returns the full set of keys loaded into the agent.
 	Loaded(ctx jsutil.AsyncContext) ([]*LoadedKey, error)
 
+	// Available returns the keys that are configured, loaded into the
+	// agent, or both, along with the status of each. Configured and loaded
+	// keys are listed together, such that a key being loaded or unloaded
+	// is not missing from the result, as it may be when Configured and
+	// Loaded are invoked separately.
+	Available(ctx jsutil.AsyncContext) ([]*AvailableKey, error)
+
 	// Load loads a new key into to the agent, using the passphrase to
-	// decrypt the private key.
-	//
-	// NOTE: Unencrypted private keys are not currently supported.
+	// decrypt the private key. The passphrase is ignored for unencrypted
+	// keys, and may be empty. It is also ignored for keys protected by the
+	// master key, which fail with ErrLocked unless the master key is
+	// unlocked. If a certificate is configured for the key, both the key
+	// and the certificate are added to the agent.
 	Load(ctx jsutil.AsyncContext, id ID, passphrase string) error
 
+	// LoadAll loads multiple keys into the agent, writing them to the
+	// session using a single storage operation. A result is returned for
+	// each request, in the same order; failure to load one key (e.g., due
+	// to an incorrect passphrase) does not prevent others from being
+	// loaded. An error is returned directly only if the configured keys
+	// could not be read.
+	LoadAll(ctx jsutil.AsyncContext, reqs []*LoadRequest) ([]*Result, error)
+
+	// LoadEphemeral loads a key into the agent without configuring it.
+	// pemPrivateKey is decrypted using the passphrase, and accepted in the
+	// same formats as for Add. The key is never written to storage, so it
+	// is lost when the service worker restarts. The name is used as the
+	// key's comment in the agent. The returned ID may be used to Unload or
+	// Remove the key.
+	LoadEphemeral(ctx jsutil.AsyncContext, name string, pemPrivateKey string, passphrase string) (ID, error)
+
 	// Unload unloads a key from the agent.
 	Unload(ctx jsutil.AsyncContext, id ID) error
+
+	// SetAutoLoad sets whether the key with the specified ID is loaded
+	// automatically by LoadAutoKeys. The passphrase for an encrypted key
+	// is cached for the session the next time the key is loaded.
+	SetAutoLoad(ctx jsutil.AsyncContext, id ID, autoLoad bool) error
+
+	// LoadAutoKeys loads the keys that are configured to load
+	// automatically, and that are not already loaded. Encrypted keys are
+	// skipped if their passphrase is not cached for the session. A result
+	// is returned for each key that was attempted.
+	LoadAutoKeys(ctx jsutil.AsyncContext) ([]*Result, error)
+
+	// Rename changes the name of the key with the specified ID. The name
+	// must not be in use by any other configured key.
+	Rename(ctx jsutil.AsyncContext, id ID, newName string) error
+
+	// SetComment changes the comment embedded in the key with the
+	// specified ID, using the passphrase to decrypt and re-encrypt it.
+	// The key is stored in the OpenSSH format, since that is the only
+	// format that includes a comment. If the key is loaded, the comment is
+	// also updated in the agent.
+	SetComment(ctx jsutil.AsyncContext, id ID, comment string, passphrase string) error
+
+	// GenerateSecurityKey creates a credential on a security key, and
+	// configures it as a key of the specified type. The public key is
+	// returned in the authorized_keys format. The private key never leaves
+	// the security key, and the user must touch it each time the key is
+	// used to sign.
+	//
+	// Only ecdsa keys are supported, since OpenSSH accepts signatures
+	// produced using WebAuthn only for sk-ecdsa-sha2-nistp256 keys.
+	GenerateSecurityKey(ctx jsutil.AsyncContext, name string, keyType KeyType) (string, error)
+
+	// GenerateCryptoKey generates a non-extractable ECDSA P-256 key held
+	// by the browser, and configures it. The public key is returned in the
+	// authorized_keys format. The private key is never stored by the
+	// Manager; removing the key deletes it permanently.
+	GenerateCryptoKey(ctx jsutil.AsyncContext, name string) (string, error)
+
+	// ChangePassphrase re-encrypts the key with the specified ID using
+	// newPassphrase. oldPassphrase must decrypt the key, or be empty if
+	// the key is not encrypted. The key is encrypted using the method
+	// returned by DefaultKeyEncryption, regardless of the format in which
+	// it was originally added.
+	ChangePassphrase(ctx jsutil.AsyncContext, id ID, oldPassphrase, newPassphrase string) error
+
+	// SetIdleTimeout sets the period after which the key with the
+	// specified ID is unloaded if it is not used. Zero indicates that the
+	// key is never unloaded, and UseDefaultIdleTimeout that the default
+	// applies.
+	SetIdleTimeout(ctx jsutil.AsyncContext, id ID, timeout time.Duration) error
+
+	// DefaultIdleTimeout returns the idle timeout for keys that do not
+	// override it.
+	DefaultIdleTimeout(ctx jsutil.AsyncContext) (time.Duration, error)
+
+	// SetDefaultIdleTimeout sets the idle timeout for keys that do not
+	// override it. Zero indicates that keys are never unloaded.
+	SetDefaultIdleTimeout(ctx jsutil.AsyncContext, timeout time.Duration) error
+
+	// DefaultKeyEncryption returns the method used to encrypt keys when
+	// their passphrase is changed, or they are re-encrypted using
+	// ReencryptAll.
+	DefaultKeyEncryption(ctx jsutil.AsyncContext) (KeyEncryption, error)
+
+	// SetDefaultKeyEncryption sets the method returned by
+	// DefaultKeyEncryption.
+	SetDefaultKeyEncryption(ctx jsutil.AsyncContext, enc KeyEncryption) error
+
+	// SetKeyEncryption re-encrypts the key with the specified ID using
+	// enc. passphrase must decrypt the key, and is used to encrypt it
+	// again.
+	SetKeyEncryption(ctx jsutil.AsyncContext, id ID, enc KeyEncryption, passphrase string) error
+
+	// ReencryptAll re-encrypts each encrypted key that passphrase
+	// decrypts using the method returned by DefaultKeyEncryption, such
+	// that all use the current settings. A result is returned for each
+	// encrypted key; those that passphrase does not decrypt are left
+	// unchanged.
+	ReencryptAll(ctx jsutil.AsyncContext, passphrase string) ([]*Result, error)
+
+	// MasterKeyState returns whether keys are protected by a master
+	// passphrase, and if so whether the master key is unlocked.
+	MasterKeyState(ctx jsutil.AsyncContext) (MasterKeyState, error)
+
+	// EnableMasterKey protects keys using the master passphrase, such that
+	// they may be loaded without a passphrase of their own while the
+	// master key is unlocked. reqs supplies the existing passphrase for
+	// each encrypted key. A result is returned for each key; those that
+	// cannot be decrypted keep their own passphrase. If already enabled,
+	// the master passphrase must match, and only keys that are not yet
+	// protected by it are affected. The master key is left unlocked.
+	EnableMasterKey(ctx jsutil.AsyncContext, masterPassphrase string, reqs []*LoadRequest) ([]*Result, error)
+
+	// DisableMasterKey restores per-key passphrases for keys protected by
+	// the master passphrase. reqs supplies the new passphrase for each
+	// such key; keys for which it is empty are stored unencrypted. A
+	// result is returned for each key. The master passphrase remains
+	// enabled unless every key is restored.
+	DisableMasterKey(ctx jsutil.AsyncContext, masterPassphrase string, reqs []*LoadRequest) ([]*Result, error)
+
+	// UnlockMasterKey unlocks the master key for the current session,
+	// such that keys protected by it may be loaded.
+	UnlockMasterKey(ctx jsutil.AsyncContext, masterPassphrase string) error
+
+	// LockMasterKey locks the master key. Keys protected by it then fail
+	// to load with ErrLocked; keys that are already loaded remain so.
+	LockMasterKey(ctx jsutil.AsyncContext) error
+
+	// SetConfirmUse sets whether the user must approve each use of the key
+	// with the specified ID to sign, in the manner of 'ssh-add -c'. The
+	// setting applies immediately if the key is loaded.
+	SetConfirmUse(ctx jsutil.AsyncContext, id ID, confirmUse bool) error
+
+	// SetRefuseSHA1 sets whether the agent refuses to produce ssh-rsa
+	// signatures, which use SHA-1, with the key with the specified ID.
+	// Clients must then request rsa-sha2-256 or rsa-sha2-512 signatures.
+	// The setting applies immediately if the key is loaded.
+	SetRefuseSHA1(ctx jsutil.AsyncContext, id ID, refuse bool) error
+
+	// SetPriority sets the priority of the key with the specified ID,
+	// which determines the order in which loaded keys are offered to
+	// clients; clients typically try them in turn. Keys with a higher
+	// priority are offered first, and those with equal priority in the
+	// order selected using SetKeyOrder. The default priority is zero, and
+	// negative priorities are permitted. The setting applies immediately
+	// if the key is loaded.
+	SetPriority(ctx jsutil.AsyncContext, id ID, priority int) error
+
+	// SetDestinations limits the hosts for which the key with the
+	// specified ID may sign, approximating OpenSSH's destination
+	// constraints. Each destination is a line in the known_hosts format,
+	// identifying a host by its host key. Hosts are only known for
+	// connections over which the client reported the host using the
+	// session-bind@openssh.com extension; requireSessionBind refuses use
+	// of the key over other connections. An empty list removes the
+	// constraint. Refused requests are recorded in the audit log.
+	SetDestinations(ctx jsutil.AsyncContext, id ID, destinations []string, requireSessionBind bool) error
+
+	// AuditLog returns the most recent entries in the audit log, oldest
+	// first. Each request to sign over a connection served by the agent
+	// returned by ConnectionAgent is recorded, whether or not it was
+	// refused. The log is retained for the session, and older entries are
+	// discarded.
+	AuditLog(ctx jsutil.AsyncContext) ([]*AuditEntry, error)
+
+	// ClearAuditLog removes all entries from the audit log.
+	ClearAuditLog(ctx jsutil.AsyncContext) error
+
+	// AuditLogEnabled returns true if requests to sign are recorded in
+	// the audit log. This is the default.
+	AuditLogEnabled(ctx jsutil.AsyncContext) (bool, error)
+
+	// SetAuditLogEnabled sets whether requests to sign are recorded in the
+	// audit log. Existing entries are retained.
+	SetAuditLogEnabled(ctx jsutil.AsyncContext, enabled bool) error
+
+	// SetExpiry sets the time at which the key with the specified ID
+	// expires, or removes it if expiresAt is zero. Keys with a certificate
+	// also expire at the end of its validity period. Expired keys cannot
+	// be loaded unless allowed using SetAllowExpired.
+	SetExpiry(ctx jsutil.AsyncContext, id ID, expiresAt time.Time) error
+
+	// AllowExpired returns true if expired keys may be loaded. By default,
+	// loading them fails with ErrKeyExpired.
+	AllowExpired(ctx jsutil.AsyncContext) (bool, error)
+
+	// SetAllowExpired sets whether expired keys may be loaded. If so, a
+	// warning is logged when they are.
+	SetAllowExpired(ctx jsutil.AsyncContext, allow bool) error
+
+	// MaxLoadedKeys returns the maximum number of identities that may be
+	// loaded into the agent at once, or zero if unlimited. This is the
+	// default.
+	MaxLoadedKeys(ctx jsutil.AsyncContext) (int, error)
+
+	// SetMaxLoadedKeys sets the maximum number of identities that may be
+	// loaded into the agent at once; zero removes the limit. A key and its
+	// certificate are separate identities. Loading a key beyond the limit
+	// fails with an error wrapping ErrTooManyLoadedKeys; keys that are
+	// already loaded are not unloaded. Servers commonly disconnect after
+	// a few failed attempts, so a limit of SuggestedMaxLoadedKeys avoids
+	// offering keys that would not be tried.
+	SetMaxLoadedKeys(ctx jsutil.AsyncContext, max int) error
+
+	// KeyOrder returns the order in which loaded identities are offered to
+	// clients. The default is KeyOrderPriority.
+	KeyOrder(ctx jsutil.AsyncContext) (KeyOrder, error)
+
+	// SetKeyOrder sets the order in which loaded identities are offered to
+	// clients.
+	SetKeyOrder(ctx jsutil.AsyncContext, order KeyOrder) error
+
+	// ProtocolKeyPolicy returns the policy for keys that clients add over
+	// the agent protocol, such as by using ssh-add. The default is
+	// ProtocolKeysEphemeral.
+	ProtocolKeyPolicy(ctx jsutil.AsyncContext) (ProtocolKeyPolicy, error)
+
+	// SetProtocolKeyPolicy sets the policy for keys that clients add over
+	// the agent protocol.
+	SetProtocolKeyPolicy(ctx jsutil.AsyncContext, policy ProtocolKeyPolicy) error
+
+	// AllowedClients returns the IDs of the extensions permitted to connect
+	// to the agent. The default is DefaultAllowedClients.
+	AllowedClients(ctx jsutil.AsyncContext) ([]string, error)
+
+	// AllowClient permits the extension with the specified ID to connect
+	// to the agent. Only extensions in DefaultAllowedClients, which are
+	// listed in the manifest, may be permitted.
+	AllowClient(ctx jsutil.AsyncContext, id string) error
+
+	// DisallowClient refuses connections to the agent from the extension
+	// with the specified ID. Connections that are already open are
+	// closed; see DefaultManager.WatchAllowedClients.
+	DisallowClient(ctx jsutil.AsyncContext, id string) error
+
+	// NativeHosts returns the names of the native messaging hosts to
+	// which the agent connects, such that it may serve clients on the
+	// local device (e.g., OpenSSH). None are connected by default.
+	NativeHosts(ctx jsutil.AsyncContext) ([]string, error)
+
+	// AllowNativeHost connects the agent to the native messaging host
+	// with the specified name.
+	AllowNativeHost(ctx jsutil.AsyncContext, name string) error
+
+	// DisallowNativeHost disconnects the agent from the native messaging
+	// host with the specified name.
+	DisallowNativeHost(ctx jsutil.AsyncContext, name string) error
+
+	// SetCertificate replaces the OpenSSH certificate for the key with the
+	// specified ID, or removes it if cert is empty. The passphrase is not
+	// required; if the key is loaded, the new certificate is loaded in
+	// place of the old one.
+	SetCertificate(ctx jsutil.AsyncContext, id ID, cert string) error
+
+	// SetTags replaces the tags applied to the key with the specified ID.
+	// Tags are stored separately from the key, so the passphrase is not
+	// required. Tags must not be empty or contain whitespace; duplicates
+	// are removed.
+	SetTags(ctx jsutil.AsyncContext, id ID, tags []string) error
+
+	// LoadByTag loads each key carrying the tag that is not already
+	// loaded, using the same passphrase for all of them. A result is
+	// returned for each key that was attempted, as with LoadAll.
+	LoadByTag(ctx jsutil.AsyncContext, tag string, passphrase string) ([]*Result, error)
+
+	// LoadMatching attempts to decrypt each encrypted key that is not
+	// already loaded using the passphrase, and loads those for which it is
+	// correct. Failure to decrypt a key is not an error; such keys are
+	// reported as remaining locked. An error is returned directly only if
+	// the configured keys could not be read.
+	LoadMatching(ctx jsutil.AsyncContext, passphrase string) (*MatchResults, error)
+
+	// PersistSession returns true if decrypted keys are persisted to
+	// session storage, such that loaded keys survive restarts of the
+	// service worker. This is the default.
+	PersistSession(ctx jsutil.AsyncContext) (bool, error)
+
+	// SetPersistSession sets whether decrypted keys are persisted to
+	// session storage. If not, they are held only in memory, and keys must
+	// be loaded again whenever the service worker restarts. Keys that are
+	// already loaded are moved accordingly.
+	SetPersistSession(ctx jsutil.AsyncContext, persist bool) error
+
+	// Locked returns true if the agent is locked.
+	Locked(ctx jsutil.AsyncContext) (bool, error)
+
+	// Lock locks the agent, as with 'ssh-add -x'. While locked, the agent
+	// lists no keys and refuses to sign, although keys remain loaded. The
+	// lock persists for the session, even if the service worker restarts.
+	Lock(ctx jsutil.AsyncContext, passphrase string) error
+
+	// Unlock unlocks the agent, as with 'ssh-add -X'. The passphrase must
+	// be the one with which the agent was locked; if not, an error
+	// wrapping ErrWrongPassphrase is returned.
+	Unlock(ctx jsutil.AsyncContext, passphrase string) error
 }
 
 // NewManager returns a Manager implementation that can manage keys in the
 // supplied agent, and store configured keys in the supplied storage.
+//
+// Requests should be served using the agent returned by Agent, rather than
+// the supplied agent.
 func NewManager(agt agent.Agent, syncStorage, sessionStorage storage.Area) *DefaultManager {
-	return &DefaultManager{
-		agent:          agt,
+	m := &DefaultManager{
 		syncStorage:    syncStorage,
 		sessionStorage: sessionStorage,
 		storedKeys:     storage.NewTyped[storedKey](syncStorage, storedKeyPrefixes),
+		settings:       storage.NewTyped[managerSettings](syncStorage, settingsPrefixes),
 		sessionKeys:    storage.NewTyped[sessionKey](sessionStorage, sessionKeyPrefixes),
+		passphrases:    storage.NewTyped[cachedPassphrase](sessionStorage, passphrasePrefixes),
+		lastUses:       storage.NewTyped[lastUse](sessionStorage, lastUsePrefixes),
+		tags:           storage.NewTyped[keyTags](syncStorage, tagsPrefixes),
+		agentLock:      storage.NewTyped[agentLock](sessionStorage, agentLockPrefixes),
+		masterKey:      storage.NewTyped[masterKey](syncStorage, masterKeyPrefixes),
+		dataKey:        storage.NewTyped[unlockedDataKey](sessionStorage, dataKeyPrefixes),
+		auditLog:       storage.NewTyped[AuditEntry](sessionStorage, auditLogPrefixes),
+		now:            time.Now,
+		alarms:         js.Undefined(),
+		memoryKeys:     map[ID]decryptedKey{},
+		confirmUse:     map[ID]string{},
+		refuseSHA1:     map[ID]bool{},
+		ephemeral:      map[ID]bool{},
+		destinations:   map[ID]*destinationConstraint{},
+		ranks:          map[ID]*keyRank{},
+
+		ephemeralLifetimes: map[ID]time.Time{},
 	}
+	m.agent = &managedAgent{Agent: agt, confirm: m.confirm, allowSHA1: m.allowSHA1, onSign: m.onSign, rank: m.rank}
+	m.locker = &lockingAgent{ExtendedAgent: m.agent, persist: m.storeLock, now: func() time.Time { return m.now() }}
+	return m
+}
+
+// SetKeepAlive sets the KeepAlive held during operations that may take longer
+// than the service worker is permitted to remain idle, such as generating and
+// migrating keys. If none is set, the service worker is not kept alive.
+func (m *DefaultManager) SetKeepAlive(k *keepalive.KeepAlive) {
+	m.keepAlive = k
 }
 
 // DefaultManager is an implementation of Manager.
 type DefaultManager struct {
-	agent          agent.Agent
+	agent          *managedAgent
+	locker         *lockingAgent
 	syncStorage    storage.Area
 	sessionStorage storage.Area
 	storedKeys     *storage.Typed[storedKey]
+	settings       *storage.Typed[managerSettings]
 	sessionKeys    *storage.Typed[sessionKey]
+	passphrases    *storage.Typed[cachedPassphrase]
+	lastUses       *storage.Typed[lastUse]
+	tags           *storage.Typed[keyTags]
+	agentLock      *storage.Typed[agentLock]
+	masterKey      *storage.Typed[masterKey]
+	dataKey        *storage.Typed[unlockedDataKey]
+	auditLog       *storage.Typed[AuditEntry]
+
+	// now returns the current time. Overridden in tests.
+	now func() time.Time
+
+	// alarms is the chrome.alarms API used to schedule unloading of idle
+	// keys, or undefined if idle keys are not unloaded automatically.
+	alarms js.Value
+	// alarmName is the name of the alarm used to schedule unloading.
+	alarmName string
+
+	// keysMu guards the state of loaded keys below, which the agent reads
+	// while serving connections, concurrently with changes made by the
+	// Manager's methods.
+	keysMu sync.Mutex
+	// memoryKeys contains the decrypted keys that are loaded but not
+	// persisted to the session, indexed by ID.
+	memoryKeys map[ID]decryptedKey
+
+	// confirmUse contains the names of keys whose use must be confirmed,
+	// indexed by ID. It is populated as keys are loaded.
+	confirmUse map[ID]string
+	// refuseSHA1 contains the IDs of keys for which SHA-1 signatures are
+	// refused. It is populated as keys are loaded.
+	refuseSHA1 map[ID]bool
+	// destinations contains the destination constraints for keys,
+	// indexed by ID. It is populated as keys are loaded.
+	destinations map[ID]*destinationConstraint
+	// ephemeral contains the IDs of keys loaded using LoadEphemeral.
+	ephemeral map[ID]bool
+	// ephemeralLifetimes contains the times at which ephemeral keys added
+	// with a lifetime constraint are unloaded, indexed by ID. For other
+	// keys, the time is recorded in the session.
+	ephemeralLifetimes map[ID]time.Time
+	// ranks contains the priority and name of keys, which determine their
+	// position in the identities list, indexed by ID. It is populated as
+	// keys are loaded.
+	ranks map[ID]*keyRank
+
+	// confirmer is used to confirm use of keys.
+	confirmer ConfirmFunc
+	// onRefused is invoked when a request to sign is refused by a
+	// constraint on the key.
+	onRefused RefusedFunc
+
+	// authenticator performs operations on security keys, and rpID is
+	// the WebAuthn relying party ID used for new credentials.
+	authenticator Authenticator
+	rpID          string
+	// cryptoKeys holds non-extractable keys.
+	cryptoKeys CryptoKeyStore
+	// keepAlive keeps the service worker running during long operations.
+	keepAlive *keepalive.KeepAlive
+
+	// changes reports changes made to keys. See OnChange.
+	changes changeTracker
+
+	// allowedClients contains the IDs of the extensions permitted to
+	// connect to the agent. See ClientAllowed.
+	clientsMu      sync.Mutex
+	allowedClients map[string]bool
 }
 
 // storedKey is the raw object stored in persistent storage for a configured
@@ -161,6 +798,119 @@ type storedKey struct {
 	ID            string `js:"id"`
 	Name          string `js:"name"`
 	PEMPrivateKey string `js:"pemPrivateKey"`
+	// PublicKey is the base64-encoded public key, if it could be
+	// determined when the key was added.
+	PublicKey string `js:"publicKey"`
+	// Comment is the comment embedded in PEMPrivateKey. The crypto
+	// libraries do not expose the comment when parsing a key, so we track
+	// it separately.
+	Comment string `js:"comment"`
+	// AutoLoad indicates that the key is loaded by LoadAutoKeys.
+	AutoLoad bool `js:"autoLoad"`
+	// OverrideIdleTimeout indicates that IdleTimeout applies, rather than
+	// the default.
+	OverrideIdleTimeout bool `js:"overrideIdleTimeout"`
+	// IdleTimeout is the idle timeout in milliseconds, if
+	// OverrideIdleTimeout is set.
+	IdleTimeout int64 `js:"idleTimeout"`
+	// ConfirmUse indicates that use of the key must be confirmed.
+	ConfirmUse bool `js:"confirmUse"`
+	// RefuseSHA1 indicates that SHA-1 signatures are refused.
+	RefuseSHA1 bool `js:"refuseSHA1"`
+	// Destinations are the hosts for which the key may sign.
+	Destinations []string `js:"destinations"`
+	// RequireSessionBind indicates that the key may not sign for unknown
+	// hosts.
+	RequireSessionBind bool `js:"requireSessionBind"`
+	// Priority is the priority of the key in the identities list.
+	Priority int `js:"priority"`
+	// Certificate is the base64-encoded OpenSSH certificate for the key,
+	// if any.
+	Certificate string `js:"certificate"`
+	// SecurityKey is the base64-encoded handle for a key held on a
+	// security key, in which case PEMPrivateKey is empty.
+	SecurityKey string `js:"securityKey"`
+	// CryptoKey is the handle for a non-extractable key held by the
+	// browser, in which case PEMPrivateKey is empty.
+	CryptoKey string `js:"cryptoKey"`
+	// ExpiresAt is the expiry set using SetExpiry, in milliseconds since
+	// the epoch, or zero if none is set.
+	ExpiresAt int64 `js:"expiresAt"`
+	// Replaces is the ID of the key that this key replaces, if it was
+	// generated using StartRotation and the rotation is not yet complete.
+	Replaces string `js:"replaces"`
+	// RotationStarted is the time at which the rotation started, in
+	// milliseconds since the epoch, if Replaces is set.
+	RotationStarted int64 `js:"rotationStarted"`
+}
+
+// SetPublic sets the public key corresponding to the stored private key.
+func (s *storedKey) SetPublic(pub ssh.PublicKey) {
+	s.PublicKey = base64.StdEncoding.EncodeToString(pub.Marshal())
+}
+
+// setPublicFromPrivate sets the public key corresponding to the decrypted
+// private key, if it is not already known.
+func (s *storedKey) setPublicFromPrivate(priv interface{}) error {
+	if s.PublicKey != "" {
+		return nil
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errParseFailed, err)
+	}
+	s.SetPublic(signer.PublicKey())
+	return nil
+}
+
+// Public returns the public key corresponding to the stored private key, or
+// nil if it is not known.
+func (s *storedKey) Public() ssh.PublicKey {
+	if s.PublicKey == "" {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s.PublicKey)
+	if err != nil {
+		jsutil.LogError("failed to decode public key for key ID %s: %v", s.ID, err)
+		return nil
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		jsutil.LogError("failed to parse public key for key ID %s: %v", s.ID, err)
+		return nil
+	}
+	return pub
+}
+
+// derivePublicKey returns the public key corresponding to the private key, or
+// nil if it cannot be determined without the passphrase.
+func derivePublicKey(privateKey string) ssh.PublicKey {
+	if isPPK(privateKey) {
+		f, err := parsePPK(privateKey)
+		if err != nil {
+			return nil
+		}
+		pub, err := ssh.ParsePublicKey(f.Public)
+		if err != nil {
+			return nil
+		}
+		return pub
+	}
+
+	priv, err := ssh.ParseRawPrivateKey([]byte(privateKey))
+	if err != nil {
+		// Encrypted OpenSSH keys include the public key in the clear.
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return missing.PublicKey
+		}
+		return nil
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil
+	}
+	return signer.PublicKey()
 }
 
 // EncryptedPKCS8 determines if the private key is an encrypted PKCS#8 formatted
@@ -179,9 +929,76 @@ func (s *storedKey) EncryptedPKCS8() bool {
 	return block.Type == "ENCRYPTED PRIVATE KEY"
 }
 
+// Formats in which a private key may be stored. Regardless of format, keys are
+// converted to PKCS#8 when loaded.
+const (
+	// FormatOpenSSH is OpenSSH's own format, as written by ssh-keygen.
+	FormatOpenSSH = "openssh"
+	// FormatPKCS8 is PKCS#8, optionally encrypted per PKCS#5 v2.
+	FormatPKCS8 = "pkcs8"
+	// FormatPKCS1 is OpenSSL's traditional PKCS#1 format for RSA keys.
+	FormatPKCS1 = "pkcs1"
+	// FormatSEC1 is OpenSSL's traditional SEC 1 format for ECDSA keys.
+	FormatSEC1 = "sec1"
+	// FormatDSA is OpenSSL's traditional format for DSA keys.
+	FormatDSA = "dsa"
+	// FormatPuTTY is a PuTTY private key file (.ppk).
+	FormatPuTTY = "putty"
+	// FormatArgon2id is our own format for keys encrypted using
+	// EncryptionArgon2id.
+	FormatArgon2id = "argon2id"
+	// FormatMasterKey is our own format for keys protected by the master
+	// passphrase; see EnableMasterKey.
+	FormatMasterKey = "master-key"
+)
+
+// pemFormats maps the PEM block types we support to the corresponding format.
+var pemFormats = map[string]string{
+	"OPENSSH PRIVATE KEY":   FormatOpenSSH,
+	"PRIVATE KEY":           FormatPKCS8,
+	"ENCRYPTED PRIVATE KEY": FormatPKCS8,
+	"RSA PRIVATE KEY":       FormatPKCS1,
+	"EC PRIVATE KEY":        FormatSEC1,
+	"DSA PRIVATE KEY":       FormatDSA,
+	envelopeBlockType:       FormatArgon2id,
+	wrappedKeyBlockType:     FormatMasterKey,
+}
+
+// detectFormat returns the format of the private key, or the empty string if
+// it is not in a supported format.
+func detectFormat(privateKey string) string {
+	if isPPK(privateKey) {
+		return FormatPuTTY
+	}
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return ""
+	}
+	return pemFormats[block.Type]
+}
+
+// Format returns the format in which the private key is stored, or the empty
+// string if it is not in a supported format.
+func (s *storedKey) Format() string {
+	if s.IsSecurityKey() {
+		return FormatSecurityKey
+	}
+	if s.IsCryptoKey() {
+		return FormatWebCrypto
+	}
+	return detectFormat(s.PEMPrivateKey)
+}
+
 // Encrypted determines if the private key is encrypted. The Proc-Type header
 // contains 'ENCRYPTED' if the key is encrypted. See RFC 1421 Section 4.6.1.1.
 func (s *storedKey) Encrypted() bool {
+	// PuTTY key files indicate whether they are encrypted in their
+	// headers.
+	if isPPK(s.PEMPrivateKey) {
+		f, err := parsePPK(s.PEMPrivateKey)
+		return err == nil && f.Encrypted()
+	}
+
 	block, _ := pem.Decode([]byte(s.PEMPrivateKey))
 	if block == nil {
 		// Attempt to handle this gracefully and guess that it isn't
@@ -196,6 +1013,16 @@ func (s *storedKey) Encrypted() bool {
 		return true
 	}
 
+	// Keys in our own format are always encrypted.
+	if block.Type == envelopeBlockType {
+		return true
+	}
+	// Keys protected by the master passphrase do not require a passphrase
+	// of their own.
+	if block.Type == wrappedKeyBlockType {
+		return false
+	}
+
 	// OpenSSH keys don't have a type or header indicating if they are
 	// encrypted. We could parse the key to determine that, but that would
 	// reimplement the underlying crypto libraries. Instead, just attempt to
@@ -218,6 +1045,17 @@ func (s *storedKey) Encrypted() bool {
 type sessionKey struct {
 	ID         string `js:"id"`
 	PrivateKey string `js:"privateKey"`
+	Comment    string `js:"comment"`
+	// LoadTime is the time at which the key was loaded, in milliseconds
+	// since the epoch.
+	LoadTime int64 `js:"loadTime"`
+	// InMemory indicates that the decrypted key is held only in memory,
+	// and is not in PrivateKey. See SetPersistSession.
+	InMemory bool `js:"inMemory"`
+	// LifetimeEnd is the time at which the key is unloaded due to the
+	// lifetime constraint with which it was added over the agent protocol,
+	// in milliseconds since the epoch, or zero if there is none.
+	LifetimeEnd int64 `js:"lifetimeEnd"`
 }
 
 var (
@@ -261,23 +1099,87 @@ var (
 const (
 	// commentPrefix is the prefix for the comment included when a
 	// configured key is loaded into the agent. The full comment is of the
-	// form 'chrome-ssh-agent:<id>'.
+	// form 'chrome-ssh-agent:<id>', followed by a space and the key's own
+	// comment if it has one.
 	commentPrefix = "chrome-ssh-agent:"
 )
 
+// agentComment returns the comment used when the key with the specified ID and
+// comment is loaded into the agent.
+func agentComment(id ID, comment string) string {
+	if comment == "" {
+		return fmt.Sprintf("%s%s", commentPrefix, id)
+	}
+	return fmt.Sprintf("%s%s %s", commentPrefix, id, comment)
+}
+
 // Configured implements Manager.Configured.
-func (m *DefaultManager) Configured(ctx jsutil.AsyncContext) ([]*ConfiguredKey, error) {
+func (m *DefaultManager) Configured(ctx jsutil.AsyncContext, opts ...ConfiguredOption) ([]*ConfiguredKey, error) {
+	var o configuredOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	keys, err := m.storedKeys.ReadAll(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read keys: %w", err)
 	}
+	defaultTimeout, err := m.DefaultIdleTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := m.readTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replacedBy := rotations(keys)
 
 	var result []*ConfiguredKey
 	for _, k := range keys {
+		if o.tag != "" && !slices.Contains(tags[ID(k.ID)], o.tag) {
+			continue
+		}
 		c := ConfiguredKey{
 			ID:        k.ID,
 			Name:      k.Name,
 			Encrypted: k.Encrypted(),
+			Format:    k.Format(),
+			Comment:   k.Comment,
+			AutoLoad:  k.AutoLoad,
+
+			IdleTimeoutMillis:   k.idleTimeout(defaultTimeout).Milliseconds(),
+			OverrideIdleTimeout: k.OverrideIdleTimeout,
+			ConfirmUse:          k.ConfirmUse,
+			RefuseSHA1:          k.RefuseSHA1,
+			Destinations:        k.Destinations,
+			RequireSessionBind:  k.RequireSessionBind,
+			Priority:            k.Priority,
+			Tags:                tags[ID(k.ID)],
+		}
+		if pub := k.Public(); pub != nil {
+			c.Type, c.Bits = keyType(pub)
+			c.FingerprintSHA256, c.FingerprintMD5 = fingerprints(pub)
+		}
+		if cert := k.Cert(); cert != nil {
+			c.HasCertificate = true
+			if cert.ValidBefore != ssh.CertTimeInfinity {
+				c.CertificateValidBeforeMillis = time.Unix(int64(cert.ValidBefore), 0).UnixMilli()
+			}
+			c.CertificateValidAfterMillis = time.Unix(int64(cert.ValidAfter), 0).UnixMilli()
+			c.CertificatePrincipals = cert.ValidPrincipals
+			c.CertificateExpired = certificateExpired(cert, m.now())
+		}
+		if expiry := k.expiry(); !expiry.IsZero() {
+			c.ExpiresAtMillis = expiry.UnixMilli()
+			c.Expired = expired(expiry, m.now())
+		}
+		if s := replacedBy[ID(k.ID)]; s != nil {
+			c.ReplacedBy = s.ID
+			c.RotationStartedMillis = s.RotationStarted
+		}
+		if replacedBy[ID(k.Replaces)] == k {
+			c.Replaces = k.Replaces
+			c.RotationStartedMillis = k.RotationStarted
 		}
 		result = append(result, &c)
 	}
@@ -286,28 +1188,246 @@ func (m *DefaultManager) Configured(ctx jsutil.AsyncContext) ([]*ConfiguredKey,
 
 var errInvalidName = errors.New("invalid name")
 
-// Add implements Manager.Add.
-func (m *DefaultManager) Add(ctx jsutil.AsyncContext, name string, pemPrivateKey string) error {
-	if name == "" {
-		return fmt.Errorf("%w: name must not be empty", errInvalidName)
+// ErrDuplicateKey indicates that a key is already configured. Errors wrapping
+// it are of type *DuplicateKeyError, which identifies the existing key.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// DuplicateKeyError is returned when adding a key that is already configured.
+type DuplicateKeyError struct {
+	// ID is the ID of the existing key.
+	ID ID
+	// Name is the name of the existing key.
+	Name string
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("%v: key is already configured as %q", ErrDuplicateKey, e.Name)
+}
+
+// Is allows the error to be compared against ErrDuplicateKey.
+func (e *DuplicateKeyError) Is(target error) bool {
+	return target == ErrDuplicateKey
+}
+
+// AddOption modifies the behavior of Add.
+type AddOption func(o *addOptions)
+
+type addOptions struct {
+	allowDuplicate    bool
+	certificate       string
+	expectedPublicKey string
+	// replaces is the ID of the key that the new key replaces; see
+	// StartRotation.
+	replaces ID
+}
+
+// AllowDuplicate permits adding a key that is already configured.
+func AllowDuplicate() AddOption {
+	return func(o *addOptions) {
+		o.allowDuplicate = true
 	}
+}
 
+// findDuplicate returns an error if a key with the same public key as pub is
+// already configured. If there are several, the one with the lowest ID (that
+// is, the original copy) is reported.
+func (m *DefaultManager) findDuplicate(ctx jsutil.AsyncContext, pub ssh.PublicKey) error {
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read keys: %w", err)
+	}
+	return findDuplicateIn(keys, pub)
+}
+
+// findDuplicateIn is like findDuplicate, but searches the supplied keys.
+func findDuplicateIn(keys []*storedKey, pub ssh.PublicKey) error {
+	keys = append([]*storedKey(nil), keys...)
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	fp := ssh.FingerprintSHA256(pub)
+	for _, k := range keys {
+		if existing := k.Public(); existing != nil && ssh.FingerprintSHA256(existing) == fp {
+			return &DuplicateKeyError{ID: ID(k.ID), Name: k.Name}
+		}
+	}
+	return nil
+}
+
+// newID returns a new random ID for a configured key.
+func newID() (ID, error) {
 	i, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
 	if err != nil {
-		return fmt.Errorf("failed to generate new ID: %w", err)
+		return InvalidID, fmt.Errorf("failed to generate new ID: %w", err)
+	}
+	return ID(i.String()), nil
+}
+
+// Add implements Manager.Add.
+func (m *DefaultManager) Add(ctx jsutil.AsyncContext, name string, pemPrivateKey string, opts ...AddOption) error {
+	var o addOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	sk, pub, err := parseNewKey(name, pemPrivateKey, &o)
+	if err != nil {
+		return err
+	}
+	if pub != nil && !o.allowDuplicate {
+		if err := m.findDuplicate(ctx, pub); err != nil {
+			return err
+		}
+	}
+
+	// The ID is derived from the public key where it is known, such that
+	// the key has the same ID on all synced devices.
+	used, err := m.usedIDs(ctx)
+	if err != nil {
+		return err
+	}
+	id, err := allocateID(pub, used)
+	if err != nil {
+		return err
+	}
+	sk.ID = string(id)
+	if o.replaces != InvalidID {
+		sk.Replaces = string(o.replaces)
+		sk.RotationStarted = m.now().UnixMilli()
+	}
+	return m.writeStoredKey(ctx, sk)
+}
+
+// parseNewKey validates a key supplied to Add, along with the certificate and
+// expected public key in o. It returns the key to be stored, which has not
+// yet been assigned an ID, and the public key where this is known.
+func parseNewKey(name string, pemPrivateKey string, o *addOptions) (*storedKey, ssh.PublicKey, error) {
+	if name == "" {
+		return nil, nil, fmt.Errorf("%w: name must not be empty", errInvalidName)
+	}
+
+	pemPrivateKey = normalizeKeyText(pemPrivateKey)
+	if n := len(splitKeys(pemPrivateKey)); n > 1 {
+		return nil, nil, fmt.Errorf("%w: found %d keys", errMultipleKeys, n)
+	}
+	pemPrivateKey, certText := splitCertificate(pemPrivateKey)
+	if o.certificate != "" {
+		certText = o.certificate
+	}
+	var cert *ssh.Certificate
+	if certText != "" {
+		var err error
+		if cert, err = parseCertificate(certText); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if detectFormat(pemPrivateKey) == "" {
+		return nil, nil, diagnoseKeyText(pemPrivateKey)
+	}
+
+	// PuTTY key files are converted to the OpenSSH format. This is only
+	// possible for unencrypted files, since we do not have the passphrase;
+	// encrypted files are stored as-is, and decrypted when loaded.
+	if isPPK(pemPrivateKey) {
+		f, err := parsePPK(pemPrivateKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !f.Encrypted() {
+			if pemPrivateKey, err = convertPPK(f); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	pub := derivePublicKey(pemPrivateKey)
+	// OpenSSH has disabled DSA keys, so servers would reject their
+	// signatures.
+	if isDSAKey(pemPrivateKey, pub) {
+		return nil, nil, fmt.Errorf("%w: DSA (ssh-dss) keys are disabled in OpenSSH; use a newer key type, such as Ed25519", ErrUnsupportedKeyType)
+	}
+	if o.expectedPublicKey != "" {
+		if err := checkExpectedPublicKey(pub, o.expectedPublicKey); err != nil {
+			return nil, nil, err
+		}
+	}
+	if pub != nil && cert != nil {
+		if err := checkCertificate(cert, pub); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	sk := &storedKey{
-		ID:            i.String(),
 		Name:          name,
 		PEMPrivateKey: pemPrivateKey,
 	}
-	return m.storedKeys.Write(ctx, sk)
+	if pub != nil {
+		sk.SetPublic(pub)
+	}
+	sk.SetCertificate(cert)
+	return sk, pub, nil
 }
 
 // Remove implements Manager.Remove.
 func (m *DefaultManager) Remove(ctx jsutil.AsyncContext, id ID) error {
-	return m.storedKeys.Delete(ctx, func(sk *storedKey) bool { return ID(sk.ID) == id })
+	// Ephemeral keys are not configured, so there is nothing else to
+	// remove.
+	if m.isEphemeral(id) {
+		m.unloadRemoved(ctx, id)
+		m.checkChanges(ctx)
+		return nil
+	}
+
+	key, err := m.storedKeys.Read(ctx, func(sk *storedKey) bool { return ID(sk.ID) == id })
+	if err != nil {
+		return err
+	}
+	if err := m.storedKeys.Delete(ctx, func(sk *storedKey) bool { return ID(sk.ID) == id }); err != nil {
+		return err
+	}
+	if key != nil && key.IsCryptoKey() {
+		m.deleteCryptoKeys(ctx, key.CryptoKey)
+	}
+	m.unloadRemoved(ctx, id)
+	m.forgetPassphrases(ctx, id)
+	m.forgetTags(ctx, id)
+	return nil
+}
+
+// RemoveAll implements Manager.RemoveAll.
+func (m *DefaultManager) RemoveAll(ctx jsutil.AsyncContext, ids []ID) ([]*Result, error) {
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	configured := map[ID]*storedKey{}
+	for _, k := range keys {
+		configured[ID(k.ID)] = k
+	}
+
+	var results []*Result
+	remove := map[ID]bool{}
+	var cryptoKeys []string
+	for _, id := range ids {
+		r := &Result{ID: id}
+		if k, ok := configured[id]; ok {
+			remove[id] = true
+			if k.IsCryptoKey() {
+				cryptoKeys = append(cryptoKeys, k.CryptoKey)
+			}
+		} else {
+			r.Err = fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+		}
+		results = append(results, r)
+	}
+
+	if err := m.storedKeys.Delete(ctx, func(sk *storedKey) bool { return remove[ID(sk.ID)] }); err != nil {
+		return nil, fmt.Errorf("failed to remove keys: %w", err)
+	}
+	m.deleteCryptoKeys(ctx, cryptoKeys...)
+	m.unloadRemoved(ctx, ids...)
+	m.forgetPassphrases(ctx, ids...)
+	m.forgetTags(ctx, ids...)
+	return results, nil
 }
 
 // Loaded implements Manager.Loaded.
@@ -324,6 +1444,11 @@ func (m *DefaultManager) Loaded(_ jsutil.AsyncContext) ([]*LoadedKey, error) {
 			Comment: l.Comment,
 		}
 		k.SetBlob(l.Marshal())
+		if pub, err := ssh.ParsePublicKey(l.Marshal()); err == nil {
+			_, k.Bits = keyType(pub)
+			k.FingerprintSHA256, k.FingerprintMD5 = fingerprints(pub)
+		}
+		k.Ephemeral = m.isEphemeral(k.ID())
 		result = append(result, &k)
 	}
 
@@ -337,8 +1462,26 @@ var (
 	errMarshalFailed = errors.New("key marshalling failed")
 )
 
-// CleanupOldData removes storage data that is no longer required.
+// Errors returned when a private key cannot be decrypted, such that callers can
+// distinguish a mistyped passphrase from a key that cannot be used at all.
+// Their identity is preserved by the client returned by NewClient.
+var (
+	// ErrWrongPassphrase indicates that the passphrase for an encrypted
+	// key is incorrect.
+	ErrWrongPassphrase = errors.New("incorrect passphrase")
+	// ErrPassphraseRequired indicates that the key is encrypted, but no
+	// passphrase was supplied.
+	ErrPassphraseRequired = errors.New("passphrase required")
+	// ErrKeyCorrupt indicates that the stored key data is malformed, and
+	// cannot be parsed regardless of the passphrase.
+	ErrKeyCorrupt = errors.New("key data is corrupt")
+)
+
+// CleanupOldData removes storage data that is no longer required, and migrates
+// data stored in older formats.
 func (m *DefaultManager) CleanupOldData(ctx jsutil.AsyncContext) {
+	defer m.keepAlive.Acquire()()
+
 	jsutil.LogDebug("DefaultManager.CleanupOldData: Cleaning up stored keys")
 
 	areas := []storage.Area{
@@ -356,65 +1499,149 @@ func (m *DefaultManager) CleanupOldData(ctx jsutil.AsyncContext) {
 			}
 		}
 	}
+
+	jsutil.LogDebug("DefaultManager.CleanupOldData: Migrating key IDs")
+	if err := m.migrateIDs(ctx); err != nil {
+		jsutil.LogError("failed to migrate key IDs: %v", err)
+	}
 }
 
 // LoadFromSession loads all keys for the current session into the agent.
 func (m *DefaultManager) LoadFromSession(ctx jsutil.AsyncContext) error {
+	// Restore the lock first, such that keys are not usable if the agent
+	// was locked.
+	if err := m.restoreLock(ctx); err != nil {
+		return err
+	}
+
 	// Read session keys. We'll load these into the agent.
 	jsutil.LogDebug("DefaultManager.LoadFromSession: Read session keys")
 	sessionKeys, err := m.sessionKeys.ReadAll(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to read session keys: %w", err)
 	}
+	sessionKeys = m.purgeLostKeys(ctx, sessionKeys)
+
+	// Read configured keys, such that we apply their settings. If we
+	// cannot, load nothing rather than bypass confirmation.
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read keys: %w", err)
+	}
+	configured := map[ID]*storedKey{}
+	for _, k := range keys {
+		configured[ID(k.ID)] = k
+	}
 
 	// Attempt to load each into the agent.
 	jsutil.LogDebug("DefaultManager.LoadFromSession: Load session keys")
 	for _, k := range sessionKeys {
-		if err := m.addToAgent(ID(k.ID), decryptedKey(k.PrivateKey)); err != nil {
+		var cert *ssh.Certificate
+		key, ok := configured[ID(k.ID)]
+		if ok {
+			cert = key.Cert()
+		}
+		if ok && key.IsSecurityKey() {
+			err = m.addSecurityKeyToAgent(ID(k.ID), key)
+		} else if ok && key.IsCryptoKey() {
+			err = m.addCryptoKeyToAgent(ID(k.ID), key)
+		} else {
+			decrypted := decryptedKey(k.PrivateKey)
+			err = m.addToAgent(ID(k.ID), decrypted, k.Comment, cert)
+			decrypted.wipe()
+		}
+		if err != nil {
 			jsutil.LogError("failed to load session key ID %s into agent: %v; skipping", k.ID, err)
+			continue
+		}
+		if ok {
+			m.applyConfirmUse(key)
+			m.applyRefuseSHA1(key)
+			m.applyDestinations(key)
+			m.applyPriority(key)
 		}
 	}
-	return nil
+
+	// Apply the limit on loaded keys last, such that keys loaded before
+	// the limit was lowered are not refused.
+	return m.restoreLimits(ctx)
 }
 
-type decryptedKey string
+// decryptedKey is a decrypted private key, PEM-encoded in PKCS#8 format. Its
+// owner must wipe it once it is no longer needed, such that the key does not
+// linger in WebAssembly memory. See secret.
+type decryptedKey []byte
+
+// wipe overwrites the key.
+func (k decryptedKey) wipe() {
+	clear(k)
+}
 
 const (
 	pkcs8BlockType = "PRIVATE KEY"
 )
 
 func decryptKey(key *storedKey, passphrase string) (decryptedKey, error) {
+	// The private key is not available for keys held on security keys.
+	if key.IsSecurityKey() {
+		return nil, fmt.Errorf("%w: private key is held on the security key", errSecurityKeyOperation)
+	}
+	if key.IsCryptoKey() {
+		return nil, fmt.Errorf("%w: private key is held by the browser", errCryptoKeyOperation)
+	}
+
 	// Decode and decrypt the key.
+	pp := secretString(passphrase)
+	defer pp.Wipe()
 	var err error
 	var priv interface{}
 	switch {
+	case isPPK(key.PEMPrivateKey):
+		var f *ppkFile
+		if f, err = parsePPK(key.PEMPrivateKey); err == nil {
+			if f.Encrypted() && passphrase == "" {
+				return nil, fmt.Errorf("%w: PuTTY key file is encrypted", ErrPassphraseRequired)
+			}
+			priv, err = f.Decrypt(passphrase)
+		}
+	case isWrapped(key.PEMPrivateKey):
+		// These are decrypted using the master key; see decryptStoredKey.
+		return nil, errProtectedByMasterKey
+	case isEnvelope(key.PEMPrivateKey):
+		if passphrase == "" {
+			return nil, fmt.Errorf("%w: key is encrypted", ErrPassphraseRequired)
+		}
+		// Errors are already classified.
+		if priv, err = openEnvelope(key.PEMPrivateKey, passphrase); err != nil {
+			return nil, err
+		}
 	case key.EncryptedPKCS8():
 		// Crypto libraries don't yet support encrypted PKCS#8 keys:
 		//   https://github.com/golang/go/issues/8860
 		var block *pem.Block
 		block, _ = pem.Decode([]byte(key.PEMPrivateKey))
 		if block == nil {
-			return "", fmt.Errorf("%w: failed to decode encrypted private key", errDecodeFailed)
+			return nil, fmt.Errorf("%w: %w: failed to decode encrypted private key", ErrKeyCorrupt, errDecodeFailed)
 		}
-		if passphrase != "" {
-			priv, err = pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(passphrase))
-		} else {
-			priv, err = pkcs8.ParsePKCS8PrivateKey(block.Bytes, nil)
+		if passphrase == "" {
+			return nil, fmt.Errorf("%w: PKCS#8 key is encrypted", ErrPassphraseRequired)
 		}
-	case key.Encrypted():
-		priv, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(key.PEMPrivateKey), []byte(passphrase))
+		priv, err = pkcs8.ParsePKCS8PrivateKey(block.Bytes, pp.Bytes())
+	case key.Encrypted() && passphrase != "":
+		priv, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(key.PEMPrivateKey), pp.Bytes())
 	default:
+		// Encrypted keys parsed without a passphrase fail with
+		// ssh.PassphraseMissingError.
 		priv, err = ssh.ParseRawPrivateKey([]byte(key.PEMPrivateKey))
 	}
-	// Forward incorrect password errors on directly.
-	if err != nil && errors.Is(err, x509.IncorrectPasswordError) {
-		return "", fmt.Errorf("failed to parse private key: %w", err)
-	}
-	// Wrap all other non-specific errors.
 	if err != nil {
-		return "", fmt.Errorf("%w: %w", errParseFailed, err)
+		return nil, classifyDecryptError(err)
 	}
+	return encodeDecryptedKey(priv)
+}
 
+// encodeDecryptedKey returns the PKCS#8 encoding of a private key.
+func encodeDecryptedKey(priv interface{}) (decryptedKey, error) {
 	// Workaround for https://github.com/google/chrome-ssh-agent/issues/28.
 	// In the case of ed25519 keys, ssh.ParseRawPublicKey() will return a
 	// *ed25519.PrivateKey (pointer), but x509.MarshalPKCS8PrivateKey()
@@ -426,35 +1653,137 @@ func decryptKey(key *storedKey, passphrase string) (decryptedKey, error) {
 	// Marshal to PKCS#8 format.
 	buf, err := x509.MarshalPKCS8PrivateKey(priv)
 	if err != nil {
-		return "", fmt.Errorf("%w: %w", errMarshalFailed, err)
+		return nil, fmt.Errorf("%w: %w", errMarshalFailed, err)
 	}
+	der := newSecret(buf)
+	defer der.Wipe()
 
+	// The encoded key is returned without copying it, such that only the
+	// caller's copy need be wiped.
 	return decryptedKey(pem.EncodeToMemory(&pem.Block{
 		Type:  pkcs8BlockType,
-		Bytes: buf,
+		Bytes: der.Bytes(),
 	})), nil
 }
 
+// pkcs8IncorrectPassword is the message of the error returned by the pkcs8
+// package for an incorrect password. The package does not export the error.
+const pkcs8IncorrectPassword = "pkcs8: incorrect password"
+
+// classifyDecryptError wraps an error encountered while decrypting and parsing
+// a private key with ErrPassphraseRequired, ErrWrongPassphrase or
+// ErrKeyCorrupt as appropriate.
+func classifyDecryptError(err error) error {
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case errors.As(err, &missing):
+		return fmt.Errorf("%w: %w", ErrPassphraseRequired, err)
+	case errors.Is(err, x509.IncorrectPasswordError), err.Error() == pkcs8IncorrectPassword:
+		return fmt.Errorf("%w: %w", ErrWrongPassphrase, err)
+	case errors.Is(err, errPPKUnsupported):
+		// The key may be valid, but we cannot use it.
+		return err
+	default:
+		return fmt.Errorf("%w: %w: %w", ErrKeyCorrupt, errParseFailed, err)
+	}
+}
+
 func parseDecryptedKey(pemPrivateKey decryptedKey) (interface{}, error) {
-	return ssh.ParseRawPrivateKey([]byte(pemPrivateKey))
+	return ssh.ParseRawPrivateKey(pemPrivateKey)
+}
+
+// recordPublicKeys stores the public keys for configured keys whose public key
+// was not known when they were added, using a single write. keys maps the ID
+// of each key to its decrypted private key. Failures are logged, since the
+// public key is not required to use the key.
+func (m *DefaultManager) recordPublicKeys(ctx jsutil.AsyncContext, keys map[ID]decryptedKey) {
+	if len(keys) == 0 {
+		return
+	}
+	_, err := m.storedKeys.Update(ctx, func(sk *storedKey) bool {
+		_, ok := keys[ID(sk.ID)]
+		return ok && sk.PublicKey == ""
+	}, func(sk *storedKey) (*storedKey, error) {
+		priv, err := parseDecryptedKey(keys[ID(sk.ID)])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errParseFailed, err)
+		}
+		updated := *sk
+		if err := updated.setPublicFromPrivate(priv); err != nil {
+			return nil, err
+		}
+		return &updated, nil
+	})
+	if err != nil {
+		jsutil.LogError("failed to record public keys: %v", err)
+	}
 }
 
-func (m *DefaultManager) addToAgent(id ID, key decryptedKey) error {
+// addToAgent adds the key to the agent. If cert is not nil, the certificate is
+// also added as a separate identity.
+func (m *DefaultManager) addToAgent(id ID, key decryptedKey, comment string, cert *ssh.Certificate) error {
 	priv, err := parseDecryptedKey(key)
 	if err != nil {
 		return err
 	}
 
-	err = m.agent.Add(agent.AddedKey{
-		PrivateKey: priv,
-		Comment:    fmt.Sprintf("%s%s", commentPrefix, id),
-	})
+	signer, err := ssh.NewSignerFromKey(priv)
 	if err != nil {
+		return fmt.Errorf("%w: %w", errParseFailed, err)
+	}
+	pubs := []ssh.PublicKey{signer.PublicKey()}
+	if cert != nil {
+		if err := checkCertificate(cert, signer.PublicKey()); err != nil {
+			return err
+		}
+		if certificateExpired(cert, m.now()) {
+			jsutil.LogError("certificate for key ID %s has expired", id)
+		}
+		pubs = append(pubs, cert)
+	}
+	// Check both identities up front, such that the key is not loaded
+	// without its certificate.
+	if err := m.agent.checkCapacity(pubs...); err != nil {
+		return err
+	}
+
+	added := agent.AddedKey{
+		PrivateKey: priv,
+		Comment:    agentComment(id, comment),
+	}
+	if err := m.agent.Add(added); err != nil {
 		return fmt.Errorf("failed to add key to agent: %w", err)
 	}
+	if cert != nil {
+		added.Certificate = cert
+		if err := m.agent.Add(added); err != nil {
+			return fmt.Errorf("failed to add certificate to agent: %w", err)
+		}
+	}
 	return nil
 }
 
+// removeFromAgent removes all identities for the key with the specified ID
+// from the agent; that is, both the key and its certificate. It returns false
+// if the key is not loaded.
+func (m *DefaultManager) removeFromAgent(ctx jsutil.AsyncContext, id ID) (bool, error) {
+	loaded, err := m.Loaded(ctx)
+	if err != nil {
+		return false, fmt.Errorf("%w: failed to enumerate loaded keys: %w", errAgentUnloadFailed, err)
+	}
+	found := false
+	for _, l := range loaded {
+		if l.ID() != id {
+			continue
+		}
+		if err := m.agent.Remove(&agent.Key{Format: l.Type, Blob: l.Blob()}); err != nil {
+			return found, fmt.Errorf("%w: %w", errAgentUnloadFailed, err)
+		}
+		found = true
+	}
+	return found, nil
+}
+
 // Load implements Manager.Load.
 func (m *DefaultManager) Load(ctx jsutil.AsyncContext, id ID, passphrase string) error {
 	key, err := m.storedKeys.Read(ctx, func(key *storedKey) bool { return ID(key.ID) == id })
@@ -466,25 +1795,268 @@ func (m *DefaultManager) Load(ctx jsutil.AsyncContext, id ID, passphrase string)
 		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
 	}
 
-	decrypted, err := decryptKey(key, passphrase)
+	decrypted, err := m.loadIntoAgent(ctx, key, passphrase)
 	if err != nil {
-		return fmt.Errorf("failed to decrypt key: %w", err)
+		return err
+	}
+	defer decrypted.wipe()
+	m.applyConfirmUse(key)
+	m.applyRefuseSHA1(key)
+	m.applyDestinations(key)
+	m.applyPriority(key)
+	if key.PublicKey == "" {
+		m.recordPublicKeys(ctx, map[ID]decryptedKey{id: decrypted})
 	}
+	m.cachePassphrase(ctx, key, passphrase)
 
-	if err := m.addToAgent(id, decrypted); err != nil {
+	sk := m.newSessionKey(id, decrypted, key.Comment, m.persistSession(ctx))
+	if err := m.sessionKeys.Write(ctx, sk); err != nil {
+		return fmt.Errorf("failed to store loaded key to session: %w", err)
+	}
+	m.scheduleIdleUnload(ctx)
+	return nil
+}
+
+// loadIntoAgent decrypts the key using the passphrase, and adds it to the
+// agent. The decrypted key is returned, and is empty for keys held on security
+// keys or by the browser since there is nothing to decrypt.
+func (m *DefaultManager) loadIntoAgent(ctx jsutil.AsyncContext, key *storedKey, passphrase string) (decryptedKey, error) {
+	id := ID(key.ID)
+	if err := m.checkExpiry(ctx, key); err != nil {
+		return nil, err
+	}
+	if key.IsSecurityKey() {
+		return nil, m.describeLoaded(ctx, m.addSecurityKeyToAgent(id, key))
+	}
+	if key.IsCryptoKey() {
+		return nil, m.describeLoaded(ctx, m.addCryptoKeyToAgent(id, key))
+	}
+
+	decrypted, err := m.decryptStoredKey(ctx, key, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key: %w", err)
+	}
+	if err := m.addToAgent(id, decrypted, key.Comment, key.Cert()); err != nil {
+		decrypted.wipe()
+		return nil, m.describeLoaded(ctx, err)
+	}
+	return decrypted, nil
+}
+
+// ChangePassphrase implements Manager.ChangePassphrase.
+func (m *DefaultManager) ChangePassphrase(ctx jsutil.AsyncContext, id ID, oldPassphrase, newPassphrase string) error {
+	if newPassphrase == "" {
+		return fmt.Errorf("%w: passphrase must not be empty", errInvalidPassphrase)
+	}
+
+	enc, err := m.DefaultKeyEncryption(ctx)
+	if err != nil {
 		return err
 	}
 
-	sk := &sessionKey{
-		ID:         string(id),
-		PrivateKey: string(decrypted),
+	// The key is re-encrypted while reading it, such that it is replaced
+	// in a single write. Storage is untouched if decryption fails.
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(key *storedKey) (*storedKey, error) {
+		return reencryptKey(key, oldPassphrase, newPassphrase, enc)
+	})
+	if err != nil {
+		return err
 	}
-	if err := m.sessionKeys.Write(ctx, sk); err != nil {
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+	return nil
+}
+
+var errNameInUse = errors.New("name already in use")
+
+// Rename implements Manager.Rename.
+func (m *DefaultManager) Rename(ctx jsutil.AsyncContext, id ID, newName string) error {
+	if newName == "" {
+		return fmt.Errorf("%w: name must not be empty", errInvalidName)
+	}
+
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read keys: %w", err)
+	}
+	for _, k := range keys {
+		if ID(k.ID) != id && k.Name == newName {
+			return fmt.Errorf("%w: %s", errNameInUse, newName)
+		}
+	}
+
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(key *storedKey) (*storedKey, error) {
+		renamed := *key
+		renamed.Name = newName
+		return &renamed, nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	if _, ok := m.confirmUse[id]; ok {
+		m.confirmUse[id] = newName
+	}
+	if r, ok := m.ranks[id]; ok {
+		m.ranks[id] = &keyRank{Priority: r.Priority, Name: newName}
+	}
+	return nil
+}
+
+// SetComment implements Manager.SetComment.
+func (m *DefaultManager) SetComment(ctx jsutil.AsyncContext, id ID, comment string, passphrase string) error {
+	var decrypted decryptedKey
+	defer func() { decrypted.wipe() }()
+	var cert *ssh.Certificate
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(key *storedKey) (*storedKey, error) {
+		var err error
+		cert = key.Cert()
+		decrypted, err = decryptKey(key, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt key: %w", err)
+		}
+		priv, err := parseDecryptedKey(decrypted)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errParseFailed, err)
+		}
+		// Preserve whether or not the key is encrypted.
+		var block *pem.Block
+		if key.Encrypted() {
+			block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, comment, []byte(passphrase))
+		} else {
+			block, err = ssh.MarshalPrivateKey(priv, comment)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errMarshalFailed, err)
+		}
+		updated := *key
+		updated.PEMPrivateKey = string(pem.EncodeToMemory(block))
+		updated.Comment = comment
+		if err := updated.setPublicFromPrivate(priv); err != nil {
+			return nil, err
+		}
+		return &updated, nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+
+	return m.replaceInAgent(ctx, id, decrypted, comment, cert)
+}
+
+// replaceInAgent replaces the key with the specified ID in the agent such that
+// it has the supplied comment and certificate. The agent does not support
+// modifying a key's comment, so it is removed and added again. Nothing is done
+// if the key is not loaded.
+func (m *DefaultManager) replaceInAgent(ctx jsutil.AsyncContext, id ID, key decryptedKey, comment string, cert *ssh.Certificate) error {
+	found, err := m.removeFromAgent(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	if err := m.addToAgent(id, key, comment, cert); err != nil {
+		return err
+	}
+	persist := m.persistSession(ctx)
+	if _, err := m.sessionKeys.Update(ctx, func(k *sessionKey) bool { return ID(k.ID) == id }, func(k *sessionKey) (*sessionKey, error) {
+		updated := m.newSessionKey(id, key, comment, persist)
+		updated.LoadTime = k.LoadTime
+		updated.LifetimeEnd = k.LifetimeEnd
+		return updated, nil
+	}); err != nil {
 		return fmt.Errorf("failed to store loaded key to session: %w", err)
 	}
 	return nil
 }
 
+// LoadAll implements Manager.LoadAll.
+func (m *DefaultManager) LoadAll(ctx jsutil.AsyncContext, reqs []*LoadRequest) ([]*Result, error) {
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	configured := map[ID]*storedKey{}
+	for _, k := range keys {
+		configured[ID(k.ID)] = k
+	}
+
+	var results []*Result
+	var loaded []*addedKey
+	for _, req := range reqs {
+		r := &Result{ID: req.ID}
+		results = append(results, r)
+
+		key, ok := configured[req.ID]
+		if !ok {
+			r.Err = fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, req.ID)
+			continue
+		}
+		decrypted, err := m.loadIntoAgent(ctx, key, req.Passphrase)
+		if err != nil {
+			r.Err = err
+			continue
+		}
+		loaded = append(loaded, &addedKey{key: key, passphrase: req.Passphrase, decrypted: decrypted, result: r})
+	}
+
+	m.finishLoad(ctx, loaded)
+	return results, nil
+}
+
+// addedKey is a key that was added to the agent by a bulk operation.
+type addedKey struct {
+	key        *storedKey
+	passphrase string
+	decrypted  decryptedKey
+	// result is the result reported for the key.
+	result *Result
+}
+
+// finishLoad applies settings for keys that were added to the agent, and
+// writes them to the session using a single storage operation. The decrypted
+// keys are wiped once done.
+func (m *DefaultManager) finishLoad(ctx jsutil.AsyncContext, loaded []*addedKey) {
+	defer func() {
+		for _, l := range loaded {
+			l.decrypted.wipe()
+		}
+	}()
+	var sks []*sessionKey
+	unknown := map[ID]decryptedKey{}
+	persist := m.persistSession(ctx)
+	for _, l := range loaded {
+		m.applyConfirmUse(l.key)
+		m.applyRefuseSHA1(l.key)
+		m.applyDestinations(l.key)
+		m.applyPriority(l.key)
+		m.cachePassphrase(ctx, l.key, l.passphrase)
+		if l.key.PublicKey == "" {
+			unknown[ID(l.key.ID)] = l.decrypted
+		}
+		sks = append(sks, m.newSessionKey(ID(l.key.ID), l.decrypted, l.key.Comment, persist))
+	}
+
+	// Keys are in the agent regardless, but report the failure against
+	// each of them, as Load does.
+	if err := m.sessionKeys.WriteAll(ctx, sks); err != nil {
+		for _, l := range loaded {
+			l.result.Err = fmt.Errorf("failed to store loaded key to session: %w", err)
+		}
+	}
+	m.recordPublicKeys(ctx, unknown)
+	m.scheduleIdleUnload(ctx)
+}
+
 var (
 	errAgentUnloadFailed   = errors.New("key unload from agent failed")
 	errStorageUnloadFailed = errors.New("key removal from session storage failed")
@@ -496,33 +2068,27 @@ func (m *DefaultManager) Unload(ctx jsutil.AsyncContext, id ID) error {
 		return fmt.Errorf("%w: invalid id", errAgentUnloadFailed)
 	}
 
-	loaded, err := m.Loaded(ctx)
+	found, err := m.removeFromAgent(ctx, id)
 	if err != nil {
-		return fmt.Errorf("%w: failed to enumerate loaded keys: %v", errAgentUnloadFailed, id)
-	}
-
-	var lk *LoadedKey
-	for _, l := range loaded {
-		if l.ID() == id {
-			lk = l
-			break
-		}
+		return err
 	}
-	if lk == nil {
+	if !found {
 		return fmt.Errorf("%w: invalid id: %s", errAgentUnloadFailed, id)
 	}
 
-	pub := &agent.Key{
-		Format: lk.Type,
-		Blob:   lk.Blob(),
-	}
-	if err := m.agent.Remove(pub); err != nil {
-		return fmt.Errorf("%w: %w", errAgentUnloadFailed, err)
-	}
-
 	if err := m.sessionKeys.Delete(ctx, func(sk *sessionKey) bool { return ID(sk.ID) == id }); err != nil {
 		return fmt.Errorf("%w: %w", errStorageUnloadFailed, err)
 	}
+	ephemeral := m.isEphemeral(id)
+	m.forgetLoaded(id)
+	// An unloaded key should not be loaded again automatically.
+	m.forgetPassphrases(ctx, id)
+	m.forgetUse(ctx, id)
+	if ephemeral {
+		// Ephemeral keys are not stored, so the change must be
+		// reported explicitly.
+		m.checkChanges(ctx)
+	}
 
 	return nil
 }
@@ -0,0 +1,424 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// MasterKeyState indicates whether keys are protected by a master passphrase.
+type MasterKeyState string
+
+const (
+	// MasterKeyDisabled indicates that each key has its own passphrase.
+	MasterKeyDisabled MasterKeyState = "disabled"
+	// MasterKeyLocked indicates that keys protected by the master
+	// passphrase cannot be loaded until the master key is unlocked.
+	MasterKeyLocked MasterKeyState = "locked"
+	// MasterKeyUnlocked indicates that keys protected by the master
+	// passphrase may be loaded without a passphrase.
+	MasterKeyUnlocked MasterKeyState = "unlocked"
+)
+
+// ErrLocked indicates that a key is protected by the master passphrase, but
+// the master key is locked. Its identity is preserved by the client returned
+// by NewClient.
+var ErrLocked = errors.New("master key is locked")
+
+var (
+	errMasterKeyDisabled    = errors.New("master key is not enabled")
+	errProtectedByMasterKey = errors.New("key is protected by the master passphrase")
+)
+
+// masterKey is the raw object stored in persistent storage when keys are
+// protected by a master passphrase. Keys are encrypted using a random
+// data-encryption key, which is itself encrypted using a key derived from the
+// master passphrase. Changing the master passphrase therefore need not
+// re-encrypt every key.
+type masterKey struct {
+	// WrappedKey is the encrypted data-encryption key, as returned by
+	// sealWithPassphrase.
+	WrappedKey string `js:"wrappedKey"`
+}
+
+// unlockedDataKey is the raw object stored in session storage while the master
+// key is unlocked. Just as for loaded keys, it is stored decrypted such that
+// we can resume without prompting if we are suspended by the browser.
+type unlockedDataKey struct {
+	// Key is the base64-encoded data-encryption key.
+	Key string `js:"key"`
+}
+
+var (
+	// masterKeyPrefixes is the prefix for the master key stored in
+	// persistent storage.
+	masterKeyPrefixes = []string{"masterKey"}
+	// dataKeyPrefixes is the prefix for the data-encryption key stored
+	// in-memory for our current session.
+	dataKeyPrefixes = []string{"dataKey"}
+)
+
+const (
+	// masterKeyKey is the storage key for masterKey and unlockedDataKey.
+	masterKeyKey = "master"
+	// dataKeyBytes is the size of the data-encryption key.
+	dataKeyBytes = 32
+
+	// masterKeyBlockType is the PEM block type for the encrypted
+	// data-encryption key.
+	masterKeyBlockType = "CHROME-SSH-AGENT MASTER KEY"
+	// wrappedKeyBlockType is the PEM block type for keys stored in
+	// FormatMasterKey. The block contains the AES-256-GCM encrypted
+	// private key in the OpenSSH format.
+	wrappedKeyBlockType = "CHROME-SSH-AGENT WRAPPED PRIVATE KEY"
+)
+
+// isWrapped determines if the private key is stored in FormatMasterKey.
+func isWrapped(privateKey string) bool {
+	block, _ := pem.Decode([]byte(privateKey))
+	return block != nil && block.Type == wrappedKeyBlockType
+}
+
+// wrapKey encrypts priv using the data-encryption key, and returns it in
+// FormatMasterKey.
+func wrapKey(priv interface{}, comment string, dek []byte) (string, error) {
+	inner, err := ssh.MarshalPrivateKey(priv, comment)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errMarshalFailed, err)
+	}
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	plaintext := pem.EncodeToMemory(inner)
+	defer clear(plaintext)
+	return sealBlock(gcm, wrappedKeyBlockType, map[string]string{}, plaintext)
+}
+
+// unwrapKey decrypts a private key stored in FormatMasterKey.
+func unwrapKey(privateKey string, dek []byte) (interface{}, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil || block.Type != wrappedKeyBlockType {
+		return nil, fmt.Errorf("%w: %w: not a wrapped key", ErrKeyCorrupt, errDecodeFailed)
+	}
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	// Authentication fails if the key was wrapped using a different
+	// master key, which there is no way to recover.
+	inner, err := openBlock(gcm, block)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrKeyCorrupt, err)
+	}
+	priv, err := ssh.ParseRawPrivateKey(inner)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w: %w", ErrKeyCorrupt, errParseFailed, err)
+	}
+	return priv, nil
+}
+
+// decryptStoredKey decrypts a configured key. Keys stored in FormatMasterKey
+// are decrypted using the unlocked data-encryption key, and the passphrase is
+// ignored.
+func (m *DefaultManager) decryptStoredKey(ctx jsutil.AsyncContext, key *storedKey, passphrase string) (decryptedKey, error) {
+	if !isWrapped(key.PEMPrivateKey) {
+		return decryptKey(key, passphrase)
+	}
+	dek, err := m.unlockedDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := unwrapKey(key.PEMPrivateKey, dek)
+	if err != nil {
+		return nil, err
+	}
+	return encodeDecryptedKey(priv)
+}
+
+// unlockedDataKey returns the data-encryption key if the master key is
+// unlocked, or ErrLocked otherwise.
+func (m *DefaultManager) unlockedDataKey(ctx jsutil.AsyncContext) ([]byte, error) {
+	u, err := m.dataKey.ReadKey(ctx, masterKeyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data-encryption key: %w", err)
+	}
+	if u == nil {
+		return nil, ErrLocked
+	}
+	dek, err := base64.StdEncoding.DecodeString(u.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode data-encryption key: %w", err)
+	}
+	return dek, nil
+}
+
+// openMasterKey returns the data-encryption key, decrypted using the master
+// passphrase. It returns errMasterKeyDisabled if there is no master key.
+func (m *DefaultManager) openMasterKey(ctx jsutil.AsyncContext, masterPassphrase string) ([]byte, error) {
+	mk, err := m.masterKey.ReadKey(ctx, masterKeyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key: %w", err)
+	}
+	if mk == nil {
+		return nil, errMasterKeyDisabled
+	}
+	if masterPassphrase == "" {
+		return nil, fmt.Errorf("%w: master key is encrypted", ErrPassphraseRequired)
+	}
+	dek, err := openWithPassphrase(masterKeyBlockType, mk.WrappedKey, masterPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt master key: %w", err)
+	}
+	if len(dek) != dataKeyBytes {
+		return nil, fmt.Errorf("%w: invalid data-encryption key size %d", ErrKeyCorrupt, len(dek))
+	}
+	return dek, nil
+}
+
+// storeDataKey unlocks the master key for the current session.
+func (m *DefaultManager) storeDataKey(ctx jsutil.AsyncContext, dek []byte) error {
+	u := &unlockedDataKey{Key: base64.StdEncoding.EncodeToString(dek)}
+	if err := m.dataKey.WriteKey(ctx, masterKeyKey, u); err != nil {
+		return fmt.Errorf("failed to store data-encryption key: %w", err)
+	}
+	return nil
+}
+
+// MasterKeyState implements Manager.MasterKeyState.
+func (m *DefaultManager) MasterKeyState(ctx jsutil.AsyncContext) (MasterKeyState, error) {
+	mk, err := m.masterKey.ReadKey(ctx, masterKeyKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read master key: %w", err)
+	}
+	if mk == nil {
+		return MasterKeyDisabled, nil
+	}
+	u, err := m.dataKey.ReadKey(ctx, masterKeyKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read data-encryption key: %w", err)
+	}
+	if u == nil {
+		return MasterKeyLocked, nil
+	}
+	return MasterKeyUnlocked, nil
+}
+
+// passphrasesByID indexes the passphrases supplied in reqs by key ID.
+func passphrasesByID(reqs []*LoadRequest) map[ID]string {
+	res := map[ID]string{}
+	for _, r := range reqs {
+		res[r.ID] = r.Passphrase
+	}
+	return res
+}
+
+// replaceStoredKeys replaces the configured keys with the updated versions,
+// using a single write.
+func (m *DefaultManager) replaceStoredKeys(ctx jsutil.AsyncContext, updated map[ID]*storedKey) error {
+	if len(updated) == 0 {
+		return nil
+	}
+	_, err := m.storedKeys.Update(ctx, func(key *storedKey) bool {
+		_, ok := updated[ID(key.ID)]
+		return ok
+	}, func(key *storedKey) (*storedKey, error) {
+		return updated[ID(key.ID)], nil
+	})
+	return err
+}
+
+// EnableMasterKey implements Manager.EnableMasterKey.
+func (m *DefaultManager) EnableMasterKey(ctx jsutil.AsyncContext, masterPassphrase string, reqs []*LoadRequest) ([]*Result, error) {
+	if masterPassphrase == "" {
+		return nil, fmt.Errorf("%w: passphrase must not be empty", errInvalidPassphrase)
+	}
+	// Each key is migrated, and deriving keys from passphrases is slow.
+	defer m.keepAlive.Acquire()()
+
+	dek, err := m.openMasterKey(ctx, masterPassphrase)
+	if errors.Is(err, errMasterKeyDisabled) {
+		dek = make([]byte, dataKeyBytes)
+		if _, err := rand.Read(dek); err != nil {
+			return nil, fmt.Errorf("failed to generate data-encryption key: %w", err)
+		}
+		wrapped, err := sealWithPassphrase(masterKeyBlockType, dek, masterPassphrase, defaultArgon2Params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt master key: %w", err)
+		}
+		// The master key is stored before any key is wrapped using it,
+		// such that wrapped keys can always be recovered.
+		if err := m.masterKey.WriteKey(ctx, masterKeyKey, &masterKey{WrappedKey: wrapped}); err != nil {
+			return nil, fmt.Errorf("failed to store master key: %w", err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if err := m.storeDataKey(ctx, dek); err != nil {
+		return nil, err
+	}
+
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	passphrases := passphrasesByID(reqs)
+	var results []*Result
+	updated := map[ID]*storedKey{}
+	for _, k := range keys {
+		if k.IsSecurityKey() || k.IsCryptoKey() || isWrapped(k.PEMPrivateKey) {
+			continue
+		}
+		r := &Result{ID: ID(k.ID)}
+		results = append(results, r)
+		decrypted, err := decryptKey(k, passphrases[r.ID])
+		if err != nil {
+			r.Err = fmt.Errorf("failed to decrypt key: %w", err)
+			continue
+		}
+		priv, err := parseDecryptedKey(decrypted)
+		decrypted.wipe()
+		if err != nil {
+			r.Err = fmt.Errorf("%w: %w", errParseFailed, err)
+			continue
+		}
+		wrapped, err := wrapKey(priv, k.Comment, dek)
+		if err != nil {
+			r.Err = err
+			continue
+		}
+		u := *k
+		u.PEMPrivateKey = wrapped
+		if err := u.setPublicFromPrivate(priv); err != nil {
+			r.Err = err
+			continue
+		}
+		updated[r.ID] = &u
+	}
+	if err := m.replaceStoredKeys(ctx, updated); err != nil {
+		return nil, fmt.Errorf("failed to write keys: %w", err)
+	}
+	// Keys no longer require a passphrase to load automatically.
+	var ids []ID
+	for id := range updated {
+		ids = append(ids, id)
+	}
+	m.forgetPassphrases(ctx, ids...)
+	return results, nil
+}
+
+// DisableMasterKey implements Manager.DisableMasterKey.
+func (m *DefaultManager) DisableMasterKey(ctx jsutil.AsyncContext, masterPassphrase string, reqs []*LoadRequest) ([]*Result, error) {
+	// Each key is migrated, and deriving keys from passphrases is slow.
+	defer m.keepAlive.Acquire()()
+
+	dek, err := m.openMasterKey(ctx, masterPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := m.DefaultKeyEncryption(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	passphrases := passphrasesByID(reqs)
+	var results []*Result
+	updated := map[ID]*storedKey{}
+	for _, k := range keys {
+		if !isWrapped(k.PEMPrivateKey) {
+			continue
+		}
+		r := &Result{ID: ID(k.ID)}
+		results = append(results, r)
+		passphrase, ok := passphrases[r.ID]
+		if !ok {
+			r.Err = fmt.Errorf("%w: no passphrase supplied for key", ErrPassphraseRequired)
+			continue
+		}
+		priv, err := unwrapKey(k.PEMPrivateKey, dek)
+		if err != nil {
+			r.Err = err
+			continue
+		}
+		var restored string
+		if passphrase == "" {
+			block, err := ssh.MarshalPrivateKey(priv, k.Comment)
+			if err != nil {
+				r.Err = fmt.Errorf("%w: %w", errMarshalFailed, err)
+				continue
+			}
+			restored = string(pem.EncodeToMemory(block))
+		} else if restored, err = encryptPrivateKey(priv, k.Comment, passphrase, enc); err != nil {
+			r.Err = err
+			continue
+		}
+		u := *k
+		u.PEMPrivateKey = restored
+		updated[r.ID] = &u
+	}
+	if err := m.replaceStoredKeys(ctx, updated); err != nil {
+		return nil, fmt.Errorf("failed to write keys: %w", err)
+	}
+
+	// The master key is required for as long as any key is wrapped
+	// using it.
+	for _, r := range results {
+		if r.Err != nil {
+			return results, nil
+		}
+	}
+	if err := m.masterKey.Delete(ctx, func(*masterKey) bool { return true }); err != nil {
+		return nil, fmt.Errorf("failed to remove master key: %w", err)
+	}
+	if err := m.LockMasterKey(ctx); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// UnlockMasterKey implements Manager.UnlockMasterKey.
+func (m *DefaultManager) UnlockMasterKey(ctx jsutil.AsyncContext, masterPassphrase string) error {
+	dek, err := m.openMasterKey(ctx, masterPassphrase)
+	if err != nil {
+		return err
+	}
+	return m.storeDataKey(ctx, dek)
+}
+
+// LockMasterKey implements Manager.LockMasterKey.
+func (m *DefaultManager) LockMasterKey(ctx jsutil.AsyncContext) error {
+	if err := m.dataKey.Delete(ctx, func(*unlockedDataKey) bool { return true }); err != nil {
+		return fmt.Errorf("failed to remove data-encryption key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestMasterKey(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "openssh", PEMPrivateKey: testdata.OpenSSHFormat.Private},
+			{Name: "pkcs8", PEMPrivateKey: testdata.PKCS8Format.Private},
+			{Name: "ecdsa", PEMPrivateKey: testdata.ECDSAWithPassphrase.Private},
+			{Name: "unencrypted", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		ids := map[string]ID{}
+		for _, name := range []string{"openssh", "pkcs8", "ecdsa", "unencrypted"} {
+			if ids[name], err = findKey(ctx, mgr, InvalidID, name); err != nil {
+				t.Fatalf("failed to find key %s: %v", name, err)
+			}
+		}
+
+		// expectState checks the state of the master key.
+		expectState := func(description string, mgr *DefaultManager, want MasterKeyState) {
+			t.Helper()
+			state, err := mgr.MasterKeyState(ctx)
+			if err != nil {
+				t.Errorf("%s: MasterKeyState failed: %v", description, err)
+			}
+			if state != want {
+				t.Errorf("%s: incorrect state; got %s, want %s", description, state, want)
+			}
+		}
+		// expectFailed checks which results report an error.
+		expectFailed := func(description string, results []*Result, want map[ID]bool) {
+			t.Helper()
+			failed := map[ID]bool{}
+			for _, r := range results {
+				failed[r.ID] = r.Err != nil
+			}
+			if diff := cmp.Diff(failed, want); diff != "" {
+				t.Errorf("%s: incorrect results; -got +want: %s", description, diff)
+			}
+		}
+		// expectFormats checks the format in which each key is stored.
+		expectFormats := func(description string, want map[string]string) {
+			t.Helper()
+			configured, err := mgr.Configured(ctx)
+			if err != nil {
+				t.Fatalf("%s: failed to get configured keys: %v", description, err)
+			}
+			formats := map[string]string{}
+			for _, k := range configured {
+				formats[k.Name] = k.Format
+			}
+			if diff := cmp.Diff(formats, want); diff != "" {
+				t.Errorf("%s: incorrect formats; -got +want: %s", description, diff)
+			}
+		}
+
+		expectState("initial", mgr, MasterKeyDisabled)
+		if err := mgr.UnlockMasterKey(ctx, "master"); !errors.Is(err, errMasterKeyDisabled) {
+			t.Errorf("UnlockMasterKey returned incorrect error before enabling: %v", err)
+		}
+
+		// Keys whose passphrase is incorrect keep their own passphrase.
+		results, err := mgr.EnableMasterKey(ctx, "master", []*LoadRequest{
+			{ID: ids["openssh"], Passphrase: "secret"},
+			{ID: ids["pkcs8"], Passphrase: "secret"},
+			{ID: ids["ecdsa"], Passphrase: "wrong"},
+		})
+		if err != nil {
+			t.Fatalf("EnableMasterKey failed: %v", err)
+		}
+		expectFailed("enable", results, map[ID]bool{
+			ids["openssh"]:     false,
+			ids["pkcs8"]:       false,
+			ids["ecdsa"]:       true,
+			ids["unencrypted"]: false,
+		})
+		expectFormats("enable", map[string]string{
+			"openssh":     FormatMasterKey,
+			"pkcs8":       FormatMasterKey,
+			"ecdsa":       FormatSEC1,
+			"unencrypted": FormatMasterKey,
+		})
+		expectState("enable", mgr, MasterKeyUnlocked)
+
+		// Protected keys load without a passphrase while unlocked.
+		if err := mgr.Load(ctx, ids["pkcs8"], ""); err != nil {
+			t.Errorf("Load failed: %v", err)
+		}
+		if err := mgr.Unload(ctx, ids["pkcs8"]); err != nil {
+			t.Errorf("Unload failed: %v", err)
+		}
+
+		if err := mgr.LockMasterKey(ctx); err != nil {
+			t.Errorf("LockMasterKey failed: %v", err)
+		}
+		expectState("lock", mgr, MasterKeyLocked)
+		if err := mgr.Load(ctx, ids["pkcs8"], "secret"); !errors.Is(err, ErrLocked) {
+			t.Errorf("Load returned incorrect error while locked: %v", err)
+		}
+		if err := mgr.UnlockMasterKey(ctx, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("UnlockMasterKey returned incorrect error for wrong passphrase: %v", err)
+		}
+		if err := mgr.UnlockMasterKey(ctx, "master"); err != nil {
+			t.Errorf("UnlockMasterKey failed: %v", err)
+		}
+		if err := mgr.Load(ctx, ids["pkcs8"], ""); err != nil {
+			t.Errorf("Load failed after unlocking: %v", err)
+		}
+
+		// Enabling again protects the remaining keys, provided the master
+		// passphrase matches.
+		if _, err := mgr.EnableMasterKey(ctx, "wrong", nil); !errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("EnableMasterKey returned incorrect error for wrong passphrase: %v", err)
+		}
+		results, err = mgr.EnableMasterKey(ctx, "master", []*LoadRequest{
+			{ID: ids["ecdsa"], Passphrase: "secret"},
+		})
+		if err != nil {
+			t.Fatalf("EnableMasterKey failed: %v", err)
+		}
+		expectFailed("enable again", results, map[ID]bool{ids["ecdsa"]: false})
+
+		// The master key is synced, but must be unlocked on each device.
+		other := NewManager(agent.NewKeyring(), syncStorage, storage.NewRaw(st.NewMemArea()))
+		expectState("other device", other, MasterKeyLocked)
+		if err := other.Load(ctx, ids["ecdsa"], ""); !errors.Is(err, ErrLocked) {
+			t.Errorf("Load on other device returned incorrect error: %v", err)
+		}
+		if err := other.UnlockMasterKey(ctx, "master"); err != nil {
+			t.Errorf("UnlockMasterKey on other device failed: %v", err)
+		}
+		if err := other.Load(ctx, ids["ecdsa"], ""); err != nil {
+			t.Errorf("Load on other device failed: %v", err)
+		}
+
+		// Disabling is incomplete until each key has a passphrase again.
+		restore := []*LoadRequest{
+			{ID: ids["openssh"], Passphrase: "new-secret"},
+			{ID: ids["pkcs8"], Passphrase: "new-secret"},
+			{ID: ids["ecdsa"], Passphrase: "new-secret"},
+		}
+		results, err = mgr.DisableMasterKey(ctx, "master", restore)
+		if err != nil {
+			t.Fatalf("DisableMasterKey failed: %v", err)
+		}
+		expectFailed("partial disable", results, map[ID]bool{
+			ids["openssh"]:     false,
+			ids["pkcs8"]:       false,
+			ids["ecdsa"]:       false,
+			ids["unencrypted"]: true,
+		})
+		expectState("partial disable", mgr, MasterKeyUnlocked)
+
+		results, err = mgr.DisableMasterKey(ctx, "master", []*LoadRequest{
+			{ID: ids["unencrypted"]},
+		})
+		if err != nil {
+			t.Fatalf("DisableMasterKey failed: %v", err)
+		}
+		expectFailed("disable", results, map[ID]bool{ids["unencrypted"]: false})
+		expectState("disable", mgr, MasterKeyDisabled)
+		expectFormats("disable", map[string]string{
+			"openssh":     FormatOpenSSH,
+			"pkcs8":       FormatOpenSSH,
+			"ecdsa":       FormatOpenSSH,
+			"unencrypted": FormatOpenSSH,
+		})
+		if err := mgr.Load(ctx, ids["openssh"], ""); !errors.Is(err, ErrPassphraseRequired) {
+			t.Errorf("Load returned incorrect error without passphrase: %v", err)
+		}
+		if err := mgr.Load(ctx, ids["openssh"], "new-secret"); err != nil {
+			t.Errorf("Load failed: %v", err)
+		}
+		if err := mgr.Load(ctx, ids["unencrypted"], ""); err != nil {
+			t.Errorf("Load failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+const (
+	// maxConcurrentDecrypts is the number of decryption attempts that
+	// LoadMatching runs at once.
+	maxConcurrentDecrypts = 4
+)
+
+// MatchResults is the outcome of LoadMatching.
+type MatchResults struct {
+	// Loaded are the IDs of the keys that were decrypted using the
+	// passphrase and loaded into the agent.
+	Loaded []ID
+	// Locked are the keys that remain locked, along with the reason. This
+	// is typically ErrWrongPassphrase.
+	Locked []*Result
+}
+
+// decryptAttempt is the outcome of attempting to decrypt a key.
+type decryptAttempt struct {
+	key       *storedKey
+	decrypted decryptedKey
+	err       error
+}
+
+// decryptEach attempts to decrypt each of the keys using the passphrase. At
+// most maxConcurrentDecrypts attempts run at once, and each yields to the
+// event loop before starting such that the agent continues to handle requests.
+// An attempt is returned for each key, in the same order.
+func decryptEach(ctx jsutil.AsyncContext, keys []*storedKey, passphrase string) []*decryptAttempt {
+	attempts := make([]*decryptAttempt, len(keys))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(maxConcurrentDecrypts, len(keys)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			y := &yielder{}
+			for i := range next {
+				y.maybeYield()
+				decrypted, err := decryptKey(keys[i], passphrase)
+				attempts[i] = &decryptAttempt{key: keys[i], decrypted: decrypted, err: err}
+			}
+		}()
+	}
+	for i := range keys {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return attempts
+}
+
+// LoadMatching implements Manager.LoadMatching.
+func (m *DefaultManager) LoadMatching(ctx jsutil.AsyncContext, passphrase string) (*MatchResults, error) {
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	loaded, err := m.Loaded(ctx)
+	if err != nil {
+		return nil, err
+	}
+	isLoaded := map[ID]bool{}
+	for _, l := range loaded {
+		isLoaded[l.ID()] = true
+	}
+
+	var locked []*storedKey
+	for _, k := range keys {
+		if isLoaded[ID(k.ID)] || k.IsSecurityKey() || !k.Encrypted() {
+			continue
+		}
+		locked = append(locked, k)
+	}
+
+	results := &MatchResults{}
+	var added []*addedKey
+	for _, a := range decryptEach(ctx, locked, passphrase) {
+		id := ID(a.key.ID)
+		if a.err != nil {
+			results.Locked = append(results.Locked, &Result{ID: id, Err: fmt.Errorf("failed to decrypt key: %w", a.err)})
+			continue
+		}
+		r := &Result{ID: id}
+		if err := m.checkExpiry(ctx, a.key); err != nil {
+			a.decrypted.wipe()
+			r.Err = err
+			results.Locked = append(results.Locked, r)
+			continue
+		}
+		if err := m.addToAgent(id, a.decrypted, a.key.Comment, a.key.Cert()); err != nil {
+			a.decrypted.wipe()
+			r.Err = err
+			results.Locked = append(results.Locked, r)
+			continue
+		}
+		added = append(added, &addedKey{key: a.key, passphrase: passphrase, decrypted: a.decrypted, result: r})
+	}
+
+	m.finishLoad(ctx, added)
+	for _, a := range added {
+		// The key is in the agent even if it could not be stored to
+		// the session.
+		if a.result.Err != nil {
+			jsutil.LogError("Key ID %s: %v", a.result.ID, a.result.Err)
+		}
+		results.Loaded = append(results.Loaded, a.result.ID)
+	}
+	return results, nil
+}
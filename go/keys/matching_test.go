@@ -0,0 +1,109 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestLoadMatching(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "rsa", PEMPrivateKey: testdata.WithPassphrase.Private},
+			{Name: "ecdsa", PEMPrivateKey: testdata.ECDSAWithPassphrase.Private},
+			{Name: "ed25519", PEMPrivateKey: testdata.ED25519WithPassphrase.Private},
+			{Name: "pkcs8", PEMPrivateKey: testdata.PKCS8Format.Private},
+			{Name: "unencrypted", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Errorf("failed to initialize manager: %v", err)
+			return
+		}
+		ids := map[string]ID{}
+		for _, name := range []string{"rsa", "ecdsa", "ed25519", "pkcs8", "unencrypted"} {
+			id, err := findKey(ctx, mgr, InvalidID, name)
+			if err != nil {
+				t.Errorf("failed to find key: %v", err)
+				return
+			}
+			ids[name] = id
+		}
+		if err := mgr.ChangePassphrase(ctx, ids["ed25519"], testdata.ED25519WithPassphrase.Passphrase, "other-secret"); err != nil {
+			t.Errorf("failed to change passphrase: %v", err)
+			return
+		}
+
+		// Keys that the passphrase decrypts are loaded; the others remain
+		// locked. Unencrypted keys are not loaded.
+		results, err := mgr.LoadMatching(ctx, "secret")
+		if err != nil {
+			t.Errorf("LoadMatching failed: %v", err)
+			return
+		}
+		if diff := cmp.Diff(results.Loaded, []ID{ids["rsa"], ids["ecdsa"], ids["pkcs8"]}, idSlice); diff != "" {
+			t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+		}
+		wantLocked := []*Result{{ID: ids["ed25519"], Err: ErrWrongPassphrase}}
+		if diff := cmp.Diff(results.Locked, wantLocked, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect locked keys; -got +want: %s", diff)
+		}
+
+		loaded, err := mgr.Loaded(ctx)
+		if err != nil {
+			t.Errorf("failed to get loaded keys: %v", err)
+			return
+		}
+		wantBlobs := []string{testdata.WithPassphrase.Blob, testdata.ECDSAWithPassphrase.Blob, testdata.PKCS8Format.Blob}
+		if diff := cmp.Diff(loadedKeyBlobs(loaded), wantBlobs, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+			t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+		}
+
+		// Keys that are already loaded are not attempted again.
+		results, err = mgr.LoadMatching(ctx, "other-secret")
+		if err != nil {
+			t.Errorf("LoadMatching failed: %v", err)
+			return
+		}
+		want := &MatchResults{Loaded: []ID{ids["ed25519"]}}
+		if diff := cmp.Diff(results, want); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+
+		// Nothing remains to be loaded.
+		results, err = mgr.LoadMatching(ctx, "secret")
+		if err != nil {
+			t.Errorf("LoadMatching failed: %v", err)
+			return
+		}
+		if diff := cmp.Diff(results, &MatchResults{}); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+var errInvalidNativeHost = errors.New("invalid native messaging host name")
+
+// validNativeHost determines if name is a valid native messaging host name:
+// lowercase alphanumeric characters, underscores and dots, where the name
+// neither starts nor ends with a dot, and dots are not consecutive. See:
+//
+//	https://developer.chrome.com/docs/extensions/develop/concepts/native-messaging#native-messaging-host
+func validNativeHost(name string) bool {
+	if name == "" || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// nativeHosts returns the names of the native messaging hosts to which the
+// agent connects.
+func nativeHosts(s *managerSettings) []string {
+	if s == nil {
+		return nil
+	}
+	return slices.Clone(s.NativeHosts)
+}
+
+// NativeHosts implements Manager.NativeHosts.
+func (m *DefaultManager) NativeHosts(ctx jsutil.AsyncContext) ([]string, error) {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings: %w", err)
+	}
+	return nativeHosts(s), nil
+}
+
+// AllowNativeHost implements Manager.AllowNativeHost.
+func (m *DefaultManager) AllowNativeHost(ctx jsutil.AsyncContext, name string) error {
+	if !validNativeHost(name) {
+		return fmt.Errorf("%w: %s", errInvalidNativeHost, name)
+	}
+	return m.updateSettings(ctx, func(s *managerSettings) {
+		if !slices.Contains(s.NativeHosts, name) {
+			s.NativeHosts = append(s.NativeHosts, name)
+		}
+	})
+}
+
+// DisallowNativeHost implements Manager.DisallowNativeHost.
+func (m *DefaultManager) DisallowNativeHost(ctx jsutil.AsyncContext, name string) error {
+	return m.updateSettings(ctx, func(s *managerSettings) {
+		s.NativeHosts = slices.DeleteFunc(s.NativeHosts, func(h string) bool { return h == name })
+	})
+}
+
+// WatchNativeHosts invokes f with the names of the native messaging hosts to
+// which the agent connects, and again whenever settings change, including
+// when changed from another device.
+//
+// The returned cleanup function must be invoked to stop watching for changes.
+func (m *DefaultManager) WatchNativeHosts(ctx jsutil.AsyncContext, f func(ctx jsutil.AsyncContext, hosts []string)) (jsutil.CleanupFunc, error) {
+	hosts, err := m.NativeHosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f(ctx, hosts)
+	return m.settings.Watch(func(_ map[string]js.Value, _ []string) {
+		jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+			hosts, err := m.NativeHosts(ctx)
+			if err != nil {
+				jsutil.LogError("failed to read native messaging hosts: %v", err)
+				return js.Undefined(), nil
+			}
+			f(ctx, hosts)
+			return js.Undefined(), nil
+		})
+	}), nil
+}
@@ -0,0 +1,113 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestNativeHosts(t *testing.T) {
+	t.Parallel()
+
+	const (
+		host  = "com.example.ssh_agent"
+		other = "org.example.agent2"
+	)
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		mgr := NewManager(agent.NewKeyring(), syncStorage, storage.NewRaw(st.NewMemArea()))
+		// elsewhere shares storage with mgr, as would a manager on
+		// another device.
+		elsewhere := NewManager(agent.NewKeyring(), syncStorage, storage.NewRaw(st.NewMemArea()))
+
+		var mu sync.Mutex
+		var watched [][]string
+		stop, err := mgr.WatchNativeHosts(ctx, func(_ jsutil.AsyncContext, hosts []string) {
+			mu.Lock()
+			defer mu.Unlock()
+			watched = append(watched, hosts)
+		})
+		if err != nil {
+			t.Fatalf("WatchNativeHosts failed: %v", err)
+		}
+		defer stop()
+
+		// expect checks the hosts, and that they were last reported to
+		// the watcher.
+		expect := func(description string, want ...string) {
+			t.Helper()
+			hosts, err := mgr.NativeHosts(ctx)
+			if err != nil {
+				t.Fatalf("%s: NativeHosts failed: %v", description, err)
+			}
+			if diff := cmp.Diff(hosts, want, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%s: incorrect hosts; -got +want: %s", description, diff)
+			}
+			last := func() []string {
+				mu.Lock()
+				defer mu.Unlock()
+				return watched[len(watched)-1]
+			}
+			poll(func() bool { return cmp.Equal(last(), want, cmpopts.EquateEmpty()) })
+			if diff := cmp.Diff(last(), want, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%s: incorrect hosts reported to watcher; -got +want: %s", description, diff)
+			}
+		}
+
+		// No hosts are connected by default.
+		expect("default")
+
+		for _, name := range []string{"", "Com.Example", ".com.example", "com.example.", "com..example", "com/example"} {
+			if err := mgr.AllowNativeHost(ctx, name); !errors.Is(err, errInvalidNativeHost) {
+				t.Errorf("AllowNativeHost(%q) returned incorrect error: %v", name, err)
+			}
+		}
+
+		if err := mgr.AllowNativeHost(ctx, host); err != nil {
+			t.Fatalf("AllowNativeHost failed: %v", err)
+		}
+		expect("allowed", host)
+
+		// Hosts may be allowed more than once.
+		if err := mgr.AllowNativeHost(ctx, host); err != nil {
+			t.Fatalf("AllowNativeHost failed: %v", err)
+		}
+		expect("allowed again", host)
+
+		// Changes made elsewhere are observed.
+		if err := elsewhere.AllowNativeHost(ctx, other); err != nil {
+			t.Fatalf("AllowNativeHost failed: %v", err)
+		}
+		expect("allowed elsewhere", host, other)
+
+		if err := mgr.DisallowNativeHost(ctx, host); err != nil {
+			t.Fatalf("DisallowNativeHost failed: %v", err)
+		}
+		expect("disallowed", other)
+	})
+}
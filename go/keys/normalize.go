@@ -0,0 +1,91 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// errPublicKeyProvided indicates that a public key was supplied where
+	// a private key was expected.
+	errPublicKeyProvided = errors.New("public key provided instead of private key")
+	// errIncompleteKey indicates that only part of a private key was
+	// supplied.
+	errIncompleteKey = errors.New("private key is incomplete")
+)
+
+const (
+	// utf8BOM is the byte order mark that some editors prepend to UTF-8
+	// files.
+	utf8BOM = "\ufeff"
+	// promptChars are characters that commonly end a shell prompt, and
+	// that are accidentally included when copying a key from a terminal.
+	promptChars = "$#>% "
+)
+
+// normalizeKeyText normalizes a private key that was pasted or read from a
+// file, such that it may be parsed. It removes any byte order mark, converts
+// line endings to '\n', and removes surrounding whitespace and any leading
+// prompt characters.
+func normalizeKeyText(text string) string {
+	text = strings.TrimPrefix(text, utf8BOM)
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.TrimSpace(text)
+	text = strings.TrimLeft(text, promptChars)
+	if text == "" {
+		return ""
+	}
+	return text + "\n"
+}
+
+// diagnoseKeyText returns an error describing why the text is not a private
+// key in a supported format. It detects common mistakes, such as providing
+// the public key or only part of the private key.
+func diagnoseKeyText(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("%w: no private key provided", errDecodeFailed)
+	}
+
+	// Public keys may be in the authorized_keys format, the RFC 4716
+	// format, or a PEM block.
+	_, _, _, _, err := ssh.ParseAuthorizedKey([]byte(text))
+	isPublic := err == nil || strings.HasPrefix(text, "---- BEGIN SSH2 PUBLIC KEY ----")
+	if block, _ := pem.Decode([]byte(text)); block != nil && strings.HasSuffix(block.Type, "PUBLIC KEY") {
+		isPublic = true
+	}
+	if isPublic {
+		return fmt.Errorf("%w: %w: supply the private key file (e.g., id_ed25519), not the public key file (e.g., id_ed25519.pub)", errDecodeFailed, errPublicKeyProvided)
+	}
+
+	hasBegin := strings.Contains(text, "-----BEGIN ")
+	hasEnd := strings.Contains(text, "-----END ")
+	if hasBegin != hasEnd {
+		return fmt.Errorf("%w: %w: include everything from the BEGIN line through the END line", errDecodeFailed, errIncompleteKey)
+	}
+	if block, _ := pem.Decode([]byte(text)); hasBegin && block == nil {
+		return fmt.Errorf("%w: %w: the key data is damaged or truncated", errDecodeFailed, errIncompleteKey)
+	}
+
+	return fmt.Errorf("%w: unrecognized private key format", errDecodeFailed)
+}
@@ -0,0 +1,397 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ssh"
+)
+
+// PuTTY private key files (.ppk) are described at:
+//   https://the.earth.li/~sgtatham/putty/0.80/htmldoc/AppendixC.html
+
+const (
+	ppkHeaderV2 = "PuTTY-User-Key-File-2"
+	ppkHeaderV3 = "PuTTY-User-Key-File-3"
+
+	ppkEncryptionNone   = "none"
+	ppkEncryptionAES256 = "aes256-cbc"
+
+	// ppkV2MACKeyPrefix is hashed along with the passphrase to derive the
+	// MAC key for version 2 files.
+	ppkV2MACKeyPrefix = "putty-private-key-file-mac-key"
+
+	// ppkMaxArgon2Memory is the maximum memory (in KiB) that we permit for
+	// key derivation, such that a malicious file cannot exhaust memory.
+	// PuTTY's default is 8 MiB.
+	ppkMaxArgon2Memory = 256 * 1024
+)
+
+var (
+	// errPPKMACFailed indicates that the MAC of an unencrypted PuTTY key
+	// file did not match, meaning that the file was corrupted or modified.
+	// For encrypted files, a mismatch instead indicates an incorrect
+	// passphrase, and x509.IncorrectPasswordError is returned.
+	errPPKMACFailed = errors.New("PuTTY key file MAC check failed")
+	// errPPKUnsupported indicates a PuTTY key file using a version, key
+	// type, or encryption scheme that is not supported.
+	errPPKUnsupported = errors.New("unsupported PuTTY key file")
+)
+
+// ppkFile is a parsed PuTTY private key file.
+type ppkFile struct {
+	Version    int
+	Algorithm  string
+	Encryption string
+	Comment    string
+	Public     []byte
+	Private    []byte
+	MAC        []byte
+
+	// Argon2 parameters, used to derive keys for encrypted version 3
+	// files.
+	KeyDerivation string
+	Memory        uint32
+	Passes        uint32
+	Parallelism   uint8
+	Salt          []byte
+}
+
+// isPPK determines if the private key is a PuTTY private key file.
+func isPPK(privateKey string) bool {
+	s := strings.TrimSpace(privateKey)
+	return strings.HasPrefix(s, ppkHeaderV2+":") || strings.HasPrefix(s, ppkHeaderV3+":")
+}
+
+// parsePPK parses the structure of a PuTTY private key file. The private key
+// is not decrypted; see ppkFile.Decrypt.
+func parsePPK(privateKey string) (*ppkFile, error) {
+	sc := bufio.NewScanner(strings.NewReader(strings.TrimSpace(privateKey)))
+
+	// field reads the next line, which must have the specified name.
+	field := func(name string) (string, error) {
+		if !sc.Scan() {
+			return "", fmt.Errorf("%w: missing %s", errParseFailed, name)
+		}
+		n, v, ok := strings.Cut(strings.TrimRight(sc.Text(), "\r"), ": ")
+		if !ok || n != name {
+			return "", fmt.Errorf("%w: expected %s, got %q", errParseFailed, name, n)
+		}
+		return v, nil
+	}
+	intField := func(name string) (uint32, error) {
+		v, err := field(name)
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid %s: %w", errParseFailed, name, err)
+		}
+		return uint32(n), nil
+	}
+	// lines reads a count of base64-encoded lines, followed by the lines
+	// themselves.
+	lines := func(name string) ([]byte, error) {
+		n, err := intField(name)
+		if err != nil {
+			return nil, err
+		}
+		var enc strings.Builder
+		for i := uint32(0); i < n; i++ {
+			if !sc.Scan() {
+				return nil, fmt.Errorf("%w: truncated %s", errParseFailed, name)
+			}
+			enc.WriteString(strings.TrimSpace(sc.Text()))
+		}
+		b, err := base64.StdEncoding.DecodeString(enc.String())
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid %s: %w", errParseFailed, name, err)
+		}
+		return b, nil
+	}
+
+	f := &ppkFile{}
+	if !sc.Scan() {
+		return nil, fmt.Errorf("%w: empty file", errParseFailed)
+	}
+	header, alg, _ := strings.Cut(strings.TrimRight(sc.Text(), "\r"), ": ")
+	switch header {
+	case ppkHeaderV2:
+		f.Version = 2
+	case ppkHeaderV3:
+		f.Version = 3
+	default:
+		return nil, fmt.Errorf("%w: header %q", errPPKUnsupported, header)
+	}
+	f.Algorithm = alg
+
+	var err error
+	if f.Encryption, err = field("Encryption"); err != nil {
+		return nil, err
+	}
+	if f.Encryption != ppkEncryptionNone && f.Encryption != ppkEncryptionAES256 {
+		return nil, fmt.Errorf("%w: encryption %q", errPPKUnsupported, f.Encryption)
+	}
+	if f.Comment, err = field("Comment"); err != nil {
+		return nil, err
+	}
+	if f.Public, err = lines("Public-Lines"); err != nil {
+		return nil, err
+	}
+	if f.Version == 3 && f.Encryption != ppkEncryptionNone {
+		if f.KeyDerivation, err = field("Key-Derivation"); err != nil {
+			return nil, err
+		}
+		if f.Memory, err = intField("Argon2-Memory"); err != nil {
+			return nil, err
+		}
+		if f.Memory > ppkMaxArgon2Memory {
+			return nil, fmt.Errorf("%w: Argon2-Memory %d exceeds %d", errPPKUnsupported, f.Memory, ppkMaxArgon2Memory)
+		}
+		if f.Passes, err = intField("Argon2-Passes"); err != nil {
+			return nil, err
+		}
+		parallelism, err := intField("Argon2-Parallelism")
+		if err != nil {
+			return nil, err
+		}
+		if parallelism == 0 || parallelism > 255 {
+			return nil, fmt.Errorf("%w: invalid Argon2-Parallelism %d", errParseFailed, parallelism)
+		}
+		f.Parallelism = uint8(parallelism)
+		salt, err := field("Argon2-Salt")
+		if err != nil {
+			return nil, err
+		}
+		if f.Salt, err = hex.DecodeString(salt); err != nil {
+			return nil, fmt.Errorf("%w: invalid Argon2-Salt: %w", errParseFailed, err)
+		}
+	}
+	if f.Private, err = lines("Private-Lines"); err != nil {
+		return nil, err
+	}
+	mac, err := field("Private-MAC")
+	if err != nil {
+		return nil, err
+	}
+	if f.MAC, err = hex.DecodeString(mac); err != nil {
+		return nil, fmt.Errorf("%w: invalid Private-MAC: %w", errParseFailed, err)
+	}
+	return f, nil
+}
+
+// Encrypted determines if the private key is encrypted.
+func (f *ppkFile) Encrypted() bool {
+	return f.Encryption != ppkEncryptionNone
+}
+
+// deriveKeys returns the cipher key, IV and MAC key for the passphrase, along
+// with the hash function used for the MAC.
+func (f *ppkFile) deriveKeys(passphrase string) (cipherKey, iv, macKey []byte, h func() hash.Hash, err error) {
+	switch f.Version {
+	case 2:
+		if f.Encrypted() {
+			var buf []byte
+			for i := uint32(0); i < 2; i++ {
+				d := sha1.New()
+				binary.Write(d, binary.BigEndian, i)
+				d.Write([]byte(passphrase))
+				buf = d.Sum(buf)
+			}
+			cipherKey = buf[:32]
+			iv = make([]byte, aes.BlockSize)
+		} else {
+			passphrase = ""
+		}
+		m := sha1.Sum([]byte(ppkV2MACKeyPrefix + passphrase))
+		return cipherKey, iv, m[:], sha1.New, nil
+	case 3:
+		if !f.Encrypted() {
+			return nil, nil, nil, sha256.New, nil
+		}
+		var out []byte
+		switch f.KeyDerivation {
+		case "Argon2id":
+			out = argon2.IDKey([]byte(passphrase), f.Salt, f.Passes, f.Memory, f.Parallelism, 80)
+		case "Argon2i":
+			out = argon2.Key([]byte(passphrase), f.Salt, f.Passes, f.Memory, f.Parallelism, 80)
+		default:
+			return nil, nil, nil, nil, fmt.Errorf("%w: key derivation %q", errPPKUnsupported, f.KeyDerivation)
+		}
+		return out[:32], out[32:48], out[48:], sha256.New, nil
+	default:
+		return nil, nil, nil, nil, fmt.Errorf("%w: version %d", errPPKUnsupported, f.Version)
+	}
+}
+
+// appendSSHString appends b to buf in the SSH wire format for strings.
+func appendSSHString(buf, b []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(b)))
+	return append(buf, b...)
+}
+
+// Decrypt decrypts the private key using the passphrase, and verifies the
+// file's MAC. The passphrase is ignored if the key is not encrypted.
+func (f *ppkFile) Decrypt(passphrase string) (crypto.PrivateKey, error) {
+	cipherKey, iv, macKey, h, err := f.deriveKeys(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	private := f.Private
+	if f.Encrypted() {
+		if len(private) == 0 || len(private)%aes.BlockSize != 0 {
+			return nil, fmt.Errorf("%w: private key is not a multiple of the block size", errParseFailed)
+		}
+		block, err := aes.NewCipher(cipherKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errParseFailed, err)
+		}
+		private = make([]byte, len(f.Private))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(private, f.Private)
+	}
+
+	var data []byte
+	for _, s := range []string{f.Algorithm, f.Encryption, f.Comment} {
+		data = appendSSHString(data, []byte(s))
+	}
+	data = appendSSHString(data, f.Public)
+	data = appendSSHString(data, private)
+	mac := hmac.New(h, macKey)
+	mac.Write(data)
+	if subtle.ConstantTimeCompare(mac.Sum(nil), f.MAC) != 1 {
+		if f.Encrypted() {
+			return nil, fmt.Errorf("failed to decrypt PuTTY key file: %w", x509.IncorrectPasswordError)
+		}
+		return nil, errPPKMACFailed
+	}
+
+	return f.privateKey(private)
+}
+
+// privateKey returns the private key, given the decrypted private portion of
+// the file.
+func (f *ppkFile) privateKey(private []byte) (crypto.PrivateKey, error) {
+	pub, err := ssh.ParsePublicKey(f.Public)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid public key: %w", errParseFailed, err)
+	}
+	if pub.Type() != f.Algorithm {
+		return nil, fmt.Errorf("%w: public key type %s does not match %s", errParseFailed, pub.Type(), f.Algorithm)
+	}
+	cpub, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: key type %s", errPPKUnsupported, f.Algorithm)
+	}
+
+	var priv crypto.Signer
+	switch pk := cpub.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		var k struct {
+			D    *big.Int
+			P    *big.Int
+			Q    *big.Int
+			Iqmp *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(private, &k); err != nil {
+			return nil, fmt.Errorf("%w: invalid RSA private key: %w", errParseFailed, err)
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: *pk,
+			D:         k.D,
+			Primes:    []*big.Int{k.P, k.Q},
+		}
+		if err := key.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: invalid RSA private key: %w", errParseFailed, err)
+		}
+		key.Precompute()
+		priv = key
+	case *ecdsa.PublicKey:
+		var k struct {
+			D    *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(private, &k); err != nil {
+			return nil, fmt.Errorf("%w: invalid ECDSA private key: %w", errParseFailed, err)
+		}
+		priv = &ecdsa.PrivateKey{PublicKey: *pk, D: k.D}
+	case ed25519.PublicKey:
+		var k struct {
+			Seed []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(private, &k); err != nil || len(k.Seed) == 0 || len(k.Seed) > ed25519.SeedSize {
+			return nil, fmt.Errorf("%w: invalid Ed25519 private key", errParseFailed)
+		}
+		// The seed is stored as a little-endian integer, omitting any
+		// high-order zero bytes.
+		seed := make([]byte, ed25519.SeedSize)
+		copy(seed, k.Seed)
+		priv = ed25519.NewKeyFromSeed(seed)
+	default:
+		return nil, fmt.Errorf("%w: key type %s", errPPKUnsupported, f.Algorithm)
+	}
+
+	// Ensure the private key corresponds to the public key.
+	signer, err := ssh.NewSignerFromSigner(priv)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errParseFailed, err)
+	}
+	if !hmac.Equal(signer.PublicKey().Marshal(), f.Public) {
+		return nil, fmt.Errorf("%w: private key does not match public key", errParseFailed)
+	}
+	return priv, nil
+}
+
+// convertPPK converts an unencrypted PuTTY private key file to the OpenSSH
+// private key format.
+func convertPPK(f *ppkFile) (string, error) {
+	priv, err := f.Decrypt("")
+	if err != nil {
+		return "", err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, f.Comment)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errMarshalFailed, err)
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
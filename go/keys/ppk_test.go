@@ -0,0 +1,359 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ppkOptions control how a test PuTTY key file is encoded.
+type ppkOptions struct {
+	Version    int
+	Passphrase string
+	// KeyDerivation is the Argon2 variant for encrypted version 3 files.
+	KeyDerivation string
+	// CorruptMAC modifies the MAC after it is computed.
+	CorruptMAC bool
+}
+
+// ppkPrivateBlob returns the private portion of a PuTTY key file for priv.
+func ppkPrivateBlob(priv crypto.Signer) []byte {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return ssh.Marshal(struct {
+			D, P, Q, Iqmp *big.Int
+		}{k.D, k.Primes[0], k.Primes[1], k.Precomputed.Qinv})
+	case *ecdsa.PrivateKey:
+		return ssh.Marshal(struct{ D *big.Int }{k.D})
+	case ed25519.PrivateKey:
+		return ssh.Marshal(struct{ Seed []byte }{k.Seed()})
+	default:
+		panic(fmt.Sprintf("unsupported key type %T", priv))
+	}
+}
+
+// encodePPK encodes priv as a PuTTY key file, as described by:
+//
+//	https://the.earth.li/~sgtatham/putty/0.80/htmldoc/AppendixC.html
+func encodePPK(priv crypto.Signer, comment string, opts ppkOptions) string {
+	signer, err := ssh.NewSignerFromSigner(priv)
+	if err != nil {
+		panic(err)
+	}
+	f := &ppkFile{
+		Version:    opts.Version,
+		Algorithm:  signer.PublicKey().Type(),
+		Encryption: ppkEncryptionNone,
+		Comment:    comment,
+		Public:     signer.PublicKey().Marshal(),
+	}
+	private := ppkPrivateBlob(priv)
+	if opts.Passphrase != "" {
+		f.Encryption = ppkEncryptionAES256
+		f.KeyDerivation = opts.KeyDerivation
+		f.Memory, f.Passes, f.Parallelism = 64, 1, 1
+		f.Salt = []byte("0123456789abcdef")
+		if pad := len(private) % aes.BlockSize; pad != 0 {
+			private = append(private, make([]byte, aes.BlockSize-pad)...)
+		}
+	}
+
+	cipherKey, iv, macKey, h, err := f.deriveKeys(opts.Passphrase)
+	if err != nil {
+		panic(err)
+	}
+	var data []byte
+	for _, b := range [][]byte{[]byte(f.Algorithm), []byte(f.Encryption), []byte(f.Comment), f.Public, private} {
+		data = appendSSHString(data, b)
+	}
+	mac := hmac.New(h, macKey)
+	mac.Write(data)
+	f.MAC = mac.Sum(nil)
+	if opts.CorruptMAC {
+		f.MAC[0] ^= 0xff
+	}
+	f.Private = private
+	if f.Encrypted() {
+		block, err := aes.NewCipher(cipherKey)
+		if err != nil {
+			panic(err)
+		}
+		f.Private = make([]byte, len(private))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(f.Private, private)
+	}
+
+	lines := func(b []byte) string {
+		enc := base64.StdEncoding.EncodeToString(b)
+		var res []string
+		for len(enc) > 64 {
+			res = append(res, enc[:64])
+			enc = enc[64:]
+		}
+		res = append(res, enc)
+		return fmt.Sprintf("%d\n%s\n", len(res), strings.Join(res, "\n"))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "PuTTY-User-Key-File-%d: %s\n", f.Version, f.Algorithm)
+	fmt.Fprintf(&sb, "Encryption: %s\n", f.Encryption)
+	fmt.Fprintf(&sb, "Comment: %s\n", f.Comment)
+	fmt.Fprintf(&sb, "Public-Lines: %s", lines(f.Public))
+	if f.Version == 3 && f.Encrypted() {
+		fmt.Fprintf(&sb, "Key-Derivation: %s\n", f.KeyDerivation)
+		fmt.Fprintf(&sb, "Argon2-Memory: %d\n", f.Memory)
+		fmt.Fprintf(&sb, "Argon2-Passes: %d\n", f.Passes)
+		fmt.Fprintf(&sb, "Argon2-Parallelism: %d\n", f.Parallelism)
+		fmt.Fprintf(&sb, "Argon2-Salt: %s\n", hex.EncodeToString(f.Salt))
+	}
+	fmt.Fprintf(&sb, "Private-Lines: %s", lines(f.Private))
+	fmt.Fprintf(&sb, "Private-MAC: %s\n", hex.EncodeToString(f.MAC))
+	return sb.String()
+}
+
+func TestAddPPK(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+
+	testcases := []struct {
+		description   string
+		key           crypto.Signer
+		opts          ppkOptions
+		crlf          bool
+		passphrase    string
+		wantEncrypted bool
+		wantAddErr    error
+		wantLoadErr   error
+	}{
+		{
+			description: "version 2 unencrypted",
+			key:         ed25519Key,
+			opts:        ppkOptions{Version: 2},
+		},
+		{
+			description: "version 3 unencrypted",
+			key:         ecdsaKey,
+			opts:        ppkOptions{Version: 3},
+		},
+		{
+			description: "version 3 unencrypted with CRLF line endings",
+			key:         rsaKey,
+			opts:        ppkOptions{Version: 3},
+			crlf:        true,
+		},
+		{
+			description:   "version 2 encrypted",
+			key:           rsaKey,
+			opts:          ppkOptions{Version: 2, Passphrase: "secret"},
+			passphrase:    "secret",
+			wantEncrypted: true,
+		},
+		{
+			description:   "version 3 encrypted with Argon2id",
+			key:           ed25519Key,
+			opts:          ppkOptions{Version: 3, Passphrase: "secret", KeyDerivation: "Argon2id"},
+			passphrase:    "secret",
+			wantEncrypted: true,
+		},
+		{
+			description:   "version 3 encrypted with Argon2i",
+			key:           ecdsaKey,
+			opts:          ppkOptions{Version: 3, Passphrase: "secret", KeyDerivation: "Argon2i"},
+			passphrase:    "secret",
+			wantEncrypted: true,
+		},
+		{
+			description:   "version 2 wrong passphrase",
+			key:           ed25519Key,
+			opts:          ppkOptions{Version: 2, Passphrase: "secret"},
+			passphrase:    "wrong",
+			wantEncrypted: true,
+			wantLoadErr:   x509.IncorrectPasswordError,
+		},
+		{
+			description:   "version 3 wrong passphrase",
+			key:           ed25519Key,
+			opts:          ppkOptions{Version: 3, Passphrase: "secret", KeyDerivation: "Argon2id"},
+			passphrase:    "wrong",
+			wantEncrypted: true,
+			wantLoadErr:   x509.IncorrectPasswordError,
+		},
+		{
+			description: "unencrypted with corrupted MAC",
+			key:         ed25519Key,
+			opts:        ppkOptions{Version: 3, CorruptMAC: true},
+			wantAddErr:  errPPKMACFailed,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				syncStorage := storage.NewRaw(st.NewMemArea())
+				sessionStorage := storage.NewRaw(st.NewMemArea())
+				mgr := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+
+				ppk := encodePPK(tc.key, "my-comment", tc.opts)
+				if tc.crlf {
+					ppk = strings.ReplaceAll(ppk, "\n", "\r\n")
+				}
+				err := mgr.Add(ctx, "new-key", ppk)
+				if diff := cmp.Diff(err, tc.wantAddErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("incorrect error from Add; -got +want: %s", diff)
+				}
+				if err != nil {
+					return
+				}
+
+				configured, err := mgr.Configured(ctx)
+				if err != nil {
+					t.Errorf("failed to get configured keys: %v", err)
+					return
+				}
+				// Unencrypted keys are converted to the OpenSSH format.
+				wantFormat := FormatOpenSSH
+				if tc.wantEncrypted {
+					wantFormat = FormatPuTTY
+				}
+				signer, err := ssh.NewSignerFromSigner(tc.key)
+				if err != nil {
+					t.Errorf("failed to create signer: %v", err)
+					return
+				}
+				// The public key is known, even if the file is
+				// encrypted.
+				want := &ConfiguredKey{Name: "new-key", Encrypted: tc.wantEncrypted, Format: wantFormat}
+				want.FingerprintSHA256, want.FingerprintMD5 = fingerprints(signer.PublicKey())
+				want.Type, want.Bits = keyType(signer.PublicKey())
+				if diff := cmp.Diff(configured, []*ConfiguredKey{want}, cmpopts.IgnoreFields(ConfiguredKey{}, "ID")); diff != "" {
+					t.Errorf("incorrect configured keys; -got +want: %s", diff)
+				}
+
+				err = mgr.Load(ctx, ID(configured[0].ID), tc.passphrase)
+				if diff := cmp.Diff(err, tc.wantLoadErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("incorrect error from Load; -got +want: %s", diff)
+				}
+				if err != nil {
+					return
+				}
+
+				loaded, err := mgr.Loaded(ctx)
+				if err != nil {
+					t.Errorf("failed to get loaded keys: %v", err)
+					return
+				}
+				wantBlobs := []string{base64.StdEncoding.EncodeToString(signer.PublicKey().Marshal())}
+				if diff := cmp.Diff(loadedKeyBlobs(loaded), wantBlobs); diff != "" {
+					t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestParsePPK(t *testing.T) {
+	t.Parallel()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	valid := encodePPK(key, "comment", ppkOptions{Version: 3, Passphrase: "secret", KeyDerivation: "Argon2id"})
+
+	testcases := []struct {
+		description string
+		ppk         string
+		wantErr     error
+	}{
+		{
+			description: "valid",
+			ppk:         valid,
+		},
+		{
+			description: "unsupported version",
+			ppk:         strings.Replace(valid, "PuTTY-User-Key-File-3", "PuTTY-User-Key-File-1", 1),
+			wantErr:     errPPKUnsupported,
+		},
+		{
+			description: "unsupported encryption",
+			ppk:         strings.Replace(valid, "aes256-cbc", "3des-cbc", 1),
+			wantErr:     errPPKUnsupported,
+		},
+		{
+			description: "excessive memory for key derivation",
+			ppk:         strings.Replace(valid, "Argon2-Memory: 64", "Argon2-Memory: 4194304", 1),
+			wantErr:     errPPKUnsupported,
+		},
+		{
+			description: "truncated",
+			ppk:         valid[:len(valid)/2],
+			wantErr:     errParseFailed,
+		},
+		{
+			description: "missing field",
+			ppk:         strings.Replace(valid, "Comment: comment\n", "", 1),
+			wantErr:     errParseFailed,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+			_, err := parsePPK(tc.ppk)
+			if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("incorrect error; -got +want: %s", diff)
+			}
+		})
+	}
+}
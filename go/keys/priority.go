@@ -0,0 +1,112 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// keyRank determines the position of a loaded key in the identities list.
+type keyRank struct {
+	// Priority is the priority of the key; keys with a higher priority
+	// are listed first.
+	Priority int
+	// Name is the name of the key. Keys with equal priority are listed by
+	// name.
+	Name string
+}
+
+// applyPriority applies the priority for a key.
+func (m *DefaultManager) applyPriority(key *storedKey) {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	m.ranks[ID(key.ID)] = &keyRank{Priority: key.Priority, Name: key.Name}
+}
+
+// rank is invoked by the agent to determine the position of the key with the
+// specified ID in the identities list. It returns nil if the key was not loaded
+// by the Manager.
+func (m *DefaultManager) rank(id ID) *keyRank {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	return m.ranks[id]
+}
+
+// SetPriority implements Manager.SetPriority.
+func (m *DefaultManager) SetPriority(ctx jsutil.AsyncContext, id ID, priority int) error {
+	var key *storedKey
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(k *storedKey) (*storedKey, error) {
+		updated := *k
+		updated.Priority = priority
+		key = &updated
+		return &updated, nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+
+	// Apply the setting regardless of whether the key is loaded; it is
+	// only consulted for loaded keys.
+	m.applyPriority(key)
+	return nil
+}
+
+// sortLocked sorts the identities such that those with the highest priority
+// are listed first. Those with equal priority are listed by name or, if the
+// order is KeyOrderRecent, with the most recently used first. Keys not loaded
+// by the Manager have priority zero, and are named by their comment. a.mu must
+// be held.
+func (a *managedAgent) sortLocked(keys []*agent.Key) {
+	ranks := make([]keyRank, len(keys))
+	for i, k := range keys {
+		if r := a.rank((&LoadedKey{Comment: k.Comment}).ID()); r != nil {
+			ranks[i] = *r
+		} else {
+			ranks[i] = keyRank{Name: k.Comment}
+		}
+	}
+	less := func(i, j int) bool {
+		if ranks[i].Priority != ranks[j].Priority {
+			return ranks[i].Priority > ranks[j].Priority
+		}
+		if a.order == KeyOrderRecent {
+			ui, uj := a.lastUsed[string(keys[i].Blob)], a.lastUsed[string(keys[j].Blob)]
+			if ui != uj {
+				return ui > uj
+			}
+		}
+		return ranks[i].Name < ranks[j].Name
+	}
+	// Sort indices, such that ranks remain aligned with keys.
+	idx := make([]int, len(keys))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return less(idx[i], idx[j]) })
+	sorted := make([]*agent.Key, len(keys))
+	for i, n := range idx {
+		sorted[i] = keys[n]
+	}
+	copy(keys, sorted)
+}
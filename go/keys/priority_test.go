@@ -0,0 +1,115 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestPriority(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		// Keys are loaded in an order other than by name.
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "charlie", PEMPrivateKey: testdata.WithoutPassphrase.Private, Load: true},
+			{Name: "alpha", PEMPrivateKey: testdata.ED25519WithoutPassphrase.Private, Load: true},
+			{Name: "bravo", PEMPrivateKey: testdata.ECDSAWithoutPassphrase.Private, Load: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		ids := map[string]ID{}
+		for _, name := range []string{"alpha", "bravo", "charlie"} {
+			if ids[name], err = findKey(ctx, mgr, InvalidID, name); err != nil {
+				t.Fatalf("failed to find key: %v", err)
+			}
+		}
+		alpha := testdata.ED25519WithoutPassphrase.Blob
+		bravo := testdata.ECDSAWithoutPassphrase.Blob
+		charlie := testdata.WithoutPassphrase.Blob
+
+		// List identities using the protocol, as with 'ssh-add -l'.
+		listed := func(mgr *DefaultManager) []string {
+			client, closeClient := serveAgent(mgr)
+			defer closeClient()
+			keys, err := client.List()
+			if err != nil {
+				t.Fatalf("failed to list keys: %v", err)
+			}
+			var blobs []string
+			for _, k := range keys {
+				blobs = append(blobs, base64.StdEncoding.EncodeToString(k.Blob))
+			}
+			return blobs
+		}
+
+		// Keys of equal priority are listed by name.
+		if diff := cmp.Diff(listed(mgr), []string{alpha, bravo, charlie}); diff != "" {
+			t.Errorf("incorrect order; -got +want: %s", diff)
+		}
+
+		// Keys with a higher priority are listed first.
+		if err := mgr.SetPriority(ctx, ids["charlie"], 10); err != nil {
+			t.Fatalf("SetPriority failed: %v", err)
+		}
+		if err := mgr.SetPriority(ctx, ids["alpha"], -1); err != nil {
+			t.Fatalf("SetPriority failed: %v", err)
+		}
+		if diff := cmp.Diff(listed(mgr), []string{charlie, bravo, alpha}); diff != "" {
+			t.Errorf("incorrect order; -got +want: %s", diff)
+		}
+
+		if err := mgr.SetPriority(ctx, InvalidID, 1); !errors.Is(err, errKeyNotFound) {
+			t.Errorf("SetPriority returned incorrect error for missing key: %v", err)
+		}
+
+		configured, err := mgr.Configured(ctx)
+		if err != nil {
+			t.Fatalf("failed to get configured keys: %v", err)
+		}
+		priorities := map[string]int{}
+		for _, k := range configured {
+			priorities[k.Name] = k.Priority
+		}
+		if diff := cmp.Diff(priorities, map[string]int{"alpha": -1, "bravo": 0, "charlie": 10}); diff != "" {
+			t.Errorf("incorrect priorities; -got +want: %s", diff)
+		}
+
+		// Priorities are stored, and apply when keys are restored to a
+		// new agent from the session.
+		restarted := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		if err := restarted.LoadFromSession(ctx); err != nil {
+			t.Fatalf("LoadFromSession failed: %v", err)
+		}
+		if diff := cmp.Diff(listed(restarted), []string{charlie, bravo, alpha}); diff != "" {
+			t.Errorf("incorrect order after restart; -got +want: %s", diff)
+		}
+	})
+}
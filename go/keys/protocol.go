@@ -0,0 +1,323 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/ed25519"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ProtocolKeyPolicy determines how keys added by clients over the agent
+// protocol (e.g., using ssh-add) are handled.
+type ProtocolKeyPolicy string
+
+const (
+	// ProtocolKeysEphemeral loads keys as with LoadEphemeral; they are
+	// held in memory only, and are not configured.
+	ProtocolKeysEphemeral ProtocolKeyPolicy = "ephemeral"
+	// ProtocolKeysPersist configures keys as with Add, and loads them.
+	// Keys that are already configured are loaded. Keys are received
+	// unencrypted, so they are only configured while the master key is
+	// unlocked, and are stored encrypted using it.
+	ProtocolKeysPersist ProtocolKeyPolicy = "persist"
+)
+
+var (
+	errInvalidProtocolKeyPolicy = errors.New("invalid policy for keys added over the agent protocol")
+	errUnsupportedConstraint    = errors.New("unsupported key constraint")
+	errProtocolKeyUnprotected   = errors.New("keys added over the agent protocol are only configured while the master key is unlocked")
+)
+
+// protocolKeyPolicy returns the policy for keys added over the agent protocol.
+func protocolKeyPolicy(s *managerSettings) ProtocolKeyPolicy {
+	if s == nil || s.ProtocolKeys == "" {
+		return ProtocolKeysEphemeral
+	}
+	return ProtocolKeyPolicy(s.ProtocolKeys)
+}
+
+// ProtocolKeyPolicy implements Manager.ProtocolKeyPolicy.
+func (m *DefaultManager) ProtocolKeyPolicy(ctx jsutil.AsyncContext) (ProtocolKeyPolicy, error) {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read settings: %w", err)
+	}
+	return protocolKeyPolicy(s), nil
+}
+
+// SetProtocolKeyPolicy implements Manager.SetProtocolKeyPolicy.
+func (m *DefaultManager) SetProtocolKeyPolicy(ctx jsutil.AsyncContext, policy ProtocolKeyPolicy) error {
+	switch policy {
+	case ProtocolKeysEphemeral, ProtocolKeysPersist:
+	default:
+		return fmt.Errorf("%w: %s", errInvalidProtocolKeyPolicy, policy)
+	}
+	return m.updateSettings(ctx, func(s *managerSettings) {
+		s.ProtocolKeys = string(policy)
+	})
+}
+
+// Add implements agent.Agent.Add(). Keys are added according to the
+// ProtocolKeyPolicy, and their constraints are applied using the Manager's
+// settings for confirmation and timeouts; see applyConstraints.
+func (a *connectionAgent) Add(key agent.AddedKey) error {
+	if a.m.locker.locked() {
+		return errAgentLocked
+	}
+	return awaitMain(func(ctx jsutil.AsyncContext) error {
+		return a.m.addFromProtocol(ctx, key)
+	})
+}
+
+// Remove implements agent.Agent.Remove(). Keys loaded by the Manager are
+// unloaded, as with Unload.
+func (a *connectionAgent) Remove(key ssh.PublicKey) error {
+	if a.m.locker.locked() {
+		return errAgentLocked
+	}
+	id := a.m.agent.lookup(key)
+	if id == InvalidID {
+		return a.ExtendedAgent.Remove(key)
+	}
+	return awaitMain(func(ctx jsutil.AsyncContext) error {
+		return a.m.Unload(ctx, id)
+	})
+}
+
+// RemoveAll implements agent.Agent.RemoveAll(). Keys loaded by the Manager are
+// unloaded, as with Unload.
+func (a *connectionAgent) RemoveAll() error {
+	if a.m.locker.locked() {
+		return errAgentLocked
+	}
+	err := awaitMain(func(ctx jsutil.AsyncContext) error {
+		loaded, err := a.m.Loaded(ctx)
+		if err != nil {
+			return err
+		}
+		unloaded := map[ID]bool{}
+		for _, l := range loaded {
+			id := l.ID()
+			if id == InvalidID || unloaded[id] {
+				continue
+			}
+			if err := a.m.Unload(ctx, id); err != nil {
+				return err
+			}
+			unloaded[id] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Remove any remaining keys that were not loaded by the Manager.
+	return a.ExtendedAgent.RemoveAll()
+}
+
+// addFromProtocol adds a key received over the agent protocol, according to
+// the ProtocolKeyPolicy. A key that is already loaded is not loaded again,
+// although its certificate is added if there is one, as ssh-add sends the
+// key and its certificate separately. Constraints replace those with which
+// the key was previously added; see applyConstraints.
+func (m *DefaultManager) addFromProtocol(ctx jsutil.AsyncContext, key agent.AddedKey) error {
+	// Constraint types other than extensions are rejected when the request
+	// is parsed. Extensions are not supported, and must not be ignored.
+	if len(key.ConstraintExtensions) > 0 {
+		return fmt.Errorf("%w: %s", errUnsupportedConstraint, key.ConstraintExtensions[0].ExtensionName)
+	}
+
+	priv := key.PrivateKey
+	if p, ok := priv.(*ed25519.PrivateKey); ok {
+		priv = *p
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errParseFailed, err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, key.Comment)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errParseFailed, err)
+	}
+	decrypted := decryptedKey(pem.EncodeToMemory(block))
+	defer decrypted.wipe()
+	name := key.Comment
+	if name == "" {
+		name = ssh.FingerprintSHA256(signer.PublicKey())
+	}
+
+	policy, err := m.ProtocolKeyPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	var id ID
+	switch policy {
+	case ProtocolKeysPersist:
+		id, err = m.persistFromProtocol(ctx, name, key.Comment, priv, decrypted, signer.PublicKey(), key.Certificate)
+	default:
+		id, err = m.loadFromProtocol(ctx, name, decrypted, signer.PublicKey(), key.Certificate)
+	}
+	if err != nil {
+		return err
+	}
+	return m.applyConstraints(ctx, id, name, key.ConfirmBeforeUse, time.Duration(key.LifetimeSecs)*time.Second)
+}
+
+// applyConstraints applies the constraints with which a key was added over the
+// agent protocol. Keys requiring confirmation are confirmed in the same manner
+// as those configured using SetConfirmUse; for configured keys, the setting is
+// stored, such that it is not lost when the key is next loaded. Keys with a
+// lifetime are unloaded when it ends, in the same manner as idle keys.
+func (m *DefaultManager) applyConstraints(ctx jsutil.AsyncContext, id ID, name string, confirmUse bool, lifetime time.Duration) error {
+	var end time.Time
+	if lifetime > 0 {
+		end = m.now().Add(lifetime)
+	}
+
+	if m.isEphemeral(id) {
+		m.applyConfirmUse(&storedKey{ID: string(id), Name: name, ConfirmUse: confirmUse})
+		m.keysMu.Lock()
+		if end.IsZero() {
+			delete(m.ephemeralLifetimes, id)
+		} else {
+			m.ephemeralLifetimes[id] = end
+		}
+		m.keysMu.Unlock()
+	} else {
+		if confirmUse {
+			if err := m.SetConfirmUse(ctx, id, true); err != nil {
+				return err
+			}
+		}
+		var lifetimeEnd int64
+		if !end.IsZero() {
+			lifetimeEnd = end.UnixMilli()
+		}
+		if _, err := m.sessionKeys.Update(ctx, func(sk *sessionKey) bool { return ID(sk.ID) == id }, func(sk *sessionKey) (*sessionKey, error) {
+			updated := *sk
+			updated.LifetimeEnd = lifetimeEnd
+			return &updated, nil
+		}); err != nil {
+			return fmt.Errorf("failed to store lifetime of key: %w", err)
+		}
+	}
+
+	m.scheduleIdleUnload(ctx)
+	return nil
+}
+
+// loadFromProtocol loads a key received over the agent protocol without
+// configuring it. It returns the ID of the key.
+func (m *DefaultManager) loadFromProtocol(ctx jsutil.AsyncContext, name string, decrypted decryptedKey, pub ssh.PublicKey, cert *ssh.Certificate) (ID, error) {
+	id := m.agent.lookup(pub)
+	if id == InvalidID {
+		return m.loadEphemeral(ctx, name, decrypted, cert)
+	}
+	if cert != nil {
+		if err := m.addToAgent(id, decrypted, name, cert); err != nil {
+			return InvalidID, err
+		}
+	}
+	return id, nil
+}
+
+// persistFromProtocol configures and loads a key received over the agent
+// protocol. It returns the ID of the key.
+func (m *DefaultManager) persistFromProtocol(ctx jsutil.AsyncContext, name, comment string, priv interface{}, decrypted decryptedKey, pub ssh.PublicKey, cert *ssh.Certificate) (ID, error) {
+	var dup *DuplicateKeyError
+	err := m.findDuplicate(ctx, pub)
+	if err == nil {
+		if err := m.configureWrapped(ctx, name, comment, priv, pub); err != nil {
+			return InvalidID, err
+		}
+		err = m.findDuplicate(ctx, pub)
+	}
+	if !errors.As(err, &dup) {
+		return InvalidID, fmt.Errorf("failed to find added key: %w", err)
+	}
+	id := dup.ID
+
+	// Setting the certificate also replaces the key in the agent if it is
+	// loaded.
+	if cert != nil {
+		if err := m.SetCertificate(ctx, id, string(ssh.MarshalAuthorizedKey(cert))); err != nil {
+			return InvalidID, err
+		}
+	}
+	if m.agent.lookup(pub) != InvalidID {
+		return id, nil
+	}
+
+	// The key is loaded using the key received from the client, such that
+	// no passphrase is required if it was configured with one.
+	sk, err := m.storedKeys.Read(ctx, func(key *storedKey) bool { return ID(key.ID) == id })
+	if err != nil {
+		return InvalidID, fmt.Errorf("failed to read key: %w", err)
+	}
+	if sk == nil {
+		return InvalidID, fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+	if err := m.checkExpiry(ctx, sk); err != nil {
+		return InvalidID, err
+	}
+	if err := m.addToAgent(id, decrypted, sk.Comment, sk.Cert()); err != nil {
+		return InvalidID, m.describeLoaded(ctx, err)
+	}
+	r := &Result{ID: id}
+	m.finishLoad(ctx, []*addedKey{{key: sk, decrypted: decrypted, result: r}})
+	return id, r.Err
+}
+
+// configureWrapped configures a new key, stored in FormatMasterKey. The key is
+// refused if the master key is not unlocked, rather than being stored
+// unencrypted in synced storage.
+func (m *DefaultManager) configureWrapped(ctx jsutil.AsyncContext, name, comment string, priv interface{}, pub ssh.PublicKey) error {
+	dek, err := m.unlockedDataKey(ctx)
+	if errors.Is(err, ErrLocked) {
+		return errProtocolKeyUnprotected
+	} else if err != nil {
+		return err
+	}
+	defer clear(dek)
+	wrapped, err := wrapKey(priv, comment, dek)
+	if err != nil {
+		return err
+	}
+
+	used, err := m.usedIDs(ctx)
+	if err != nil {
+		return err
+	}
+	id, err := allocateID(pub, used)
+	if err != nil {
+		return err
+	}
+	sk := &storedKey{
+		ID:            string(id),
+		Name:          name,
+		PEMPrivateKey: wrapped,
+	}
+	sk.SetPublic(pub)
+	return m.writeStoredKey(ctx, sk)
+}
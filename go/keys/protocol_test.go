@@ -0,0 +1,400 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serveConnection serves the manager's agent for a connection over a pipe,
+// returning a client that communicates with it.
+func serveConnection(mgr *DefaultManager) (agent.ExtendedAgent, func()) {
+	c, s := net.Pipe()
+	go agent.ServeAgent(mgr.ConnectionAgent(&Connection{Name: "client"}), s)
+	return agent.NewClient(c), func() { c.Close() }
+}
+
+func TestProtocolKeys(t *testing.T) {
+	t.Parallel()
+
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ED25519WithoutPassphrase.Private))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	pub := signer.PublicKey()
+
+	testcases := []struct {
+		description   string
+		policy        ProtocolKeyPolicy
+		key           agent.AddedKey
+		wantEphemeral bool
+		wantConfirm   bool
+		wantConfig    []*ConfiguredKey
+	}{
+		{
+			description:   "ephemeral",
+			key:           agent.AddedKey{PrivateKey: priv, Comment: "added"},
+			wantEphemeral: true,
+		},
+		{
+			description:   "ephemeral with confirmation",
+			key:           agent.AddedKey{PrivateKey: priv, Comment: "added", ConfirmBeforeUse: true},
+			wantEphemeral: true,
+			wantConfirm:   true,
+		},
+		{
+			description: "persist",
+			policy:      ProtocolKeysPersist,
+			key:         agent.AddedKey{PrivateKey: priv, Comment: "added"},
+			wantConfig:  []*ConfiguredKey{{Name: "added"}},
+		},
+		{
+			description: "persist with constraints",
+			policy:      ProtocolKeysPersist,
+			key:         agent.AddedKey{PrivateKey: priv, Comment: "added", ConfirmBeforeUse: true, LifetimeSecs: 60},
+			wantConfirm: true,
+			wantConfig:  []*ConfiguredKey{{Name: "added", ConfirmUse: true}},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+				// Deny all requests for confirmation, such that keys
+				// requiring it cannot sign.
+				mgr.SetConfirmer(func(*ConfirmRequest) bool { return false })
+				if tc.policy != "" {
+					if err := mgr.SetProtocolKeyPolicy(ctx, tc.policy); err != nil {
+						t.Fatalf("failed to set policy: %v", err)
+					}
+				}
+				if tc.policy == ProtocolKeysPersist {
+					if _, err := mgr.EnableMasterKey(ctx, "master", nil); err != nil {
+						t.Fatalf("EnableMasterKey failed: %v", err)
+					}
+				}
+
+				client, closeClient := serveConnection(mgr)
+				defer closeClient()
+
+				if err := client.Add(tc.key); err != nil {
+					t.Fatalf("Add failed: %v", err)
+				}
+
+				loaded, err := mgr.Loaded(ctx)
+				if err != nil {
+					t.Fatalf("failed to enumerate loaded keys: %v", err)
+				}
+				if len(loaded) != 1 {
+					t.Fatalf("incorrect number of loaded keys; got %d, want 1", len(loaded))
+				}
+				if loaded[0].Ephemeral != tc.wantEphemeral {
+					t.Errorf("incorrect ephemeral; got %t, want %t", loaded[0].Ephemeral, tc.wantEphemeral)
+				}
+
+				configured, err := mgr.Configured(ctx)
+				if err != nil {
+					t.Fatalf("failed to enumerate configured keys: %v", err)
+				}
+				var gotConfig []*ConfiguredKey
+				for _, k := range configured {
+					gotConfig = append(gotConfig, &ConfiguredKey{
+						Name:                k.Name,
+						ConfirmUse:          k.ConfirmUse,
+						IdleTimeoutMillis:   k.IdleTimeoutMillis,
+						OverrideIdleTimeout: k.OverrideIdleTimeout,
+					})
+				}
+				if diff := cmp.Diff(gotConfig, tc.wantConfig); diff != "" {
+					t.Errorf("incorrect configured keys; -got +want: %s", diff)
+				}
+
+				_, err = client.Sign(pub, []byte("data"))
+				if gotConfirm := err != nil; gotConfirm != tc.wantConfirm {
+					t.Errorf("incorrect confirmation; got %t, want %t (err=%v)", gotConfirm, tc.wantConfirm, err)
+				}
+
+				// Adding the key again succeeds without loading it
+				// twice.
+				if err := client.Add(tc.key); err != nil {
+					t.Errorf("Add failed for loaded key: %v", err)
+				}
+				if keys, err := client.List(); err != nil || len(keys) != 1 {
+					t.Errorf("incorrect keys after adding again; got %d keys, err=%v", len(keys), err)
+				}
+
+				// Removing the key unloads it, but leaves its
+				// configuration.
+				if err := client.Remove(pub); err != nil {
+					t.Errorf("Remove failed: %v", err)
+				}
+				if loaded, err := mgr.Loaded(ctx); err != nil || len(loaded) != 0 {
+					t.Errorf("incorrect loaded keys after Remove; got %d keys, err=%v", len(loaded), err)
+				}
+				if configured, err := mgr.Configured(ctx); err != nil || len(configured) != len(tc.wantConfig) {
+					t.Errorf("incorrect configured keys after Remove; got %d keys, err=%v", len(configured), err)
+				}
+			})
+		})
+	}
+}
+
+func TestProtocolKeyLifetime(t *testing.T) {
+	t.Parallel()
+
+	priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ED25519WithoutPassphrase.Private))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+
+	testcases := []struct {
+		description string
+		policy      ProtocolKeyPolicy
+		restart     bool
+	}{
+		{
+			description: "ephemeral",
+		},
+		{
+			description: "persist",
+			policy:      ProtocolKeysPersist,
+		},
+		{
+			description: "persist across restart",
+			policy:      ProtocolKeysPersist,
+			restart:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				syncStorage := storage.NewRaw(st.NewMemArea())
+				sessionStorage := storage.NewRaw(st.NewMemArea())
+				start := time.UnixMilli(1700000000000)
+				now := start
+				mgr := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+				mgr.now = func() time.Time { return now }
+				if tc.policy != "" {
+					if err := mgr.SetProtocolKeyPolicy(ctx, tc.policy); err != nil {
+						t.Fatalf("failed to set policy: %v", err)
+					}
+				}
+				if tc.policy == ProtocolKeysPersist {
+					if _, err := mgr.EnableMasterKey(ctx, "master", nil); err != nil {
+						t.Fatalf("EnableMasterKey failed: %v", err)
+					}
+				}
+				alarms := st.NewAlarms()
+				const alarmName = "unload-idle"
+				mgr.UnloadIdleWithAlarms(ctx, alarms, alarmName)
+
+				client, closeClient := serveConnection(mgr)
+				defer closeClient()
+				if err := client.Add(agent.AddedKey{PrivateKey: priv, Comment: "added", LifetimeSecs: 60}); err != nil {
+					t.Fatalf("Add failed: %v", err)
+				}
+				if diff := cmp.Diff(st.AlarmTime(alarms, alarmName), start.Add(time.Minute).UnixMilli()); diff != "" {
+					t.Errorf("incorrect alarm time; -got +want: %s", diff)
+				}
+
+				if tc.restart {
+					mgr = NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+					mgr.now = func() time.Time { return now }
+					if err := mgr.LoadFromSession(ctx); err != nil {
+						t.Fatalf("failed to load keys from session: %v", err)
+					}
+				}
+
+				// The key remains loaded until the end of its
+				// lifetime, even if it is not used.
+				now = start.Add(59 * time.Second)
+				if unloaded, err := mgr.UnloadIdle(ctx); err != nil || len(unloaded) != 0 {
+					t.Errorf("incorrect keys unloaded before end of lifetime; got %d, err=%v", len(unloaded), err)
+				}
+				now = start.Add(time.Minute)
+				if unloaded, err := mgr.UnloadIdle(ctx); err != nil || len(unloaded) != 1 {
+					t.Errorf("incorrect keys unloaded at end of lifetime; got %d, err=%v", len(unloaded), err)
+				}
+				if loaded, err := mgr.Loaded(ctx); err != nil || len(loaded) != 0 {
+					t.Errorf("incorrect loaded keys; got %d keys, err=%v", len(loaded), err)
+				}
+			})
+		})
+	}
+}
+
+func TestProtocolKeysPersistProtected(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		if err := mgr.SetProtocolKeyPolicy(ctx, ProtocolKeysPersist); err != nil {
+			t.Fatalf("failed to set policy: %v", err)
+		}
+		priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ED25519WithoutPassphrase.Private))
+		if err != nil {
+			t.Fatalf("failed to parse key: %v", err)
+		}
+		key := agent.AddedKey{PrivateKey: priv, Comment: "added"}
+
+		// Without the master key, the key would be stored
+		// unencrypted, so it is refused.
+		if err := mgr.addFromProtocol(ctx, key); !errors.Is(err, errProtocolKeyUnprotected) {
+			t.Errorf("incorrect error without master key; got %v, want %v", err, errProtocolKeyUnprotected)
+		}
+		if configured, err := mgr.Configured(ctx); err != nil || len(configured) != 0 {
+			t.Errorf("incorrect configured keys; got %d keys, err=%v", len(configured), err)
+		}
+
+		// With the master key unlocked, the key is stored wrapped.
+		if _, err := mgr.EnableMasterKey(ctx, "master", nil); err != nil {
+			t.Fatalf("EnableMasterKey failed: %v", err)
+		}
+		if err := mgr.addFromProtocol(ctx, key); err != nil {
+			t.Fatalf("addFromProtocol failed: %v", err)
+		}
+		keys, err := mgr.storedKeys.ReadAll(ctx)
+		if err != nil {
+			t.Fatalf("failed to read keys: %v", err)
+		}
+		if len(keys) != 1 || !isWrapped(keys[0].PEMPrivateKey) {
+			t.Errorf("key not stored wrapped: %+v", keys)
+		}
+	})
+}
+
+func TestProtocolKeysUnsupportedConstraint(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ED25519WithoutPassphrase.Private))
+		if err != nil {
+			t.Fatalf("failed to parse key: %v", err)
+		}
+		// agent.Client does not send constraint extensions, so the key
+		// is added directly.
+		err = mgr.addFromProtocol(ctx, agent.AddedKey{
+			PrivateKey:           priv,
+			Comment:              "added",
+			ConstraintExtensions: []agent.ConstraintExtension{{ExtensionName: "unknown@example.com"}},
+		})
+		if !errors.Is(err, errUnsupportedConstraint) {
+			t.Errorf("incorrect error; got %v, want %v", err, errUnsupportedConstraint)
+		}
+		if loaded, err := mgr.Loaded(ctx); err != nil || len(loaded) != 0 {
+			t.Errorf("incorrect loaded keys; got %d keys, err=%v", len(loaded), err)
+		}
+	})
+}
+
+func TestProtocolRemoveAll(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "configured", PEMPrivateKey: testdata.WithoutPassphrase.Private, Load: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		if _, err := mgr.LoadEphemeral(ctx, "ephemeral", testdata.ED25519WithoutPassphrase.Private, ""); err != nil {
+			t.Fatalf("failed to load ephemeral key: %v", err)
+		}
+
+		client, closeClient := serveConnection(mgr)
+		defer closeClient()
+
+		if err := client.RemoveAll(); err != nil {
+			t.Fatalf("RemoveAll failed: %v", err)
+		}
+		if loaded, err := mgr.Loaded(ctx); err != nil || len(loaded) != 0 {
+			t.Errorf("incorrect loaded keys after RemoveAll; got %d keys, err=%v", len(loaded), err)
+		}
+		if configured, err := mgr.Configured(ctx); err != nil || len(configured) != 1 {
+			t.Errorf("incorrect configured keys after RemoveAll; got %d keys, err=%v", len(configured), err)
+		}
+	})
+}
+
+func TestProtocolKeysLocked(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		if err := mgr.Lock(ctx, "passphrase"); err != nil {
+			t.Fatalf("failed to lock: %v", err)
+		}
+		client, closeClient := serveConnection(mgr)
+		defer closeClient()
+
+		priv, err := ssh.ParseRawPrivateKey([]byte(testdata.ED25519WithoutPassphrase.Private))
+		if err != nil {
+			t.Fatalf("failed to parse key: %v", err)
+		}
+		if err := client.Add(agent.AddedKey{PrivateKey: priv, Comment: "added"}); err == nil {
+			t.Errorf("Add succeeded while locked")
+		}
+		if err := client.RemoveAll(); err == nil {
+			t.Errorf("RemoveAll succeeded while locked")
+		}
+	})
+}
+
+func TestSetProtocolKeyPolicy(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+		if policy, err := mgr.ProtocolKeyPolicy(ctx); err != nil || policy != ProtocolKeysEphemeral {
+			t.Errorf("incorrect default policy; got %s, err=%v", policy, err)
+		}
+		if err := mgr.SetProtocolKeyPolicy(ctx, ProtocolKeysPersist); err != nil {
+			t.Errorf("failed to set policy: %v", err)
+		}
+		if policy, err := mgr.ProtocolKeyPolicy(ctx); err != nil || policy != ProtocolKeysPersist {
+			t.Errorf("incorrect policy; got %s, err=%v", policy, err)
+		}
+		if err := mgr.SetProtocolKeyPolicy(ctx, "bogus"); err == nil {
+			t.Errorf("invalid policy accepted")
+		}
+	})
+}
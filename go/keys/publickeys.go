@@ -0,0 +1,70 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// PublicKeyEntry is the public key for a configured key.
+type PublicKeyEntry struct {
+	// ID is the unique ID for the key.
+	ID string `js:"id"`
+	// Name is the name allocated to the key.
+	Name string `js:"name"`
+	// AuthorizedKey is the public key as a line in the authorized_keys
+	// format. The comment is that embedded in the private key, or the
+	// name of the key if there is none. It is empty if the public key is
+	// not known; this is the case for some encrypted keys until they are
+	// first loaded.
+	AuthorizedKey string `js:"authorizedKey"`
+}
+
+// authorizedKey returns pub as a line in the authorized_keys format.
+func authorizedKey(pub ssh.PublicKey, comment string) string {
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	if comment == "" {
+		return line
+	}
+	return fmt.Sprintf("%s %s", line, comment)
+}
+
+// PublicKeys implements Manager.PublicKeys.
+func (m *DefaultManager) PublicKeys(ctx jsutil.AsyncContext) ([]*PublicKeyEntry, error) {
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+
+	var result []*PublicKeyEntry
+	for _, k := range keys {
+		e := &PublicKeyEntry{ID: k.ID, Name: k.Name}
+		if pub := k.Public(); pub != nil {
+			comment := k.Comment
+			if comment == "" {
+				comment = k.Name
+			}
+			e.AuthorizedKey = authorizedKey(pub, comment)
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
@@ -0,0 +1,90 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestPublicKeys(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+			{Name: "unencrypted", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+			// The public key of an encrypted PKCS#8 key is not known
+			// until it is loaded.
+			{Name: "encrypted", PEMPrivateKey: testdata.PKCS8Format.Private},
+		})
+		if err != nil {
+			t.Errorf("failed to initialize manager: %v", err)
+			return
+		}
+		unencryptedID, err := findKey(ctx, mgr, InvalidID, "unencrypted")
+		if err != nil {
+			t.Errorf("failed to find key: %v", err)
+			return
+		}
+		encryptedID, err := findKey(ctx, mgr, InvalidID, "encrypted")
+		if err != nil {
+			t.Errorf("failed to find key: %v", err)
+			return
+		}
+
+		unencryptedLine := fmt.Sprintf("%s %s unencrypted", testdata.WithoutPassphrase.Type, testdata.WithoutPassphrase.Blob)
+		encryptedLine := fmt.Sprintf("%s %s encrypted", testdata.PKCS8Format.Type, testdata.PKCS8Format.Blob)
+		sortEntries := cmpopts.SortSlices(func(a, b *PublicKeyEntry) bool { return a.Name < b.Name })
+
+		entries, err := mgr.PublicKeys(ctx)
+		if err != nil {
+			t.Errorf("failed to get public keys: %v", err)
+			return
+		}
+		want := []*PublicKeyEntry{
+			{ID: string(unencryptedID), Name: "unencrypted", AuthorizedKey: unencryptedLine},
+			{ID: string(encryptedID), Name: "encrypted"},
+		}
+		if diff := cmp.Diff(entries, want, sortEntries); diff != "" {
+			t.Errorf("incorrect public keys before load; -got +want: %s", diff)
+		}
+
+		// Loading the key records its public key.
+		if err := mgr.Load(ctx, encryptedID, testdata.PKCS8Format.Passphrase); err != nil {
+			t.Errorf("failed to load key: %v", err)
+			return
+		}
+		entries, err = mgr.PublicKeys(ctx)
+		if err != nil {
+			t.Errorf("failed to get public keys: %v", err)
+			return
+		}
+		want[1].AuthorizedKey = encryptedLine
+		if diff := cmp.Diff(entries, want, sortEntries); diff != "" {
+			t.Errorf("incorrect public keys after load; -got +want: %s", diff)
+		}
+	})
+}
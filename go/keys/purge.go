@@ -0,0 +1,67 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// UnloadAll unloads all keys that are loaded, and returns their IDs. Keys that
+// fail to unload are logged and skipped.
+func (m *DefaultManager) UnloadAll(ctx jsutil.AsyncContext) ([]ID, error) {
+	loaded, err := m.Loaded(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var unloaded []ID
+	for _, k := range loaded {
+		id := k.ID()
+		if id == InvalidID {
+			continue
+		}
+		jsutil.LogDebug("DefaultManager.UnloadAll: unloading key ID %s", id)
+		if err := m.Unload(ctx, id); err != nil {
+			jsutil.LogError("failed to unload key ID %s: %v; skipping", id, err)
+			continue
+		}
+		unloaded = append(unloaded, id)
+	}
+	return unloaded, nil
+}
+
+// PurgeSecrets removes all secrets held for the current session: loaded keys
+// are unloaded, cached passphrases are forgotten, and the master key is
+// locked. Keys then cannot be used until they are loaded again using their
+// passphrase. Unlike Lock, no passphrase is required; this suits a user who
+// must secure the agent immediately.
+func (m *DefaultManager) PurgeSecrets(ctx jsutil.AsyncContext) error {
+	if _, err := m.UnloadAll(ctx); err != nil {
+		return fmt.Errorf("failed to unload keys: %w", err)
+	}
+	// Decrypted keys that failed to unload are wiped regardless.
+	m.wipeMemoryKeys()
+	if err := m.passphrases.Delete(ctx, func(*cachedPassphrase) bool { return true }); err != nil {
+		return fmt.Errorf("failed to remove cached passphrases: %w", err)
+	}
+	if err := m.LockMasterKey(ctx); err != nil {
+		return fmt.Errorf("failed to lock master key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestUnloadAll(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "loaded", PEMPrivateKey: testdata.WithoutPassphrase.Private, Load: true},
+			{Name: "encrypted", PEMPrivateKey: testdata.WithPassphrase.Private, Load: true, Passphrase: testdata.WithPassphrase.Passphrase},
+			{Name: "unloaded", PEMPrivateKey: testdata.ECDSAWithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		var want []ID
+		for _, name := range []string{"loaded", "encrypted"} {
+			id, err := findKey(ctx, mgr, InvalidID, name)
+			if err != nil {
+				t.Fatalf("failed to find key: %v", err)
+			}
+			want = append(want, id)
+		}
+
+		unloaded, err := mgr.UnloadAll(ctx)
+		if err != nil {
+			t.Fatalf("UnloadAll failed: %v", err)
+		}
+		if diff := cmp.Diff(unloaded, want, cmpopts.SortSlices(func(a, b ID) bool { return a < b })); diff != "" {
+			t.Errorf("incorrect keys unloaded; -got +want: %s", diff)
+		}
+		if loaded, err := mgr.Loaded(ctx); err != nil || len(loaded) != 0 {
+			t.Errorf("keys remain loaded: %v, %v", loadedKeyBlobs(loaded), err)
+		}
+
+		// Keys are not restored when the service worker restarts.
+		restarted := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		if err := restarted.LoadFromSession(ctx); err != nil {
+			t.Fatalf("LoadFromSession failed: %v", err)
+		}
+		if loaded, err := restarted.Loaded(ctx); err != nil || len(loaded) != 0 {
+			t.Errorf("keys restored after restart: %v, %v", loadedKeyBlobs(loaded), err)
+		}
+
+		// Nothing remains to be unloaded.
+		if unloaded, err := mgr.UnloadAll(ctx); err != nil || len(unloaded) != 0 {
+			t.Errorf("UnloadAll unloaded keys again: %v, %v", unloaded, err)
+		}
+	})
+}
+
+func TestPurgeSecrets(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "auto", PEMPrivateKey: testdata.WithPassphrase.Private},
+			{Name: "protected", PEMPrivateKey: testdata.ECDSAWithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		auto, err := findKey(ctx, mgr, InvalidID, "auto")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+		protected, err := findKey(ctx, mgr, InvalidID, "protected")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+
+		// The passphrase of a key loaded automatically is cached, as is
+		// the unlocked master key.
+		if err := mgr.SetAutoLoad(ctx, auto, true); err != nil {
+			t.Fatalf("SetAutoLoad failed: %v", err)
+		}
+		if err := mgr.Load(ctx, auto, testdata.WithPassphrase.Passphrase); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if _, err := mgr.EnableMasterKey(ctx, "master", []*LoadRequest{{ID: protected}}); err != nil {
+			t.Fatalf("EnableMasterKey failed: %v", err)
+		}
+		if err := mgr.Load(ctx, protected, ""); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+
+		if err := mgr.PurgeSecrets(ctx); err != nil {
+			t.Fatalf("PurgeSecrets failed: %v", err)
+		}
+
+		if loaded, err := mgr.Loaded(ctx); err != nil || len(loaded) != 0 {
+			t.Errorf("keys remain loaded: %v, %v", loadedKeyBlobs(loaded), err)
+		}
+		if cached, err := mgr.passphrases.ReadAll(ctx); err != nil || len(cached) != 0 {
+			t.Errorf("passphrases remain cached: %d, %v", len(cached), err)
+		}
+		if state, err := mgr.MasterKeyState(ctx); err != nil || state != MasterKeyLocked {
+			t.Errorf("incorrect master key state; got %s, %v, want %s", state, err, MasterKeyLocked)
+		}
+
+		// Neither key can be loaded automatically or without a
+		// passphrase.
+		if results, err := mgr.LoadAutoKeys(ctx); err != nil || len(results) != 0 {
+			t.Errorf("LoadAutoKeys loaded keys after purge: %v, %v", results, err)
+		}
+		if err := mgr.Load(ctx, protected, ""); err == nil {
+			t.Errorf("Load unexpectedly succeeded after purge")
+		}
+	})
+}
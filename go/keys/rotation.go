@@ -0,0 +1,125 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+var (
+	errRotationInProgress = errors.New("key is already being rotated")
+	errNotRotating        = errors.New("key is not being rotated")
+)
+
+// replacing indicates that the key being added replaces the key with the
+// specified ID.
+func replacing(id ID) AddOption {
+	return func(o *addOptions) {
+		o.replaces = id
+	}
+}
+
+// rotations returns the keys that are being rotated, mapped to the keys that
+// replace them. Keys that replace a key that is no longer configured are
+// ignored.
+func rotations(keys []*storedKey) map[ID]*storedKey {
+	configured := map[ID]bool{}
+	for _, k := range keys {
+		configured[ID(k.ID)] = true
+	}
+	result := map[ID]*storedKey{}
+	for _, k := range keys {
+		if k.Replaces != "" && configured[ID(k.Replaces)] {
+			result[ID(k.Replaces)] = k
+		}
+	}
+	return result
+}
+
+// StartRotation implements Manager.StartRotation.
+func (m *DefaultManager) StartRotation(ctx jsutil.AsyncContext, id ID, name string, keyType KeyType, bits int, passphrase string, progress ProgressFunc) (string, error) {
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read keys: %w", err)
+	}
+	found := false
+	for _, k := range keys {
+		if ID(k.ID) == id {
+			found = true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+	if s := rotations(keys)[id]; s != nil {
+		return "", fmt.Errorf("%w: key ID %s is being replaced by %s", errRotationInProgress, id, s.Name)
+	}
+	return m.generate(ctx, name, keyType, bits, passphrase, progress, replacing(id))
+}
+
+// CompleteRotation implements Manager.CompleteRotation.
+func (m *DefaultManager) CompleteRotation(ctx jsutil.AsyncContext, id ID, remove bool) error {
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read keys: %w", err)
+	}
+	successor := rotations(keys)[id]
+	if successor == nil {
+		return fmt.Errorf("%w: key ID %s", errNotRotating, id)
+	}
+
+	if remove {
+		// Removing the key also unloads it.
+		if err := m.Remove(ctx, id); err != nil {
+			return err
+		}
+	} else {
+		loaded, err := m.Loaded(ctx)
+		if err != nil {
+			return err
+		}
+		for _, l := range loaded {
+			if l.ID() != id {
+				continue
+			}
+			if err := m.Unload(ctx, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = m.storedKeys.Update(ctx, func(key *storedKey) bool { return key.ID == successor.ID }, func(key *storedKey) (*storedKey, error) {
+		updated := *key
+		updated.Replaces = ""
+		updated.RotationStarted = 0
+		return &updated, nil
+	})
+	return err
+}
+
+// superseded determines if the key with the specified ID is being replaced
+// by another key.
+func (m *DefaultManager) superseded(ctx jsutil.AsyncContext, id ID) (bool, error) {
+	keys, err := m.storedKeys.ReadAll(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to read keys: %w", err)
+	}
+	return rotations(keys)[id] != nil, nil
+}
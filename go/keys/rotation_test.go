@@ -0,0 +1,150 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestRotation(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		remove      bool
+		wantNames   []string
+	}{
+		{
+			description: "keep original",
+			wantNames:   []string{"new-key", "old-key"},
+		},
+		{
+			description: "remove original",
+			remove:      true,
+			wantNames:   []string{"new-key"},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+					{Name: "old-key", PEMPrivateKey: testdata.ED25519WithoutPassphrase.Private, Load: true},
+				})
+				if err != nil {
+					t.Fatalf("failed to initialize manager: %v", err)
+				}
+				oldID, err := findKey(ctx, mgr, InvalidID, "old-key")
+				if err != nil {
+					t.Fatalf("failed to find key: %v", err)
+				}
+
+				if _, err := mgr.StartRotation(ctx, InvalidID, "new-key", KeyTypeED25519, 0, "secret", nil); !errors.Is(err, errKeyNotFound) {
+					t.Errorf("StartRotation returned incorrect error for missing key: %v", err)
+				}
+				if err := mgr.CompleteRotation(ctx, oldID, tc.remove); !errors.Is(err, errNotRotating) {
+					t.Errorf("CompleteRotation returned incorrect error before starting: %v", err)
+				}
+				if _, err := mgr.StartRotation(ctx, oldID, "new-key", KeyTypeED25519, 0, "secret", nil); err != nil {
+					t.Fatalf("StartRotation failed: %v", err)
+				}
+				if _, err := mgr.StartRotation(ctx, oldID, "other-key", KeyTypeED25519, 0, "secret", nil); !errors.Is(err, errRotationInProgress) {
+					t.Errorf("StartRotation returned incorrect error when already rotating: %v", err)
+				}
+				newID, err := findKey(ctx, mgr, InvalidID, "new-key")
+				if err != nil {
+					t.Fatalf("failed to find key: %v", err)
+				}
+
+				// Both keys are linked, and may be loaded.
+				configured, err := mgr.Configured(ctx)
+				if err != nil {
+					t.Fatalf("failed to get configured keys: %v", err)
+				}
+				links := map[string][2]string{}
+				for _, k := range configured {
+					links[k.Name] = [2]string{k.Replaces, k.ReplacedBy}
+					if k.RotationStartedMillis == 0 {
+						t.Errorf("rotation start not reported for %s", k.Name)
+					}
+				}
+				wantLinks := map[string][2]string{
+					"old-key": {"", string(newID)},
+					"new-key": {string(oldID), ""},
+				}
+				if diff := cmp.Diff(links, wantLinks); diff != "" {
+					t.Errorf("incorrect rotation links; -got +want: %s", diff)
+				}
+				if err := mgr.Load(ctx, newID, "secret"); err != nil {
+					t.Errorf("failed to load new key: %v", err)
+				}
+
+				// Uses of the original key are flagged.
+				mgr.recordAudit(&AuditEntry{ID: string(oldID)})
+				mgr.recordAudit(&AuditEntry{ID: string(newID)})
+				var entries []*AuditEntry
+				poll(func() bool {
+					entries, err = mgr.AuditLog(ctx)
+					return err != nil || len(entries) == 2
+				})
+				superseded := map[string]bool{}
+				for _, e := range entries {
+					superseded[e.ID] = e.Superseded
+				}
+				wantSuperseded := map[string]bool{string(oldID): true, string(newID): false}
+				if diff := cmp.Diff(superseded, wantSuperseded); diff != "" {
+					t.Errorf("incorrect superseded entries; -got +want: %s", diff)
+				}
+
+				if err := mgr.CompleteRotation(ctx, oldID, tc.remove); err != nil {
+					t.Fatalf("CompleteRotation failed: %v", err)
+				}
+				loaded, err := mgr.Loaded(ctx)
+				if err != nil {
+					t.Fatalf("failed to get loaded keys: %v", err)
+				}
+				if diff := cmp.Diff(loadedKeyIds(loaded), []ID{newID}); diff != "" {
+					t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+				}
+				configured, err = mgr.Configured(ctx)
+				if err != nil {
+					t.Fatalf("failed to get configured keys: %v", err)
+				}
+				if diff := cmp.Diff(configuredKeyNames(configured), tc.wantNames); diff != "" {
+					t.Errorf("incorrect configured keys; -got +want: %s", diff)
+				}
+				for _, k := range configured {
+					if k.Replaces != "" || k.ReplacedBy != "" || k.RotationStartedMillis != 0 {
+						t.Errorf("rotation still reported for %s", k.Name)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,57 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+// secret holds sensitive data, such as a passphrase or an encoded private key,
+// such that it can be overwritten as soon as it is no longer needed. Otherwise,
+// it lingers in WebAssembly memory, which can be inspected using the browser's
+// developer tools.
+//
+// Go strings cannot be overwritten, so this only limits the number of copies
+// that linger; callers should avoid making further copies of the data.
+type secret struct {
+	data  []byte
+	wiped bool
+}
+
+// secretCreated is invoked whenever a secret is created. Overridden in tests
+// to check that secrets are wiped.
+var secretCreated = func(s *secret) {}
+
+// newSecret returns a secret holding data, which it takes ownership of.
+func newSecret(data []byte) *secret {
+	s := &secret{data: data}
+	secretCreated(s)
+	return s
+}
+
+// secretString returns a secret holding a copy of s.
+func secretString(s string) *secret {
+	return newSecret([]byte(s))
+}
+
+// Bytes returns the data held by the secret. It must not be retained after the
+// secret is wiped.
+func (s *secret) Bytes() []byte {
+	return s.data
+}
+
+// Wipe overwrites the data held by the secret.
+func (s *secret) Wipe() {
+	clear(s.data)
+	s.wiped = true
+}
@@ -0,0 +1,197 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestSecretWipe(t *testing.T) {
+	t.Parallel()
+
+	s := secretString("secret")
+	data := s.Bytes()
+	s.Wipe()
+	if !bytes.Equal(data, make([]byte, len("secret"))) {
+		t.Errorf("data not overwritten: %q", data)
+	}
+}
+
+// TestSecretsWiped checks that the secrets created while loading keys are
+// wiped, including when loading fails. It is not run in parallel, since it
+// overrides secretCreated.
+func TestSecretsWiped(t *testing.T) {
+	var created []*secret
+	secretCreated = func(s *secret) { created = append(created, s) }
+	defer func() { secretCreated = func(s *secret) {} }()
+
+	testcases := []struct {
+		description string
+		privateKey  string
+		passphrase  string
+		wantErr     bool
+	}{
+		{
+			description: "unencrypted key",
+			privateKey:  testdata.WithoutPassphrase.Private,
+		},
+		{
+			description: "encrypted key",
+			privateKey:  testdata.OpenSSHFormat.Private,
+			passphrase:  "secret",
+		},
+		{
+			description: "encrypted PKCS#8 key",
+			privateKey:  testdata.PKCS8Format.Private,
+			passphrase:  "secret",
+		},
+		{
+			description: "wrong passphrase",
+			privateKey:  testdata.OpenSSHFormat.Private,
+			passphrase:  "wrong",
+			wantErr:     true,
+		},
+		{
+			description: "wrong PKCS#8 passphrase",
+			privateKey:  testdata.PKCS8Format.Private,
+			passphrase:  "wrong",
+			wantErr:     true,
+		},
+		{
+			description: "missing passphrase",
+			privateKey:  testdata.OpenSSHFormat.Private,
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		created = nil
+		jut.DoSync(func(ctx jsutil.AsyncContext) {
+			mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), []*initialKey{
+				{Name: "key", PEMPrivateKey: tc.privateKey},
+			})
+			if err != nil {
+				t.Fatalf("%s: failed to initialize manager: %v", tc.description, err)
+			}
+			id, err := findKey(ctx, mgr, InvalidID, "key")
+			if err != nil {
+				t.Fatalf("%s: failed to find key: %v", tc.description, err)
+			}
+			created = nil
+			if err := mgr.Load(ctx, id, tc.passphrase); (err != nil) != tc.wantErr {
+				t.Errorf("%s: Load returned incorrect error: %v", tc.description, err)
+			}
+		})
+		if len(created) == 0 {
+			t.Errorf("%s: no secrets created", tc.description)
+		}
+		for i, s := range created {
+			if !s.wiped {
+				t.Errorf("%s: secret %d not wiped", tc.description, i)
+			}
+		}
+	}
+}
+
+func TestMemoryKeysWiped(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		do          func(ctx jsutil.AsyncContext, mgr *DefaultManager, id ID) error
+		retained    bool
+	}{
+		{
+			description: "unload",
+			do: func(ctx jsutil.AsyncContext, mgr *DefaultManager, id ID) error {
+				return mgr.Unload(ctx, id)
+			},
+		},
+		{
+			description: "remove",
+			do: func(ctx jsutil.AsyncContext, mgr *DefaultManager, id ID) error {
+				return mgr.Remove(ctx, id)
+			},
+		},
+		{
+			description: "purge secrets",
+			do: func(ctx jsutil.AsyncContext, mgr *DefaultManager, _ ID) error {
+				return mgr.PurgeSecrets(ctx)
+			},
+		},
+		{
+			description: "reload",
+			do: func(ctx jsutil.AsyncContext, mgr *DefaultManager, id ID) error {
+				return mgr.Load(ctx, id, testdata.WithPassphrase.Passphrase)
+			},
+			retained: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				mgr, err := newTestManager(ctx, agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()), nil)
+				if err != nil {
+					t.Fatalf("failed to initialize manager: %v", err)
+				}
+				if err := mgr.SetPersistSession(ctx, false); err != nil {
+					t.Fatalf("SetPersistSession failed: %v", err)
+				}
+				if err := mgr.Add(ctx, "key", testdata.WithPassphrase.Private); err != nil {
+					t.Fatalf("Add failed: %v", err)
+				}
+				id, err := findKey(ctx, mgr, InvalidID, "key")
+				if err != nil {
+					t.Fatalf("failed to find key: %v", err)
+				}
+				if err := mgr.Load(ctx, id, testdata.WithPassphrase.Passphrase); err != nil {
+					t.Fatalf("Load failed: %v", err)
+				}
+
+				// Hold on to the buffer retained by the manager.
+				mgr.keysMu.Lock()
+				retained := mgr.memoryKeys[id]
+				mgr.keysMu.Unlock()
+				if len(retained) == 0 {
+					t.Fatalf("decrypted key not retained in memory")
+				}
+
+				if err := tc.do(ctx, mgr, id); err != nil {
+					t.Fatalf("%s failed: %v", tc.description, err)
+				}
+				if !bytes.Equal(retained, make([]byte, len(retained))) {
+					t.Errorf("decrypted key not wiped")
+				}
+				if got := mgr.hasMemoryKey(id); got != tc.retained {
+					t.Errorf("incorrect retained state: got %t, want %t", got, tc.retained)
+				}
+			})
+		})
+	}
+}
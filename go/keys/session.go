@@ -0,0 +1,191 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// newSessionKey returns the session key for a key that was loaded now. If
+// persist is false, a copy of the decrypted key is retained in memory rather
+// than written to the session; the caller remains responsible for wiping key.
+func (m *DefaultManager) newSessionKey(id ID, key decryptedKey, comment string, persist bool) *sessionKey {
+	sk := &sessionKey{
+		ID:       string(id),
+		Comment:  comment,
+		LoadTime: m.now().UnixMilli(),
+	}
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	// Any decrypted key retained from an earlier load is replaced.
+	m.memoryKeys[id].wipe()
+	if persist || len(key) == 0 {
+		sk.PrivateKey = string(key)
+		delete(m.memoryKeys, id)
+		return sk
+	}
+	sk.InMemory = true
+	m.memoryKeys[id] = slices.Clone(key)
+	return sk
+}
+
+// memoryKey returns a copy of the decrypted key with the specified ID that is
+// retained in memory, or an empty key if none is retained. The caller must
+// wipe the copy.
+func (m *DefaultManager) memoryKey(id ID) decryptedKey {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	return slices.Clone(m.memoryKeys[id])
+}
+
+// hasMemoryKey returns true if the decrypted key with the specified ID is
+// retained in memory.
+func (m *DefaultManager) hasMemoryKey(id ID) bool {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	return len(m.memoryKeys[id]) > 0
+}
+
+// forgetLoaded discards the state retained for the keys with the specified
+// IDs while they are loaded. Decrypted keys retained in memory are wiped.
+func (m *DefaultManager) forgetLoaded(ids ...ID) {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	for _, id := range ids {
+		m.memoryKeys[id].wipe()
+		delete(m.memoryKeys, id)
+		delete(m.confirmUse, id)
+		delete(m.refuseSHA1, id)
+		delete(m.destinations, id)
+		delete(m.ephemeral, id)
+		delete(m.ephemeralLifetimes, id)
+	}
+}
+
+// wipeMemoryKeys wipes and discards all decrypted keys retained in memory.
+func (m *DefaultManager) wipeMemoryKeys() {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	for id, key := range m.memoryKeys {
+		key.wipe()
+		delete(m.memoryKeys, id)
+	}
+}
+
+// sessionPrivateKey returns a copy of the decrypted key for the session key,
+// wherever it is held. The caller must wipe the copy.
+func (m *DefaultManager) sessionPrivateKey(sk *sessionKey) decryptedKey {
+	if sk.InMemory {
+		return m.memoryKey(ID(sk.ID))
+	}
+	return decryptedKey(sk.PrivateKey)
+}
+
+// persistSession returns true if decrypted keys are persisted to the session.
+// If the setting cannot be read, keys are not persisted; at worst, they must
+// be loaded again if the service worker restarts.
+func (m *DefaultManager) persistSession(ctx jsutil.AsyncContext) bool {
+	persist, err := m.PersistSession(ctx)
+	if err != nil {
+		jsutil.LogError("failed to determine whether to persist keys to session: %v", err)
+		return false
+	}
+	return persist
+}
+
+// purgeLostKeys removes session keys that were held only in memory by a
+// previous instance, and are therefore no longer available. The remaining
+// session keys are returned. Failures are logged; the lost keys are still
+// excluded from the result.
+func (m *DefaultManager) purgeLostKeys(ctx jsutil.AsyncContext, sks []*sessionKey) []*sessionKey {
+	var remaining []*sessionKey
+	lost := map[ID]bool{}
+	for _, sk := range sks {
+		if sk.InMemory && !m.hasMemoryKey(ID(sk.ID)) {
+			lost[ID(sk.ID)] = true
+			continue
+		}
+		remaining = append(remaining, sk)
+	}
+	if len(lost) == 0 {
+		return remaining
+	}
+	if err := m.sessionKeys.Delete(ctx, func(sk *sessionKey) bool { return lost[ID(sk.ID)] }); err != nil {
+		jsutil.LogError("failed to remove lost session keys: %v", err)
+	}
+	for id := range lost {
+		m.forgetUse(ctx, id)
+	}
+	return remaining
+}
+
+// unloadRemoved unloads keys that are no longer configured, such that their
+// decrypted copies do not outlive them in the agent or the session. Failures
+// are logged.
+func (m *DefaultManager) unloadRemoved(ctx jsutil.AsyncContext, ids ...ID) {
+	remove := map[ID]bool{}
+	for _, id := range ids {
+		remove[id] = true
+		if _, err := m.removeFromAgent(ctx, id); err != nil {
+			jsutil.LogError("failed to unload removed key ID %s: %v", id, err)
+		}
+	}
+	m.forgetLoaded(ids...)
+	if err := m.sessionKeys.Delete(ctx, func(sk *sessionKey) bool { return remove[ID(sk.ID)] }); err != nil {
+		jsutil.LogError("failed to remove session keys: %v", err)
+	}
+	m.forgetUse(ctx, ids...)
+}
+
+// PersistSession implements Manager.PersistSession.
+func (m *DefaultManager) PersistSession(ctx jsutil.AsyncContext) (bool, error) {
+	s, err := m.settings.ReadKey(ctx, managerSettingsKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read settings: %w", err)
+	}
+	return s == nil || !s.MemoryOnly, nil
+}
+
+// SetPersistSession implements Manager.SetPersistSession.
+func (m *DefaultManager) SetPersistSession(ctx jsutil.AsyncContext, persist bool) error {
+	if err := m.updateSettings(ctx, func(s *managerSettings) {
+		s.MemoryOnly = !persist
+	}); err != nil {
+		return err
+	}
+
+	// Move the decrypted keys that are already loaded.
+	if _, err := m.sessionKeys.Update(ctx, func(sk *sessionKey) bool {
+		if persist {
+			return sk.InMemory && m.hasMemoryKey(ID(sk.ID))
+		}
+		return !sk.InMemory && sk.PrivateKey != ""
+	}, func(sk *sessionKey) (*sessionKey, error) {
+		key := m.sessionPrivateKey(sk)
+		defer key.wipe()
+		updated := m.newSessionKey(ID(sk.ID), key, sk.Comment, persist)
+		updated.LoadTime = sk.LoadTime
+		updated.LifetimeEnd = sk.LifetimeEnd
+		return updated, nil
+	}); err != nil {
+		return fmt.Errorf("failed to update session keys: %w", err)
+	}
+	return nil
+}
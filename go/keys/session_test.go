@@ -0,0 +1,180 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sessionPrivateKeys returns the private keys stored in the session, indexed
+// by ID.
+func sessionPrivateKeys(ctx jsutil.AsyncContext, mgr *DefaultManager) (map[ID]string, error) {
+	sks, err := mgr.sessionKeys.ReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := map[ID]string{}
+	for _, sk := range sks {
+		result[ID(sk.ID)] = sk.PrivateKey
+	}
+	return result, nil
+}
+
+func TestPersistSession(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		// Storage persists across multiple manager instances.
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "good-key", PEMPrivateKey: testdata.WithPassphrase.Private},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "good-key")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+
+		// Keys are persisted by default.
+		persist, err := mgr.PersistSession(ctx)
+		if err != nil {
+			t.Errorf("PersistSession failed: %v", err)
+		}
+		if !persist {
+			t.Errorf("incorrect default; got %t, want true", persist)
+		}
+
+		// Other settings are preserved when the setting changes.
+		if err := mgr.SetDefaultIdleTimeout(ctx, time.Hour); err != nil {
+			t.Fatalf("failed to set default idle timeout: %v", err)
+		}
+		if err := mgr.SetPersistSession(ctx, false); err != nil {
+			t.Fatalf("SetPersistSession failed: %v", err)
+		}
+		if timeout, err := mgr.DefaultIdleTimeout(ctx); err != nil || timeout != time.Hour {
+			t.Errorf("incorrect default idle timeout; got %s, %v; want %s", timeout, err, time.Hour)
+		}
+
+		// The decrypted key is not written to the session.
+		if err := mgr.Load(ctx, id, testdata.WithPassphrase.Passphrase); err != nil {
+			t.Fatalf("failed to load key: %v", err)
+		}
+		sessionPrivate, err := sessionPrivateKeys(ctx, mgr)
+		if err != nil {
+			t.Fatalf("failed to read session keys: %v", err)
+		}
+		if diff := cmp.Diff(sessionPrivate, map[ID]string{id: ""}); diff != "" {
+			t.Errorf("incorrect session keys; -got +want: %s", diff)
+		}
+
+		// A new instance cannot restore the key, and forgets it.
+		restarted := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		if err := restarted.LoadFromSession(ctx); err != nil {
+			t.Fatalf("failed to load keys from session: %v", err)
+		}
+		loaded, err := restarted.Loaded(ctx)
+		if err != nil {
+			t.Fatalf("failed to enumerate loaded keys: %v", err)
+		}
+		if len(loaded) != 0 {
+			t.Errorf("incorrect loaded keys; got %v, want none", loadedKeyIds(loaded))
+		}
+		sessionPrivate, err = sessionPrivateKeys(ctx, restarted)
+		if err != nil {
+			t.Fatalf("failed to read session keys: %v", err)
+		}
+		if len(sessionPrivate) != 0 {
+			t.Errorf("incorrect session keys; got %v, want none", sessionPrivate)
+		}
+
+		// Persisting again writes the loaded key to the session, such that
+		// a new instance restores it.
+		if err := mgr.Load(ctx, id, testdata.WithPassphrase.Passphrase); err != nil {
+			t.Fatalf("failed to load key: %v", err)
+		}
+		if err := mgr.SetPersistSession(ctx, true); err != nil {
+			t.Fatalf("SetPersistSession failed: %v", err)
+		}
+		restarted = NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		if err := restarted.LoadFromSession(ctx); err != nil {
+			t.Fatalf("failed to load keys from session: %v", err)
+		}
+		loaded, err = restarted.Loaded(ctx)
+		if err != nil {
+			t.Fatalf("failed to enumerate loaded keys: %v", err)
+		}
+		if diff := cmp.Diff(loadedKeyIds(loaded), []ID{id}); diff != "" {
+			t.Errorf("incorrect loaded key IDs; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestRemoveUnloadsKey(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "removed-key", PEMPrivateKey: testdata.WithPassphrase.Private, Load: true, Passphrase: testdata.WithPassphrase.Passphrase},
+			{Name: "other-key", PEMPrivateKey: testdata.WithoutPassphrase.Private, Load: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to initialize manager: %v", err)
+		}
+		removedID, err := findKey(ctx, mgr, InvalidID, "removed-key")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+		otherID, err := findKey(ctx, mgr, InvalidID, "other-key")
+		if err != nil {
+			t.Fatalf("failed to find key: %v", err)
+		}
+
+		if err := mgr.Remove(ctx, removedID); err != nil {
+			t.Fatalf("failed to remove key: %v", err)
+		}
+
+		loaded, err := mgr.Loaded(ctx)
+		if err != nil {
+			t.Fatalf("failed to enumerate loaded keys: %v", err)
+		}
+		if diff := cmp.Diff(loadedKeyIds(loaded), []ID{otherID}); diff != "" {
+			t.Errorf("incorrect loaded key IDs; -got +want: %s", diff)
+		}
+		sessionPrivate, err := sessionPrivateKeys(ctx, mgr)
+		if err != nil {
+			t.Fatalf("failed to read session keys: %v", err)
+		}
+		if _, ok := sessionPrivate[removedID]; ok {
+			t.Errorf("session key for removed key ID %s was not purged", removedID)
+		}
+	})
+}
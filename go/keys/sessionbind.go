@@ -0,0 +1,218 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sessionBindExtension is the name of the agent extension with which OpenSSH
+// clients (8.9 and later) identify the host to which they are connected. See
+// Section 1 of OpenSSH's PROTOCOL.agent.
+const sessionBindExtension = "session-bind@openssh.com"
+
+// maxSessionBinds is the maximum number of bindings recorded for a connection,
+// as with OpenSSH's agent.
+const maxSessionBinds = 16
+
+var (
+	errInvalidSessionBind  = errors.New("invalid session binding")
+	errSessionBindConflict = errors.New("session binding conflicts with earlier binding")
+)
+
+// sessionBind is a binding of a connection to the agent to an SSH session,
+// as reported by the client using the session-bind@openssh.com extension.
+type sessionBind struct {
+	// HostKey is the host key of the server to which the client is
+	// connected.
+	HostKey ssh.PublicKey
+	// SessionID is the exchange hash of the SSH session.
+	SessionID []byte
+	// Forwarding indicates that the agent is being forwarded to the host,
+	// rather than being used to authenticate to it.
+	Forwarding bool
+}
+
+// parseSessionBind parses the contents of a session-bind@openssh.com
+// extension message, and verifies that the host key signed the session ID.
+func parseSessionBind(contents []byte) (*sessionBind, error) {
+	var msg struct {
+		HostKey    []byte
+		SessionID  []byte
+		Signature  []byte
+		Forwarding bool
+	}
+	if err := ssh.Unmarshal(contents, &msg); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidSessionBind, err)
+	}
+	hostKey, err := ssh.ParsePublicKey(msg.HostKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse host key: %w", errInvalidSessionBind, err)
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(msg.Signature, &sig); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse signature: %w", errInvalidSessionBind, err)
+	}
+	if err := hostKey.Verify(msg.SessionID, &sig); err != nil {
+		return nil, fmt.Errorf("%w: incorrect signature: %w", errInvalidSessionBind, err)
+	}
+	return &sessionBind{
+		HostKey:    hostKey,
+		SessionID:  msg.SessionID,
+		Forwarding: msg.Forwarding,
+	}, nil
+}
+
+// sessionID returns the session ID at the start of data to be signed for
+// public key authentication. See RFC 4252 Section 7.
+func sessionID(data []byte) []byte {
+	var msg struct {
+		SessionID []byte
+		Rest      []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(data, &msg); err != nil {
+		return nil
+	}
+	return msg.SessionID
+}
+
+// connectionAgent serves a single connection to the agent. It records the
+// session bindings reported by the client, such that destination constraints
+// can be enforced for requests made over the connection, and records each
+// request to sign in the audit log.
+//
+// connectionAgent implements the agent.ExtendedAgent interface.
+type connectionAgent struct {
+	agent.ExtendedAgent
+	m *DefaultManager
+	// conn identifies the client.
+	conn *Connection
+
+	mu sync.Mutex
+	// binds are the bindings reported by the client, in order.
+	binds []*sessionBind
+}
+
+// ConnectionAgent returns an agent that serves a single connection; a new one
+// should be used for each connection. It behaves as the agent returned by
+// Agent, but additionally applies destination constraints using the session
+// bindings reported by the client. conn identifies the client in the audit
+// log, and to the user when confirming use of a key.
+func (m *DefaultManager) ConnectionAgent(conn *Connection) agent.ExtendedAgent {
+	return &connectionAgent{ExtendedAgent: m.locker, m: m, conn: conn}
+}
+
+// bindings returns the bindings reported by the client, in order, along with
+// the most recent, which is nil if there are none.
+func (a *connectionAgent) bindings() ([]*sessionBind, *sessionBind) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.binds) == 0 {
+		return nil, nil
+	}
+	return slices.Clone(a.binds), a.binds[len(a.binds)-1]
+}
+
+// Sign implements agent.Agent.Sign().
+func (a *connectionAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.sign(key, data, func(r *requester) (*ssh.Signature, error) {
+		return a.m.locker.signFor(r, key, data, 0)
+	})
+}
+
+// SignWithFlags implements agent.ExtendedAgent.SignWithFlags().
+func (a *connectionAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	return a.sign(key, data, func(r *requester) (*ssh.Signature, error) {
+		return a.m.locker.signFor(r, key, data, flags)
+	})
+}
+
+// sign applies destination constraints for the key, and invokes f to sign on
+// behalf of the client if they allow it. The outcome is recorded in the audit
+// log.
+func (a *connectionAgent) sign(key ssh.PublicKey, data []byte, f func(r *requester) (*ssh.Signature, error)) (*ssh.Signature, error) {
+	id := a.m.agent.lookup(key)
+	binds, bind := a.bindings()
+	var sig *ssh.Signature
+	err := a.m.checkDestination(id, data, binds)
+	if err == nil {
+		sig, err = f(&requester{conn: a.conn, bind: bind})
+	}
+
+	fingerprint, _ := fingerprints(key)
+	e := &AuditEntry{
+		ID:                string(id),
+		FingerprintSHA256: fingerprint,
+		Connection:        a.conn.String(),
+		Host:              a.m.describeHost(id, bind),
+	}
+	if a.conn != nil {
+		e.ExtensionID = a.conn.ExtensionID
+		e.ConnectionName = a.conn.Name
+		if !a.conn.Connected.IsZero() {
+			e.ConnectedMillis = a.conn.Connected.UnixMilli()
+		}
+	}
+	if err != nil {
+		e.Refused = err.Error()
+	}
+	if refusedByConstraint(err) && a.m.onRefused != nil {
+		// The entry is modified when it is recorded.
+		refused := *e
+		a.m.onRefused(&refused)
+	}
+	a.m.recordAudit(e)
+	return sig, err
+}
+
+// bindSession handles the session-bind@openssh.com extension, recording the
+// binding reported by the client. As with OpenSSH's agent, a connection that
+// was bound for authentication may not be bound again, and a session may not
+// be bound to a different host key; a binding that was already recorded is
+// accepted, but not recorded again.
+func (a *connectionAgent) bindSession(contents []byte) ([]byte, error) {
+	b, err := parseSessionBind(contents)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, prev := range a.binds {
+		if !prev.Forwarding {
+			return nil, fmt.Errorf("%w: connection already bound for authentication", errSessionBindConflict)
+		}
+		if !bytes.Equal(prev.SessionID, b.SessionID) {
+			continue
+		}
+		if !bytes.Equal(prev.HostKey.Marshal(), b.HostKey.Marshal()) {
+			return nil, fmt.Errorf("%w: session already bound to a different host key", errSessionBindConflict)
+		}
+		return nil, nil
+	}
+	if len(a.binds) >= maxSessionBinds {
+		return nil, fmt.Errorf("%w: too many bindings", errSessionBindConflict)
+	}
+	a.binds = append(a.binds, b)
+	return nil, nil
+}
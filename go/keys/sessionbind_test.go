@@ -0,0 +1,366 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// newHostKey returns a new host key.
+func newHostKey(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	return signer
+}
+
+// makeSessionBind returns the contents of a session-bind@openssh.com extension
+// message. The session ID is signed using signer, which may differ from the
+// host key.
+func makeSessionBind(t *testing.T, hostKey ssh.PublicKey, signer ssh.Signer, sessionID []byte, forwarding bool) []byte {
+	t.Helper()
+	sig, err := signer.Sign(rand.Reader, sessionID)
+	if err != nil {
+		t.Fatalf("failed to sign session ID: %v", err)
+	}
+	return ssh.Marshal(struct {
+		HostKey    []byte
+		SessionID  []byte
+		Signature  []byte
+		Forwarding bool
+	}{
+		HostKey:    hostKey.Marshal(),
+		SessionID:  sessionID,
+		Signature:  ssh.Marshal(sig),
+		Forwarding: forwarding,
+	})
+}
+
+// Session bindings captured from an OpenSSH 9.2p1 client connecting to a host
+// with capturedHostKey, authenticating and then forwarding the agent in the
+// session with capturedSessionID. Each is the complete extension request
+// sent to the agent, excluding its length.
+const (
+	capturedHostKey   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIEeL+dq/frwwB63rRHbOi2xZsKn3/Sw3UZGfmCtTWKRj"
+	capturedSessionID = "29c32b02484724cb8951832ffec92ca9b43b7d9a068ba8ed37963b40912ad911"
+	capturedAuthBind  = "1b0000001873657373696f6e2d62696e64406f70656e7373682e636f6d000000" +
+		"330000000b7373682d6564323535313900000020478bf9dabf7ebc3007adeb44" +
+		"76ce8b6c59b0a9f7fd2c3751919f982b5358a4630000002029c32b02484724cb" +
+		"8951832ffec92ca9b43b7d9a068ba8ed37963b40912ad911000000530000000b" +
+		"7373682d6564323535313900000040961a8c3beccadff9199aa1db52d7043ce8" +
+		"0f7032c7e88d981e431541316490462b775d208bcac2d345477bb60438c3de76" +
+		"cdcd54d2a84cf37610c286f5ce380000"
+	capturedForwardBind = "1b0000001873657373696f6e2d62696e64406f70656e7373682e636f6d000000" +
+		"330000000b7373682d6564323535313900000020478bf9dabf7ebc3007adeb44" +
+		"76ce8b6c59b0a9f7fd2c3751919f982b5358a4630000002029c32b02484724cb" +
+		"8951832ffec92ca9b43b7d9a068ba8ed37963b40912ad911000000530000000b" +
+		"7373682d6564323535313900000040961a8c3beccadff9199aa1db52d7043ce8" +
+		"0f7032c7e88d981e431541316490462b775d208bcac2d345477bb60438c3de76" +
+		"cdcd54d2a84cf37610c286f5ce380001"
+)
+
+func TestParseSessionBind(t *testing.T) {
+	t.Parallel()
+
+	hostKey := newHostKey(t)
+	otherKey := newHostKey(t)
+	sessionID := []byte("session-id")
+
+	testcases := []struct {
+		description string
+		contents    []byte
+		want        *sessionBind
+		wantErr     error
+	}{
+		{
+			description: "authentication",
+			contents:    makeSessionBind(t, hostKey.PublicKey(), hostKey, sessionID, false),
+			want: &sessionBind{
+				HostKey:   hostKey.PublicKey(),
+				SessionID: sessionID,
+			},
+		},
+		{
+			description: "forwarding",
+			contents:    makeSessionBind(t, hostKey.PublicKey(), hostKey, sessionID, true),
+			want: &sessionBind{
+				HostKey:    hostKey.PublicKey(),
+				SessionID:  sessionID,
+				Forwarding: true,
+			},
+		},
+		{
+			description: "signed by other key",
+			contents:    makeSessionBind(t, hostKey.PublicKey(), otherKey, sessionID, false),
+			wantErr:     errInvalidSessionBind,
+		},
+		{
+			description: "truncated",
+			contents:    makeSessionBind(t, hostKey.PublicKey(), hostKey, sessionID, false)[:20],
+			wantErr:     errInvalidSessionBind,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseSessionBind(tc.contents)
+			if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("incorrect error; -got +want: %s", diff)
+			}
+			if diff := cmp.Diff(got, tc.want, publicKeyCmp); diff != "" {
+				t.Errorf("incorrect binding; -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+// publicKeyCmp compares public keys by their wire format.
+var publicKeyCmp = cmp.Comparer(func(a, b ssh.PublicKey) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return string(a.Marshal()) == string(b.Marshal())
+})
+
+// mustDecodeHex decodes a hex-encoded capture.
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("failed to decode capture: %v", err)
+	}
+	return b
+}
+
+// requestRaw sends a request to the agent over c, and returns the response.
+// Both exclude the length with which they are sent.
+func requestRaw(t *testing.T, c net.Conn, req []byte) []byte {
+	t.Helper()
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(req)))
+	if _, err := c.Write(append(l[:], req...)); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	if _, err := io.ReadFull(c, l[:]); err != nil {
+		t.Fatalf("failed to read response length: %v", err)
+	}
+	rsp := make([]byte, binary.BigEndian.Uint32(l[:]))
+	if _, err := io.ReadFull(c, rsp); err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	return rsp
+}
+
+func TestCapturedSessionBind(t *testing.T) {
+	t.Parallel()
+
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(capturedHostKey))
+	if err != nil {
+		t.Fatalf("failed to parse host key: %v", err)
+	}
+	sessionID := mustDecodeHex(t, capturedSessionID)
+
+	for _, tc := range []struct {
+		description string
+		capture     string
+		want        *sessionBind
+	}{
+		{
+			description: "authentication",
+			capture:     capturedAuthBind,
+			want:        &sessionBind{HostKey: hostKey, SessionID: sessionID},
+		},
+		{
+			description: "forwarding",
+			capture:     capturedForwardBind,
+			want:        &sessionBind{HostKey: hostKey, SessionID: sessionID, Forwarding: true},
+		},
+	} {
+		req := mustDecodeHex(t, tc.capture)
+		var msg struct {
+			Type     byte
+			Name     string
+			Contents []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(req, &msg); err != nil {
+			t.Errorf("%s: failed to parse request: %v", tc.description, err)
+			continue
+		}
+		if msg.Type != 27 || msg.Name != sessionBindExtension { // SSH_AGENTC_EXTENSION
+			t.Errorf("%s: incorrect request; got type %d for %s", tc.description, msg.Type, msg.Name)
+		}
+		got, err := parseSessionBind(msg.Contents)
+		if err != nil {
+			t.Errorf("%s: failed to parse binding: %v", tc.description, err)
+		}
+		if diff := cmp.Diff(got, tc.want, publicKeyCmp); diff != "" {
+			t.Errorf("%s: incorrect binding; -got +want: %s", tc.description, diff)
+		}
+	}
+
+	// The client binds each connection it makes to the agent; the one
+	// used to authenticate is not bound again.
+	mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+	for _, step := range []struct {
+		description string
+		captures    []string
+		want        []bool
+	}{
+		{
+			description: "authentication",
+			captures:    []string{capturedAuthBind, capturedForwardBind},
+			want:        []bool{true, false},
+		},
+		{
+			description: "forwarding",
+			captures:    []string{capturedForwardBind, capturedForwardBind, capturedAuthBind},
+			want:        []bool{true, true, true},
+		},
+	} {
+		c, s := net.Pipe()
+		go agent.ServeAgent(mgr.ConnectionAgent(&Connection{Name: "client"}), s)
+		for i, capture := range step.captures {
+			rsp := requestRaw(t, c, mustDecodeHex(t, capture))
+			if accepted := len(rsp) > 0 && rsp[0] == agentSuccess; accepted != step.want[i] {
+				t.Errorf("%s: incorrect response to binding %d; got %x, want accepted=%t", step.description, i, rsp, step.want[i])
+			}
+		}
+		c.Close()
+	}
+}
+
+func TestSessionBindConflicts(t *testing.T) {
+	t.Parallel()
+
+	hostKey := newHostKey(t)
+	otherKey := newHostKey(t)
+
+	// bind describes a binding reported by the client.
+	type bind struct {
+		key        ssh.Signer
+		sessionID  string
+		forwarding bool
+		wantErr    error
+	}
+	type testcase struct {
+		description string
+		binds       []bind
+		wantBinds   int
+	}
+	testcases := []testcase{
+		{
+			description: "rebound after authentication",
+			binds: []bind{
+				{key: hostKey, sessionID: "session-1"},
+				{key: otherKey, sessionID: "session-2", wantErr: errSessionBindConflict},
+			},
+			wantBinds: 1,
+		},
+		{
+			description: "repeated after authentication",
+			binds: []bind{
+				{key: hostKey, sessionID: "session-1"},
+				{key: hostKey, sessionID: "session-1", wantErr: errSessionBindConflict},
+			},
+			wantBinds: 1,
+		},
+		{
+			description: "forwarded through several hosts",
+			binds: []bind{
+				{key: hostKey, sessionID: "session-1", forwarding: true},
+				{key: otherKey, sessionID: "session-2", forwarding: true},
+				{key: hostKey, sessionID: "session-3"},
+			},
+			wantBinds: 3,
+		},
+		{
+			description: "repeated while forwarding",
+			binds: []bind{
+				{key: hostKey, sessionID: "session-1", forwarding: true},
+				{key: hostKey, sessionID: "session-1", forwarding: true},
+			},
+			wantBinds: 1,
+		},
+		{
+			description: "session bound to other host key",
+			binds: []bind{
+				{key: hostKey, sessionID: "session-1", forwarding: true},
+				{key: otherKey, sessionID: "session-1", forwarding: true, wantErr: errSessionBindConflict},
+			},
+			wantBinds: 1,
+		},
+	}
+
+	// Bindings are limited.
+	tooMany := testcase{description: "too many bindings", wantBinds: maxSessionBinds}
+	for i := 0; i <= maxSessionBinds; i++ {
+		b := bind{key: hostKey, sessionID: fmt.Sprintf("session-%d", i), forwarding: true}
+		if i == maxSessionBinds {
+			b.wantErr = errSessionBindConflict
+		}
+		tooMany.binds = append(tooMany.binds, b)
+	}
+	testcases = append(testcases, tooMany)
+
+	// Repeating an existing binding is accepted once the limit is reached.
+	repeated := testcase{description: "repeated when full", wantBinds: maxSessionBinds}
+	repeated.binds = append(repeated.binds, tooMany.binds[:maxSessionBinds]...)
+	repeated.binds = append(repeated.binds, tooMany.binds[0])
+	testcases = append(testcases, repeated)
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+			a := mgr.ConnectionAgent(&Connection{Name: "client"}).(*connectionAgent)
+			for i, b := range tc.binds {
+				_, err := a.bindSession(makeSessionBind(t, b.key.PublicKey(), b.key, []byte(b.sessionID), b.forwarding))
+				if diff := cmp.Diff(err, b.wantErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("binding %d: incorrect error; -got +want: %s", i, diff)
+				}
+			}
+			if n := len(a.binds); n != tc.wantBinds {
+				t.Errorf("incorrect number of bindings recorded; got %d, want %d", n, tc.wantBinds)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var errSHA1Refused = errors.New("SHA-1 signatures refused")
+
+// isRSA returns true if the public key (or the key underlying a certificate)
+// is an RSA key.
+func isRSA(key ssh.PublicKey) bool {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+	return key.Type() == ssh.KeyAlgoRSA
+}
+
+// signatureFlags returns the flags that apply when signing with the key. As
+// with OpenSSH, the RSA flags are ignored for other key types, SHA-256 is
+// preferred if both SHA-2 flags are set, and unknown flags are ignored.
+func signatureFlags(key ssh.PublicKey, flags agent.SignatureFlags) agent.SignatureFlags {
+	switch {
+	case !isRSA(key):
+		return 0
+	case flags&agent.SignatureFlagRsaSha256 != 0:
+		return agent.SignatureFlagRsaSha256
+	case flags&agent.SignatureFlagRsaSha512 != 0:
+		return agent.SignatureFlagRsaSha512
+	default:
+		return 0
+	}
+}
+
+// applyRefuseSHA1 applies the SHA-1 setting for a key.
+func (m *DefaultManager) applyRefuseSHA1(key *storedKey) {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
+	if !key.RefuseSHA1 {
+		delete(m.refuseSHA1, ID(key.ID))
+		return
+	}
+	m.refuseSHA1[ID(key.ID)] = true
+}
+
+// allowSHA1 is invoked by the agent before the key with the specified ID is
+// used to produce a SHA-1 signature. It returns true if the key permits it.
+func (m *DefaultManager) allowSHA1(id ID) bool {
+	m.keysMu.Lock()
+	refuse := m.refuseSHA1[id]
+	m.keysMu.Unlock()
+	if refuse {
+		jsutil.LogDebug("DefaultManager.allowSHA1: refusing SHA-1 signature for key ID %s", id)
+		return false
+	}
+	return true
+}
+
+// SetRefuseSHA1 implements Manager.SetRefuseSHA1.
+func (m *DefaultManager) SetRefuseSHA1(ctx jsutil.AsyncContext, id ID, refuse bool) error {
+	var key *storedKey
+	n, err := m.storedKeys.Update(ctx, func(key *storedKey) bool { return ID(key.ID) == id }, func(k *storedKey) (*storedKey, error) {
+		updated := *k
+		updated.RefuseSHA1 = refuse
+		key = &updated
+		return &updated, nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+
+	// Apply the setting regardless of whether the key is loaded; it is
+	// only consulted for loaded keys.
+	m.applyRefuseSHA1(key)
+	return nil
+}
@@ -0,0 +1,215 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// Message numbers from the SSH agent protocol.
+	agentFailure      = 5
+	agentSignRequest  = 13
+	agentSignResponse = 14
+)
+
+// rawSignRequest is SSH_AGENTC_SIGN_REQUEST.
+type rawSignRequest struct {
+	KeyBlob []byte `sshtype:"13"`
+	Data    []byte
+	Flags   uint32
+}
+
+// rawSignResponse is SSH_AGENT_SIGN_RESPONSE.
+type rawSignResponse struct {
+	SigBlob []byte `sshtype:"14"`
+}
+
+// rawSign sends a sign request over the connection, and returns the signature
+// from the response. An error is returned if the agent reports failure.
+func rawSign(conn net.Conn, keyBlob, data []byte, flags uint32) (*ssh.Signature, error) {
+	req := ssh.Marshal(rawSignRequest{KeyBlob: keyBlob, Data: data, Flags: flags})
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(req)))
+	if _, err := conn.Write(append(msg, req...)); err != nil {
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+
+	var length [4]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, fmt.Errorf("failed to read response length: %w", err)
+	}
+	rsp := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := io.ReadFull(conn, rsp); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	switch rsp[0] {
+	case agentFailure:
+		return nil, fmt.Errorf("agent failure")
+	case agentSignResponse:
+		var sr rawSignResponse
+		if err := ssh.Unmarshal(rsp, &sr); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+		var sig ssh.Signature
+		if err := ssh.Unmarshal(sr.SigBlob, &sig); err != nil {
+			return nil, fmt.Errorf("failed to parse signature: %w", err)
+		}
+		return &sig, nil
+	default:
+		return nil, fmt.Errorf("unexpected response type %d", rsp[0])
+	}
+}
+
+func TestSignatureAlgorithms(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		key         testdata.TestKey
+		flags       uint32
+		refuseSHA1  bool
+		wantFormat  string
+		wantFailure bool
+	}{
+		{
+			description: "RSA without flags",
+			key:         testdata.WithoutPassphrase,
+			wantFormat:  ssh.KeyAlgoRSA,
+		},
+		{
+			description: "RSA with SHA-256 flag",
+			key:         testdata.WithoutPassphrase,
+			flags:       uint32(agent.SignatureFlagRsaSha256),
+			wantFormat:  ssh.KeyAlgoRSASHA256,
+		},
+		{
+			description: "RSA with SHA-512 flag",
+			key:         testdata.WithoutPassphrase,
+			flags:       uint32(agent.SignatureFlagRsaSha512),
+			wantFormat:  ssh.KeyAlgoRSASHA512,
+		},
+		{
+			description: "RSA with both SHA-2 flags prefers SHA-256",
+			key:         testdata.WithoutPassphrase,
+			flags:       uint32(agent.SignatureFlagRsaSha256 | agent.SignatureFlagRsaSha512),
+			wantFormat:  ssh.KeyAlgoRSASHA256,
+		},
+		{
+			description: "RSA with unknown flag",
+			key:         testdata.WithoutPassphrase,
+			flags:       uint32(agent.SignatureFlagReserved),
+			wantFormat:  ssh.KeyAlgoRSA,
+		},
+		{
+			description: "RSA without flags when SHA-1 refused",
+			key:         testdata.WithoutPassphrase,
+			refuseSHA1:  true,
+			wantFailure: true,
+		},
+		{
+			description: "RSA with SHA-512 flag when SHA-1 refused",
+			key:         testdata.WithoutPassphrase,
+			flags:       uint32(agent.SignatureFlagRsaSha512),
+			refuseSHA1:  true,
+			wantFormat:  ssh.KeyAlgoRSASHA512,
+		},
+		{
+			description: "ED25519 ignores RSA flags",
+			key:         testdata.ED25519WithoutPassphrase,
+			flags:       uint32(agent.SignatureFlagRsaSha256),
+			refuseSHA1:  true,
+			wantFormat:  ssh.KeyAlgoED25519,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				syncStorage := storage.NewRaw(st.NewMemArea())
+				sessionStorage := storage.NewRaw(st.NewMemArea())
+				mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+					{Name: "key", PEMPrivateKey: tc.key.Private, Load: true},
+				})
+				if err != nil {
+					t.Errorf("failed to initialize manager: %v", err)
+					return
+				}
+				id, err := findKey(ctx, mgr, InvalidID, "key")
+				if err != nil {
+					t.Errorf("failed to find key: %v", err)
+					return
+				}
+				if err := mgr.SetRefuseSHA1(ctx, id, tc.refuseSHA1); err != nil {
+					t.Errorf("failed to set SHA-1 setting: %v", err)
+					return
+				}
+
+				blob, err := base64.StdEncoding.DecodeString(tc.key.Blob)
+				if err != nil {
+					t.Errorf("failed to decode public key: %v", err)
+					return
+				}
+				pub, err := ssh.ParsePublicKey(blob)
+				if err != nil {
+					t.Errorf("failed to parse public key: %v", err)
+					return
+				}
+
+				c, s := net.Pipe()
+				defer c.Close()
+				go agent.ServeAgent(mgr.Agent(), s)
+
+				data := []byte("data to sign")
+				sig, err := rawSign(c, blob, data, tc.flags)
+				if tc.wantFailure {
+					if err == nil {
+						t.Errorf("sign unexpectedly succeeded with format %s", sig.Format)
+					}
+					return
+				}
+				if err != nil {
+					t.Errorf("failed to sign: %v", err)
+					return
+				}
+				if sig.Format != tc.wantFormat {
+					t.Errorf("incorrect signature format; got %s, want %s", sig.Format, tc.wantFormat)
+				}
+				// Verification selects the hash using the format, so
+				// it fails if the blob does not match the format.
+				if err := pub.Verify(data, sig); err != nil {
+					t.Errorf("failed to verify signature: %v", err)
+				}
+			})
+		})
+	}
+}
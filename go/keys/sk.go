@@ -0,0 +1,336 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// CredentialRequest is a request to create a credential on a security key.
+type CredentialRequest struct {
+	// RPID is the WebAuthn relying party ID for which the credential is
+	// created. It is the application of the resulting OpenSSH key.
+	RPID string
+	// UserName is a human-readable name for the credential.
+	UserName string
+	// Challenge is random data included in the attestation.
+	Challenge []byte
+}
+
+// Credential is a credential created on a security key.
+type Credential struct {
+	// ID is the credential ID; that is, the key handle.
+	ID []byte
+	// PublicKey is the DER-encoded SubjectPublicKeyInfo of the credential's
+	// public key.
+	PublicKey []byte
+}
+
+// AssertionRequest is a request to sign using a credential on a security key.
+type AssertionRequest struct {
+	// RPID is the WebAuthn relying party ID for which the credential was
+	// created.
+	RPID string
+	// CredentialID is the ID of the credential to use.
+	CredentialID []byte
+	// Challenge is the data to be signed.
+	Challenge []byte
+}
+
+// Assertion is a signature produced by a security key, in the form returned by
+// the WebAuthn API.
+type Assertion struct {
+	// AuthenticatorData contains the relying party ID hash, flags and
+	// signature counter.
+	AuthenticatorData []byte
+	// ClientDataJSON is the client data, which includes the challenge.
+	ClientDataJSON []byte
+	// Signature is the DER-encoded signature over AuthenticatorData and
+	// the hash of ClientDataJSON.
+	Signature []byte
+}
+
+// Authenticator performs operations on security keys. Implementations
+// typically use the WebAuthn API, which drives the browser's own prompts for
+// user presence.
+type Authenticator interface {
+	// Create creates a credential on a security key.
+	Create(ctx jsutil.AsyncContext, req *CredentialRequest) (*Credential, error)
+	// Get signs the challenge using a credential on a security key.
+	Get(ctx jsutil.AsyncContext, req *AssertionRequest) (*Assertion, error)
+}
+
+var (
+	errNoAuthenticator      = errors.New("security keys not supported")
+	errSecurityKeyFailed    = errors.New("security key operation failed")
+	errSecurityKeyOperation = errors.New("operation not supported for security keys")
+)
+
+const (
+	// FormatSecurityKey indicates that the private key is held on a
+	// security key, rather than being stored.
+	FormatSecurityKey = "security-key"
+
+	// skUserPresenceRequired is the OpenSSH key flag indicating that the
+	// user must touch the security key for each signature.
+	skUserPresenceRequired = 0x01
+
+	// webauthnSigFormat is the OpenSSH signature format for signatures
+	// produced using WebAuthn. OpenSSH supports this format only for ECDSA
+	// keys, which is why Ed25519 security keys cannot be supported.
+	webauthnSigFormat = "webauthn-sk-ecdsa-sha2-nistp256@openssh.com"
+)
+
+// skHandle is the data stored for a key held on a security key. The private
+// key never leaves the security key; instead, the key handle identifies it.
+type skHandle struct {
+	Application string
+	Flags       uint8
+	KeyHandle   []byte
+}
+
+// SetSKHandle sets the security key handle for the stored key.
+func (s *storedKey) SetSKHandle(h *skHandle) {
+	s.SecurityKey = base64.StdEncoding.EncodeToString(ssh.Marshal(h))
+}
+
+// SKHandle returns the security key handle for the stored key, or nil if the
+// private key is not held on a security key.
+func (s *storedKey) SKHandle() *skHandle {
+	if !s.IsSecurityKey() {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s.SecurityKey)
+	if err != nil {
+		jsutil.LogError("failed to decode security key handle for key ID %s: %v", s.ID, err)
+		return nil
+	}
+	var h skHandle
+	if err := ssh.Unmarshal(b, &h); err != nil {
+		jsutil.LogError("failed to parse security key handle for key ID %s: %v", s.ID, err)
+		return nil
+	}
+	return &h
+}
+
+// IsSecurityKey determines if the private key is held on a security key.
+func (s *storedKey) IsSecurityKey() bool {
+	return s.SecurityKey != ""
+}
+
+// SetAuthenticator sets the Authenticator used for keys held on security keys,
+// along with the WebAuthn relying party ID used for new credentials. If none
+// is set, security keys cannot be generated or used.
+func (m *DefaultManager) SetAuthenticator(a Authenticator, rpID string) {
+	m.authenticator = a
+	m.rpID = rpID
+}
+
+// newSKPublicKey returns the OpenSSH public key for a security key credential
+// with the specified SubjectPublicKeyInfo.
+func newSKPublicKey(application string, spki []byte) (ssh.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(spki)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errParseFailed, err)
+	}
+	ec, ok := key.(*ecdsa.PublicKey)
+	if !ok || ec.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w: security key returned unsupported key type %T", errParseFailed, key)
+	}
+	ecdh, err := ec.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errParseFailed, err)
+	}
+	wire := struct {
+		Name        string
+		ID          string
+		Key         []byte
+		Application string
+	}{ssh.KeyAlgoSKECDSA256, "nistp256", ecdh.Bytes(), application}
+	return ssh.ParsePublicKey(ssh.Marshal(wire))
+}
+
+// GenerateSecurityKey implements Manager.GenerateSecurityKey.
+func (m *DefaultManager) GenerateSecurityKey(ctx jsutil.AsyncContext, name string, keyType KeyType) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("%w: name must not be empty", errInvalidName)
+	}
+	if keyType != KeyTypeECDSA {
+		return "", fmt.Errorf("%w: only %s security keys are supported", ErrUnsupportedKeyType, KeyTypeECDSA)
+	}
+	if m.authenticator == nil {
+		return "", errNoAuthenticator
+	}
+
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", fmt.Errorf("%w: %w", errGenerateFailed, err)
+	}
+	cred, err := m.authenticator.Create(ctx, &CredentialRequest{
+		RPID:      m.rpID,
+		UserName:  name,
+		Challenge: challenge,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errSecurityKeyFailed, err)
+	}
+	pub, err := newSKPublicKey(m.rpID, cred.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	used, err := m.usedIDs(ctx)
+	if err != nil {
+		return "", err
+	}
+	id, err := allocateID(pub, used)
+	if err != nil {
+		return "", err
+	}
+	sk := &storedKey{
+		ID:   string(id),
+		Name: name,
+	}
+	sk.SetPublic(pub)
+	sk.SetSKHandle(&skHandle{
+		Application: m.rpID,
+		Flags:       skUserPresenceRequired,
+		KeyHandle:   cred.ID,
+	})
+	if err := m.writeStoredKey(ctx, sk); err != nil {
+		return "", fmt.Errorf("failed to store key: %w", err)
+	}
+
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	return fmt.Sprintf("%s %s", authorized, name), nil
+}
+
+// skSigner signs using a key held on a security key.
+//
+// skSigner implements the Signer interface.
+type skSigner struct {
+	m      *DefaultManager
+	pub    ssh.PublicKey
+	handle *skHandle
+}
+
+// PublicKey implements Signer.PublicKey.
+func (s *skSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+// Sign implements Signer.Sign. It blocks until the user touches the security
+// key.
+func (s *skSigner) Sign(_ io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.m.signWithSecurityKey(s.handle, data)
+}
+
+// addSecurityKeyToAgent adds the key held on a security key to the agent.
+func (m *DefaultManager) addSecurityKeyToAgent(id ID, key *storedKey) error {
+	pub, h := key.Public(), key.SKHandle()
+	if pub == nil || h == nil {
+		return fmt.Errorf("%w: invalid security key", errParseFailed)
+	}
+	return m.agent.addHeld(&heldIdentity{
+		Signer:  &skSigner{m: m, pub: pub, handle: h},
+		Comment: agentComment(id, key.Comment),
+	})
+}
+
+// signWithSecurityKey signs the data using the key held on a security key. It
+// must not be invoked from the main thread, since it blocks until the user
+// touches the security key.
+func (m *DefaultManager) signWithSecurityKey(handle *skHandle, data []byte) (*ssh.Signature, error) {
+	if m.authenticator == nil {
+		return nil, errNoAuthenticator
+	}
+
+	type result struct {
+		assertion *Assertion
+		err       error
+	}
+	done := make(chan result, 1)
+	jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+		a, err := m.authenticator.Get(ctx, &AssertionRequest{
+			RPID:         handle.Application,
+			CredentialID: handle.KeyHandle,
+			Challenge:    data,
+		})
+		done <- result{assertion: a, err: err}
+		return js.Undefined(), nil
+	})
+	r := <-done
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: %w", errSecurityKeyFailed, r.err)
+	}
+	return webauthnSignature(r.assertion)
+}
+
+// webauthnSignature returns the OpenSSH signature corresponding to a WebAuthn
+// assertion. See the description of webauthn-sk-ecdsa-sha2-nistp256@openssh.com
+// in OpenSSH's PROTOCOL.u2f.
+func webauthnSignature(a *Assertion) (*ssh.Signature, error) {
+	// The authenticator data starts with the 32-byte hash of the relying
+	// party ID, followed by the flags and the big-endian counter.
+	if len(a.AuthenticatorData) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", errSecurityKeyFailed)
+	}
+	flags := a.AuthenticatorData[32]
+	counter := binary.BigEndian.Uint32(a.AuthenticatorData[33:37])
+
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(a.Signature, &sig); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse signature: %w", errSecurityKeyFailed, err)
+	}
+	var clientData struct {
+		Origin string `json:"origin"`
+	}
+	if err := json.Unmarshal(a.ClientDataJSON, &clientData); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse client data: %w", errSecurityKeyFailed, err)
+	}
+
+	rest := struct {
+		Flags      uint8
+		Counter    uint32
+		Origin     string
+		ClientData []byte
+		Extensions []byte
+	}{flags, counter, clientData.Origin, a.ClientDataJSON, nil}
+	return &ssh.Signature{
+		Format: webauthnSigFormat,
+		Blob:   ssh.Marshal(sig),
+		Rest:   ssh.Marshal(rest),
+	}, nil
+}
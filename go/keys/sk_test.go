@@ -0,0 +1,358 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	testRPID   = "extension-id"
+	testOrigin = "chrome-extension://extension-id"
+)
+
+// fakeAuthenticator implements Authenticator in the manner of a security key
+// accessed using WebAuthn.
+type fakeAuthenticator struct {
+	keys    map[string]*ecdsa.PrivateKey
+	counter uint32
+	// err is returned by all operations, if set.
+	err error
+}
+
+func newFakeAuthenticator() *fakeAuthenticator {
+	return &fakeAuthenticator{keys: map[string]*ecdsa.PrivateKey{}}
+}
+
+func (f *fakeAuthenticator) Create(_ jsutil.AsyncContext, req *CredentialRequest) (*Credential, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	f.keys[string(id)] = priv
+	return &Credential{ID: id, PublicKey: spki}, nil
+}
+
+func (f *fakeAuthenticator) Get(_ jsutil.AsyncContext, req *AssertionRequest) (*Assertion, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	priv, ok := f.keys[string(req.CredentialID)]
+	if !ok {
+		return nil, errors.New("unknown credential")
+	}
+	clientData, err := json.Marshal(map[string]string{
+		"type":      "webauthn.get",
+		"challenge": base64.RawURLEncoding.EncodeToString(req.Challenge),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f.counter++
+	rpIDHash := sha256.Sum256([]byte(req.RPID))
+	authData := append(rpIDHash[:], skUserPresenceRequired)
+	authData = binary.BigEndian.AppendUint32(authData, f.counter)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return &Assertion{
+		AuthenticatorData: authData,
+		ClientDataJSON:    clientData,
+		Signature:         sig,
+	}, nil
+}
+
+// verifyWebAuthnSignature verifies the signature over the data in the manner
+// of OpenSSH's sshd.
+func verifyWebAuthnSignature(pub ssh.PublicKey, application string, data []byte, sig *ssh.Signature) error {
+	if sig.Format != webauthnSigFormat {
+		return fmt.Errorf("incorrect signature format %s", sig.Format)
+	}
+	var rs struct {
+		R, S *big.Int
+	}
+	if err := ssh.Unmarshal(sig.Blob, &rs); err != nil {
+		return fmt.Errorf("failed to parse signature blob: %w", err)
+	}
+	var rest struct {
+		Flags      uint8
+		Counter    uint32
+		Origin     string
+		ClientData []byte
+		Extensions []byte
+	}
+	if err := ssh.Unmarshal(sig.Rest, &rest); err != nil {
+		return fmt.Errorf("failed to parse signature: %w", err)
+	}
+	wantPrefix := fmt.Sprintf(`{"challenge":"%s","origin":"%s"`, base64.RawURLEncoding.EncodeToString(data), rest.Origin)
+	if !strings.HasPrefix(string(rest.ClientData), wantPrefix) {
+		return fmt.Errorf("incorrect client data %s", rest.ClientData)
+	}
+	if rest.Origin != testOrigin {
+		return fmt.Errorf("incorrect origin %s", rest.Origin)
+	}
+
+	wire := struct {
+		Name        string
+		ID          string
+		Key         []byte
+		Application string
+	}{}
+	if err := ssh.Unmarshal(pub.Marshal(), &wire); err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+	if wire.Application != application {
+		return fmt.Errorf("incorrect application %s", wire.Application)
+	}
+	ecPub, ok := pub.(ssh.CryptoPublicKey).CryptoPublicKey().(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("incorrect public key type %s", pub.Type())
+	}
+
+	appHash := sha256.Sum256([]byte(application))
+	clientDataHash := sha256.Sum256(rest.ClientData)
+	var signed bytes.Buffer
+	signed.Write(appHash[:])
+	signed.WriteByte(rest.Flags)
+	signed.Write(binary.BigEndian.AppendUint32(nil, rest.Counter))
+	signed.Write(clientDataHash[:])
+	digest := sha256.Sum256(signed.Bytes())
+	if !ecdsa.Verify(ecPub, digest[:], rs.R, rs.S) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+func TestGenerateSecurityKey(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description   string
+		keyType       KeyType
+		authenticator func() Authenticator
+		wantErr       error
+	}{
+		{
+			description:   "ecdsa",
+			keyType:       KeyTypeECDSA,
+			authenticator: func() Authenticator { return newFakeAuthenticator() },
+		},
+		{
+			description:   "ed25519 not supported",
+			keyType:       KeyTypeED25519,
+			authenticator: func() Authenticator { return newFakeAuthenticator() },
+			wantErr:       ErrUnsupportedKeyType,
+		},
+		{
+			description: "no authenticator",
+			keyType:     KeyTypeECDSA,
+			wantErr:     errNoAuthenticator,
+		},
+		{
+			description: "security key missing",
+			keyType:     KeyTypeECDSA,
+			authenticator: func() Authenticator {
+				a := newFakeAuthenticator()
+				a.err = errors.New("no security key")
+				return a
+			},
+			wantErr: errSecurityKeyFailed,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+				if tc.authenticator != nil {
+					mgr.SetAuthenticator(tc.authenticator(), testRPID)
+				}
+
+				pub, err := mgr.GenerateSecurityKey(ctx, "some-key", tc.keyType)
+				if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("incorrect error; -got +want: %s", diff)
+				}
+				configured, err := mgr.Configured(ctx)
+				if err != nil {
+					t.Errorf("failed to get configured keys: %v", err)
+					return
+				}
+				if tc.wantErr != nil {
+					if len(configured) != 0 {
+						t.Errorf("incorrect configured keys; got %d, want 0", len(configured))
+					}
+					return
+				}
+
+				if !strings.HasPrefix(pub, ssh.KeyAlgoSKECDSA256+" ") || !strings.HasSuffix(pub, " some-key") {
+					t.Errorf("incorrect public key %s", pub)
+				}
+				want := []*ConfiguredKey{{Name: "some-key", Format: FormatSecurityKey, Type: ssh.KeyAlgoSKECDSA256, Bits: 256}}
+				if diff := cmp.Diff(configured, want, cmpopts.IgnoreFields(ConfiguredKey{}, "ID", "FingerprintSHA256", "FingerprintMD5")); diff != "" {
+					t.Errorf("incorrect configured keys; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestSignWithSecurityKey(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		authenticator := newFakeAuthenticator()
+		mgr := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		mgr.SetAuthenticator(authenticator, testRPID)
+
+		authorized, err := mgr.GenerateSecurityKey(ctx, "some-key", KeyTypeECDSA)
+		if err != nil {
+			t.Errorf("failed to generate key: %v", err)
+			return
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorized))
+		if err != nil {
+			t.Errorf("failed to parse public key: %v", err)
+			return
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Errorf("failed to find key: %v", err)
+			return
+		}
+		if err := mgr.Load(ctx, id, ""); err != nil {
+			t.Errorf("failed to load key: %v", err)
+			return
+		}
+
+		for _, step := range []struct {
+			description string
+			restart     bool
+			missing     bool
+			wantErr     error
+		}{
+			{
+				description: "signed using security key",
+			},
+			{
+				description: "security key missing",
+				missing:     true,
+				wantErr:     errSecurityKeyFailed,
+			},
+			{
+				description: "signed after restart",
+				restart:     true,
+			},
+		} {
+			if step.restart {
+				mgr = NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+				mgr.SetAuthenticator(authenticator, testRPID)
+				if err := mgr.LoadFromSession(ctx); err != nil {
+					t.Errorf("%s: failed to load keys from session: %v", step.description, err)
+					return
+				}
+			}
+			authenticator.err = nil
+			if step.missing {
+				authenticator.err = errors.New("no security key")
+			}
+
+			loaded, err := mgr.Loaded(ctx)
+			if err != nil {
+				t.Errorf("%s: failed to get loaded keys: %v", step.description, err)
+				return
+			}
+			if diff := cmp.Diff(loadedKeyIDs(loaded), []ID{id}); diff != "" {
+				t.Errorf("%s: incorrect loaded keys; -got +want: %s", step.description, diff)
+			}
+
+			data := []byte("data to sign")
+			sig, err := mgr.Agent().Sign(pub, data)
+			if diff := cmp.Diff(err, step.wantErr, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s: incorrect error; -got +want: %s", step.description, diff)
+			}
+			if err != nil {
+				continue
+			}
+			if err := verifyWebAuthnSignature(pub, testRPID, data, sig); err != nil {
+				t.Errorf("%s: invalid signature: %v", step.description, err)
+			}
+		}
+
+		// Operations requiring the private key are not supported.
+		err = mgr.SetComment(ctx, id, "comment", "")
+		if diff := cmp.Diff(err, errSecurityKeyOperation, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect SetComment error; -got +want: %s", diff)
+		}
+		err = mgr.ChangePassphrase(ctx, id, "", "new-passphrase")
+		if diff := cmp.Diff(err, errSecurityKeyOperation, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect ChangePassphrase error; -got +want: %s", diff)
+		}
+
+		if err := mgr.Unload(ctx, id); err != nil {
+			t.Errorf("failed to unload key: %v", err)
+			return
+		}
+		loaded, err := mgr.Loaded(ctx)
+		if err != nil {
+			t.Errorf("failed to get loaded keys: %v", err)
+			return
+		}
+		if len(loaded) != 0 {
+			t.Errorf("incorrect loaded keys; got %d, want 0", len(loaded))
+		}
+	})
+}
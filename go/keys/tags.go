@@ -0,0 +1,162 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// keyTags is the raw object stored in persistent storage for the tags applied
+// to a configured key. It is stored under the key's ID, separately from the
+// key itself, such that changing tags does not rewrite the (possibly large and
+// chunked) private key.
+type keyTags struct {
+	ID   string   `js:"id"`
+	Tags []string `js:"tags"`
+}
+
+var (
+	// tagsPrefixes is the prefix for tags stored in persistent storage.
+	tagsPrefixes = []string{"tags"}
+)
+
+var errInvalidTag = errors.New("invalid tag")
+
+// ConfiguredOption modifies the behavior of Configured.
+type ConfiguredOption func(o *configuredOptions)
+
+type configuredOptions struct {
+	tag string
+}
+
+// WithTag restricts the keys returned by Configured to those carrying the
+// specified tag.
+func WithTag(tag string) ConfiguredOption {
+	return func(o *configuredOptions) {
+		o.tag = tag
+	}
+}
+
+// normalizeTags returns the tags with surrounding whitespace removed, sorted,
+// and without duplicates. An error is returned if any tag is empty or contains
+// whitespace.
+func normalizeTags(tags []string) ([]string, error) {
+	var result []string
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			return nil, fmt.Errorf("%w: tag must not be empty", errInvalidTag)
+		}
+		if strings.ContainsFunc(t, unicode.IsSpace) {
+			return nil, fmt.Errorf("%w: tag must not contain whitespace: %q", errInvalidTag, t)
+		}
+		result = append(result, t)
+	}
+	slices.Sort(result)
+	return slices.Compact(result), nil
+}
+
+// readTags returns the tags for each configured key, indexed by ID.
+func (m *DefaultManager) readTags(ctx jsutil.AsyncContext) (map[ID][]string, error) {
+	all, err := m.tags.ReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags: %w", err)
+	}
+	result := map[ID][]string{}
+	for _, t := range all {
+		result[ID(t.ID)] = t.Tags
+	}
+	return result, nil
+}
+
+// forgetTags removes the tags for the keys with the specified IDs. Failures
+// are logged; orphaned tags are ignored since they are only read alongside
+// configured keys.
+func (m *DefaultManager) forgetTags(ctx jsutil.AsyncContext, ids ...ID) {
+	remove := map[ID]bool{}
+	for _, id := range ids {
+		remove[id] = true
+	}
+	if err := m.tags.Delete(ctx, func(t *keyTags) bool { return remove[ID(t.ID)] }); err != nil {
+		jsutil.LogError("failed to remove tags: %v", err)
+	}
+}
+
+// SetTags implements Manager.SetTags.
+func (m *DefaultManager) SetTags(ctx jsutil.AsyncContext, id ID, tags []string) error {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return err
+	}
+
+	key, err := m.storedKeys.Read(ctx, func(key *storedKey) bool { return ID(key.ID) == id })
+	if err != nil {
+		return fmt.Errorf("failed to read key: %w", err)
+	}
+	if key == nil {
+		return fmt.Errorf("%w: failed to find key with ID %s", errKeyNotFound, id)
+	}
+
+	if len(tags) == 0 {
+		if err := m.tags.Delete(ctx, func(t *keyTags) bool { return ID(t.ID) == id }); err != nil {
+			return fmt.Errorf("failed to remove tags: %w", err)
+		}
+		return nil
+	}
+	kt := &keyTags{
+		ID:   string(id),
+		Tags: tags,
+	}
+	if err := m.tags.WriteKey(ctx, string(id), kt); err != nil {
+		return fmt.Errorf("failed to store tags: %w", err)
+	}
+	return nil
+}
+
+// LoadByTag implements Manager.LoadByTag.
+func (m *DefaultManager) LoadByTag(ctx jsutil.AsyncContext, tag string, passphrase string) ([]*Result, error) {
+	configured, err := m.Configured(ctx, WithTag(tag))
+	if err != nil {
+		return nil, err
+	}
+	loaded, err := m.Loaded(ctx)
+	if err != nil {
+		return nil, err
+	}
+	isLoaded := map[ID]bool{}
+	for _, l := range loaded {
+		isLoaded[l.ID()] = true
+	}
+
+	var reqs []*LoadRequest
+	for _, k := range configured {
+		if isLoaded[ID(k.ID)] {
+			continue
+		}
+		reqs = append(reqs, &LoadRequest{ID: ID(k.ID), Passphrase: passphrase})
+	}
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	return m.LoadAll(ctx, reqs)
+}
@@ -0,0 +1,239 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestSetTags(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "work-key", PEMPrivateKey: testdata.WithPassphrase.Private},
+			{Name: "personal-key", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Errorf("failed to initialize manager: %v", err)
+			return
+		}
+		workID, err := findKey(ctx, mgr, InvalidID, "work-key")
+		if err != nil {
+			t.Errorf("failed to find key: %v", err)
+			return
+		}
+		before, err := mgr.storedKeys.ReadAll(ctx)
+		if err != nil {
+			t.Errorf("failed to read stored keys: %v", err)
+			return
+		}
+
+		// Tags are normalized.
+		if err := mgr.SetTags(ctx, workID, []string{" work ", "client-a", "work"}); err != nil {
+			t.Errorf("failed to set tags: %v", err)
+			return
+		}
+		configured, err := mgr.Configured(ctx)
+		if err != nil {
+			t.Errorf("failed to get configured keys: %v", err)
+			return
+		}
+		gotTags := map[string][]string{}
+		for _, k := range configured {
+			gotTags[k.Name] = k.Tags
+		}
+		wantTags := map[string][]string{
+			"work-key":     {"client-a", "work"},
+			"personal-key": nil,
+		}
+		if diff := cmp.Diff(gotTags, wantTags); diff != "" {
+			t.Errorf("incorrect tags; -got +want: %s", diff)
+		}
+
+		// Stored keys are untouched.
+		after, err := mgr.storedKeys.ReadAll(ctx)
+		if err != nil {
+			t.Errorf("failed to read stored keys: %v", err)
+			return
+		}
+		if diff := cmp.Diff(after, before, cmpopts.SortSlices(func(a, b *storedKey) bool { return a.ID < b.ID })); diff != "" {
+			t.Errorf("stored keys changed; -got +want: %s", diff)
+		}
+
+		// Configured keys can be filtered by tag.
+		configured, err = mgr.Configured(ctx, WithTag("work"))
+		if err != nil {
+			t.Errorf("failed to get configured keys: %v", err)
+			return
+		}
+		if diff := cmp.Diff(configuredKeyNames(configured), []string{"work-key"}); diff != "" {
+			t.Errorf("incorrect filtered keys; -got +want: %s", diff)
+		}
+		configured, err = mgr.Configured(ctx, WithTag("bogus"))
+		if err != nil {
+			t.Errorf("failed to get configured keys: %v", err)
+			return
+		}
+		if len(configured) != 0 {
+			t.Errorf("incorrect filtered keys; got %v, want none", configuredKeyNames(configured))
+		}
+
+		// Clearing tags removes them.
+		if err := mgr.SetTags(ctx, workID, nil); err != nil {
+			t.Errorf("failed to clear tags: %v", err)
+			return
+		}
+		configured, err = mgr.Configured(ctx, WithTag("work"))
+		if err != nil {
+			t.Errorf("failed to get configured keys: %v", err)
+			return
+		}
+		if len(configured) != 0 {
+			t.Errorf("incorrect filtered keys; got %v, want none", configuredKeyNames(configured))
+		}
+
+		// Invalid tags and unknown keys are rejected.
+		for _, tags := range [][]string{{""}, {"two words"}} {
+			err := mgr.SetTags(ctx, workID, tags)
+			if diff := cmp.Diff(err, errInvalidTag, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("incorrect error for tags %q; -got +want: %s", tags, diff)
+			}
+		}
+		err = mgr.SetTags(ctx, ID("bogus-id"), []string{"work"})
+		if diff := cmp.Diff(err, errKeyNotFound, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+
+		// Removing a key removes its tags.
+		if err := mgr.SetTags(ctx, workID, []string{"work"}); err != nil {
+			t.Errorf("failed to set tags: %v", err)
+			return
+		}
+		if err := mgr.Remove(ctx, workID); err != nil {
+			t.Errorf("failed to remove key: %v", err)
+			return
+		}
+		tags, err := mgr.readTags(ctx)
+		if err != nil {
+			t.Errorf("failed to read tags: %v", err)
+			return
+		}
+		if len(tags) != 0 {
+			t.Errorf("incorrect tags after removal; got %v, want none", tags)
+		}
+	})
+}
+
+func TestLoadByTag(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		mgr, err := newTestManager(ctx, agent.NewKeyring(), syncStorage, sessionStorage, []*initialKey{
+			{Name: "work-encrypted", PEMPrivateKey: testdata.WithPassphrase.Private},
+			{Name: "work-unencrypted", PEMPrivateKey: testdata.WithoutPassphrase.Private},
+			{Name: "work-other-passphrase", PEMPrivateKey: testdata.ED25519WithPassphrase.Private},
+			{Name: "personal", PEMPrivateKey: testdata.ECDSAWithoutPassphrase.Private},
+		})
+		if err != nil {
+			t.Errorf("failed to initialize manager: %v", err)
+			return
+		}
+		ids := map[string]ID{}
+		for _, name := range []string{"work-encrypted", "work-unencrypted", "work-other-passphrase", "personal"} {
+			id, err := findKey(ctx, mgr, InvalidID, name)
+			if err != nil {
+				t.Errorf("failed to find key: %v", err)
+				return
+			}
+			ids[name] = id
+			if name == "work-other-passphrase" {
+				if err := mgr.ChangePassphrase(ctx, id, testdata.ED25519WithPassphrase.Passphrase, "other-secret"); err != nil {
+					t.Errorf("failed to change passphrase: %v", err)
+					return
+				}
+			}
+			tag := "work"
+			if name == "personal" {
+				tag = "personal"
+			}
+			if err := mgr.SetTags(ctx, id, []string{tag}); err != nil {
+				t.Errorf("failed to set tags: %v", err)
+				return
+			}
+		}
+
+		results, err := mgr.LoadByTag(ctx, "work", testdata.WithPassphrase.Passphrase)
+		if err != nil {
+			t.Errorf("LoadByTag failed: %v", err)
+			return
+		}
+		gotErrs := map[ID]error{}
+		for _, r := range results {
+			gotErrs[r.ID] = r.Err
+		}
+		wantErrs := map[ID]error{
+			ids["work-encrypted"]:        nil,
+			ids["work-unencrypted"]:      nil,
+			ids["work-other-passphrase"]: x509.IncorrectPasswordError,
+		}
+		if diff := cmp.Diff(gotErrs, wantErrs, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect results; -got +want: %s", diff)
+		}
+
+		wantBlobs := []string{testdata.WithPassphrase.Blob, testdata.WithoutPassphrase.Blob}
+		loaded, err := mgr.Loaded(ctx)
+		if err != nil {
+			t.Errorf("failed to get loaded keys: %v", err)
+			return
+		}
+		if diff := cmp.Diff(loadedKeyBlobs(loaded), wantBlobs, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+			t.Errorf("incorrect loaded keys; -got +want: %s", diff)
+		}
+
+		// Keys that are already loaded are not loaded again.
+		results, err = mgr.LoadByTag(ctx, "work", "other-secret")
+		if err != nil {
+			t.Errorf("LoadByTag failed: %v", err)
+			return
+		}
+		var gotIDs []ID
+		for _, r := range results {
+			gotIDs = append(gotIDs, r.ID)
+			if r.Err != nil {
+				t.Errorf("failed to load key ID %s: %v", r.ID, r.Err)
+			}
+		}
+		if diff := cmp.Diff(gotIDs, []ID{ids["work-other-passphrase"]}); diff != "" {
+			t.Errorf("incorrect keys attempted; -got +want: %s", diff)
+		}
+	})
+}
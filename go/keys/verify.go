@@ -0,0 +1,62 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrPublicKeyMismatch indicates that a private key does not correspond to
+// the public key it was expected to. Its identity is preserved by the client
+// returned by NewClient.
+var ErrPublicKeyMismatch = errors.New("private key does not match expected public key")
+
+var (
+	errInvalidPublicKey = errors.New("invalid public key")
+	errPublicKeyUnknown = errors.New("public key cannot be determined")
+)
+
+// WithExpectedPublicKey requires that the key correspond to the supplied
+// public key, in the format written by ssh-keygen to .pub files. For example,
+// this allows the user to check that a key matches one already distributed to
+// servers.
+func WithExpectedPublicKey(pub string) AddOption {
+	return func(o *addOptions) {
+		o.expectedPublicKey = pub
+	}
+}
+
+// checkExpectedPublicKey returns an error if pub, the public key derived from
+// a key being added, does not match the expected public key in authorized
+// keys format. pub is nil if the public key could not be determined without
+// the passphrase, in which case the key cannot be checked.
+func checkExpectedPublicKey(pub ssh.PublicKey, expected string) error {
+	want, _, _, _, err := ssh.ParseAuthorizedKey([]byte(expected))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errInvalidPublicKey, err)
+	}
+	if pub == nil {
+		return fmt.Errorf("%w: key must be in the OpenSSH or PuTTY format, or unencrypted, to be checked", errPublicKeyUnknown)
+	}
+	if got, want := ssh.FingerprintSHA256(pub), ssh.FingerprintSHA256(want); got != want {
+		return fmt.Errorf("%w: private key is %s, expected %s", ErrPublicKeyMismatch, got, want)
+	}
+	return nil
+}
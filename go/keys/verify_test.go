@@ -0,0 +1,111 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAddExpectedPublicKey(t *testing.T) {
+	t.Parallel()
+
+	// authorizedKey returns the public key of key, as found in a .pub file.
+	authorizedKey := func(key testdata.TestKey) string {
+		return key.Type + " " + key.Blob + " comment"
+	}
+
+	testcases := []struct {
+		description  string
+		privateKey   string
+		expected     string
+		wantErr      error
+		wantErrTexts []string
+	}{
+		{
+			description: "matching key",
+			privateKey:  testdata.ED25519WithoutPassphrase.Private,
+			expected:    authorizedKey(testdata.ED25519WithoutPassphrase),
+		},
+		{
+			description: "matching encrypted OpenSSH key",
+			privateKey:  testdata.OpenSSHFormat.Private,
+			expected:    authorizedKey(testdata.OpenSSHFormat),
+		},
+		{
+			description: "mismatched key",
+			privateKey:  testdata.ED25519WithoutPassphrase.Private,
+			expected:    authorizedKey(testdata.OpenSSHFormat),
+			wantErr:     ErrPublicKeyMismatch,
+			wantErrTexts: []string{
+				ssh.FingerprintSHA256(derivePublicKey(testdata.ED25519WithoutPassphrase.Private)),
+				ssh.FingerprintSHA256(derivePublicKey(testdata.OpenSSHFormat.Private)),
+			},
+		},
+		{
+			description: "public key unknown without passphrase",
+			privateKey:  testdata.PKCS8Format.Private,
+			expected:    authorizedKey(testdata.PKCS8Format),
+			wantErr:     errPublicKeyUnknown,
+		},
+		{
+			description: "invalid expected public key",
+			privateKey:  testdata.ED25519WithoutPassphrase.Private,
+			expected:    "bogus",
+			wantErr:     errInvalidPublicKey,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+
+				err := mgr.Add(ctx, "some-key", tc.privateKey, WithExpectedPublicKey(tc.expected))
+				if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("incorrect error; -got +want: %s", diff)
+				}
+				for _, want := range tc.wantErrTexts {
+					if err == nil || !strings.Contains(err.Error(), want) {
+						t.Errorf("error %v does not mention %s", err, want)
+					}
+				}
+
+				configured, err := mgr.Configured(ctx)
+				if err != nil {
+					t.Fatalf("failed to get configured keys: %v", err)
+				}
+				if got, want := len(configured), 0; tc.wantErr != nil && got != want {
+					t.Errorf("incorrect number of configured keys; got %d, want %d", got, want)
+				}
+			})
+		})
+	}
+}
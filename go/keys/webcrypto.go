@@ -0,0 +1,224 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// CryptoKeyStore generates and uses non-extractable keys. The private keys are
+// held by the browser, and are never available to the extension. Implementations
+// typically use the WebCrypto API, and store keys in IndexedDB.
+type CryptoKeyStore interface {
+	// Generate generates and stores a non-extractable ECDSA P-256 key. It
+	// returns the handle that identifies the key, along with the
+	// DER-encoded SubjectPublicKeyInfo of its public key.
+	Generate(ctx jsutil.AsyncContext) (handle string, spki []byte, err error)
+	// Sign signs the data using ECDSA with SHA-256. The signature may be
+	// returned in the IEEE P1363 format produced by WebCrypto, or
+	// DER-encoded.
+	Sign(ctx jsutil.AsyncContext, handle string, data []byte) ([]byte, error)
+	// Delete deletes the key. It is not an error if there is no such key.
+	Delete(ctx jsutil.AsyncContext, handle string) error
+}
+
+var (
+	errNoCryptoKeyStore   = errors.New("non-extractable keys not supported")
+	errCryptoKeyFailed    = errors.New("non-extractable key operation failed")
+	errCryptoKeyOperation = errors.New("operation not supported for non-extractable keys")
+)
+
+const (
+	// FormatWebCrypto indicates that the private key is a
+	// non-extractable key held by the browser, rather than being stored.
+	FormatWebCrypto = "webcrypto"
+
+	// p256CoordinateBytes is the size of each of r and s in a P-256
+	// signature in the IEEE P1363 format.
+	p256CoordinateBytes = 32
+)
+
+// IsCryptoKey determines if the private key is a non-extractable key held by
+// the browser.
+func (s *storedKey) IsCryptoKey() bool {
+	return s.CryptoKey != ""
+}
+
+// SetCryptoKeyStore sets the CryptoKeyStore used for non-extractable keys. If
+// none is set, such keys cannot be generated or used.
+func (m *DefaultManager) SetCryptoKeyStore(s CryptoKeyStore) {
+	m.cryptoKeys = s
+}
+
+// newCryptoPublicKey returns the OpenSSH public key for a non-extractable key
+// with the specified SubjectPublicKeyInfo.
+func newCryptoPublicKey(spki []byte) (ssh.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(spki)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errParseFailed, err)
+	}
+	ec, ok := key.(*ecdsa.PublicKey)
+	if !ok || ec.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w: unsupported key type %T", errParseFailed, key)
+	}
+	return ssh.NewPublicKey(ec)
+}
+
+// GenerateCryptoKey implements Manager.GenerateCryptoKey.
+func (m *DefaultManager) GenerateCryptoKey(ctx jsutil.AsyncContext, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("%w: name must not be empty", errInvalidName)
+	}
+	if m.cryptoKeys == nil {
+		return "", errNoCryptoKeyStore
+	}
+
+	handle, spki, err := m.cryptoKeys.Generate(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errCryptoKeyFailed, err)
+	}
+	pub, err := newCryptoPublicKey(spki)
+	if err != nil {
+		m.deleteCryptoKeys(ctx, handle)
+		return "", err
+	}
+
+	used, err := m.usedIDs(ctx)
+	if err != nil {
+		m.deleteCryptoKeys(ctx, handle)
+		return "", err
+	}
+	id, err := allocateID(pub, used)
+	if err != nil {
+		m.deleteCryptoKeys(ctx, handle)
+		return "", err
+	}
+	sk := &storedKey{
+		ID:        string(id),
+		Name:      name,
+		CryptoKey: handle,
+	}
+	sk.SetPublic(pub)
+	if err := m.writeStoredKey(ctx, sk); err != nil {
+		m.deleteCryptoKeys(ctx, handle)
+		return "", fmt.Errorf("failed to store key: %w", err)
+	}
+
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	return fmt.Sprintf("%s %s", authorized, name), nil
+}
+
+// deleteCryptoKeys deletes the non-extractable keys with the specified
+// handles. Failures are logged; an orphaned key cannot be used, since nothing
+// refers to it.
+func (m *DefaultManager) deleteCryptoKeys(ctx jsutil.AsyncContext, handles ...string) {
+	if m.cryptoKeys == nil {
+		return
+	}
+	for _, h := range handles {
+		if err := m.cryptoKeys.Delete(ctx, h); err != nil {
+			jsutil.LogError("failed to delete non-extractable key: %v", err)
+		}
+	}
+}
+
+// cryptoKeySigner signs using a non-extractable key.
+//
+// cryptoKeySigner implements the Signer interface.
+type cryptoKeySigner struct {
+	m      *DefaultManager
+	pub    ssh.PublicKey
+	handle string
+}
+
+// PublicKey implements Signer.PublicKey.
+func (s *cryptoKeySigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+// Sign implements Signer.Sign.
+func (s *cryptoKeySigner) Sign(_ io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.m.signWithCryptoKey(s.handle, data)
+}
+
+// addCryptoKeyToAgent adds the non-extractable key to the agent.
+func (m *DefaultManager) addCryptoKeyToAgent(id ID, key *storedKey) error {
+	pub := key.Public()
+	if pub == nil {
+		return fmt.Errorf("%w: invalid non-extractable key", errParseFailed)
+	}
+	return m.agent.addHeld(&heldIdentity{
+		Signer:  &cryptoKeySigner{m: m, pub: pub, handle: key.CryptoKey},
+		Comment: agentComment(id, key.Comment),
+	})
+}
+
+// signWithCryptoKey signs the data using the non-extractable key. It must not
+// be invoked from the main thread, since it blocks until the signature is
+// available.
+func (m *DefaultManager) signWithCryptoKey(handle string, data []byte) (*ssh.Signature, error) {
+	if m.cryptoKeys == nil {
+		return nil, errNoCryptoKeyStore
+	}
+
+	type result struct {
+		sig []byte
+		err error
+	}
+	done := make(chan result, 1)
+	jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+		sig, err := m.cryptoKeys.Sign(ctx, handle, data)
+		done <- result{sig: sig, err: err}
+		return js.Undefined(), nil
+	})
+	r := <-done
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: %w", errCryptoKeyFailed, r.err)
+	}
+	return ecdsaP256Signature(r.sig)
+}
+
+// ecdsaP256Signature returns the OpenSSH signature corresponding to an ECDSA
+// P-256 signature, which may be in either the IEEE P1363 format (r followed by
+// s, each of fixed size) or DER-encoded. See RFC 5656 Section 3.1.2.
+func ecdsaP256Signature(sig []byte) (*ssh.Signature, error) {
+	var rs struct {
+		R, S *big.Int
+	}
+	if len(sig) == 2*p256CoordinateBytes {
+		rs.R = new(big.Int).SetBytes(sig[:p256CoordinateBytes])
+		rs.S = new(big.Int).SetBytes(sig[p256CoordinateBytes:])
+	} else if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse signature: %w", errCryptoKeyFailed, err)
+	}
+	return &ssh.Signature{
+		Format: ssh.KeyAlgoECDSA256,
+		Blob:   ssh.Marshal(rs),
+	}, nil
+}
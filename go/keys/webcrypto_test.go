@@ -0,0 +1,289 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// fakeCryptoKeyStore implements CryptoKeyStore in the manner of WebCrypto keys
+// stored in IndexedDB.
+type fakeCryptoKeyStore struct {
+	keys map[string]*ecdsa.PrivateKey
+	// der indicates that signatures are DER-encoded, rather than in the
+	// IEEE P1363 format.
+	der bool
+	// err is returned by all operations, if set.
+	err error
+}
+
+func newFakeCryptoKeyStore() *fakeCryptoKeyStore {
+	return &fakeCryptoKeyStore{keys: map[string]*ecdsa.PrivateKey{}}
+}
+
+func (f *fakeCryptoKeyStore) Generate(_ jsutil.AsyncContext) (string, []byte, error) {
+	if f.err != nil {
+		return "", nil, f.err
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", nil, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return "", nil, err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	handle := hex.EncodeToString(b)
+	f.keys[handle] = priv
+	return handle, spki, nil
+}
+
+func (f *fakeCryptoKeyStore) Sign(_ jsutil.AsyncContext, handle string, data []byte) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	priv, ok := f.keys[handle]
+	if !ok {
+		return nil, errors.New("unknown key")
+	}
+	digest := sha256.Sum256(data)
+	if f.der {
+		return ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 2*p256CoordinateBytes)
+	r.FillBytes(sig[:p256CoordinateBytes])
+	s.FillBytes(sig[p256CoordinateBytes:])
+	return sig, nil
+}
+
+func (f *fakeCryptoKeyStore) Delete(_ jsutil.AsyncContext, handle string) error {
+	if f.err != nil {
+		return f.err
+	}
+	delete(f.keys, handle)
+	return nil
+}
+
+func TestGenerateCryptoKey(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		store       func() CryptoKeyStore
+		wantErr     error
+	}{
+		{
+			description: "generated",
+			store:       func() CryptoKeyStore { return newFakeCryptoKeyStore() },
+		},
+		{
+			description: "no key store",
+			wantErr:     errNoCryptoKeyStore,
+		},
+		{
+			description: "key store failure",
+			store: func() CryptoKeyStore {
+				s := newFakeCryptoKeyStore()
+				s.err = errors.New("failed")
+				return s
+			},
+			wantErr: errCryptoKeyFailed,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				mgr := NewManager(agent.NewKeyring(), storage.NewRaw(st.NewMemArea()), storage.NewRaw(st.NewMemArea()))
+				if tc.store != nil {
+					mgr.SetCryptoKeyStore(tc.store())
+				}
+
+				pub, err := mgr.GenerateCryptoKey(ctx, "some-key")
+				if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("incorrect error; -got +want: %s", diff)
+				}
+				configured, err := mgr.Configured(ctx)
+				if err != nil {
+					t.Errorf("failed to get configured keys: %v", err)
+					return
+				}
+				if tc.wantErr != nil {
+					if len(configured) != 0 {
+						t.Errorf("incorrect configured keys; got %d, want 0", len(configured))
+					}
+					return
+				}
+
+				if !strings.HasPrefix(pub, ssh.KeyAlgoECDSA256+" ") || !strings.HasSuffix(pub, " some-key") {
+					t.Errorf("incorrect public key %s", pub)
+				}
+				want := []*ConfiguredKey{{Name: "some-key", Format: FormatWebCrypto, Type: ssh.KeyAlgoECDSA256, Bits: 256}}
+				if diff := cmp.Diff(configured, want, cmpopts.IgnoreFields(ConfiguredKey{}, "ID", "FingerprintSHA256", "FingerprintMD5")); diff != "" {
+					t.Errorf("incorrect configured keys; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestSignWithCryptoKey(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		syncStorage := storage.NewRaw(st.NewMemArea())
+		sessionStorage := storage.NewRaw(st.NewMemArea())
+		store := newFakeCryptoKeyStore()
+		mgr := NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+		mgr.SetCryptoKeyStore(store)
+
+		authorized, err := mgr.GenerateCryptoKey(ctx, "some-key")
+		if err != nil {
+			t.Errorf("failed to generate key: %v", err)
+			return
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorized))
+		if err != nil {
+			t.Errorf("failed to parse public key: %v", err)
+			return
+		}
+		id, err := findKey(ctx, mgr, InvalidID, "some-key")
+		if err != nil {
+			t.Errorf("failed to find key: %v", err)
+			return
+		}
+		if err := mgr.Load(ctx, id, ""); err != nil {
+			t.Errorf("failed to load key: %v", err)
+			return
+		}
+
+		for _, step := range []struct {
+			description string
+			restart     bool
+			der         bool
+			fail        bool
+			wantErr     error
+		}{
+			{
+				description: "signed using IEEE P1363 signature",
+			},
+			{
+				description: "signed using DER signature",
+				der:         true,
+			},
+			{
+				description: "key store failure",
+				fail:        true,
+				wantErr:     errCryptoKeyFailed,
+			},
+			{
+				description: "signed after restart",
+				restart:     true,
+			},
+		} {
+			if step.restart {
+				mgr = NewManager(agent.NewKeyring(), syncStorage, sessionStorage)
+				mgr.SetCryptoKeyStore(store)
+				if err := mgr.LoadFromSession(ctx); err != nil {
+					t.Errorf("%s: failed to load keys from session: %v", step.description, err)
+					return
+				}
+			}
+			store.der = step.der
+			store.err = nil
+			if step.fail {
+				store.err = errors.New("failed")
+			}
+
+			loaded, err := mgr.Loaded(ctx)
+			if err != nil {
+				t.Errorf("%s: failed to get loaded keys: %v", step.description, err)
+				return
+			}
+			if diff := cmp.Diff(loadedKeyIDs(loaded), []ID{id}); diff != "" {
+				t.Errorf("%s: incorrect loaded keys; -got +want: %s", step.description, diff)
+			}
+
+			data := []byte("data to sign")
+			sig, err := mgr.Agent().Sign(pub, data)
+			if diff := cmp.Diff(err, step.wantErr, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s: incorrect error; -got +want: %s", step.description, diff)
+			}
+			if err != nil {
+				continue
+			}
+			if err := pub.Verify(data, sig); err != nil {
+				t.Errorf("%s: invalid signature: %v", step.description, err)
+			}
+		}
+
+		// Operations requiring the private key are not supported.
+		err = mgr.SetComment(ctx, id, "comment", "")
+		if diff := cmp.Diff(err, errCryptoKeyOperation, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect SetComment error; -got +want: %s", diff)
+		}
+		err = mgr.SetCertificate(ctx, id, "")
+		if diff := cmp.Diff(err, errCryptoKeyOperation, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect SetCertificate error; -got +want: %s", diff)
+		}
+
+		// Removing the key deletes it from the key store.
+		if err := mgr.Remove(ctx, id); err != nil {
+			t.Errorf("failed to remove key: %v", err)
+			return
+		}
+		if len(store.keys) != 0 {
+			t.Errorf("incorrect keys in key store; got %d, want 0", len(store.keys))
+		}
+		loaded, err := mgr.Loaded(ctx)
+		if err != nil {
+			t.Errorf("failed to get loaded keys: %v", err)
+			return
+		}
+		if len(loaded) != 0 {
+			t.Errorf("incorrect loaded keys; got %d, want 0", len(loaded))
+		}
+	})
+}
@@ -36,9 +36,23 @@ var locks = func() js.Value {
 
 // Async runs a routine asynchronously once access to the resource has been
 // granted. Access to the resource is released when the routine returns.
+//
+// Access is exclusive; no other routine may hold the lock concurrently.
 func Async(resource string, f func(ctx jsutil.AsyncContext)) *jsutil.Promise {
+	return request(resource, "exclusive", f)
+}
+
+// AsyncShared is like Async, but access is shared. Any number of routines may
+// hold the lock in shared mode concurrently, but not while it is held by a
+// routine started with Async.
+func AsyncShared(resource string, f func(ctx jsutil.AsyncContext)) *jsutil.Promise {
+	return request(resource, "shared", f)
+}
+
+func request(resource, mode string, f func(ctx jsutil.AsyncContext)) *jsutil.Promise {
 	return jsutil.AsPromise(locks.Call(
 		"request", resource,
+		js.ValueOf(map[string]interface{}{"mode": mode}),
 		// Return a promise encapsulating the supplied function.
 		jsutil.OneTimeFuncOf(func(this js.Value, args []js.Value) interface{} {
 			return jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
@@ -70,3 +70,46 @@ func TestExclusive(t *testing.T) {
 		}
 	})
 }
+
+func TestShared(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		const workers = 10
+		var count, maxCount, exclusiveConcurrent int32
+
+		var promises []*jsutil.Promise
+		for i := 0; i < workers; i++ {
+			promises = append(promises, AsyncShared("my-shared-resource", func(ctx jsutil.AsyncContext) {
+				n := atomic.AddInt32(&count, 1)
+				defer atomic.AddInt32(&count, -1)
+				for {
+					m := atomic.LoadInt32(&maxCount)
+					if n <= m || atomic.CompareAndSwapInt32(&maxCount, m, n) {
+						break
+					}
+				}
+				time.Sleep(100 * time.Millisecond)
+			}))
+		}
+		// An exclusive holder must not run alongside any shared holder.
+		promises = append(promises, Async("my-shared-resource", func(ctx jsutil.AsyncContext) {
+			if atomic.LoadInt32(&count) > 0 {
+				atomic.AddInt32(&exclusiveConcurrent, 1)
+			}
+		}))
+
+		for _, p := range promises {
+			if _, err := p.Await(ctx); err != nil {
+				t.Errorf("promise returned error: %v", err)
+			}
+		}
+
+		if m := atomic.LoadInt32(&maxCount); m < 2 {
+			t.Errorf("shared holders did not run concurrently; max concurrent %d", m)
+		}
+		if c := atomic.LoadInt32(&exclusiveConcurrent); c > 0 {
+			t.Errorf("exclusive holder ran concurrently with shared holders")
+		}
+	})
+}
@@ -0,0 +1,82 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"sync"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// Action is a fake implementation of chrome.action, sufficient to display a
+// badge on the extension's toolbar icon.
+type Action struct {
+	v js.Value
+
+	mu    sync.Mutex
+	text  string
+	color string
+	title string
+}
+
+// NewAction returns a fake implementation of chrome.action, displaying no
+// badge.
+func NewAction() *Action {
+	a := &Action{v: jsutil.NewObject()}
+	// set returns a method that stores the specified property of its
+	// details argument.
+	set := func(prop string, dst *string) js.Func {
+		return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			*dst = jsutil.SingleArg(args).Get(prop).String()
+			return nil
+		})
+	}
+	a.v.Set("setBadgeText", set("text", &a.text))
+	a.v.Set("setBadgeBackgroundColor", set("color", &a.color))
+	a.v.Set("setTitle", set("title", &a.title))
+	return a
+}
+
+// Value returns the fake as seen by the extension.
+func (a *Action) Value() js.Value {
+	return a.v
+}
+
+// BadgeText returns the text of the badge, or an empty string if none is
+// displayed.
+func (a *Action) BadgeText() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.text
+}
+
+// BadgeBackgroundColor returns the background color of the badge.
+func (a *Action) BadgeBackgroundColor() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.color
+}
+
+// Title returns the title of the toolbar icon, displayed as its tooltip.
+func (a *Action) Title() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.title
+}
@@ -0,0 +1,81 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"sync"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// Command is a command declared by the extension.
+type Command struct {
+	Name        string
+	Description string
+	Shortcut    string
+}
+
+// Commands is a fake implementation of chrome.commands.
+type Commands struct {
+	v js.Value
+
+	mu       sync.Mutex
+	commands []Command
+}
+
+// NewCommands returns a fake implementation of chrome.commands, declaring the
+// specified commands.
+func NewCommands(commands ...Command) *Commands {
+	c := &Commands{
+		v:        jsutil.NewObject(),
+		commands: commands,
+	}
+	c.v.Set("getAll", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			var result []interface{}
+			for _, cmd := range c.commands {
+				result = append(result, map[string]interface{}{
+					"name":        cmd.Name,
+					"description": cmd.Description,
+					"shortcut":    cmd.Shortcut,
+				})
+			}
+			return js.ValueOf(result), nil
+		}).JSValue()
+	}))
+	return c
+}
+
+// Value returns the fake as seen by the extension.
+func (c *Commands) Value() js.Value {
+	return c.v
+}
+
+// SetShortcut assigns the shortcut to the named command, as if the user had
+// changed it.
+func (c *Commands) SetShortcut(name, shortcut string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.commands {
+		if c.commands[i].Name == name {
+			c.commands[i].Shortcut = shortcut
+		}
+	}
+}
@@ -0,0 +1,191 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// event is a fake implementation of a Chrome event, such as
+// chrome.runtime.Port.onMessage.
+type event struct {
+	v js.Value
+
+	mu        sync.Mutex
+	listeners []js.Value
+}
+
+func newEvent() *event {
+	e := &event{v: jsutil.NewObject()}
+	e.v.Set("addListener", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.listeners = append(e.listeners, jsutil.SingleArg(args))
+		return nil
+	}))
+	e.v.Set("removeListener", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		l := jsutil.SingleArg(args)
+		for i := range e.listeners {
+			if e.listeners[i].Equal(l) {
+				e.listeners = append(e.listeners[:i], e.listeners[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}))
+	return e
+}
+
+// dispatch invokes the listeners with the supplied arguments.
+func (e *event) dispatch(args ...interface{}) {
+	e.mu.Lock()
+	listeners := append([]js.Value(nil), e.listeners...)
+	e.mu.Unlock()
+	for _, l := range listeners {
+		l.Invoke(args...)
+	}
+}
+
+// NativePort is a fake implementation of a chrome.runtime.Port connected to a
+// native messaging host, as returned by chrome.runtime.connectNative. Tests act
+// as the host: they send messages to the extension using Send, and receive
+// those posted by the extension using Receive.
+type NativePort struct {
+	// Name is the name of the native messaging host.
+	Name string
+
+	v            js.Value
+	onMessage    *event
+	onDisconnect *event
+	messages     chan js.Value
+
+	mu           sync.Mutex
+	disconnected bool
+}
+
+// NewNativePort returns a fake port connected to the named native messaging
+// host.
+func NewNativePort(name string) *NativePort {
+	p := &NativePort{
+		Name:         name,
+		v:            jsutil.NewObject(),
+		onMessage:    newEvent(),
+		onDisconnect: newEvent(),
+		messages:     make(chan js.Value, 1024),
+	}
+	p.v.Set("name", name)
+	p.v.Set("onMessage", p.onMessage.v)
+	p.v.Set("onDisconnect", p.onDisconnect.v)
+	p.v.Set("postMessage", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if p.Disconnected() {
+			panic("postMessage on disconnected port")
+		}
+		// Messages are serialized as JSON when sent to the host.
+		p.messages <- jsutil.FromJSON(jsutil.ToJSON(jsutil.SingleArg(args)))
+		return nil
+	}))
+	p.v.Set("disconnect", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		// Chrome does not notify the extension of ports that it
+		// disconnects itself.
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.disconnected = true
+		return nil
+	}))
+	return p
+}
+
+// Value returns the port as seen by the extension.
+func (p *NativePort) Value() js.Value {
+	return p.v
+}
+
+// Send delivers a message from the host to the extension.
+func (p *NativePort) Send(msg js.Value) {
+	p.onMessage.dispatch(jsutil.FromJSON(jsutil.ToJSON(msg)), p.v)
+}
+
+// Receive returns the next message posted by the extension to the host. It
+// returns false if no message is posted within the timeout.
+func (p *NativePort) Receive(timeout time.Duration) (js.Value, bool) {
+	select {
+	case msg := <-p.messages:
+		return msg, true
+	case <-time.After(timeout):
+		return js.Undefined(), false
+	}
+}
+
+// Exit disconnects the port as if the host exited, notifying the extension.
+func (p *NativePort) Exit() {
+	p.mu.Lock()
+	p.disconnected = true
+	p.mu.Unlock()
+	p.onDisconnect.dispatch(p.v)
+}
+
+// Disconnected returns true if the port was disconnected, either by the
+// extension or by the host.
+func (p *NativePort) Disconnected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.disconnected
+}
+
+// NativeRuntime is a fake implementation of chrome.runtime, sufficient to
+// connect to native messaging hosts.
+type NativeRuntime struct {
+	v js.Value
+
+	mu    sync.Mutex
+	ports map[string]*NativePort
+}
+
+// NewNativeRuntime returns a fake implementation of chrome.runtime.
+func NewNativeRuntime() *NativeRuntime {
+	r := &NativeRuntime{
+		v:     jsutil.NewObject(),
+		ports: map[string]*NativePort{},
+	}
+	r.v.Set("connectNative", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		p := NewNativePort(jsutil.SingleArg(args).String())
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.ports[p.Name] = p
+		return p.v
+	}))
+	return r
+}
+
+// Value returns the runtime as seen by the extension.
+func (r *NativeRuntime) Value() js.Value {
+	return r.v
+}
+
+// Port returns the port most recently connected to the named host, or nil if
+// none was connected.
+func (r *NativeRuntime) Port(name string) *NativePort {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ports[name]
+}
@@ -0,0 +1,100 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/norunners/vert"
+)
+
+type nativeMsg struct {
+	Data string `js:"data"`
+}
+
+func TestNativePort(t *testing.T) {
+	t.Parallel()
+
+	r := NewNativeRuntime()
+	if p := r.Port("com.example.host"); p != nil {
+		t.Errorf("port unexpectedly connected")
+	}
+	port := r.Value().Call("connectNative", "com.example.host")
+	p := r.Port("com.example.host")
+	if p == nil {
+		t.Fatalf("port not connected")
+	}
+
+	var received []string
+	var disconnects int
+	onMessage := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		var m nativeMsg
+		if err := vert.ValueOf(args[0]).AssignTo(&m); err != nil {
+			t.Errorf("failed to parse message: %v", err)
+		}
+		received = append(received, m.Data)
+		return nil
+	})
+	defer onMessage.Release()
+	onDisconnect := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		disconnects++
+		return nil
+	})
+	defer onDisconnect.Release()
+	port.Get("onMessage").Call("addListener", onMessage)
+	port.Get("onDisconnect").Call("addListener", onDisconnect)
+
+	// Messages from the host are delivered to listeners.
+	p.Send(vert.ValueOf(nativeMsg{Data: "first"}).JSValue())
+	port.Get("onMessage").Call("removeListener", onMessage)
+	p.Send(vert.ValueOf(nativeMsg{Data: "second"}).JSValue())
+	if diff := cmp.Diff(received, []string{"first"}); diff != "" {
+		t.Errorf("incorrect messages received; -got +want: %s", diff)
+	}
+
+	// Messages from the extension are received by the host.
+	port.Call("postMessage", vert.ValueOf(nativeMsg{Data: "reply"}).JSValue())
+	msg, ok := p.Receive(5 * time.Second)
+	if !ok {
+		t.Fatalf("timed out waiting for message")
+	}
+	var m nativeMsg
+	if err := vert.ValueOf(msg).AssignTo(&m); err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	if m.Data != "reply" {
+		t.Errorf("incorrect message; got %s, want reply", m.Data)
+	}
+	if _, ok := p.Receive(10 * time.Millisecond); ok {
+		t.Errorf("unexpected message")
+	}
+
+	// The extension is notified when the host exits.
+	if p.Disconnected() {
+		t.Errorf("port unexpectedly disconnected")
+	}
+	p.Exit()
+	if !p.Disconnected() {
+		t.Errorf("port not disconnected after exit")
+	}
+	if disconnects != 1 {
+		t.Errorf("incorrect number of disconnect notifications; got %d, want 1", disconnects)
+	}
+}
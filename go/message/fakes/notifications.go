@@ -0,0 +1,95 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakes
+
+import (
+	"sort"
+	"sync"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// Notification is a notification displayed using Notifications.
+type Notification struct {
+	Title   string
+	Message string
+}
+
+// Notifications is a fake implementation of chrome.notifications. As with
+// Chrome, creating a notification with the ID of one that is displayed
+// replaces it.
+type Notifications struct {
+	v js.Value
+
+	mu        sync.Mutex
+	displayed map[string]Notification
+}
+
+// NewNotifications returns a fake implementation of chrome.notifications,
+// displaying no notifications.
+func NewNotifications() *Notifications {
+	n := &Notifications{
+		v:         jsutil.NewObject(),
+		displayed: map[string]Notification{},
+	}
+	n.v.Set("create", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		var id, opts js.Value
+		jsutil.ExpandArgs(args, &id, &opts)
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.displayed[id.String()] = Notification{
+			Title:   opts.Get("title").String(),
+			Message: opts.Get("message").String(),
+		}
+		return nil
+	}))
+	n.v.Set("clear", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.displayed, jsutil.SingleArg(args).String())
+		return nil
+	}))
+	return n
+}
+
+// Value returns the fake as seen by the extension.
+func (n *Notifications) Value() js.Value {
+	return n.v
+}
+
+// Displayed returns the IDs of the notifications that are displayed, in
+// sorted order.
+func (n *Notifications) Displayed() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var ids []string
+	for id := range n.displayed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Get returns the displayed notification with the ID, or false if none is
+// displayed.
+func (n *Notifications) Get(id string) (Notification, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	notification, ok := n.displayed[id]
+	return notification, ok
+}
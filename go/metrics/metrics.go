@@ -0,0 +1,301 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics counts the requests served by the agent.
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/message"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	"github.com/norunners/vert"
+)
+
+// counter identifies one of the counts maintained by Metrics.
+type counter int
+
+const (
+	counterList counter = iota
+	counterSign
+	counterAdd
+	counterRemove
+	counterFailure
+	numCounters
+)
+
+// Message types of the SSH agent protocol; see draft-miller-ssh-agent.
+const (
+	agentFailure                     = 5
+	agentcRequestIdentities          = 11
+	agentcSignRequest                = 13
+	agentcAddIdentity                = 17
+	agentcRemoveIdentity             = 18
+	agentcRemoveAllIdentities        = 19
+	agentcAddSmartcardKey            = 20
+	agentcRemoveSmartcardKey         = 21
+	agentcAddIDConstrained           = 25
+	agentcAddSmartcardKeyConstrained = 26
+	agentExtensionFailure            = 28
+)
+
+// requestCounter returns the counter for a request of the specified type, or
+// false if requests of the type are not counted.
+func requestCounter(t byte) (counter, bool) {
+	switch t {
+	case agentcRequestIdentities:
+		return counterList, true
+	case agentcSignRequest:
+		return counterSign, true
+	case agentcAddIdentity, agentcAddIDConstrained, agentcAddSmartcardKey, agentcAddSmartcardKeyConstrained:
+		return counterAdd, true
+	case agentcRemoveIdentity, agentcRemoveAllIdentities, agentcRemoveSmartcardKey:
+		return counterRemove, true
+	}
+	return 0, false
+}
+
+// Counts are the number of requests of each type, and of failure responses.
+type Counts struct {
+	// List is the number of requests to list identities.
+	List int64 `js:"list"`
+	// Sign is the number of requests for signatures.
+	Sign int64 `js:"sign"`
+	// Add is the number of requests to add keys.
+	Add int64 `js:"add"`
+	// Remove is the number of requests to remove keys, including those
+	// to remove all keys.
+	Remove int64 `js:"remove"`
+	// Failure is the number of requests of any type answered with a
+	// failure.
+	Failure int64 `js:"failure"`
+}
+
+// counts returns the Counts for the values, which are indexed by counter.
+func counts(v *[numCounters]int64) Counts {
+	return Counts{
+		List:    v[counterList],
+		Sign:    v[counterSign],
+		Add:     v[counterAdd],
+		Remove:  v[counterRemove],
+		Failure: v[counterFailure],
+	}
+}
+
+// values returns the values for the Counts, indexed by counter.
+func (c *Counts) values() [numCounters]int64 {
+	var v [numCounters]int64
+	v[counterList] = c.List
+	v[counterSign] = c.Sign
+	v[counterAdd] = c.Add
+	v[counterRemove] = c.Remove
+	v[counterFailure] = c.Failure
+	return v
+}
+
+// Snapshot is the state of the metrics at a point in time.
+type Snapshot struct {
+	// Session are the counts since the service worker started.
+	Session Counts `js:"session"`
+	// Cumulative are the counts across restarts of the service worker,
+	// including those that have yet to be stored.
+	Cumulative Counts `js:"cumulative"`
+}
+
+var (
+	// metricsPrefixes is the prefix for metrics stored in persistent
+	// storage.
+	metricsPrefixes = []string{"metrics"}
+)
+
+// cumulativeKey is the storage key for the cumulative counts.
+const cumulativeKey = "cumulative"
+
+// Metrics counts the requests served by the agent. Counting is cheap, and
+// never blocks; cumulative counts are only written to storage by Flush, which
+// should be invoked periodically.
+type Metrics struct {
+	// session are the counts since the service worker started.
+	session [numCounters]atomic.Int64
+	// unflushed are the counts that have yet to be added to those in
+	// storage.
+	unflushed [numCounters]atomic.Int64
+
+	stored *storage.Typed[Counts]
+
+	// mu guards the fields below, and serializes Load and Flush.
+	mu sync.Mutex
+	// cumulative are the counts in storage, as last read or written.
+	cumulative [numCounters]int64
+	// loaded indicates that cumulative has been read from storage.
+	loaded bool
+}
+
+// New returns a new Metrics that stores cumulative counts in the supplied
+// area.
+func New(store storage.Area) *Metrics {
+	return &Metrics{
+		stored: storage.NewTyped[Counts](store, metricsPrefixes),
+	}
+}
+
+// Observe counts a request served by the agent, and its response. Both are
+// messages of the SSH agent protocol, excluding their length. Requests of
+// types that are not counted are only counted if they fail. Observe may be
+// invoked on a nil Metrics, in which case nothing is counted.
+func (m *Metrics) Observe(req, rsp []byte) {
+	if m == nil {
+		return
+	}
+	if len(req) > 0 {
+		if c, ok := requestCounter(req[0]); ok {
+			m.add(c)
+		}
+	}
+	if len(rsp) > 0 && (rsp[0] == agentFailure || rsp[0] == agentExtensionFailure) {
+		m.add(counterFailure)
+	}
+}
+
+// add increments the counter.
+func (m *Metrics) add(c counter) {
+	m.session[c].Add(1)
+	m.unflushed[c].Add(1)
+}
+
+// load reads the cumulative counts from storage, unless they have already been
+// read. m.mu must be held.
+func (m *Metrics) load(ctx jsutil.AsyncContext) error {
+	if m.loaded {
+		return nil
+	}
+	c, err := m.stored.ReadKey(ctx, cumulativeKey)
+	if err != nil {
+		return fmt.Errorf("failed to read metrics: %w", err)
+	}
+	if c != nil {
+		m.cumulative = c.values()
+	}
+	m.loaded = true
+	return nil
+}
+
+// Load reads the cumulative counts from storage, such that they are included
+// in snapshots. It should be invoked when the service worker starts.
+func (m *Metrics) Load(ctx jsutil.AsyncContext) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.load(ctx)
+}
+
+// Flush adds the counts observed since the last flush to those in storage,
+// using a single write. Nothing is written if there are none. If the write
+// fails, the counts are retained for the next flush.
+func (m *Metrics) Flush(ctx jsutil.AsyncContext) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.load(ctx); err != nil {
+		return err
+	}
+
+	var delta [numCounters]int64
+	var changed bool
+	for c := range delta {
+		delta[c] = m.unflushed[c].Swap(0)
+		changed = changed || delta[c] != 0
+	}
+	if !changed {
+		return nil
+	}
+
+	updated := m.cumulative
+	for c := range updated {
+		updated[c] += delta[c]
+	}
+	stored := counts(&updated)
+	if err := m.stored.WriteKey(ctx, cumulativeKey, &stored); err != nil {
+		for c := range delta {
+			m.unflushed[c].Add(delta[c])
+		}
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	m.cumulative = updated
+	return nil
+}
+
+// Snapshot returns the current counts. Cumulative counts exclude those in
+// storage if they have yet to be loaded; see Load.
+func (m *Metrics) Snapshot() *Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var session, cumulative [numCounters]int64
+	for c := range session {
+		session[c] = m.session[c].Load()
+		cumulative[c] = m.cumulative[c] + m.unflushed[c].Load()
+	}
+	return &Snapshot{
+		Session:    counts(&session),
+		Cumulative: counts(&cumulative),
+	}
+}
+
+// Define a distinct type for each message. These are embedded in each
+// message, and are distinct from those used by keys.Server.
+const (
+	msgTypeSnapshot int = 5000 + iota
+	msgTypeSnapshotRsp
+)
+
+type msgSnapshot struct {
+	Type int `js:"type"`
+}
+
+type rspSnapshot struct {
+	Type     int      `js:"type"`
+	Snapshot Snapshot `js:"snapshot"`
+}
+
+// OnMessage is the callback invoked when a message is received. Requests sent
+// using Get are answered. Other messages are ignored, in which case undefined
+// is returned such that they may be handled elsewhere.
+func (m *Metrics) OnMessage(_ jsutil.AsyncContext, headerObj js.Value, _ js.Value) js.Value {
+	var msg msgSnapshot
+	if err := vert.ValueOf(headerObj).AssignTo(&msg); err != nil || msg.Type != msgTypeSnapshot {
+		return js.Undefined()
+	}
+	jsutil.LogDebug("Metrics.OnMessage(Snapshot req)")
+	return vert.ValueOf(rspSnapshot{Type: msgTypeSnapshotRsp, Snapshot: *m.Snapshot()}).JSValue()
+}
+
+// Get returns a snapshot of the metrics maintained by the background page,
+// such that they can be displayed by the options page.
+func Get(ctx jsutil.AsyncContext, msg message.Sender) (*Snapshot, error) {
+	m := msgSnapshot{Type: msgTypeSnapshot}
+	jsutil.LogDebug("Metrics.Get(req)")
+	rspObj, err := msg.Send(ctx, vert.ValueOf(m).JSValue())
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSnapshot
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &rsp.Snapshot, nil
+}
@@ -0,0 +1,173 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	mfakes "github.com/google/chrome-ssh-agent/go/message/fakes"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	success = []byte{6} // SSH_AGENT_SUCCESS
+	failure = []byte{agentFailure}
+)
+
+func TestObserve(t *testing.T) {
+	t.Parallel()
+
+	m := New(storage.NewRaw(st.NewMemArea()))
+	m.Observe([]byte{agentcRequestIdentities}, []byte{12})
+	m.Observe([]byte{agentcSignRequest}, []byte{14})
+	m.Observe([]byte{agentcSignRequest}, failure)
+	m.Observe([]byte{agentcAddIdentity}, success)
+	m.Observe([]byte{agentcAddIDConstrained}, success)
+	m.Observe([]byte{agentcRemoveIdentity}, success)
+	m.Observe([]byte{agentcRemoveAllIdentities}, success)
+	m.Observe([]byte{27}, []byte{agentExtensionFailure}) // SSH_AGENTC_EXTENSION
+	m.Observe([]byte{22}, success)                       // SSH_AGENTC_LOCK
+	m.Observe(nil, failure)
+
+	want := Counts{List: 1, Sign: 2, Add: 2, Remove: 2, Failure: 3}
+	if diff := cmp.Diff(m.Snapshot(), &Snapshot{Session: want, Cumulative: want}); diff != "" {
+		t.Errorf("incorrect snapshot; -got +want: %s", diff)
+	}
+
+	// Nothing is counted without metrics.
+	var none *Metrics
+	none.Observe([]byte{agentcSignRequest}, failure)
+}
+
+func TestObserveAllocations(t *testing.T) {
+	m := New(storage.NewRaw(st.NewMemArea()))
+	req := []byte{agentcSignRequest}
+	if n := testing.AllocsPerRun(100, func() { m.Observe(req, failure) }); n != 0 {
+		t.Errorf("incorrect allocations when counting; got %v, want 0", n)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		area := st.NewQuotaMemArea(0, 0, 0)
+		m := New(storage.NewRaw(area))
+		if err := m.Load(ctx); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+
+		// Counts are not written as they are observed.
+		for i := 0; i < 100; i++ {
+			m.Observe([]byte{agentcSignRequest}, []byte{14})
+		}
+		m.Observe([]byte{agentcRequestIdentities}, failure)
+		writes := func() int {
+			var n int
+			for _, op := range st.Ops(area) {
+				if op.Method == "set" {
+					n++
+				}
+			}
+			return n
+		}
+		if n := writes(); n != 0 {
+			t.Errorf("incorrect number of writes before flush; got %d, want 0", n)
+		}
+
+		// They are written using a single write when flushed, and not
+		// written again until more are observed.
+		if err := m.Flush(ctx); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if n := writes(); n != 1 {
+			t.Errorf("incorrect number of writes after flush; got %d, want 1", n)
+		}
+		if err := m.Flush(ctx); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if n := writes(); n != 1 {
+			t.Errorf("incorrect number of writes after flush without changes; got %d, want 1", n)
+		}
+
+		// Counts that fail to be written are retained.
+		m.Observe([]byte{agentcAddIdentity}, success)
+		st.FailWrites(area, 1, "QUOTA_BYTES quota exceeded")
+		if err := m.Flush(ctx); err == nil {
+			t.Errorf("Flush unexpectedly succeeded")
+		}
+		m.Observe([]byte{agentcRemoveIdentity}, success)
+		if err := m.Flush(ctx); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		m.Observe([]byte{agentcSignRequest}, []byte{14})
+
+		want := Counts{List: 1, Sign: 101, Add: 1, Remove: 1, Failure: 1}
+		if diff := cmp.Diff(m.Snapshot(), &Snapshot{Session: want, Cumulative: want}); diff != "" {
+			t.Errorf("incorrect snapshot; -got +want: %s", diff)
+		}
+
+		// Cumulative counts survive a restart, excluding those that were
+		// not flushed.
+		restarted := New(storage.NewRaw(area))
+		if err := restarted.Load(ctx); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		restarted.Observe([]byte{agentcRequestIdentities}, []byte{12})
+		if diff := cmp.Diff(restarted.Snapshot(), &Snapshot{
+			Session:    Counts{List: 1},
+			Cumulative: Counts{List: 2, Sign: 100, Add: 1, Remove: 1, Failure: 1},
+		}); diff != "" {
+			t.Errorf("incorrect snapshot after restart; -got +want: %s", diff)
+		}
+
+		// Counts observed before they were loaded are added to those
+		// stored.
+		unloaded := New(storage.NewRaw(area))
+		unloaded.Observe([]byte{agentcAddIdentity}, success)
+		if err := unloaded.Flush(ctx); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if diff := cmp.Diff(unloaded.Snapshot().Cumulative, Counts{List: 1, Sign: 100, Add: 2, Remove: 1, Failure: 1}); diff != "" {
+			t.Errorf("incorrect cumulative counts; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		m := New(storage.NewRaw(st.NewMemArea()))
+		hub.AddReceiver(m)
+
+		m.Observe([]byte{agentcSignRequest}, failure)
+		got, err := Get(ctx, hub)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		want := Counts{Sign: 1, Failure: 1}
+		if diff := cmp.Diff(got, &Snapshot{Session: want, Cumulative: want}); diff != "" {
+			t.Errorf("incorrect snapshot; -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,418 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notifications notifies the user of events affecting their keys
+// using desktop notifications.
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/message"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	"github.com/norunners/vert"
+)
+
+// Category is a category of notifications, which may be disabled separately.
+type Category int
+
+const (
+	// KeyLoad notifications are displayed when keys fail to load
+	// automatically.
+	KeyLoad Category = iota + 1
+	// Signature notifications are displayed when a request to sign is
+	// refused by a constraint on the key.
+	Signature
+	// Expiry notifications are displayed when keys or their certificates
+	// are about to expire.
+	Expiry
+	// Quota notifications are displayed when storage is nearly full.
+	Quota
+)
+
+// Categories are all categories of notifications.
+var Categories = []Category{KeyLoad, Signature, Expiry, Quota}
+
+// String returns the name of the category. It is stored in settings, and
+// used in notification IDs, so must not change.
+func (c Category) String() string {
+	switch c {
+	case KeyLoad:
+		return "keyLoad"
+	case Signature:
+		return "signature"
+	case Expiry:
+		return "expiry"
+	case Quota:
+		return "quota"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(c))
+	}
+}
+
+// section returns the ID of the element on the options page displaying
+// information relevant to notifications in the category.
+func (c Category) section() string {
+	switch c {
+	case Signature:
+		return "auditPane"
+	case Quota:
+		return "controlPane"
+	default:
+		return "keysPane"
+	}
+}
+
+// parseCategory returns the category with the specified name, or false if
+// there is none.
+func parseCategory(name string) (Category, bool) {
+	for _, c := range Categories {
+		if c.String() == name {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// Settings determine the notifications that are displayed. All are displayed
+// by default.
+type Settings struct {
+	// Disabled indicates that no notifications are displayed.
+	Disabled bool `js:"disabled"`
+	// DisabledCategories are the names of the categories for which
+	// notifications are not displayed.
+	DisabledCategories []string `js:"disabledCategories"`
+}
+
+// Enabled returns true if notifications in the category are displayed.
+func (s *Settings) Enabled(c Category) bool {
+	return !s.Disabled && !slices.Contains(s.DisabledCategories, c.String())
+}
+
+var (
+	// settingsPrefixes is the prefix for the settings in storage.
+	settingsPrefixes = []string{"notifications"}
+)
+
+const (
+	// settingsKey is the storage key for Settings.
+	settingsKey = "settings"
+	// idSeparator separates the category from the subject in notification
+	// IDs.
+	idSeparator = "/"
+)
+
+// OpenFunc opens the options page, displaying the element with the specified
+// ID.
+type OpenFunc func(section string)
+
+// OpenTab returns an OpenFunc that opens page in a new tab using tabs, which
+// is typically chrome.tabs.
+func OpenTab(tabs js.Value, page string) OpenFunc {
+	return func(section string) {
+		tabs.Call("create", map[string]interface{}{"url": fmt.Sprintf("%s#%s", page, section)})
+	}
+}
+
+// Notifier displays notifications using chrome.notifications.
+type Notifier struct {
+	notifications js.Value
+	iconURL       string
+	open          OpenFunc
+	settings      *storage.Typed[Settings]
+}
+
+// New returns a Notifier that displays notifications using notifications,
+// which is typically chrome.notifications, with the icon at iconURL. Settings
+// are read from store. open is invoked when the user clicks a notification.
+func New(notifications js.Value, iconURL string, store storage.Area, open OpenFunc) *Notifier {
+	return &Notifier{
+		notifications: notifications,
+		iconURL:       iconURL,
+		open:          open,
+		settings:      storage.NewTyped[Settings](store, settingsPrefixes),
+	}
+}
+
+// Settings returns the current settings.
+func (n *Notifier) Settings(ctx jsutil.AsyncContext) (*Settings, error) {
+	s, err := n.settings.ReadKey(ctx, settingsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings: %w", err)
+	}
+	if s == nil {
+		s = &Settings{}
+	}
+	return s, nil
+}
+
+// updateSettings applies update to the current settings, and stores them.
+func (n *Notifier) updateSettings(ctx jsutil.AsyncContext, update func(s *Settings)) error {
+	s, err := n.Settings(ctx)
+	if err != nil {
+		return err
+	}
+	update(s)
+	if err := n.settings.WriteKey(ctx, settingsKey, s); err != nil {
+		return fmt.Errorf("failed to write settings: %w", err)
+	}
+	return nil
+}
+
+// SetEnabled sets whether any notifications are displayed. Categories that
+// were disabled remain so.
+func (n *Notifier) SetEnabled(ctx jsutil.AsyncContext, enabled bool) error {
+	return n.updateSettings(ctx, func(s *Settings) {
+		s.Disabled = !enabled
+	})
+}
+
+// SetCategoryEnabled sets whether notifications in the category are
+// displayed.
+func (n *Notifier) SetCategoryEnabled(ctx jsutil.AsyncContext, c Category, enabled bool) error {
+	if _, ok := parseCategory(c.String()); !ok {
+		return fmt.Errorf("invalid category: %s", c)
+	}
+	return n.updateSettings(ctx, func(s *Settings) {
+		s.DisabledCategories = slices.DeleteFunc(s.DisabledCategories, func(name string) bool { return name == c.String() })
+		if !enabled {
+			s.DisabledCategories = append(s.DisabledCategories, c.String())
+		}
+	})
+}
+
+// notificationOptions are the options for a notification. See
+// https://developer.chrome.com/docs/extensions/reference/api/notifications#type-NotificationOptions
+type notificationOptions struct {
+	Type    string `js:"type"`
+	IconURL string `js:"iconUrl"`
+	Title   string `js:"title"`
+	Message string `js:"message"`
+}
+
+// notify displays a notification in the category, unless it is disabled. The
+// notification's ID is derived from the category and subject, such that a
+// repeated event replaces the notification rather than adding another.
+func (n *Notifier) notify(ctx jsutil.AsyncContext, c Category, subject, title, msg string) {
+	if n.notifications.IsUndefined() {
+		jsutil.LogError("notifications not supported; dropping notification: %s: %s", title, msg)
+		return
+	}
+	// Notifications are displayed if the settings cannot be read, since
+	// they are more useful than not.
+	if s, err := n.Settings(ctx); err != nil {
+		jsutil.LogError("failed to read notification settings: %v", err)
+	} else if !s.Enabled(c) {
+		jsutil.LogDebug("Notifier.notify: %s disabled; dropping notification: %s: %s", c, title, msg)
+		return
+	}
+
+	opts := &notificationOptions{
+		Type:    "basic",
+		IconURL: n.iconURL,
+		Title:   title,
+		Message: msg,
+	}
+	n.notifications.Call("create", notificationID(c, subject), vert.ValueOf(opts).JSValue())
+}
+
+// notificationID returns the ID of the notification in the category about the
+// subject.
+func notificationID(c Category, subject string) string {
+	return c.String() + idSeparator + subject
+}
+
+// KeyLoadFailed notifies the user that a key failed to load automatically.
+// id and name identify the key, and are empty if keys could not be loaded at
+// all.
+func (n *Notifier) KeyLoadFailed(ctx jsutil.AsyncContext, id, name string, err error) {
+	if id == "" {
+		n.notify(ctx, KeyLoad, id, "Failed to load keys", err.Error())
+		return
+	}
+	n.notify(ctx, KeyLoad, id, "Failed to load key", fmt.Sprintf("Key %s failed to load: %v", name, err))
+}
+
+// SignatureDenied notifies the user that a request to sign using a key was
+// refused by a constraint on the key.
+func (n *Notifier) SignatureDenied(ctx jsutil.AsyncContext, id, name, reason string) {
+	n.notify(ctx, Signature, id, "Signature refused", fmt.Sprintf("Use of key %s was refused: %s", name, reason))
+}
+
+// CertificateExpiring notifies the user that a key, or its certificate,
+// expires soon or has expired.
+func (n *Notifier) CertificateExpiring(ctx jsutil.AsyncContext, id, name string, expiresAt time.Time, expired bool) {
+	formatted := expiresAt.Format("2006-01-02 15:04")
+	if expired {
+		n.notify(ctx, Expiry, id, "Key expired", fmt.Sprintf("Key %s expired on %s", name, formatted))
+		return
+	}
+	n.notify(ctx, Expiry, id, "Key expiring soon", fmt.Sprintf("Key %s expires on %s", name, formatted))
+}
+
+// QuotaWarning notifies the user that the named storage area is nearly full,
+// such that keys may soon fail to be saved.
+func (n *Notifier) QuotaWarning(ctx jsutil.AsyncContext, area string, used, quota int) {
+	n.notify(ctx, Quota, area, "Storage nearly full", fmt.Sprintf("%s storage is %d%% full (%d of %d bytes); remove unused keys to free space", area, used*100/quota, used, quota))
+}
+
+// OnClicked is the callback invoked when the user clicks a notification. The
+// notification is dismissed, and the options page opened at the section
+// relevant to it.
+func (n *Notifier) OnClicked(notificationID string) {
+	name, _, _ := strings.Cut(notificationID, idSeparator)
+	c, ok := parseCategory(name)
+	if !ok {
+		jsutil.LogDebug("Notifier.OnClicked: ignoring unknown notification %s", notificationID)
+		return
+	}
+	n.notifications.Call("clear", notificationID)
+	n.open(c.section())
+}
+
+// Define a distinct type for each message. These are embedded in each
+// message, and are distinct from those used by keys.Server.
+const (
+	msgTypeSettings int = 6000 + iota
+	msgTypeSettingsRsp
+	msgTypeSetEnabled
+	msgTypeSetEnabledRsp
+)
+
+type msgSettings struct {
+	Type int `js:"type"`
+}
+
+type rspSettings struct {
+	Type     int      `js:"type"`
+	Settings Settings `js:"settings"`
+	Err      string   `js:"err"`
+}
+
+type msgSetEnabled struct {
+	Type int `js:"type"`
+	// Category is the name of the category, or empty for all
+	// notifications.
+	Category string `js:"category"`
+	Enabled  bool   `js:"enabled"`
+}
+
+type rspSetEnabled struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+// errString returns the message of err, or an empty string if it is nil.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// OnMessage is the callback invoked when a message is received. Requests sent
+// using GetSettings, SetEnabled and SetCategoryEnabled are answered. Other
+// messages are ignored, in which case undefined is returned such that they may
+// be handled elsewhere.
+func (n *Notifier) OnMessage(ctx jsutil.AsyncContext, headerObj js.Value, _ js.Value) js.Value {
+	var hdr msgSettings
+	if err := vert.ValueOf(headerObj).AssignTo(&hdr); err != nil {
+		return js.Undefined()
+	}
+	switch hdr.Type {
+	case msgTypeSettings:
+		jsutil.LogDebug("Notifier.OnMessage(Settings req)")
+		rsp := rspSettings{Type: msgTypeSettingsRsp}
+		s, err := n.Settings(ctx)
+		if s != nil {
+			rsp.Settings = *s
+		}
+		rsp.Err = errString(err)
+		return vert.ValueOf(rsp).JSValue()
+	case msgTypeSetEnabled:
+		var m msgSetEnabled
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return vert.ValueOf(rspSetEnabled{Type: msgTypeSetEnabledRsp, Err: fmt.Sprintf("failed to parse SetEnabled message: %v", err)}).JSValue()
+		}
+		jsutil.LogDebug("Notifier.OnMessage(SetEnabled req): category=%q enabled=%t", m.Category, m.Enabled)
+		var err error
+		if m.Category == "" {
+			err = n.SetEnabled(ctx, m.Enabled)
+		} else if c, ok := parseCategory(m.Category); ok {
+			err = n.SetCategoryEnabled(ctx, c, m.Enabled)
+		} else {
+			err = fmt.Errorf("invalid category: %s", m.Category)
+		}
+		return vert.ValueOf(rspSetEnabled{Type: msgTypeSetEnabledRsp, Err: errString(err)}).JSValue()
+	}
+	return js.Undefined()
+}
+
+// GetSettings returns the settings maintained by the background page, such
+// that they can be displayed by the options page.
+func GetSettings(ctx jsutil.AsyncContext, msg message.Sender) (*Settings, error) {
+	m := msgSettings{Type: msgTypeSettings}
+	jsutil.LogDebug("Notifications.GetSettings(req)")
+	rspObj, err := msg.Send(ctx, vert.ValueOf(m).JSValue())
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSettings
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if rsp.Err != "" {
+		return nil, errors.New(rsp.Err)
+	}
+	return &rsp.Settings, nil
+}
+
+// setEnabled sends a request to enable or disable notifications in the named
+// category, or all notifications if it is empty.
+func setEnabled(ctx jsutil.AsyncContext, msg message.Sender, category string, enabled bool) error {
+	m := msgSetEnabled{Type: msgTypeSetEnabled, Category: category, Enabled: enabled}
+	jsutil.LogDebug("Notifications.SetEnabled(req): category=%q enabled=%t", category, enabled)
+	rspObj, err := msg.Send(ctx, vert.ValueOf(m).JSValue())
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var rsp rspSetEnabled
+	if err := vert.ValueOf(rspObj).AssignTo(&rsp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if rsp.Err != "" {
+		return errors.New(rsp.Err)
+	}
+	return nil
+}
+
+// SetEnabled sets whether any notifications are displayed by the background
+// page; see Notifier.SetEnabled.
+func SetEnabled(ctx jsutil.AsyncContext, msg message.Sender, enabled bool) error {
+	return setEnabled(ctx, msg, "", enabled)
+}
+
+// SetCategoryEnabled sets whether notifications in the category are displayed
+// by the background page; see Notifier.SetCategoryEnabled.
+func SetCategoryEnabled(ctx jsutil.AsyncContext, msg message.Sender, c Category, enabled bool) error {
+	return setEnabled(ctx, msg, c.String(), enabled)
+}
@@ -0,0 +1,192 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifications
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	mfakes "github.com/google/chrome-ssh-agent/go/message/fakes"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// newNotifier returns a Notifier using fakes, and the sections of the options
+// page it opened.
+func newNotifier() (*Notifier, *mfakes.Notifications, *[]string) {
+	fake := mfakes.NewNotifications()
+	var opened []string
+	n := New(fake.Value(), "icon.png", storage.NewRaw(st.NewMemArea()), func(section string) {
+		opened = append(opened, section)
+	})
+	return n, fake, &opened
+}
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		n, fake, _ := newNotifier()
+		expiresAt := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC).Local()
+
+		n.KeyLoadFailed(ctx, "", "", errors.New("storage unavailable"))
+		n.KeyLoadFailed(ctx, "id-1", "my-key", errors.New("wrong passphrase"))
+		n.SignatureDenied(ctx, "id-1", "my-key", "destination not permitted for key: example.com")
+		n.CertificateExpiring(ctx, "id-2", "other-key", expiresAt, false)
+		n.QuotaWarning(ctx, "sync", 90000, 100000)
+
+		want := map[string]mfakes.Notification{
+			"keyLoad/":       {Title: "Failed to load keys", Message: "storage unavailable"},
+			"keyLoad/id-1":   {Title: "Failed to load key", Message: "Key my-key failed to load: wrong passphrase"},
+			"signature/id-1": {Title: "Signature refused", Message: "Use of key my-key was refused: destination not permitted for key: example.com"},
+			"expiry/id-2":    {Title: "Key expiring soon", Message: "Key other-key expires on " + expiresAt.Format("2006-01-02 15:04")},
+			"quota/sync":     {Title: "Storage nearly full", Message: "sync storage is 90% full (90000 of 100000 bytes); remove unused keys to free space"},
+		}
+		got := map[string]mfakes.Notification{}
+		for _, id := range fake.Displayed() {
+			got[id], _ = fake.Get(id)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect notifications; -got +want: %s", diff)
+		}
+
+		// Repeated events replace the notification, rather than adding
+		// another.
+		n.CertificateExpiring(ctx, "id-2", "other-key", expiresAt, true)
+		if got := len(fake.Displayed()); got != len(want) {
+			t.Errorf("incorrect number of notifications after repeated event; got %d, want %d", got, len(want))
+		}
+		wantExpired := mfakes.Notification{Title: "Key expired", Message: "Key other-key expired on " + expiresAt.Format("2006-01-02 15:04")}
+		if got, _ := fake.Get("expiry/id-2"); got != wantExpired {
+			t.Errorf("incorrect notification after repeated event; got %+v, want %+v", got, wantExpired)
+		}
+	})
+}
+
+func TestSettings(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		n, fake, _ := newNotifier()
+		// expect triggers a notification in each category, and checks
+		// those displayed.
+		expect := func(description string, want ...string) {
+			t.Helper()
+			for _, id := range fake.Displayed() {
+				fake.Value().Call("clear", id)
+			}
+			n.KeyLoadFailed(ctx, "id", "key", errors.New("failed"))
+			n.SignatureDenied(ctx, "id", "key", "refused")
+			n.CertificateExpiring(ctx, "id", "key", time.Now(), true)
+			n.QuotaWarning(ctx, "sync", 1, 1)
+			if diff := cmp.Diff(fake.Displayed(), want, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%s: incorrect notifications; -got +want: %s", description, diff)
+			}
+		}
+
+		expect("default", "expiry/id", "keyLoad/id", "quota/sync", "signature/id")
+
+		if err := n.SetCategoryEnabled(ctx, Signature, false); err != nil {
+			t.Fatalf("SetCategoryEnabled failed: %v", err)
+		}
+		if err := n.SetCategoryEnabled(ctx, Quota, false); err != nil {
+			t.Fatalf("SetCategoryEnabled failed: %v", err)
+		}
+		expect("categories disabled", "expiry/id", "keyLoad/id")
+
+		if err := n.SetEnabled(ctx, false); err != nil {
+			t.Fatalf("SetEnabled failed: %v", err)
+		}
+		expect("all disabled")
+
+		// Categories remain disabled when all are enabled again.
+		if err := n.SetEnabled(ctx, true); err != nil {
+			t.Fatalf("SetEnabled failed: %v", err)
+		}
+		expect("all enabled", "expiry/id", "keyLoad/id")
+
+		if err := n.SetCategoryEnabled(ctx, Signature, true); err != nil {
+			t.Fatalf("SetCategoryEnabled failed: %v", err)
+		}
+		expect("category enabled", "expiry/id", "keyLoad/id", "signature/id")
+
+		if err := n.SetCategoryEnabled(ctx, Category(0), false); err == nil {
+			t.Errorf("SetCategoryEnabled unexpectedly succeeded for invalid category")
+		}
+	})
+}
+
+func TestOnClicked(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		n, fake, opened := newNotifier()
+		n.SignatureDenied(ctx, "id", "key", "refused")
+		n.QuotaWarning(ctx, "sync", 1, 1)
+
+		// Clicking dismisses the notification, and opens the relevant
+		// section of the options page.
+		n.OnClicked("signature/id")
+		n.OnClicked("quota/sync")
+		// Notifications displayed by others are ignored.
+		n.OnClicked("unknown")
+
+		if diff := cmp.Diff(*opened, []string{"auditPane", "controlPane"}); diff != "" {
+			t.Errorf("incorrect sections opened; -got +want: %s", diff)
+		}
+		if got := fake.Displayed(); len(got) != 0 {
+			t.Errorf("notifications not dismissed when clicked: %v", got)
+		}
+	})
+}
+
+func TestClientServer(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		n, _, _ := newNotifier()
+		hub := mfakes.NewHub()
+		hub.AddReceiver(n)
+
+		if err := SetEnabled(ctx, hub, false); err != nil {
+			t.Fatalf("SetEnabled failed: %v", err)
+		}
+		if err := SetCategoryEnabled(ctx, hub, Expiry, false); err != nil {
+			t.Fatalf("SetCategoryEnabled failed: %v", err)
+		}
+		if err := SetCategoryEnabled(ctx, hub, Category(0), false); err == nil {
+			t.Errorf("SetCategoryEnabled unexpectedly succeeded for invalid category")
+		}
+
+		got, err := GetSettings(ctx, hub)
+		if err != nil {
+			t.Fatalf("GetSettings failed: %v", err)
+		}
+		want := &Settings{Disabled: true, DisabledCategories: []string{"expiry"}}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect settings; -got +want: %s", diff)
+		}
+		if got.Enabled(KeyLoad) || got.Enabled(Expiry) {
+			t.Errorf("categories unexpectedly enabled while all disabled")
+		}
+	})
+}
@@ -20,26 +20,40 @@ import (
 	"syscall/js"
 
 	"github.com/google/chrome-ssh-agent/go/app"
+	"github.com/google/chrome-ssh-agent/go/commands"
+	"github.com/google/chrome-ssh-agent/go/confirm"
 	"github.com/google/chrome-ssh-agent/go/dom"
 	"github.com/google/chrome-ssh-agent/go/jsutil"
 	"github.com/google/chrome-ssh-agent/go/keys"
 	"github.com/google/chrome-ssh-agent/go/message"
+	"github.com/google/chrome-ssh-agent/go/metrics"
 	"github.com/google/chrome-ssh-agent/go/optionsui"
 	"github.com/google/chrome-ssh-agent/go/testing"
+	"github.com/google/chrome-ssh-agent/go/webauthn"
+)
+
+const (
+	// lockParam is the query string parameter present when the background
+	// page opens us to lock or unlock the agent. Keep in sync with
+	// go/background/main.go.
+	lockParam = "lock"
 )
 
 type options struct {
-	manager keys.Manager
-	doc     *dom.Doc
+	manager  keys.Manager
+	progress *keys.ProgressReceiver
+	doc      *dom.Doc
 }
 
 func newOptions() *options {
-	mgr := keys.NewClient(message.NewLocalSender())
+	progress := keys.NewProgressReceiver()
+	mgr := keys.NewClient(message.NewLocalSender(), keys.WithProgress(progress))
 	doc := dom.New(js.Null())
 
 	return &options{
-		manager: mgr,
-		doc:     doc,
+		manager:  mgr,
+		progress: progress,
+		doc:      doc,
 	}
 }
 
@@ -48,10 +62,64 @@ func (a *options) Name() string {
 }
 
 func (a *options) Init(ctx jsutil.AsyncContext, cleanup *jsutil.CleanupFuncs) error {
+	qs := dom.NewURLSearchParams(dom.DefaultQueryString())
+
+	// The background page opens us to confirm use of a key.
+	if qs.Has(confirm.RequestParam) {
+		reqID := qs.Get(confirm.RequestParam)
+		req := &keys.ConfirmRequest{
+			Name:   qs.Get(confirm.NameParam),
+			Client: qs.Get(confirm.ClientParam),
+			Host:   qs.Get(confirm.HostParam),
+		}
+		ui := optionsui.NewConfirm(a.doc, req, func(ctx jsutil.AsyncContext, approved bool) {
+			if err := confirm.Respond(ctx, message.NewLocalSender(), reqID, approved); err != nil {
+				jsutil.LogError("failed to respond to confirmation request: %v", err)
+			}
+		})
+		cleanup.Add(ui.Release)
+		return nil
+	}
+
+	// The background page opens us to perform operations on security
+	// keys, since the WebAuthn API is not available to it.
+	if qs.Has(webauthn.RequestParam) {
+		optionsui.ShowSecurityKey(a.doc)
+		// Failures are also reported to the background page, which
+		// closes the window once it receives the response.
+		if err := webauthn.Perform(ctx, message.NewLocalSender(), js.Global().Get("navigator").Get("credentials"), qs.Get(webauthn.RequestParam)); err != nil {
+			jsutil.LogError("failed to perform security key request: %v", err)
+		}
+		js.Global().Get("window").Call("close")
+		return nil
+	}
+
 	ui := optionsui.New(a.manager, a.doc)
+	ui.SetMetrics(func(ctx jsutil.AsyncContext) (*metrics.Snapshot, error) {
+		return metrics.Get(ctx, message.NewLocalSender())
+	})
+	ui.SetNotifications(message.NewLocalSender())
+	ui.SetCommands(func(ctx jsutil.AsyncContext) ([]*commands.Command, error) {
+		return commands.List(ctx, js.Global().Get("chrome").Get("commands"))
+	})
 	cleanup.Add(ui.Release)
 
-	qs := dom.NewURLSearchParams(dom.DefaultQueryString())
+	// Keys may be changed by the background page (e.g., when unloaded
+	// because they are idle), by other pages, or from other devices.
+	cleanup.Add(onMessage(keys.NewChangeReceiver(func(ctx jsutil.AsyncContext, _ []keys.ChangeEvent) {
+		ui.Refresh(ctx)
+	})))
+	// The background page reports the progress of keys being generated.
+	cleanup.Add(onMessage(a.progress))
+
+	// The background page opens us to lock or unlock the agent in
+	// response to a keyboard command.
+	if qs.Has(lockParam) {
+		ui.ToggleLock(ctx)
+		js.Global().Get("window").Call("close")
+		return nil
+	}
+
 	if qs.Has("test") {
 		testing.WriteResults(a.doc, ui.EndToEndTest(ctx))
 	}
@@ -59,6 +127,32 @@ func (a *options) Init(ctx jsutil.AsyncContext, cleanup *jsutil.CleanupFuncs) er
 	return nil
 }
 
+// receiver receives messages broadcast within our own extension.
+type receiver interface {
+	OnMessage(ctx jsutil.AsyncContext, headerObj js.Value, sender js.Value) js.Value
+}
+
+// onMessage registers the receiver to be invoked when messages are broadcast
+// within our own extension. The returned cleanup function must be invoked to
+// stop receiving messages.
+func onMessage(r receiver) jsutil.CleanupFunc {
+	onMessage := js.Global().Get("chrome").Get("runtime").Get("onMessage")
+	listener := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		var message, sender js.Value
+		jsutil.ExpandArgs(args, &message, &sender)
+		jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+			r.OnMessage(ctx, message, sender)
+			return js.Undefined(), nil
+		})
+		return nil
+	})
+	onMessage.Call("addListener", listener)
+	return func() {
+		onMessage.Call("removeListener", listener)
+		listener.Release()
+	}
+}
+
 func main() {
 	a := app.New(newOptions())
 	defer a.Release()
@@ -0,0 +1,88 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/dom"
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/keys"
+)
+
+// RespondFunc is invoked with the user's response to a confirmation request.
+type RespondFunc func(ctx jsutil.AsyncContext, approved bool)
+
+// ConfirmUI implements the behavior underlying the user interface displayed
+// to confirm use of a key. It is displayed in place of the options.
+type ConfirmUI struct {
+	dom     *dom.Doc
+	respond RespondFunc
+	cleanup *jsutil.CleanupFuncs
+}
+
+// NewConfirm returns a new ConfirmUI instance asking the user to approve use
+// of a key, as described by req. domObj is the DOM instance corresponding to
+// the document in which the UI is displayed.
+func NewConfirm(domObj *dom.Doc, req *keys.ConfirmRequest, respond RespondFunc) *ConfirmUI {
+	result := &ConfirmUI{
+		dom:     domObj,
+		respond: respond,
+		cleanup: &jsutil.CleanupFuncs{},
+	}
+
+	domObj.GetElement("options").Set("hidden", true)
+	domObj.GetElement("confirmPane").Set("hidden", false)
+	nameText := domObj.GetElement("confirmName")
+	dom.RemoveChildren(nameText)
+	dom.AppendChild(nameText, domObj.NewText(req.Name), nil)
+	// The client and host are only described if they are known.
+	if req.Client != "" {
+		clientText := domObj.GetElement("confirmClient")
+		dom.RemoveChildren(clientText)
+		dom.AppendChild(clientText, domObj.NewText(req.Client), nil)
+	}
+	if req.Host != "" {
+		hostText := domObj.GetElement("confirmHost")
+		dom.RemoveChildren(hostText)
+		dom.AppendChild(hostText, domObj.NewText(req.Host), nil)
+		domObj.GetElement("confirmHostText").Set("hidden", false)
+	}
+
+	// Add event handlers.
+	cf := result.cleanup
+	cf.Add(dom.OnClick(domObj.GetElement("confirmAllow"), func(ctx jsutil.AsyncContext, _ dom.Event) {
+		result.done(ctx, true)
+	}))
+	cf.Add(dom.OnClick(domObj.GetElement("confirmDeny"), func(ctx jsutil.AsyncContext, _ dom.Event) {
+		result.done(ctx, false)
+	}))
+	return result
+}
+
+// Release cleans up any resources when ConfirmUI is no longer used.
+func (u *ConfirmUI) Release() {
+	u.cleanup.Do()
+}
+
+// done sends the response, and closes the window.
+func (u *ConfirmUI) done(ctx jsutil.AsyncContext, approved bool) {
+	u.respond(ctx, approved)
+	if win := js.Global().Get("window"); !win.IsUndefined() {
+		win.Call("close")
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/dom"
+	dt "github.com/google/chrome-ssh-agent/go/dom/testing"
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConfirm(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		button      string
+		want        bool
+	}{
+		{
+			description: "allow",
+			button:      "confirmAllow",
+			want:        true,
+		},
+		{
+			description: "deny",
+			button:      "confirmDeny",
+			want:        false,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				domObj := dom.New(dt.NewDocForTesting(optionsHTMLData))
+				responded := false
+				var got bool
+				ui := NewConfirm(domObj, &keys.ConfirmRequest{Name: "my-key", Client: "crosh (extension-id)", Host: "git.example.com"}, func(_ jsutil.AsyncContext, approved bool) {
+					responded = true
+					got = approved
+				})
+				defer ui.Release()
+
+				if !domObj.GetElement("options").Get("hidden").Bool() {
+					t.Errorf("options displayed")
+				}
+				if diff := cmp.Diff(dom.TextContent(domObj.GetElement("confirmName")), "my-key"); diff != "" {
+					t.Errorf("incorrect key name; -got +want: %s", diff)
+				}
+				if diff := cmp.Diff(dom.TextContent(domObj.GetElement("confirmClient")), "crosh (extension-id)"); diff != "" {
+					t.Errorf("incorrect client; -got +want: %s", diff)
+				}
+				if diff := cmp.Diff(dom.TextContent(domObj.GetElement("confirmHost")), "git.example.com"); diff != "" {
+					t.Errorf("incorrect host; -got +want: %s", diff)
+				}
+				if domObj.GetElement("confirmHostText").Get("hidden").Bool() {
+					t.Errorf("host not displayed")
+				}
+
+				dom.DoClick(domObj.GetElement(tc.button))
+				if !poll(ctx, func() bool { return responded }) {
+					t.Errorf("no response")
+					return
+				}
+				if diff := cmp.Diff(got, tc.want); diff != "" {
+					t.Errorf("incorrect response; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
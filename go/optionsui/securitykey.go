@@ -0,0 +1,30 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"github.com/google/chrome-ssh-agent/go/dom"
+)
+
+// ShowSecurityKey displays the user interface asking the user to touch their
+// security key. It is displayed in place of the options while the browser
+// prompts for the security key. domObj is the DOM instance corresponding to
+// the document in which the UI is displayed.
+func ShowSecurityKey(domObj *dom.Doc) {
+	domObj.GetElement("options").Set("hidden", true)
+	domObj.GetElement("securityKeyPane").Set("hidden", false)
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package optionsui
+
+import (
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/dom"
+	dt "github.com/google/chrome-ssh-agent/go/dom/testing"
+)
+
+func TestShowSecurityKey(t *testing.T) {
+	t.Parallel()
+
+	domObj := dom.New(dt.NewDocForTesting(optionsHTMLData))
+	ShowSecurityKey(domObj)
+	if !domObj.GetElement("options").Get("hidden").Bool() {
+		t.Errorf("options displayed")
+	}
+	if domObj.GetElement("securityKeyPane").Get("hidden").Bool() {
+		t.Errorf("security key prompt not displayed")
+	}
+}
@@ -21,18 +21,24 @@ package optionsui
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
 	"sort"
+	"strings"
 	"sync"
 	"syscall/js"
 	"time"
 
+	"github.com/google/chrome-ssh-agent/go/commands"
 	"github.com/google/chrome-ssh-agent/go/dom"
 	"github.com/google/chrome-ssh-agent/go/jsutil"
 	"github.com/google/chrome-ssh-agent/go/keys"
 	"github.com/google/chrome-ssh-agent/go/keys/testdata"
+	"github.com/google/chrome-ssh-agent/go/message"
+	"github.com/google/chrome-ssh-agent/go/metrics"
+	"github.com/google/chrome-ssh-agent/go/notifications"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -42,11 +48,49 @@ type UI struct {
 	mgr         keys.Manager
 	dom         *dom.Doc
 	addButton   js.Value
+	lockButton  js.Value
+	copyButton  js.Value
+	lockedText  js.Value
 	loadingText js.Value
 	errorText   js.Value
+	importText  js.Value
 	keysData    js.Value
-	keys        []*displayedKey
-	cleanup     *jsutil.CleanupFuncs
+	auditData   js.Value
+	auditFilter js.Value
+	auditEnable js.Value
+	auditClear  js.Value
+	clientsData js.Value
+	clientID    js.Value
+	clientAllow js.Value
+	hostsData   js.Value
+	hostName    js.Value
+	hostAllow   js.Value
+	metricsPane js.Value
+	metricsData js.Value
+	metricsBtn  js.Value
+	// metrics returns the metrics to display, or is nil if they are not
+	// displayed.
+	metrics MetricsFunc
+	keys    []*displayedKey
+	cleanup *jsutil.CleanupFuncs
+	// clientsCleanup releases event handlers for the displayed clients.
+	clientsCleanup *jsutil.CleanupFuncs
+	// hostsCleanup releases event handlers for the displayed native
+	// messaging hosts.
+	hostsCleanup *jsutil.CleanupFuncs
+	notifyPane   js.Value
+	notifyEnable js.Value
+	notifyData   js.Value
+	// notifyEnables holds the checkbox for each category of notifications.
+	notifyEnables map[notifications.Category]js.Value
+	// notifySender is used to access the notification settings maintained
+	// by the background page, or is nil if they are not displayed.
+	notifySender message.Sender
+	commandsPane js.Value
+	commandsData js.Value
+	// commands returns the keyboard commands to display, or is nil if they
+	// are not displayed.
+	commands CommandsFunc
 }
 
 // signal is a primitive that allows one routine to block until notified.
@@ -83,10 +127,35 @@ func New(mgr keys.Manager, domObj *dom.Doc) *UI {
 		mgr:         mgr,
 		dom:         domObj,
 		addButton:   domObj.GetElement("add"),
+		lockButton:  domObj.GetElement("lock"),
+		copyButton:  domObj.GetElement("copyPublicKeys"),
+		lockedText:  domObj.GetElement("lockedMessage"),
 		loadingText: domObj.GetElement("loadingMessage"),
 		errorText:   domObj.GetElement("errorMessage"),
+		importText:  domObj.GetElement("importSummary"),
 		keysData:    domObj.GetElement("keysData"),
+		auditData:   domObj.GetElement("auditData"),
+		auditFilter: domObj.GetElement("auditFilter"),
+		auditEnable: domObj.GetElement("auditEnabled"),
+		auditClear:  domObj.GetElement("auditClear"),
+		clientsData: domObj.GetElement("clientsData"),
+		clientID:    domObj.GetElement("clientID"),
+		clientAllow: domObj.GetElement("clientAllow"),
+		hostsData:   domObj.GetElement("nativeHostsData"),
+		hostName:    domObj.GetElement("nativeHostName"),
+		hostAllow:   domObj.GetElement("nativeHostAllow"),
+		metricsPane: domObj.GetElement("metricsPane"),
+		metricsData: domObj.GetElement("metricsData"),
+		metricsBtn:  domObj.GetElement("metricsRefresh"),
 		cleanup:     &jsutil.CleanupFuncs{},
+
+		clientsCleanup: &jsutil.CleanupFuncs{},
+		hostsCleanup:   &jsutil.CleanupFuncs{},
+		notifyPane:     domObj.GetElement("notificationsPane"),
+		notifyEnable:   domObj.GetElement("notificationsEnabled"),
+		notifyData:     domObj.GetElement("notificationCategories"),
+		commandsPane:   domObj.GetElement("commandsPane"),
+		commandsData:   domObj.GetElement("commandsData"),
 	}
 
 	// Add event handlers.
@@ -95,12 +164,125 @@ func New(mgr keys.Manager, domObj *dom.Doc) *UI {
 	cf.Add(result.dom.OnDOMContentLoaded(result.updateKeys))
 	// Configure new key on click
 	cf.Add(dom.OnClick(result.addButton, result.add))
+	// Lock or unlock the agent on click
+	cf.Add(dom.OnClick(result.lockButton, func(ctx jsutil.AsyncContext, _ dom.Event) {
+		result.ToggleLock(ctx)
+	}))
+	// Copy all public keys on click
+	cf.Add(dom.OnClick(result.copyButton, func(ctx jsutil.AsyncContext, _ dom.Event) {
+		result.copyPublicKeys(ctx, keys.InvalidID)
+	}))
+	// Display only the audit log entries for the selected key
+	cf.Add(dom.OnChange(result.auditFilter, func(ctx jsutil.AsyncContext, _ dom.Event) {
+		result.updateAuditLog(ctx)
+	}))
+	// Enable or disable the audit log on change
+	cf.Add(dom.OnChange(result.auditEnable, func(ctx jsutil.AsyncContext, _ dom.Event) {
+		if err := result.mgr.SetAuditLogEnabled(ctx, result.auditEnable.Get("checked").Bool()); err != nil {
+			result.setError(fmt.Errorf("failed to update audit log setting: %w", err))
+		}
+		result.updateAuditLog(ctx)
+	}))
+	// Clear the audit log on click
+	cf.Add(dom.OnClick(result.auditClear, func(ctx jsutil.AsyncContext, _ dom.Event) {
+		if err := result.mgr.ClearAuditLog(ctx); err != nil {
+			result.setError(fmt.Errorf("failed to clear audit log: %w", err))
+		}
+		result.updateAuditLog(ctx)
+	}))
+	// Permit the entered extension to connect on click
+	cf.Add(dom.OnClick(result.clientAllow, func(ctx jsutil.AsyncContext, _ dom.Event) {
+		if err := result.mgr.AllowClient(ctx, strings.TrimSpace(dom.Value(result.clientID))); err != nil {
+			result.setError(fmt.Errorf("failed to allow extension: %w", err))
+			return
+		}
+		result.setError(nil)
+		dom.SetValue(result.clientID, "")
+		result.updateClients(ctx)
+	}))
+	// Connect to the entered native messaging host on click
+	cf.Add(dom.OnClick(result.hostAllow, func(ctx jsutil.AsyncContext, _ dom.Event) {
+		if err := result.mgr.AllowNativeHost(ctx, strings.TrimSpace(dom.Value(result.hostName))); err != nil {
+			result.setError(fmt.Errorf("failed to allow native messaging host: %w", err))
+			return
+		}
+		result.setError(nil)
+		dom.SetValue(result.hostName, "")
+		result.updateNativeHosts(ctx)
+	}))
+	// Refresh the displayed metrics on click
+	cf.Add(dom.OnClick(result.metricsBtn, func(ctx jsutil.AsyncContext, _ dom.Event) {
+		result.updateMetrics(ctx)
+	}))
 	return result
 }
 
+// MetricsFunc returns the metrics maintained by the background page.
+type MetricsFunc func(ctx jsutil.AsyncContext) (*metrics.Snapshot, error)
+
+// SetMetrics displays the metrics returned by f alongside the keys. Metrics
+// are not displayed unless this is invoked.
+func (u *UI) SetMetrics(f MetricsFunc) {
+	u.metrics = f
+}
+
+// CommandsFunc returns the keyboard commands declared by the extension.
+type CommandsFunc func(ctx jsutil.AsyncContext) ([]*commands.Command, error)
+
+// SetCommands displays the keyboard commands returned by f, along with their
+// shortcuts. Commands are not displayed unless this is invoked.
+func (u *UI) SetCommands(f CommandsFunc) {
+	u.commands = f
+}
+
+// notificationDescriptions describes each category of notifications in the
+// settings.
+var notificationDescriptions = map[notifications.Category]string{
+	notifications.KeyLoad:   "Keys that fail to load",
+	notifications.Signature: "Signature requests refused by key constraints",
+	notifications.Expiry:    "Certificates that are expiring or have expired",
+	notifications.Quota:     "Storage that is nearly full",
+}
+
+// SetNotifications displays the settings for notifications displayed by the
+// background page, which are accessed by sending messages using msg. The
+// settings are not displayed unless this is invoked.
+func (u *UI) SetNotifications(msg message.Sender) {
+	u.notifySender = msg
+	u.notifyEnables = map[notifications.Category]js.Value{}
+	// Enable or disable all notifications on change
+	u.cleanup.Add(dom.OnChange(u.notifyEnable, func(ctx jsutil.AsyncContext, _ dom.Event) {
+		if err := notifications.SetEnabled(ctx, msg, u.notifyEnable.Get("checked").Bool()); err != nil {
+			u.setError(fmt.Errorf("failed to update notification setting: %w", err))
+		}
+		u.updateNotifications(ctx)
+	}))
+	for _, c := range notifications.Categories {
+		c := c
+		dom.AppendChild(u.notifyData, u.dom.NewElement("div"), func(row js.Value) {
+			dom.AppendChild(row, u.dom.NewElement("label"), func(label js.Value) {
+				dom.AppendChild(label, u.dom.NewElement("input"), func(input js.Value) {
+					input.Set("type", "checkbox")
+					u.notifyEnables[c] = input
+					// Enable or disable the category on change
+					u.cleanup.Add(dom.OnChange(input, func(ctx jsutil.AsyncContext, _ dom.Event) {
+						if err := notifications.SetCategoryEnabled(ctx, msg, c, input.Get("checked").Bool()); err != nil {
+							u.setError(fmt.Errorf("failed to update notification setting: %w", err))
+						}
+						u.updateNotifications(ctx)
+					}))
+				})
+				dom.AppendChild(label, u.dom.NewText(notificationDescriptions[c]), nil)
+			})
+		})
+	}
+}
+
 // Release cleans up any resources when UI is no longer used.
 func (u *UI) Release() {
 	u.setKeys(nil)
+	u.clientsCleanup.Do()
+	u.hostsCleanup.Do()
 	u.cleanup.Do()
 }
 
@@ -116,30 +298,62 @@ func (u *UI) setError(err error) {
 	}
 }
 
-// add configures a new key.  It displays a dialog prompting the user for a name
-// and the corresponding private key.  If the user continues, the key is
+// setImportSummary updates the UI to display the outcome of importing several
+// keys. If results is nil, then any displayed summary is cleared.
+func (u *UI) setImportSummary(results []*keys.ImportResult) {
+	dom.RemoveChildren(u.importText)
+
+	for _, r := range results {
+		text := fmt.Sprintf("Added key %q", r.Name)
+		if r.Err != "" {
+			text = fmt.Sprintf("Failed to add key %q: %s", r.Name, r.Err)
+		}
+		dom.AppendChild(u.importText, u.dom.NewElement("div"), func(div js.Value) {
+			dom.AppendChild(div, u.dom.NewText(text), nil)
+		})
+	}
+}
+
+// add configures new keys.  It displays a dialog prompting the user for a name
+// and the corresponding private keys.  If the user continues, the keys are
 // added to the manager.
 func (u *UI) add(ctx jsutil.AsyncContext, _ dom.Event) {
-	ok, name, privateKey := u.promptAdd(ctx)
+	ok, name, privateKey, expectedPublicKey := u.promptAdd(ctx)
 	if !ok {
 		return
 	}
 
-	if err := u.mgr.Add(ctx, name, privateKey); err != nil {
+	u.setImportSummary(nil)
+	var opts []keys.AddOption
+	if strings.TrimSpace(expectedPublicKey) != "" {
+		opts = append(opts, keys.WithExpectedPublicKey(expectedPublicKey))
+	}
+	results, err := u.mgr.Import(ctx, name, privateKey, opts...)
+	if err != nil {
 		u.setError(fmt.Errorf("failed to add key: %w", err))
 		return
 	}
-
-	u.setError(nil)
 	u.updateKeys(ctx)
+
+	// A single key is reported as before; a summary is only needed when
+	// several were supplied.
+	if len(results) == 1 {
+		if results[0].Err != "" {
+			u.setError(fmt.Errorf("failed to add key: %s", results[0].Err))
+		}
+		return
+	}
+	u.setImportSummary(results)
 }
 
-// promptAdd displays a dialog prompting the user for a name and private key.
-func (u *UI) promptAdd(ctx jsutil.AsyncContext) (ok bool, name, privateKey string) {
+// promptAdd displays a dialog prompting the user for a name and private key,
+// and optionally the public key to which the private key must correspond.
+func (u *UI) promptAdd(ctx jsutil.AsyncContext) (ok bool, name, privateKey, expectedPublicKey string) {
 	dialog := dom.NewDialog(u.dom.GetElement("addDialog"))
 	form := u.dom.GetElement("addForm")
 	nameField := u.dom.GetElement("addName")
 	keyField := u.dom.GetElement("addKey")
+	expectedField := u.dom.GetElement("addExpectedPublicKey")
 	cancel := u.dom.GetElement("addCancel")
 
 	sig := newSignal()
@@ -148,6 +362,7 @@ func (u *UI) promptAdd(ctx jsutil.AsyncContext) (ok bool, name, privateKey strin
 		ok = true
 		name = dom.Value(nameField)
 		privateKey = dom.Value(keyField)
+		expectedPublicKey = dom.Value(expectedField)
 		dialog.Close()
 		sig.Notify()
 	}))
@@ -158,6 +373,7 @@ func (u *UI) promptAdd(ctx jsutil.AsyncContext) (ok bool, name, privateKey strin
 	cleanup.Add(dialog.OnClose(func(ctx jsutil.AsyncContext, evt dom.Event) {
 		dom.SetValue(nameField, "")
 		dom.SetValue(keyField, "")
+		dom.SetValue(expectedField, "")
 		cleanup.Do()
 	}))
 
@@ -167,7 +383,8 @@ func (u *UI) promptAdd(ctx jsutil.AsyncContext) (ok bool, name, privateKey strin
 }
 
 // load loads the key with the specified ID.  A dialog prompts the user for a
-// passphrase if the private key is encrypted.
+// passphrase if the private key is encrypted, and prompts again if the
+// passphrase is incorrect.
 func (u *UI) load(ctx jsutil.AsyncContext, id keys.ID) {
 	k := u.keyByID(id)
 	if k == nil {
@@ -175,18 +392,26 @@ func (u *UI) load(ctx jsutil.AsyncContext, id keys.ID) {
 		return
 	}
 
-	var ok bool
-	var passphrase string
-	if k.Encrypted {
-		ok, passphrase = u.promptPassphrase(ctx)
-		if !ok {
-			return
+	for {
+		var ok bool
+		var passphrase string
+		if k.Encrypted {
+			ok, passphrase = u.promptPassphrase(ctx)
+			if !ok {
+				return
+			}
 		}
-	}
 
-	if err := u.mgr.Load(ctx, id, passphrase); err != nil {
-		u.setError(fmt.Errorf("failed to load key: %w", err))
-		return
+		err := u.mgr.Load(ctx, id, passphrase)
+		if k.Encrypted && errors.Is(err, keys.ErrWrongPassphrase) {
+			u.setError(fmt.Errorf("failed to load key: %w", err))
+			continue
+		}
+		if err != nil {
+			u.setError(fmt.Errorf("failed to load key: %w", err))
+			return
+		}
+		break
 	}
 	u.setError(nil)
 	u.updateKeys(ctx)
@@ -221,6 +446,57 @@ func (u *UI) promptPassphrase(ctx jsutil.AsyncContext) (ok bool, passphrase stri
 	return
 }
 
+// ToggleLock locks the agent if it is unlocked, and unlocks it otherwise. A
+// dialog prompts the user for the passphrase, and prompts again if it is
+// incorrect.
+func (u *UI) ToggleLock(ctx jsutil.AsyncContext) {
+	locked, err := u.mgr.Locked(ctx)
+	if err != nil {
+		u.setError(fmt.Errorf("failed to get lock state: %w", err))
+		return
+	}
+
+	for {
+		ok, passphrase := u.promptPassphrase(ctx)
+		if !ok {
+			return
+		}
+
+		if !locked {
+			err = u.mgr.Lock(ctx, passphrase)
+		} else {
+			err = u.mgr.Unlock(ctx, passphrase)
+		}
+		if locked && errors.Is(err, keys.ErrWrongPassphrase) {
+			u.setError(fmt.Errorf("failed to unlock agent: %w", err))
+			continue
+		}
+		if err != nil {
+			u.setError(fmt.Errorf("failed to change lock state: %w", err))
+			return
+		}
+		break
+	}
+	u.setError(nil)
+	u.updateKeys(ctx)
+}
+
+// updateLock updates the UI to reflect whether the agent is locked.
+func (u *UI) updateLock(ctx jsutil.AsyncContext) {
+	locked, err := u.mgr.Locked(ctx)
+	if err != nil {
+		u.setError(fmt.Errorf("failed to get lock state: %w", err))
+		return
+	}
+	dom.RemoveChildren(u.lockButton)
+	label := "Lock"
+	if locked {
+		label = "Unlock"
+	}
+	dom.AppendChild(u.lockButton, u.dom.NewText(label), nil)
+	u.lockedText.Set("hidden", !locked)
+}
+
 // unload unloads the specified key.
 func (u *UI) unload(ctx jsutil.AsyncContext, id keys.ID) {
 	if err := u.mgr.Unload(ctx, id); err != nil {
@@ -282,6 +558,61 @@ func (u *UI) remove(ctx jsutil.AsyncContext, id keys.ID) {
 	u.updateKeys(ctx)
 }
 
+// promptCertificate displays a dialog prompting the user for the certificate
+// for a key.
+func (u *UI) promptCertificate(ctx jsutil.AsyncContext, id keys.ID) (ok bool, cert string) {
+	k := u.keyByID(id)
+	if k == nil {
+		u.setError(fmt.Errorf("failed to set certificate for key ID %s: not found", id))
+		return
+	}
+
+	dialog := dom.NewDialog(u.dom.GetElement("certificateDialog"))
+	form := u.dom.GetElement("certificateForm")
+	name := u.dom.GetElement("certificateName")
+	certField := u.dom.GetElement("certificate")
+	cancel := u.dom.GetElement("certificateCancel")
+	dom.AppendChild(name, u.dom.NewText(k.Name), nil)
+
+	sig := newSignal()
+	var cleanup jsutil.CleanupFuncs
+	cleanup.Add(dom.OnSubmit(form, func(ctx jsutil.AsyncContext, evt dom.Event) {
+		ok = true
+		cert = dom.Value(certField)
+		dialog.Close()
+		sig.Notify()
+	}))
+	cleanup.Add(dom.OnClick(cancel, func(ctx jsutil.AsyncContext, evt dom.Event) {
+		dialog.Close()
+		sig.Notify()
+	}))
+	cleanup.Add(dialog.OnClose(func(ctx jsutil.AsyncContext, evt dom.Event) {
+		dom.RemoveChildren(name)
+		dom.SetValue(certField, "")
+		cleanup.Do()
+	}))
+
+	dialog.ShowModal()
+	sig.Wait(ctx)
+	return
+}
+
+// setCertificate replaces the certificate for the key with the specified ID.
+// A dialog prompts the user for the new certificate.
+func (u *UI) setCertificate(ctx jsutil.AsyncContext, id keys.ID) {
+	ok, cert := u.promptCertificate(ctx, id)
+	if !ok {
+		return
+	}
+
+	if err := u.mgr.SetCertificate(ctx, id, cert); err != nil {
+		u.setError(fmt.Errorf("failed to set certificate for key ID %s: %w", id, err))
+		return
+	}
+	u.setError(nil)
+	u.updateKeys(ctx)
+}
+
 // displayedKey represents a key displayed in the UI.
 type displayedKey struct {
 	// ID is the unique ID corresponding to the key.
@@ -300,6 +631,12 @@ type displayedKey struct {
 	Blob string
 	// Comment is the comment attached to the key in the agent
 	Comment string
+	// CertificateExpired indicates that the certificate configured for
+	// the key has expired.
+	CertificateExpired bool
+	// Replaces is the name of the key that this key replaces, if a
+	// rotation is in progress.
+	Replaces string
 	// cleanup keeps track of any cleanup required before removing this key
 	// from the UI.
 	cleanup jsutil.CleanupFuncs
@@ -358,6 +695,12 @@ const (
 	UnloadButton
 	// RemoveButton indicates that the button removes the key.
 	RemoveButton
+	// CertificateButton indicates that the button replaces the key's
+	// certificate.
+	CertificateButton
+	// PublicKeyButton indicates that the button copies the key's public
+	// key to the clipboard.
+	PublicKeyButton
 )
 
 // buttonID returns the value of the 'id' attribute to be assigned to the HTML
@@ -371,6 +714,10 @@ func buttonID(kind buttonKind, id keys.ID) string {
 		s = "unload"
 	case RemoveButton:
 		s = "remove"
+	case CertificateButton:
+		s = "certificate"
+	case PublicKeyButton:
+		s = "publicKey"
 	}
 	return fmt.Sprintf("%s-%s", s, id)
 }
@@ -394,6 +741,18 @@ func (u *UI) setKeys(newKeys []*displayedKey) {
 					div.Set("className", "keyName")
 					dom.AppendChild(div, u.dom.NewText(k.Name), nil)
 				})
+				if k.CertificateExpired {
+					dom.AppendChild(cell, u.dom.NewElement("div"), func(div js.Value) {
+						div.Set("className", "keyWarning")
+						dom.AppendChild(div, u.dom.NewText("Certificate expired"), nil)
+					})
+				}
+				if k.Replaces != "" {
+					dom.AppendChild(cell, u.dom.NewElement("div"), func(div js.Value) {
+						div.Set("className", "keyNote")
+						dom.AppendChild(div, u.dom.NewText(fmt.Sprintf("Replaces: %s", k.Replaces)), nil)
+					})
+				}
 			})
 
 			// Controls
@@ -427,6 +786,26 @@ func (u *UI) setKeys(newKeys []*displayedKey) {
 						})
 					}
 
+					// Certificate button
+					dom.AppendChild(div, u.dom.NewElement("button"), func(btn js.Value) {
+						btn.Set("type", "button")
+						btn.Set("id", buttonID(CertificateButton, k.ID))
+						dom.AppendChild(btn, u.dom.NewText("Certificate"), nil)
+						k.cleanup.Add(dom.OnClick(btn, func(ctx jsutil.AsyncContext, evt dom.Event) {
+							u.setCertificate(ctx, k.ID)
+						}))
+					})
+
+					// Public key button
+					dom.AppendChild(div, u.dom.NewElement("button"), func(btn js.Value) {
+						btn.Set("type", "button")
+						btn.Set("id", buttonID(PublicKeyButton, k.ID))
+						dom.AppendChild(btn, u.dom.NewText("Copy Public Key"), nil)
+						k.cleanup.Add(dom.OnClick(btn, func(ctx jsutil.AsyncContext, evt dom.Event) {
+							u.copyPublicKeys(ctx, k.ID)
+						}))
+					})
+
 					// Remove button
 					dom.AppendChild(div, u.dom.NewElement("button"), func(btn js.Value) {
 						btn.Set("type", "button")
@@ -462,57 +841,40 @@ func (u *UI) setKeys(newKeys []*displayedKey) {
 	u.keys = newKeys
 }
 
-// mergeKeys merges configured and loaded keys to create a consolidated list
-// of keys that should be displayed in the UI.
-func mergeKeys(configured []*keys.ConfiguredKey, loaded []*keys.LoadedKey) []*displayedKey {
-	// Build map of configured keys for faster lookup
-	configuredMap := make(map[keys.ID]*keys.ConfiguredKey)
-	for _, k := range configured {
-		configuredMap[keys.ID(k.ID)] = k
+// availableKeys converts the keys available in the manager to the list of keys
+// that should be displayed in the UI.
+func availableKeys(available []*keys.AvailableKey) []*displayedKey {
+	names := map[string]string{}
+	for _, a := range available {
+		if c := a.Configured; c != nil {
+			names[c.ID] = c.Name
+		}
 	}
 
 	var result []*displayedKey
-
-	// Add all loaded keys. Keep track of the IDs that were detected as
-	// being loaded.
-	loadedIds := make(map[keys.ID]bool)
-	for _, l := range loaded {
-		// Gather basic fields we get for any loaded key.
-		dk := &displayedKey{
-			Loaded:  true,
-			Type:    l.Type,
-			Blob:    base64.StdEncoding.EncodeToString(l.Blob()),
-			Comment: l.Comment,
-		}
-		// Attempt to figure out if this is a key we loaded. If so, fill
-		// in some additional information.  It is possible that a key with
-		// a non-existent ID is loaded (e.g., it was removed while loaded);
-		// in this case we claim we do not have an ID.
-		if id := l.ID(); id != keys.InvalidID {
-			if ak := configuredMap[id]; ak != nil {
-				loadedIds[id] = true
-				dk.ID = id
-				dk.Name = ak.Name
+	for _, a := range available {
+		dk := &displayedKey{}
+		if l := a.Loaded; l != nil {
+			dk.Loaded = true
+			dk.Type = l.Type
+			dk.Blob = base64.StdEncoding.EncodeToString(l.Blob())
+			dk.Comment = l.Comment
+		}
+		// Keys that are loaded but not configured have no ID; for
+		// example, keys loaded by other means, or that were removed
+		// while loaded.
+		if c := a.Configured; c != nil {
+			dk.ID = keys.ID(c.ID)
+			dk.Name = c.Name
+			dk.CertificateExpired = c.CertificateExpired
+			dk.Replaces = names[c.Replaces]
+			if !dk.Loaded {
+				dk.Encrypted = c.Encrypted
 			}
 		}
 		result = append(result, dk)
 	}
 
-	// Add all configured keys that are not loaded.
-	for _, a := range configured {
-		// Skip any that we already covered above.
-		if loadedIds[keys.ID(a.ID)] {
-			continue
-		}
-
-		result = append(result, &displayedKey{
-			ID:        keys.ID(a.ID),
-			Loaded:    false,
-			Encrypted: a.Encrypted,
-			Name:      a.Name,
-		})
-	}
-
 	// Sort to ensure consistent ordering.
 	sort.Slice(result, func(i, j int) bool {
 		a, b := result[i], result[j]
@@ -534,27 +896,293 @@ func mergeKeys(configured []*keys.ConfiguredKey, loaded []*keys.LoadedKey) []*di
 	return result
 }
 
-// updateKeys queries the manager for configured and loaded keys, then triggers
-// UI updates to reflect the current state.
-func (u *UI) updateKeys(ctx jsutil.AsyncContext) {
-	configured, err := u.mgr.Configured(ctx)
+// copyPublicKeys copies the public key with the specified ID to the clipboard
+// in the authorized_keys format, or all public keys if id is InvalidID.
+func (u *UI) copyPublicKeys(ctx jsutil.AsyncContext, id keys.ID) {
+	entries, err := u.mgr.PublicKeys(ctx)
 	if err != nil {
-		u.setError(fmt.Errorf("failed to get configured keys: %w", err))
+		u.setError(fmt.Errorf("failed to get public keys: %w", err))
 		return
 	}
 
-	loaded, err := u.mgr.Loaded(ctx)
+	var lines []string
+	for _, e := range entries {
+		if id != keys.InvalidID && keys.ID(e.ID) != id {
+			continue
+		}
+		if e.AuthorizedKey == "" {
+			if id != keys.InvalidID {
+				u.setError(fmt.Errorf("public key for %s is not known until it is loaded", e.Name))
+				return
+			}
+			continue
+		}
+		lines = append(lines, e.AuthorizedKey)
+	}
+	if len(lines) == 0 {
+		u.setError(errors.New("no public keys are known"))
+		return
+	}
+
+	if err := dom.WriteClipboard(ctx, strings.Join(lines, "\n")+"\n"); err != nil {
+		u.setError(err)
+		return
+	}
+	u.setError(nil)
+}
+
+// updateKeys queries the manager for available keys, then triggers UI updates
+// to reflect the current state.
+func (u *UI) updateKeys(ctx jsutil.AsyncContext) {
+	available, err := u.mgr.Available(ctx)
 	if err != nil {
-		u.setError(fmt.Errorf("failed to get loaded keys: %w", err))
+		u.setError(fmt.Errorf("failed to get keys: %w", err))
 		return
 	}
 	u.setError(nil)
-	u.setKeys(mergeKeys(configured, loaded))
+	u.setKeys(availableKeys(available))
+	u.updateLock(ctx)
+	u.updateAuditLog(ctx)
+	u.updateClients(ctx)
+	u.updateNativeHosts(ctx)
+	u.updateMetrics(ctx)
+	u.updateNotifications(ctx)
+	u.updateCommands(ctx)
 
 	// We have successfully loaded keys. No need for initial status.
 	dom.RemoveChildren(u.loadingText)
 }
 
+// updateAuditLog queries the manager for the audit log, and displays the
+// entries for the key selected in the filter.
+func (u *UI) updateAuditLog(ctx jsutil.AsyncContext) {
+	enabled, err := u.mgr.AuditLogEnabled(ctx)
+	if err != nil {
+		u.setError(fmt.Errorf("failed to get audit log setting: %w", err))
+		return
+	}
+	u.auditEnable.Set("checked", enabled)
+
+	entries, err := u.mgr.AuditLog(ctx)
+	if err != nil {
+		u.setError(fmt.Errorf("failed to get audit log: %w", err))
+		return
+	}
+
+	// Offer each key as a filter, retaining the current selection if the
+	// key still exists.
+	filter := dom.Value(u.auditFilter)
+	dom.RemoveChildren(u.auditFilter)
+	dom.AppendChild(u.auditFilter, u.dom.NewElement("option"), func(opt js.Value) {
+		opt.Set("value", "")
+		dom.AppendChild(opt, u.dom.NewText("All keys"), nil)
+	})
+	for _, k := range u.keys {
+		if k.Name == "" {
+			continue
+		}
+		dom.AppendChild(u.auditFilter, u.dom.NewElement("option"), func(opt js.Value) {
+			opt.Set("value", string(k.ID))
+			dom.AppendChild(opt, u.dom.NewText(k.Name), nil)
+		})
+	}
+	if u.keyByID(keys.ID(filter)) == nil {
+		filter = ""
+	}
+	dom.SetValue(u.auditFilter, filter)
+
+	dom.RemoveChildren(u.auditData)
+	// Display the most recent entries first.
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if filter != "" && e.ID != filter {
+			continue
+		}
+		key := e.FingerprintSHA256
+		if k := u.keyByID(keys.ID(e.ID)); k != nil && k.Name != "" {
+			key = k.Name
+		}
+		if e.Superseded {
+			key = fmt.Sprintf("%s (being replaced)", key)
+		}
+		result := "Signed"
+		if e.Refused != "" {
+			result = fmt.Sprintf("Refused: %s", e.Refused)
+		}
+		dom.AppendChild(u.auditData, u.dom.NewElement("tr"), func(row js.Value) {
+			for _, text := range []string{
+				time.UnixMilli(e.TimeMillis).Format(time.DateTime),
+				key,
+				e.Connection,
+				e.Host,
+				result,
+			} {
+				dom.AppendChild(row, u.dom.NewElement("td"), func(cell js.Value) {
+					dom.AppendChild(cell, u.dom.NewText(text), nil)
+				})
+			}
+		})
+	}
+}
+
+// updateClients queries the manager for the extensions permitted to connect to
+// the agent, and displays them.
+func (u *UI) updateClients(ctx jsutil.AsyncContext) {
+	clients, err := u.mgr.AllowedClients(ctx)
+	if err != nil {
+		u.setError(fmt.Errorf("failed to get extensions permitted to connect: %w", err))
+		return
+	}
+
+	u.clientsCleanup.Do()
+	u.clientsCleanup = &jsutil.CleanupFuncs{}
+	dom.RemoveChildren(u.clientsData)
+	for _, id := range clients {
+		dom.AppendChild(u.clientsData, u.dom.NewElement("tr"), func(row js.Value) {
+			dom.AppendChild(row, u.dom.NewElement("td"), func(cell js.Value) {
+				dom.AppendChild(cell, u.dom.NewText(id), nil)
+			})
+			dom.AppendChild(row, u.dom.NewElement("td"), func(cell js.Value) {
+				dom.AppendChild(cell, u.dom.NewElement("button"), func(btn js.Value) {
+					btn.Set("type", "button")
+					dom.AppendChild(btn, u.dom.NewText("Remove"), nil)
+					u.clientsCleanup.Add(dom.OnClick(btn, func(ctx jsutil.AsyncContext, _ dom.Event) {
+						if err := u.mgr.DisallowClient(ctx, id); err != nil {
+							u.setError(fmt.Errorf("failed to remove extension: %w", err))
+						}
+						u.updateClients(ctx)
+					}))
+				})
+			})
+		})
+	}
+}
+
+// updateNativeHosts queries the manager for the native messaging hosts to
+// which the agent connects, and displays them.
+func (u *UI) updateNativeHosts(ctx jsutil.AsyncContext) {
+	hosts, err := u.mgr.NativeHosts(ctx)
+	if err != nil {
+		u.setError(fmt.Errorf("failed to get native messaging hosts: %w", err))
+		return
+	}
+
+	u.hostsCleanup.Do()
+	u.hostsCleanup = &jsutil.CleanupFuncs{}
+	dom.RemoveChildren(u.hostsData)
+	for _, name := range hosts {
+		dom.AppendChild(u.hostsData, u.dom.NewElement("tr"), func(row js.Value) {
+			dom.AppendChild(row, u.dom.NewElement("td"), func(cell js.Value) {
+				dom.AppendChild(cell, u.dom.NewText(name), nil)
+			})
+			dom.AppendChild(row, u.dom.NewElement("td"), func(cell js.Value) {
+				dom.AppendChild(cell, u.dom.NewElement("button"), func(btn js.Value) {
+					btn.Set("type", "button")
+					dom.AppendChild(btn, u.dom.NewText("Remove"), nil)
+					u.hostsCleanup.Add(dom.OnClick(btn, func(ctx jsutil.AsyncContext, _ dom.Event) {
+						if err := u.mgr.DisallowNativeHost(ctx, name); err != nil {
+							u.setError(fmt.Errorf("failed to remove native messaging host: %w", err))
+						}
+						u.updateNativeHosts(ctx)
+					}))
+				})
+			})
+		})
+	}
+}
+
+// updateMetrics obtains the metrics, and displays them.
+func (u *UI) updateMetrics(ctx jsutil.AsyncContext) {
+	if u.metrics == nil {
+		return
+	}
+	snapshot, err := u.metrics(ctx)
+	if err != nil {
+		u.setError(fmt.Errorf("failed to get metrics: %w", err))
+		return
+	}
+
+	u.metricsPane.Set("hidden", false)
+	dom.RemoveChildren(u.metricsData)
+	for _, m := range []struct {
+		name                string
+		session, cumulative int64
+	}{
+		{"List keys", snapshot.Session.List, snapshot.Cumulative.List},
+		{"Sign", snapshot.Session.Sign, snapshot.Cumulative.Sign},
+		{"Add keys", snapshot.Session.Add, snapshot.Cumulative.Add},
+		{"Remove keys", snapshot.Session.Remove, snapshot.Cumulative.Remove},
+		{"Failed", snapshot.Session.Failure, snapshot.Cumulative.Failure},
+	} {
+		dom.AppendChild(u.metricsData, u.dom.NewElement("tr"), func(row js.Value) {
+			for _, text := range []string{m.name, fmt.Sprint(m.session), fmt.Sprint(m.cumulative)} {
+				dom.AppendChild(row, u.dom.NewElement("td"), func(cell js.Value) {
+					dom.AppendChild(cell, u.dom.NewText(text), nil)
+				})
+			}
+		})
+	}
+}
+
+// updateNotifications obtains the notification settings, and displays them.
+func (u *UI) updateNotifications(ctx jsutil.AsyncContext) {
+	if u.notifySender == nil {
+		return
+	}
+	settings, err := notifications.GetSettings(ctx, u.notifySender)
+	if err != nil {
+		u.setError(fmt.Errorf("failed to get notification settings: %w", err))
+		return
+	}
+
+	u.notifyPane.Set("hidden", false)
+	u.notifyEnable.Set("checked", !settings.Disabled)
+	for c, input := range u.notifyEnables {
+		input.Set("checked", settings.Enabled(c))
+		// Categories cannot be changed while all notifications are
+		// disabled.
+		input.Set("disabled", settings.Disabled)
+	}
+}
+
+// updateCommands obtains the keyboard commands, and displays them.
+func (u *UI) updateCommands(ctx jsutil.AsyncContext) {
+	if u.commands == nil {
+		return
+	}
+	cmds, err := u.commands(ctx)
+	if err != nil {
+		u.setError(fmt.Errorf("failed to get keyboard shortcuts: %w", err))
+		return
+	}
+
+	u.commandsPane.Set("hidden", false)
+	dom.RemoveChildren(u.commandsData)
+	for _, c := range cmds {
+		description := c.Description
+		if description == "" {
+			description = c.Name
+		}
+		shortcut := c.Shortcut
+		if shortcut == "" {
+			shortcut = "Not set"
+		}
+		dom.AppendChild(u.commandsData, u.dom.NewElement("tr"), func(row js.Value) {
+			for _, text := range []string{description, shortcut} {
+				dom.AppendChild(row, u.dom.NewElement("td"), func(cell js.Value) {
+					dom.AppendChild(cell, u.dom.NewText(text), nil)
+				})
+			}
+		})
+	}
+}
+
+// Refresh updates the displayed keys. It should be invoked when keys are
+// changed other than through the UI; for example, when idle keys are unloaded.
+func (u *UI) Refresh(ctx jsutil.AsyncContext) {
+	u.updateKeys(ctx)
+}
+
 const (
 	pollInterval = 100 * time.Millisecond
 	pollTimeout  = 10 * time.Second
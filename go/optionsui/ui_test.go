@@ -15,6 +15,7 @@
 package optionsui
 
 import (
+	"encoding/base64"
 	"fmt"
 	"syscall/js"
 	"testing"
@@ -61,6 +62,7 @@ type testHarness struct {
 	addButton        js.Value
 	addName          js.Value
 	addKey           js.Value
+	addExpected      js.Value
 	addOk            js.Value
 	addCancel        js.Value
 	passphraseDialog js.Value
@@ -70,6 +72,24 @@ type testHarness struct {
 	removeDialog     js.Value
 	removeYes        js.Value
 	removeNo         js.Value
+	certDialog       js.Value
+	certInput        js.Value
+	certOk           js.Value
+	lockButton       js.Value
+}
+
+// fingerprint returns the SHA256 fingerprint of the public key blob, as
+// displayed by ssh-keygen.
+func fingerprint(blob string) string {
+	b, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		panic(fmt.Sprintf("failed to decode public key: %v", err))
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse public key: %v", err))
+	}
+	return ssh.FingerprintSHA256(pub)
 }
 
 func (h *testHarness) Release() {
@@ -109,6 +129,13 @@ func (h *testHarness) waitKeyLoaded(ctx jsutil.AsyncContext, name string) {
 	})
 }
 
+func (h *testHarness) waitLocked(ctx jsutil.AsyncContext, want bool) {
+	mustPoll(ctx, func() bool {
+		locked, err := h.manager.Locked(ctx)
+		return err == nil && locked == want
+	})
+}
+
 func (h *testHarness) waitKeyUnloaded(ctx jsutil.AsyncContext, name string) {
 	mustPoll(ctx, func() bool {
 		k := h.UI.keyByName(name)
@@ -142,6 +169,7 @@ func newHarness() *testHarness {
 		addButton:        domObj.GetElement("add"),
 		addName:          domObj.GetElement("addName"),
 		addKey:           domObj.GetElement("addKey"),
+		addExpected:      domObj.GetElement("addExpectedPublicKey"),
 		addOk:            domObj.GetElement("addOk"),
 		addCancel:        domObj.GetElement("addCancel"),
 		passphraseDialog: domObj.GetElement("passphraseDialog"),
@@ -151,6 +179,10 @@ func newHarness() *testHarness {
 		removeDialog:     domObj.GetElement("removeDialog"),
 		removeYes:        domObj.GetElement("removeYes"),
 		removeNo:         domObj.GetElement("removeNo"),
+		certDialog:       domObj.GetElement("certificateDialog"),
+		certInput:        domObj.GetElement("certificate"),
+		certOk:           domObj.GetElement("certificateOk"),
+		lockButton:       domObj.GetElement("lock"),
 	}
 }
 
@@ -213,6 +245,54 @@ func TestUserActions(t *testing.T) {
 				},
 			},
 		},
+		{
+			description: "add key with expired certificate",
+			sequence: func(ctx jsutil.AsyncContext, h *testHarness) {
+				dom.DoClick(h.addButton)
+				h.waitDialogOpen(ctx, h.addDialog)
+				dom.SetValue(h.addName, "new-key")
+				dom.SetValue(h.addKey, testdata.WithoutPassphrase.Private+"\n"+testdata.ExpiredCertificate)
+				dom.DoClick(h.addOk)
+				h.waitDialogClosed(ctx, h.addDialog)
+				h.waitKeyConfigured(ctx, "new-key")
+			},
+			wantDisplayed: []*displayedKey{
+				{
+					ID:                 validID,
+					Name:               "new-key",
+					CertificateExpired: true,
+				},
+			},
+		},
+		{
+			description: "remove expired certificate",
+			sequence: func(ctx jsutil.AsyncContext, h *testHarness) {
+				dom.DoClick(h.addButton)
+				h.waitDialogOpen(ctx, h.addDialog)
+				dom.SetValue(h.addName, "new-key")
+				dom.SetValue(h.addKey, testdata.WithoutPassphrase.Private+"\n"+testdata.ExpiredCertificate)
+				dom.DoClick(h.addOk)
+				h.waitDialogClosed(ctx, h.addDialog)
+				h.waitKeyConfigured(ctx, "new-key")
+
+				id := findKey(h.UI.displayedKeys(), "new-key")
+				dom.DoClick(h.dom.GetElement(buttonID(CertificateButton, id)))
+				h.waitDialogOpen(ctx, h.certDialog)
+				dom.SetValue(h.certInput, "")
+				dom.DoClick(h.certOk)
+				h.waitDialogClosed(ctx, h.certDialog)
+				mustPoll(ctx, func() bool {
+					k := h.UI.keyByName("new-key")
+					return k != nil && !k.CertificateExpired
+				})
+			},
+			wantDisplayed: []*displayedKey{
+				{
+					ID:   validID,
+					Name: "new-key",
+				},
+			},
+		},
 		{
 			description: "add multiple keys",
 			sequence: func(ctx jsutil.AsyncContext, h *testHarness) {
@@ -266,6 +346,20 @@ func TestUserActions(t *testing.T) {
 			},
 			wantErr: "failed to add key: invalid name: name must not be empty",
 		},
+		{
+			description: "add key with mismatched public key",
+			sequence: func(ctx jsutil.AsyncContext, h *testHarness) {
+				dom.DoClick(h.addButton)
+				h.waitDialogOpen(ctx, h.addDialog)
+				dom.SetValue(h.addName, "new-key")
+				dom.SetValue(h.addKey, testdata.WithoutPassphrase.Private)
+				dom.SetValue(h.addExpected, testdata.ED25519WithoutPassphrase.Type+" "+testdata.ED25519WithoutPassphrase.Blob)
+				dom.DoClick(h.addOk)
+				h.waitDialogClosed(ctx, h.addDialog)
+			},
+			wantErr: fmt.Sprintf("failed to add key: private key does not match expected public key: private key is %s, expected %s",
+				fingerprint(testdata.WithoutPassphrase.Blob), fingerprint(testdata.ED25519WithoutPassphrase.Blob)),
+		},
 		{
 			description: "remove key",
 			sequence: func(ctx jsutil.AsyncContext, h *testHarness) {
@@ -399,6 +493,40 @@ func TestUserActions(t *testing.T) {
 				},
 			},
 		},
+		{
+			description: "load key after incorrect passphrase",
+			sequence: func(ctx jsutil.AsyncContext, h *testHarness) {
+				dom.DoClick(h.addButton)
+				h.waitDialogOpen(ctx, h.addDialog)
+				dom.SetValue(h.addName, "new-passphrase-key")
+				dom.SetValue(h.addKey, testdata.WithPassphrase.Private)
+				dom.DoClick(h.addOk)
+				h.waitDialogClosed(ctx, h.addDialog)
+				h.waitKeyConfigured(ctx, "new-passphrase-key")
+
+				id := findKey(h.UI.displayedKeys(), "new-passphrase-key")
+				dom.DoClick(h.dom.GetElement(buttonID(LoadButton, id)))
+				h.waitDialogOpen(ctx, h.passphraseDialog)
+				dom.SetValue(h.passphraseInput, "incorrect-passphrase")
+				dom.DoClick(h.passphraseOk)
+				h.waitDialogClosed(ctx, h.passphraseDialog)
+				// The user is prompted again.
+				h.waitDialogOpen(ctx, h.passphraseDialog)
+				dom.SetValue(h.passphraseInput, testdata.WithPassphrase.Passphrase)
+				dom.DoClick(h.passphraseOk)
+				h.waitDialogClosed(ctx, h.passphraseDialog)
+				h.waitKeyLoaded(ctx, "new-passphrase-key")
+			},
+			wantDisplayed: []*displayedKey{
+				{
+					ID:     validID,
+					Name:   "new-passphrase-key",
+					Loaded: true,
+					Type:   testdata.WithPassphrase.Type,
+					Blob:   testdata.WithPassphrase.Blob,
+				},
+			},
+		},
 		{
 			description: "load key cancelled by user",
 			sequence: func(ctx jsutil.AsyncContext, h *testHarness) {
@@ -579,7 +707,7 @@ func TestUserActions(t *testing.T) {
 			},
 		},
 		{
-			description: "display loaded key that was previously-configured, then removed",
+			description: "remove loaded key",
 			sequence: func(ctx jsutil.AsyncContext, h *testHarness) {
 				dom.DoClick(h.addButton)
 				h.waitDialogOpen(ctx, h.addDialog)
@@ -603,12 +731,38 @@ func TestUserActions(t *testing.T) {
 				h.waitDialogClosed(ctx, h.removeDialog)
 				h.waitKeyRemoved(ctx, "new-key")
 			},
+			// Removing a key also unloads it.
+			wantDisplayed: nil,
+		},
+		{
+			description: "lock and unlock agent after incorrect passphrase",
+			sequence: func(ctx jsutil.AsyncContext, h *testHarness) {
+				directLoadKey(h.agent, testdata.WithoutPassphrase.Private)
+
+				dom.DoClick(h.lockButton)
+				h.waitDialogOpen(ctx, h.passphraseDialog)
+				dom.SetValue(h.passphraseInput, "lock-passphrase")
+				dom.DoClick(h.passphraseOk)
+				h.waitDialogClosed(ctx, h.passphraseDialog)
+				h.waitLocked(ctx, true)
+
+				dom.DoClick(h.lockButton)
+				h.waitDialogOpen(ctx, h.passphraseDialog)
+				dom.SetValue(h.passphraseInput, "incorrect-passphrase")
+				dom.DoClick(h.passphraseOk)
+				mustPoll(ctx, func() bool { return dom.TextContent(h.UI.errorText) != "" })
+				h.waitDialogOpen(ctx, h.passphraseDialog)
+				dom.SetValue(h.passphraseInput, "lock-passphrase")
+				dom.DoClick(h.passphraseOk)
+				h.waitDialogClosed(ctx, h.passphraseDialog)
+				h.waitLocked(ctx, false)
+			},
 			wantDisplayed: []*displayedKey{
 				{
 					ID:     keys.InvalidID,
 					Loaded: true,
-					Type:   testdata.WithPassphrase.Type,
-					Blob:   testdata.WithPassphrase.Blob,
+					Type:   testdata.WithoutPassphrase.Type,
+					Blob:   testdata.WithoutPassphrase.Blob,
 				},
 			},
 		},
@@ -36,8 +36,32 @@ type Area interface {
 	// storage.
 	Get(ctx jsutil.AsyncContext) (map[string]js.Value, error)
 
+	// GetKeys reads only the items with the specified keys. Keys that are
+	// not found in storage are omitted from the returned map.
+	GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error)
+
+	// BytesInUse returns the number of bytes used by the items with the
+	// specified keys.
+	BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error)
+
 	// Delete removes the items from storage with the specified keys. If a
 	// key is not found in storage, it will be silently ignored (i.e., no
 	// error will be returned).
 	Delete(ctx jsutil.AsyncContext, keys []string) error
+
+	// Watch registers f to be invoked when items in storage change.
+	// changed contains the new values for items that were added or
+	// updated, and removed contains the keys of items that were removed.
+	// The returned cleanup function must be invoked to stop watching.
+	Watch(f WatchFunc) jsutil.CleanupFunc
+}
+
+// WatchFunc is invoked with the items that have changed in storage.
+type WatchFunc func(changed map[string]js.Value, removed []string)
+
+// KeyLister is implemented by areas that can list the keys of stored items
+// without reading their values.
+type KeyLister interface {
+	// Keys returns the keys of all items currently stored.
+	Keys(ctx jsutil.AsyncContext) ([]string, error)
 }
@@ -17,10 +17,16 @@
 package storage
 
 import (
+	"bytes"
+	"compress/gzip"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
+	"sync"
 	"syscall/js"
 
 	"github.com/google/chrome-ssh-agent/go/jsutil"
@@ -36,14 +42,32 @@ import (
 //
 // Big implements the Area interface.
 type Big struct {
+	// limitMu guards maxItemBytes, which Migrate may change while other
+	// operations are in progress.
+	limitMu sync.Mutex
 	// maxItemBytes is the maximum size of the key and value for
 	// an entry in the storage.
 	maxItemBytes int
 
 	// s is the underlying storage area.
 	s Area
+
+	// observed is the Observed wrapping the underlying storage area, or
+	// nil if operations are not observed; see WithObserver.
+	observed *Observed
+
+	// indexed indicates that chunk reference counts are maintained in an
+	// index; see WithChunkIndex.
+	indexed bool
+
+	// compactMu guards compacting.
+	compactMu sync.Mutex
+	// compacting indicates that Compact is in progress.
+	compacting bool
 }
 
+// NewBig returns a Big that stores data in store, splitting values such that
+// no item exceeds maxItemBytes.
 func NewBig(maxItemBytes int, store Area) *Big {
 	return &Big{
 		maxItemBytes: maxItemBytes,
@@ -51,6 +75,25 @@ func NewBig(maxItemBytes int, store Area) *Big {
 	}
 }
 
+// NewBigAuto returns a Big that stores data in the specified area, which must
+// point to an object implementing the StorageArea API. The maximum item size
+// is determined from the limits reported by the area; see ReadLimits.
+//
+// The chunk size is derived from this such that chunk keys and any escaping
+// applied when Chrome stringifies values still fit within the limit.
+func NewBigAuto(area js.Value) *Big {
+	return NewBig(ReadLimits(area).MaxItemBytes(), NewRaw(area))
+}
+
+// WithObserver notifies o of all operations on the underlying storage area,
+// and returns b. Operations on chunks are attributed to the values they belong
+// to. It must be invoked before b is used.
+func (b *Big) WithObserver(o Observer) *Big {
+	b.observed = NewObserved(b.s, o)
+	b.s = b.observed
+	return b
+}
+
 // bigValueManifest is the value stored in place of a big value.  It contains
 // pointers to the chunks that actually contain the values.
 type bigValueManifest struct {
@@ -61,49 +104,167 @@ type bigValueManifest struct {
 	// ChunkKeys is the sequence of keys that are chunks of the stored
 	// data for this value.
 	ChunkKeys []string `js:"chunkKeys"`
+
+	// Encoding is the encoding applied to the value's JSON before it was
+	// split into chunks. Empty indicates no encoding, which is the case
+	// for manifests written before compression was supported.
+	Encoding string `js:"encoding"`
+
+	// Version is the version of the manifest format. Zero indicates a
+	// manifest written before the version was recorded, which is otherwise
+	// identical to version 1.
+	Version int `js:"version"`
 }
 
 func newBigValueManifest() *bigValueManifest {
 	return &bigValueManifest{
-		Magic: bigValueManifestMagic,
+		Magic:   bigValueManifestMagic,
+		Version: bigValueManifestVersion,
 	}
 }
 
+// Valid determines if the value is a manifest. This is the case for manifests
+// of any version, including those we do not understand; see Supported.
 func (b *bigValueManifest) Valid() bool {
 	return b.Magic == bigValueManifestMagic
 }
 
+// Supported determines if we understand the manifest's format well enough to
+// reassemble its value.
+//
+// Regardless, chunks referenced by any valid manifest are never considered
+// dangling. Future versions must therefore continue to list all chunks they
+// reference in ChunkKeys; this ensures that older clients do not remove data
+// written by newer clients after a sync.
+func (b *bigValueManifest) Supported() bool {
+	return b.Version <= bigValueManifestVersion
+}
+
 const (
 	// bigValueManifestMagic is the magic string that we encode in manifests.
 	bigValueManifestMagic = "3cc36853-b864-4122-beaa-516aa24448f6"
 
+	// bigValueManifestVersion is the latest manifest version that we
+	// understand, and the version of the manifests that we write.
+	bigValueManifestVersion = 1
+
 	// chunkKeyPrefix is the prefix added to keys for individual chunks.
 	chunkKeyPrefix = "chunk-" + bigValueManifestMagic + ":"
+
+	// gzipEncoding indicates that a value was compressed with gzip before
+	// being split into chunks.
+	gzipEncoding = "gzip"
+
+	// minCompressBytes is the size below which we skip compressing values.
+	// Compression overhead isn't worthwhile for small values.
+	minCompressBytes = 1024
 )
 
+// compress attempts to compress the value. The encoding is returned along with
+// the resulting value; if compression is skipped or isn't beneficial, the
+// value is returned unchanged with an empty encoding.
+func compress(val []byte) ([]byte, string) {
+	if len(val) < minCompressBytes {
+		return val, ""
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(val); err != nil {
+		return val, ""
+	}
+	if err := w.Close(); err != nil {
+		return val, ""
+	}
+	if buf.Len() >= len(val) {
+		return val, ""
+	}
+	return buf.Bytes(), gzipEncoding
+}
+
+// decompress reverses the encoding applied by compress.
+func decompress(val []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return val, nil
+	case gzipEncoding:
+		r, err := gzip.NewReader(bytes.NewReader(val))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
 // chunkKeyLength is the size of chunk keys that we store.
 var chunkKeyLength = len(makeChunkKey("dummy"))
 
+// itemLimit returns the maximum size of the key and value for an entry in the
+// storage.
+func (b *Big) itemLimit() int {
+	b.limitMu.Lock()
+	defer b.limitMu.Unlock()
+	return b.maxItemBytes
+}
+
+// setItemLimit sets the maximum size of the key and value for an entry in the
+// storage. The caller must hold the chunk lock in exclusive mode, such that no
+// write is in progress using the previous limit.
+func (b *Big) setItemLimit(maxItemBytes int) {
+	b.limitMu.Lock()
+	defer b.limitMu.Unlock()
+	b.maxItemBytes = maxItemBytes
+}
+
 func (b *Big) maxChunkSize() int {
-	// Chunk values are stored as strings; subtract 2 to leave room for
-	// quotes added as part of stringification when storing.
-	res := b.maxItemBytes - chunkKeyLength - 2
+	// Chunk values are base64-encoded strings, which require no escaping,
+	// and all chunk keys have the same length. The only overhead beyond the
+	// chunk itself is the key, plus the quotes added as part of
+	// stringification when storing.
+	maxItemBytes := b.itemLimit()
+	res := maxItemBytes - storedSize(makeChunkKey(""), `""`)
 	if res <= 0 {
-		panic(fmt.Errorf("maxItemBytes=%d is insufficient; chunkKeyLength=%d", b.maxItemBytes, chunkKeyLength))
+		panic(fmt.Errorf("maxItemBytes=%d is insufficient; chunkKeyLength=%d", maxItemBytes, chunkKeyLength))
 	}
 	return res
 }
 
+// storedSize returns the number of bytes counted against
+// QUOTA_BYTES_PER_ITEM when storing a value with the given JSON
+// representation at key.
+//
+// Chrome counts the UTF-8 length of the key plus that of the value serialized
+// as JSON. Chrome's serializer escapes some characters that JSON.stringify
+// leaves as-is, so we account for the additional bytes those require. See:
+//
+//	https://chromium.googlesource.com/chromium/chromium/+/707a0ab1f8777bda5aef8aadf6553b4b10f157b2/base/json/string_escape.cc#53
+func storedSize(key string, valJSON string) int {
+	n := len(key) + len(valJSON)
+	for _, r := range valJSON {
+		switch r {
+		case '<', '\x7f':
+			// Escaped as \u003C and \u007F respectively: 6 bytes
+			// rather than 1.
+			n += 5
+		case '\u2028', '\u2029':
+			// Escaped as \u2028 and \u2029 respectively: 6 bytes
+			// rather than 3.
+			n += 3
+		}
+	}
+	return n
+}
+
 func (b *Big) canStore(key string, valJSON string) bool {
-	// Values are stored as strings after converting to JSON.
-	// When stored as JSON, Chrome may escape some additional characters.
-	// See:
-	//   https://chromium.googlesource.com/chromium/chromium/+/707a0ab1f8777bda5aef8aadf6553b4b10f157b2/base/json/string_escape.cc#53
-	// Therefore, we give ourselves a significant margin for safety.
-	return len(key)+(len(valJSON)*2) <= b.maxItemBytes
+	return storedSize(key, valJSON) <= b.itemLimit()
 }
 
-// makeChunkKey returns the key at which this chunk should be stored.
+// makeChunkKey returns the key at which this chunk should be stored. The key
+// depends only on the chunk's contents, so identical chunks share storage,
+// regardless of the values they belong to or when they were written.
 func makeChunkKey(chunk string) string {
 	h := sha256.Sum256([]byte(chunk))
 	e := base64.StdEncoding.EncodeToString([]byte(h[:]))
@@ -116,62 +277,291 @@ func isChunkKey(key string) bool {
 }
 
 const (
-	// lockResourceID identifies the lock taken to protect against
-	// concurrent access during read-modify-write operations. For now, we
-	// have a single lock protecting all instances of Big.  We could have
-	// different locks for different instances, but the complexity doesn't
-	// seem worth it for now.
+	// lockResourceID identifies the lock protecting chunks. Operations
+	// that merely read, or that add and reference chunks, hold it in
+	// shared mode. Removal of unreferenced chunks holds it in exclusive
+	// mode, ensuring that chunks written by an in-progress Set are never
+	// removed before the corresponding manifest is written.
+	//
+	// For now, we have a single lock protecting all instances of Big.  We
+	// could have different locks for different instances, but the
+	// complexity doesn't seem worth it for now.
 	lockResourceID = "big-storage-lock"
+
+	// keyLockResourcePrefix is the prefix for per-key locks. These
+	// serialize writes to the same top-level key, while allowing writes to
+	// disjoint keys to proceed concurrently.
+	keyLockResourcePrefix = lockResourceID + ":key:"
 )
 
-// See PersistentStore.Set().
-func (b *Big) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+// withKeyLocks runs f while holding the per-key lock for each of the keys,
+// plus the chunk lock (in exclusive mode if requested, else shared mode).
+// Per-key locks are acquired in sorted order, and always before the chunk
+// lock, to avoid deadlock between operations on overlapping keys.
+func withKeyLocks(ctx jsutil.AsyncContext, keys []string, exclusive bool, f func(ctx jsutil.AsyncContext)) error {
+	uniq := map[string]bool{}
+	for _, k := range keys {
+		uniq[k] = true
+	}
+	var sorted []string
+	for k := range uniq {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var acquire func(ctx jsutil.AsyncContext, i int) error
+	acquire = func(ctx jsutil.AsyncContext, i int) error {
+		if i == len(sorted) {
+			if exclusive {
+				_, err := lock.Async(lockResourceID, f).Await(ctx)
+				return err
+			}
+			_, err := lock.AsyncShared(lockResourceID, f).Await(ctx)
+			return err
+		}
+		var err error
+		_, aerr := lock.Async(keyLockResourcePrefix+sorted[i], func(ctx jsutil.AsyncContext) {
+			err = acquire(ctx, i+1)
+		}).Await(ctx)
+		if aerr != nil {
+			return aerr
+		}
+		return err
+	}
+	return acquire(ctx, 0)
+}
+
+// collectGarbage deletes all chunks that are not referenced by any manifest,
+// taking the exclusive chunk lock.
+func (b *Big) collectGarbage(ctx jsutil.AsyncContext) error {
+	var err error
+	_, aerr := lock.Async(lockResourceID, func(ctx jsutil.AsyncContext) {
+		err = b.deleteDanglingChunks(ctx)
+	}).Await(ctx)
+	if aerr != nil {
+		return aerr
+	}
+	return err
+}
+
+// split prepares data for storage. Values that are small enough are stored
+// directly; larger ones are replaced by a manifest referencing chunks. The
+// chunks and values to be written are returned separately. The caller must
+// hold the chunk lock until they are written, such that Migrate cannot change
+// the maximum item size in the meantime.
+func (b *Big) split(data map[string]js.Value) (chunks, values map[string]js.Value) {
 	maxEncodedChunkSize := b.maxChunkSize()
 	maxDecodedChunkSize := base64.StdEncoding.DecodedLen(maxEncodedChunkSize)
 
-	chunked := map[string]js.Value{}
+	chunks = map[string]js.Value{}
+	values = map[string]js.Value{}
 	for k, v := range data {
 		json := jsutil.ToJSON(v)
 		if b.canStore(k, json) {
 			// Store directly. Value is small enough.
-			chunked[k] = v
+			values[k] = v
 			continue
 		}
 
-		// Value too large. Break into chunks. There are two caveats:
-		// - The JSON string may contain UTF-8 characters, meaning it
-		//   we have to be careful about splitting in the middle of a
-		//   single character (which occupies 2 bytes).
-		// - When stored, Chrome may escape some additional characters
-		//   and occupy more space than we compute.  See:
-		//     https://chromium.googlesource.com/chromium/chromium/+/707a0ab1f8777bda5aef8aadf6553b4b10f157b2/base/json/string_escape.cc#53
-		// To avoid this miscalculations in chunk sizes, we split into
-		// chunks such that each chunk, when encoded as base64, fits
-		// within the required chunk size.
+		// Value too large. Compress if worthwhile, then break into
+		// chunks. The JSON string may contain multibyte characters and
+		// characters that require escaping, so splitting it directly
+		// would make chunk sizes hard to reason about. Instead, we
+		// split such that each chunk, when encoded as base64 (which
+		// never requires escaping), fits within the required chunk
+		// size.
 		manifest := newBigValueManifest()
-		for i := 0; i < len(json); i += maxDecodedChunkSize {
+		encoded, encoding := compress([]byte(json))
+		manifest.Encoding = encoding
+		for i := 0; i < len(encoded); i += maxDecodedChunkSize {
 			extent := i + maxDecodedChunkSize
-			if extent > len(json) {
-				extent = len(json)
+			if extent > len(encoded) {
+				extent = len(encoded)
 			}
 
 			// Key is the hash of the contents. This is a simple way
 			// to avoid overwriting data.
-			chunk := base64.StdEncoding.EncodeToString([]byte(json[i:extent]))
+			chunk := base64.StdEncoding.EncodeToString(encoded[i:extent])
 			chunkKey := makeChunkKey(chunk)
 
 			// Add to manifest and data we will store.
 			manifest.ChunkKeys = append(manifest.ChunkKeys, chunkKey)
-			chunked[chunkKey] = js.ValueOf(chunk)
+			chunks[chunkKey] = js.ValueOf(chunk)
 		}
 
 		// Associate the manifest with the original key.
-		chunked[k] = vert.ValueOf(manifest).JSValue()
+		values[k] = vert.ValueOf(manifest).JSValue()
+	}
+	if b.observed != nil {
+		// Chunks are written before the manifests referencing them.
+		b.observed.learn(values)
+	}
+	return chunks, values
+}
+
+// write stores chunks and values produced by split. The caller must hold the
+// chunk lock, in either shared or exclusive mode.
+//
+// Writes happen in two phases. Chunks are written first; manifests and simple
+// values are only written once all chunks have been successfully stored. This
+// ensures that a manifest never references chunks that were not written. If
+// the second phase fails, some chunks may be left unreferenced; the caller
+// is responsible for removing them.
+//
+// Values and chunks that are already stored are skipped, such that re-saving
+// unchanged data performs no writes. This matters for chrome.storage.sync,
+// which limits the rate of write operations.
+func (b *Big) write(ctx jsutil.AsyncContext, chunks, values map[string]js.Value) error {
+	var keys []string
+	for k := range chunks {
+		keys = append(keys, k)
+	}
+	for k := range values {
+		keys = append(keys, k)
+	}
+	existing, err := b.s.GetKeys(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("failed to read existing values: %w", err)
+	}
+	chunks = changedItems(existing, chunks)
+	values = changedItems(existing, values)
+
+	// Phase 1: write chunks.
+	if len(chunks) > 0 {
+		if err := b.s.Set(ctx, chunks); err != nil {
+			return fmt.Errorf("failed to write chunks: %w", err)
+		}
+	}
+
+	// Phase 2: write manifests and simple values, which now reference only
+	// chunks that are known to be present.
+	if len(values) > 0 {
+		if err := b.s.Set(ctx, values); err != nil {
+			return fmt.Errorf("failed to write values: %w", err)
+		}
+	}
+	return nil
+}
+
+// changedItems returns the items whose values differ from those in existing.
+// Since chunk keys are derived from the chunk contents, this also skips chunks
+// shared with values that are already stored.
+func changedItems(existing, items map[string]js.Value) map[string]js.Value {
+	res := map[string]js.Value{}
+	for k, v := range items {
+		if ev, ok := existing[k]; ok && jsutil.ToJSON(ev) == jsutil.ToJSON(v) {
+			continue
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// See PersistentStore.Set().
+func (b *Big) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	var keys []string
+	for k := range data {
+		keys = append(keys, k)
 	}
 
+	var err error
+	if b.indexed {
+		if aerr := withKeyLocks(ctx, keys, true, func(ctx jsutil.AsyncContext) {
+			chunks, values := b.split(data)
+			err = b.commitIndexed(ctx, chunks, values, nil)
+		}); aerr != nil {
+			return aerr
+		}
+		return err
+	}
+
+	var chunks map[string]js.Value
+	if aerr := withKeyLocks(ctx, keys, false, func(ctx jsutil.AsyncContext) {
+		var values map[string]js.Value
+		chunks, values = b.split(data)
+		err = b.write(ctx, chunks, values)
+	}); aerr != nil {
+		return aerr
+	}
+	if err != nil && len(chunks) > 0 {
+		if cerr := b.collectGarbage(ctx); cerr != nil {
+			jsutil.LogError("Big.Set: failed to clean up chunks: %v", cerr)
+		}
+	}
+	return err
+}
+
+// Migrate updates the maximum size of items in storage, and rewrites any
+// stored items that exceed it. Big values whose chunks are too large are
+// re-split into smaller chunks, and their old chunks are removed. Values that
+// are already within the limit are left untouched, so this is idempotent and
+// safe to invoke each time the storage is initialized.
+func (b *Big) Migrate(ctx jsutil.AsyncContext, newMaxItemBytes int) error {
 	var err error
 	_, aerr := lock.Async(lockResourceID, func(ctx jsutil.AsyncContext) {
-		err = b.s.Set(ctx, chunked)
+		// Writers hold the lock in shared mode, so none is in progress
+		// using the previous limit.
+		b.setItemLimit(newMaxItemBytes)
+
+		err = func() error {
+			data, err := b.s.Get(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to read data: %w", err)
+			}
+
+			// Find all values that no longer fit.
+			rewrite := map[string]js.Value{}
+			for k, v := range data {
+				if isChunkKey(k) {
+					continue
+				}
+
+				manifest, ok := readManifest(v)
+				if !ok {
+					if !b.canStore(k, jsutil.ToJSON(v)) {
+						rewrite[k] = v
+					}
+					continue
+				}
+
+				var oversized bool
+				for _, chunkKey := range manifest.ChunkKeys {
+					chunkVal, present := data[chunkKey]
+					if present && !b.canStore(chunkKey, jsutil.ToJSON(chunkVal)) {
+						oversized = true
+						break
+					}
+				}
+				if !oversized {
+					continue
+				}
+				val, err := reassembleManifest(k, manifest, data)
+				if err != nil {
+					jsutil.LogError("Big.Migrate: skipping %s: %v", k, err)
+					continue
+				}
+				rewrite[k] = val
+			}
+
+			if len(rewrite) == 0 {
+				return nil // Nothing to do.
+			}
+
+			jsutil.Log("Big.Migrate: rewriting %d values", len(rewrite))
+			chunks, values := b.split(rewrite)
+			werr := b.write(ctx, chunks, values)
+
+			// Remove the chunks that were replaced, or that were
+			// left unreferenced by a failed write.
+			if err := b.deleteDanglingChunks(ctx); err != nil {
+				if werr != nil {
+					jsutil.LogError("Big.Migrate: failed to clean up chunks: %v", err)
+					return werr
+				}
+				return err
+			}
+			return werr
+		}()
 	}).Await(ctx)
 	if aerr != nil {
 		return aerr
@@ -179,11 +569,72 @@ func (b *Big) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
 	return err
 }
 
+var (
+	// ErrIncompleteValue indicates that a big value could not be
+	// reassembled because some of its chunks are missing.
+	ErrIncompleteValue = errors.New("incomplete value")
+
+	// ErrChunkMissing indicates that a chunk referenced by a manifest is
+	// not present in storage.
+	ErrChunkMissing = fmt.Errorf("%w: chunk missing", ErrIncompleteValue)
+
+	// ErrManifestTooNew indicates that a big value was written by a
+	// newer version using a manifest format that we do not understand.
+	// The extension must be updated to read the value.
+	ErrManifestTooNew = errors.New("manifest version too new")
+
+	// ErrChunkCorrupt indicates that a chunk's content no longer matches
+	// the hash embedded in its key.
+	ErrChunkCorrupt = errors.New("chunk corrupt")
+)
+
+// ChunkError describes a failure to reassemble a big value from its chunks.
+// Err is either ErrChunkMissing or ErrChunkCorrupt.
+type ChunkError struct {
+	// Key is the top-level key whose value could not be reassembled.
+	Key string
+	// ChunkKey is the key of the offending chunk.
+	ChunkKey string
+	// Err is the underlying reason for the failure.
+	Err error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("failed to read key %s: chunk %s: %v", e.Key, e.ChunkKey, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// readChunk returns the decoded content of a chunk, validating that it matches
+// the hash embedded in the chunk key.
+func readChunk(data map[string]js.Value, key, chunkKey string) ([]byte, error) {
+	chunkVal, present := data[chunkKey]
+	if !present {
+		return nil, &ChunkError{Key: key, ChunkKey: chunkKey, Err: ErrChunkMissing}
+	}
+	if chunkVal.Type() != js.TypeString {
+		return nil, &ChunkError{Key: key, ChunkKey: chunkKey, Err: ErrChunkCorrupt}
+	}
+	// Copying the string out of Javascript is relatively expensive, so
+	// do it only once.
+	chunk := chunkVal.String()
+	if makeChunkKey(chunk) != chunkKey {
+		return nil, &ChunkError{Key: key, ChunkKey: chunkKey, Err: ErrChunkCorrupt}
+	}
+	dec, err := base64.StdEncoding.DecodeString(chunk)
+	if err != nil {
+		return nil, &ChunkError{Key: key, ChunkKey: chunkKey, Err: fmt.Errorf("%w: base64 decode failed: %w", ErrChunkCorrupt, err)}
+	}
+	return dec, nil
+}
+
 // See PersistentStore.Get().
 func (b *Big) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
 	var data map[string]js.Value
 	var err error
-	_, aerr := lock.Async(lockResourceID, func(ctx jsutil.AsyncContext) {
+	_, aerr := lock.AsyncShared(lockResourceID, func(ctx jsutil.AsyncContext) {
 		data, err = b.s.Get(ctx)
 	}).Await(ctx)
 	if aerr != nil {
@@ -193,32 +644,223 @@ func (b *Big) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
 		return nil, err
 	}
 
-	unchunked := map[string]js.Value{}
+	b.upgradeManifests(ctx, data)
+	return reassemble(data, data)
+}
+
+// upgradeManifests rewrites any manifests in data that predate the manifest
+// version, such that they record the version. Failures are logged, since the
+// manifests remain readable regardless.
+func (b *Big) upgradeManifests(ctx jsutil.AsyncContext, data map[string]js.Value) {
+	var keys []string
 	for k, v := range data {
 		if isChunkKey(k) {
-			// Skip chunks. We read these as part of the manifest.
 			continue
 		}
+		if manifest, ok := readManifest(v); ok && manifest.Version == 0 {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
 
-		// Attempt to read as a manifest.
-		var manifest bigValueManifest
-		if err := vert.ValueOf(v).AssignTo(&manifest); err == nil && manifest.Valid() {
-			// Concatenate chunks and parse the JSON.
-			var json strings.Builder
-			for _, chunkKey := range manifest.ChunkKeys {
-				chunkVal, present := data[chunkKey]
-				if !present {
-					return nil, fmt.Errorf("failed to read data; chunk key %s missing", chunkKey)
+	var err error
+	if aerr := withKeyLocks(ctx, keys, false, func(ctx jsutil.AsyncContext) {
+		err = func() error {
+			// The manifests may have been replaced in the meantime.
+			latest, err := b.s.GetKeys(ctx, keys)
+			if err != nil {
+				return err
+			}
+			upgraded := map[string]js.Value{}
+			for k, v := range latest {
+				if manifest, ok := readManifest(v); ok && manifest.Version == 0 {
+					// The referenced chunks are unchanged.
+					manifest.Version = bigValueManifestVersion
+					upgraded[k] = vert.ValueOf(manifest).JSValue()
 				}
-				dec, err := base64.StdEncoding.DecodeString(chunkVal.String())
-				if err != nil {
-					return nil, fmt.Errorf("failed to read data; base64 decode failed: %w", err)
+			}
+			if len(upgraded) == 0 {
+				return nil
+			}
+			jsutil.Log("Big: upgrading %d manifests", len(upgraded))
+			return b.s.Set(ctx, upgraded)
+		}()
+	}); aerr != nil {
+		err = aerr
+	}
+	if err != nil {
+		jsutil.LogError("Big: failed to upgrade manifests: %v", err)
+	}
+}
+
+// readKeys reads the requested keys, along with any chunks referenced by
+// them. The caller must hold the chunk lock.
+func (b *Big) readKeys(ctx jsutil.AsyncContext, keys []string) (data, chunks map[string]js.Value, err error) {
+	data, err = b.s.GetKeys(ctx, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Fetch the chunks referenced by any manifests.
+	var chunkKeys []string
+	for _, v := range data {
+		if manifest, ok := readManifest(v); ok {
+			chunkKeys = append(chunkKeys, manifest.ChunkKeys...)
+		}
+	}
+	chunks, err = b.s.GetKeys(ctx, chunkKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, chunks, nil
+}
+
+// See PersistentStore.GetKeys().
+func (b *Big) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	// Chunks are an implementation detail; never return them directly.
+	var requested []string
+	for _, k := range keys {
+		if !isChunkKey(k) {
+			requested = append(requested, k)
+		}
+	}
+
+	var data, chunks map[string]js.Value
+	var err error
+	_, aerr := lock.AsyncShared(lockResourceID, func(ctx jsutil.AsyncContext) {
+		data, chunks, err = b.readKeys(ctx, requested)
+	}).Await(ctx)
+	if aerr != nil {
+		return nil, aerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b.upgradeManifests(ctx, data)
+	return reassemble(data, chunks)
+}
+
+// See PersistentStore.BytesInUse().
+func (b *Big) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	var n int
+	var err error
+	_, aerr := lock.AsyncShared(lockResourceID, func(ctx jsutil.AsyncContext) {
+		n, err = func() (int, error) {
+			data, err := b.s.GetKeys(ctx, keys)
+			if err != nil {
+				return 0, err
+			}
+
+			// Include the chunks referenced by any manifests. Shared
+			// chunks are only counted once.
+			all := map[string]bool{}
+			for k, v := range data {
+				all[k] = true
+				if manifest, ok := readManifest(v); ok {
+					for _, chunkKey := range manifest.ChunkKeys {
+						all[chunkKey] = true
+					}
+				}
+			}
+			var allKeys []string
+			for k := range all {
+				allKeys = append(allKeys, k)
+			}
+			return b.s.BytesInUse(ctx, allKeys)
+		}()
+	}).Await(ctx)
+	if aerr != nil {
+		return 0, aerr
+	}
+	return n, err
+}
+
+// Usage returns the number of bytes used in the underlying storage by each
+// value. For big values, this includes both the manifest and all referenced
+// chunks. A chunk shared by multiple values is attributed to each in equal
+// proportion.
+func (b *Big) Usage(ctx jsutil.AsyncContext) (map[string]int, error) {
+	var usage map[string]int
+	var err error
+	_, aerr := lock.AsyncShared(lockResourceID, func(ctx jsutil.AsyncContext) {
+		usage, err = func() (map[string]int, error) {
+			data, err := b.s.Get(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			// Determine which values reference each chunk.
+			refs := map[string][]string{}
+			for k, v := range data {
+				if isChunkKey(k) {
+					continue
+				}
+				if manifest, ok := readManifest(v); ok {
+					for _, chunkKey := range manifest.ChunkKeys {
+						refs[chunkKey] = append(refs[chunkKey], k)
+					}
 				}
+			}
 
-				json.WriteString(string(dec))
+			usage := map[string]int{}
+			for k := range data {
+				if isChunkKey(k) {
+					continue
+				}
+				n, err := b.s.BytesInUse(ctx, []string{k})
+				if err != nil {
+					return nil, fmt.Errorf("failed to get bytes in use for %s: %w", k, err)
+				}
+				usage[k] += n
+			}
+			for chunkKey, keys := range refs {
+				n, err := b.s.BytesInUse(ctx, []string{chunkKey})
+				if err != nil {
+					return nil, fmt.Errorf("failed to get bytes in use for chunk %s: %w", chunkKey, err)
+				}
+				for _, k := range keys {
+					usage[k] += n / len(keys)
+				}
 			}
+			return usage, nil
+		}()
+	}).Await(ctx)
+	if aerr != nil {
+		return nil, aerr
+	}
+	return usage, err
+}
 
-			unchunked[k] = jsutil.FromJSON(json.String())
+// readManifest attempts to interpret a stored value as a manifest.
+func readManifest(v js.Value) (*bigValueManifest, bool) {
+	var manifest bigValueManifest
+	if err := vert.ValueOf(v).AssignTo(&manifest); err != nil || !manifest.Valid() {
+		return nil, false
+	}
+	return &manifest, true
+}
+
+// reassemble returns the values in data, with any manifests replaced by the
+// values reassembled from their chunks. Chunks are read from chunks, and any
+// chunks present in data are skipped.
+func reassemble(data, chunks map[string]js.Value) (map[string]js.Value, error) {
+	unchunked := map[string]js.Value{}
+	for k, v := range data {
+		if isChunkKey(k) {
+			// Skip chunks. We read these as part of the manifest.
+			continue
+		}
+
+		// Attempt to read as a manifest.
+		if manifest, ok := readManifest(v); ok {
+			val, err := reassembleManifest(k, manifest, chunks)
+			if err != nil {
+				return nil, err
+			}
+			unchunked[k] = val
 			continue
 		}
 
@@ -229,10 +871,87 @@ func (b *Big) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
 	return unchunked, nil
 }
 
+// reassembleManifest returns the value for key reassembled from the chunks
+// referenced by its manifest.
+func reassembleManifest(key string, manifest *bigValueManifest, chunks map[string]js.Value) (js.Value, error) {
+	if !manifest.Supported() {
+		return js.Undefined(), fmt.Errorf("failed to read key %s: %w: got version %d, support up to %d", key, ErrManifestTooNew, manifest.Version, bigValueManifestVersion)
+	}
+
+	// Concatenate chunks, decode, and parse the JSON. Chunks are joined
+	// once all are read, avoiding repeatedly growing a buffer for values
+	// with many chunks.
+	parts := make([][]byte, 0, len(manifest.ChunkKeys))
+	for _, chunkKey := range manifest.ChunkKeys {
+		dec, err := readChunk(chunks, key, chunkKey)
+		if err != nil {
+			return js.Undefined(), err
+		}
+
+		parts = append(parts, dec)
+	}
+	encoded := bytes.Join(parts, nil)
+
+	json, err := decompress(encoded, manifest.Encoding)
+	if err != nil {
+		return js.Undefined(), fmt.Errorf("failed to read key %s: failed to decode value: %w", key, err)
+	}
+
+	return jsutil.FromJSON(string(json)), nil
+}
+
+// deleteDanglingChunks deletes all chunks that are not referenced by any
+// manifest. The caller must hold the chunk lock in exclusive mode.
+func (b *Big) deleteDanglingChunks(ctx jsutil.AsyncContext) error {
+	if b.indexed {
+		// Rebuilding also keeps the index consistent.
+		_, err := b.rebuildIndex(ctx)
+		return err
+	}
+
+	data, err := b.s.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to query for dangling chunks: %w", err)
+	}
+
+	// Initially, consider all chunk keys as dangling.
+	danglingChunkKeys := map[string]bool{}
+	for k := range data {
+		if isChunkKey(k) {
+			danglingChunkKeys[k] = true
+		}
+	}
+
+	// Remove those that are referenced by a manifest.
+	for _, v := range data {
+		manifest, ok := readManifest(v)
+		if !ok {
+			continue // This is not a manifest.
+		}
+		for _, chunkKey := range manifest.ChunkKeys {
+			delete(danglingChunkKeys, chunkKey)
+		}
+	}
+
+	// Delete dangling chunk keys.
+	var dangling []string
+	for k := range danglingChunkKeys {
+		dangling = append(dangling, k)
+	}
+	if err := b.s.Delete(ctx, dangling); err != nil {
+		return fmt.Errorf("failed to delete dangling chunks: %w", err)
+	}
+	return nil
+}
+
 // See PersistentStore.Delete().
 func (b *Big) Delete(ctx jsutil.AsyncContext, keys []string) error {
 	var derr error
-	_, aerr := lock.Async(lockResourceID, func(ctx jsutil.AsyncContext) {
+	if aerr := withKeyLocks(ctx, keys, true, func(ctx jsutil.AsyncContext) {
+		if b.indexed {
+			derr = b.commitIndexed(ctx, map[string]js.Value{}, map[string]js.Value{}, keys)
+			return
+		}
 		derr = func() error {
 			// Delete the requested keys.
 			if err := b.s.Delete(ctx, keys); err != nil {
@@ -243,43 +962,183 @@ func (b *Big) Delete(ctx jsutil.AsyncContext, keys []string) error {
 			// referenced by any manifest. This takes care of those that
 			// were just deleted, as well as any dangling ones that may
 			// have been left over from before.
-			data, err := b.s.Get(ctx)
-			if err != nil {
-				return fmt.Errorf("failed to query for dangling chunks: %w", err)
-			}
+			return b.deleteDanglingChunks(ctx)
+		}()
+	}); aerr != nil {
+		return aerr
+	}
+	return derr
+}
 
-			// Initially, consider all chunk keys as dangling.
-			danglingChunkKeys := map[string]bool{}
-			for k := range data {
-				if isChunkKey(k) {
-					danglingChunkKeys[k] = true
-				}
-			}
+// Keys returns the keys of all values in storage, without reading chunks or
+// reassembling values. If the underlying area implements KeyLister, no values
+// are read at all; this is considerably cheaper than Get when values span
+// many chunks (see BenchmarkKeys).
+func (b *Big) Keys(ctx jsutil.AsyncContext) ([]string, error) {
+	var all []string
+	if kl, ok := b.s.(KeyLister); ok {
+		keys, err := kl.Keys(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keys: %w", err)
+		}
+		all = keys
+	} else {
+		data, err := b.s.Get(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read data: %w", err)
+		}
+		all = dataKeys(data)
+	}
 
-			// Remove those that are referenced by a manifest.
-			for _, v := range data {
-				var manifest bigValueManifest
-				if err := vert.ValueOf(v).AssignTo(&manifest); err != nil || !manifest.Valid() {
-					continue // This is not a manifest.
-				}
-				for _, chunkKey := range manifest.ChunkKeys {
-					delete(danglingChunkKeys, chunkKey)
-				}
-			}
+	var res []string
+	for _, k := range all {
+		if !isChunkKey(k) {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
 
-			// Delete dangling chunk keys.
-			var dangling []string
-			for k := range danglingChunkKeys {
-				dangling = append(dangling, k)
+// Clear removes all data from the underlying storage area in a single
+// operation. This includes all values and manifests, all chunks (including
+// any left dangling), and any other data stored in the area.
+func (b *Big) Clear(ctx jsutil.AsyncContext) error {
+	var err error
+	_, aerr := lock.Async(lockResourceID, func(ctx jsutil.AsyncContext) {
+		err = func() error {
+			data, err := b.s.Get(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to read data: %w", err)
 			}
-			if err := b.s.Delete(ctx, dangling); err != nil {
-				return fmt.Errorf("failed to delete dangling chunks: %w", err)
+			if len(data) == 0 {
+				return nil
 			}
-			return nil
+			return b.s.Delete(ctx, dataKeys(data))
 		}()
 	}).Await(ctx)
 	if aerr != nil {
 		return aerr
 	}
-	return derr
+	return err
+}
+
+// ClearPrefix removes all values with keys that have the specified prefix,
+// along with any chunks that are no longer referenced as a result. Chunks
+// shared with remaining values are retained.
+func (b *Big) ClearPrefix(ctx jsutil.AsyncContext, prefix string) error {
+	var data map[string]js.Value
+	var err error
+	_, aerr := lock.AsyncShared(lockResourceID, func(ctx jsutil.AsyncContext) {
+		data, err = b.s.Get(ctx)
+	}).Await(ctx)
+	if aerr != nil {
+		return aerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read data: %w", err)
+	}
+
+	var keys []string
+	for k := range data {
+		if !isChunkKey(k) && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return b.Delete(ctx, keys)
+}
+
+// bigWatcher tracks changes to the underlying storage, and reports changes
+// to logical values once they are fully available.
+type bigWatcher struct {
+	b *Big
+	f WatchFunc
+
+	// mu serializes processing of change events.
+	mu sync.Mutex
+	// pending are manifests whose chunks have not all been observed. They
+	// are reported once all chunks are available.
+	pending map[string]*bigValueManifest
+}
+
+// See PersistentStore.Watch().
+//
+// Changes to chunks and manifests are coalesced such that f is invoked only
+// once a big value can be fully reassembled. Chunks may arrive before or
+// after the manifest that references them (e.g., when changes are synced from
+// another device); a manifest is not reported until all of its chunks are
+// present.
+func (b *Big) Watch(f WatchFunc) jsutil.CleanupFunc {
+	w := &bigWatcher{
+		b:       b,
+		f:       f,
+		pending: map[string]*bigValueManifest{},
+	}
+	return b.s.Watch(func(changed map[string]js.Value, removed []string) {
+		// Processing may require reading chunks from storage, so we
+		// must do so asynchronously.
+		jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+			w.process(ctx, changed, removed)
+			return js.Undefined(), nil
+		})
+	})
+}
+
+func (w *bigWatcher) process(ctx jsutil.AsyncContext, changed map[string]js.Value, removed []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	nchanged := map[string]js.Value{}
+	var nremoved []string
+	for k, v := range changed {
+		if isChunkKey(k) {
+			continue // May complete a pending manifest; checked below.
+		}
+		if manifest, ok := readManifest(v); ok {
+			w.pending[k] = manifest
+			continue
+		}
+		delete(w.pending, k)
+		nchanged[k] = v
+	}
+	for _, k := range removed {
+		if isChunkKey(k) {
+			continue
+		}
+		delete(w.pending, k)
+		nremoved = append(nremoved, k)
+	}
+
+	if len(w.pending) > 0 {
+		var chunkKeys []string
+		for _, manifest := range w.pending {
+			chunkKeys = append(chunkKeys, manifest.ChunkKeys...)
+		}
+		chunks, err := w.b.s.GetKeys(ctx, chunkKeys)
+		if err != nil {
+			jsutil.LogError("Big.Watch: failed to read chunks: %v", err)
+			chunks = map[string]js.Value{}
+		}
+
+		for k, manifest := range w.pending {
+			val, err := reassembleManifest(k, manifest, chunks)
+			if errors.Is(err, ErrChunkMissing) {
+				continue // Wait for remaining chunks to arrive.
+			}
+			delete(w.pending, k)
+			if err != nil {
+				jsutil.LogError("Big.Watch: dropping change to %s: %v", k, err)
+				continue
+			}
+			nchanged[k] = val
+		}
+	}
+
+	if len(nchanged) == 0 && len(nremoved) == 0 {
+		return
+	}
+	w.f(nchanged, nremoved)
 }
@@ -15,63 +15,48 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"reflect"
 	"strings"
+	"sync"
 	"syscall/js"
 	"testing"
+	"testing/quick"
+	"time"
 
 	"github.com/google/chrome-ssh-agent/go/jsutil"
 	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
 	st "github.com/google/chrome-ssh-agent/go/storage/testing"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/norunners/vert"
 )
 
-func isManifest(v js.Value) bool {
-	var manifest bigValueManifest
-	if err := vert.ValueOf(v).AssignTo(&manifest); err == nil && manifest.Valid() {
-		return true
-	}
-	return false
-}
-
-func getEntryType(ctx jsutil.AsyncContext, s Area) (map[string]string, error) {
-	data, err := s.Get(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	res := map[string]string{}
-	for k, v := range data {
-		switch {
-		case isChunkKey(k):
-			res[k] = "chunk"
-		case isManifest(v):
-			res[k] = "manifest"
-		default:
-			res[k] = "simple"
-		}
-	}
-	return res, nil
-}
-
-func getJSON(ctx jsutil.AsyncContext, s Area) (map[string]string, error) {
-	data, err := s.Get(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	json := map[string]string{}
-	for k, v := range data {
-		json[k] = jsutil.ToJSON(v)
-	}
-	return json, nil
-}
-
 const (
 	defaultMaxItemBytes = 1024
 )
 
+// areaModes are the in-memory areas against which Big is tested: one that
+// completes operations promptly, and one that delays their completion, such
+// that concurrent operations complete out of order.
+var areaModes = []struct {
+	description string
+	newArea     func() js.Value
+}{
+	{description: "prompt", newArea: st.NewMemArea},
+	{
+		description: "delayed",
+		newArea: func() js.Value {
+			return st.NewDelayedArea(st.NewMemArea(), st.Delay{Latency: 5 * time.Millisecond, Reorder: true})
+		},
+	},
+}
+
 func TestSetAndGet(t *testing.T) {
 	t.Parallel()
 
@@ -127,37 +112,39 @@ func TestSetAndGet(t *testing.T) {
 	}
 
 	for _, tc := range testcases {
-		tc := tc
-		t.Run(tc.description, func(t *testing.T) {
-			t.Parallel()
-
-			if tc.maxItemBytes == 0 {
-				tc.maxItemBytes = defaultMaxItemBytes
-			}
+		for _, mode := range areaModes {
+			tc, mode := tc, mode
+			t.Run(tc.description+"/"+mode.description, func(t *testing.T) {
+				t.Parallel()
 
-			jut.DoSync(func(ctx jsutil.AsyncContext) {
-				b := NewBig(tc.maxItemBytes, NewRaw(st.NewMemArea()))
-				if err := b.Set(ctx, tc.set); err != nil {
-					t.Fatalf("set failed: %v", err)
+				if tc.maxItemBytes == 0 {
+					tc.maxItemBytes = defaultMaxItemBytes
 				}
 
-				gotRaw, err := getEntryType(ctx, b.s)
-				if err != nil {
-					t.Fatalf("get failed for underlying storage: %v", err)
-				}
-				got, err := getJSON(ctx, b)
-				if err != nil {
-					t.Fatalf("get failed for Big: %v", err)
-				}
+				jut.DoSync(func(ctx jsutil.AsyncContext) {
+					b := NewBig(tc.maxItemBytes, NewRaw(mode.newArea()))
+					if err := b.Set(ctx, tc.set); err != nil {
+						t.Fatalf("set failed: %v", err)
+					}
 
-				if diff := cmp.Diff(gotRaw, tc.wantRaw); diff != "" {
-					t.Errorf("incorrect raw data: -got +want: %s", diff)
-				}
-				if diff := cmp.Diff(got, tc.want); diff != "" {
-					t.Errorf("incorrect data: -got +want: %s", diff)
-				}
+					gotRaw, err := st.EntryTypes(ctx, b.s)
+					if err != nil {
+						t.Fatalf("get failed for underlying storage: %v", err)
+					}
+					got, err := st.Get(ctx, b)
+					if err != nil {
+						t.Fatalf("get failed for Big: %v", err)
+					}
+
+					if diff := cmp.Diff(gotRaw, tc.wantRaw); diff != "" {
+						t.Errorf("incorrect raw data: -got +want: %s", diff)
+					}
+					if diff := cmp.Diff(got, tc.want); diff != "" {
+						t.Errorf("incorrect data: -got +want: %s", diff)
+					}
+				})
 			})
-		})
+		}
 	}
 }
 
@@ -235,41 +222,1483 @@ func TestDelete(t *testing.T) {
 		},
 	}
 
+	for _, tc := range testcases {
+		for _, mode := range areaModes {
+			tc, mode := tc, mode
+			t.Run(tc.description+"/"+mode.description, func(t *testing.T) {
+				t.Parallel()
+
+				if tc.maxItemBytes == 0 {
+					tc.maxItemBytes = defaultMaxItemBytes
+				}
+
+				jut.DoSync(func(ctx jsutil.AsyncContext) {
+					b := NewBig(tc.maxItemBytes, NewRaw(mode.newArea()))
+					if err := b.Set(ctx, tc.set); err != nil {
+						t.Fatalf("set failed: %v", err)
+					}
+
+					if err := b.Delete(ctx, tc.del); err != nil {
+						t.Fatalf("delete failed: %v", err)
+					}
+
+					gotRaw, err := st.EntryTypes(ctx, b.s)
+					if err != nil {
+						t.Fatalf("get failed for underlying storage: %v", err)
+					}
+					got, err := st.Get(ctx, b)
+					if err != nil {
+						t.Fatalf("get failed for Big: %v", err)
+					}
+
+					if diff := cmp.Diff(gotRaw, tc.wantRaw); diff != "" {
+						t.Errorf("incorrect raw data: -got +want: %s", diff)
+					}
+					if diff := cmp.Diff(got, tc.want); diff != "" {
+						t.Errorf("incorrect data: -got +want: %s", diff)
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		area        js.Value
+	}{
+		{description: "area without getKeys", area: st.NewMemArea()},
+		{description: "area with getKeys", area: st.NewQuotaMemArea(0, 0, 0)},
+	}
+
 	for _, tc := range testcases {
 		tc := tc
 		t.Run(tc.description, func(t *testing.T) {
 			t.Parallel()
 
-			if tc.maxItemBytes == 0 {
-				tc.maxItemBytes = defaultMaxItemBytes
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				b := NewBig(200, NewRaw(tc.area)).WithChunkIndex()
+				if err := b.Set(ctx, map[string]js.Value{
+					"myNumber":   js.ValueOf(2),
+					"myString":   js.ValueOf(strings.Repeat("a", 200)),
+					"yourString": js.ValueOf(strings.Repeat("b", 200)),
+				}); err != nil {
+					t.Errorf("set failed: %v", err)
+					return
+				}
+
+				got, err := b.Keys(ctx)
+				if err != nil {
+					t.Errorf("keys failed: %v", err)
+					return
+				}
+				want := []string{"myNumber", "myString", "yourString"}
+				if diff := cmp.Diff(got, want); diff != "" {
+					t.Errorf("incorrect keys: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+// incompressible returns a string of at least n characters derived from seed
+// that does not compress well, such that it is split into many chunks.
+func incompressible(seed string, n int) string {
+	var val strings.Builder
+	sum := sha256.Sum256([]byte(seed))
+	for val.Len() < n {
+		sum = sha256.Sum256(sum[:])
+		val.WriteString(base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	return val.String()
+}
+
+// BenchmarkKeys compares listing keys with reading all values, for a dozen
+// values that each span multiple chunks.
+func BenchmarkKeys(b *testing.B) {
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		s := NewBig(200, NewRaw(st.NewQuotaMemArea(0, 0, 0)))
+		data := map[string]js.Value{}
+		for i := 0; i < 12; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			data[key] = js.ValueOf(incompressible(key, 2000))
+		}
+		if err := s.Set(ctx, data); err != nil {
+			b.Errorf("set failed: %v", err)
+			return
+		}
+
+		b.Run("Keys", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Keys(ctx); err != nil {
+					b.Errorf("keys failed: %v", err)
+					return
+				}
+			}
+		})
+		b.Run("Get", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Get(ctx); err != nil {
+					b.Errorf("get failed: %v", err)
+					return
+				}
+			}
+		})
+	})
+}
+
+// benchmarkSizes are the sizes of values used in benchmarks.
+var benchmarkSizes = []struct {
+	description string
+	size        int
+}{
+	{description: "1KB", size: 1 << 10},
+	{description: "64KB", size: 64 << 10},
+	{description: "512KB", size: 512 << 10},
+}
+
+func BenchmarkSet(b *testing.B) {
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		for _, bc := range benchmarkSizes {
+			val := js.ValueOf(incompressible(bc.description, bc.size))
+			b.Run(bc.description, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					s := NewBig(defaultMaxItemBytes, NewRaw(st.NewMemArea()))
+					b.StartTimer()
+					if err := s.Set(ctx, map[string]js.Value{"key": val}); err != nil {
+						b.Errorf("set failed: %v", err)
+						return
+					}
+				}
+			})
+		}
+	})
+}
+
+func BenchmarkGet(b *testing.B) {
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		for _, bc := range benchmarkSizes {
+			s := NewBig(defaultMaxItemBytes, NewRaw(st.NewMemArea()))
+			if err := s.Set(ctx, map[string]js.Value{"key": js.ValueOf(incompressible(bc.description, bc.size))}); err != nil {
+				b.Errorf("set failed: %v", err)
+				return
 			}
+			b.Run(bc.description, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := s.GetKeys(ctx, []string{"key"}); err != nil {
+						b.Errorf("get failed: %v", err)
+						return
+					}
+				}
+			})
+		}
+	})
+}
+
+func BenchmarkDelete(b *testing.B) {
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		for _, bc := range benchmarkSizes {
+			val := js.ValueOf(incompressible(bc.description, bc.size))
+			b.Run(bc.description, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					s := NewBig(defaultMaxItemBytes, NewRaw(st.NewMemArea()))
+					if err := s.Set(ctx, map[string]js.Value{"key": val}); err != nil {
+						b.Errorf("set failed: %v", err)
+						return
+					}
+					b.StartTimer()
+					if err := s.Delete(ctx, []string{"key"}); err != nil {
+						b.Errorf("delete failed: %v", err)
+						return
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestClear(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		indexed     bool
+	}{
+		{description: "without chunk index"},
+		{description: "with chunk index", indexed: true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
 
 			jut.DoSync(func(ctx jsutil.AsyncContext) {
-				b := NewBig(tc.maxItemBytes, NewRaw(st.NewMemArea()))
-				if err := b.Set(ctx, tc.set); err != nil {
-					t.Fatalf("set failed: %v", err)
+				counter := &countingArea{Area: NewRaw(st.NewMemArea())}
+				b := NewBig(200, counter)
+				if tc.indexed {
+					b.WithChunkIndex()
+				}
+				if err := b.Set(ctx, map[string]js.Value{
+					"myNumber":   js.ValueOf(2),
+					"myString":   js.ValueOf(strings.Repeat("a", 200)),
+					"yourString": js.ValueOf(strings.Repeat("a", 200)),
+				}); err != nil {
+					t.Errorf("set failed: %v", err)
+					return
+				}
+
+				counter.writes = 0
+				if err := b.Clear(ctx); err != nil {
+					t.Errorf("clear failed: %v", err)
+					return
+				}
+				if counter.writes != 1 {
+					t.Errorf("clear performed %d writes; want 1", counter.writes)
+				}
+
+				gotRaw, err := st.EntryTypes(ctx, counter.Area)
+				if err != nil {
+					t.Errorf("get failed for underlying storage: %v", err)
+					return
+				}
+				if diff := cmp.Diff(gotRaw, map[string]string{}, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("incorrect raw data: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestClearPrefix(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		indexed     bool
+	}{
+		{description: "without chunk index"},
+		{description: "with chunk index", indexed: true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				b := NewBig(200, NewRaw(st.NewMemArea()))
+				if tc.indexed {
+					b.WithChunkIndex()
+				}
+				if err := b.Set(ctx, map[string]js.Value{
+					"a:myNumber": js.ValueOf(2),
+					"a:myString": js.ValueOf(strings.Repeat("a", 200)),
+					"a:myObject": vert.ValueOf(&myStruct{
+						IntField:    2000000,
+						StringField: strings.Repeat("a", 200),
+					}).JSValue(),
+					"b:myString": js.ValueOf(strings.Repeat("a", 200)),
+				}); err != nil {
+					t.Errorf("set failed: %v", err)
+					return
 				}
 
-				if err := b.Delete(ctx, tc.del); err != nil {
-					t.Fatalf("delete failed: %v", err)
+				if err := b.ClearPrefix(ctx, "a:"); err != nil {
+					t.Errorf("clear failed: %v", err)
+					return
 				}
 
-				gotRaw, err := getEntryType(ctx, b.s)
+				gotRaw, err := st.EntryTypes(ctx, b.s)
 				if err != nil {
-					t.Fatalf("get failed for underlying storage: %v", err)
+					t.Errorf("get failed for underlying storage: %v", err)
+					return
 				}
-				got, err := getJSON(ctx, b)
+				delete(gotRaw, chunkIndexKey)
+				got, err := st.Get(ctx, b)
 				if err != nil {
-					t.Fatalf("get failed for Big: %v", err)
+					t.Errorf("get failed for Big: %v", err)
+					return
 				}
 
-				if diff := cmp.Diff(gotRaw, tc.wantRaw); diff != "" {
+				// Chunks shared with the remaining value are retained.
+				wantRaw := map[string]string{
+					"chunk-3cc36853-b864-4122-beaa-516aa24448f6:Fru0sIiU1np0QdrjNzVcQQnL4/go9+Bhsa0jum0KFbU=": "chunk",
+					"chunk-3cc36853-b864-4122-beaa-516aa24448f6:G6T7G7fdARNR9OSgrLFctjhsP2mKdz4GS9bvK8F21ek=": "chunk",
+					"chunk-3cc36853-b864-4122-beaa-516aa24448f6:lHZRIv7UAumQRGrzQCQplvRz6iS71g6jnTlZwEhQQcs=": "chunk",
+					"b:myString": "manifest",
+				}
+				if diff := cmp.Diff(gotRaw, wantRaw); diff != "" {
 					t.Errorf("incorrect raw data: -got +want: %s", diff)
 				}
-				if diff := cmp.Diff(got, tc.want); diff != "" {
+				want := map[string]string{
+					"b:myString": fmt.Sprintf(`"%s"`, strings.Repeat("a", 200)),
+				}
+				if diff := cmp.Diff(got, want); diff != "" {
 					t.Errorf("incorrect data: -got +want: %s", diff)
 				}
 			})
 		})
 	}
 }
+
+func TestChunkKeysDeterministic(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		data := map[string]js.Value{
+			"myString": js.ValueOf(incompressible("my", 500)),
+		}
+
+		// Separate instances, writing to separate areas, store
+		// identical layouts.
+		var layouts []map[string]string
+		for i := 0; i < 2; i++ {
+			raw := NewRaw(st.NewMemArea())
+			if err := NewBig(200, raw).Set(ctx, data); err != nil {
+				t.Errorf("set failed: %v", err)
+				return
+			}
+			stored, err := raw.Get(ctx)
+			if err != nil {
+				t.Errorf("get failed for underlying storage: %v", err)
+				return
+			}
+			layouts = append(layouts, dataToJSON(stored))
+
+			// Each chunk is stored at the key derived from its
+			// contents.
+			for k, v := range stored {
+				if isChunkKey(k) && k != makeChunkKey(v.String()) {
+					t.Errorf("chunk stored at %s; want %s", k, makeChunkKey(v.String()))
+				}
+			}
+		}
+		if diff := cmp.Diff(layouts[0], layouts[1]); diff != "" {
+			t.Errorf("layouts differ between instances: -first +second: %s", diff)
+		}
+	})
+}
+
+func TestGetKeys(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		set         map[string]js.Value
+		keys        []string
+		want        map[string]string
+	}{
+		{
+			description: "Simple and big values",
+			set: map[string]js.Value{
+				"myNumber": js.ValueOf(2),
+				"myString": js.ValueOf(strings.Repeat("a", 200)),
+				"myOther":  js.ValueOf("foo"),
+			},
+			keys: []string{"myNumber", "myString", "missing"},
+			want: map[string]string{
+				"myNumber": "2",
+				"myString": fmt.Sprintf(`"%s"`, strings.Repeat("a", 200)),
+			},
+		},
+		{
+			description: "Chunk keys are never returned",
+			set: map[string]js.Value{
+				"myString": js.ValueOf(strings.Repeat("a", 200)),
+			},
+			keys: []string{
+				"chunk-3cc36853-b864-4122-beaa-516aa24448f6:Fru0sIiU1np0QdrjNzVcQQnL4/go9+Bhsa0jum0KFbU=",
+			},
+			want: map[string]string{},
+		},
+	}
+
+	for _, tc := range testcases {
+		for _, mode := range areaModes {
+			tc, mode := tc, mode
+			t.Run(tc.description+"/"+mode.description, func(t *testing.T) {
+				t.Parallel()
+
+				jut.DoSync(func(ctx jsutil.AsyncContext) {
+					b := NewBig(200, NewRaw(mode.newArea()))
+					if err := b.Set(ctx, tc.set); err != nil {
+						t.Fatalf("set failed: %v", err)
+					}
+
+					got, err := b.GetKeys(ctx, tc.keys)
+					if err != nil {
+						t.Fatalf("GetKeys failed: %v", err)
+					}
+					if diff := cmp.Diff(dataToJSON(got), tc.want); diff != "" {
+						t.Errorf("incorrect data: -got +want: %s", diff)
+					}
+				})
+			})
+		}
+	}
+}
+
+// randomRunes are the runes from which random strings are built. They include
+// multibyte characters, and characters that Chrome escapes when serializing.
+var randomRunes = []rune("abcXYZ019 <>&\"\\\x7f\u00e9\u2028\u2029\u4e16\U0001f600")
+
+// randomString returns a random string of up to n runes.
+func randomString(r *rand.Rand, n int) string {
+	var sb strings.Builder
+	for i := r.Intn(n + 1); i > 0; i-- {
+		sb.WriteRune(randomRunes[r.Intn(len(randomRunes))])
+	}
+	return sb.String()
+}
+
+// randomValue returns a random value that can be converted with js.ValueOf,
+// nesting objects and arrays up to the specified depth. Strings are of up to
+// n runes.
+func randomValue(r *rand.Rand, depth, n int) interface{} {
+	kind := r.Intn(6)
+	if depth == 0 {
+		kind = r.Intn(4)
+	}
+	switch kind {
+	case 0:
+		return randomString(r, n)
+	case 1:
+		return r.Float64() * 1e6
+	case 2:
+		return r.Intn(2) == 0
+	case 3:
+		return nil
+	case 4:
+		obj := map[string]interface{}{}
+		for i := r.Intn(4); i > 0; i-- {
+			obj[randomString(r, 8)] = randomValue(r, depth-1, n)
+		}
+		return obj
+	default:
+		var arr []interface{}
+		for i := r.Intn(4); i > 0; i-- {
+			arr = append(arr, randomValue(r, depth-1, n))
+		}
+		return arr
+	}
+}
+
+func TestRoundTripProperty(t *testing.T) {
+	t.Parallel()
+
+	const maxItemBytes = 200
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		roundTrips := func(key string, val js.Value) bool {
+			raw := NewRaw(st.NewMemArea())
+			b := NewBig(maxItemBytes, raw)
+			if err := b.Set(ctx, map[string]js.Value{key: val}); err != nil {
+				t.Logf("set failed: %v", err)
+				return false
+			}
+
+			// Set-then-Get returns an identical value.
+			got, err := b.GetKeys(ctx, []string{key})
+			if err != nil {
+				t.Logf("get failed: %v", err)
+				return false
+			}
+			if jsutil.ToJSON(got[key]) != jsutil.ToJSON(val) {
+				t.Logf("incorrect value for %q: got %s, want %s", key, jsutil.ToJSON(got[key]), jsutil.ToJSON(val))
+				return false
+			}
+
+			// No chunk or simple value in the underlying storage
+			// exceeds the limit. Manifests grow with the number of
+			// chunks, and are not considered here; at the limits of
+			// Chrome's storage areas, they fit for any value within
+			// the overall quota.
+			stored, err := raw.Get(ctx)
+			if err != nil {
+				t.Logf("get failed for underlying storage: %v", err)
+				return false
+			}
+			for k, v := range stored {
+				if st.IsManifest(v) {
+					continue
+				}
+				if size := storedSize(k, jsutil.ToJSON(v)); size > maxItemBytes {
+					t.Logf("item %s is %d bytes; limit %d", k, size, maxItemBytes)
+					return false
+				}
+			}
+			return true
+		}
+
+		config := &quick.Config{
+			MaxCount: 200,
+			Values: func(args []reflect.Value, r *rand.Rand) {
+				// Straddle the limit, such that values are both
+				// stored directly and split into chunks.
+				args[0] = reflect.ValueOf(randomString(r, 20))
+				args[1] = reflect.ValueOf(js.ValueOf(randomValue(r, 3, 2*maxItemBytes)))
+			},
+		}
+		if err := quick.Check(roundTrips, config); err != nil {
+			t.Errorf("round trip failed: %v", err)
+		}
+	})
+}
+
+func FuzzReassemble(f *testing.F) {
+	validManifest, _ := json.Marshal(map[string]interface{}{
+		"magic":     bigValueManifestMagic,
+		"version":   bigValueManifestVersion,
+		"chunkKeys": []string{makeChunkKey("Zm9v")},
+	})
+	f.Add(string(validManifest), "Zm9v")
+	f.Add(`{"magic":"`+bigValueManifestMagic+`","chunkKeys":"abc"}`, "Zm9v")
+	f.Add(`{"magic":"`+bigValueManifestMagic+`","chunkKeys":[1,{"a":2},null]}`, `{}`)
+	f.Add(`{"magic":"`+bigValueManifestMagic+`","version":"9","encoding":5}`, `null`)
+	f.Add(`{"magic":"`+bigValueManifestMagic+`","encoding":"gzip","chunkKeys":[]}`, `[]`)
+	f.Add(`{"magic":5}`, `"!!"`)
+	f.Add(`{`, `{`)
+
+	f.Fuzz(func(t *testing.T, manifestJSON, chunkJSON string) {
+		// Values may be arbitrary, such as those synced from a hostile
+		// peer. We must never panic when reading them.
+		data := map[string]js.Value{"key": jsutil.FromJSON(manifestJSON)}
+		chunks := map[string]js.Value{}
+		if manifest, ok := readManifest(data["key"]); ok {
+			for _, chunkKey := range manifest.ChunkKeys {
+				chunks[chunkKey] = jsutil.FromJSON(chunkJSON)
+			}
+		}
+		reassemble(data, chunks)
+	})
+}
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		b := NewBig(defaultMaxItemBytes, NewRaw(st.NewMemArea()))
+		val := strings.Repeat("a", 3000)
+
+		// Ensure the value would require 5 chunks if not compressed.
+		maxDecodedChunkSize := base64.StdEncoding.DecodedLen(b.maxChunkSize())
+		json := jsutil.ToJSON(js.ValueOf(val))
+		if n := (len(json) + maxDecodedChunkSize - 1) / maxDecodedChunkSize; n != 5 {
+			t.Fatalf("test value requires %d chunks uncompressed; want 5", n)
+		}
+
+		if err := b.Set(ctx, map[string]js.Value{"myString": js.ValueOf(val)}); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+
+		gotRaw, err := st.EntryTypes(ctx, b.s)
+		if err != nil {
+			t.Fatalf("get failed for underlying storage: %v", err)
+		}
+		var chunks int
+		for _, typ := range gotRaw {
+			if typ == "chunk" {
+				chunks++
+			}
+		}
+		if chunks != 1 {
+			t.Errorf("incorrect number of chunks; got %d, want 1", chunks)
+		}
+
+		got, err := st.Get(ctx, b)
+		if err != nil {
+			t.Fatalf("get failed for Big: %v", err)
+		}
+		if diff := cmp.Diff(got, map[string]string{"myString": json}); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestItemSizeBoundary(t *testing.T) {
+	t.Parallel()
+
+	const (
+		// maxItemBytes must leave room for a manifest referencing
+		// multiple chunks.
+		maxItemBytes = 512
+		key          = "myString"
+	)
+
+	testcases := []struct {
+		description string
+		char        string
+		// charBytes is the number of bytes the character occupies
+		// when serialized by Chrome.
+		charBytes int
+	}{
+		{description: "ASCII", char: "a", charBytes: 1},
+		{description: "quotes", char: `"`, charBytes: 2},
+		{description: "backslashes", char: `\`, charBytes: 2},
+		{description: "2-byte UTF-8", char: "é", charBytes: 2},
+		{description: "3-byte UTF-8", char: "日", charBytes: 3},
+		{description: "4-byte UTF-8", char: "😀", charBytes: 4},
+		{description: "escaped by Chrome only", char: "<", charBytes: 6},
+		{description: "line separator", char: "\u2028", charBytes: 6},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			// Construct a value that, along with the key and
+			// surrounding quotes, occupies exactly maxItemBytes.
+			avail := maxItemBytes - len(key) - 2
+			exact := strings.Repeat(tc.char, avail/tc.charBytes) + strings.Repeat("a", avail%tc.charBytes)
+
+			for _, sc := range []struct {
+				val     string
+				wantRaw string
+			}{
+				{val: exact, wantRaw: "simple"},
+				{val: exact + "a", wantRaw: "manifest"},
+			} {
+				jut.DoSync(func(ctx jsutil.AsyncContext) {
+					b := NewBig(maxItemBytes, NewRaw(st.NewQuotaMemArea(0, maxItemBytes, 0)))
+					if err := b.Set(ctx, map[string]js.Value{key: js.ValueOf(sc.val)}); err != nil {
+						t.Errorf("set failed for value of %d characters: %v", len([]rune(sc.val)), err)
+						return
+					}
+
+					gotRaw, err := st.EntryTypes(ctx, b.s)
+					if err != nil {
+						t.Errorf("get failed for underlying storage: %v", err)
+						return
+					}
+					if gotRaw[key] != sc.wantRaw {
+						t.Errorf("incorrect raw type for value of %d characters; got %s, want %s", len([]rune(sc.val)), gotRaw[key], sc.wantRaw)
+					}
+
+					got, err := st.Get(ctx, b)
+					if err != nil {
+						t.Errorf("get failed for Big: %v", err)
+						return
+					}
+					want := map[string]string{key: jsutil.ToJSON(js.ValueOf(sc.val))}
+					if diff := cmp.Diff(got, want); diff != "" {
+						t.Errorf("incorrect data: -got +want: %s", diff)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestSetUnchanged(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		indexed     bool
+	}{
+		{description: "without chunk index"},
+		{description: "with chunk index", indexed: true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				counter := &countingArea{Area: NewRaw(st.NewMemArea())}
+				b := NewBig(200, counter)
+				if tc.indexed {
+					b.WithChunkIndex()
+				}
+				data := map[string]js.Value{
+					"myString": js.ValueOf(strings.Repeat("a", 200)),
+					"myNumber": js.ValueOf(2),
+				}
+				if err := b.Set(ctx, data); err != nil {
+					t.Errorf("set failed: %v", err)
+					return
+				}
+
+				// Re-saving identical data performs no writes.
+				counter.writes = 0
+				if err := b.Set(ctx, data); err != nil {
+					t.Errorf("set failed: %v", err)
+					return
+				}
+				if counter.writes != 0 {
+					t.Errorf("re-saving unchanged data performed %d writes; want 0", counter.writes)
+				}
+
+				// An identical value at another key shares the
+				// existing chunks.
+				if err := b.Set(ctx, map[string]js.Value{"yourString": js.ValueOf(strings.Repeat("a", 200))}); err != nil {
+					t.Errorf("set failed: %v", err)
+					return
+				}
+				gotRaw, err := st.EntryTypes(ctx, counter.Area)
+				if err != nil {
+					t.Errorf("get failed for underlying storage: %v", err)
+					return
+				}
+				var chunks int
+				for k, typ := range gotRaw {
+					if typ == "chunk" && k != chunkIndexKey {
+						chunks++
+					}
+				}
+				if chunks != 3 {
+					t.Errorf("incorrect number of chunks; got %d, want 3", chunks)
+				}
+			})
+		})
+	}
+}
+
+func TestReadManifestWithoutEncoding(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		b := NewBig(defaultMaxItemBytes, raw)
+
+		// Write data in the format used before compression was supported.
+		chunk := base64.StdEncoding.EncodeToString([]byte(`"foo"`))
+		chunkKey := makeChunkKey(chunk)
+		manifest := js.ValueOf(map[string]any{
+			"magic":     bigValueManifestMagic,
+			"chunkKeys": []any{chunkKey},
+		})
+		if err := raw.Set(ctx, map[string]js.Value{
+			chunkKey:   js.ValueOf(chunk),
+			"myString": manifest,
+		}); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+
+		got, err := st.Get(ctx, b)
+		if err != nil {
+			t.Fatalf("get failed for Big: %v", err)
+		}
+		if diff := cmp.Diff(got, map[string]string{"myString": `"foo"`}); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestUpgradeManifest(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		b := NewBig(defaultMaxItemBytes, raw)
+
+		// Write a manifest in the format used before versioning.
+		chunk := base64.StdEncoding.EncodeToString([]byte(`"foo"`))
+		chunkKey := makeChunkKey(chunk)
+		if err := raw.Set(ctx, map[string]js.Value{
+			chunkKey: js.ValueOf(chunk),
+			"myString": js.ValueOf(map[string]any{
+				"magic":     bigValueManifestMagic,
+				"chunkKeys": []any{chunkKey},
+				"encoding":  "",
+			}),
+		}); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+
+		got, err := st.Get(ctx, b)
+		if err != nil {
+			t.Fatalf("get failed for Big: %v", err)
+		}
+		if diff := cmp.Diff(got, map[string]string{"myString": `"foo"`}); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+
+		// Reading upgraded the manifest in place.
+		gotRaw, err := st.Get(ctx, raw)
+		if err != nil {
+			t.Fatalf("get failed for underlying storage: %v", err)
+		}
+		wantRaw := map[string]string{
+			chunkKey:   fmt.Sprintf(`"%s"`, chunk),
+			"myString": fmt.Sprintf(`{"magic":"%s","chunkKeys":["%s"],"encoding":"","version":1}`, bigValueManifestMagic, chunkKey),
+		}
+		if diff := cmp.Diff(gotRaw, wantRaw); diff != "" {
+			t.Errorf("incorrect raw data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestManifestTooNew(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		b := NewBig(defaultMaxItemBytes, raw)
+
+		// Write a manifest as a future version might.
+		chunk := base64.StdEncoding.EncodeToString([]byte("future format"))
+		chunkKey := makeChunkKey(chunk)
+		if err := raw.Set(ctx, map[string]js.Value{
+			chunkKey: js.ValueOf(chunk),
+			"myString": js.ValueOf(map[string]any{
+				"magic":     bigValueManifestMagic,
+				"chunkKeys": []any{chunkKey},
+				"encoding":  "future",
+				"version":   bigValueManifestVersion + 1,
+			}),
+			"myNumber": js.ValueOf(2),
+		}); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+
+		if _, err := b.GetKeys(ctx, []string{"myString"}); !errors.Is(err, ErrManifestTooNew) {
+			t.Errorf("incorrect error from GetKeys; got %v, want %v", err, ErrManifestTooNew)
+		}
+
+		// Chunks referenced by the manifest are retained when cleaning
+		// up after other keys.
+		if err := b.Delete(ctx, []string{"myNumber"}); err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+		gotRaw, err := st.EntryTypes(ctx, raw)
+		if err != nil {
+			t.Fatalf("get failed for underlying storage: %v", err)
+		}
+		wantRaw := map[string]string{
+			chunkKey:   "chunk",
+			"myString": "manifest",
+		}
+		if diff := cmp.Diff(gotRaw, wantRaw); diff != "" {
+			t.Errorf("incorrect raw data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestUsage(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewQuotaMemArea(0, 0, 0))
+		b := NewBig(200, raw)
+		if err := b.Set(ctx, map[string]js.Value{
+			"myNumber": js.ValueOf(2),
+			"myString": js.ValueOf(strings.Repeat("a", 200)),
+		}); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+
+		usage, err := b.Usage(ctx)
+		if err != nil {
+			t.Fatalf("Usage failed: %v", err)
+		}
+		if got, want := usage["myNumber"], len("myNumber")+len("2"); got != want {
+			t.Errorf("incorrect usage for simple value; got %d, want %d", got, want)
+		}
+
+		// All bytes are attributed to a logical key.
+		data, err := raw.Get(ctx)
+		if err != nil {
+			t.Fatalf("get failed for underlying storage: %v", err)
+		}
+		var allKeys []string
+		for k := range data {
+			allKeys = append(allKeys, k)
+		}
+		total, err := raw.BytesInUse(ctx, allKeys)
+		if err != nil {
+			t.Fatalf("BytesInUse failed: %v", err)
+		}
+		if got := usage["myNumber"] + usage["myString"]; got != total {
+			t.Errorf("incorrect total usage; got %d, want %d", got, total)
+		}
+
+		// BytesInUse on Big includes chunks.
+		big, err := b.BytesInUse(ctx, []string{"myString"})
+		if err != nil {
+			t.Fatalf("BytesInUse failed: %v", err)
+		}
+		if big != usage["myString"] {
+			t.Errorf("incorrect bytes in use; got %d, want %d", big, usage["myString"])
+		}
+	})
+}
+
+func TestUsageSharedChunks(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		b := NewBig(200, NewRaw(st.NewQuotaMemArea(0, 0, 0)))
+		if err := b.Set(ctx, map[string]js.Value{
+			"myString":   js.ValueOf(strings.Repeat("a", 200)),
+			"yourString": js.ValueOf(strings.Repeat("a", 200)),
+		}); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+
+		usage, err := b.Usage(ctx)
+		if err != nil {
+			t.Fatalf("Usage failed: %v", err)
+		}
+		// Manifest keys differ in length by 2 bytes; the shared chunks
+		// are split equally.
+		if got, want := usage["yourString"]-usage["myString"], 2; got != want {
+			t.Errorf("incorrect difference in usage; got %d, want %d", got, want)
+		}
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		b := NewBig(400, raw)
+		set := map[string]js.Value{
+			"myNumber": js.ValueOf(2),
+			"myString": js.ValueOf(strings.Repeat("a", 600)),
+			"myOther":  js.ValueOf(strings.Repeat("b", 150)),
+		}
+		if err := b.Set(ctx, set); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+
+		// Shrink the maximum size, and migrate twice to verify it is
+		// idempotent.
+		const newMaxItemBytes = 200
+		for i := 0; i < 2; i++ {
+			if err := b.Migrate(ctx, newMaxItemBytes); err != nil {
+				t.Fatalf("migrate failed: %v", err)
+			}
+		}
+
+		// All chunks must fit within the new limit.
+		data, err := raw.Get(ctx)
+		if err != nil {
+			t.Fatalf("get failed for underlying storage: %v", err)
+		}
+		for k, v := range data {
+			if !isChunkKey(k) {
+				continue
+			}
+			if n := len(k) + len(jsutil.ToJSON(v)); n > newMaxItemBytes {
+				t.Errorf("item %s has size %d; exceeds %d: %s", k, n, newMaxItemBytes, jsutil.ToJSON(v))
+			}
+		}
+
+		// Data must be unchanged.
+		got, err := st.Get(ctx, b)
+		if err != nil {
+			t.Fatalf("get failed for Big: %v", err)
+		}
+		if diff := cmp.Diff(got, dataToJSON(set)); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+
+		// Items must match those written by a fresh Set at the new size.
+		fresh := NewBig(newMaxItemBytes, NewRaw(st.NewMemArea()))
+		if err := fresh.Set(ctx, set); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		gotRaw, err := st.EntryTypes(ctx, raw)
+		if err != nil {
+			t.Fatalf("get failed for underlying storage: %v", err)
+		}
+		wantRaw, err := st.EntryTypes(ctx, fresh.s)
+		if err != nil {
+			t.Fatalf("get failed for underlying storage: %v", err)
+		}
+		if diff := cmp.Diff(gotRaw, wantRaw); diff != "" {
+			t.Errorf("incorrect raw data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestMigrateConcurrentSet(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		b := NewBig(400, raw)
+
+		// Chunks written by Sets that overlap with the migration must
+		// use either the previous limit, such that the migration
+		// rewrites them, or the new limit.
+		const newMaxItemBytes = 200
+		set := map[string]js.Value{}
+		var promises []*jsutil.Promise
+		for i := 0; i < 8; i++ {
+			key := fmt.Sprintf("key%d", i)
+			val := js.ValueOf(strings.Repeat(string(rune('a'+i)), 600))
+			set[key] = val
+			delay := time.Duration(i) * time.Millisecond
+			promises = append(promises, jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+				time.Sleep(delay)
+				return js.Undefined(), b.Set(ctx, map[string]js.Value{key: val})
+			}))
+			if i == 4 {
+				promises = append(promises, jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+					return js.Undefined(), b.Migrate(ctx, newMaxItemBytes)
+				}))
+			}
+		}
+		for _, p := range promises {
+			if _, err := p.Await(ctx); err != nil {
+				t.Errorf("operation failed: %v", err)
+			}
+		}
+
+		data, err := raw.Get(ctx)
+		if err != nil {
+			t.Fatalf("get failed for underlying storage: %v", err)
+		}
+		for k, v := range data {
+			if !isChunkKey(k) {
+				continue
+			}
+			if n := storedSize(k, jsutil.ToJSON(v)); n > newMaxItemBytes {
+				t.Errorf("item %s has size %d; exceeds %d", k, n, newMaxItemBytes)
+			}
+		}
+
+		got, err := st.Get(ctx, b)
+		if err != nil {
+			t.Fatalf("get failed for Big: %v", err)
+		}
+		if diff := cmp.Diff(got, dataToJSON(set)); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+	})
+}
+
+type watchEvent struct {
+	changed map[string]string
+	removed []string
+}
+
+// watchEvents returns a channel on which all changes observed are sent.
+func watchEvents(s Area) (<-chan watchEvent, jsutil.CleanupFunc) {
+	events := make(chan watchEvent, 10)
+	cleanup := s.Watch(func(changed map[string]js.Value, removed []string) {
+		events <- watchEvent{changed: dataToJSON(changed), removed: removed}
+	})
+	return events, cleanup
+}
+
+// nextEvent waits for the next event, returning nil if none arrives.
+func nextEvent(events <-chan watchEvent) *watchEvent {
+	select {
+	case ev := <-events:
+		return &ev
+	case <-time.After(200 * time.Millisecond):
+		return nil
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		b := NewBig(200, NewRaw(st.NewQuotaMemArea(0, 0, 0)))
+		events, cleanup := watchEvents(b)
+		defer cleanup()
+
+		if err := b.Set(ctx, map[string]js.Value{
+			"myString": js.ValueOf(strings.Repeat("a", 200)),
+		}); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		want := &watchEvent{
+			changed: map[string]string{
+				"myString": fmt.Sprintf(`"%s"`, strings.Repeat("a", 200)),
+			},
+		}
+		if diff := cmp.Diff(nextEvent(events), want, cmp.AllowUnexported(watchEvent{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect event after set: -got +want: %s", diff)
+		}
+
+		if err := b.Delete(ctx, []string{"myString"}); err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+		want = &watchEvent{
+			removed: []string{"myString"},
+		}
+		if diff := cmp.Diff(nextEvent(events), want, cmp.AllowUnexported(watchEvent{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect event after delete: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestWatchManifestBeforeChunks(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewQuotaMemArea(0, 0, 0))
+		b := NewBig(defaultMaxItemBytes, raw)
+		events, cleanup := watchEvents(b)
+		defer cleanup()
+
+		chunk := base64.StdEncoding.EncodeToString([]byte(`"foo"`))
+		chunkKey := makeChunkKey(chunk)
+		manifest := newBigValueManifest()
+		manifest.ChunkKeys = []string{chunkKey}
+
+		// Manifest arrives first; no notification should occur.
+		if err := raw.Set(ctx, map[string]js.Value{
+			"myString": vert.ValueOf(manifest).JSValue(),
+		}); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		if ev := nextEvent(events); ev != nil {
+			t.Errorf("unexpected event before chunks arrived: %+v", ev)
+		}
+
+		// Chunk arrives; value is now complete.
+		if err := raw.Set(ctx, map[string]js.Value{
+			chunkKey: js.ValueOf(chunk),
+		}); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		want := &watchEvent{
+			changed: map[string]string{"myString": `"foo"`},
+		}
+		if diff := cmp.Diff(nextEvent(events), want, cmp.AllowUnexported(watchEvent{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect event after chunk arrived: -got +want: %s", diff)
+		}
+	})
+}
+
+// failingArea wraps an Area, failing calls to Set when failSet returns true.
+type failingArea struct {
+	Area
+	failSet func(data map[string]js.Value) bool
+}
+
+var errInjected = errors.New("injected failure")
+
+func (f *failingArea) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	if f.failSet != nil && f.failSet(data) {
+		return errInjected
+	}
+	return f.Area.Set(ctx, data)
+}
+
+func TestSetFailureRemovesChunks(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		b := NewBig(200, raw)
+		if err := b.Set(ctx, map[string]js.Value{
+			"myString": js.ValueOf(strings.Repeat("a", 200)),
+		}); err != nil {
+			t.Fatalf("initial set failed: %v", err)
+		}
+
+		// Fail any write that isn't exclusively chunks.
+		b.s = &failingArea{
+			Area: raw,
+			failSet: func(data map[string]js.Value) bool {
+				for k := range data {
+					if !isChunkKey(k) {
+						return true
+					}
+				}
+				return false
+			},
+		}
+		err := b.Set(ctx, map[string]js.Value{
+			"yourString": js.ValueOf(strings.Repeat("b", 200)),
+		})
+		if !errors.Is(err, errInjected) {
+			t.Fatalf("incorrect error from set; got %v, want %v", err, errInjected)
+		}
+
+		gotRaw, err := st.EntryTypes(ctx, raw)
+		if err != nil {
+			t.Fatalf("get failed for underlying storage: %v", err)
+		}
+		wantRaw := map[string]string{
+			"chunk-3cc36853-b864-4122-beaa-516aa24448f6:Fru0sIiU1np0QdrjNzVcQQnL4/go9+Bhsa0jum0KFbU=": "chunk",
+			"chunk-3cc36853-b864-4122-beaa-516aa24448f6:G6T7G7fdARNR9OSgrLFctjhsP2mKdz4GS9bvK8F21ek=": "chunk",
+			"chunk-3cc36853-b864-4122-beaa-516aa24448f6:lHZRIv7UAumQRGrzQCQplvRz6iS71g6jnTlZwEhQQcs=": "chunk",
+			"myString": "manifest",
+		}
+		if diff := cmp.Diff(gotRaw, wantRaw); diff != "" {
+			t.Errorf("incorrect raw data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestSetFailureMidWrite(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description       string
+		quotaBytesPerItem int
+		failNthWrite      int
+		wantWrites        []st.Op
+	}{
+		{
+			description:  "chunk write fails",
+			failNthWrite: 1,
+			wantWrites: []st.Op{
+				{Method: "set", Keys: []string{"<chunks>"}, Failed: true},
+			},
+		},
+		{
+			description:  "manifest write fails",
+			failNthWrite: 2,
+			wantWrites: []st.Op{
+				{Method: "set", Keys: []string{"<chunks>"}},
+				{Method: "set", Keys: []string{"myString"}, Failed: true},
+				{Method: "remove", Keys: []string{"<chunks>"}},
+			},
+		},
+		{
+			description:       "per-item quota exceeded",
+			quotaBytesPerItem: 100,
+			wantWrites: []st.Op{
+				{Method: "set", Keys: []string{"<chunks>"}, Failed: true},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				area := st.NewQuotaMemArea(0, tc.quotaBytesPerItem, 0)
+				raw := newRawNoRetry(area)
+				b := NewBig(200, raw)
+				if tc.failNthWrite > 0 {
+					st.FailNthWrite(area, tc.failNthWrite, "QUOTA_BYTES quota exceeded")
+				}
+
+				err := b.Set(ctx, map[string]js.Value{
+					"myString": js.ValueOf(incompressible("my", 500)),
+				})
+				if !errors.Is(err, ErrQuotaExceeded) {
+					t.Errorf("incorrect error from set; got %v, want %v", err, ErrQuotaExceeded)
+				}
+
+				// No manifest may be left referencing chunks that
+				// were not written, and no chunks may be left behind.
+				gotRaw, err := st.EntryTypes(ctx, raw)
+				if err != nil {
+					t.Errorf("get failed for underlying storage: %v", err)
+					return
+				}
+				if diff := cmp.Diff(gotRaw, map[string]string{}, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("incorrect raw data: -got +want: %s", diff)
+				}
+
+				// Writes happen in the expected order. Chunk keys are
+				// summarized, since they depend on the content.
+				var gotWrites []st.Op
+				for _, op := range st.Ops(area) {
+					if op.Method != "set" && op.Method != "remove" {
+						continue
+					}
+					if len(op.Keys) > 0 && isChunkKey(op.Keys[0]) {
+						op.Keys = []string{"<chunks>"}
+					}
+					gotWrites = append(gotWrites, op)
+				}
+				if diff := cmp.Diff(gotWrites, tc.wantWrites); diff != "" {
+					t.Errorf("incorrect writes: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestGetBrokenChunks(t *testing.T) {
+	t.Parallel()
+
+	const brokenChunkKey = "chunk-3cc36853-b864-4122-beaa-516aa24448f6:G6T7G7fdARNR9OSgrLFctjhsP2mKdz4GS9bvK8F21ek="
+
+	testcases := []struct {
+		description string
+		breakChunk  func(ctx jsutil.AsyncContext, raw Area) error
+		wantErr     error
+	}{
+		{
+			description: "manifest references deleted chunk",
+			breakChunk: func(ctx jsutil.AsyncContext, raw Area) error {
+				return raw.Delete(ctx, []string{brokenChunkKey})
+			},
+			wantErr: ErrChunkMissing,
+		},
+		{
+			description: "chunk content tampered with",
+			breakChunk: func(ctx jsutil.AsyncContext, raw Area) error {
+				return raw.Set(ctx, map[string]js.Value{
+					brokenChunkKey: js.ValueOf("dGFtcGVyZWQ="),
+				})
+			},
+			wantErr: ErrChunkCorrupt,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				raw := NewRaw(st.NewMemArea())
+				b := NewBig(200, raw)
+				if err := b.Set(ctx, map[string]js.Value{
+					"myString": js.ValueOf(strings.Repeat("a", 200)),
+				}); err != nil {
+					t.Fatalf("set failed: %v", err)
+				}
+
+				if err := tc.breakChunk(ctx, raw); err != nil {
+					t.Fatalf("failed to break chunk: %v", err)
+				}
+
+				_, err := b.Get(ctx)
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("incorrect error from get; got %v, want %v", err, tc.wantErr)
+				}
+				var cerr *ChunkError
+				if !errors.As(err, &cerr) {
+					t.Fatalf("error is not a ChunkError: %v", err)
+				}
+				if cerr.Key != "myString" || cerr.ChunkKey != brokenChunkKey {
+					t.Errorf("incorrect error details; got key=%s chunk=%s", cerr.Key, cerr.ChunkKey)
+				}
+			})
+		})
+	}
+}
+
+// recordingArea wraps an Area, recording the last write to each top-level
+// (i.e., non-chunk) key. A deleted key is recorded as an empty string. Writes
+// of chunks are delayed, widening the window in which concurrent operations
+// may interfere.
+type recordingArea struct {
+	Area
+	mu   sync.Mutex
+	last map[string]string
+}
+
+func (r *recordingArea) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	if err := r.Area.Set(ctx, data); err != nil {
+		return err
+	}
+	for k := range data {
+		if isChunkKey(k) {
+			time.Sleep(5 * time.Millisecond)
+			break
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, v := range data {
+		if !isChunkKey(k) {
+			r.last[k] = jsutil.ToJSON(v)
+		}
+	}
+	return nil
+}
+
+func (r *recordingArea) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	if err := r.Area.Delete(ctx, keys); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range keys {
+		if !isChunkKey(k) {
+			r.last[k] = ""
+		}
+	}
+	return nil
+}
+
+func TestConcurrentSetAndDelete(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range areaModes {
+		mode := mode
+		t.Run(mode.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				raw := NewRaw(mode.newArea())
+				rec := &recordingArea{Area: raw, last: map[string]string{}}
+				b := NewBig(200, rec)
+
+				keys := []string{"key0", "key1", "key2", "key3"}
+				const rounds = 10
+				const opsPerRound = 16
+
+				// manifests maps the stored form of each value to the value
+				// itself, allowing us to identify the value that was last
+				// written to each key.
+				manifests := map[string]string{"": ""}
+				for round := 0; round < rounds; round++ {
+					var promises []*jsutil.Promise
+					for i := 0; i < opsPerRound; i++ {
+						key := keys[i%len(keys)]
+						if i%3 == 0 {
+							// Stagger deletions so that they overlap with
+							// in-progress writes.
+							delay := time.Duration(i) * time.Millisecond
+							promises = append(promises, jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+								time.Sleep(delay)
+								return js.Undefined(), b.Delete(ctx, []string{key})
+							}))
+							continue
+						}
+
+						val := js.ValueOf(strings.Repeat(string(rune('a'+i)), 300+round))
+						_, values := b.split(map[string]js.Value{key: val})
+						manifests[jsutil.ToJSON(values[key])] = jsutil.ToJSON(val)
+						promises = append(promises, jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+							return js.Undefined(), b.Set(ctx, map[string]js.Value{key: val})
+						}))
+					}
+					for _, p := range promises {
+						if _, err := p.Await(ctx); err != nil {
+							t.Errorf("operation failed: %v", err)
+						}
+					}
+
+					// Each value must be fully readable, and match the last
+					// write.
+					got, err := st.Get(ctx, b)
+					if err != nil {
+						t.Errorf("Get failed after round %d: %v", round, err)
+						continue
+					}
+					want := map[string]string{}
+					for k, m := range rec.last {
+						if v := manifests[m]; v != "" {
+							want[k] = v
+						}
+					}
+					if diff := cmp.Diff(got, want); diff != "" {
+						t.Errorf("incorrect data after round %d: -got +want: %s", round, diff)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,122 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// Cancellable binds operations on an underlying storage area to a
+// context.Context. Once the context is cancelled or its deadline passes,
+// in-progress operations return the context's error immediately, and new
+// operations fail without being started.
+//
+// The underlying Storage API calls cannot be interrupted. An abandoned write
+// may therefore still complete after its operation has returned an error.
+//
+// Cancellable implements the Area interface.
+type Cancellable struct {
+	ctx context.Context
+	s   Area
+}
+
+// WithContext returns a Cancellable binding operations on the storage area
+// to the supplied context. This is typically used to abandon operations when
+// the page that started them is closing, or to apply a timeout.
+func WithContext(ctx context.Context, store Area) *Cancellable {
+	return &Cancellable{
+		ctx: ctx,
+		s:   store,
+	}
+}
+
+// run executes f in the background, and returns its result unless the
+// context is done first.
+func run[T any](actx jsutil.AsyncContext, ctx context.Context, f func(actx jsutil.AsyncContext) (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	type result struct {
+		val T
+		err error
+	}
+	done := make(chan result, 1)
+	jsutil.Async(func(actx jsutil.AsyncContext) (js.Value, error) {
+		val, err := f(actx)
+		done <- result{val: val, err: err}
+		return js.Undefined(), nil
+	})
+
+	select {
+	case r := <-done:
+		return r.val, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// Set implements Area.Set().
+func (c *Cancellable) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	_, err := run(ctx, c.ctx, func(ctx jsutil.AsyncContext) (struct{}, error) {
+		return struct{}{}, c.s.Set(ctx, data)
+	})
+	return err
+}
+
+// Get implements Area.Get().
+func (c *Cancellable) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	return run(ctx, c.ctx, c.s.Get)
+}
+
+// GetKeys implements Area.GetKeys().
+func (c *Cancellable) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	return run(ctx, c.ctx, func(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+		return c.s.GetKeys(ctx, keys)
+	})
+}
+
+// BytesInUse implements Area.BytesInUse().
+func (c *Cancellable) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	return run(ctx, c.ctx, func(ctx jsutil.AsyncContext) (int, error) {
+		return c.s.BytesInUse(ctx, keys)
+	})
+}
+
+// Delete implements Area.Delete().
+func (c *Cancellable) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	_, err := run(ctx, c.ctx, func(ctx jsutil.AsyncContext) (struct{}, error) {
+		return struct{}{}, c.s.Delete(ctx, keys)
+	})
+	return err
+}
+
+// Watch implements Area.Watch().
+//
+// Changes are no longer reported once the context is done.
+func (c *Cancellable) Watch(f WatchFunc) jsutil.CleanupFunc {
+	return c.s.Watch(func(changed map[string]js.Value, removed []string) {
+		if c.ctx.Err() != nil {
+			return
+		}
+		f(changed, removed)
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// slowArea delays each read by a fixed duration.
+type slowArea struct {
+	Area
+	delay time.Duration
+}
+
+func (s *slowArea) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	time.Sleep(s.delay)
+	return s.Area.Get(ctx)
+}
+
+func TestCancellable(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		delay       time.Duration
+		timeout     time.Duration
+		cancel      bool
+		want        map[string]string
+		wantErr     error
+	}{
+		{
+			description: "completes before timeout",
+			delay:       0,
+			timeout:     time.Second,
+			want:        map[string]string{"key": `1`},
+		},
+		{
+			description: "slow operation exceeds timeout",
+			delay:       time.Second,
+			timeout:     50 * time.Millisecond,
+			wantErr:     context.DeadlineExceeded,
+		},
+		{
+			description: "already cancelled",
+			delay:       0,
+			timeout:     time.Second,
+			cancel:      true,
+			wantErr:     context.Canceled,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				raw := NewRaw(st.NewMemArea())
+				if err := raw.Set(ctx, map[string]js.Value{"key": js.ValueOf(1)}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				cctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+				defer cancel()
+				if tc.cancel {
+					cancel()
+				}
+				c := WithContext(cctx, &slowArea{Area: raw, delay: tc.delay})
+
+				start := time.Now()
+				got, err := c.Get(ctx)
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("incorrect error; got %v, want %v", err, tc.wantErr)
+				}
+				if elapsed := time.Since(start); tc.wantErr != nil && tc.delay > 0 && elapsed >= tc.delay {
+					t.Errorf("operation not abandoned; took %v", elapsed)
+				}
+				if diff := cmp.Diff(dataToJSON(got), tc.want, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("incorrect data; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
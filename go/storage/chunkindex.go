@@ -0,0 +1,253 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// chunkIndexKey is the key at which the chunk index is stored. It shares the
+// chunk key prefix so that it is treated as internal (and hidden from reads),
+// but can never collide with a chunk key since those are base64-encoded
+// hashes.
+const chunkIndexKey = chunkKeyPrefix + "index"
+
+// chunkIndex maps each chunk key to the number of manifests referencing it.
+type chunkIndex map[string]int
+
+// WithChunkIndex enables the chunk index, and returns b.
+//
+// By default, removing chunks that are no longer referenced requires reading
+// all manifests. With the index enabled, the number of manifests referencing
+// each chunk is instead maintained in a single index entry, such that Delete
+// only needs to read the manifests of the deleted keys. Chunks replaced by Set
+// are also removed promptly, rather than lingering until the next Delete.
+//
+// The index must only be enabled if the underlying area is not modified by
+// other writers outside of our lock (e.g., chrome.storage.sync, where other
+// devices may write concurrently). Otherwise, lost updates to the index could
+// cause chunks that are still referenced to be removed. Likewise, all
+// instances of Big accessing the same area must agree on whether the index is
+// enabled.
+func (b *Big) WithChunkIndex() *Big {
+	b.indexed = true
+	return b
+}
+
+func (idx chunkIndex) value() js.Value {
+	obj := jsutil.NewObject()
+	for k, n := range idx {
+		obj.Set(k, n)
+	}
+	return obj
+}
+
+// readIndex returns the chunk index, or nil if it is not present or cannot be
+// parsed. The caller must hold the chunk lock in exclusive mode.
+func (b *Big) readIndex(ctx jsutil.AsyncContext) (chunkIndex, error) {
+	data, err := b.s.GetKeys(ctx, []string{chunkIndexKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk index: %w", err)
+	}
+	val, ok := data[chunkIndexKey]
+	if !ok {
+		return nil, nil
+	}
+	refs, err := valueToData(val)
+	if err != nil {
+		jsutil.LogError("Big: ignoring unparseable chunk index: %v", err)
+		return nil, nil
+	}
+	idx := chunkIndex{}
+	for k, n := range refs {
+		if n.Type() != js.TypeNumber {
+			jsutil.LogError("Big: ignoring chunk index with invalid count for %s", k)
+			return nil, nil
+		}
+		idx[k] = n.Int()
+	}
+	return idx, nil
+}
+
+// rebuildIndex reconstructs the chunk index from all manifests, and removes
+// any chunks that are not referenced. The caller must hold the chunk lock in
+// exclusive mode.
+func (b *Big) rebuildIndex(ctx jsutil.AsyncContext) (chunkIndex, error) {
+	jsutil.Log("Big: rebuilding chunk index")
+	data, err := b.s.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data: %w", err)
+	}
+
+	idx := chunkIndex{}
+	for k, v := range data {
+		if isChunkKey(k) {
+			continue
+		}
+		if manifest, ok := readManifest(v); ok {
+			for _, chunkKey := range manifest.ChunkKeys {
+				idx[chunkKey]++
+			}
+		}
+	}
+	if err := b.s.Set(ctx, map[string]js.Value{chunkIndexKey: idx.value()}); err != nil {
+		return nil, fmt.Errorf("failed to write chunk index: %w", err)
+	}
+
+	var dangling []string
+	for k := range data {
+		if isChunkKey(k) && k != chunkIndexKey && idx[k] == 0 {
+			dangling = append(dangling, k)
+		}
+	}
+	if err := b.s.Delete(ctx, dangling); err != nil {
+		return nil, fmt.Errorf("failed to delete dangling chunks: %w", err)
+	}
+	return idx, nil
+}
+
+// manifestRefs returns the chunk keys referenced by any manifests.
+func manifestRefs(data map[string]js.Value) []string {
+	var refs []string
+	for _, v := range data {
+		if manifest, ok := readManifest(v); ok {
+			refs = append(refs, manifest.ChunkKeys...)
+		}
+	}
+	return refs
+}
+
+// commitIndexed writes chunks and values produced by split, deletes the
+// specified keys, and updates the chunk index accordingly. The caller must
+// hold the per-key locks, and the chunk lock in exclusive mode.
+//
+// Updates are ordered such that a failure at any point leaves reference
+// counts that are too high (causing chunks to linger until the index is next
+// rebuilt), but never too low (which would cause referenced chunks to be
+// removed):
+//   - Chunks are written together with the index, incremented for the
+//     references in new manifests.
+//   - Values are written and keys deleted.
+//   - The index is decremented for references in replaced (or deleted)
+//     manifests, and chunks that are no longer referenced are removed.
+//
+// As with write, values and chunks that are already stored are skipped.
+func (b *Big) commitIndexed(ctx jsutil.AsyncContext, chunks, values map[string]js.Value, deleted []string) error {
+	keys := append([]string{}, deleted...)
+	for k := range values {
+		keys = append(keys, k)
+	}
+	for k := range chunks {
+		keys = append(keys, k)
+	}
+	existing, err := b.s.GetKeys(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("failed to read existing values: %w", err)
+	}
+	chunks = changedItems(existing, chunks)
+	values = changedItems(existing, values)
+	if len(values) == 0 && len(deleted) == 0 {
+		return nil
+	}
+
+	// Only replaced and deleted values give up their references.
+	old := map[string]js.Value{}
+	for _, k := range deleted {
+		if v, ok := existing[k]; ok {
+			old[k] = v
+		}
+	}
+	for k := range values {
+		if v, ok := existing[k]; ok {
+			old[k] = v
+		}
+	}
+
+	idx, err := b.readIndex(ctx)
+	if err != nil {
+		return err
+	}
+	if idx == nil {
+		if idx, err = b.rebuildIndex(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Phase 1: write chunks, and account for new references.
+	for _, chunkKey := range manifestRefs(values) {
+		idx[chunkKey]++
+	}
+	phase1 := map[string]js.Value{chunkIndexKey: idx.value()}
+	for k, v := range chunks {
+		phase1[k] = v
+	}
+	if err := b.s.Set(ctx, phase1); err != nil {
+		return fmt.Errorf("failed to write chunks: %w", err)
+	}
+
+	// Phase 2: write values, and delete keys.
+	if len(values) > 0 {
+		if err := b.s.Set(ctx, values); err != nil {
+			return b.cleanupIndexed(ctx, fmt.Errorf("failed to write values: %w", err))
+		}
+	}
+	if len(deleted) > 0 {
+		if err := b.s.Delete(ctx, deleted); err != nil {
+			return b.cleanupIndexed(ctx, fmt.Errorf("failed to delete values: %w", err))
+		}
+	}
+
+	// Phase 3: drop old references, and remove unreferenced chunks.
+	oldRefs := manifestRefs(old)
+	if len(oldRefs) == 0 {
+		return nil
+	}
+	var unreferenced []string
+	for _, chunkKey := range oldRefs {
+		if idx[chunkKey] <= 0 {
+			// The index is inconsistent with the manifests. Rebuild
+			// it, which also removes unreferenced chunks.
+			jsutil.LogError("Big: chunk index missing reference to %s", chunkKey)
+			_, err := b.rebuildIndex(ctx)
+			return err
+		}
+		idx[chunkKey]--
+		if idx[chunkKey] == 0 {
+			delete(idx, chunkKey)
+			unreferenced = append(unreferenced, chunkKey)
+		}
+	}
+	if err := b.s.Set(ctx, map[string]js.Value{chunkIndexKey: idx.value()}); err != nil {
+		return fmt.Errorf("failed to write chunk index: %w", err)
+	}
+	if err := b.s.Delete(ctx, unreferenced); err != nil {
+		return fmt.Errorf("failed to delete unreferenced chunks: %w", err)
+	}
+	return nil
+}
+
+// cleanupIndexed rebuilds the index after a failed write, removing any chunks
+// that were left unreferenced. The original error is returned.
+func (b *Big) cleanupIndexed(ctx jsutil.AsyncContext, err error) error {
+	if _, cerr := b.rebuildIndex(ctx); cerr != nil {
+		jsutil.LogError("Big: failed to clean up chunks: %v", cerr)
+	}
+	return err
+}
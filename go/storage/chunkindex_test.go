@@ -0,0 +1,281 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fullReadCountingArea counts calls that read the entire area.
+type fullReadCountingArea struct {
+	Area
+
+	mu        sync.Mutex
+	fullReads int
+}
+
+func (c *fullReadCountingArea) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	c.mu.Lock()
+	c.fullReads++
+	c.mu.Unlock()
+	return c.Area.Get(ctx)
+}
+
+// getIndex returns the chunk index stored in the area, as JSON.
+func getIndex(ctx jsutil.AsyncContext, s Area) (string, error) {
+	data, err := s.GetKeys(ctx, []string{chunkIndexKey})
+	if err != nil {
+		return "", err
+	}
+	v, ok := data[chunkIndexKey]
+	if !ok {
+		return "", nil
+	}
+	return jsutil.ToJSON(v), nil
+}
+
+func TestChunkIndexDelete(t *testing.T) {
+	t.Parallel()
+
+	const (
+		chunk1 = "chunk-3cc36853-b864-4122-beaa-516aa24448f6:Fru0sIiU1np0QdrjNzVcQQnL4/go9+Bhsa0jum0KFbU="
+		chunk2 = "chunk-3cc36853-b864-4122-beaa-516aa24448f6:G6T7G7fdARNR9OSgrLFctjhsP2mKdz4GS9bvK8F21ek="
+		chunk3 = "chunk-3cc36853-b864-4122-beaa-516aa24448f6:lHZRIv7UAumQRGrzQCQplvRz6iS71g6jnTlZwEhQQcs="
+	)
+
+	testcases := []struct {
+		description string
+		set         map[string]js.Value
+		del         []string
+		wantRaw     map[string]string
+		want        map[string]string
+	}{
+		{
+			description: "Delete big value",
+			set: map[string]js.Value{
+				"myString": js.ValueOf(strings.Repeat("a", 200)),
+				"myNumber": js.ValueOf(2),
+			},
+			del: []string{"myString"},
+			wantRaw: map[string]string{
+				chunkIndexKey: "chunk",
+				"myNumber":    "simple",
+			},
+			want: map[string]string{
+				"myNumber": "2",
+			},
+		},
+		{
+			description: "Delete big values that reference same data chunk",
+			set: map[string]js.Value{
+				"myString":   js.ValueOf(strings.Repeat("a", 200)),
+				"yourString": js.ValueOf(strings.Repeat("a", 200)),
+			},
+			del: []string{"yourString"},
+			wantRaw: map[string]string{
+				chunkIndexKey: "chunk",
+				chunk1:        "chunk",
+				chunk2:        "chunk",
+				chunk3:        "chunk",
+				"myString":    "manifest",
+			},
+			want: map[string]string{
+				"myString": fmt.Sprintf(`"%s"`, strings.Repeat("a", 200)),
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				raw := NewRaw(st.NewMemArea())
+				counter := &fullReadCountingArea{Area: raw}
+				b := NewBig(200, counter).WithChunkIndex()
+				if err := b.Set(ctx, tc.set); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				// Only the initial creation of the index requires
+				// reading everything.
+				counter.fullReads = 0
+				if err := b.Delete(ctx, tc.del); err != nil {
+					t.Fatalf("Delete failed: %v", err)
+				}
+				if counter.fullReads != 0 {
+					t.Errorf("Delete read all data %d times", counter.fullReads)
+				}
+
+				gotRaw, err := st.EntryTypes(ctx, raw)
+				if err != nil {
+					t.Fatalf("Get failed for underlying storage: %v", err)
+				}
+				if diff := cmp.Diff(gotRaw, tc.wantRaw); diff != "" {
+					t.Errorf("incorrect raw data: -got +want: %s", diff)
+				}
+				got, err := st.Get(ctx, b)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				if diff := cmp.Diff(got, tc.want); diff != "" {
+					t.Errorf("incorrect data: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestChunkIndexSetRemovesReplacedChunks(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		b := NewBig(200, raw).WithChunkIndex()
+		if err := b.Set(ctx, map[string]js.Value{"myString": js.ValueOf(strings.Repeat("a", 200))}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if err := b.Set(ctx, map[string]js.Value{"myString": js.ValueOf(strings.Repeat("b", 200))}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		// Only chunks for the new value remain.
+		fresh := NewBig(200, NewRaw(st.NewMemArea()))
+		if err := fresh.Set(ctx, map[string]js.Value{"myString": js.ValueOf(strings.Repeat("b", 200))}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		gotRaw, err := st.EntryTypes(ctx, raw)
+		if err != nil {
+			t.Fatalf("Get failed for underlying storage: %v", err)
+		}
+		wantRaw, err := st.EntryTypes(ctx, fresh.s)
+		if err != nil {
+			t.Fatalf("Get failed for underlying storage: %v", err)
+		}
+		wantRaw[chunkIndexKey] = "chunk"
+		if diff := cmp.Diff(gotRaw, wantRaw); diff != "" {
+			t.Errorf("incorrect raw data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestChunkIndexRebuild(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		index       js.Value
+	}{
+		{
+			description: "missing index",
+			index:       js.Undefined(),
+		},
+		{
+			description: "index missing references",
+			index:       js.ValueOf(map[string]interface{}{}),
+		},
+		{
+			description: "unparseable index",
+			index:       js.ValueOf("garbage"),
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				raw := NewRaw(st.NewMemArea())
+				// Write data without maintaining an index, including
+				// a dangling chunk.
+				if err := NewBig(200, raw).Set(ctx, map[string]js.Value{
+					"myString":   js.ValueOf(strings.Repeat("a", 200)),
+					"yourString": js.ValueOf(strings.Repeat("a", 200)),
+					"other":      js.ValueOf(strings.Repeat("c", 200)),
+				}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+				if err := raw.Set(ctx, map[string]js.Value{
+					makeChunkKey("ZGFuZ2xpbmc="): js.ValueOf("ZGFuZ2xpbmc="),
+				}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+				if !tc.index.IsUndefined() {
+					if err := raw.Set(ctx, map[string]js.Value{chunkIndexKey: tc.index}); err != nil {
+						t.Fatalf("Set failed: %v", err)
+					}
+				}
+
+				b := NewBig(200, raw).WithChunkIndex()
+				if err := b.Delete(ctx, []string{"yourString"}); err != nil {
+					t.Fatalf("Delete failed: %v", err)
+				}
+
+				// Shared chunks must survive, and the dangling one
+				// must be removed.
+				got, err := st.Get(ctx, b)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				want := map[string]string{
+					"myString": fmt.Sprintf(`"%s"`, strings.Repeat("a", 200)),
+					"other":    fmt.Sprintf(`"%s"`, strings.Repeat("c", 200)),
+				}
+				if diff := cmp.Diff(got, want); diff != "" {
+					t.Errorf("incorrect data: -got +want: %s", diff)
+				}
+
+				// The index must match one built from scratch.
+				gotIndex, err := getIndex(ctx, raw)
+				if err != nil {
+					t.Fatalf("failed to read index: %v", err)
+				}
+				fresh := NewBig(200, NewRaw(st.NewMemArea())).WithChunkIndex()
+				if err := fresh.Set(ctx, map[string]js.Value{
+					"myString": js.ValueOf(strings.Repeat("a", 200)),
+					"other":    js.ValueOf(strings.Repeat("c", 200)),
+				}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+				wantIndex, err := getIndex(ctx, fresh.s)
+				if err != nil {
+					t.Fatalf("failed to read index: %v", err)
+				}
+				var gotIdx, wantIdx map[string]int
+				if err := json.Unmarshal([]byte(gotIndex), &gotIdx); err != nil {
+					t.Fatalf("failed to parse index: %v", err)
+				}
+				if err := json.Unmarshal([]byte(wantIndex), &wantIdx); err != nil {
+					t.Fatalf("failed to parse index: %v", err)
+				}
+				if diff := cmp.Diff(gotIdx, wantIdx); diff != "" {
+					t.Errorf("incorrect index: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
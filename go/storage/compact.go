@@ -0,0 +1,145 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/lock"
+)
+
+// ErrCompactionInProgress indicates that Compact was invoked while a previous
+// invocation was still in progress.
+var ErrCompactionInProgress = errors.New("compaction already in progress")
+
+// Compact rewrites all big values using the current chunk size, and removes
+// chunks that are no longer referenced. Over time, values may otherwise be
+// left split into more chunks than necessary (e.g., if they were written
+// under a smaller maximum item size), and chunks may be left dangling by
+// interrupted writes. Simple values that no longer fit are also split.
+//
+// Chunks are content-addressed, so chunks that are unchanged are not
+// rewritten, and identical chunks are stored only once. The number of bytes
+// saved is returned; this may be negative if values grew (e.g., when simple
+// values must now be split).
+//
+// Unreferenced chunks are removed without regard to where they came from, so
+// Compact should not be invoked periodically on an area written concurrently
+// by other devices; see DefaultCompactable.
+//
+// Compact returns ErrCompactionInProgress if it is already running.
+func (b *Big) Compact(ctx jsutil.AsyncContext) (int, error) {
+	b.compactMu.Lock()
+	if b.compacting {
+		b.compactMu.Unlock()
+		return 0, ErrCompactionInProgress
+	}
+	b.compacting = true
+	b.compactMu.Unlock()
+	defer func() {
+		b.compactMu.Lock()
+		defer b.compactMu.Unlock()
+		b.compacting = false
+	}()
+
+	var saved int
+	var err error
+	_, aerr := lock.Async(lockResourceID, func(ctx jsutil.AsyncContext) {
+		saved, err = b.compact(ctx)
+	}).Await(ctx)
+	if aerr != nil {
+		return 0, aerr
+	}
+	return saved, err
+}
+
+// compact implements Compact. The caller must hold the chunk lock in
+// exclusive mode.
+func (b *Big) compact(ctx jsutil.AsyncContext) (int, error) {
+	data, err := b.s.Get(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read data: %w", err)
+	}
+	before := dataSize(data)
+
+	rewrite := map[string]js.Value{}
+	for k, v := range data {
+		if isChunkKey(k) {
+			continue
+		}
+		manifest, ok := readManifest(v)
+		if !ok {
+			if !b.canStore(k, jsutil.ToJSON(v)) {
+				rewrite[k] = v
+			}
+			continue
+		}
+		val, err := reassembleManifest(k, manifest, data)
+		if err != nil {
+			jsutil.LogError("Big.Compact: skipping %s: %v", k, err)
+			continue
+		}
+		rewrite[k] = val
+	}
+
+	chunks, values := b.split(rewrite)
+	werr := b.write(ctx, chunks, values)
+
+	// Remove the chunks that were replaced, or that were left unreferenced
+	// by a failed write.
+	if err := b.deleteDanglingChunks(ctx); err != nil {
+		if werr != nil {
+			jsutil.LogError("Big.Compact: failed to clean up chunks: %v", err)
+			return 0, werr
+		}
+		return 0, err
+	}
+	if werr != nil {
+		return 0, werr
+	}
+
+	after, err := b.s.Get(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read compacted data: %w", err)
+	}
+	return before - dataSize(after), nil
+}
+
+// ScheduleCompaction ensures that the named alarm of the chrome.alarms API
+// fires periodically, such that the caller can invoke Compact when it does.
+// Alarms persist beyond the lifetime of the extension's service worker, so an
+// existing alarm is left as-is; restarting does not postpone compaction.
+func ScheduleCompaction(ctx jsutil.AsyncContext, alarms js.Value, name string, period time.Duration) error {
+	existing, err := jsutil.AsPromise(alarms.Call("get", name)).Await(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read alarm: %w", err)
+	}
+	if existing.Type() == js.TypeObject {
+		return nil // Already scheduled.
+	}
+
+	info := jsutil.NewObject()
+	info.Set("periodInMinutes", period.Minutes())
+	if _, err := jsutil.AsPromise(alarms.Call("create", name, info)).Await(ctx); err != nil {
+		return fmt.Errorf("failed to create alarm: %w", err)
+	}
+	return nil
+}
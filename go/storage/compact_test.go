@@ -0,0 +1,174 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+)
+
+// countChunks returns the number of chunks in the underlying storage.
+func countChunks(ctx jsutil.AsyncContext, s Area) (int, error) {
+	data, err := s.Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for k := range data {
+		if isChunkKey(k) && k != chunkIndexKey {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestCompact(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		indexed     bool
+	}{
+		{description: "without chunk index"},
+		{description: "with chunk index", indexed: true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				raw := NewRaw(st.NewMemArea())
+				data := map[string]js.Value{
+					"myString":   js.ValueOf(incompressible("my", 1000)),
+					"yourString": js.ValueOf(incompressible("my", 1000)),
+					"myNumber":   js.ValueOf(2),
+				}
+				// Write under a smaller item size, leaving values
+				// split into more chunks than necessary.
+				if err := NewBig(150, raw).Set(ctx, data); err != nil {
+					t.Errorf("set failed: %v", err)
+					return
+				}
+				// Leave a dangling chunk.
+				if err := raw.Set(ctx, map[string]js.Value{makeChunkKey("ZGFuZ2xpbmc="): js.ValueOf("ZGFuZ2xpbmc=")}); err != nil {
+					t.Errorf("set failed for underlying storage: %v", err)
+					return
+				}
+				before, err := countChunks(ctx, raw)
+				if err != nil {
+					t.Errorf("get failed for underlying storage: %v", err)
+					return
+				}
+
+				b := NewBig(1000, raw)
+				if tc.indexed {
+					b.WithChunkIndex()
+				}
+				saved, err := b.Compact(ctx)
+				if err != nil {
+					t.Errorf("compact failed: %v", err)
+					return
+				}
+				if saved <= 0 {
+					t.Errorf("incorrect bytes saved; got %d, want > 0", saved)
+				}
+
+				after, err := countChunks(ctx, raw)
+				if err != nil {
+					t.Errorf("get failed for underlying storage: %v", err)
+					return
+				}
+				if after >= before {
+					t.Errorf("chunks not compacted; got %d, had %d", after, before)
+				}
+
+				got, err := st.Get(ctx, b)
+				if err != nil {
+					t.Errorf("get failed: %v", err)
+					return
+				}
+				if diff := cmp.Diff(got, dataToJSON(data)); diff != "" {
+					t.Errorf("incorrect data: -got +want: %s", diff)
+				}
+
+				// Compacting again changes nothing.
+				saved, err = b.Compact(ctx)
+				if err != nil {
+					t.Errorf("compact failed: %v", err)
+					return
+				}
+				if saved != 0 {
+					t.Errorf("incorrect bytes saved on repeat; got %d, want 0", saved)
+				}
+			})
+		})
+	}
+}
+
+func TestCompactInProgress(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		b := NewBig(200, NewRaw(st.NewMemArea()))
+		b.compacting = true
+		if _, err := b.Compact(ctx); !errors.Is(err, ErrCompactionInProgress) {
+			t.Errorf("incorrect error: got %v, want %v", err, ErrCompactionInProgress)
+		}
+	})
+}
+
+func TestScheduleCompaction(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		alarms := st.NewAlarms()
+		if err := ScheduleCompaction(ctx, alarms, "compact", time.Hour); err != nil {
+			t.Errorf("schedule failed: %v", err)
+			return
+		}
+		scheduled := st.AlarmTime(alarms, "compact")
+		if scheduled == 0 {
+			t.Errorf("alarm not scheduled")
+			return
+		}
+
+		// Rescheduling leaves the existing alarm as-is.
+		time.Sleep(5 * time.Millisecond)
+		if err := ScheduleCompaction(ctx, alarms, "compact", time.Hour); err != nil {
+			t.Errorf("schedule failed: %v", err)
+			return
+		}
+		if got := st.AlarmTime(alarms, "compact"); got != scheduled {
+			t.Errorf("alarm rescheduled; got %d, want %d", got, scheduled)
+		}
+
+		// Periodic alarms fire repeatedly.
+		if !st.FireAlarm(alarms, "compact") {
+			t.Errorf("alarm did not fire")
+		}
+		if got, want := st.AlarmTime(alarms, "compact"), scheduled+time.Hour.Milliseconds(); got != want {
+			t.Errorf("alarm not rescheduled after firing; got %d, want %d", got, want)
+		}
+	})
+}
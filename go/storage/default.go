@@ -21,13 +21,41 @@ import (
 )
 
 // DefaultSync returns an Area that can store and retrieve data that is synced
-// between the user's devices.  See:
+// between the user's devices.  Writes are throttled to respect the sync
+// area's write-rate quotas. If the sync area is unavailable (e.g., sync is
+// disabled by policy), data is instead stored locally; see Fallback. See:
 //
 //	https://developer.chrome.com/docs/extensions/reference/storage/#property-sync
 func DefaultSync() Area {
+	return NewFallback(defaultSyncBig(), DefaultLocal())
+}
+
+// defaultSyncBig returns the Big storing data in the sync area.
+func defaultSyncBig() *Big {
 	area := js.Global().Get("chrome").Get("storage").Get("sync")
-	maxItemBytes := area.Get("QUOTA_BYTES_PER_ITEM").Int()
-	return NewBig(maxItemBytes, NewRaw(area))
+	return NewBig(ReadLimits(area).MaxItemBytes(), NewThrottled(NewRaw(area)))
+}
+
+// DefaultLocal returns an Area that can store and retrieve data on the local
+// device.  See:
+//
+//	https://developer.chrome.com/docs/extensions/reference/storage/#property-local
+func DefaultLocal() Area {
+	return defaultLocalBig()
+}
+
+// defaultLocalBig returns the Big storing data in the local area.
+func defaultLocalBig() *Big {
+	area := js.Global().Get("chrome").Get("storage").Get("local")
+	return NewBigAuto(area)
+}
+
+// DefaultCompactable returns the Big areas that may be compacted periodically;
+// see Big.Compact. Only the local area underlying DefaultLocal is included. In
+// the sync area, a chunk written by another device may arrive before the
+// manifest referencing it, so it would wrongly be deleted as dangling.
+func DefaultCompactable() []*Big {
+	return []*Big{defaultLocalBig()}
 }
 
 // DefaultSession returns an Area that can store and retrieve in-memory data.
@@ -36,5 +64,23 @@ func DefaultSync() Area {
 //	https://developer.chrome.com/docs/extensions/reference/storage/#property-session
 func DefaultSession() Area {
 	area := js.Global().Get("chrome").Get("storage").Get("session")
-	return NewRaw(area)
+	return NewSession(area)
+}
+
+// DefaultIndexedDB returns an Area that can store and retrieve large values
+// on the local device, using the named IndexedDB database. Unlike the Storage
+// API areas, there is no per-item quota.  See:
+//
+//	https://developer.mozilla.org/en-US/docs/Web/API/IndexedDB_API
+func DefaultIndexedDB(name string) Area {
+	return NewIndexedDB(js.Global().Get("indexedDB"), name)
+}
+
+// DefaultManaged returns an Area that can retrieve data configured by an
+// administrator. The data is read-only.  See:
+//
+//	https://developer.chrome.com/docs/extensions/reference/storage/#property-managed
+func DefaultManaged() Area {
+	area := js.Global().Get("chrome").Get("storage").Get("managed")
+	return NewManaged(area)
 }
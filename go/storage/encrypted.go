@@ -0,0 +1,440 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/norunners/vert"
+)
+
+var (
+	// ErrLocked indicates that an operation requiring the encryption key
+	// was attempted while the Encrypted area is locked.
+	ErrLocked = errors.New("encrypted storage is locked")
+
+	// ErrWrongKey indicates that the supplied passphrase or key does not
+	// match the one with which existing data was encrypted.
+	ErrWrongKey = errors.New("incorrect passphrase or key")
+)
+
+const (
+	// encryptionParamsKey is the key in the underlying storage area at
+	// which the encryption parameters are stored.
+	encryptionParamsKey = "encryption-params"
+
+	// pbkdf2Iterations is the number of PBKDF2 iterations used when
+	// deriving a key from a passphrase. This follows OWASP's
+	// recommendation for PBKDF2-HMAC-SHA256.
+	pbkdf2Iterations = 600000
+
+	// saltBytes is the size of the salt used when deriving a key.
+	saltBytes = 16
+
+	// ivBytes is the size of the AES-GCM initialization vector.
+	ivBytes = 12
+
+	// verifierPlaintext is encrypted when the parameters are first
+	// created, allowing us to detect an incorrect key.
+	verifierPlaintext = `"chrome-ssh-agent"`
+)
+
+var subtle = js.Global().Get("crypto").Get("subtle")
+
+// encryptedValue is a single encrypted value, as stored in the underlying
+// storage area.
+type encryptedValue struct {
+	// IV is the base64-encoded initialization vector.
+	IV string `js:"iv"`
+	// Data is the base64-encoded ciphertext.
+	Data string `js:"data"`
+}
+
+// encryptionParams are the parameters shared by all values in the underlying
+// storage area.
+type encryptionParams struct {
+	// Salt is the base64-encoded salt used when deriving a key from a
+	// passphrase.
+	Salt string `js:"salt"`
+	// Verifier is verifierPlaintext encrypted using the key.
+	Verifier encryptedValue `js:"verifier"`
+}
+
+// Encrypted encrypts values before storing them in an underlying storage
+// area, and decrypts them upon retrieval. Values are encrypted using AES-GCM.
+// Keys are not encrypted.
+//
+// The area must be unlocked with a passphrase or key before values can be
+// read or written; until then, such operations fail with ErrLocked. Values
+// may be deleted while locked.
+//
+// When used with Big, Encrypted should wrap Big so that chunks are computed
+// over ciphertext rather than plaintext.
+//
+// Encrypted implements the Area interface.
+type Encrypted struct {
+	s Area
+
+	// mu protects key.
+	mu sync.Mutex
+	// key is the AES-GCM CryptoKey, or undefined if locked.
+	key js.Value
+}
+
+// NewEncrypted returns an Encrypted storing data in the specified underlying
+// storage area. It is initially locked.
+func NewEncrypted(store Area) *Encrypted {
+	return &Encrypted{
+		s:   store,
+		key: js.Undefined(),
+	}
+}
+
+func toUint8Array(b []byte) js.Value {
+	a := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(a, b)
+	return a
+}
+
+func fromArrayBuffer(buf js.Value) []byte {
+	a := js.Global().Get("Uint8Array").New(buf)
+	b := make([]byte, a.Length())
+	js.CopyBytesToGo(b, a)
+	return b
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return b, nil
+}
+
+// deriveKey derives a non-extractable AES-GCM key from a passphrase.
+func deriveKey(ctx jsutil.AsyncContext, passphrase string, salt []byte) (js.Value, error) {
+	base, err := jsutil.AsPromise(subtle.Call("importKey",
+		"raw", toUint8Array([]byte(passphrase)), "PBKDF2", false,
+		js.ValueOf([]interface{}{"deriveKey"}))).Await(ctx)
+	if err != nil {
+		return js.Undefined(), fmt.Errorf("failed to import passphrase: %w", err)
+	}
+	key, err := jsutil.AsPromise(subtle.Call("deriveKey",
+		js.ValueOf(map[string]interface{}{
+			"name":       "PBKDF2",
+			"salt":       toUint8Array(salt),
+			"iterations": pbkdf2Iterations,
+			"hash":       "SHA-256",
+		}),
+		base,
+		js.ValueOf(map[string]interface{}{
+			"name":   "AES-GCM",
+			"length": 256,
+		}),
+		false,
+		js.ValueOf([]interface{}{"encrypt", "decrypt"}))).Await(ctx)
+	if err != nil {
+		return js.Undefined(), fmt.Errorf("failed to derive key: %w", err)
+	}
+	return key, nil
+}
+
+// encrypt encrypts the JSON representation of a value.
+func encrypt(ctx jsutil.AsyncContext, key js.Value, plaintext string) (*encryptedValue, error) {
+	iv, err := randomBytes(ivBytes)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := jsutil.AsPromise(subtle.Call("encrypt",
+		js.ValueOf(map[string]interface{}{
+			"name": "AES-GCM",
+			"iv":   toUint8Array(iv),
+		}),
+		key,
+		toUint8Array([]byte(plaintext)))).Await(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return &encryptedValue{
+		IV:   base64.StdEncoding.EncodeToString(iv),
+		Data: base64.StdEncoding.EncodeToString(fromArrayBuffer(buf)),
+	}, nil
+}
+
+// decrypt decrypts a value, returning its JSON representation.
+func decrypt(ctx jsutil.AsyncContext, key js.Value, ev *encryptedValue) (string, error) {
+	iv, err := base64.StdEncoding.DecodeString(ev.IV)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode IV: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(ev.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode ciphertext: %w", err)
+	}
+	buf, err := jsutil.AsPromise(subtle.Call("decrypt",
+		js.ValueOf(map[string]interface{}{
+			"name": "AES-GCM",
+			"iv":   toUint8Array(iv),
+		}),
+		key,
+		toUint8Array(data))).Await(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return string(fromArrayBuffer(buf)), nil
+}
+
+// readParams reads the encryption parameters from the underlying storage
+// area. It returns nil if the parameters have not yet been created.
+func (e *Encrypted) readParams(ctx jsutil.AsyncContext) (*encryptionParams, error) {
+	data, err := e.s.GetKeys(ctx, []string{encryptionParamsKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption parameters: %w", err)
+	}
+	val, ok := data[encryptionParamsKey]
+	if !ok {
+		return nil, nil
+	}
+	var params encryptionParams
+	if err := vert.ValueOf(val).AssignTo(&params); err != nil {
+		return nil, fmt.Errorf("failed to parse encryption parameters: %w", err)
+	}
+	return &params, nil
+}
+
+// unlock verifies the key against the parameters (creating them if they do
+// not yet exist), and then unlocks the area.
+func (e *Encrypted) unlock(ctx jsutil.AsyncContext, params *encryptionParams, salt []byte, key js.Value) error {
+	if params != nil {
+		if _, err := decrypt(ctx, key, &params.Verifier); err != nil {
+			return ErrWrongKey
+		}
+	} else {
+		verifier, err := encrypt(ctx, key, verifierPlaintext)
+		if err != nil {
+			return err
+		}
+		params = &encryptionParams{
+			Salt:     base64.StdEncoding.EncodeToString(salt),
+			Verifier: *verifier,
+		}
+		if err := e.s.Set(ctx, map[string]js.Value{
+			encryptionParamsKey: vert.ValueOf(params).JSValue(),
+		}); err != nil {
+			return fmt.Errorf("failed to write encryption parameters: %w", err)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.key = key
+	return nil
+}
+
+// Unlock unlocks the area using a key derived from the supplied passphrase.
+// If no data has yet been encrypted, the passphrase is accepted and will be
+// required for subsequent unlocks; otherwise, ErrWrongKey is returned if the
+// passphrase does not match.
+func (e *Encrypted) Unlock(ctx jsutil.AsyncContext, passphrase string) error {
+	params, err := e.readParams(ctx)
+	if err != nil {
+		return err
+	}
+
+	var salt []byte
+	if params != nil {
+		salt, err = base64.StdEncoding.DecodeString(params.Salt)
+		if err != nil {
+			return fmt.Errorf("failed to decode salt: %w", err)
+		}
+	} else {
+		salt, err = randomBytes(saltBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := deriveKey(ctx, passphrase, salt)
+	if err != nil {
+		return err
+	}
+	return e.unlock(ctx, params, salt, key)
+}
+
+// UnlockWithKey unlocks the area using the supplied AES-GCM CryptoKey. The
+// key may be non-extractable. As with Unlock, ErrWrongKey is returned if the
+// key does not match the one with which existing data was encrypted.
+func (e *Encrypted) UnlockWithKey(ctx jsutil.AsyncContext, key js.Value) error {
+	params, err := e.readParams(ctx)
+	if err != nil {
+		return err
+	}
+	// The salt is unused, but is generated so parameters are well-formed.
+	salt, err := randomBytes(saltBytes)
+	if err != nil {
+		return err
+	}
+	return e.unlock(ctx, params, salt, key)
+}
+
+// Lock locks the area, discarding the key.
+func (e *Encrypted) Lock() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.key = js.Undefined()
+}
+
+// Locked returns true if the area is locked.
+func (e *Encrypted) Locked() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.key.IsUndefined()
+}
+
+// currentKey returns the key, or ErrLocked if the area is locked.
+func (e *Encrypted) currentKey() (js.Value, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.key.IsUndefined() {
+		return js.Undefined(), ErrLocked
+	}
+	return e.key, nil
+}
+
+// decryptData decrypts values read from the underlying storage area. The
+// encryption parameters are omitted.
+func decryptData(ctx jsutil.AsyncContext, key js.Value, data map[string]js.Value) (map[string]js.Value, error) {
+	result := map[string]js.Value{}
+	for k, v := range data {
+		if k == encryptionParamsKey {
+			continue
+		}
+		var ev encryptedValue
+		if err := vert.ValueOf(v).AssignTo(&ev); err != nil {
+			return nil, fmt.Errorf("failed to parse encrypted value for key %s: %w", k, err)
+		}
+		plaintext, err := decrypt(ctx, key, &ev)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt value for key %s: %w", k, err)
+		}
+		result[k] = jsutil.FromJSON(plaintext)
+	}
+	return result, nil
+}
+
+// Set implements Area.Set().
+func (e *Encrypted) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	key, err := e.currentKey()
+	if err != nil {
+		return err
+	}
+
+	edata := map[string]js.Value{}
+	for k, v := range data {
+		if k == encryptionParamsKey {
+			return fmt.Errorf("key %s is reserved", k)
+		}
+		ev, err := encrypt(ctx, key, jsutil.ToJSON(v))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt value for key %s: %w", k, err)
+		}
+		edata[k] = vert.ValueOf(ev).JSValue()
+	}
+	return e.s.Set(ctx, edata)
+}
+
+// Get implements Area.Get().
+func (e *Encrypted) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	key, err := e.currentKey()
+	if err != nil {
+		return nil, err
+	}
+	data, err := e.s.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return decryptData(ctx, key, data)
+}
+
+// GetKeys implements Area.GetKeys().
+func (e *Encrypted) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	key, err := e.currentKey()
+	if err != nil {
+		return nil, err
+	}
+	data, err := e.s.GetKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	return decryptData(ctx, key, data)
+}
+
+// BytesInUse implements Area.BytesInUse().
+//
+// The size reported is that of the encrypted values.
+func (e *Encrypted) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	return e.s.BytesInUse(ctx, keys)
+}
+
+// Delete implements Area.Delete().
+func (e *Encrypted) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	var nkeys []string
+	for _, k := range keys {
+		if k != encryptionParamsKey {
+			nkeys = append(nkeys, k)
+		}
+	}
+	return e.s.Delete(ctx, nkeys)
+}
+
+// Watch implements Area.Watch().
+//
+// Changed values are decrypted before being reported. Changes observed while
+// the area is locked cannot be decrypted, and are dropped.
+func (e *Encrypted) Watch(f WatchFunc) jsutil.CleanupFunc {
+	return e.s.Watch(func(changed map[string]js.Value, removed []string) {
+		jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+			var nremoved []string
+			for _, k := range removed {
+				if k != encryptionParamsKey {
+					nremoved = append(nremoved, k)
+				}
+			}
+			nchanged := map[string]js.Value{}
+			if len(changed) > 0 {
+				key, err := e.currentKey()
+				if err != nil {
+					jsutil.LogError("Encrypted.Watch: dropping %d changed values: %v", len(changed), err)
+				} else if nchanged, err = decryptData(ctx, key, changed); err != nil {
+					jsutil.LogError("Encrypted.Watch: dropping changed values: %v", err)
+					nchanged = map[string]js.Value{}
+				}
+			}
+			if len(nchanged) == 0 && len(nremoved) == 0 {
+				return js.Undefined(), nil
+			}
+			f(nchanged, nremoved)
+			return js.Undefined(), nil
+		})
+	})
+}
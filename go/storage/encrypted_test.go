@@ -0,0 +1,213 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"strings"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/norunners/vert"
+)
+
+func TestEncryptedSetAndGet(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		e := NewEncrypted(NewBig(defaultMaxItemBytes, raw))
+		if err := e.Unlock(ctx, "passphrase"); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+
+		data := map[string]js.Value{
+			"num":    js.ValueOf(2),
+			"secret": js.ValueOf("my-secret-value"),
+			"struct": vert.ValueOf(&myStruct{IntField: 3, StringField: "foo"}).JSValue(),
+		}
+		if err := e.Set(ctx, data); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		got, err := e.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), dataToJSON(data)); diff != "" {
+			t.Errorf("incorrect data; -got +want: %s", diff)
+		}
+
+		got, err = e.GetKeys(ctx, []string{"secret", "missing"})
+		if err != nil {
+			t.Fatalf("GetKeys failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{"secret": `"my-secret-value"`}); diff != "" {
+			t.Errorf("incorrect data from GetKeys; -got +want: %s", diff)
+		}
+
+		// Plaintext must not be visible in the underlying storage.
+		rawData, err := raw.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed for underlying storage: %v", err)
+		}
+		for k, v := range dataToJSON(rawData) {
+			if strings.Contains(v, "my-secret-value") {
+				t.Errorf("plaintext visible in underlying storage at key %s: %s", k, v)
+			}
+		}
+
+		if err := e.Delete(ctx, []string{"secret", "struct"}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		got, err = e.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{"num": `2`}); diff != "" {
+			t.Errorf("incorrect data after Delete; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestEncryptedLocked(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		e := NewEncrypted(raw)
+		if !e.Locked() {
+			t.Errorf("new area is unlocked")
+		}
+		if err := e.Set(ctx, map[string]js.Value{"key": js.ValueOf(1)}); !errors.Is(err, ErrLocked) {
+			t.Errorf("incorrect error from Set; got %v, want %v", err, ErrLocked)
+		}
+		if _, err := e.Get(ctx); !errors.Is(err, ErrLocked) {
+			t.Errorf("incorrect error from Get; got %v, want %v", err, ErrLocked)
+		}
+
+		if err := e.Unlock(ctx, "passphrase"); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+		if err := e.Set(ctx, map[string]js.Value{"key": js.ValueOf(1)}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		e.Lock()
+		if _, err := e.GetKeys(ctx, []string{"key"}); !errors.Is(err, ErrLocked) {
+			t.Errorf("incorrect error from GetKeys; got %v, want %v", err, ErrLocked)
+		}
+
+		// A different instance must use the same passphrase.
+		other := NewEncrypted(raw)
+		if err := other.Unlock(ctx, "wrong"); !errors.Is(err, ErrWrongKey) {
+			t.Errorf("incorrect error from Unlock with wrong passphrase; got %v, want %v", err, ErrWrongKey)
+		}
+		if !other.Locked() {
+			t.Errorf("area unlocked with wrong passphrase")
+		}
+		if err := other.Unlock(ctx, "passphrase"); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+		got, err := other.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{"key": `1`}); diff != "" {
+			t.Errorf("incorrect data; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestEncryptedUnlockWithKey(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		genKey := func() js.Value {
+			key, err := jsutil.AsPromise(subtle.Call("generateKey",
+				js.ValueOf(map[string]interface{}{"name": "AES-GCM", "length": 256}),
+				false,
+				js.ValueOf([]interface{}{"encrypt", "decrypt"}))).Await(ctx)
+			if err != nil {
+				t.Fatalf("failed to generate key: %v", err)
+			}
+			return key
+		}
+
+		raw := NewRaw(st.NewMemArea())
+		key := genKey()
+		e := NewEncrypted(raw)
+		if err := e.UnlockWithKey(ctx, key); err != nil {
+			t.Fatalf("UnlockWithKey failed: %v", err)
+		}
+		if err := e.Set(ctx, map[string]js.Value{"key": js.ValueOf("value")}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		if err := NewEncrypted(raw).UnlockWithKey(ctx, genKey()); !errors.Is(err, ErrWrongKey) {
+			t.Errorf("incorrect error from UnlockWithKey with wrong key; got %v, want %v", err, ErrWrongKey)
+		}
+
+		other := NewEncrypted(raw)
+		if err := other.UnlockWithKey(ctx, key); err != nil {
+			t.Fatalf("UnlockWithKey failed: %v", err)
+		}
+		got, err := other.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{"key": `"value"`}); diff != "" {
+			t.Errorf("incorrect data; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestEncryptedWatch(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		e := NewEncrypted(NewRaw(st.NewQuotaMemArea(0, 0, 0)))
+		if err := e.Unlock(ctx, "passphrase"); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+		events, cleanup := watchEvents(e)
+		defer cleanup()
+
+		if err := e.Set(ctx, map[string]js.Value{"key": js.ValueOf("value")}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		want := &watchEvent{
+			changed: map[string]string{"key": `"value"`},
+		}
+		if diff := cmp.Diff(nextEvent(events), want, cmp.AllowUnexported(watchEvent{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect event after set: -got +want: %s", diff)
+		}
+
+		if err := e.Delete(ctx, []string{"key"}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		want = &watchEvent{
+			removed: []string{"key"},
+		}
+		if diff := cmp.Diff(nextEvent(events), want, cmp.AllowUnexported(watchEvent{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect event after delete: -got +want: %s", diff)
+		}
+	})
+}
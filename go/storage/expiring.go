@@ -0,0 +1,276 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+const (
+	// expiringValueMagic is the magic string that we encode in values
+	// stored by Expiring, distinguishing them from any other value that
+	// may have been stored.
+	expiringValueMagic = "9f0d3b7e-51c4-4a8e-9d27-6c7f4e2a1b85"
+)
+
+// Expiring stores values that may expire after a specified period. Expired
+// values are omitted when reading, and removed from storage as they are
+// encountered. To ensure that expired values (e.g., decrypted key material)
+// do not linger in storage until they are next read, they can also be purged
+// proactively; see PurgeWithAlarms.
+//
+// Values are stored along with their expiry time. Values written by Set never
+// expire.
+//
+// Expiring implements the Area interface.
+type Expiring struct {
+	s Area
+
+	// now returns the current time. Overridden in tests.
+	now func() time.Time
+
+	// alarms is the chrome.alarms API used to schedule purging of expired
+	// values, or undefined if values are not purged proactively.
+	alarms js.Value
+	// alarmName is the name of the alarm used to schedule purging.
+	alarmName string
+}
+
+// NewExpiring returns an Expiring that stores values in store.
+func NewExpiring(store Area) *Expiring {
+	return &Expiring{
+		s:      store,
+		now:    time.Now,
+		alarms: js.Undefined(),
+	}
+}
+
+// wrapExpiring returns the value to be stored for v, expiring at the
+// specified time. A zero time indicates that the value never expires.
+func wrapExpiring(v js.Value, expires time.Time) js.Value {
+	res := jsutil.NewObject()
+	res.Set("magic", expiringValueMagic)
+	if !expires.IsZero() {
+		res.Set("expires", expires.UnixMilli())
+	}
+	res.Set("value", v)
+	return res
+}
+
+// unwrapExpiring returns the original value for a stored value, along with its
+// expiry time. Values that were not stored by Expiring are returned
+// unmodified, and never expire.
+func unwrapExpiring(v js.Value) (js.Value, time.Time) {
+	if v.Type() != js.TypeObject || v.Get("magic").Type() != js.TypeString || v.Get("magic").String() != expiringValueMagic {
+		return v, time.Time{}
+	}
+	var expires time.Time
+	if e := v.Get("expires"); e.Type() == js.TypeNumber {
+		expires = time.UnixMilli(int64(e.Float()))
+	}
+	return v.Get("value"), expires
+}
+
+// unwrapAll returns the original values for stored values that have not
+// expired, along with the keys of those that have expired.
+func (e *Expiring) unwrapAll(data map[string]js.Value) (map[string]js.Value, []string) {
+	now := e.now()
+	res := map[string]js.Value{}
+	var expired []string
+	for k, v := range data {
+		val, expires := unwrapExpiring(v)
+		if !expires.IsZero() && !now.Before(expires) {
+			expired = append(expired, k)
+			continue
+		}
+		res[k] = val
+	}
+	return res, expired
+}
+
+// deleteExpired removes expired values encountered when reading. Failures are
+// logged; the values are already omitted from results.
+func (e *Expiring) deleteExpired(ctx jsutil.AsyncContext, expired []string) {
+	if len(expired) == 0 {
+		return
+	}
+	if err := e.s.Delete(ctx, expired); err != nil {
+		jsutil.LogError("Expiring: failed to delete expired values: %v", err)
+	}
+}
+
+// Set implements Area.Set(). The values never expire.
+func (e *Expiring) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	wrapped := map[string]js.Value{}
+	for k, v := range data {
+		wrapped[k] = wrapExpiring(v, time.Time{})
+	}
+	return e.s.Set(ctx, wrapped)
+}
+
+// SetWithTTL stores new data in storage, in the same manner as Set. The values
+// expire once ttl has elapsed.
+func (e *Expiring) SetWithTTL(ctx jsutil.AsyncContext, data map[string]js.Value, ttl time.Duration) error {
+	expires := e.now().Add(ttl)
+	wrapped := map[string]js.Value{}
+	for k, v := range data {
+		wrapped[k] = wrapExpiring(v, expires)
+	}
+	if err := e.s.Set(ctx, wrapped); err != nil {
+		return err
+	}
+	return e.schedulePurge(ctx, expires)
+}
+
+// Get implements Area.Get().
+func (e *Expiring) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	data, err := e.s.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, expired := e.unwrapAll(data)
+	e.deleteExpired(ctx, expired)
+	return res, nil
+}
+
+// GetKeys implements Area.GetKeys().
+func (e *Expiring) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	data, err := e.s.GetKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	res, expired := e.unwrapAll(data)
+	e.deleteExpired(ctx, expired)
+	return res, nil
+}
+
+// BytesInUse implements Area.BytesInUse().
+func (e *Expiring) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	return e.s.BytesInUse(ctx, keys)
+}
+
+// Delete implements Area.Delete().
+func (e *Expiring) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	return e.s.Delete(ctx, keys)
+}
+
+// Watch implements Area.Watch().
+func (e *Expiring) Watch(f WatchFunc) jsutil.CleanupFunc {
+	return e.s.Watch(func(changed map[string]js.Value, removed []string) {
+		res, _ := e.unwrapAll(changed)
+		if len(res) == 0 && len(removed) == 0 {
+			return
+		}
+		f(res, removed)
+	})
+}
+
+// Purge removes all expired values from storage. The time at which the next
+// value expires is returned, or the zero time if no values are due to expire.
+func (e *Expiring) Purge(ctx jsutil.AsyncContext) (time.Time, error) {
+	data, err := e.s.Get(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read data: %w", err)
+	}
+	_, expired := e.unwrapAll(data)
+	if len(expired) > 0 {
+		if err := e.s.Delete(ctx, expired); err != nil {
+			return time.Time{}, fmt.Errorf("failed to delete expired values: %w", err)
+		}
+	}
+
+	isExpired := map[string]bool{}
+	for _, k := range expired {
+		isExpired[k] = true
+	}
+	var next time.Time
+	for k, v := range data {
+		if _, expires := unwrapExpiring(v); !isExpired[k] && !expires.IsZero() && (next.IsZero() || expires.Before(next)) {
+			next = expires
+		}
+	}
+	return next, nil
+}
+
+// PurgeWithAlarms arranges for expired values to be purged proactively, using
+// the named alarm of the chrome.alarms API. Alarms persist beyond the lifetime
+// of the extension's service worker, so PurgeWithAlarms should be invoked
+// each time the service worker starts. Any values that expired while it was
+// not running are purged immediately.
+//
+// The returned cleanup function must be invoked to stop purging.
+func (e *Expiring) PurgeWithAlarms(alarms js.Value, name string) jsutil.CleanupFunc {
+	e.alarms = alarms
+	e.alarmName = name
+
+	purge := func() {
+		jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+			next, err := e.Purge(ctx)
+			if err != nil {
+				jsutil.LogError("Expiring: failed to purge expired values: %v", err)
+				return js.Undefined(), nil
+			}
+			if err := e.schedulePurge(ctx, next); err != nil {
+				jsutil.LogError("Expiring: failed to schedule purge: %v", err)
+			}
+			return js.Undefined(), nil
+		})
+	}
+
+	listener := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if alarm := jsutil.SingleArg(args); alarm.Get("name").String() == name {
+			purge()
+		}
+		return nil
+	})
+	alarms.Get("onAlarm").Call("addListener", listener)
+	purge()
+
+	return func() {
+		alarms.Get("onAlarm").Call("removeListener", listener)
+		listener.Release()
+		e.alarms = js.Undefined()
+	}
+}
+
+// schedulePurge ensures that the purge alarm fires no later than the
+// specified time. It does nothing if the time is zero, or if alarms are not
+// in use.
+func (e *Expiring) schedulePurge(ctx jsutil.AsyncContext, when time.Time) error {
+	if e.alarms.IsUndefined() || when.IsZero() {
+		return nil
+	}
+
+	existing, err := jsutil.AsPromise(e.alarms.Call("get", e.alarmName)).Await(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read alarm: %w", err)
+	}
+	if existing.Type() == js.TypeObject && int64(existing.Get("scheduledTime").Float()) <= when.UnixMilli() {
+		return nil // Already scheduled sooner.
+	}
+
+	info := jsutil.NewObject()
+	info.Set("when", when.UnixMilli())
+	if _, err := jsutil.AsPromise(e.alarms.Call("create", e.alarmName, info)).Await(ctx); err != nil {
+		return fmt.Errorf("failed to create alarm: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sync"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// fakeClock is a manually-advanced clock.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.UnixMilli(1700000000000)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestExpiring(store Area, clock *fakeClock) *Expiring {
+	e := NewExpiring(store)
+	e.now = clock.Now
+	return e
+}
+
+func TestExpiring(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		elapsed     time.Duration
+		want        map[string]string
+		wantRaw     []string
+	}{
+		{
+			description: "before expiry",
+			elapsed:     59 * time.Second,
+			want: map[string]string{
+				"permanent": "1",
+				"temporary": "2",
+			},
+			wantRaw: []string{"permanent", "temporary"},
+		},
+		{
+			description: "at expiry",
+			elapsed:     time.Minute,
+			want: map[string]string{
+				"permanent": "1",
+			},
+			wantRaw: []string{"permanent"},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				raw := NewRaw(st.NewMemArea())
+				clock := newFakeClock()
+				e := newTestExpiring(raw, clock)
+				if err := e.Set(ctx, map[string]js.Value{"permanent": js.ValueOf(1)}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+				if err := e.SetWithTTL(ctx, map[string]js.Value{"temporary": js.ValueOf(2)}, time.Minute); err != nil {
+					t.Fatalf("SetWithTTL failed: %v", err)
+				}
+
+				clock.Advance(tc.elapsed)
+				got, err := e.Get(ctx)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				if diff := cmp.Diff(dataToJSON(got), tc.want); diff != "" {
+					t.Errorf("incorrect data; -got +want: %s", diff)
+				}
+				got, err = e.GetKeys(ctx, []string{"temporary"})
+				if err != nil {
+					t.Fatalf("GetKeys failed: %v", err)
+				}
+				wantKeys := map[string]string{}
+				if v, ok := tc.want["temporary"]; ok {
+					wantKeys["temporary"] = v
+				}
+				if diff := cmp.Diff(dataToJSON(got), wantKeys); diff != "" {
+					t.Errorf("incorrect data for GetKeys; -got +want: %s", diff)
+				}
+
+				// Expired values are removed as they are read.
+				gotRaw, err := raw.Get(ctx)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				var gotRawKeys []string
+				for k := range gotRaw {
+					gotRawKeys = append(gotRawKeys, k)
+				}
+				if diff := cmp.Diff(gotRawKeys, tc.wantRaw, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+					t.Errorf("incorrect raw keys; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestExpiringUnwrapped(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		if err := raw.Set(ctx, map[string]js.Value{"legacy": js.ValueOf("foo")}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		// Values stored before Expiring was used never expire.
+		e := NewExpiring(raw)
+		got, err := e.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{"legacy": `"foo"`}); diff != "" {
+			t.Errorf("incorrect data; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestExpiringPurgeWithAlarms(t *testing.T) {
+	t.Parallel()
+
+	const alarmName = "purge"
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		clock := newFakeClock()
+		e := newTestExpiring(raw, clock)
+		alarms := st.NewAlarms()
+		cleanup := e.PurgeWithAlarms(alarms, alarmName)
+		defer cleanup()
+
+		if err := e.SetWithTTL(ctx, map[string]js.Value{"later": js.ValueOf(1)}, 2*time.Minute); err != nil {
+			t.Fatalf("SetWithTTL failed: %v", err)
+		}
+		if err := e.SetWithTTL(ctx, map[string]js.Value{"sooner": js.ValueOf(2)}, time.Minute); err != nil {
+			t.Fatalf("SetWithTTL failed: %v", err)
+		}
+
+		// The alarm is scheduled for the earliest expiry.
+		if got, want := st.AlarmTime(alarms, alarmName), clock.Now().Add(time.Minute).UnixMilli(); got != want {
+			t.Errorf("incorrect alarm time; got %d, want %d", got, want)
+		}
+
+		// When the alarm fires, expired values are removed without
+		// being read, and the alarm is rescheduled for the next expiry.
+		clock.Advance(time.Minute)
+		if !st.FireAlarm(alarms, alarmName) {
+			t.Fatalf("alarm not scheduled")
+		}
+		want := map[string]string{"later": jsutil.ToJSON(wrapExpiring(js.ValueOf(1), clock.Now().Add(time.Minute)))}
+		deadline := time.Now().Add(time.Second)
+		for {
+			got, err := raw.Get(ctx)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			diff := cmp.Diff(dataToJSON(got), want)
+			if diff == "" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expired values not purged; -got +want: %s", diff)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		deadline = time.Now().Add(time.Second)
+		for st.AlarmTime(alarms, alarmName) != clock.Now().Add(time.Minute).UnixMilli() {
+			if time.Now().After(deadline) {
+				t.Fatalf("alarm not rescheduled; got %d", st.AlarmTime(alarms, alarmName))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// ErrUnsupportedVersion indicates that an exported document was produced by
+// a newer version, and cannot be imported.
+var ErrUnsupportedVersion = errors.New("unsupported export version")
+
+// exportVersion is the version of the export format produced by ExportAll.
+const exportVersion = 1
+
+// ImportMode determines how ImportAll treats existing data.
+type ImportMode int
+
+const (
+	// ImportMerge retains existing values, except where they are
+	// replaced by values in the document.
+	ImportMerge ImportMode = iota
+	// ImportOverwrite replaces all existing values with those in the
+	// document.
+	ImportOverwrite
+)
+
+// ExportAll serializes all values in the storage area to a JSON document.
+// Values are exported as they are returned by the area; for Big, this means
+// reassembled values rather than individual chunks.
+//
+// The document is of the form:
+//
+//	{"version": 1, "items": {"key": value, ...}}
+//
+// Items are ordered by key, so the document is stable for identical data.
+func ExportAll(ctx jsutil.AsyncContext, s Area) (string, error) {
+	data, err := s.Get(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read data: %w", err)
+	}
+
+	var keys []string
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := jsutil.NewObject()
+	for _, k := range keys {
+		items.Set(k, data[k])
+	}
+
+	doc := jsutil.NewObject()
+	doc.Set("version", exportVersion)
+	doc.Set("items", items)
+	return jsutil.ToJSON(doc), nil
+}
+
+// parseExport parses a document produced by ExportAll.
+func parseExport(doc string) (map[string]js.Value, error) {
+	val := jsutil.FromJSON(doc)
+	if val.Type() != js.TypeObject {
+		return nil, errors.New("document is not a JSON object")
+	}
+	version := val.Get("version")
+	if version.Type() != js.TypeNumber {
+		return nil, errors.New("document is missing version")
+	}
+	if v := version.Int(); v > exportVersion {
+		return nil, fmt.Errorf("%w: version %d; maximum supported is %d", ErrUnsupportedVersion, v, exportVersion)
+	}
+	items := val.Get("items")
+	if items.Type() != js.TypeObject {
+		return nil, errors.New("document is missing items")
+	}
+	return valueToData(items)
+}
+
+// ImportAll restores values from a JSON document produced by ExportAll. The
+// values are written via the storage area; for Big, this means values are
+// split into chunks as required.
+//
+// Documents with an unsupported (future) version are rejected with
+// ErrUnsupportedVersion, and no data is modified.
+func ImportAll(ctx jsutil.AsyncContext, s Area, doc string, mode ImportMode) error {
+	data, err := parseExport(doc)
+	if err != nil {
+		return fmt.Errorf("failed to parse document: %w", err)
+	}
+
+	if mode == ImportOverwrite {
+		existing, err := s.Get(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read existing data: %w", err)
+		}
+		var stale []string
+		for k := range existing {
+			if _, ok := data[k]; !ok {
+				stale = append(stale, k)
+			}
+		}
+		if err := s.Delete(ctx, stale); err != nil {
+			return fmt.Errorf("failed to delete existing data: %w", err)
+		}
+	}
+
+	if err := s.Set(ctx, data); err != nil {
+		return fmt.Errorf("failed to write data: %w", err)
+	}
+	return nil
+}
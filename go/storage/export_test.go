@@ -0,0 +1,181 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"strings"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExportAll(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		b := NewBig(200, NewRaw(st.NewMemArea()))
+		if err := b.Set(ctx, map[string]js.Value{
+			"b": js.ValueOf(strings.Repeat("x", 300)),
+			"a": js.ValueOf(1),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		got, err := ExportAll(ctx, b)
+		if err != nil {
+			t.Fatalf("ExportAll failed: %v", err)
+		}
+		want := `{"version":1,"items":{"a":1,"b":"` + strings.Repeat("x", 300) + `"}}`
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect document: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestImportAll(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		init        map[string]js.Value
+		doc         string
+		mode        ImportMode
+		want        map[string]string
+		wantErr     bool
+		wantErrIs   error
+	}{
+		{
+			description: "merge",
+			init: map[string]js.Value{
+				"a": js.ValueOf(1),
+				"b": js.ValueOf(2),
+			},
+			doc:  `{"version":1,"items":{"b":3,"c":"` + strings.Repeat("y", 300) + `"}}`,
+			mode: ImportMerge,
+			want: map[string]string{
+				"a": `1`,
+				"b": `3`,
+				"c": `"` + strings.Repeat("y", 300) + `"`,
+			},
+		},
+		{
+			description: "overwrite",
+			init: map[string]js.Value{
+				"a": js.ValueOf(1),
+				"b": js.ValueOf(2),
+			},
+			doc:  `{"version":1,"items":{"b":3}}`,
+			mode: ImportOverwrite,
+			want: map[string]string{
+				"b": `3`,
+			},
+		},
+		{
+			description: "future version",
+			init: map[string]js.Value{
+				"a": js.ValueOf(1),
+			},
+			doc:       `{"version":2,"items":{"b":3}}`,
+			mode:      ImportOverwrite,
+			wantErr:   true,
+			wantErrIs: ErrUnsupportedVersion,
+			want: map[string]string{
+				"a": `1`,
+			},
+		},
+		{
+			description: "invalid document",
+			init: map[string]js.Value{
+				"a": js.ValueOf(1),
+			},
+			doc:     `{"items":{"b":3}}`,
+			mode:    ImportOverwrite,
+			wantErr: true,
+			want: map[string]string{
+				"a": `1`,
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				b := NewBig(200, NewRaw(st.NewMemArea()))
+				if err := b.Set(ctx, tc.init); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				err := ImportAll(ctx, b, tc.doc, tc.mode)
+				if (err != nil) != tc.wantErr {
+					t.Errorf("incorrect error; got %v, want error %v", err, tc.wantErr)
+				}
+				if tc.wantErrIs != nil && !errors.Is(err, tc.wantErrIs) {
+					t.Errorf("incorrect error; got %v, want %v", err, tc.wantErrIs)
+				}
+
+				got, err := st.Get(ctx, b)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				if diff := cmp.Diff(got, tc.want); diff != "" {
+					t.Errorf("incorrect data: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		src := NewBig(200, NewRaw(st.NewMemArea()))
+		if err := src.Set(ctx, map[string]js.Value{
+			"small": js.ValueOf(1),
+			"large": js.ValueOf(strings.Repeat("z", 1000)),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		doc, err := ExportAll(ctx, src)
+		if err != nil {
+			t.Fatalf("ExportAll failed: %v", err)
+		}
+
+		dst := NewBig(200, NewRaw(st.NewMemArea()))
+		if err := ImportAll(ctx, dst, doc, ImportOverwrite); err != nil {
+			t.Fatalf("ImportAll failed: %v", err)
+		}
+
+		got, err := st.Get(ctx, dst)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		want, err := st.Get(ctx, src)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,242 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// Location identifies which area of a Fallback currently holds a key.
+type Location int
+
+const (
+	// LocationNone indicates that the key is not present in either area.
+	LocationNone Location = iota
+	// LocationPrimary indicates that the key is present in the primary
+	// area.
+	LocationPrimary
+	// LocationSecondary indicates that the key is present only in the
+	// secondary area.
+	LocationSecondary
+)
+
+// String implements fmt.Stringer.
+func (l Location) String() string {
+	switch l {
+	case LocationNone:
+		return "none"
+	case LocationPrimary:
+		return "primary"
+	case LocationSecondary:
+		return "secondary"
+	default:
+		return fmt.Sprintf("Location(%d)", int(l))
+	}
+}
+
+// Fallback stores data in a primary area (typically chrome.storage.sync),
+// falling back to a secondary area (typically chrome.storage.local) when the
+// primary is unavailable. This is the case, for example, if sync is disabled
+// by enterprise policy.
+//
+// Writes go to the primary area if it accepts them, and to the secondary area
+// otherwise. Reads merge both areas; where a key is present in both, the
+// primary value takes precedence. Values written to the secondary area are
+// copied to the primary area once it accepts writes again; see Reconcile.
+//
+// While the primary area is unavailable, deleted keys are only removed from
+// the secondary area. Keys previously written to the primary area may
+// therefore reappear once it becomes available again.
+//
+// Fallback implements the Area interface.
+type Fallback struct {
+	primary   Area
+	secondary Area
+
+	// mu serializes writes to the secondary area with reconciliation, such
+	// that values written concurrently are not lost.
+	mu sync.Mutex
+	// degraded indicates that the primary area was found to be unavailable,
+	// and the secondary area may hold values that need to be reconciled.
+	degraded bool
+}
+
+// NewFallback returns a Fallback that stores data in primary when possible,
+// and otherwise in secondary.
+func NewFallback(primary, secondary Area) *Fallback {
+	return &Fallback{
+		primary:   primary,
+		secondary: secondary,
+		degraded:  true,
+	}
+}
+
+// primaryFailed records that the primary area is unavailable.
+func (f *Fallback) primaryFailed(op string, err error) {
+	jsutil.LogError("Fallback.%s: primary area unavailable: %v", op, err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.degraded = true
+}
+
+// primaryOK records that the primary area accepted a write, reconciling values
+// in the secondary area in the background if it was previously unavailable.
+func (f *Fallback) primaryOK() {
+	f.mu.Lock()
+	degraded := f.degraded
+	f.mu.Unlock()
+	if !degraded {
+		return
+	}
+	jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+		if err := f.Reconcile(ctx); err != nil {
+			jsutil.LogError("Fallback: failed to reconcile: %v", err)
+		}
+		return js.Undefined(), nil
+	})
+}
+
+// Reconcile copies values from the secondary area to the primary area, and
+// removes them from the secondary area. Values in the secondary area were
+// written while the primary area was unavailable, and therefore replace those
+// in the primary area.
+//
+// Reconcile is invoked automatically once the primary area accepts a write
+// after being found unavailable (or after the Fallback is created, since
+// values may remain from a previous session).
+func (f *Fallback) Reconcile(ctx jsutil.AsyncContext) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := f.secondary.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read secondary area: %w", err)
+	}
+	if len(data) > 0 {
+		if err := f.primary.Set(ctx, data); err != nil {
+			f.degraded = true
+			return fmt.Errorf("failed to write primary area: %w", err)
+		}
+		var keys []string
+		for k := range data {
+			keys = append(keys, k)
+		}
+		if err := f.secondary.Delete(ctx, keys); err != nil {
+			return fmt.Errorf("failed to delete from secondary area: %w", err)
+		}
+	}
+	f.degraded = false
+	return nil
+}
+
+// Set implements Area.Set().
+func (f *Fallback) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	if err := f.primary.Set(ctx, data); err != nil {
+		f.primaryFailed("Set", err)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.secondary.Set(ctx, data)
+	}
+	f.primaryOK()
+	return nil
+}
+
+// Get implements Area.Get().
+func (f *Fallback) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	secondary, err := f.secondary.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	primary, err := f.primary.Get(ctx)
+	if err != nil {
+		f.primaryFailed("Get", err)
+		return secondary, nil
+	}
+	return merge(primary, secondary), nil
+}
+
+// GetKeys implements Area.GetKeys().
+func (f *Fallback) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	secondary, err := f.secondary.GetKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	primary, err := f.primary.GetKeys(ctx, keys)
+	if err != nil {
+		f.primaryFailed("GetKeys", err)
+		return secondary, nil
+	}
+	return merge(primary, secondary), nil
+}
+
+// Location returns the area that currently holds the value for key.
+func (f *Fallback) Location(ctx jsutil.AsyncContext, key string) (Location, error) {
+	primary, err := f.primary.GetKeys(ctx, []string{key})
+	if err != nil {
+		f.primaryFailed("Location", err)
+	} else if _, ok := primary[key]; ok {
+		return LocationPrimary, nil
+	}
+	secondary, err := f.secondary.GetKeys(ctx, []string{key})
+	if err != nil {
+		return LocationNone, err
+	}
+	if _, ok := secondary[key]; ok {
+		return LocationSecondary, nil
+	}
+	return LocationNone, nil
+}
+
+// BytesInUse implements Area.BytesInUse().
+func (f *Fallback) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	secondary, err := f.secondary.BytesInUse(ctx, keys)
+	if err != nil {
+		return 0, err
+	}
+	primary, err := f.primary.BytesInUse(ctx, keys)
+	if err != nil {
+		f.primaryFailed("BytesInUse", err)
+		return secondary, nil
+	}
+	return primary + secondary, nil
+}
+
+// Delete implements Area.Delete().
+func (f *Fallback) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	f.mu.Lock()
+	err := f.secondary.Delete(ctx, keys)
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := f.primary.Delete(ctx, keys); err != nil {
+		f.primaryFailed("Delete", err)
+	}
+	return nil
+}
+
+// Watch implements Area.Watch().
+//
+// Changes to primary values are always reported. Changes to secondary values
+// are reported only if the key is not present in the primary area.
+func (f *Fallback) Watch(fn WatchFunc) jsutil.CleanupFunc {
+	return NewOverlay(f.primary, f.secondary).Watch(fn)
+}
@@ -0,0 +1,221 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+)
+
+const unavailableError = "Sync is disabled"
+
+// newRawNoRetry returns a Raw for area that does not retry failures,
+// such that failures injected into area are immediately visible.
+func newRawNoRetry(area js.Value) *Raw {
+	return NewRawWithRetryPolicy(area, RetryPolicy{MaxAttempts: 1})
+}
+
+func TestFallback(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description   string
+		readFailures  int
+		writeFailures int
+		wantLocation  Location
+		want          map[string]string
+	}{
+		{
+			description:  "primary available",
+			wantLocation: LocationPrimary,
+			want: map[string]string{
+				"existing": "1",
+				"new":      "2",
+			},
+		},
+		{
+			description:   "primary rejects writes",
+			writeFailures: 1,
+			wantLocation:  LocationSecondary,
+			want: map[string]string{
+				"existing": "1",
+				"new":      "2",
+			},
+		},
+		{
+			description:   "primary unavailable",
+			readFailures:  2,
+			writeFailures: 1,
+			wantLocation:  LocationSecondary,
+			want: map[string]string{
+				"new": "2",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				area := st.NewQuotaMemArea(0, 0, 0)
+				primary := newRawNoRetry(area)
+				secondary := NewRaw(st.NewMemArea())
+				if err := primary.Set(ctx, map[string]js.Value{"existing": js.ValueOf(1)}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				f := NewFallback(primary, secondary)
+				st.FailWrites(area, tc.writeFailures, unavailableError)
+				if err := f.Set(ctx, map[string]js.Value{"new": js.ValueOf(2)}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				st.FailReads(area, tc.readFailures, unavailableError)
+				loc, err := f.Location(ctx, "new")
+				if err != nil {
+					t.Fatalf("Location failed: %v", err)
+				}
+				if loc != tc.wantLocation {
+					t.Errorf("incorrect location; got %v, want %v", loc, tc.wantLocation)
+				}
+				got, err := f.Get(ctx)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				if diff := cmp.Diff(dataToJSON(got), tc.want); diff != "" {
+					t.Errorf("incorrect data; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestFallbackPrimaryWins(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		primary := NewRaw(st.NewMemArea())
+		secondary := NewRaw(st.NewMemArea())
+		if err := primary.Set(ctx, map[string]js.Value{"key": js.ValueOf("primary")}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if err := secondary.Set(ctx, map[string]js.Value{"key": js.ValueOf("secondary")}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		f := NewFallback(primary, secondary)
+		got, err := f.GetKeys(ctx, []string{"key"})
+		if err != nil {
+			t.Fatalf("GetKeys failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{"key": `"primary"`}); diff != "" {
+			t.Errorf("incorrect data; -got +want: %s", diff)
+		}
+		loc, err := f.Location(ctx, "key")
+		if err != nil {
+			t.Fatalf("Location failed: %v", err)
+		}
+		if loc != LocationPrimary {
+			t.Errorf("incorrect location; got %v, want %v", loc, LocationPrimary)
+		}
+	})
+}
+
+func TestFallbackReconcile(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		area := st.NewQuotaMemArea(0, 0, 0)
+		primary := newRawNoRetry(area)
+		secondary := NewRaw(st.NewMemArea())
+		f := NewFallback(primary, secondary)
+
+		// Written while the primary area is unavailable.
+		st.FailWrites(area, 1, unavailableError)
+		if err := f.Set(ctx, map[string]js.Value{"offline": js.ValueOf(1)}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		// Once the primary area accepts writes again, values are
+		// copied to it in the background.
+		if err := f.Set(ctx, map[string]js.Value{"online": js.ValueOf(2)}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for {
+			loc, err := f.Location(ctx, "offline")
+			if err != nil {
+				t.Fatalf("Location failed: %v", err)
+			}
+			if loc == LocationPrimary {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("value not reconciled; location %v", loc)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		got, err := primary.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{"offline": "1", "online": "2"}); diff != "" {
+			t.Errorf("incorrect primary data; -got +want: %s", diff)
+		}
+		got, err = secondary.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{}); diff != "" {
+			t.Errorf("incorrect secondary data; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestFallbackDelete(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		primary := NewRaw(st.NewMemArea())
+		secondary := NewRaw(st.NewMemArea())
+		if err := primary.Set(ctx, map[string]js.Value{"key": js.ValueOf(1)}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if err := secondary.Set(ctx, map[string]js.Value{"key": js.ValueOf(2)}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		f := NewFallback(primary, secondary)
+		if err := f.Delete(ctx, []string{"key"}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		loc, err := f.Location(ctx, "key")
+		if err != nil {
+			t.Fatalf("Location failed: %v", err)
+		}
+		if loc != LocationNone {
+			t.Errorf("incorrect location; got %v, want %v", loc, LocationNone)
+		}
+	})
+}
@@ -0,0 +1,348 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/norunners/vert"
+)
+
+const (
+	// idbVersion is the version of the database schema. It must be
+	// incremented whenever upgradeIndexedDB changes.
+	idbVersion = 1
+
+	// idbStoreName is the name of the object store containing our items.
+	idbStoreName = "items"
+)
+
+// idbHelpers are Javascript helpers that adapt IndexedDB's event-based API
+// to promises.
+var idbHelpers = js.Global().Call("eval", `{
+	({
+		request: (req) => new Promise((resolve, reject) => {
+			req.onsuccess = () => resolve(req.result);
+			req.onerror = () => reject(req.error);
+		}),
+		transaction: (tx) => new Promise((resolve, reject) => {
+			tx.oncomplete = () => resolve();
+			tx.onerror = () => reject(tx.error);
+			tx.onabort = () => reject(tx.error || new Error("transaction aborted"));
+		}),
+		open: (factory, name, version, upgrade, versionChange) => new Promise((resolve, reject) => {
+			const req = factory.open(name, version);
+			req.onupgradeneeded = (event) => upgrade(req.result, event.oldVersion);
+			req.onsuccess = () => {
+				req.result.onversionchange = () => versionChange(req.result);
+				resolve(req.result);
+			};
+			req.onerror = () => reject(req.error);
+			req.onblocked = () => console.warn("IndexedDB open blocked by another connection:", name);
+		}),
+	});
+}`)
+
+// IndexedDB supports storing and retrieving data using the browser's IndexedDB
+// API. Values are stored using structured cloning, and there is no per-item
+// quota; values therefore never need to be split into chunks.
+//
+// IndexedDB implements the Area interface.
+type IndexedDB struct {
+	// factory is the IDBFactory used to open the database.
+	factory js.Value
+	// name is the name of the database.
+	name string
+
+	// mu protects db.
+	mu sync.Mutex
+	// db is the open database connection, or undefined if not open.
+	db js.Value
+}
+
+// NewIndexedDB returns an IndexedDB storing data in the named database. The
+// specified factory must point to an object implementing the IDBFactory API
+// (typically the global 'indexedDB').
+func NewIndexedDB(factory js.Value, name string) *IndexedDB {
+	return &IndexedDB{
+		factory: factory,
+		name:    name,
+		db:      js.Undefined(),
+	}
+}
+
+// upgradeIndexedDB migrates the database schema from oldVersion to
+// idbVersion.
+func upgradeIndexedDB(db js.Value, oldVersion int) {
+	jsutil.Log("IndexedDB: upgrading %s from version %d to %d", db.Get("name"), oldVersion, idbVersion)
+	if oldVersion < 1 {
+		db.Call("createObjectStore", idbStoreName)
+	}
+}
+
+// open returns the database connection, opening it if required.
+//
+// Multiple extension pages may have the database open concurrently. If another
+// page attempts to upgrade the database, we close our connection so that it is
+// not blocked; we reopen it on the next operation.
+func (i *IndexedDB) open(ctx jsutil.AsyncContext) (js.Value, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if !i.db.IsUndefined() {
+		return i.db, nil
+	}
+
+	upgrade := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		var db, oldVersion js.Value
+		jsutil.ExpandArgs(args, &db, &oldVersion)
+		upgradeIndexedDB(db, oldVersion.Int())
+		return nil
+	})
+	defer upgrade.Release()
+
+	versionChange := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		db := jsutil.SingleArg(args)
+		jsutil.Log("IndexedDB: closing %s for version change", i.name)
+		db.Call("close")
+		// We must not block in a callback; forget the connection
+		// in the background.
+		go func() {
+			i.mu.Lock()
+			defer i.mu.Unlock()
+			if i.db.Equal(db) {
+				i.db = js.Undefined()
+			}
+		}()
+		return nil
+	})
+
+	db, err := jsutil.AsPromise(idbHelpers.Call("open", i.factory, i.name, idbVersion, upgrade, versionChange)).Await(ctx)
+	if err != nil {
+		versionChange.Release()
+		return js.Undefined(), fmt.Errorf("failed to open database %s: %w", i.name, err)
+	}
+
+	// versionChange remains registered for the lifetime of the connection.
+	i.db = db
+	return db, nil
+}
+
+// transaction starts a new transaction on our object store.
+func (i *IndexedDB) transaction(ctx jsutil.AsyncContext, mode string) (tx js.Value, store js.Value, err error) {
+	db, err := i.open(ctx)
+	if err != nil {
+		return js.Undefined(), js.Undefined(), err
+	}
+	tx = db.Call("transaction", idbStoreName, mode)
+	return tx, tx.Call("objectStore", idbStoreName), nil
+}
+
+// idbRequest returns a promise for the result of a request. The promise must
+// be created before yielding to the event loop, else the result may be missed.
+func idbRequest(req js.Value) *jsutil.Promise {
+	return jsutil.AsPromise(idbHelpers.Call("request", req))
+}
+
+// idbTransaction returns a promise that is resolved when the transaction
+// completes. The same caveat applies as for idbRequest.
+func idbTransaction(tx js.Value) *jsutil.Promise {
+	return jsutil.AsPromise(idbHelpers.Call("transaction", tx))
+}
+
+// Set implements Area.Set().
+func (i *IndexedDB) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	jsutil.LogDebug("IndexedDB.Set: setting %d values", len(data))
+	defer jsutil.LogDebug("IndexedDB.Set: finished")
+
+	if len(data) == 0 {
+		return nil // Nothing to do.
+	}
+
+	tx, store, err := i.transaction(ctx, "readwrite")
+	if err != nil {
+		return fmt.Errorf("failed to set data: %w", err)
+	}
+	done := idbTransaction(tx)
+	for k, v := range data {
+		store.Call("put", v, k)
+	}
+	if _, err := done.Await(ctx); err != nil {
+		return fmt.Errorf("failed to set data: %w", err)
+	}
+
+	i.notify(data, nil)
+	return nil
+}
+
+// Get implements Area.Get().
+func (i *IndexedDB) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	jsutil.LogDebug("IndexedDB.Get: reading all values")
+	defer jsutil.LogDebug("IndexedDB.Get: finished")
+
+	_, store, err := i.transaction(ctx, "readonly")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data: %w", err)
+	}
+	keysReq := idbRequest(store.Call("getAllKeys"))
+	valsReq := idbRequest(store.Call("getAll"))
+	keys, err := keysReq.Await(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+	vals, err := valsReq.Await(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get values: %w", err)
+	}
+
+	data := map[string]js.Value{}
+	for n := 0; n < keys.Length(); n++ {
+		data[keys.Index(n).String()] = vals.Index(n)
+	}
+
+	jsutil.LogDebug("IndexedDB.Get: return %d values", len(data))
+	return data, nil
+}
+
+// GetKeys implements Area.GetKeys().
+func (i *IndexedDB) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	jsutil.LogDebug("IndexedDB.GetKeys: reading %d values", len(keys))
+	defer jsutil.LogDebug("IndexedDB.GetKeys: finished")
+
+	if len(keys) == 0 {
+		return map[string]js.Value{}, nil // Nothing to do.
+	}
+
+	_, store, err := i.transaction(ctx, "readonly")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data: %w", err)
+	}
+	reqs := map[string]*jsutil.Promise{}
+	for _, k := range keys {
+		reqs[k] = idbRequest(store.Call("get", k))
+	}
+
+	data := map[string]js.Value{}
+	for k, req := range reqs {
+		val, err := req.Await(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get key %s: %w", k, err)
+		}
+		if !val.IsUndefined() {
+			data[k] = val
+		}
+	}
+	return data, nil
+}
+
+// BytesInUse implements Area.BytesInUse().
+//
+// IndexedDB does not report usage for individual items. Instead, we estimate
+// it in the same manner as Chrome's Storage API: the length of the key plus
+// the length of the JSON-stringified value.
+func (i *IndexedDB) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	data, err := i.GetKeys(ctx, keys)
+	if err != nil {
+		return 0, err
+	}
+	return dataBytes(data), nil
+}
+
+// Delete implements Area.Delete().
+func (i *IndexedDB) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	jsutil.LogDebug("IndexedDB.Delete: deleting %d values", len(keys))
+	defer jsutil.LogDebug("IndexedDB.Delete: finished")
+
+	if len(keys) == 0 {
+		return nil // Nothing to do.
+	}
+
+	tx, store, err := i.transaction(ctx, "readwrite")
+	if err != nil {
+		return fmt.Errorf("failed to delete data: %w", err)
+	}
+	done := idbTransaction(tx)
+	for _, k := range keys {
+		store.Call("delete", k)
+	}
+	if _, err := done.Await(ctx); err != nil {
+		return fmt.Errorf("failed to delete data: %w", err)
+	}
+
+	i.notify(nil, keys)
+	return nil
+}
+
+// channelName is the name of the BroadcastChannel used to notify watchers
+// (in this and other extension pages) of changes.
+func (i *IndexedDB) channelName() string {
+	return "indexeddb-storage:" + i.name
+}
+
+// notify informs all watchers of changes.
+func (i *IndexedDB) notify(changed map[string]js.Value, removed []string) {
+	ch := js.Global().Get("BroadcastChannel")
+	if ch.IsUndefined() {
+		return
+	}
+	c := ch.New(i.channelName())
+	defer c.Call("close")
+	// The message is an object with 'changed' (an object of updated
+	// values) and 'removed' (an array of removed keys) fields.
+	msg := js.Global().Get("Object").New()
+	msg.Set("changed", dataToValue(changed))
+	msg.Set("removed", vert.ValueOf(removed).JSValue())
+	c.Call("postMessage", msg)
+}
+
+// Watch implements Area.Watch().
+//
+// IndexedDB does not natively support change notifications. Changes made via
+// IndexedDB (in any extension page) are broadcast to watchers.
+func (i *IndexedDB) Watch(f WatchFunc) jsutil.CleanupFunc {
+	ch := js.Global().Get("BroadcastChannel")
+	if ch.IsUndefined() {
+		jsutil.LogError("IndexedDB.Watch: BroadcastChannel unsupported; changes will not be reported")
+		return func() {}
+	}
+
+	c := ch.New(i.channelName())
+	listener := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		msg := jsutil.SingleArg(args).Get("data")
+		changed, err := valueToData(msg.Get("changed"))
+		if err != nil {
+			jsutil.LogError("IndexedDB.Watch: failed to parse changed values: %v", err)
+			return nil
+		}
+		var removed []string
+		if err := vert.ValueOf(msg.Get("removed")).AssignTo(&removed); err != nil {
+			jsutil.LogError("IndexedDB.Watch: failed to parse removed keys: %v", err)
+			return nil
+		}
+		f(changed, removed)
+		return nil
+	})
+	c.Call("addEventListener", "message", listener)
+	return func() {
+		c.Call("removeEventListener", "message", listener)
+		c.Call("close")
+		listener.Release()
+	}
+}
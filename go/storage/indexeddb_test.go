@@ -0,0 +1,162 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"strings"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/norunners/vert"
+)
+
+func TestIndexedDBSetGetAndDelete(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		i := NewIndexedDB(st.NewIDBFactory(), "set-get-delete")
+		if err := i.Set(ctx, map[string]js.Value{
+			"num":    js.ValueOf(2),
+			"big":    js.ValueOf(strings.Repeat("a", 100000)),
+			"struct": vert.ValueOf(&myStruct{IntField: 3, StringField: "foo"}).JSValue(),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		got, err := i.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		want := map[string]string{
+			"num":    `2`,
+			"big":    `"` + strings.Repeat("a", 100000) + `"`,
+			"struct": `{"intField":3,"stringField":"foo"}`,
+		}
+		if diff := cmp.Diff(dataToJSON(got), want); diff != "" {
+			t.Errorf("incorrect data; -got +want: %s", diff)
+		}
+
+		got, err = i.GetKeys(ctx, []string{"num", "missing"})
+		if err != nil {
+			t.Fatalf("GetKeys failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{"num": `2`}); diff != "" {
+			t.Errorf("incorrect data from GetKeys; -got +want: %s", diff)
+		}
+
+		if err := i.Delete(ctx, []string{"big", "struct"}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		got, err = i.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{"num": `2`}); diff != "" {
+			t.Errorf("incorrect data after Delete; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestIndexedDBPersistence(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		factory := st.NewIDBFactory()
+		if err := NewIndexedDB(factory, "persistence").Set(ctx, map[string]js.Value{
+			"key": js.ValueOf("value"),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		// A new connection to the same database observes the data.
+		got, err := NewIndexedDB(factory, "persistence").Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{"key": `"value"`}); diff != "" {
+			t.Errorf("incorrect data; -got +want: %s", diff)
+		}
+
+		// A different database does not.
+		got, err = NewIndexedDB(factory, "other").Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{}); diff != "" {
+			t.Errorf("incorrect data in other database; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestIndexedDBBytesInUse(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		i := NewIndexedDB(st.NewIDBFactory(), "bytes-in-use")
+		if err := i.Set(ctx, map[string]js.Value{
+			"key1": js.ValueOf("value"),
+			"key2": js.ValueOf(2),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		got, err := i.BytesInUse(ctx, []string{"key1", "missing"})
+		if err != nil {
+			t.Fatalf("BytesInUse failed: %v", err)
+		}
+		if want := len("key1") + len(`"value"`); got != want {
+			t.Errorf("incorrect bytes in use; got %d, want %d", got, want)
+		}
+	})
+}
+
+func TestIndexedDBWatch(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		factory := st.NewIDBFactory()
+		writer := NewIndexedDB(factory, "watch")
+		// Changes are observed by other connections, such as those in
+		// other extension pages.
+		events, cleanup := watchEvents(NewIndexedDB(factory, "watch"))
+		defer cleanup()
+
+		if err := writer.Set(ctx, map[string]js.Value{
+			"key": js.ValueOf(2),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		want := &watchEvent{
+			changed: map[string]string{"key": `2`},
+		}
+		if diff := cmp.Diff(nextEvent(events), want, cmp.AllowUnexported(watchEvent{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect event after set: -got +want: %s", diff)
+		}
+
+		if err := writer.Delete(ctx, []string{"key"}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		want = &watchEvent{
+			removed: []string{"key"},
+		}
+		if diff := cmp.Diff(nextEvent(events), want, cmp.AllowUnexported(watchEvent{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect event after delete: -got +want: %s", diff)
+		}
+	})
+}
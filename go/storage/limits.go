@@ -0,0 +1,72 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"syscall/js"
+)
+
+// Limits are the quotas enforced by a storage area. A value of 0 indicates
+// that the storage area does not enforce the quota.
+type Limits struct {
+	// QuotaBytes is the maximum amount of data that may be stored.
+	QuotaBytes int
+	// QuotaBytesPerItem is the maximum size of an individual item.
+	QuotaBytesPerItem int
+	// MaxItems is the maximum number of items that may be stored.
+	MaxItems int
+}
+
+const (
+	// fallbackMaxItemBytes is the maximum item size used when a storage
+	// area reports no limits at all. This matches the per-item quota for
+	// chrome.storage.sync.
+	fallbackMaxItemBytes = 8192
+)
+
+// ReadLimits returns the limits reported by an object implementing the
+// StorageArea API. See:
+//
+//	https://developer.chrome.com/docs/extensions/reference/storage/#property-sync
+func ReadLimits(area js.Value) Limits {
+	readInt := func(name string) int {
+		v := area.Get(name)
+		if v.Type() != js.TypeNumber {
+			return 0
+		}
+		return v.Int()
+	}
+	return Limits{
+		QuotaBytes:        readInt(QuotaBytes),
+		QuotaBytesPerItem: readInt(QuotaBytesPerItem),
+		MaxItems:          readInt(QuotaMaxItems),
+	}
+}
+
+// MaxItemBytes returns the maximum size of an item to use when splitting
+// values. chrome.storage.local and chrome.storage.session report no per-item
+// limit, in which case the overall quota is used.
+func (l Limits) MaxItemBytes() int {
+	switch {
+	case l.QuotaBytesPerItem > 0:
+		return l.QuotaBytesPerItem
+	case l.QuotaBytes > 0:
+		return l.QuotaBytes
+	default:
+		return fallbackMaxItemBytes
+	}
+}
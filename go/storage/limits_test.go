@@ -0,0 +1,77 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"syscall/js"
+	"testing"
+
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadLimits(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description      string
+		area             js.Value
+		want             Limits
+		wantMaxItemBytes int
+	}{
+		{
+			description: "sync-like area",
+			area:        st.NewQuotaMemArea(102400, 8192, 512),
+			want: Limits{
+				QuotaBytes:        102400,
+				QuotaBytesPerItem: 8192,
+				MaxItems:          512,
+			},
+			wantMaxItemBytes: 8192,
+		},
+		{
+			description: "local-like area",
+			area:        st.NewQuotaMemArea(10485760, 0, 0),
+			want: Limits{
+				QuotaBytes: 10485760,
+			},
+			wantMaxItemBytes: 10485760,
+		},
+		{
+			description:      "no limits",
+			area:             st.NewMemArea(),
+			want:             Limits{},
+			wantMaxItemBytes: fallbackMaxItemBytes,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			got := ReadLimits(tc.area)
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("incorrect limits; -got +want: %s", diff)
+			}
+			if got := got.MaxItemBytes(); got != tc.wantMaxItemBytes {
+				t.Errorf("incorrect max item bytes; got %d, want %d", got, tc.wantMaxItemBytes)
+			}
+			if got := NewBigAuto(tc.area).itemLimit(); got != tc.wantMaxItemBytes {
+				t.Errorf("incorrect max item bytes for Big; got %d, want %d", got, tc.wantMaxItemBytes)
+			}
+		})
+	}
+}
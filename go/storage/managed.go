@@ -0,0 +1,220 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// ErrReadOnly indicates that a write was attempted to a read-only area.
+var ErrReadOnly = errors.New("storage area is read-only")
+
+// Managed supports retrieving data configured by an administrator (e.g., via
+// enterprise policy). Data can be read, but not written; Set and Delete always
+// fail with ErrReadOnly.
+//
+// Managed implements the Area interface.
+type Managed struct {
+	s Area
+}
+
+// NewManaged returns a Managed for the specified area. The specified area
+// must point to an object implementing the StorageArea API (typically
+// chrome.storage.managed).
+func NewManaged(area js.Value) *Managed {
+	return &Managed{s: NewRaw(area)}
+}
+
+// Set implements Area.Set().
+func (m *Managed) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	return ErrReadOnly
+}
+
+// Get implements Area.Get().
+func (m *Managed) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	return m.s.Get(ctx)
+}
+
+// GetKeys implements Area.GetKeys().
+func (m *Managed) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	return m.s.GetKeys(ctx, keys)
+}
+
+// BytesInUse implements Area.BytesInUse().
+func (m *Managed) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	return m.s.BytesInUse(ctx, keys)
+}
+
+// Delete implements Area.Delete().
+func (m *Managed) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	return ErrReadOnly
+}
+
+// Watch implements Area.Watch().
+func (m *Managed) Watch(f WatchFunc) jsutil.CleanupFunc {
+	return m.s.Watch(f)
+}
+
+// Overlay presents a merged view of managed values layered over user values.
+// Where a key is present in both, the managed value takes precedence; this
+// allows an administrator to enforce particular settings.
+//
+// Writes are applied to the user values. A write to a key that is also
+// managed succeeds, but remains hidden for as long as the key is managed.
+//
+// Overlay implements the Area interface.
+type Overlay struct {
+	managed Area
+	user    Area
+}
+
+// NewOverlay returns an Overlay of managed values over user values.
+func NewOverlay(managed, user Area) *Overlay {
+	return &Overlay{
+		managed: managed,
+		user:    user,
+	}
+}
+
+// merge overlays managed values over user values.
+func merge(managed, user map[string]js.Value) map[string]js.Value {
+	data := map[string]js.Value{}
+	for k, v := range user {
+		data[k] = v
+	}
+	for k, v := range managed {
+		data[k] = v
+	}
+	return data
+}
+
+// Set implements Area.Set().
+func (o *Overlay) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	return o.user.Set(ctx, data)
+}
+
+// Get implements Area.Get().
+func (o *Overlay) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	managed, err := o.managed.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := o.user.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return merge(managed, user), nil
+}
+
+// GetKeys implements Area.GetKeys().
+func (o *Overlay) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	managed, err := o.managed.GetKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	user, err := o.user.GetKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	return merge(managed, user), nil
+}
+
+// BytesInUse implements Area.BytesInUse().
+//
+// Only user values are counted; managed values do not count against the
+// user's quota.
+func (o *Overlay) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	return o.user.BytesInUse(ctx, keys)
+}
+
+// Delete implements Area.Delete().
+func (o *Overlay) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	return o.user.Delete(ctx, keys)
+}
+
+// Watch implements Area.Watch().
+//
+// Changes to managed values are always reported. If a managed value is
+// removed, the user value (if any) is reported in its place. Changes to user
+// values are reported only if the key is not managed.
+func (o *Overlay) Watch(f WatchFunc) jsutil.CleanupFunc {
+	var cleanup jsutil.CleanupFuncs
+	cleanup.Add(o.managed.Watch(func(changed map[string]js.Value, removed []string) {
+		if len(removed) == 0 {
+			o.notify(f, changed, nil)
+			return
+		}
+		jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+			// Keys no longer managed revert to the user value.
+			user, err := o.user.GetKeys(ctx, removed)
+			if err != nil {
+				jsutil.LogError("Overlay.Watch: failed to read user values: %v", err)
+				return js.Undefined(), nil
+			}
+			o.notify(f, merge(changed, user), removed)
+			return js.Undefined(), nil
+		})
+	}))
+	cleanup.Add(o.user.Watch(func(changed map[string]js.Value, removed []string) {
+		jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+			var keys []string
+			for k := range changed {
+				keys = append(keys, k)
+			}
+			keys = append(keys, removed...)
+			// Changes to managed keys are hidden.
+			managed, err := o.managed.GetKeys(ctx, keys)
+			if err != nil {
+				jsutil.LogError("Overlay.Watch: failed to read managed values: %v", err)
+				return js.Undefined(), nil
+			}
+			nchanged := map[string]js.Value{}
+			for k, v := range changed {
+				if _, ok := managed[k]; !ok {
+					nchanged[k] = v
+				}
+			}
+			var nremoved []string
+			for _, k := range removed {
+				if _, ok := managed[k]; !ok {
+					nremoved = append(nremoved, k)
+				}
+			}
+			o.notify(f, nchanged, nremoved)
+			return js.Undefined(), nil
+		})
+	}))
+	return cleanup.Do
+}
+
+// notify invokes f, unless there is nothing to report. Keys that are both
+// changed and removed are reported as changed.
+func (o *Overlay) notify(f WatchFunc, changed map[string]js.Value, removed []string) {
+	var nremoved []string
+	for _, k := range removed {
+		if _, ok := changed[k]; !ok {
+			nremoved = append(nremoved, k)
+		}
+	}
+	if len(changed) == 0 && len(nremoved) == 0 {
+		return
+	}
+	f(changed, nremoved)
+}
@@ -0,0 +1,192 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestManagedReadOnly(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		area := st.NewMemArea()
+		// The administrator configures a value.
+		if err := NewRaw(area).Set(ctx, map[string]js.Value{
+			"policy": js.ValueOf("value"),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		m := NewManaged(area)
+		got, err := m.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), map[string]string{"policy": `"value"`}); diff != "" {
+			t.Errorf("incorrect data; -got +want: %s", diff)
+		}
+
+		if err := m.Set(ctx, map[string]js.Value{"key": js.ValueOf(1)}); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("incorrect error from Set; got %v, want %v", err, ErrReadOnly)
+		}
+		if err := m.Delete(ctx, []string{"policy"}); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("incorrect error from Delete; got %v, want %v", err, ErrReadOnly)
+		}
+	})
+}
+
+func TestOverlay(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		managed := st.NewMemArea()
+		if err := NewRaw(managed).Set(ctx, map[string]js.Value{
+			"shared":      js.ValueOf("managed"),
+			"managedOnly": js.ValueOf("managed"),
+		}); err != nil {
+			t.Fatalf("Set failed for managed area: %v", err)
+		}
+
+		o := NewOverlay(NewManaged(managed), NewRaw(st.NewMemArea()))
+		if err := o.Set(ctx, map[string]js.Value{
+			"shared":   js.ValueOf("user"),
+			"userOnly": js.ValueOf("user"),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		got, err := o.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		want := map[string]string{
+			"shared":      `"managed"`,
+			"managedOnly": `"managed"`,
+			"userOnly":    `"user"`,
+		}
+		if diff := cmp.Diff(dataToJSON(got), want); diff != "" {
+			t.Errorf("incorrect data; -got +want: %s", diff)
+		}
+
+		got, err = o.GetKeys(ctx, []string{"shared", "userOnly"})
+		if err != nil {
+			t.Fatalf("GetKeys failed: %v", err)
+		}
+		want = map[string]string{
+			"shared":   `"managed"`,
+			"userOnly": `"user"`,
+		}
+		if diff := cmp.Diff(dataToJSON(got), want); diff != "" {
+			t.Errorf("incorrect data from GetKeys; -got +want: %s", diff)
+		}
+
+		// Deleting a managed key removes only the user value.
+		if err := o.Delete(ctx, []string{"shared", "userOnly"}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		got, err = o.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		want = map[string]string{
+			"shared":      `"managed"`,
+			"managedOnly": `"managed"`,
+		}
+		if diff := cmp.Diff(dataToJSON(got), want); diff != "" {
+			t.Errorf("incorrect data after Delete; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestOverlayWatch(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		managedArea := st.NewQuotaMemArea(0, 0, 0)
+		admin := NewRaw(managedArea)
+		user := NewRaw(st.NewQuotaMemArea(0, 0, 0))
+		if err := admin.Set(ctx, map[string]js.Value{
+			"shared": js.ValueOf("managed"),
+		}); err != nil {
+			t.Fatalf("Set failed for managed area: %v", err)
+		}
+		if err := user.Set(ctx, map[string]js.Value{
+			"shared": js.ValueOf("user"),
+		}); err != nil {
+			t.Fatalf("Set failed for user area: %v", err)
+		}
+		o := NewOverlay(NewManaged(managedArea), user)
+		// Allow notifications for the above writes to be delivered before
+		// we start watching.
+		time.Sleep(50 * time.Millisecond)
+		events, cleanup := watchEvents(o)
+		defer cleanup()
+
+		// Changes to a managed key by the user are hidden.
+		if err := o.Set(ctx, map[string]js.Value{
+			"shared": js.ValueOf("user2"),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if ev := nextEvent(events); ev != nil {
+			t.Errorf("unexpected event for managed key: %+v", ev)
+		}
+
+		// Changes by the administrator are reported.
+		if err := admin.Set(ctx, map[string]js.Value{
+			"shared": js.ValueOf("managed2"),
+		}); err != nil {
+			t.Fatalf("Set failed for managed area: %v", err)
+		}
+		want := &watchEvent{
+			changed: map[string]string{"shared": `"managed2"`},
+		}
+		if diff := cmp.Diff(nextEvent(events), want, cmp.AllowUnexported(watchEvent{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect event after policy update: -got +want: %s", diff)
+		}
+
+		// When the policy is removed, the user value is revealed.
+		if err := admin.Delete(ctx, []string{"shared"}); err != nil {
+			t.Fatalf("Delete failed for managed area: %v", err)
+		}
+		want = &watchEvent{
+			changed: map[string]string{"shared": `"user2"`},
+		}
+		if diff := cmp.Diff(nextEvent(events), want, cmp.AllowUnexported(watchEvent{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect event after policy removal: -got +want: %s", diff)
+		}
+
+		// Changes to keys that are not managed are reported.
+		if err := o.Delete(ctx, []string{"shared"}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		want = &watchEvent{
+			removed: []string{"shared"},
+		}
+		if diff := cmp.Diff(nextEvent(events), want, cmp.AllowUnexported(watchEvent{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect event after user delete: -got +want: %s", diff)
+		}
+	})
+}
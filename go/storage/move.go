@@ -0,0 +1,71 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// ErrVerifyFailed indicates that values written to the destination of a move
+// could not be read back intact.
+var ErrVerifyFailed = errors.New("moved values failed verification")
+
+// MoveKeys moves the values for the specified keys from src to dst. Values
+// are read from src as returned by the area (for Big, reassembled values), so
+// they are split according to the destination's own limits when written.
+//
+// Values are only deleted from src once they have been written to dst and
+// successfully read back. If MoveKeys is interrupted or fails, the values
+// therefore remain in src, and MoveKeys may simply be invoked again. Keys
+// that are not present in src (e.g., because they were already moved) are
+// ignored.
+func MoveKeys(ctx jsutil.AsyncContext, src, dst Area, keys []string) error {
+	data, err := src.GetKeys(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("failed to read source: %w", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := dst.Set(ctx, data); err != nil {
+		return fmt.Errorf("failed to write destination: %w", err)
+	}
+
+	var present []string
+	for k := range data {
+		present = append(present, k)
+	}
+	written, err := dst.GetKeys(ctx, present)
+	if err != nil {
+		return fmt.Errorf("failed to read back destination: %w", err)
+	}
+	for k, v := range data {
+		w, ok := written[k]
+		if !ok || jsutil.ToJSON(w) != jsutil.ToJSON(v) {
+			return fmt.Errorf("%w: key %s", ErrVerifyFailed, k)
+		}
+	}
+
+	if err := src.Delete(ctx, present); err != nil {
+		return fmt.Errorf("failed to delete from source: %w", err)
+	}
+	return nil
+}
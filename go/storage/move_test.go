@@ -0,0 +1,183 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// corruptingArea wraps an Area, returning corrupted values from GetKeys.
+type corruptingArea struct {
+	Area
+}
+
+func (c *corruptingArea) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	data, err := c.Area.GetKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for k := range data {
+		data[k] = js.ValueOf("corrupt")
+	}
+	return data, nil
+}
+
+func TestMoveKeys(t *testing.T) {
+	t.Parallel()
+
+	big := strings.Repeat("a", 500)
+
+	testcases := []struct {
+		description  string
+		dstExisting  map[string]js.Value
+		keys         []string
+		writeFailure bool
+		corrupt      bool
+		wantErr      error
+		wantSrc      map[string]string
+		wantDst      map[string]string
+	}{
+		{
+			description: "move subset",
+			keys:        []string{"big", "small", "missing"},
+			wantSrc: map[string]string{
+				"other": "3",
+			},
+			wantDst: map[string]string{
+				"big":   fmt.Sprintf(`"%s"`, big),
+				"small": "2",
+			},
+		},
+		{
+			description: "resume after destination written",
+			dstExisting: map[string]js.Value{
+				"small": js.ValueOf(2),
+			},
+			keys: []string{"small"},
+			wantSrc: map[string]string{
+				"big":   fmt.Sprintf(`"%s"`, big),
+				"other": "3",
+			},
+			wantDst: map[string]string{
+				"small": "2",
+			},
+		},
+		{
+			description: "source overrides stale destination",
+			dstExisting: map[string]js.Value{
+				"small": js.ValueOf(1),
+			},
+			keys: []string{"small"},
+			wantSrc: map[string]string{
+				"big":   fmt.Sprintf(`"%s"`, big),
+				"other": "3",
+			},
+			wantDst: map[string]string{
+				"small": "2",
+			},
+		},
+		{
+			description:  "write failure retains source",
+			keys:         []string{"big", "small"},
+			writeFailure: true,
+			wantErr:      ErrQuotaExceeded,
+			wantSrc: map[string]string{
+				"big":   fmt.Sprintf(`"%s"`, big),
+				"small": "2",
+				"other": "3",
+			},
+		},
+		{
+			description: "verification failure retains source",
+			keys:        []string{"big", "small"},
+			corrupt:     true,
+			wantErr:     ErrVerifyFailed,
+			wantSrc: map[string]string{
+				"big":   fmt.Sprintf(`"%s"`, big),
+				"small": "2",
+				"other": "3",
+			},
+			wantDst: map[string]string{
+				"big":   fmt.Sprintf(`"%s"`, big),
+				"small": "2",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				src := NewBig(200, NewRaw(st.NewMemArea()))
+				if err := src.Set(ctx, map[string]js.Value{
+					"big":   js.ValueOf(big),
+					"small": js.ValueOf(2),
+					"other": js.ValueOf(3),
+				}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				// The destination uses a different item size, so
+				// values must be re-split.
+				area := st.NewQuotaMemArea(0, 0, 0)
+				dst := NewBig(300, newRawNoRetry(area))
+				if tc.dstExisting != nil {
+					if err := dst.Set(ctx, tc.dstExisting); err != nil {
+						t.Fatalf("Set failed: %v", err)
+					}
+				}
+
+				var moveDst Area = dst
+				if tc.corrupt {
+					moveDst = &corruptingArea{Area: dst}
+				}
+				if tc.writeFailure {
+					st.FailWrites(area, 1, "QUOTA_BYTES quota exceeded")
+				}
+				err := MoveKeys(ctx, src, moveDst, tc.keys)
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("incorrect error; got %v, want %v", err, tc.wantErr)
+				}
+
+				gotSrc, err := st.Get(ctx, src)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				if diff := cmp.Diff(gotSrc, tc.wantSrc, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("incorrect source data: -got +want: %s", diff)
+				}
+				gotDst, err := st.Get(ctx, dst)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				if diff := cmp.Diff(gotDst, tc.wantDst, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("incorrect destination data: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,251 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sort"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// OpType identifies the type of a storage operation.
+type OpType string
+
+// Operation types are named after the corresponding Area methods.
+const (
+	OpSet        OpType = "Set"
+	OpGet        OpType = "Get"
+	OpGetKeys    OpType = "GetKeys"
+	OpBytesInUse OpType = "BytesInUse"
+	OpDelete     OpType = "Delete"
+)
+
+// OpInfo describes a storage operation.
+type OpInfo struct {
+	// Op is the type of operation.
+	Op OpType
+	// Keys is the number of keys written, requested or deleted. For Get,
+	// it is the number of keys read.
+	Keys int
+	// Parents are the logical keys affected by the operation. Operations
+	// on chunks are attributed to the keys whose values they belong to.
+	Parents []string
+	// Bytes is the size of the values written or read, in the same manner
+	// as it is counted against quotas. It is only known for Set when the
+	// operation starts, and for Get and GetKeys when it ends.
+	Bytes int
+	// Duration is the time taken by the operation. It is only set when
+	// the operation ends.
+	Duration time.Duration
+	// Err is the error returned by the operation, if any. It is only set
+	// when the operation ends.
+	Err error
+}
+
+// Observer is notified of storage operations; see NewObserved and
+// Big.WithObserver.
+type Observer interface {
+	// OnOpStart is invoked before an operation is started.
+	OnOpStart(op OpInfo)
+	// OnOpEnd is invoked after an operation completes.
+	OnOpEnd(op OpInfo)
+}
+
+// LogObserver is an Observer that logs each completed operation.
+type LogObserver struct{}
+
+// OnOpStart implements Observer.OnOpStart().
+func (LogObserver) OnOpStart(op OpInfo) {}
+
+// OnOpEnd implements Observer.OnOpEnd().
+func (LogObserver) OnOpEnd(op OpInfo) {
+	if op.Err != nil {
+		jsutil.LogError("Storage.%s: %d keys for %v failed after %s: %v", op.Op, op.Keys, op.Parents, op.Duration, op.Err)
+		return
+	}
+	jsutil.LogDebug("Storage.%s: %d keys (%d bytes) for %v took %s", op.Op, op.Keys, op.Bytes, op.Parents, op.Duration)
+}
+
+// Observed notifies an Observer of all operations on an underlying area.
+//
+// Observed learns which chunks belong to which values from the manifests that
+// it encounters, such that operations on chunks are attributed to the values
+// they belong to. Where this is needed for chunks before their manifests are
+// written, use Big.WithObserver instead.
+//
+// Observed implements the Area interface.
+type Observed struct {
+	s Area
+	o Observer
+
+	mu sync.Mutex
+	// parents maps each known chunk key to the keys whose manifests
+	// reference it (or referenced it, up until the chunk is deleted).
+	parents map[string]map[string]bool
+}
+
+// NewObserved returns an Observed that notifies o of operations on store.
+// Since observing operations has a cost, store should only be wrapped when
+// an observer is required.
+func NewObserved(store Area, o Observer) *Observed {
+	return &Observed{
+		s:       store,
+		o:       o,
+		parents: map[string]map[string]bool{},
+	}
+}
+
+// learn records the chunks referenced by manifests in data.
+func (ob *Observed) learn(data map[string]js.Value) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	for k, v := range data {
+		if isChunkKey(k) {
+			continue
+		}
+		if manifest, ok := readManifest(v); ok {
+			for _, chunkKey := range manifest.ChunkKeys {
+				if ob.parents[chunkKey] == nil {
+					ob.parents[chunkKey] = map[string]bool{}
+				}
+				ob.parents[chunkKey][k] = true
+			}
+		}
+	}
+}
+
+// forget discards what is known about chunks that were deleted.
+func (ob *Observed) forget(keys []string) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	for _, k := range keys {
+		delete(ob.parents, k)
+	}
+}
+
+// parentsOf returns the logical keys to which the keys belong.
+func (ob *Observed) parentsOf(keys []string) []string {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	uniq := map[string]bool{}
+	for _, k := range keys {
+		if !isChunkKey(k) {
+			uniq[k] = true
+			continue
+		}
+		for p := range ob.parents[k] {
+			uniq[p] = true
+		}
+	}
+	var res []string
+	for k := range uniq {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// start notifies the observer that an operation is starting, returning a
+// function to be invoked when it ends.
+func (ob *Observed) start(op OpInfo) func(op OpInfo) {
+	ob.o.OnOpStart(op)
+	start := time.Now()
+	return func(op OpInfo) {
+		op.Duration = time.Since(start)
+		ob.o.OnOpEnd(op)
+	}
+}
+
+// dataKeys returns the keys in data.
+func dataKeys(data map[string]js.Value) []string {
+	var keys []string
+	for k := range data {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// dataSize returns the size of data, in the same manner as it is counted
+// against quotas.
+func dataSize(data map[string]js.Value) int {
+	var n int
+	for k, v := range data {
+		n += storedSize(k, jsutil.ToJSON(v))
+	}
+	return n
+}
+
+// Set implements Area.Set().
+func (ob *Observed) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	ob.learn(data)
+	op := OpInfo{Op: OpSet, Keys: len(data), Parents: ob.parentsOf(dataKeys(data)), Bytes: dataSize(data)}
+	end := ob.start(op)
+	op.Err = ob.s.Set(ctx, data)
+	end(op)
+	return op.Err
+}
+
+// Get implements Area.Get().
+func (ob *Observed) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	end := ob.start(OpInfo{Op: OpGet})
+	data, err := ob.s.Get(ctx)
+	ob.learn(data)
+	end(OpInfo{Op: OpGet, Keys: len(data), Parents: ob.parentsOf(dataKeys(data)), Bytes: dataSize(data), Err: err})
+	return data, err
+}
+
+// GetKeys implements Area.GetKeys().
+func (ob *Observed) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	op := OpInfo{Op: OpGetKeys, Keys: len(keys), Parents: ob.parentsOf(keys)}
+	end := ob.start(op)
+	data, err := ob.s.GetKeys(ctx, keys)
+	ob.learn(data)
+	op.Bytes = dataSize(data)
+	op.Err = err
+	end(op)
+	return data, err
+}
+
+// BytesInUse implements Area.BytesInUse().
+func (ob *Observed) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	op := OpInfo{Op: OpBytesInUse, Keys: len(keys), Parents: ob.parentsOf(keys)}
+	end := ob.start(op)
+	n, err := ob.s.BytesInUse(ctx, keys)
+	op.Err = err
+	end(op)
+	return n, err
+}
+
+// Delete implements Area.Delete().
+func (ob *Observed) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	op := OpInfo{Op: OpDelete, Keys: len(keys), Parents: ob.parentsOf(keys)}
+	end := ob.start(op)
+	op.Err = ob.s.Delete(ctx, keys)
+	if op.Err == nil {
+		ob.forget(keys)
+	}
+	end(op)
+	return op.Err
+}
+
+// Watch implements Area.Watch().
+func (ob *Observed) Watch(f WatchFunc) jsutil.CleanupFunc {
+	return ob.s.Watch(f)
+}
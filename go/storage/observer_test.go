@@ -0,0 +1,148 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"strings"
+	"sync"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// recordingObserver records completed operations.
+type recordingObserver struct {
+	mu      sync.Mutex
+	started int
+	ops     []OpInfo
+}
+
+func (r *recordingObserver) OnOpStart(op OpInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started++
+}
+
+func (r *recordingObserver) OnOpEnd(op OpInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+}
+
+// take returns the recorded operations, and resets the recording.
+func (r *recordingObserver) take() []OpInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ops := r.ops
+	r.ops = nil
+	return ops
+}
+
+// opsDiff compares operations, ignoring fields that vary.
+func opsDiff(got, want []OpInfo) string {
+	return cmp.Diff(got, want, cmpopts.IgnoreFields(OpInfo{}, "Bytes", "Duration"), cmpopts.EquateEmpty(), cmpopts.EquateErrors())
+}
+
+func TestObservedBig(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		obs := &recordingObserver{}
+		b := NewBig(200, NewRaw(st.NewMemArea())).WithObserver(obs)
+
+		// Chunks are attributed to the value they belong to, even
+		// though they are written before the manifest.
+		if err := b.Set(ctx, map[string]js.Value{
+			"myString": js.ValueOf(strings.Repeat("a", 200)),
+			"myNumber": js.ValueOf(2),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		want := []OpInfo{
+			{Op: OpGetKeys, Keys: 5, Parents: []string{"myNumber", "myString"}},
+			{Op: OpSet, Keys: 3, Parents: []string{"myString"}},
+			{Op: OpSet, Keys: 2, Parents: []string{"myNumber", "myString"}},
+		}
+		if diff := opsDiff(obs.take(), want); diff != "" {
+			t.Errorf("incorrect operations for Set; -got +want: %s", diff)
+		}
+
+		if _, err := b.GetKeys(ctx, []string{"myString"}); err != nil {
+			t.Fatalf("GetKeys failed: %v", err)
+		}
+		want = []OpInfo{
+			{Op: OpGetKeys, Keys: 1, Parents: []string{"myString"}},
+			{Op: OpGetKeys, Keys: 3, Parents: []string{"myString"}},
+		}
+		got := obs.take()
+		if diff := opsDiff(got, want); diff != "" {
+			t.Errorf("incorrect operations for GetKeys; -got +want: %s", diff)
+		}
+		for _, op := range got {
+			if op.Bytes == 0 {
+				t.Errorf("%s of %v reported no bytes read", op.Op, op.Parents)
+			}
+		}
+
+		// Removal of chunks is attributed to the value they belonged
+		// to.
+		if err := b.Delete(ctx, []string{"myString"}); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		want = []OpInfo{
+			{Op: OpDelete, Keys: 1, Parents: []string{"myString"}},
+			// Cleanup reads the remaining value, and the chunks
+			// left behind.
+			{Op: OpGet, Keys: 4, Parents: []string{"myNumber", "myString"}},
+			{Op: OpDelete, Keys: 3, Parents: []string{"myString"}},
+		}
+		if diff := opsDiff(obs.take(), want); diff != "" {
+			t.Errorf("incorrect operations for Delete; -got +want: %s", diff)
+		}
+
+		obs.mu.Lock()
+		defer obs.mu.Unlock()
+		if obs.started != 8 {
+			t.Errorf("incorrect number of operations started; got %d, want 8", obs.started)
+		}
+	})
+}
+
+func TestObservedErrors(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		area := st.NewQuotaMemArea(0, 0, 0)
+		obs := &recordingObserver{}
+		o := NewObserved(newRawNoRetry(area), obs)
+
+		st.FailWrites(area, 1, "QUOTA_BYTES quota exceeded")
+		err := o.Set(ctx, map[string]js.Value{"myNumber": js.ValueOf(2)})
+		if err == nil {
+			t.Fatalf("Set unexpectedly succeeded")
+		}
+		want := []OpInfo{
+			{Op: OpSet, Keys: 1, Parents: []string{"myNumber"}, Err: err},
+		}
+		if diff := opsDiff(obs.take(), want); diff != "" {
+			t.Errorf("incorrect operations; -got +want: %s", diff)
+		}
+	})
+}
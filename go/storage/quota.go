@@ -0,0 +1,139 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// ErrQuotaExceeded indicates that a write was rejected because it would exceed
+// one of the storage area's quotas. Use errors.As with *QuotaError for details.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Names of the quotas enforced by Chrome's Storage API. These appear in the
+// error message when a write is rejected. See:
+//
+//	https://developer.chrome.com/docs/extensions/reference/storage/#property-sync
+const (
+	QuotaBytes        = "QUOTA_BYTES"
+	QuotaBytesPerItem = "QUOTA_BYTES_PER_ITEM"
+	QuotaMaxItems     = "MAX_ITEMS"
+
+	QuotaMaxWriteOperationsPerMinute = "MAX_WRITE_OPERATIONS_PER_MINUTE"
+	QuotaMaxWriteOperationsPerHour   = "MAX_WRITE_OPERATIONS_PER_HOUR"
+)
+
+// QuotaError describes a write that was rejected due to a storage quota.
+type QuotaError struct {
+	// Quota is the name of the quota that was exceeded (e.g.,
+	// QuotaBytesPerItem).
+	Quota string
+	// PerItem indicates that a single item exceeded the per-item quota, as
+	// opposed to the storage area's total quota.
+	PerItem bool
+	// BytesInUse is the number of bytes in use in the storage area at the
+	// time of failure, or -1 if it could not be determined.
+	BytesInUse int
+	// BytesAttempted is the approximate number of bytes that the rejected
+	// write attempted to store.
+	BytesAttempted int
+	// Err is the original error returned by the Storage API.
+	Err error
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s quota exceeded: attempted to write %d bytes with %d bytes in use: %v", e.Quota, e.BytesAttempted, e.BytesInUse, e.Err)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+func (e *QuotaError) Unwrap() error {
+	return e.Err
+}
+
+// quotaNames are the quotas we detect in error messages. Longer names with a
+// common prefix must appear first.
+var quotaNames = []string{
+	QuotaBytesPerItem,
+	QuotaBytes,
+	QuotaMaxItems,
+	QuotaMaxWriteOperationsPerMinute,
+	QuotaMaxWriteOperationsPerHour,
+}
+
+// parseQuotaName returns the name of the quota referenced by the error message,
+// if any.
+func parseQuotaName(err error) (string, bool) {
+	msg := err.Error()
+	if !strings.Contains(msg, "quota exceeded") {
+		return "", false
+	}
+	for _, q := range quotaNames {
+		if strings.Contains(msg, q) {
+			return q, true
+		}
+	}
+	return "", false
+}
+
+// dataBytes returns the approximate number of bytes required to store data.
+// This mirrors Chrome's computation: the length of the key plus the length
+// of the JSON-stringified value.
+func dataBytes(data map[string]js.Value) int {
+	var n int
+	for k, v := range data {
+		n += len(k) + len(jsutil.ToJSON(v))
+	}
+	return n
+}
+
+// totalBytesInUse queries the number of bytes in use by the storage area. -1
+// is returned if it cannot be determined.
+func (r *Raw) totalBytesInUse(ctx jsutil.AsyncContext) int {
+	if r.o.Get("getBytesInUse").Type() != js.TypeFunction {
+		return -1
+	}
+	val, err := jsutil.AsPromise(r.o.Call("getBytesInUse", js.Null())).Await(ctx)
+	if err != nil {
+		jsutil.LogError("RawStorage: failed to query bytes in use: %v", err)
+		return -1
+	}
+	return val.Int()
+}
+
+// asQuotaError converts err to a *QuotaError if it indicates that a quota was
+// exceeded. Otherwise, err is returned unchanged.
+func (r *Raw) asQuotaError(ctx jsutil.AsyncContext, err error, data map[string]js.Value) error {
+	quota, ok := parseQuotaName(err)
+	if !ok {
+		return err
+	}
+	return &QuotaError{
+		Quota:          quota,
+		PerItem:        quota == QuotaBytesPerItem,
+		BytesInUse:     r.totalBytesInUse(ctx),
+		BytesAttempted: dataBytes(data),
+		Err:            err,
+	}
+}
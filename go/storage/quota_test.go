@@ -0,0 +1,125 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"strings"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestQuotaExceeded(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description       string
+		quotaBytes        int
+		quotaBytesPerItem int
+		maxItems          int
+		init              map[string]js.Value
+		set               map[string]js.Value
+		want              *QuotaError
+	}{
+		{
+			description:       "per-item quota",
+			quotaBytesPerItem: 20,
+			set: map[string]js.Value{
+				"key": js.ValueOf(strings.Repeat("a", 20)),
+			},
+			want: &QuotaError{
+				Quota:          QuotaBytesPerItem,
+				PerItem:        true,
+				BytesInUse:     0,
+				BytesAttempted: 25,
+			},
+		},
+		{
+			description: "total quota",
+			quotaBytes:  30,
+			init: map[string]js.Value{
+				"key1": js.ValueOf(strings.Repeat("a", 10)),
+			},
+			set: map[string]js.Value{
+				"key2": js.ValueOf(strings.Repeat("a", 10)),
+			},
+			want: &QuotaError{
+				Quota:          QuotaBytes,
+				PerItem:        false,
+				BytesInUse:     16,
+				BytesAttempted: 16,
+			},
+		},
+		{
+			description: "max items",
+			maxItems:    1,
+			init: map[string]js.Value{
+				"key1": js.ValueOf(1),
+			},
+			set: map[string]js.Value{
+				"key2": js.ValueOf(2),
+			},
+			want: &QuotaError{
+				Quota:          QuotaMaxItems,
+				PerItem:        false,
+				BytesInUse:     5,
+				BytesAttempted: 5,
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				s := NewRaw(st.NewQuotaMemArea(tc.quotaBytes, tc.quotaBytesPerItem, tc.maxItems))
+				if err := s.Set(ctx, tc.init); err != nil {
+					t.Fatalf("initial Set failed: %v", err)
+				}
+
+				err := s.Set(ctx, tc.set)
+				if !errors.Is(err, ErrQuotaExceeded) {
+					t.Fatalf("incorrect error; got %v, want %v", err, ErrQuotaExceeded)
+				}
+				var got *QuotaError
+				if !errors.As(err, &got) {
+					t.Fatalf("error is not a QuotaError: %v", err)
+				}
+				if diff := cmp.Diff(got, tc.want, cmpopts.IgnoreFields(QuotaError{}, "Err")); diff != "" {
+					t.Errorf("incorrect error; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestQuotaNotExceeded(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		s := NewRaw(st.NewQuotaMemArea(100, 20, 0))
+		if err := s.Set(ctx, map[string]js.Value{"key": js.ValueOf(2)}); err != nil {
+			t.Errorf("Set failed: %v", err)
+		}
+	})
+}
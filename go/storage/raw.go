@@ -18,7 +18,9 @@ package storage
 
 import (
 	"fmt"
+	"strings"
 	"syscall/js"
+	"time"
 
 	"github.com/google/chrome-ssh-agent/go/jsutil"
 	"github.com/norunners/vert"
@@ -28,14 +30,83 @@ import (
 //
 // Raw implements the Area interface.
 type Raw struct {
-	o js.Value
+	o     js.Value
+	retry RetryPolicy
+}
+
+// RetryPolicy configures how failed storage operations are retried. Errors
+// that are not transient (e.g., quota errors or invalid arguments) are never
+// retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts for an operation.
+	// Values less than 1 are treated as 1 (i.e., no retries).
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay between retries. The delay doubles
+	// after each failed attempt until it reaches MaxBackoff.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used by NewRaw.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     1 * time.Second,
 }
 
 // NewRaw returns a Raw for storing and retrieving data.  The specified area
 // must point to an object implmenting the StorageArea API.
 func NewRaw(area js.Value) *Raw {
+	return NewRawWithRetryPolicy(area, DefaultRetryPolicy)
+}
+
+// NewRawWithRetryPolicy is the same as NewRaw, but allows the retry policy
+// to be customized.
+func NewRawWithRetryPolicy(area js.Value, policy RetryPolicy) *Raw {
 	return &Raw{
-		o: area,
+		o:     area,
+		retry: policy,
+	}
+}
+
+// nonRetryableMessages are substrings of error messages for errors that will
+// not succeed if retried.
+var nonRetryableMessages = []string{
+	"quota exceeded",
+	"Invalid",
+	"Error in invocation",
+	"TypeError",
+}
+
+// isRetryable determines if an error returned by the Storage API may be
+// transient.
+func isRetryable(err error) bool {
+	msg := err.Error()
+	for _, m := range nonRetryableMessages {
+		if strings.Contains(msg, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// call invokes a method on the underlying StorageArea, retrying transient
+// failures according to the retry policy.
+func (r *Raw) call(ctx jsutil.AsyncContext, method string, args ...any) (js.Value, error) {
+	backoff := r.retry.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		val, err := jsutil.AsPromise(r.o.Call(method, args...)).Await(ctx)
+		if err == nil || !isRetryable(err) || attempt >= r.retry.MaxAttempts {
+			return val, err
+		}
+
+		jsutil.LogDebug("RawStorage.%s: attempt %d failed: %v; retrying in %s", method, attempt, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > r.retry.MaxBackoff {
+			backoff = r.retry.MaxBackoff
+		}
 	}
 }
 
@@ -66,9 +137,9 @@ func (r *Raw) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
 	defer jsutil.LogDebug("RawStorage.Set: finished")
 
 	jsutil.LogDebug("RawStorage.Set: setting data in storage")
-	_, err := jsutil.AsPromise(r.o.Call("set", dataToValue(data))).Await(ctx)
+	_, err := r.call(ctx, "set", dataToValue(data))
 	if err != nil {
-		return fmt.Errorf("failed to set data: %w", err)
+		return fmt.Errorf("failed to set data: %w", r.asQuotaError(ctx, err, data))
 	}
 	return nil
 }
@@ -79,7 +150,7 @@ func (r *Raw) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
 	defer jsutil.LogDebug("RawStorage.Get: finished")
 
 	jsutil.LogDebug("RawStorage.Get: read data from storage")
-	val, err := jsutil.AsPromise(r.o.Call("get", js.Null())).Await(ctx)
+	val, err := r.call(ctx, "get", js.Null())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get data: %w", err)
 	}
@@ -94,6 +165,75 @@ func (r *Raw) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
 	return data, nil
 }
 
+// GetKeys implements Area.GetKeys().
+func (r *Raw) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	jsutil.LogDebug("RawStorage.GetKeys: reading %d values", len(keys))
+	defer jsutil.LogDebug("RawStorage.GetKeys: finished")
+
+	if len(keys) == 0 {
+		return map[string]js.Value{}, nil // Nothing to do.
+	}
+
+	jsutil.LogDebug("RawStorage.GetKeys: read data from storage")
+	val, err := r.call(ctx, "get", vert.ValueOf(keys).JSValue())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data: %w", err)
+	}
+
+	jsutil.LogDebug("RawStorage.GetKeys: parse data")
+	data, err := valueToData(val)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse data: %w", err)
+	}
+
+	jsutil.LogDebug("RawStorage.GetKeys: return %d values", len(data))
+	return data, nil
+}
+
+// Keys implements KeyLister.Keys(). Values are only read if the storage area
+// does not support getKeys(), which was added in Chrome 130.
+func (r *Raw) Keys(ctx jsutil.AsyncContext) ([]string, error) {
+	jsutil.LogDebug("RawStorage.Keys: reading all keys")
+	defer jsutil.LogDebug("RawStorage.Keys: finished")
+
+	if r.o.Get("getKeys").Type() != js.TypeFunction {
+		data, err := r.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return dataKeys(data), nil
+	}
+
+	val, err := r.call(ctx, "getKeys")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+	var keys []string
+	for i := 0; i < val.Length(); i++ {
+		keys = append(keys, val.Index(i).String())
+	}
+	return keys, nil
+}
+
+// BytesInUse implements Area.BytesInUse().
+func (r *Raw) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	jsutil.LogDebug("RawStorage.BytesInUse: querying %d values", len(keys))
+	defer jsutil.LogDebug("RawStorage.BytesInUse: finished")
+
+	if len(keys) == 0 {
+		return 0, nil // Nothing to do.
+	}
+	if r.o.Get("getBytesInUse").Type() != js.TypeFunction {
+		return 0, fmt.Errorf("storage area does not support getBytesInUse")
+	}
+
+	val, err := r.call(ctx, "getBytesInUse", vert.ValueOf(keys).JSValue())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get bytes in use: %w", err)
+	}
+	return val.Int(), nil
+}
+
 // Delete implements Area.Delete().
 func (r *Raw) Delete(ctx jsutil.AsyncContext, keys []string) error {
 	jsutil.LogDebug("RawStorage.Delete: deleting %d values", len(keys))
@@ -104,11 +244,42 @@ func (r *Raw) Delete(ctx jsutil.AsyncContext, keys []string) error {
 	}
 
 	jsutil.LogDebug("RawStorage.Delete: removing from storage")
-	_, err := jsutil.AsPromise(r.o.Call("remove", vert.ValueOf(keys).JSValue())).Await(ctx)
+	_, err := r.call(ctx, "remove", vert.ValueOf(keys).JSValue())
 	if err != nil {
-		return fmt.Errorf("failed to delete data: %w", err)
+		return fmt.Errorf("failed to delete data: %w", r.asQuotaError(ctx, err, nil))
 	}
 
 	jsutil.LogDebug("RawStorage.Delete: finished")
 	return nil
 }
+
+// Watch implements Area.Watch().
+func (r *Raw) Watch(f WatchFunc) jsutil.CleanupFunc {
+	onChanged := r.o.Get("onChanged")
+	listener := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		changes := jsutil.SingleArg(args)
+		keys, err := jsutil.ObjectKeys(changes)
+		if err != nil {
+			jsutil.LogError("RawStorage.Watch: failed to parse changes: %v", err)
+			return nil
+		}
+
+		changed := map[string]js.Value{}
+		var removed []string
+		for _, k := range keys {
+			newValue := changes.Get(k).Get("newValue")
+			if newValue.IsUndefined() {
+				removed = append(removed, k)
+				continue
+			}
+			changed[k] = newValue
+		}
+		f(changed, removed)
+		return nil
+	})
+	onChanged.Call("addListener", listener)
+	return func() {
+		onChanged.Call("removeListener", listener)
+		listener.Release()
+	}
+}
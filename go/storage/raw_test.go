@@ -17,6 +17,7 @@ package storage
 import (
 	"syscall/js"
 	"testing"
+	"time"
 
 	"github.com/google/chrome-ssh-agent/go/jsutil"
 	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
@@ -183,3 +184,162 @@ func TestRawDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestRawGetKeys(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		init        map[string]js.Value
+		keys        []string
+		want        map[string]js.Value
+	}{
+		{
+			description: "get subset of entries",
+			init: map[string]js.Value{
+				"key1": js.ValueOf(1),
+				"key2": js.ValueOf(2),
+				"key3": js.ValueOf(3),
+			},
+			keys: []string{"key1", "key3"},
+			want: map[string]js.Value{
+				"key1": js.ValueOf(1),
+				"key3": js.ValueOf(3),
+			},
+		},
+		{
+			description: "get missing entry",
+			init: map[string]js.Value{
+				"key": js.ValueOf(2),
+			},
+			keys: []string{"missing"},
+			want: map[string]js.Value{},
+		},
+		{
+			description: "get no entry",
+			init: map[string]js.Value{
+				"key": js.ValueOf(2),
+			},
+			keys: []string{},
+			want: map[string]js.Value{},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				s := NewRaw(st.NewMemArea())
+				if err := s.Set(ctx, tc.init); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				got, err := s.GetKeys(ctx, tc.keys)
+				if err != nil {
+					t.Fatalf("GetKeys failed: %v", err)
+				}
+				if diff := cmp.Diff(dataToJSON(got), dataToJSON(tc.want)); diff != "" {
+					t.Errorf("incorrect data; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestRawBytesInUse(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		s := NewRaw(st.NewQuotaMemArea(0, 0, 0))
+		if err := s.Set(ctx, map[string]js.Value{
+			"key1": js.ValueOf(1),
+			"key2": js.ValueOf("foo"),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		got, err := s.BytesInUse(ctx, []string{"key2", "missing"})
+		if err != nil {
+			t.Fatalf("BytesInUse failed: %v", err)
+		}
+		if want := len("key2") + len(`"foo"`); got != want {
+			t.Errorf("incorrect bytes in use; got %d, want %d", got, want)
+		}
+	})
+}
+
+func TestRawRetry(t *testing.T) {
+	t.Parallel()
+
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+	}
+
+	testcases := []struct {
+		description string
+		failures    int
+		message     string
+		wantErr     bool
+	}{
+		{
+			description: "no failures",
+		},
+		{
+			description: "transient failures",
+			failures:    2,
+			message:     "transient failure",
+		},
+		{
+			description: "too many failures",
+			failures:    3,
+			message:     "transient failure",
+			wantErr:     true,
+		},
+		{
+			description: "quota errors not retried",
+			failures:    1,
+			message:     "QUOTA_BYTES quota exceeded",
+			wantErr:     true,
+		},
+		{
+			description: "invalid arguments not retried",
+			failures:    1,
+			message:     "Invalid value for argument 1",
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				area := st.NewQuotaMemArea(0, 0, 0)
+				s := NewRawWithRetryPolicy(area, policy)
+
+				st.FailWrites(area, tc.failures, tc.message)
+				err := s.Set(ctx, map[string]js.Value{"key": js.ValueOf(2)})
+				if gotErr := err != nil; gotErr != tc.wantErr {
+					t.Errorf("incorrect error from Set; got %v, wantErr %v", err, tc.wantErr)
+				}
+
+				st.FailReads(area, tc.failures, tc.message)
+				_, err = s.Get(ctx)
+				if gotErr := err != nil; gotErr != tc.wantErr {
+					t.Errorf("incorrect error from Get; got %v, wantErr %v", err, tc.wantErr)
+				}
+
+				st.FailWrites(area, tc.failures, tc.message)
+				err = s.Delete(ctx, []string{"key"})
+				if gotErr := err != nil; gotErr != tc.wantErr {
+					t.Errorf("incorrect error from Delete; got %v, wantErr %v", err, tc.wantErr)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,322 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+const (
+	// revisionValueMagic is the magic string that we encode in values
+	// stored by Revisioned, distinguishing them from any other value that
+	// may have been stored.
+	revisionValueMagic = "5b8e2f61-0c3d-4a7b-9e14-d2a6f8c3b957"
+
+	// conflictKeyPrefix is the prefix for keys under which detected
+	// conflicts are recorded.
+	conflictKeyPrefix = "conflict-" + revisionValueMagic + ":"
+)
+
+// Conflict describes concurrent modifications of the same key on different
+// devices.
+type Conflict struct {
+	// Key is the key that was concurrently modified.
+	Key string
+	// Local is the value written on this device, which was replaced.
+	Local js.Value
+	// Remote is the value written on another device, which replaced the
+	// local value.
+	Remote js.Value
+}
+
+// ConflictFunc is invoked when a conflict is detected.
+type ConflictFunc func(c Conflict)
+
+// Revisioned stores values along with a revision number and the identifier of
+// the device that wrote them. This allows concurrent modifications on
+// different devices to be detected, rather than the last write silently
+// winning, as it does with chrome.storage.sync.
+//
+// Each write of a key increments its revision. If a change arriving from
+// another device does not have a higher revision than the value most recently
+// written on this device, the other device did not see the local write before
+// replacing it. The replaced local value is then recorded as a conflict, so
+// that the user can choose which copy to keep; see Conflicts and Resolve.
+//
+// Conflicts are only detected for values written since the Revisioned was
+// created, and while a watcher is registered using OnConflict.
+//
+// Revisioned implements the Area interface.
+type Revisioned struct {
+	s      Area
+	device string
+
+	mu sync.Mutex
+	// written holds the stored form of the values most recently written
+	// on this device, keyed by key.
+	written map[string]js.Value
+}
+
+// NewRevisioned returns a Revisioned that stores values in store. device
+// identifies the local device, and must differ between devices.
+func NewRevisioned(store Area, device string) *Revisioned {
+	return &Revisioned{
+		s:       store,
+		device:  device,
+		written: map[string]js.Value{},
+	}
+}
+
+// revision is the revision metadata associated with a stored value.
+type revision struct {
+	Rev    int
+	Device string
+}
+
+// wrapRevision returns the value to be stored for v at the specified revision.
+func wrapRevision(v js.Value, rev revision) js.Value {
+	res := jsutil.NewObject()
+	res.Set("magic", revisionValueMagic)
+	res.Set("rev", rev.Rev)
+	res.Set("device", rev.Device)
+	res.Set("value", v)
+	return res
+}
+
+// unwrapRevision returns the original value for a stored value, along with its
+// revision. Values that were not stored by Revisioned are returned unmodified
+// at revision 0.
+func unwrapRevision(v js.Value) (js.Value, revision) {
+	if v.Type() != js.TypeObject || v.Get("magic").Type() != js.TypeString || v.Get("magic").String() != revisionValueMagic {
+		return v, revision{}
+	}
+	return v.Get("value"), revision{
+		Rev:    v.Get("rev").Int(),
+		Device: v.Get("device").String(),
+	}
+}
+
+// isConflictKey returns true if key records a conflict.
+func isConflictKey(key string) bool {
+	return strings.HasPrefix(key, conflictKeyPrefix)
+}
+
+// unwrapAllRevisions returns the original values for stored values, omitting
+// recorded conflicts.
+func unwrapAllRevisions(data map[string]js.Value) map[string]js.Value {
+	res := map[string]js.Value{}
+	for k, v := range data {
+		if isConflictKey(k) {
+			continue
+		}
+		res[k], _ = unwrapRevision(v)
+	}
+	return res
+}
+
+// Set implements Area.Set(). The revision of each value is incremented.
+func (r *Revisioned) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	var keys []string
+	for k := range data {
+		if isConflictKey(k) {
+			return fmt.Errorf("cannot set reserved key %s", k)
+		}
+		keys = append(keys, k)
+	}
+	existing, err := r.s.GetKeys(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("failed to read existing revisions: %w", err)
+	}
+
+	wrapped := map[string]js.Value{}
+	for k, v := range data {
+		_, rev := unwrapRevision(existing[k])
+		wrapped[k] = wrapRevision(v, revision{Rev: rev.Rev + 1, Device: r.device})
+	}
+	if err := r.s.Set(ctx, wrapped); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, v := range wrapped {
+		r.written[k] = v
+	}
+	return nil
+}
+
+// Get implements Area.Get().
+func (r *Revisioned) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	data, err := r.s.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapAllRevisions(data), nil
+}
+
+// GetKeys implements Area.GetKeys().
+func (r *Revisioned) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	data, err := r.s.GetKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapAllRevisions(data), nil
+}
+
+// BytesInUse implements Area.BytesInUse().
+func (r *Revisioned) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	return r.s.BytesInUse(ctx, keys)
+}
+
+// Delete implements Area.Delete().
+func (r *Revisioned) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	if err := r.s.Delete(ctx, keys); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range keys {
+		delete(r.written, k)
+	}
+	return nil
+}
+
+// Watch implements Area.Watch().
+func (r *Revisioned) Watch(f WatchFunc) jsutil.CleanupFunc {
+	return r.s.Watch(func(changed map[string]js.Value, removed []string) {
+		res := unwrapAllRevisions(changed)
+		var nremoved []string
+		for _, k := range removed {
+			if !isConflictKey(k) {
+				nremoved = append(nremoved, k)
+			}
+		}
+		if len(res) == 0 && len(nremoved) == 0 {
+			return
+		}
+		f(res, nremoved)
+	})
+}
+
+// detect returns the conflicts indicated by changes in storage. Changes
+// written on this device, and changes from other devices that followed the
+// local write, are not conflicts.
+func (r *Revisioned) detect(changed map[string]js.Value) []Conflict {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var res []Conflict
+	for k, v := range changed {
+		if isConflictKey(k) {
+			continue
+		}
+		remote, rrev := unwrapRevision(v)
+		if rrev.Device == r.device {
+			continue
+		}
+		local, ok := r.written[k]
+		if !ok {
+			continue
+		}
+		// The local write is superseded either way; later changes are
+		// compared against the remote value.
+		delete(r.written, k)
+		lval, lrev := unwrapRevision(local)
+		if rrev.Rev > lrev.Rev {
+			continue
+		}
+		res = append(res, Conflict{Key: k, Local: lval, Remote: remote})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// record stores the conflicts, such that they can later be retrieved using
+// Conflicts.
+func (r *Revisioned) record(ctx jsutil.AsyncContext, conflicts []Conflict) error {
+	data := map[string]js.Value{}
+	for _, c := range conflicts {
+		rec := jsutil.NewObject()
+		rec.Set("local", c.Local)
+		rec.Set("remote", c.Remote)
+		data[conflictKeyPrefix+c.Key] = rec
+	}
+	return r.s.Set(ctx, data)
+}
+
+// OnConflict registers f to be invoked when a conflict is detected. Detected
+// conflicts are also recorded in storage until resolved.
+//
+// The returned cleanup function must be invoked to stop detecting conflicts.
+func (r *Revisioned) OnConflict(f ConflictFunc) jsutil.CleanupFunc {
+	return r.s.Watch(func(changed map[string]js.Value, removed []string) {
+		conflicts := r.detect(changed)
+		if len(conflicts) == 0 {
+			return
+		}
+		jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+			if err := r.record(ctx, conflicts); err != nil {
+				jsutil.LogError("Revisioned: failed to record conflicts: %v", err)
+			}
+			for _, c := range conflicts {
+				f(c)
+			}
+			return js.Undefined(), nil
+		})
+	})
+}
+
+// Conflicts returns the recorded conflicts that have not yet been resolved,
+// sorted by key.
+func (r *Revisioned) Conflicts(ctx jsutil.AsyncContext) ([]Conflict, error) {
+	data, err := r.s.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data: %w", err)
+	}
+	var res []Conflict
+	for k, v := range data {
+		if !isConflictKey(k) {
+			continue
+		}
+		res = append(res, Conflict{
+			Key:    strings.TrimPrefix(k, conflictKeyPrefix),
+			Local:  v.Get("local"),
+			Remote: v.Get("remote"),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res, nil
+}
+
+// Resolve resolves a conflict by storing the chosen value for key (typically
+// either the Local or Remote value of the conflict), and discarding the
+// recorded conflict.
+func (r *Revisioned) Resolve(ctx jsutil.AsyncContext, key string, value js.Value) error {
+	if err := r.Set(ctx, map[string]js.Value{key: value}); err != nil {
+		return fmt.Errorf("failed to store resolved value: %w", err)
+	}
+	if err := r.s.Delete(ctx, []string{conflictKeyPrefix + key}); err != nil {
+		return fmt.Errorf("failed to discard conflict: %w", err)
+	}
+	return nil
+}
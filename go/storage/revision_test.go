@@ -0,0 +1,205 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestRevisioned(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		r := NewRevisioned(raw, "deviceA")
+		if err := r.Set(ctx, map[string]js.Value{"key": js.ValueOf("one")}); err != nil {
+			t.Errorf("set failed: %v", err)
+			return
+		}
+		if err := r.Set(ctx, map[string]js.Value{"key": js.ValueOf("two")}); err != nil {
+			t.Errorf("set failed: %v", err)
+			return
+		}
+
+		got, err := st.Get(ctx, r)
+		if err != nil {
+			t.Errorf("get failed: %v", err)
+			return
+		}
+		if diff := cmp.Diff(got, map[string]string{"key": `"two"`}); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+
+		stored, err := raw.GetKeys(ctx, []string{"key"})
+		if err != nil {
+			t.Errorf("get failed for underlying storage: %v", err)
+			return
+		}
+		_, rev := unwrapRevision(stored["key"])
+		if diff := cmp.Diff(rev, revision{Rev: 2, Device: "deviceA"}); diff != "" {
+			t.Errorf("incorrect revision: -got +want: %s", diff)
+		}
+	})
+}
+
+// conflictEvents registers a conflict watcher, returning a channel to which
+// detected conflicts are sent.
+func conflictEvents(r *Revisioned) (<-chan Conflict, jsutil.CleanupFunc) {
+	conflicts := make(chan Conflict, 10)
+	cleanup := r.OnConflict(func(c Conflict) {
+		conflicts <- c
+	})
+	return conflicts, cleanup
+}
+
+func TestRevisionedConflicts(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		// remote writes to the shared storage area, simulating another
+		// device.
+		remote        func(ctx jsutil.AsyncContext, raw Area, b *Revisioned) error
+		wantConflicts []string
+	}{
+		{
+			description: "remote change follows local change",
+			remote: func(ctx jsutil.AsyncContext, raw Area, b *Revisioned) error {
+				return b.Set(ctx, map[string]js.Value{"key": js.ValueOf("remote")})
+			},
+		},
+		{
+			description: "concurrent remote change",
+			remote: func(ctx jsutil.AsyncContext, raw Area, b *Revisioned) error {
+				// The remote device wrote the first revision
+				// without seeing the local one.
+				return raw.Set(ctx, map[string]js.Value{
+					"key": wrapRevision(js.ValueOf("remote"), revision{Rev: 1, Device: "deviceB"}),
+				})
+			},
+			wantConflicts: []string{"key"},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				raw := NewRaw(st.NewQuotaMemArea(0, 0, 0))
+				a := NewRevisioned(raw, "deviceA")
+				b := NewRevisioned(raw, "deviceB")
+				events, cleanup := conflictEvents(a)
+				defer cleanup()
+
+				if err := a.Set(ctx, map[string]js.Value{"key": js.ValueOf("local")}); err != nil {
+					t.Errorf("set failed: %v", err)
+					return
+				}
+				if err := tc.remote(ctx, raw, b); err != nil {
+					t.Errorf("remote set failed: %v", err)
+					return
+				}
+
+				var gotEvents []string
+				for {
+					select {
+					case c := <-events:
+						gotEvents = append(gotEvents, c.Key)
+						if diff := cmp.Diff(jsutil.ToJSON(c.Local), `"local"`); diff != "" {
+							t.Errorf("incorrect local value: -got +want: %s", diff)
+						}
+						if diff := cmp.Diff(jsutil.ToJSON(c.Remote), `"remote"`); diff != "" {
+							t.Errorf("incorrect remote value: -got +want: %s", diff)
+						}
+						continue
+					case <-time.After(200 * time.Millisecond):
+					}
+					break
+				}
+				if diff := cmp.Diff(gotEvents, tc.wantConflicts, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("incorrect conflict events: -got +want: %s", diff)
+				}
+
+				conflicts, err := a.Conflicts(ctx)
+				if err != nil {
+					t.Errorf("conflicts failed: %v", err)
+					return
+				}
+				var gotConflicts []string
+				for _, c := range conflicts {
+					gotConflicts = append(gotConflicts, c.Key)
+				}
+				if diff := cmp.Diff(gotConflicts, tc.wantConflicts, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("incorrect recorded conflicts: -got +want: %s", diff)
+				}
+
+				// Recorded conflicts are never returned as values.
+				got, err := st.Get(ctx, a)
+				if err != nil {
+					t.Errorf("get failed: %v", err)
+					return
+				}
+				if diff := cmp.Diff(got, map[string]string{"key": `"remote"`}); diff != "" {
+					t.Errorf("incorrect data: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestRevisionedResolve(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		r := NewRevisioned(raw, "deviceA")
+		if err := r.record(ctx, []Conflict{{Key: "key", Local: js.ValueOf("local"), Remote: js.ValueOf("remote")}}); err != nil {
+			t.Errorf("record failed: %v", err)
+			return
+		}
+
+		if err := r.Resolve(ctx, "key", js.ValueOf("local")); err != nil {
+			t.Errorf("resolve failed: %v", err)
+			return
+		}
+
+		conflicts, err := r.Conflicts(ctx)
+		if err != nil {
+			t.Errorf("conflicts failed: %v", err)
+			return
+		}
+		if len(conflicts) != 0 {
+			t.Errorf("conflict not discarded; got %d conflicts", len(conflicts))
+		}
+		got, err := st.Get(ctx, r)
+		if err != nil {
+			t.Errorf("get failed: %v", err)
+			return
+		}
+		if diff := cmp.Diff(got, map[string]string{"key": `"local"`}); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+	})
+}
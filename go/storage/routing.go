@@ -0,0 +1,223 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+// RouteFunc returns the area in which a value should be stored, given its key
+// and its size (in the same manner as it is counted against quotas). It must
+// return one of the areas supplied to NewRouting.
+type RouteFunc func(key string, size int) Area
+
+// Routing stores each value in one of several areas, as selected by a
+// RouteFunc. For example, small settings may be stored in an area that is
+// synced between devices, while large values are kept on the local device.
+//
+// If a value is written to a different area than the one currently holding
+// the key (e.g., because its size crossed a threshold), it is moved: the new
+// value is written and verified, and only then is the old value deleted.
+// If this is interrupted, reads resolve the duplicate in favor of the area
+// selected by the RouteFunc.
+//
+// Routing implements the Area interface.
+type Routing struct {
+	route RouteFunc
+	areas []Area
+}
+
+// NewRouting returns a Routing that stores values in the supplied areas, as
+// selected by route.
+func NewRouting(route RouteFunc, areas ...Area) *Routing {
+	return &Routing{
+		route: route,
+		areas: areas,
+	}
+}
+
+// routeFor returns the area selected for the value of key.
+func (r *Routing) routeFor(key string, v js.Value) (Area, error) {
+	area := r.route(key, storedSize(key, jsutil.ToJSON(v)))
+	for _, a := range r.areas {
+		if a == area {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("route for %s selected an unknown area", key)
+}
+
+// mergeRouted merges values read from each area. Where a key is present in
+// multiple areas, the value in the area selected for it takes precedence.
+func (r *Routing) mergeRouted(read []map[string]js.Value) map[string]js.Value {
+	res := map[string]js.Value{}
+	for i, data := range read {
+		for k, v := range data {
+			if _, ok := res[k]; !ok {
+				res[k] = v
+				continue
+			}
+			if area, err := r.routeFor(k, v); err == nil && area == r.areas[i] {
+				res[k] = v
+			}
+		}
+	}
+	return res
+}
+
+// Set implements Area.Set().
+func (r *Routing) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	groups := map[Area]map[string]js.Value{}
+	for k, v := range data {
+		area, err := r.routeFor(k, v)
+		if err != nil {
+			return err
+		}
+		if groups[area] == nil {
+			groups[area] = map[string]js.Value{}
+		}
+		groups[area][k] = v
+	}
+
+	for dst, group := range groups {
+		if err := dst.Set(ctx, group); err != nil {
+			return err
+		}
+
+		// Verify the new values before removing any old ones from
+		// other areas, such that a failure never loses a value.
+		var keys []string
+		for k := range group {
+			keys = append(keys, k)
+		}
+		written, err := dst.GetKeys(ctx, keys)
+		if err != nil {
+			return fmt.Errorf("failed to read back values: %w", err)
+		}
+		for k, v := range group {
+			if w, ok := written[k]; !ok || jsutil.ToJSON(w) != jsutil.ToJSON(v) {
+				return fmt.Errorf("%w: key %s", ErrVerifyFailed, k)
+			}
+		}
+
+		for _, a := range r.areas {
+			if a == dst {
+				continue
+			}
+			if err := a.Delete(ctx, keys); err != nil {
+				return fmt.Errorf("failed to delete values from previous area: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Get implements Area.Get().
+func (r *Routing) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	var read []map[string]js.Value
+	for _, a := range r.areas {
+		data, err := a.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		read = append(read, data)
+	}
+	return r.mergeRouted(read), nil
+}
+
+// GetKeys implements Area.GetKeys().
+func (r *Routing) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	var read []map[string]js.Value
+	for _, a := range r.areas {
+		data, err := a.GetKeys(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		read = append(read, data)
+	}
+	return r.mergeRouted(read), nil
+}
+
+// BytesInUse implements Area.BytesInUse().
+func (r *Routing) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	var n int
+	for _, a := range r.areas {
+		an, err := a.BytesInUse(ctx, keys)
+		if err != nil {
+			return 0, err
+		}
+		n += an
+	}
+	return n, nil
+}
+
+// Delete implements Area.Delete().
+func (r *Routing) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	for _, a := range r.areas {
+		if err := a.Delete(ctx, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch implements Area.Watch().
+//
+// A key removed from one area is only reported as removed if no other area
+// holds it, such that moving a value between areas is reported as a change.
+func (r *Routing) Watch(f WatchFunc) jsutil.CleanupFunc {
+	var cleanup jsutil.CleanupFuncs
+	for _, a := range r.areas {
+		a := a
+		cleanup.Add(a.Watch(func(changed map[string]js.Value, removed []string) {
+			if len(removed) == 0 {
+				f(changed, removed)
+				return
+			}
+			jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+				present := map[string]bool{}
+				for _, other := range r.areas {
+					if other == a {
+						continue
+					}
+					data, err := other.GetKeys(ctx, removed)
+					if err != nil {
+						jsutil.LogError("Routing.Watch: failed to read other area: %v", err)
+						continue
+					}
+					for k := range data {
+						present[k] = true
+					}
+				}
+				var nremoved []string
+				for _, k := range removed {
+					if !present[k] {
+						nremoved = append(nremoved, k)
+					}
+				}
+				if len(changed) > 0 || len(nremoved) > 0 {
+					f(changed, nremoved)
+				}
+				return js.Undefined(), nil
+			})
+		}))
+	}
+	return cleanup.Do
+}
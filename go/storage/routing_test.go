@@ -0,0 +1,236 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"strings"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// sizeRoute returns a RouteFunc that stores values of at most threshold bytes
+// in small, and larger ones in large.
+func sizeRoute(threshold int, small, large Area) RouteFunc {
+	return func(key string, size int) Area {
+		if size <= threshold {
+			return small
+		}
+		return large
+	}
+}
+
+func TestRouting(t *testing.T) {
+	t.Parallel()
+
+	big := strings.Repeat("a", 500)
+
+	testcases := []struct {
+		description string
+		initial     map[string]js.Value
+		set         map[string]js.Value
+		wantSmall   map[string]string
+		wantLarge   map[string]string
+	}{
+		{
+			description: "route by size",
+			set: map[string]js.Value{
+				"setting": js.ValueOf("dark"),
+				"key":     js.ValueOf(big),
+			},
+			wantSmall: map[string]string{"setting": `"dark"`},
+			wantLarge: map[string]string{"key": `"` + big + `"`},
+		},
+		{
+			description: "value grows beyond threshold",
+			initial: map[string]js.Value{
+				"key": js.ValueOf("small"),
+			},
+			set: map[string]js.Value{
+				"key": js.ValueOf(big),
+			},
+			wantLarge: map[string]string{"key": `"` + big + `"`},
+		},
+		{
+			description: "value shrinks below threshold",
+			initial: map[string]js.Value{
+				"key": js.ValueOf(big),
+			},
+			set: map[string]js.Value{
+				"key": js.ValueOf("small"),
+			},
+			wantSmall: map[string]string{"key": `"small"`},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				small := NewRaw(st.NewMemArea())
+				large := NewBig(200, NewRaw(st.NewMemArea()))
+				r := NewRouting(sizeRoute(100, small, large), small, large)
+				if len(tc.initial) > 0 {
+					if err := r.Set(ctx, tc.initial); err != nil {
+						t.Errorf("initial set failed: %v", err)
+						return
+					}
+				}
+
+				if err := r.Set(ctx, tc.set); err != nil {
+					t.Errorf("set failed: %v", err)
+					return
+				}
+
+				gotSmall, err := st.Get(ctx, small)
+				if err != nil {
+					t.Errorf("get failed for small area: %v", err)
+					return
+				}
+				gotLarge, err := st.Get(ctx, large)
+				if err != nil {
+					t.Errorf("get failed for large area: %v", err)
+					return
+				}
+				got, err := st.Get(ctx, r)
+				if err != nil {
+					t.Errorf("get failed: %v", err)
+					return
+				}
+
+				if diff := cmp.Diff(gotSmall, tc.wantSmall, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("incorrect small area data: -got +want: %s", diff)
+				}
+				if diff := cmp.Diff(gotLarge, tc.wantLarge, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("incorrect large area data: -got +want: %s", diff)
+				}
+				want := map[string]string{}
+				for k, v := range tc.wantSmall {
+					want[k] = v
+				}
+				for k, v := range tc.wantLarge {
+					want[k] = v
+				}
+				if diff := cmp.Diff(got, want); diff != "" {
+					t.Errorf("incorrect data: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestRoutingVerifyFailure(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		small := NewRaw(st.NewMemArea())
+		large := &corruptingArea{Area: NewRaw(st.NewMemArea())}
+		r := NewRouting(sizeRoute(100, small, large), small, large)
+		if err := r.Set(ctx, map[string]js.Value{"key": js.ValueOf("small")}); err != nil {
+			t.Errorf("initial set failed: %v", err)
+			return
+		}
+
+		// The value cannot be verified in its new location, so the old
+		// value is retained.
+		if err := r.Set(ctx, map[string]js.Value{"key": js.ValueOf(strings.Repeat("a", 500))}); !errors.Is(err, ErrVerifyFailed) {
+			t.Errorf("incorrect error: got %v, want %v", err, ErrVerifyFailed)
+		}
+		got, err := st.Get(ctx, small)
+		if err != nil {
+			t.Errorf("get failed for small area: %v", err)
+			return
+		}
+		if diff := cmp.Diff(got, map[string]string{"key": `"small"`}); diff != "" {
+			t.Errorf("incorrect small area data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestRoutingDuplicates(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		small := NewRaw(st.NewMemArea())
+		large := NewRaw(st.NewMemArea())
+		r := NewRouting(sizeRoute(100, small, large), small, large)
+
+		// Simulate an interrupted move from small to large.
+		big := strings.Repeat("a", 500)
+		if err := small.Set(ctx, map[string]js.Value{"key": js.ValueOf("small")}); err != nil {
+			t.Errorf("set failed for small area: %v", err)
+			return
+		}
+		if err := large.Set(ctx, map[string]js.Value{"key": js.ValueOf(big)}); err != nil {
+			t.Errorf("set failed for large area: %v", err)
+			return
+		}
+
+		got, err := st.Get(ctx, r)
+		if err != nil {
+			t.Errorf("get failed: %v", err)
+			return
+		}
+		if diff := cmp.Diff(got, map[string]string{"key": `"` + big + `"`}); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestRoutingWatchMove(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		small := NewRaw(st.NewQuotaMemArea(0, 0, 0))
+		large := NewRaw(st.NewQuotaMemArea(0, 0, 0))
+		r := NewRouting(sizeRoute(100, small, large), small, large)
+		if err := r.Set(ctx, map[string]js.Value{"key": js.ValueOf("small")}); err != nil {
+			t.Errorf("initial set failed: %v", err)
+			return
+		}
+
+		events, cleanup := watchEvents(r)
+		defer cleanup()
+		for ev := nextEvent(events); ev != nil; ev = nextEvent(events) {
+			// Discard events from the initial write.
+		}
+
+		big := strings.Repeat("a", 500)
+		if err := r.Set(ctx, map[string]js.Value{"key": js.ValueOf(big)}); err != nil {
+			t.Errorf("set failed: %v", err)
+			return
+		}
+
+		// The move is reported as a change, without a removal.
+		var got []watchEvent
+		for ev := nextEvent(events); ev != nil; ev = nextEvent(events) {
+			got = append(got, *ev)
+		}
+		want := []watchEvent{
+			{changed: map[string]string{"key": `"` + big + `"`}},
+		}
+		if diff := cmp.Diff(got, want, cmp.AllowUnexported(watchEvent{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect events: -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/norunners/vert"
+)
+
+const (
+	// trustedContextsAccessLevel restricts access to extension pages and
+	// service workers; content scripts cannot access the storage area.
+	trustedContextsAccessLevel = "TRUSTED_CONTEXTS"
+)
+
+type accessLevelOptions struct {
+	AccessLevel string `js:"accessLevel"`
+}
+
+// NewSession returns an Area that stores in-memory data in the specified area,
+// which must point to an object implementing the StorageArea API for
+// chrome.storage.session. Data is not written to disk. Since we store
+// decrypted key material here, access to the area is restricted so that
+// content scripts cannot read it.
+//
+// Values are split as required to respect the area's limits.
+func NewSession(area js.Value) Area {
+	restrictAccess(area)
+	return NewBigAuto(area)
+}
+
+// restrictAccess limits access to the area to trusted contexts. The request
+// completes asynchronously; failures are logged.
+func restrictAccess(area js.Value) {
+	if area.Get("setAccessLevel").Type() != js.TypeFunction {
+		jsutil.LogError("storage area does not support setAccessLevel; access not restricted")
+		return
+	}
+
+	opts := &accessLevelOptions{AccessLevel: trustedContextsAccessLevel}
+	jsutil.AsPromise(area.Call("setAccessLevel", vert.ValueOf(opts).JSValue())).Then(
+		func(js.Value) {},
+		func(err error) { jsutil.LogError("failed to restrict access to storage area: %v", err) },
+	)
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSession(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		area := st.NewSessionMemArea()
+		s := NewSession(area)
+
+		if got := st.AccessLevel(area); got != trustedContextsAccessLevel {
+			t.Errorf("incorrect access level; got %q, want %q", got, trustedContextsAccessLevel)
+		}
+
+		data := map[string]js.Value{
+			"key": js.ValueOf("decrypted-key-material"),
+		}
+		if err := s.Set(ctx, data); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		got, err := s.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(dataToJSON(got), dataToJSON(data)); diff != "" {
+			t.Errorf("incorrect data; -got +want: %s", diff)
+		}
+	})
+}
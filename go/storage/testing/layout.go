@@ -0,0 +1,106 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"strings"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+const (
+	// manifestMagic is the magic string encoded in manifests written by
+	// storage.Big. It must match the storage package, whose tests verify
+	// the classification made by EntryTypes.
+	manifestMagic = "3cc36853-b864-4122-beaa-516aa24448f6"
+
+	// chunkKeyPrefix is the prefix of keys for chunks written by
+	// storage.Big.
+	chunkKeyPrefix = "chunk-" + manifestMagic + ":"
+)
+
+// Entry types reported by EntryTypes.
+const (
+	EntryChunk    = "chunk"
+	EntryManifest = "manifest"
+	EntrySimple   = "simple"
+)
+
+// Area is the subset of the storage.Area interface used by the helpers in
+// this package. It is declared here, rather than importing the storage
+// package, such that the storage package's own tests may use the helpers.
+type Area interface {
+	Set(ctx jsutil.AsyncContext, data map[string]js.Value) error
+	Get(ctx jsutil.AsyncContext) (map[string]js.Value, error)
+	Delete(ctx jsutil.AsyncContext, keys []string) error
+}
+
+// Get returns all data in s, with each value serialized as JSON.
+func Get(ctx jsutil.AsyncContext, s Area) (map[string]string, error) {
+	data, err := s.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]string{}
+	for k, v := range data {
+		res[k] = jsutil.ToJSON(v)
+	}
+	return res, nil
+}
+
+// Set stores data in s, where each value is serialized as JSON.
+func Set(ctx jsutil.AsyncContext, s Area, data map[string]string) error {
+	vals := map[string]js.Value{}
+	for k, v := range data {
+		vals[k] = jsutil.FromJSON(v)
+	}
+	return s.Set(ctx, vals)
+}
+
+// Delete removes the specified keys from s.
+func Delete(ctx jsutil.AsyncContext, s Area, keys []string) error {
+	return s.Delete(ctx, keys)
+}
+
+// IsManifest returns true if v is a manifest written by storage.Big.
+func IsManifest(v js.Value) bool {
+	return v.Type() == js.TypeObject && v.Get("magic").Type() == js.TypeString && v.Get("magic").String() == manifestMagic
+}
+
+// EntryTypes returns the type of each entry in s, as laid out by storage.Big:
+// EntryChunk, EntryManifest, or EntrySimple for values stored directly.
+func EntryTypes(ctx jsutil.AsyncContext, s Area) (map[string]string, error) {
+	data, err := s.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]string{}
+	for k, v := range data {
+		switch {
+		case strings.HasPrefix(k, chunkKeyPrefix):
+			res[k] = EntryChunk
+		case IsManifest(v):
+			res[k] = EntryManifest
+		default:
+			res[k] = EntrySimple
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// mapArea is a minimal Area backed by a map.
+type mapArea map[string]js.Value
+
+func (m mapArea) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	for k, v := range data {
+		m[k] = v
+	}
+	return nil
+}
+
+func (m mapArea) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	res := map[string]js.Value{}
+	for k, v := range m {
+		res[k] = v
+	}
+	return res, nil
+}
+
+func (m mapArea) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	for _, k := range keys {
+		delete(m, k)
+	}
+	return nil
+}
+
+func TestLayout(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		area := mapArea{}
+		chunkKey := chunkKeyPrefix + "abc"
+		if err := Set(ctx, area, map[string]string{
+			"number":   `1`,
+			"object":   `{"magic":"other"}`,
+			"manifest": `{"magic":"` + manifestMagic + `","chunkKeys":["` + chunkKey + `"]}`,
+			chunkKey:   `"Zm9v"`,
+		}); err != nil {
+			t.Errorf("set failed: %v", err)
+			return
+		}
+		if err := Delete(ctx, area, []string{"number"}); err != nil {
+			t.Errorf("delete failed: %v", err)
+			return
+		}
+
+		got, err := Get(ctx, area)
+		if err != nil {
+			t.Errorf("get failed: %v", err)
+			return
+		}
+		want := map[string]string{
+			"object":   `{"magic":"other"}`,
+			"manifest": `{"magic":"` + manifestMagic + `","chunkKeys":["` + chunkKey + `"]}`,
+			chunkKey:   `"Zm9v"`,
+		}
+		if diff := cmp.Diff(got, want, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+
+		gotTypes, err := EntryTypes(ctx, area)
+		if err != nil {
+			t.Errorf("get failed: %v", err)
+			return
+		}
+		wantTypes := map[string]string{
+			"object":   EntrySimple,
+			"manifest": EntryManifest,
+			chunkKey:   EntryChunk,
+		}
+		if diff := cmp.Diff(gotTypes, wantTypes); diff != "" {
+			t.Errorf("incorrect entry types: -got +want: %s", diff)
+		}
+	})
+}
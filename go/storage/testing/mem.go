@@ -19,12 +19,437 @@ package testing
 
 import (
 	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
 )
 
 var storageArea = js.Global().Call("eval", `{
 	require("mem-storage-area/StorageArea");
 }`)
 
+// NewMemArea returns a new in-memory object implementing the StorageArea API.
 func NewMemArea() js.Value {
 	return storageArea.New()
 }
+
+var quotaArea = js.Global().Call("eval", `{
+	(area, quotaBytes, quotaBytesPerItem, maxItems) => {
+		// Like Chrome, count the UTF-8 length of keys and of values
+		// serialized as JSON, including the characters that Chrome's
+		// serializer escapes but JSON.stringify does not.
+		const encoder = new TextEncoder();
+		const serialize = (v) => JSON.stringify(v).replace(
+			/[<\x7f\u2028\u2029]/g,
+			(c) => "\\u" + c.charCodeAt(0).toString(16).padStart(4, "0"));
+		const size = (items) => Object.entries(items).reduce(
+			(n, [k, v]) => n + encoder.encode(k).length + encoder.encode(serialize(v)).length, 0);
+		const asKeys = (keys) => typeof keys === "string" ? [keys] : keys;
+		const listeners = new Set();
+		// writeFailures and readFailures are error messages with which
+		// to reject upcoming writes and reads respectively. Undefined
+		// entries indicate operations that succeed.
+		const writeFailures = [];
+		const readFailures = [];
+		const maybeFail = (failures) => {
+			const message = failures.shift();
+			if (message !== undefined) {
+				throw new Error(message);
+			}
+		};
+		// ops records each operation, in the order invoked.
+		const ops = [];
+		const record = (method, keys) => {
+			const op = {method: method, keys: keys === null || keys === undefined ? [] : asKeys(keys).slice().sort(), failed: false};
+			ops.push(op);
+			return op;
+		};
+		const recorded = (op, f) => {
+			try {
+				return f();
+			} catch (e) {
+				op.failed = true;
+				throw e;
+			}
+		};
+		const emit = (changes) => {
+			if (Object.keys(changes).length === 0) {
+				return;
+			}
+			// Chrome notifies listeners asynchronously.
+			setTimeout(() => listeners.forEach((l) => l(changes)), 0);
+		};
+		const fake = {
+			get: async (keys) => {
+				recorded(record("get", keys), () => maybeFail(readFailures));
+				return area.get(keys);
+			},
+			getKeys: async () => {
+				recorded(record("getKeys", null), () => maybeFail(readFailures));
+				return Object.keys(await area.get(null));
+			},
+			getBytesInUse: async (keys) => size(await area.get(keys)),
+			set: async (items) => {
+				const op = record("set", Object.keys(items));
+				const merged = Object.assign(await area.get(null), items);
+				recorded(op, () => {
+					maybeFail(writeFailures);
+					for (const [k, v] of Object.entries(items)) {
+						if (quotaBytesPerItem > 0 && size({[k]: v}) > quotaBytesPerItem) {
+							throw new Error("QUOTA_BYTES_PER_ITEM quota exceeded");
+						}
+					}
+					if (quotaBytes > 0 && size(merged) > quotaBytes) {
+						throw new Error("QUOTA_BYTES quota exceeded");
+					}
+					if (maxItems > 0 && Object.keys(merged).length > maxItems) {
+						throw new Error("MAX_ITEMS quota exceeded");
+					}
+				});
+				const old = await area.get(Object.keys(items));
+				await area.set(items);
+				const changes = {};
+				for (const [k, v] of Object.entries(items)) {
+					changes[k] = {oldValue: old[k], newValue: JSON.parse(JSON.stringify(v))};
+				}
+				emit(changes);
+			},
+			remove: async (keys) => {
+				recorded(record("remove", keys), () => maybeFail(writeFailures));
+				const old = await area.get(asKeys(keys));
+				await area.remove(keys);
+				const changes = {};
+				for (const [k, v] of Object.entries(old)) {
+					changes[k] = {oldValue: v};
+				}
+				emit(changes);
+			},
+			onChanged: {
+				addListener: (l) => listeners.add(l),
+				removeListener: (l) => listeners.delete(l),
+			},
+			failWrites: (n, message) => {
+				for (let i = 0; i < n; i++) {
+					writeFailures.push(message);
+				}
+			},
+			failReads: (n, message) => {
+				for (let i = 0; i < n; i++) {
+					readFailures.push(message);
+				}
+			},
+			failNthWrite: (n, message) => {
+				writeFailures[n - 1] = message;
+			},
+			ops: () => ops,
+		};
+		// Expose limits in the same manner as Chrome's Storage API.
+		if (quotaBytes > 0) {
+			fake.QUOTA_BYTES = quotaBytes;
+		}
+		if (quotaBytesPerItem > 0) {
+			fake.QUOTA_BYTES_PER_ITEM = quotaBytesPerItem;
+		}
+		if (maxItems > 0) {
+			fake.MAX_ITEMS = maxItems;
+		}
+		return fake;
+	};
+}`)
+
+// NewQuotaMemArea returns a new in-memory object implementing the StorageArea
+// API that rejects writes exceeding the supplied quotas, in the same manner as
+// Chrome's Storage API. A quota of 0 is not enforced. Enforced quotas are
+// exposed as the QUOTA_BYTES, QUOTA_BYTES_PER_ITEM and MAX_ITEMS properties.
+// Unlike NewMemArea, the returned object also supports getKeys(),
+// getBytesInUse() and onChanged events.
+func NewQuotaMemArea(quotaBytes, quotaBytesPerItem, maxItems int) js.Value {
+	return quotaArea.Invoke(NewMemArea(), quotaBytes, quotaBytesPerItem, maxItems)
+}
+
+// FailWrites causes the next n writes (i.e., calls to set() or remove()) to an
+// area returned by NewQuotaMemArea to be rejected with the supplied error
+// message.
+func FailWrites(area js.Value, n int, message string) {
+	area.Call("failWrites", n, message)
+}
+
+// FailNthWrite causes the nth upcoming write (i.e., call to set() or remove())
+// to an area returned by NewQuotaMemArea to be rejected with the supplied
+// error message, counting from 1 for the next write. Other writes are
+// unaffected.
+func FailNthWrite(area js.Value, n int, message string) {
+	area.Call("failNthWrite", n, message)
+}
+
+// Op describes an operation invoked on an area returned by NewQuotaMemArea.
+type Op struct {
+	// Method is the name of the StorageArea method invoked; e.g., "set".
+	Method string
+	// Keys are the keys passed to the method, or those of the items
+	// passed to set(), in sorted order. Keys is empty when reading all
+	// items.
+	Keys []string
+	// Failed indicates that the operation was rejected.
+	Failed bool
+}
+
+// Ops returns the operations invoked on an area returned by NewQuotaMemArea,
+// in the order in which they were invoked.
+func Ops(area js.Value) []Op {
+	val := area.Call("ops")
+	var res []Op
+	for i := 0; i < val.Length(); i++ {
+		op := val.Index(i)
+		var keys []string
+		for j := 0; j < op.Get("keys").Length(); j++ {
+			keys = append(keys, op.Get("keys").Index(j).String())
+		}
+		res = append(res, Op{
+			Method: op.Get("method").String(),
+			Keys:   keys,
+			Failed: op.Get("failed").Bool(),
+		})
+	}
+	return res
+}
+
+// FailReads causes the next n reads (i.e., calls to get()) from an area
+// returned by NewQuotaMemArea to be rejected with the supplied error message.
+func FailReads(area js.Value, n int, message string) {
+	area.Call("failReads", n, message)
+}
+
+var delayedArea = js.Global().Call("eval", `{
+	(area, latencyMs, reorder) => {
+		// pending holds operations that have not yet completed.
+		const pending = new Set();
+		const delay = () => reorder ? Math.random() * latencyMs : latencyMs;
+		const deferred = (method) => (...args) => {
+			// Operations are applied in the order invoked, as
+			// Chrome does, but complete only after a delay.
+			const result = area[method](...args);
+			const p = new Promise((resolve) => setTimeout(resolve, delay())).then(() => result);
+			pending.add(p);
+			const done = () => pending.delete(p);
+			p.then(done, done);
+			return p;
+		};
+		const fake = Object.create(area);
+		for (const method of ["get", "getKeys", "getBytesInUse", "set", "remove", "clear"]) {
+			if (typeof area[method] === "function") {
+				fake[method] = deferred(method);
+			}
+		}
+		fake.flush = async () => {
+			while (pending.size > 0) {
+				await Promise.allSettled([...pending]);
+			}
+		};
+		return fake;
+	};
+}`)
+
+// Delay configures the delays introduced by NewDelayedArea.
+type Delay struct {
+	// Latency is the time taken by each operation to complete.
+	Latency time.Duration
+	// Reorder randomizes the time taken by each operation, up to Latency,
+	// such that concurrent operations may complete out of order.
+	Reorder bool
+}
+
+// NewDelayedArea returns an object implementing the StorageArea API on top of
+// area, delaying the completion of each operation. As with Chrome's Storage
+// API, operations are applied in the order in which they are invoked. This
+// exposes code that assumes operations complete promptly, or in order.
+func NewDelayedArea(area js.Value, d Delay) js.Value {
+	return delayedArea.Invoke(area, d.Latency.Milliseconds(), d.Reorder)
+}
+
+// Flush waits for all in-progress operations on an area returned by
+// NewDelayedArea to complete.
+func Flush(ctx jsutil.AsyncContext, area js.Value) error {
+	_, err := jsutil.AsPromise(area.Call("flush")).Await(ctx)
+	return err
+}
+
+var sessionArea = js.Global().Call("eval", `{
+	(area) => {
+		area.setAccessLevel = async (opts) => {
+			area.accessLevel = opts.accessLevel;
+		};
+		return area;
+	};
+}`)
+
+// NewSessionMemArea returns a new in-memory object implementing the
+// StorageArea API in the same manner as chrome.storage.session. This includes
+// its quota and support for setAccessLevel(); see AccessLevel.
+func NewSessionMemArea() js.Value {
+	return sessionArea.Invoke(NewQuotaMemArea(sessionQuotaBytes, 0, 0))
+}
+
+const (
+	// sessionQuotaBytes is the quota for chrome.storage.session.
+	sessionQuotaBytes = 10485760
+)
+
+// AccessLevel returns the access level most recently set on an area returned
+// by NewSessionMemArea, or an empty string if it has not been set.
+func AccessLevel(area js.Value) string {
+	level := area.Get("accessLevel")
+	if level.IsUndefined() {
+		return ""
+	}
+	return level.String()
+}
+
+var idbFactory = js.Global().Call("eval", `{
+	(() => {
+		// Requests and transactions complete asynchronously, in the
+		// order in which they were issued, as in real IndexedDB.
+		const later = (f) => setTimeout(f, 0);
+		const request = (tx, op) => {
+			const req = {};
+			tx.pending++;
+			later(() => {
+				try {
+					req.result = op();
+					req.onsuccess && req.onsuccess();
+				} catch (e) {
+					req.error = e;
+					req.onerror && req.onerror();
+				}
+				if (--tx.pending === 0) {
+					later(() => tx.oncomplete && tx.oncomplete());
+				}
+			});
+			return req;
+		};
+		const clone = (v) => structuredClone(v);
+		return () => {
+			// databases maps database name to {version, stores}.
+			const databases = new Map();
+			return {
+				open: (name, version) => {
+					const req = {};
+					later(() => {
+						if (!databases.has(name)) {
+							databases.set(name, {version: 0, stores: new Map()});
+						}
+						const entry = databases.get(name);
+						const db = {
+							name: name,
+							close: () => {},
+							createObjectStore: (store) => entry.stores.set(store, new Map()),
+							transaction: (store, mode) => {
+								const items = entry.stores.get(store);
+								if (!items) {
+									throw new Error("NotFoundError: " + store);
+								}
+								const writable = mode === "readwrite";
+								const checkWrite = () => {
+									if (!writable) {
+										throw new Error("ReadOnlyError");
+									}
+								};
+								const tx = {pending: 0};
+								tx.objectStore = () => ({
+									put: (v, k) => request(tx, () => { checkWrite(); items.set(k, clone(v)); return k; }),
+									delete: (k) => request(tx, () => { checkWrite(); items.delete(k); }),
+									get: (k) => request(tx, () => items.has(k) ? clone(items.get(k)) : undefined),
+									getAllKeys: () => request(tx, () => [...items.keys()].sort()),
+									getAll: () => request(tx, () => [...items.keys()].sort().map((k) => clone(items.get(k)))),
+								});
+								// Transactions with no requests complete immediately.
+								later(() => tx.pending === 0 && tx.oncomplete && tx.oncomplete());
+								return tx;
+							},
+						};
+						req.result = db;
+						if (entry.version < version) {
+							const oldVersion = entry.version;
+							entry.version = version;
+							req.onupgradeneeded && req.onupgradeneeded({oldVersion: oldVersion});
+						}
+						req.onsuccess && req.onsuccess();
+					});
+					return req;
+				},
+			};
+		};
+	})();
+}`)
+
+// NewIDBFactory returns a new in-memory object implementing the subset of the
+// IDBFactory API used by storage.IndexedDB. Databases opened through the same
+// factory share their contents.
+func NewIDBFactory() js.Value {
+	return idbFactory.Invoke()
+}
+
+var alarms = js.Global().Call("eval", `{
+	() => {
+		const scheduled = new Map();
+		const listeners = new Set();
+		return {
+			create: async (name, info) => {
+				const alarm = {name: name, scheduledTime: info.when};
+				if (info.periodInMinutes !== undefined) {
+					alarm.periodInMinutes = info.periodInMinutes;
+					if (alarm.scheduledTime === undefined) {
+						alarm.scheduledTime = Date.now() + info.periodInMinutes * 60000;
+					}
+				}
+				scheduled.set(name, alarm);
+			},
+			get: async (name) => scheduled.get(name),
+			clear: async (name) => scheduled.delete(name),
+			onAlarm: {
+				addListener: (l) => listeners.add(l),
+				removeListener: (l) => listeners.delete(l),
+			},
+			scheduledTime: (name) => {
+				const alarm = scheduled.get(name);
+				return alarm === undefined ? 0 : alarm.scheduledTime;
+			},
+			fire: (name) => {
+				const alarm = scheduled.get(name);
+				if (alarm === undefined) {
+					return false;
+				}
+				if (alarm.periodInMinutes === undefined) {
+					scheduled.delete(name);
+				} else {
+					scheduled.set(name, Object.assign({}, alarm, {
+						scheduledTime: alarm.scheduledTime + alarm.periodInMinutes * 60000,
+					}));
+				}
+				listeners.forEach((l) => l(alarm));
+				return true;
+			},
+		};
+	};
+}`)
+
+// NewAlarms returns a new in-memory object implementing the subset of the
+// chrome.alarms API used for scheduling one-off and periodic alarms. Alarms
+// never fire by themselves; see FireAlarm.
+func NewAlarms() js.Value {
+	return alarms.Invoke()
+}
+
+// AlarmTime returns the time at which the named alarm on an object returned
+// by NewAlarms is scheduled, in milliseconds since the epoch. Zero is returned
+// if the alarm is not scheduled.
+func AlarmTime(alarms js.Value, name string) int64 {
+	return int64(alarms.Call("scheduledTime", name).Float())
+}
+
+// FireAlarm fires the named alarm on an object returned by NewAlarms,
+// notifying listeners. Periodic alarms are rescheduled to fire again after
+// their period. It returns false if the alarm was not scheduled.
+func FireAlarm(alarms js.Value, name string) bool {
+	return alarms.Call("fire", name).Bool()
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"fmt"
+	"sync"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+)
+
+func TestDelayedArea(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		area := NewDelayedArea(NewMemArea(), Delay{Latency: 20 * time.Millisecond, Reorder: true})
+
+		// Start many writes without waiting for them, recording the
+		// order in which they complete.
+		var mu sync.Mutex
+		var completed []int
+		const writes = 20
+		for i := 0; i < writes; i++ {
+			i := i
+			item := jsutil.NewObject()
+			item.Set("key", i)
+			done := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+				mu.Lock()
+				defer mu.Unlock()
+				completed = append(completed, i)
+				return nil
+			})
+			defer done.Release()
+			area.Call("set", item).Call("then", done)
+		}
+
+		if err := Flush(ctx, area); err != nil {
+			t.Errorf("flush failed: %v", err)
+			return
+		}
+
+		mu.Lock()
+		got := fmt.Sprint(completed)
+		n := len(completed)
+		mu.Unlock()
+		if n != writes {
+			t.Errorf("incorrect number of completed writes after flush; got %d, want %d", n, writes)
+		}
+		var inOrder []int
+		for i := 0; i < writes; i++ {
+			inOrder = append(inOrder, i)
+		}
+		if got == fmt.Sprint(inOrder) {
+			t.Errorf("writes unexpectedly completed in order")
+		}
+
+		// Writes are nonetheless applied in the order invoked.
+		val, err := jsutil.AsPromise(area.Call("get", "key")).Await(ctx)
+		if err != nil {
+			t.Errorf("get failed: %v", err)
+			return
+		}
+		if got := val.Get("key").Int(); got != writes-1 {
+			t.Errorf("incorrect value; got %d, want %d", got, writes-1)
+		}
+	})
+}
@@ -0,0 +1,215 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+)
+
+const (
+	// defaultThrottleRetryDelay is the delay before retrying a write that
+	// was rejected due to the write-rate quota.
+	defaultThrottleRetryDelay = 5 * time.Second
+
+	// defaultThrottleMaxAttempts is the maximum number of attempts for a
+	// write. Together with defaultThrottleRetryDelay, this allows the
+	// per-minute write quota sufficient time to replenish.
+	defaultThrottleMaxAttempts = 13
+)
+
+// Throttled queues writes to an underlying storage area. Writes that are
+// queued concurrently are combined into a single write where possible, which
+// reduces the number of write operations counted against the storage area's
+// quota. Writes rejected because the per-minute write quota was exceeded are
+// retried after a delay.
+//
+// Throttled implements the Area interface.
+type Throttled struct {
+	// s is the underlying storage area.
+	s Area
+
+	// retryDelay is the delay before retrying a rejected write.
+	retryDelay time.Duration
+	// maxAttempts is the maximum number of attempts for a write.
+	maxAttempts int
+
+	// mu protects the fields below.
+	mu sync.Mutex
+	// queue contains writes that are yet to be performed.
+	queue []*throttledOp
+	// flushing indicates if a caller is currently performing queued
+	// writes.
+	flushing bool
+}
+
+// NewThrottled returns a Throttled that writes to the supplied storage area.
+func NewThrottled(store Area) *Throttled {
+	return &Throttled{
+		s:           store,
+		retryDelay:  defaultThrottleRetryDelay,
+		maxAttempts: defaultThrottleMaxAttempts,
+	}
+}
+
+// throttledOp is a single queued write operation.
+type throttledOp struct {
+	// set is the data to be stored. It is nil for deletions.
+	set map[string]js.Value
+	// del is the keys to be deleted.
+	del []string
+	// done receives the result of the operation.
+	done chan error
+}
+
+func (o *throttledOp) isSet() bool {
+	return o.set != nil
+}
+
+// Direct returns the underlying storage area. Writes to it bypass the queue;
+// this allows callers to opt out of batching and retries.
+func (t *Throttled) Direct() Area {
+	return t.s
+}
+
+// enqueue queues the operation, and waits for it to complete.
+//
+// If no other caller is performing queued writes, then this caller takes on
+// that responsibility until the queue is empty.
+func (t *Throttled) enqueue(ctx jsutil.AsyncContext, op *throttledOp) error {
+	op.done = make(chan error, 1)
+
+	t.mu.Lock()
+	t.queue = append(t.queue, op)
+	flush := !t.flushing
+	t.flushing = true
+	t.mu.Unlock()
+
+	if flush {
+		t.flush(ctx)
+	}
+	return <-op.done
+}
+
+// flush performs queued writes until the queue is empty.
+func (t *Throttled) flush(ctx jsutil.AsyncContext) {
+	for {
+		t.mu.Lock()
+		ops := t.queue
+		t.queue = nil
+		if len(ops) == 0 {
+			t.flushing = false
+			t.mu.Unlock()
+			return
+		}
+		t.mu.Unlock()
+
+		// Combine adjacent operations of the same kind. Order must be
+		// preserved across operations of differing kinds, since a key
+		// may be both set and deleted.
+		for len(ops) > 0 {
+			n := 1
+			for n < len(ops) && ops[n].isSet() == ops[0].isSet() {
+				n++
+			}
+			batch := ops[:n]
+			ops = ops[n:]
+
+			err := t.write(ctx, batch)
+			for _, op := range batch {
+				op.done <- err
+			}
+		}
+	}
+}
+
+// write performs a batch of operations of the same kind as a single write.
+func (t *Throttled) write(ctx jsutil.AsyncContext, batch []*throttledOp) error {
+	if batch[0].isSet() {
+		data := map[string]js.Value{}
+		for _, op := range batch {
+			for k, v := range op.set {
+				data[k] = v
+			}
+		}
+		jsutil.LogDebug("Throttled.write: setting %d values from %d operations", len(data), len(batch))
+		return t.retry(func() error { return t.s.Set(ctx, data) })
+	}
+
+	var keys []string
+	for _, op := range batch {
+		keys = append(keys, op.del...)
+	}
+	jsutil.LogDebug("Throttled.write: deleting %d values from %d operations", len(keys), len(batch))
+	return t.retry(func() error { return t.s.Delete(ctx, keys) })
+}
+
+// retry invokes f, retrying while it fails due to the per-minute write quota.
+func (t *Throttled) retry(f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		var qerr *QuotaError
+		if err == nil || !errors.As(err, &qerr) || qerr.Quota != QuotaMaxWriteOperationsPerMinute {
+			return err
+		}
+		if attempt >= t.maxAttempts {
+			return err
+		}
+		jsutil.LogDebug("Throttled.retry: write rate exceeded on attempt %d; retrying in %s", attempt, t.retryDelay)
+		time.Sleep(t.retryDelay)
+	}
+}
+
+// Set implements Area.Set().
+func (t *Throttled) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	if len(data) == 0 {
+		return nil // Nothing to do.
+	}
+	return t.enqueue(ctx, &throttledOp{set: data})
+}
+
+// Get implements Area.Get().
+func (t *Throttled) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
+	return t.s.Get(ctx)
+}
+
+// GetKeys implements Area.GetKeys().
+func (t *Throttled) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	return t.s.GetKeys(ctx, keys)
+}
+
+// BytesInUse implements Area.BytesInUse().
+func (t *Throttled) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	return t.s.BytesInUse(ctx, keys)
+}
+
+// Delete implements Area.Delete().
+func (t *Throttled) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	if len(keys) == 0 {
+		return nil // Nothing to do.
+	}
+	return t.enqueue(ctx, &throttledOp{del: keys})
+}
+
+// Watch implements Area.Watch().
+func (t *Throttled) Watch(f WatchFunc) jsutil.CleanupFunc {
+	return t.s.Watch(f)
+}
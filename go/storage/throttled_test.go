@@ -0,0 +1,176 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	writeRateError = "MAX_WRITE_OPERATIONS_PER_MINUTE quota exceeded"
+)
+
+// countingArea wraps an Area, counting the number of writes.
+type countingArea struct {
+	Area
+
+	mu     sync.Mutex
+	writes int
+}
+
+func (c *countingArea) count() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+}
+
+func (c *countingArea) Set(ctx jsutil.AsyncContext, data map[string]js.Value) error {
+	c.count()
+	return c.Area.Set(ctx, data)
+}
+
+func (c *countingArea) Delete(ctx jsutil.AsyncContext, keys []string) error {
+	c.count()
+	return c.Area.Delete(ctx, keys)
+}
+
+func newTestThrottled(area Area) *Throttled {
+	t := NewThrottled(area)
+	t.retryDelay = 50 * time.Millisecond
+	t.maxAttempts = 3
+	return t
+}
+
+func TestThrottledRetry(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		failures    int
+		message     string
+		wantErr     bool
+	}{
+		{
+			description: "eventual success",
+			failures:    2,
+			message:     writeRateError,
+		},
+		{
+			description: "too many failures",
+			failures:    3,
+			message:     writeRateError,
+			wantErr:     true,
+		},
+		{
+			description: "other errors not retried",
+			failures:    1,
+			message:     "QUOTA_BYTES quota exceeded",
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				area := st.NewQuotaMemArea(0, 0, 0)
+				s := newTestThrottled(NewRaw(area))
+
+				st.FailWrites(area, tc.failures, tc.message)
+				err := s.Set(ctx, map[string]js.Value{"key": js.ValueOf(2)})
+				if gotErr := err != nil; gotErr != tc.wantErr {
+					t.Fatalf("incorrect error from Set; got %v, wantErr %v", err, tc.wantErr)
+				}
+				if tc.wantErr && !errors.Is(err, ErrQuotaExceeded) {
+					t.Errorf("incorrect error from Set; got %v, want %v", err, ErrQuotaExceeded)
+				}
+				if tc.wantErr {
+					return
+				}
+
+				got, err := s.Get(ctx)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				if diff := cmp.Diff(dataToJSON(got), map[string]string{"key": "2"}); diff != "" {
+					t.Errorf("incorrect data; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestThrottledBatching(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		area := st.NewQuotaMemArea(0, 0, 0)
+		counting := &countingArea{Area: NewRaw(area)}
+		s := newTestThrottled(counting)
+
+		// Stall the first write; subsequent writes are queued while it
+		// waits to be retried.
+		st.FailWrites(area, 1, writeRateError)
+
+		var wg sync.WaitGroup
+		write := func(key string) {
+			wg.Add(1)
+			jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+				defer wg.Done()
+				if err := s.Set(ctx, map[string]js.Value{key: js.ValueOf(key)}); err != nil {
+					t.Errorf("Set for %s failed: %v", key, err)
+				}
+				return js.Undefined(), nil
+			})
+		}
+		write("first")
+		time.Sleep(10 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			write(fmt.Sprintf("key%d", i))
+		}
+		wg.Wait()
+
+		// First write (plus its retry), then a single combined write.
+		if counting.writes != 3 {
+			t.Errorf("incorrect number of writes; got %d, want 3", counting.writes)
+		}
+
+		got, err := s.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		want := map[string]string{
+			"first": `"first"`,
+			"key0":  `"key0"`,
+			"key1":  `"key1"`,
+			"key2":  `"key2"`,
+		}
+		if diff := cmp.Diff(dataToJSON(got), want); diff != "" {
+			t.Errorf("incorrect data; -got +want: %s", diff)
+		}
+	})
+}
@@ -18,15 +18,20 @@ package storage
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
+	"strings"
 	"syscall/js"
 
 	"github.com/google/chrome-ssh-agent/go/jsutil"
 	"github.com/norunners/vert"
 )
 
+// ErrInvalidValue indicates that a stored value could not be deserialized.
+var ErrInvalidValue = errors.New("invalid stored value")
+
 // Typed reads and writes typed values. They are serialized upon writing,
 // and deserialized upon reading.  If deserialization fails for a given value,
 // it is ignored.
@@ -43,6 +48,15 @@ func NewTyped[V any](store Area, keyPrefix []string) *Typed[V] {
 	}
 }
 
+// decode deserializes the value stored at key.
+func decode[V any](key string, v js.Value) (*V, error) {
+	var tv V
+	if err := vert.ValueOf(v).AssignTo(&tv); err != nil {
+		return nil, fmt.Errorf("%w: key %s: %w", ErrInvalidValue, key, err)
+	}
+	return &tv, nil
+}
+
 // readAllItems returns all the stored values, along with their keys.
 func (t *Typed[V]) readAllItems(ctx jsutil.AsyncContext) (map[string]*V, error) {
 	data, err := t.store.Get(ctx)
@@ -52,13 +66,13 @@ func (t *Typed[V]) readAllItems(ctx jsutil.AsyncContext) (map[string]*V, error)
 
 	values := map[string]*V{}
 	for k, v := range data {
-		var tv V
-		if err := vert.ValueOf(v).AssignTo(&tv); err != nil {
+		tv, err := decode[V](k, v)
+		if err != nil {
 			jsutil.LogError("failed to parse value %s; dropping", k)
 			continue
 		}
 
-		values[k] = &tv
+		values[k] = tv
 	}
 	return values, nil
 }
@@ -77,6 +91,12 @@ func (t *Typed[V]) ReadAll(ctx jsutil.AsyncContext) ([]*V, error) {
 	return values, nil
 }
 
+// ReadAllItems returns all the stored values, along with their keys. As with
+// ReadAll, values that cannot be deserialized are dropped.
+func (t *Typed[V]) ReadAllItems(ctx jsutil.AsyncContext) (map[string]*V, error) {
+	return t.readAllItems(ctx)
+}
+
 // Read returns a single value that matches the supplied test function. If
 // multiple values match, only the first is returned. If the value is not found,
 // a nil value is returned.
@@ -97,13 +117,22 @@ func (t *Typed[V]) Read(ctx jsutil.AsyncContext, test func(v *V) bool) (*V, erro
 
 // Write writes a new value to storage.
 func (t *Typed[V]) Write(ctx jsutil.AsyncContext, value *V) error {
-	// Generate a unique key under which value will be stored.
-	key, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
-	if err != nil {
-		return fmt.Errorf("failed to generate new ID: %w", err)
+	return t.WriteAll(ctx, []*V{value})
+}
+
+// WriteAll writes new values to storage in a single write.
+func (t *Typed[V]) WriteAll(ctx jsutil.AsyncContext, values []*V) error {
+	data := map[string]js.Value{}
+	for _, value := range values {
+		// Generate a unique key under which value will be stored.
+		key, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+		if err != nil {
+			return fmt.Errorf("failed to generate new ID: %w", err)
+		}
+		data[key.String()] = vert.ValueOf(value).JSValue()
 	}
-	data := map[string]js.Value{
-		key.String(): vert.ValueOf(value).JSValue(),
+	if len(data) == 0 {
+		return nil
 	}
 	return t.store.Set(ctx, data)
 }
@@ -125,3 +154,110 @@ func (t *Typed[V]) Delete(ctx jsutil.AsyncContext, test func(v *V) bool) error {
 
 	return t.store.Delete(ctx, keys)
 }
+
+// DeleteKeys removes the values stored at the specified keys. Keys that are not
+// found are ignored.
+func (t *Typed[V]) DeleteKeys(ctx jsutil.AsyncContext, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return t.store.Delete(ctx, keys)
+}
+
+// Update replaces each value that matches the supplied test function with the
+// result of invoking update on it. Values are replaced under their existing
+// keys, in a single write, such that either all or none are replaced. The
+// number of values replaced is returned. If update returns an error, no values
+// are replaced.
+func (t *Typed[V]) Update(ctx jsutil.AsyncContext, test func(v *V) bool, update func(v *V) (*V, error)) (int, error) {
+	data, err := t.readAllItems(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enumerate values: %w", err)
+	}
+
+	updated := map[string]js.Value{}
+	for k, v := range data {
+		if !test(v) {
+			continue
+		}
+		nv, err := update(v)
+		if err != nil {
+			return 0, err
+		}
+		updated[k] = vert.ValueOf(nv).JSValue()
+	}
+	if len(updated) == 0 {
+		return 0, nil
+	}
+	if err := t.store.Set(ctx, updated); err != nil {
+		return 0, err
+	}
+	return len(updated), nil
+}
+
+// ReadKey returns the value stored at the specified key. If the value is not
+// found, a nil value is returned. Unlike ReadAll, an error wrapping
+// ErrInvalidValue is returned if the value cannot be deserialized.
+func (t *Typed[V]) ReadKey(ctx jsutil.AsyncContext, key string) (*V, error) {
+	data, err := t.store.GetKeys(ctx, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	v, ok := data[key]
+	if !ok {
+		return nil, nil
+	}
+	return decode[V](key, v)
+}
+
+// WriteKey writes a value to storage at the specified key, replacing any
+// existing value.
+func (t *Typed[V]) WriteKey(ctx jsutil.AsyncContext, key string, value *V) error {
+	data := map[string]js.Value{
+		key: vert.ValueOf(value).JSValue(),
+	}
+	return t.store.Set(ctx, data)
+}
+
+// WriteKeys writes values to storage at the specified keys in a single write,
+// such that either all or none are written. Existing values are replaced.
+func (t *Typed[V]) WriteKeys(ctx jsutil.AsyncContext, values map[string]*V) error {
+	data := map[string]js.Value{}
+	for key, value := range values {
+		data[key] = vert.ValueOf(value).JSValue()
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return t.store.Set(ctx, data)
+}
+
+// List returns the values stored at keys with the specified prefix, along with
+// their keys. Unlike ReadAll, an error wrapping ErrInvalidValue is returned if
+// any of the values cannot be deserialized.
+func (t *Typed[V]) List(ctx jsutil.AsyncContext, prefix string) (map[string]*V, error) {
+	data, err := t.store.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]*V{}
+	for k, v := range data {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		tv, err := decode[V](k, v)
+		if err != nil {
+			return nil, err
+		}
+		values[k] = tv
+	}
+	return values, nil
+}
+
+// Watch invokes f when values are changed or removed. Keys are reported
+// without the prefix used to distinguish values. The returned cleanup function
+// must be invoked to stop watching for changes.
+func (t *Typed[V]) Watch(f WatchFunc) jsutil.CleanupFunc {
+	return t.store.Watch(f)
+}
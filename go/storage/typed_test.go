@@ -15,6 +15,7 @@
 package storage
 
 import (
+	"errors"
 	"syscall/js"
 	"testing"
 
@@ -220,6 +221,68 @@ func TestTypedWrite(t *testing.T) {
 	}
 }
 
+func TestTypedWriteAll(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		write       []*myStruct
+		want        []*myStruct
+	}{
+		{
+			description: "write multiple values",
+			write: []*myStruct{
+				{IntField: 100},
+				{IntField: 42},
+			},
+			want: []*myStruct{
+				{IntField: 42},
+				{IntField: 42},
+				{IntField: 100},
+				{StringField: "foo"},
+			},
+		},
+		{
+			description: "write no values",
+			want: []*myStruct{
+				{IntField: 42},
+				{StringField: "foo"},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				store := NewRaw(st.NewMemArea())
+				if err := store.Set(ctx, map[string]js.Value{
+					testKeyPrefix + "." + "1": vert.ValueOf(&myStruct{IntField: 42}).JSValue(),
+					testKeyPrefix + "." + "2": vert.ValueOf(&myStruct{StringField: "foo"}).JSValue(),
+				}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				ts := NewTyped[myStruct](store, testKeyPrefixes)
+
+				if err := ts.WriteAll(ctx, tc.write); err != nil {
+					t.Errorf("WriteAll failed: %v", err)
+				}
+
+				got, err := ts.ReadAll(ctx)
+				if err != nil {
+					t.Fatalf("ReadAll failed: %v", err)
+				}
+				if diff := cmp.Diff(got, tc.want, cmpopts.SortSlices(myStructLess)); diff != "" {
+					t.Errorf("incorrect result: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
 func TestTypedDelete(t *testing.T) {
 	t.Parallel()
 
@@ -284,3 +347,318 @@ func TestTypedDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestTypedUpdate(t *testing.T) {
+	t.Parallel()
+
+	errUpdate := errors.New("update failed")
+
+	testcases := []struct {
+		description string
+		test        func(v *myStruct) bool
+		update      func(v *myStruct) (*myStruct, error)
+		want        map[string]string
+		wantUpdated int
+		wantErr     error
+	}{
+		{
+			description: "update single value",
+			test:        func(v *myStruct) bool { return v.IntField == 42 },
+			update: func(v *myStruct) (*myStruct, error) {
+				return &myStruct{IntField: v.IntField + 1}, nil
+			},
+			want: map[string]string{
+				testKeyPrefix + "." + "1": `{"intField":43,"stringField":""}`,
+				testKeyPrefix + "." + "2": `{"intField":100,"stringField":""}`,
+				testKeyPrefix + "." + "3": `{"intField":0,"stringField":"foo"}`,
+			},
+			wantUpdated: 1,
+		},
+		{
+			description: "update multiple values",
+			test:        func(v *myStruct) bool { return v.IntField > 0 },
+			update: func(v *myStruct) (*myStruct, error) {
+				return &myStruct{StringField: "bar"}, nil
+			},
+			want: map[string]string{
+				testKeyPrefix + "." + "1": `{"intField":0,"stringField":"bar"}`,
+				testKeyPrefix + "." + "2": `{"intField":0,"stringField":"bar"}`,
+				testKeyPrefix + "." + "3": `{"intField":0,"stringField":"foo"}`,
+			},
+			wantUpdated: 2,
+		},
+		{
+			description: "no matching values",
+			test:        func(v *myStruct) bool { return false },
+			update: func(v *myStruct) (*myStruct, error) {
+				return &myStruct{StringField: "bar"}, nil
+			},
+			want: map[string]string{
+				testKeyPrefix + "." + "1": `{"intField":42,"stringField":""}`,
+				testKeyPrefix + "." + "2": `{"intField":100,"stringField":""}`,
+				testKeyPrefix + "." + "3": `{"intField":0,"stringField":"foo"}`,
+			},
+		},
+		{
+			description: "failed update leaves values unchanged",
+			test:        func(v *myStruct) bool { return v.IntField > 0 },
+			update: func(v *myStruct) (*myStruct, error) {
+				if v.IntField == 100 {
+					return nil, errUpdate
+				}
+				return &myStruct{StringField: "bar"}, nil
+			},
+			want: map[string]string{
+				testKeyPrefix + "." + "1": `{"intField":42,"stringField":""}`,
+				testKeyPrefix + "." + "2": `{"intField":100,"stringField":""}`,
+				testKeyPrefix + "." + "3": `{"intField":0,"stringField":"foo"}`,
+			},
+			wantErr: errUpdate,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				store := NewRaw(st.NewMemArea())
+				if err := store.Set(ctx, map[string]js.Value{
+					testKeyPrefix + "." + "1": vert.ValueOf(&myStruct{IntField: 42}).JSValue(),
+					testKeyPrefix + "." + "2": vert.ValueOf(&myStruct{IntField: 100}).JSValue(),
+					testKeyPrefix + "." + "3": vert.ValueOf(&myStruct{StringField: "foo"}).JSValue(),
+				}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				ts := NewTyped[myStruct](store, testKeyPrefixes)
+
+				updated, err := ts.Update(ctx, tc.test, tc.update)
+				if diff := cmp.Diff(err, tc.wantErr, cmpopts.EquateErrors()); diff != "" {
+					t.Errorf("incorrect error: -got +want: %s", diff)
+				}
+				if updated != tc.wantUpdated {
+					t.Errorf("incorrect number of values updated: got %d, want %d", updated, tc.wantUpdated)
+				}
+
+				got, err := st.Get(ctx, store)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				if diff := cmp.Diff(got, tc.want); diff != "" {
+					t.Errorf("incorrect data: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestTypedReadKey(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		key         string
+		want        *myStruct
+		wantErr     error
+	}{
+		{
+			description: "read value",
+			key:         "1",
+			want:        &myStruct{IntField: 42},
+		},
+		{
+			description: "missing value",
+			key:         "3",
+		},
+		{
+			description: "unparseable value",
+			key:         "2",
+			wantErr:     ErrInvalidValue,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				store := NewRaw(st.NewMemArea())
+				if err := store.Set(ctx, map[string]js.Value{
+					testKeyPrefix + "." + "1": vert.ValueOf(&myStruct{IntField: 42}).JSValue(),
+					testKeyPrefix + "." + "2": js.ValueOf(42),
+				}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				ts := NewTyped[myStruct](store, testKeyPrefixes)
+
+				got, err := ts.ReadKey(ctx, tc.key)
+				if diff := cmp.Diff(got, tc.want); diff != "" {
+					t.Errorf("incorrect result: -got +want: %s", diff)
+				}
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("incorrect error: got %v, want %v", err, tc.wantErr)
+				}
+			})
+		})
+	}
+}
+
+func TestTypedWriteKey(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		store := NewRaw(st.NewMemArea())
+		ts := NewTyped[myStruct](store, testKeyPrefixes)
+
+		if err := ts.WriteKey(ctx, "1", &myStruct{IntField: 42}); err != nil {
+			t.Fatalf("WriteKey failed: %v", err)
+		}
+		if err := ts.WriteKey(ctx, "1", &myStruct{StringField: "foo"}); err != nil {
+			t.Fatalf("WriteKey failed: %v", err)
+		}
+
+		got, err := st.Get(ctx, store)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		want := map[string]string{
+			testKeyPrefix + "." + "1": `{"intField":0,"stringField":"foo"}`,
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestTypedWriteKeys(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		store := NewRaw(st.NewMemArea())
+		ts := NewTyped[myStruct](store, testKeyPrefixes)
+
+		if err := ts.WriteKey(ctx, "1", &myStruct{IntField: 42}); err != nil {
+			t.Fatalf("WriteKey failed: %v", err)
+		}
+		if err := ts.WriteKeys(ctx, map[string]*myStruct{
+			"1": {StringField: "foo"},
+			"2": {IntField: 7},
+		}); err != nil {
+			t.Fatalf("WriteKeys failed: %v", err)
+		}
+
+		got, err := st.Get(ctx, store)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		want := map[string]string{
+			testKeyPrefix + "." + "1": `{"intField":0,"stringField":"foo"}`,
+			testKeyPrefix + "." + "2": `{"intField":7,"stringField":""}`,
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestTypedList(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		prefix      string
+		want        map[string]*myStruct
+		wantErr     error
+	}{
+		{
+			description: "matching prefix",
+			prefix:      "a.",
+			want: map[string]*myStruct{
+				"a.1": {IntField: 1},
+				"a.2": {IntField: 2},
+			},
+		},
+		{
+			description: "no matches",
+			prefix:      "c.",
+		},
+		{
+			description: "unparseable value",
+			prefix:      "b.",
+			wantErr:     ErrInvalidValue,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				store := NewRaw(st.NewMemArea())
+				if err := store.Set(ctx, map[string]js.Value{
+					testKeyPrefix + "." + "a.1": vert.ValueOf(&myStruct{IntField: 1}).JSValue(),
+					testKeyPrefix + "." + "a.2": vert.ValueOf(&myStruct{IntField: 2}).JSValue(),
+					testKeyPrefix + "." + "b.1": js.ValueOf(42),
+				}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				ts := NewTyped[myStruct](store, testKeyPrefixes)
+
+				got, err := ts.List(ctx, tc.prefix)
+				if diff := cmp.Diff(got, tc.want, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("incorrect result: -got +want: %s", diff)
+				}
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("incorrect error: got %v, want %v", err, tc.wantErr)
+				}
+			})
+		})
+	}
+}
+
+func TestTypedReadAllItemsAndDeleteKeys(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		store := NewRaw(st.NewMemArea())
+		if err := store.Set(ctx, map[string]js.Value{
+			testKeyPrefix + "." + "1": vert.ValueOf(&myStruct{IntField: 1}).JSValue(),
+			testKeyPrefix + "." + "2": vert.ValueOf(&myStruct{IntField: 2}).JSValue(),
+			testKeyPrefix + "." + "3": js.ValueOf(42),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		ts := NewTyped[myStruct](store, testKeyPrefixes)
+
+		// Unparseable values are dropped.
+		got, err := ts.ReadAllItems(ctx)
+		if err != nil {
+			t.Fatalf("ReadAllItems failed: %v", err)
+		}
+		if diff := cmp.Diff(got, map[string]*myStruct{"1": {IntField: 1}, "2": {IntField: 2}}); diff != "" {
+			t.Errorf("incorrect result: -got +want: %s", diff)
+		}
+
+		// Identical values are distinguished by their keys.
+		if err := ts.WriteKey(ctx, "4", &myStruct{IntField: 2}); err != nil {
+			t.Fatalf("WriteKey failed: %v", err)
+		}
+		if err := ts.DeleteKeys(ctx, []string{"2", "missing"}); err != nil {
+			t.Fatalf("DeleteKeys failed: %v", err)
+		}
+		got, err = ts.ReadAllItems(ctx)
+		if err != nil {
+			t.Fatalf("ReadAllItems failed: %v", err)
+		}
+		if diff := cmp.Diff(got, map[string]*myStruct{"1": {IntField: 1}, "4": {IntField: 2}}); diff != "" {
+			t.Errorf("incorrect result after delete: -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/lock"
+)
+
+// ErrConflict indicates that an update could not be applied because the
+// values it was based on were repeatedly changed by someone else (e.g.,
+// another device syncing changes).
+var ErrConflict = errors.New("conflicting update")
+
+// ErrNotObject indicates that a value could not be merged because it is not
+// an object.
+var ErrNotObject = errors.New("value is not an object")
+
+// maxUpdateAttempts is the number of times an update is attempted before
+// giving up with ErrConflict.
+const maxUpdateAttempts = 5
+
+// UpdateFunc computes new values from the current values of a set of keys.
+// Keys that are absent from current are not presently stored. The returned
+// map holds the new values; keys omitted from it are deleted.
+type UpdateFunc func(current map[string]js.Value) (map[string]js.Value, error)
+
+// sameValues returns true if both sets of stored values are identical.
+func sameValues(a, b map[string]js.Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, av := range a {
+		bv, ok := b[k]
+		if !ok || jsutil.ToJSON(av) != jsutil.ToJSON(bv) {
+			return false
+		}
+	}
+	return true
+}
+
+// Update performs a read-modify-write of the specified keys. The current
+// values are read and passed to fn, and the values it returns are written.
+//
+// Immediately before writing, the keys are re-read. If they were changed in
+// the meantime, fn is invoked again with the latest values. ErrConflict is
+// returned if this repeatedly fails. fn may therefore be invoked multiple
+// times, and should not have side effects.
+func (b *Big) Update(ctx jsutil.AsyncContext, keys []string, fn UpdateFunc) error {
+	inKeys := map[string]bool{}
+	for _, k := range keys {
+		if isChunkKey(k) {
+			return fmt.Errorf("cannot update chunk key %s", k)
+		}
+		inKeys[k] = true
+	}
+
+	for attempt := 1; attempt <= maxUpdateAttempts; attempt++ {
+		// Read the current values.
+		var data, chunks map[string]js.Value
+		var err error
+		_, aerr := lock.AsyncShared(lockResourceID, func(ctx jsutil.AsyncContext) {
+			data, chunks, err = b.readKeys(ctx, keys)
+		}).Await(ctx)
+		if aerr != nil {
+			return aerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read current values: %w", err)
+		}
+		current, err := reassemble(data, chunks)
+		if err != nil {
+			return fmt.Errorf("failed to read current values: %w", err)
+		}
+
+		// Compute the new values.
+		next, err := fn(current)
+		if err != nil {
+			return err
+		}
+		for k := range next {
+			if !inKeys[k] {
+				return fmt.Errorf("update returned unexpected key %s", k)
+			}
+		}
+		var deleted []string
+		for k := range inKeys {
+			if _, ok := next[k]; !ok {
+				deleted = append(deleted, k)
+			}
+		}
+		// Write, provided nothing changed in the meantime.
+		var conflict bool
+		var newChunks map[string]js.Value
+		if aerr := withKeyLocks(ctx, keys, b.indexed, func(ctx jsutil.AsyncContext) {
+			err = func() error {
+				var values map[string]js.Value
+				newChunks, values = b.split(next)
+				latest, err := b.s.GetKeys(ctx, keys)
+				if err != nil {
+					return fmt.Errorf("failed to re-read values: %w", err)
+				}
+				if !sameValues(latest, data) {
+					conflict = true
+					return nil
+				}
+				if b.indexed {
+					return b.commitIndexed(ctx, newChunks, values, deleted)
+				}
+				if err := b.write(ctx, newChunks, values); err != nil {
+					return err
+				}
+				return b.s.Delete(ctx, deleted)
+			}()
+		}); aerr != nil {
+			return aerr
+		}
+		if conflict {
+			jsutil.Log("Big.Update: values changed during attempt %d; retrying", attempt)
+			continue
+		}
+
+		// As with Set and Delete, remove chunks left unreferenced by a
+		// failed write, or by deleted values.
+		if !b.indexed && ((err != nil && len(newChunks) > 0) || (err == nil && len(deleted) > 0)) {
+			if cerr := b.collectGarbage(ctx); cerr != nil {
+				jsutil.LogError("Big.Update: failed to clean up chunks: %v", cerr)
+			}
+		}
+		return err
+	}
+
+	return fmt.Errorf("%w: values changed during each of %d attempts", ErrConflict, maxUpdateAttempts)
+}
+
+// isObject returns true if v is a plain JavaScript object (i.e., not null or
+// an array).
+func isObject(v js.Value) bool {
+	return v.Type() == js.TypeObject && !js.Global().Get("Array").Call("isArray", v).Bool()
+}
+
+// Merge updates the object stored at key with the properties in patch. This
+// is a shallow merge: properties in patch replace those of the same name in
+// the stored object, and all other properties are retained. If no value is
+// stored at key, patch is stored as-is.
+//
+// ErrNotObject is returned if either patch or the stored value is not an
+// object. As with Set, chunks of big values that are unaffected by the
+// change are not rewritten.
+func (b *Big) Merge(ctx jsutil.AsyncContext, key string, patch js.Value) error {
+	if !isObject(patch) {
+		return fmt.Errorf("%w: patch", ErrNotObject)
+	}
+	return b.Update(ctx, []string{key}, func(current map[string]js.Value) (map[string]js.Value, error) {
+		merged := jsutil.NewObject()
+		if cur, ok := current[key]; ok {
+			if !isObject(cur) {
+				return nil, fmt.Errorf("%w: key %s", ErrNotObject, key)
+			}
+			js.Global().Get("Object").Call("assign", merged, cur)
+		}
+		js.Global().Get("Object").Call("assign", merged, patch)
+		return map[string]js.Value{key: merged}, nil
+	})
+}
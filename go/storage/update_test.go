@@ -0,0 +1,274 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"strings"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		b := NewBig(200, NewRaw(st.NewMemArea()))
+		if err := b.Set(ctx, map[string]js.Value{
+			"counter": js.ValueOf(1),
+			"big":     js.ValueOf(strings.Repeat("a", 500)),
+			"other":   js.ValueOf("untouched"),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		var gotCurrent map[string]string
+		if err := b.Update(ctx, []string{"counter", "big", "missing"}, func(current map[string]js.Value) (map[string]js.Value, error) {
+			gotCurrent = dataToJSON(current)
+			// Increment the counter, replace missing, and delete big.
+			return map[string]js.Value{
+				"counter": js.ValueOf(current["counter"].Int() + 1),
+				"missing": js.ValueOf(strings.Repeat("b", 500)),
+			}, nil
+		}); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+
+		wantCurrent := map[string]string{
+			"counter": `1`,
+			"big":     `"` + strings.Repeat("a", 500) + `"`,
+		}
+		if diff := cmp.Diff(gotCurrent, wantCurrent); diff != "" {
+			t.Errorf("incorrect current values: -got +want: %s", diff)
+		}
+
+		got, err := st.Get(ctx, b)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		want := map[string]string{
+			"counter": `2`,
+			"missing": `"` + strings.Repeat("b", 500) + `"`,
+			"other":   `"untouched"`,
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestUpdateFuncError(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		b := NewBig(200, NewRaw(st.NewMemArea()))
+		if err := b.Set(ctx, map[string]js.Value{"key": js.ValueOf(1)}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		err := b.Update(ctx, []string{"key"}, func(current map[string]js.Value) (map[string]js.Value, error) {
+			return nil, errInjected
+		})
+		if !errors.Is(err, errInjected) {
+			t.Errorf("incorrect error; got %v, want %v", err, errInjected)
+		}
+
+		got, err := st.Get(ctx, b)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(got, map[string]string{"key": `1`}); diff != "" {
+			t.Errorf("incorrect data: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestUpdateConflict(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		conflicts   int
+		want        map[string]string
+		wantErr     error
+	}{
+		{
+			description: "retry after conflict",
+			conflicts:   2,
+			// The final attempt observes the value written by the
+			// last conflicting write.
+			want: map[string]string{"key": `"2-updated"`},
+		},
+		{
+			description: "conflict on every attempt",
+			conflicts:   maxUpdateAttempts,
+			want:        map[string]string{"key": `"5"`},
+			wantErr:     ErrConflict,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				raw := NewRaw(st.NewMemArea())
+				b := NewBig(200, raw)
+				if err := b.Set(ctx, map[string]js.Value{"key": js.ValueOf("0")}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+
+				var calls int
+				err := b.Update(ctx, []string{"key"}, func(current map[string]js.Value) (map[string]js.Value, error) {
+					calls++
+					if calls <= tc.conflicts {
+						// Simulate a change from another device
+						// while the update is in progress.
+						if err := raw.Set(ctx, map[string]js.Value{
+							"key": js.ValueOf(string(rune('0' + calls))),
+						}); err != nil {
+							t.Errorf("conflicting Set failed: %v", err)
+						}
+					}
+					return map[string]js.Value{
+						"key": js.ValueOf(current["key"].String() + "-updated"),
+					}, nil
+				})
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("incorrect error; got %v, want %v", err, tc.wantErr)
+				}
+
+				got, err := st.Get(ctx, b)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				if diff := cmp.Diff(got, tc.want); diff != "" {
+					t.Errorf("incorrect data: -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		initial     map[string]js.Value
+		patch       js.Value
+		want        map[string]string
+		wantRaw     string
+		wantErr     error
+	}{
+		{
+			description: "merge into simple object",
+			initial: map[string]js.Value{
+				"settings": jsutil.FromJSON(`{"a":1,"b":2}`),
+			},
+			patch:   jsutil.FromJSON(`{"b":3,"c":4}`),
+			want:    map[string]string{"settings": `{"a":1,"b":3,"c":4}`},
+			wantRaw: "simple",
+		},
+		{
+			description: "patch grows value beyond chunking threshold",
+			initial: map[string]js.Value{
+				"settings": jsutil.FromJSON(`{"a":1}`),
+			},
+			patch:   jsutil.FromJSON(`{"b":"` + strings.Repeat("b", 500) + `"}`),
+			want:    map[string]string{"settings": `{"a":1,"b":"` + strings.Repeat("b", 500) + `"}`},
+			wantRaw: "manifest",
+		},
+		{
+			description: "patch shrinks value below chunking threshold",
+			initial: map[string]js.Value{
+				"settings": jsutil.FromJSON(`{"a":1,"b":"` + strings.Repeat("b", 500) + `"}`),
+			},
+			patch:   jsutil.FromJSON(`{"b":"short"}`),
+			want:    map[string]string{"settings": `{"a":1,"b":"short"}`},
+			wantRaw: "simple",
+		},
+		{
+			description: "missing value",
+			patch:       jsutil.FromJSON(`{"a":1}`),
+			want:        map[string]string{"settings": `{"a":1}`},
+			wantRaw:     "simple",
+		},
+		{
+			description: "stored value is not an object",
+			initial: map[string]js.Value{
+				"settings": jsutil.FromJSON(`[1,2]`),
+			},
+			patch:   jsutil.FromJSON(`{"a":1}`),
+			want:    map[string]string{"settings": `[1,2]`},
+			wantRaw: "simple",
+			wantErr: ErrNotObject,
+		},
+		{
+			description: "patch is not an object",
+			initial: map[string]js.Value{
+				"settings": jsutil.FromJSON(`{"a":1}`),
+			},
+			patch:   js.ValueOf("a"),
+			want:    map[string]string{"settings": `{"a":1}`},
+			wantRaw: "simple",
+			wantErr: ErrNotObject,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				b := NewBig(200, NewRaw(st.NewMemArea()))
+				if len(tc.initial) > 0 {
+					if err := b.Set(ctx, tc.initial); err != nil {
+						t.Errorf("Set failed: %v", err)
+						return
+					}
+				}
+
+				if err := b.Merge(ctx, "settings", tc.patch); !errors.Is(err, tc.wantErr) {
+					t.Errorf("incorrect error: got %v, want %v", err, tc.wantErr)
+				}
+
+				got, err := st.Get(ctx, b)
+				if err != nil {
+					t.Errorf("Get failed: %v", err)
+					return
+				}
+				if diff := cmp.Diff(got, tc.want); diff != "" {
+					t.Errorf("incorrect data: -got +want: %s", diff)
+				}
+				gotRaw, err := st.EntryTypes(ctx, b.s)
+				if err != nil {
+					t.Errorf("get failed for underlying storage: %v", err)
+					return
+				}
+				if gotRaw["settings"] != tc.wantRaw {
+					t.Errorf("incorrect raw entry type: got %s, want %s", gotRaw["settings"], tc.wantRaw)
+				}
+			})
+		})
+	}
+}
@@ -64,6 +64,21 @@ func NewView(prefixes []string, store Area) *View {
 	}
 }
 
+// NewNamespaced returns a view of a storage area for a single namespace.
+// Keys are transparently prefixed on writes, and only keys in the namespace
+// are returned on reads.
+//
+// The namespace must not contain the '.' separator; this guarantees that
+// namespaces can never overlap (e.g., namespace "a" and key "b.c" versus
+// namespace "a.b" and key "c"). When used with Big, the view should wrap Big
+// so that chunk keys are not namespaced.
+func NewNamespaced(store Area, namespace string) *View {
+	if namespace == "" || strings.Contains(namespace, ".") {
+		panic(fmt.Errorf("invalid namespace %q", namespace))
+	}
+	return NewView([]string{namespace}, store)
+}
+
 // readKey detects if the key belongs to our view.
 func (v *View) readKey(prefix, key string) (string, bool) {
 	return strings.TrimPrefix(key, prefix), strings.HasPrefix(key, prefix)
@@ -108,6 +123,43 @@ func (v *View) Get(ctx jsutil.AsyncContext) (map[string]js.Value, error) {
 	return ndata, nil
 }
 
+// GetKeys implements Area.GetKeys().
+func (v *View) GetKeys(ctx jsutil.AsyncContext, keys []string) (map[string]js.Value, error) {
+	var nkeys []string
+	for _, k := range keys {
+		for _, prefix := range v.prefixes {
+			nkeys = append(nkeys, v.makeKey(prefix, k))
+		}
+	}
+	data, err := v.s.GetKeys(ctx, nkeys)
+	if err != nil {
+		return nil, err
+	}
+
+	ndata := map[string]js.Value{}
+	for _, k := range keys {
+		for _, prefix := range v.prefixes {
+			// First prefix takes precedence.
+			if val, present := data[v.makeKey(prefix, k)]; present {
+				ndata[k] = val
+				break
+			}
+		}
+	}
+	return ndata, nil
+}
+
+// BytesInUse implements Area.BytesInUse().
+func (v *View) BytesInUse(ctx jsutil.AsyncContext, keys []string) (int, error) {
+	var nkeys []string
+	for _, k := range keys {
+		for _, prefix := range v.prefixes {
+			nkeys = append(nkeys, v.makeKey(prefix, k))
+		}
+	}
+	return v.s.BytesInUse(ctx, nkeys)
+}
+
 // Delete implements Area.Delete().
 func (v *View) Delete(ctx jsutil.AsyncContext, keys []string) error {
 	var nkeys []string
@@ -119,6 +171,42 @@ func (v *View) Delete(ctx jsutil.AsyncContext, keys []string) error {
 	return v.s.Delete(ctx, nkeys)
 }
 
+// Watch implements Area.Watch().
+func (v *View) Watch(f WatchFunc) jsutil.CleanupFunc {
+	return v.s.Watch(func(changed map[string]js.Value, removed []string) {
+		nchanged := map[string]js.Value{}
+		nremoved := map[string]bool{}
+		for _, prefix := range v.prefixes {
+			for k, val := range changed {
+				sk, ok := v.readKey(prefix, k)
+				if !ok {
+					continue
+				}
+				// Don't overwrite; first prefix takes precedence.
+				if _, present := nchanged[sk]; !present {
+					nchanged[sk] = val
+				}
+			}
+			for _, k := range removed {
+				if sk, ok := v.readKey(prefix, k); ok {
+					nremoved[sk] = true
+				}
+			}
+		}
+
+		var nremovedKeys []string
+		for k := range nremoved {
+			if _, present := nchanged[k]; !present {
+				nremovedKeys = append(nremovedKeys, k)
+			}
+		}
+		if len(nchanged) == 0 && len(nremovedKeys) == 0 {
+			return // Nothing relevant to this view.
+		}
+		f(nchanged, nremovedKeys)
+	})
+}
+
 // DeleteViewPrefixes deletes all storage entries for views with the given prefixes.
 func DeleteViewPrefixes(ctx jsutil.AsyncContext, prefixes []string, store Area) error {
 	v := NewView(prefixes, store)
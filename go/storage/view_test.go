@@ -15,6 +15,7 @@
 package storage
 
 import (
+	"strings"
 	"syscall/js"
 	"testing"
 
@@ -122,7 +123,7 @@ func TestViewSet(t *testing.T) {
 					t.Fatalf("View.Set failed: %v", err)
 				}
 
-				got, err := getJSON(ctx, raw)
+				got, err := st.Get(ctx, raw)
 				if err != nil {
 					t.Fatalf("Get failed: %v", err)
 				}
@@ -217,7 +218,7 @@ func TestViewGet(t *testing.T) {
 				}
 
 				view := NewView(tc.prefixes, raw)
-				got, err := getJSON(ctx, view)
+				got, err := st.Get(ctx, view)
 				if err != nil {
 					t.Fatalf("View.Get failed: %v", err)
 				}
@@ -320,7 +321,7 @@ func TestViewDelete(t *testing.T) {
 					t.Fatalf("View.Delete failed: %v", err)
 				}
 
-				got, err := getJSON(ctx, raw)
+				got, err := st.Get(ctx, raw)
 				if err != nil {
 					t.Fatalf("Get failed: %v", err)
 				}
@@ -348,11 +349,11 @@ func TestMultipleViews(t *testing.T) {
 			t.Fatalf("Set failed: %v", err)
 		}
 
-		gotV1, err := getJSON(ctx, v1)
+		gotV1, err := st.Get(ctx, v1)
 		if err != nil {
 			t.Fatalf("Get failed: %v", err)
 		}
-		gotV2, err := getJSON(ctx, v2)
+		gotV2, err := st.Get(ctx, v2)
 		if err != nil {
 			t.Fatalf("Get failed: %v", err)
 		}
@@ -427,7 +428,7 @@ func TestDeleteViewPrefixes(t *testing.T) {
 					t.Fatalf("DeleteViewPrefixes failed: %v", err)
 				}
 
-				got, err := getJSON(ctx, raw)
+				got, err := st.Get(ctx, raw)
 				if err != nil {
 					t.Fatalf("Get failed: %v", err)
 				}
@@ -439,3 +440,136 @@ func TestDeleteViewPrefixes(t *testing.T) {
 		})
 	}
 }
+
+func TestViewGetKeys(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		prefixes    []string
+		initRaw     map[string]js.Value
+		keys        []string
+		want        map[string]string
+	}{
+		{
+			description: "get subset of keys",
+			prefixes:    []string{"foo"},
+			initRaw: map[string]js.Value{
+				"foo.my-key":    js.ValueOf(2),
+				"foo.other-key": js.ValueOf("some-val"),
+				"bar.my-key":    js.ValueOf(3),
+			},
+			keys: []string{"my-key"},
+			want: map[string]string{
+				"my-key": "2",
+			},
+		},
+		{
+			description: "first prefix takes precedence",
+			prefixes:    []string{"foo-new", "foo-old"},
+			initRaw: map[string]js.Value{
+				"foo-new.my-key":    js.ValueOf(2),
+				"foo-old.my-key":    js.ValueOf(3),
+				"foo-old.other-key": js.ValueOf("some-val"),
+			},
+			keys: []string{"my-key", "other-key", "missing-key"},
+			want: map[string]string{
+				"my-key":    "2",
+				"other-key": `"some-val"`,
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				raw := NewRaw(st.NewMemArea())
+				if err := raw.Set(ctx, tc.initRaw); err != nil {
+					t.Fatalf("initial Set failed: %v", err)
+				}
+
+				view := NewView(tc.prefixes, raw)
+				got, err := view.GetKeys(ctx, tc.keys)
+				if err != nil {
+					t.Fatalf("View.GetKeys failed: %v", err)
+				}
+
+				if diff := cmp.Diff(dataToJSON(got), tc.want); diff != "" {
+					t.Errorf("incorrect result; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestNamespacedIsolation(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		raw := NewRaw(st.NewMemArea())
+		b := NewBig(200, raw)
+		n1 := NewNamespaced(b, "foo")
+		n2 := NewNamespaced(b, "bar")
+
+		// Large enough that values are chunked.
+		big := strings.Repeat("a", 500)
+		if err := n1.Set(ctx, map[string]js.Value{
+			"my-key":  js.ValueOf(big),
+			"foo-key": js.ValueOf(1),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if err := n2.Set(ctx, map[string]js.Value{
+			"my-key": js.ValueOf(big),
+		}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		// Deleting everything in one namespace leaves the other intact,
+		// including chunks shared by identical values.
+		gotN1, err := st.Get(ctx, n1)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		var keys []string
+		for k := range gotN1 {
+			keys = append(keys, k)
+		}
+		if err := n1.Delete(ctx, keys); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		gotN1, err = st.Get(ctx, n1)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(gotN1, map[string]string{}); diff != "" {
+			t.Errorf("incorrect namespace foo: -got +want: %s", diff)
+		}
+		gotN2, err := st.Get(ctx, n2)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if diff := cmp.Diff(gotN2, map[string]string{"my-key": `"` + big + `"`}); diff != "" {
+			t.Errorf("incorrect namespace bar: -got +want: %s", diff)
+		}
+	})
+}
+
+func TestNamespacedInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, ns := range []string{"", "foo.bar"} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("NewNamespaced(%q) did not panic", ns)
+				}
+			}()
+			NewNamespaced(NewRaw(st.NewMemArea()), ns)
+		}()
+	}
+}
@@ -0,0 +1,483 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webauthn performs operations on security keys using the WebAuthn
+// API.
+//
+// The WebAuthn API is not available to the extension's service worker.
+// Instead, a Broker running in the service worker opens an extension page for
+// each request. The page retrieves the request and performs it using Perform,
+// such that the browser displays its own prompts asking the user to touch the
+// security key.
+package webauthn
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"syscall/js"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/message"
+	"github.com/norunners/vert"
+)
+
+// RequestParam is the query string parameter containing the request ID in
+// the URL of the page opened by OpenWindow.
+const RequestParam = "webauthn"
+
+const (
+	opCreate = "create"
+	opGet    = "get"
+
+	// algES256 is the COSE algorithm identifier for ECDSA using P-256 and
+	// SHA-256.
+	algES256 = -7
+)
+
+var errTimeout = errors.New("timed out waiting for security key")
+
+// Request is a request to perform an operation on a security key.
+type Request struct {
+	// ID uniquely identifies the request. It must be supplied to Perform.
+	ID string
+}
+
+// CloseFunc closes the page opened for a request.
+type CloseFunc func(ctx jsutil.AsyncContext)
+
+// OpenFunc opens a page that performs the request.
+type OpenFunc func(ctx jsutil.AsyncContext, req *Request) (CloseFunc, error)
+
+// result is the response to a pending request.
+type result struct {
+	rsp *msgRespond
+	err error
+}
+
+// pendingRequest is a request awaiting a response from the page.
+type pendingRequest struct {
+	req *rspFetch
+	rsp chan result
+}
+
+// Broker implements keys.Authenticator by opening a page for each request and
+// waiting for it to perform the operation.
+type Broker struct {
+	open    OpenFunc
+	timeout time.Duration
+	pending map[string]*pendingRequest
+}
+
+var _ keys.Authenticator = (*Broker)(nil)
+
+// NewBroker returns a Broker that opens pages using open. Requests fail if the
+// page does not respond within the timeout; this allows the user time to find
+// and touch their security key.
+func NewBroker(open OpenFunc, timeout time.Duration) *Broker {
+	return &Broker{
+		open:    open,
+		timeout: timeout,
+		pending: map[string]*pendingRequest{},
+	}
+}
+
+// Define a distinct type for each message. These are embedded in each
+// message, and are distinct from those used by keys.Server and confirm.
+const (
+	msgTypeFetch int = 3000 + iota
+	msgTypeFetchRsp
+	msgTypeRespond
+	msgTypeRespondRsp
+)
+
+// Binary data is base64-encoded, since it cannot be sent directly in
+// messages.
+
+type msgFetch struct {
+	Type    int    `js:"type"`
+	Request string `js:"request"`
+}
+
+type rspFetch struct {
+	Type         int    `js:"type"`
+	Op           string `js:"op"`
+	RPID         string `js:"rpId"`
+	UserName     string `js:"userName"`
+	Challenge    string `js:"challenge"`
+	CredentialID string `js:"credentialId"`
+	Timeout      int64  `js:"timeout"`
+	Err          string `js:"err"`
+}
+
+type msgRespond struct {
+	Type              int    `js:"type"`
+	Request           string `js:"request"`
+	CredentialID      string `js:"credentialId"`
+	PublicKey         string `js:"publicKey"`
+	AuthenticatorData string `js:"authenticatorData"`
+	ClientDataJSON    string `js:"clientDataJSON"`
+	Signature         string `js:"signature"`
+	Err               string `js:"err"`
+}
+
+type rspRespond struct {
+	Type int    `js:"type"`
+	Err  string `js:"err"`
+}
+
+// newRequestID returns a new random request ID.
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// decode decodes each base64-encoded value in turn.
+func decode(vals ...*string) ([][]byte, error) {
+	var result [][]byte
+	for _, v := range vals {
+		b, err := base64.StdEncoding.DecodeString(*v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		result = append(result, b)
+	}
+	return result, nil
+}
+
+// Create implements keys.Authenticator.Create.
+func (b *Broker) Create(ctx jsutil.AsyncContext, req *keys.CredentialRequest) (*keys.Credential, error) {
+	rsp, err := b.perform(ctx, &rspFetch{
+		Op:        opCreate,
+		RPID:      req.RPID,
+		UserName:  req.UserName,
+		Challenge: base64.StdEncoding.EncodeToString(req.Challenge),
+	})
+	if err != nil {
+		return nil, err
+	}
+	vals, err := decode(&rsp.CredentialID, &rsp.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &keys.Credential{ID: vals[0], PublicKey: vals[1]}, nil
+}
+
+// Get implements keys.Authenticator.Get.
+func (b *Broker) Get(ctx jsutil.AsyncContext, req *keys.AssertionRequest) (*keys.Assertion, error) {
+	rsp, err := b.perform(ctx, &rspFetch{
+		Op:           opGet,
+		RPID:         req.RPID,
+		Challenge:    base64.StdEncoding.EncodeToString(req.Challenge),
+		CredentialID: base64.StdEncoding.EncodeToString(req.CredentialID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	vals, err := decode(&rsp.AuthenticatorData, &rsp.ClientDataJSON, &rsp.Signature)
+	if err != nil {
+		return nil, err
+	}
+	return &keys.Assertion{
+		AuthenticatorData: vals[0],
+		ClientDataJSON:    vals[1],
+		Signature:         vals[2],
+	}, nil
+}
+
+// perform opens a page for the request, and waits for the response.
+func (b *Broker) perform(ctx jsutil.AsyncContext, op *rspFetch) (*msgRespond, error) {
+	reqID, err := newRequestID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate request ID: %w", err)
+	}
+	req := &Request{ID: reqID}
+	op.Type = msgTypeFetchRsp
+	op.Timeout = b.timeout.Milliseconds()
+
+	p := &pendingRequest{req: op, rsp: make(chan result, 1)}
+	b.pending[req.ID] = p
+	defer delete(b.pending, req.ID)
+
+	jsutil.LogDebug("Broker.perform: opening page for %s request %s", op.Op, req.ID)
+	closePage, err := b.open(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open security key page: %w", err)
+	}
+	defer closePage(ctx)
+
+	select {
+	case r := <-p.rsp:
+		return r.rsp, r.err
+	case <-time.After(b.timeout):
+		return nil, fmt.Errorf("%w: request %s not completed after %s", errTimeout, req.ID, b.timeout)
+	}
+}
+
+// OnMessage is the callback invoked when a message is received. Messages sent
+// by Perform are handled. Other messages are ignored, in which case undefined
+// is returned such that they may be handled elsewhere.
+func (b *Broker) OnMessage(_ jsutil.AsyncContext, headerObj js.Value, _ js.Value) js.Value {
+	var hdr struct {
+		Type int `js:"type"`
+	}
+	if err := vert.ValueOf(headerObj).AssignTo(&hdr); err != nil {
+		return js.Undefined()
+	}
+
+	switch hdr.Type {
+	case msgTypeFetch:
+		var m msgFetch
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return vert.ValueOf(rspFetch{Type: msgTypeFetchRsp, Err: err.Error()}).JSValue()
+		}
+		jsutil.LogDebug("Broker.OnMessage(Fetch req): request=%s", m.Request)
+		p, ok := b.pending[m.Request]
+		if !ok {
+			return vert.ValueOf(rspFetch{Type: msgTypeFetchRsp, Err: fmt.Sprintf("no pending request %s", m.Request)}).JSValue()
+		}
+		return vert.ValueOf(p.req).JSValue()
+	case msgTypeRespond:
+		var m msgRespond
+		if err := vert.ValueOf(headerObj).AssignTo(&m); err != nil {
+			return vert.ValueOf(rspRespond{Type: msgTypeRespondRsp, Err: err.Error()}).JSValue()
+		}
+		jsutil.LogDebug("Broker.OnMessage(Respond req): request=%s, err=%s", m.Request, m.Err)
+		rsp := rspRespond{Type: msgTypeRespondRsp}
+		if p, ok := b.pending[m.Request]; ok {
+			r := result{rsp: &m}
+			if m.Err != "" {
+				r = result{err: errors.New(m.Err)}
+			}
+			select {
+			case p.rsp <- r:
+			default: // Already responded.
+			}
+		} else {
+			rsp.Err = fmt.Sprintf("no pending request %s", m.Request)
+		}
+		return vert.ValueOf(rsp).JSValue()
+	default:
+		return js.Undefined()
+	}
+}
+
+// toUint8Array converts a byte slice to a Uint8Array.
+func toUint8Array(b []byte) js.Value {
+	a := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(a, b)
+	return a
+}
+
+// fromBuffer converts an ArrayBuffer to a base64-encoded string.
+func fromBuffer(buf js.Value) string {
+	a := js.Global().Get("Uint8Array").New(buf)
+	b := make([]byte, a.Get("length").Int())
+	js.CopyBytesToGo(b, a)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// fromBase64 converts a base64-encoded string to a Uint8Array.
+func fromBase64(s string) (js.Value, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return js.Undefined(), fmt.Errorf("failed to decode request: %w", err)
+	}
+	return toUint8Array(b), nil
+}
+
+// createOptions returns the options for navigator.credentials.create(). See
+// https://www.w3.org/TR/webauthn-2/#dictdef-publickeycredentialcreationoptions
+func createOptions(req *rspFetch) (js.Value, error) {
+	challenge, err := fromBase64(req.Challenge)
+	if err != nil {
+		return js.Undefined(), err
+	}
+	// The user ID is not used by OpenSSH, but must be supplied.
+	userID := make([]byte, 16)
+	if _, err := rand.Read(userID); err != nil {
+		return js.Undefined(), fmt.Errorf("failed to generate user ID: %w", err)
+	}
+	return js.ValueOf(map[string]any{
+		"publicKey": map[string]any{
+			"rp": map[string]any{
+				"id":   req.RPID,
+				"name": "SSH Agent for Google Chrome",
+			},
+			"user": map[string]any{
+				"id":          toUint8Array(userID),
+				"name":        req.UserName,
+				"displayName": req.UserName,
+			},
+			"challenge": challenge,
+			"pubKeyCredParams": []any{
+				map[string]any{"type": "public-key", "alg": algES256},
+			},
+			"authenticatorSelection": map[string]any{
+				"residentKey":      "discouraged",
+				"userVerification": "discouraged",
+			},
+			"attestation": "none",
+			"timeout":     req.Timeout,
+		},
+	}), nil
+}
+
+// getOptions returns the options for navigator.credentials.get(). See
+// https://www.w3.org/TR/webauthn-2/#dictdef-publickeycredentialrequestoptions
+func getOptions(req *rspFetch) (js.Value, error) {
+	challenge, err := fromBase64(req.Challenge)
+	if err != nil {
+		return js.Undefined(), err
+	}
+	credID, err := fromBase64(req.CredentialID)
+	if err != nil {
+		return js.Undefined(), err
+	}
+	return js.ValueOf(map[string]any{
+		"publicKey": map[string]any{
+			"rpId":      req.RPID,
+			"challenge": challenge,
+			"allowCredentials": []any{
+				map[string]any{"type": "public-key", "id": credID},
+			},
+			"userVerification": "discouraged",
+			"timeout":          req.Timeout,
+		},
+	}), nil
+}
+
+// do performs the request using the WebAuthn API, and returns the response.
+func do(ctx jsutil.AsyncContext, credentials js.Value, req *rspFetch) (*msgRespond, error) {
+	switch req.Op {
+	case opCreate:
+		opts, err := createOptions(req)
+		if err != nil {
+			return nil, err
+		}
+		cred, err := jsutil.AsPromise(credentials.Call("create", opts)).Await(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create credential: %w", err)
+		}
+		return &msgRespond{
+			CredentialID: fromBuffer(cred.Get("rawId")),
+			PublicKey:    fromBuffer(cred.Get("response").Call("getPublicKey")),
+		}, nil
+	case opGet:
+		opts, err := getOptions(req)
+		if err != nil {
+			return nil, err
+		}
+		cred, err := jsutil.AsPromise(credentials.Call("get", opts)).Await(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get assertion: %w", err)
+		}
+		rsp := cred.Get("response")
+		return &msgRespond{
+			AuthenticatorData: fromBuffer(rsp.Get("authenticatorData")),
+			ClientDataJSON:    fromBuffer(rsp.Get("clientDataJSON")),
+			Signature:         fromBuffer(rsp.Get("signature")),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported operation %s", req.Op)
+	}
+}
+
+// Perform performs the request with the specified ID using the supplied
+// navigator.credentials object, and sends the result to the Broker. Failures
+// are also sent to the Broker, such that it need not wait for the timeout.
+func Perform(ctx jsutil.AsyncContext, msg message.Sender, credentials js.Value, reqID string) error {
+	jsutil.LogDebug("Perform(fetch): request=%s", reqID)
+	rspObj, err := msg.Send(ctx, vert.ValueOf(msgFetch{Type: msgTypeFetch, Request: reqID}).JSValue())
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var req rspFetch
+	if err := vert.ValueOf(rspObj).AssignTo(&req); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if req.Err != "" {
+		return fmt.Errorf("failed to fetch request: %s", req.Err)
+	}
+
+	rsp, opErr := do(ctx, credentials, &req)
+	if opErr != nil {
+		rsp = &msgRespond{Err: opErr.Error()}
+	}
+	rsp.Type = msgTypeRespond
+	rsp.Request = reqID
+
+	jsutil.LogDebug("Perform(respond): request=%s, err=%s", reqID, rsp.Err)
+	rspObj, err = msg.Send(ctx, vert.ValueOf(rsp).JSValue())
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	var r rspRespond
+	if err := vert.ValueOf(rspObj).AssignTo(&r); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if r.Err != "" {
+		return fmt.Errorf("failed to respond: %s", r.Err)
+	}
+	return opErr
+}
+
+// windowOptions are the options for a window. See
+// https://developer.chrome.com/docs/extensions/reference/api/windows#method-create
+type windowOptions struct {
+	URL     string `js:"url"`
+	Type    string `js:"type"`
+	Width   int    `js:"width"`
+	Height  int    `js:"height"`
+	Focused bool   `js:"focused"`
+}
+
+// OpenWindow returns an OpenFunc that opens the page at the specified URL in a
+// popup window using the chrome.windows API. The request ID is supplied in the
+// RequestParam query string parameter.
+func OpenWindow(windows js.Value, pageURL string) OpenFunc {
+	return func(ctx jsutil.AsyncContext, req *Request) (CloseFunc, error) {
+		qs := url.Values{}
+		qs.Set(RequestParam, req.ID)
+		opts := &windowOptions{
+			URL:     pageURL + "?" + qs.Encode(),
+			Type:    "popup",
+			Width:   400,
+			Height:  200,
+			Focused: true,
+		}
+		win, err := jsutil.AsPromise(windows.Call("create", vert.ValueOf(opts).JSValue())).Await(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create window: %w", err)
+		}
+		winID := win.Get("id")
+		return func(ctx jsutil.AsyncContext) {
+			// The page closes itself once it responds, in which case
+			// removing the window fails.
+			if _, err := jsutil.AsPromise(windows.Call("remove", winID)).Await(ctx); err != nil {
+				jsutil.LogDebug("failed to remove window: %v", err)
+			}
+		}, nil
+	}
+}
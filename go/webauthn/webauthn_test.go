@@ -0,0 +1,268 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webauthn
+
+import (
+	"errors"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	mfakes "github.com/google/chrome-ssh-agent/go/message/fakes"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// toBuffer converts a byte slice to an ArrayBuffer.
+func toBuffer(b []byte) js.Value {
+	return toUint8Array(b).Get("buffer")
+}
+
+// fromUint8Array converts a Uint8Array to a byte slice.
+func fromUint8Array(a js.Value) []byte {
+	b := make([]byte, a.Get("length").Int())
+	js.CopyBytesToGo(b, a)
+	return b
+}
+
+// fakeCredentials implements the navigator.credentials API, recording the
+// options for each request.
+type fakeCredentials struct {
+	obj     js.Value
+	options js.Value
+	err     error
+	cleanup jsutil.CleanupFuncs
+}
+
+func newFakeCredentials() *fakeCredentials {
+	f := &fakeCredentials{obj: jsutil.NewObject()}
+	f.cleanup.Add(jsutil.DefineAsyncFunc(f.obj, "create", func(ctx jsutil.AsyncContext, this js.Value, args []js.Value) (js.Value, error) {
+		f.options = jsutil.SingleArg(args)
+		if f.err != nil {
+			return js.Undefined(), f.err
+		}
+		rsp := jsutil.NewObject()
+		f.cleanup.Add(jsutil.DefineFunc(rsp, "getPublicKey", func(this js.Value, args []js.Value) interface{} {
+			return toBuffer([]byte("public-key"))
+		}))
+		cred := jsutil.NewObject()
+		cred.Set("rawId", toBuffer([]byte("credential-id")))
+		cred.Set("response", rsp)
+		return cred, nil
+	}))
+	f.cleanup.Add(jsutil.DefineAsyncFunc(f.obj, "get", func(ctx jsutil.AsyncContext, this js.Value, args []js.Value) (js.Value, error) {
+		f.options = jsutil.SingleArg(args)
+		if f.err != nil {
+			return js.Undefined(), f.err
+		}
+		rsp := jsutil.NewObject()
+		rsp.Set("authenticatorData", toBuffer([]byte("authenticator-data")))
+		rsp.Set("clientDataJSON", toBuffer([]byte("client-data")))
+		rsp.Set("signature", toBuffer([]byte("signature")))
+		cred := jsutil.NewObject()
+		cred.Set("response", rsp)
+		return cred, nil
+	}))
+	return f
+}
+
+func (f *fakeCredentials) Release() {
+	f.cleanup.Do()
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		description string
+		perform     bool
+		credErr     error
+		want        *keys.Credential
+		wantErr     bool
+	}{
+		{
+			description: "created",
+			perform:     true,
+			want: &keys.Credential{
+				ID:        []byte("credential-id"),
+				PublicKey: []byte("public-key"),
+			},
+		},
+		{
+			description: "security key missing",
+			perform:     true,
+			credErr:     errors.New("NotAllowedError"),
+			wantErr:     true,
+		},
+		{
+			description: "timeout",
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			jut.DoSync(func(ctx jsutil.AsyncContext) {
+				hub := mfakes.NewHub()
+				creds := newFakeCredentials()
+				defer creds.Release()
+				creds.err = tc.credErr
+				closed := false
+				open := func(ctx jsutil.AsyncContext, req *Request) (CloseFunc, error) {
+					if tc.perform {
+						jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+							// Failures are expected to be returned
+							// both here and by the Broker.
+							if err := Perform(ctx, hub, creds.obj, req.ID); (err != nil) != tc.wantErr {
+								t.Errorf("incorrect error from Perform: %v", err)
+							}
+							return js.Undefined(), nil
+						})
+					}
+					return func(jsutil.AsyncContext) { closed = true }, nil
+				}
+				b := NewBroker(open, 100*time.Millisecond)
+				hub.AddReceiver(b)
+
+				got, err := b.Create(ctx, &keys.CredentialRequest{
+					RPID:      "extension-id",
+					UserName:  "my-key",
+					Challenge: []byte("challenge"),
+				})
+				if (err != nil) != tc.wantErr {
+					t.Errorf("incorrect error; got %v, want error %t", err, tc.wantErr)
+				}
+				if diff := cmp.Diff(got, tc.want); diff != "" {
+					t.Errorf("incorrect result; -got +want: %s", diff)
+				}
+				if !closed {
+					t.Errorf("page not closed")
+				}
+				if !tc.perform {
+					return
+				}
+
+				pk := creds.options.Get("publicKey")
+				if diff := cmp.Diff(pk.Get("rp").Get("id").String(), "extension-id"); diff != "" {
+					t.Errorf("incorrect relying party ID; -got +want: %s", diff)
+				}
+				if diff := cmp.Diff(pk.Get("user").Get("name").String(), "my-key"); diff != "" {
+					t.Errorf("incorrect user name; -got +want: %s", diff)
+				}
+				if diff := cmp.Diff(string(fromUint8Array(pk.Get("challenge"))), "challenge"); diff != "" {
+					t.Errorf("incorrect challenge; -got +want: %s", diff)
+				}
+				if diff := cmp.Diff(pk.Get("pubKeyCredParams").Index(0).Get("alg").Int(), algES256); diff != "" {
+					t.Errorf("incorrect algorithm; -got +want: %s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		creds := newFakeCredentials()
+		defer creds.Release()
+		open := func(ctx jsutil.AsyncContext, req *Request) (CloseFunc, error) {
+			jsutil.Async(func(ctx jsutil.AsyncContext) (js.Value, error) {
+				if err := Perform(ctx, hub, creds.obj, req.ID); err != nil {
+					t.Errorf("failed to perform request: %v", err)
+				}
+				return js.Undefined(), nil
+			})
+			return func(jsutil.AsyncContext) {}, nil
+		}
+		b := NewBroker(open, time.Second)
+		hub.AddReceiver(b)
+
+		got, err := b.Get(ctx, &keys.AssertionRequest{
+			RPID:         "extension-id",
+			CredentialID: []byte("credential-id"),
+			Challenge:    []byte("challenge"),
+		})
+		if err != nil {
+			t.Errorf("failed to get assertion: %v", err)
+			return
+		}
+		want := &keys.Assertion{
+			AuthenticatorData: []byte("authenticator-data"),
+			ClientDataJSON:    []byte("client-data"),
+			Signature:         []byte("signature"),
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("incorrect result; -got +want: %s", diff)
+		}
+
+		pk := creds.options.Get("publicKey")
+		if diff := cmp.Diff(pk.Get("rpId").String(), "extension-id"); diff != "" {
+			t.Errorf("incorrect relying party ID; -got +want: %s", diff)
+		}
+		if diff := cmp.Diff(string(fromUint8Array(pk.Get("allowCredentials").Index(0).Get("id"))), "credential-id"); diff != "" {
+			t.Errorf("incorrect credential ID; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		open := func(ctx jsutil.AsyncContext, req *Request) (CloseFunc, error) {
+			return func(jsutil.AsyncContext) {}, nil
+		}
+		b := NewBroker(open, 100*time.Millisecond)
+		_, err := b.Get(ctx, &keys.AssertionRequest{})
+		if diff := cmp.Diff(err, errTimeout, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error; -got +want: %s", diff)
+		}
+	})
+}
+
+func TestPerformUnknownRequest(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		hub := mfakes.NewHub()
+		hub.AddReceiver(NewBroker(nil, time.Second))
+		creds := newFakeCredentials()
+		defer creds.Release()
+		if err := Perform(ctx, hub, creds.obj, "bogus-request"); err == nil {
+			t.Errorf("unknown request performed")
+		}
+	})
+}
+
+func TestOnMessageIgnoresOtherMessages(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		b := NewBroker(nil, time.Second)
+		msg := jsutil.NewObject()
+		msg.Set("type", 2000)
+		if rsp := b.OnMessage(ctx, msg, js.Null()); !rsp.IsUndefined() {
+			t.Errorf("incorrect response; got %v, want undefined", rsp)
+		}
+	})
+}
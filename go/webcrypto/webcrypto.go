@@ -0,0 +1,144 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webcrypto holds non-extractable keys using the WebCrypto API.
+//
+// Keys are generated as non-extractable CryptoKey objects, which the browser
+// allows to be stored in IndexedDB but never reveals the private key material
+// of. Signing is performed by the browser using crypto.subtle.sign.
+package webcrypto
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"syscall/js"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	"github.com/google/chrome-ssh-agent/go/keys"
+	"github.com/google/chrome-ssh-agent/go/storage"
+)
+
+// DBName is the name of the IndexedDB database in which keys are typically
+// stored.
+const DBName = "webcrypto"
+
+var errKeyNotFound = errors.New("key not found")
+
+// Store implements keys.CryptoKeyStore using WebCrypto.
+type Store struct {
+	subtle js.Value
+	keys   storage.Area
+}
+
+var _ keys.CryptoKeyStore = (*Store)(nil)
+
+// NewStore returns a Store that uses the supplied SubtleCrypto API (typically
+// crypto.subtle), and holds keys in the supplied area. Each private key is
+// stored under its handle. The area must be able to store CryptoKey objects;
+// storage.IndexedDB can, since it uses structured cloning, but the Storage API
+// areas cannot.
+func NewStore(subtle js.Value, keys storage.Area) *Store {
+	return &Store{
+		subtle: subtle,
+		keys:   keys,
+	}
+}
+
+// newHandle returns a new random handle for a key.
+func newHandle() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// toUint8Array converts a byte slice to a Uint8Array.
+func toUint8Array(b []byte) js.Value {
+	a := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(a, b)
+	return a
+}
+
+// fromArrayBuffer converts an ArrayBuffer to a byte slice.
+func fromArrayBuffer(buf js.Value) []byte {
+	a := js.Global().Get("Uint8Array").New(buf)
+	b := make([]byte, a.Length())
+	js.CopyBytesToGo(b, a)
+	return b
+}
+
+// Generate implements keys.CryptoKeyStore.Generate.
+func (s *Store) Generate(ctx jsutil.AsyncContext) (string, []byte, error) {
+	pair, err := jsutil.AsPromise(s.subtle.Call("generateKey",
+		js.ValueOf(map[string]interface{}{
+			"name":       "ECDSA",
+			"namedCurve": "P-256",
+		}),
+		false,
+		js.ValueOf([]interface{}{"sign", "verify"}))).Await(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	// Public keys are always extractable.
+	spki, err := jsutil.AsPromise(s.subtle.Call("exportKey", "spki", pair.Get("publicKey"))).Await(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to export public key: %w", err)
+	}
+
+	handle, err := newHandle()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate handle: %w", err)
+	}
+	if err := s.keys.Set(ctx, map[string]js.Value{handle: pair.Get("privateKey")}); err != nil {
+		return "", nil, fmt.Errorf("failed to store key: %w", err)
+	}
+	return handle, fromArrayBuffer(spki), nil
+}
+
+// Sign implements keys.CryptoKeyStore.Sign. The signature is returned in the
+// IEEE P1363 format.
+func (s *Store) Sign(ctx jsutil.AsyncContext, handle string, data []byte) ([]byte, error) {
+	vals, err := s.keys.GetKeys(ctx, []string{handle})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	key, ok := vals[handle]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errKeyNotFound, handle)
+	}
+	sig, err := jsutil.AsPromise(s.subtle.Call("sign",
+		js.ValueOf(map[string]interface{}{
+			"name": "ECDSA",
+			"hash": "SHA-256",
+		}),
+		key,
+		toUint8Array(data))).Await(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	return fromArrayBuffer(sig), nil
+}
+
+// Delete implements keys.CryptoKeyStore.Delete.
+func (s *Store) Delete(ctx jsutil.AsyncContext, handle string) error {
+	if err := s.keys.Delete(ctx, []string{handle}); err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+	return nil
+}
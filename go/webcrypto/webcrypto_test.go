@@ -0,0 +1,82 @@
+//go:build js
+
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webcrypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"math/big"
+	"syscall/js"
+	"testing"
+
+	"github.com/google/chrome-ssh-agent/go/jsutil"
+	jut "github.com/google/chrome-ssh-agent/go/jsutil/testing"
+	"github.com/google/chrome-ssh-agent/go/storage"
+	st "github.com/google/chrome-ssh-agent/go/storage/testing"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	jut.DoSync(func(ctx jsutil.AsyncContext) {
+		s := NewStore(js.Global().Get("crypto").Get("subtle"), storage.NewIndexedDB(st.NewIDBFactory(), DBName))
+
+		handle, spki, err := s.Generate(ctx)
+		if err != nil {
+			t.Errorf("failed to generate key: %v", err)
+			return
+		}
+		parsed, err := x509.ParsePKIXPublicKey(spki)
+		if err != nil {
+			t.Errorf("failed to parse public key: %v", err)
+			return
+		}
+		pub, ok := parsed.(*ecdsa.PublicKey)
+		if !ok {
+			t.Errorf("incorrect public key type %T", parsed)
+			return
+		}
+
+		data := []byte("data to sign")
+		sig, err := s.Sign(ctx, handle, data)
+		if err != nil {
+			t.Errorf("failed to sign: %v", err)
+			return
+		}
+		if len(sig) != 64 {
+			t.Errorf("incorrect signature length; got %d, want 64", len(sig))
+			return
+		}
+		digest := sha256.Sum256(data)
+		r, ss := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, ss) {
+			t.Errorf("invalid signature")
+		}
+
+		if err := s.Delete(ctx, handle); err != nil {
+			t.Errorf("failed to delete key: %v", err)
+			return
+		}
+		_, err = s.Sign(ctx, handle, data)
+		if diff := cmp.Diff(err, errKeyNotFound, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("incorrect error after delete; -got +want: %s", diff)
+		}
+	})
+}
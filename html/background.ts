@@ -20,6 +20,9 @@ const app = new WASMApp("../go/background/background.wasm");
 declare function handleOnMessage(message: any, sender: chrome.runtime.MessageSender, sendResponse: (message: any) => void): Promise<void>;
 declare function handleConnectionMessage(port: chrome.runtime.Port, message: any): Promise<void>;
 declare function handleConnectionDisconnect(port: chrome.runtime.Port): Promise<void>;
+declare function handleAlarm(alarm: chrome.alarms.Alarm): Promise<void>;
+declare function handleCommand(command: string): Promise<void>;
+declare function handleNotificationClick(notificationId: string): Promise<void>;
 
 // Workaround for https://github.com/w3c/ServiceWorker/issues/1499#issuecomment-578730536.
 // The cited issue illustrates limitation for Rust, but we have the same in Go.
@@ -38,14 +41,28 @@ chrome.runtime.onMessage.addListener((message: any, sender: chrome.runtime.Messa
 	return true;  // sendResponse invoked asynchronously.
 });
 
-async function onConnectionMessage(port: chrome.runtime.Port, msg: any) {
-	await app.waitInit()
-	return handleConnectionMessage(port, msg);
-}
+// ConnectionQueue forwards events for a connection in the order in which they
+// were received. No event is forwarded until initialization is complete, which
+// includes restoring the lock and the keys loaded in the session, such that
+// the first message after a restart is served by the restored agent.
+//
+// Events cannot simply each wait for initialization, since those that waited
+// are not guaranteed to resume in order once it completes.
+class ConnectionQueue {
+	private ready: Promise<void>;
 
-async function onConnectionDisconnect(port: chrome.runtime.Port) {
-	await app.waitInit()
-	return handleConnectionDisconnect(port);
+	constructor() {
+		this.ready = app.waitInit();
+	}
+
+	// Forward an event once those received before it have been forwarded.
+	// A failure to forward one event does not prevent others from being
+	// forwarded.
+	forward(f: () => Promise<void>) {
+		this.ready = this.ready.then(f).catch((err: any) => {
+			console.error('failed to forward connection event:', err);
+		});
+	}
 }
 
 chrome.runtime.onConnectExternal.addListener((port: chrome.runtime.Port) => {
@@ -53,6 +70,40 @@ chrome.runtime.onConnectExternal.addListener((port: chrome.runtime.Port) => {
 	// guarantee that installed event handlers are in place before the other
 	// side of the connection starts sending messages.  Without this, we can
 	// miss events.
-	port.onMessage.addListener((msg: any) => onConnectionMessage(port, msg));
-	port.onDisconnect.addListener((port: chrome.runtime.Port) => onConnectionDisconnect(port));
+	const queue = new ConnectionQueue();
+	port.onMessage.addListener((msg: any) => queue.forward(() => handleConnectionMessage(port, msg)));
+	port.onDisconnect.addListener((port: chrome.runtime.Port) => queue.forward(() => handleConnectionDisconnect(port)));
+});
+
+async function onAlarm(alarm: chrome.alarms.Alarm) {
+	await app.waitInit()
+	return handleAlarm(alarm);
+}
+
+chrome.alarms.onAlarm.addListener((alarm: chrome.alarms.Alarm) => {
+	onAlarm(alarm);
+});
+
+async function onCommand(command: string) {
+	await app.waitInit()
+	return handleCommand(command);
+}
+
+chrome.commands.onCommand.addListener((command: string) => {
+	onCommand(command);
+});
+
+async function onNotificationClick(notificationId: string) {
+	await app.waitInit()
+	return handleNotificationClick(notificationId);
+}
+
+chrome.notifications.onClicked.addListener((notificationId: string) => {
+	onNotificationClick(notificationId);
+});
+
+// Listening for browser startup ensures the service worker is started, such
+// that keys configured to load automatically are loaded.
+chrome.runtime.onStartup.addListener(() => {
+	app.waitInit();
 });